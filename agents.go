@@ -0,0 +1,371 @@
+// manifold/agents.go
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/opentracing/opentracing-go"
+)
+
+const (
+	coordinatorName = "coordinator"
+
+	defaultAgentMaxSubtasks = 5
+	defaultAgentTimeout     = 120 * time.Second
+	defaultAgentMaxTokens   = 2048
+)
+
+// AgentConfig is a named agent of the team, with its own model and system prompt.
+type AgentConfig struct {
+	Name         string  `yaml:"name" json:"name"`
+	Description  string  `yaml:"description,omitempty" json:"description,omitempty"` // what the coordinator may ask the agent to do
+	Service      string  `yaml:"service,omitempty" json:"service,omitempty"`         // name of a service in the services section that serves the model
+	Endpoint     string  `yaml:"endpoint,omitempty" json:"endpoint,omitempty"`       // OpenAI compatible base URL, the chat backend when this and service are empty
+	Model        string  `yaml:"model,omitempty" json:"model,omitempty"`
+	APIKey       string  `yaml:"api_key,omitempty" json:"-"`
+	SystemPrompt string  `yaml:"system_prompt,omitempty" json:"system_prompt,omitempty"`
+	Temperature  float64 `yaml:"temperature,omitempty" json:"temperature,omitempty"`
+	MaxTokens    int     `yaml:"max_tokens,omitempty" json:"max_tokens,omitempty"` // 2048 when unset
+}
+
+// AgentsConfig configures the agent team the teams tool asks. Without agents the teams tool
+// rewrites the prompt as search queries.
+type AgentsConfig struct {
+	Coordinator    AgentConfig   `yaml:"coordinator,omitempty"` // splits the prompt into subtasks, its name is ignored
+	Agents         []AgentConfig `yaml:"agents,omitempty"`
+	MaxSubtasks    int           `yaml:"max_subtasks,omitempty"`    // 5 when unset
+	TimeoutSeconds int           `yaml:"timeout_seconds,omitempty"` // per agent call, 120 when unset
+}
+
+// AgentMessage is a message passed between the coordinator and an agent during a chat turn.
+type AgentMessage struct {
+	ID        uint      `gorm:"primaryKey" json:"id"`
+	SessionID string    `gorm:"index" json:"session_id"`
+	TurnID    string    `gorm:"index" json:"turn_id"`
+	Sender    string    `json:"sender"`
+	Recipient string    `json:"recipient"`
+	Content   string    `gorm:"type:text" json:"content"`
+	Error     string    `json:"error,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// Subtask is a piece of work the coordinator gives an agent.
+type Subtask struct {
+	Agent string `json:"agent"`
+	Task  string `json:"task"`
+}
+
+// Agent sends tasks to its model.
+type Agent struct {
+	config AgentConfig
+	client LLMClient // nil uses the chat backend
+}
+
+// newAgent creates an agent, resolving the service that serves its model.
+func newAgent(config AgentConfig, services []ServiceConfig) (*Agent, error) {
+	a := &Agent{config: config}
+	switch {
+	case config.Endpoint != "":
+		a.client = NewLocalLLMClient(config.Endpoint, config.Model, config.APIKey)
+	case config.Service != "":
+		for _, service := range services {
+			if service.Name == config.Service {
+				baseURL := fmt.Sprintf("http://%s:%d/v1", service.Host, service.Port)
+				a.client = NewLocalLLMClient(baseURL, config.Model, config.APIKey)
+				return a, nil
+			}
+		}
+		return nil, fmt.Errorf("agent %q: unknown service %q", config.Name, config.Service)
+	}
+	return a, nil
+}
+
+// Ask sends a task to the agent's model and returns its reply.
+func (a *Agent) Ask(ctx context.Context, task string, timeout time.Duration) (string, error) {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	client := a.client
+	if client == nil {
+		backend, release := currentBackend()
+		defer release()
+		client = backend
+	}
+	if client == nil {
+		return "", errors.New("no completions backend")
+	}
+
+	var messages []Message
+	if a.config.SystemPrompt != "" {
+		messages = append(messages, Message{Role: "system", Content: a.config.SystemPrompt})
+	}
+	messages = append(messages, Message{Role: "user", Content: task})
+
+	maxTokens := a.config.MaxTokens
+	if maxTokens <= 0 {
+		maxTokens = defaultAgentMaxTokens
+	}
+	payload := &CompletionRequest{
+		Model:       a.config.Model,
+		Messages:    messages,
+		Temperature: a.config.Temperature,
+		MaxTokens:   maxTokens,
+	}
+
+	resp, err := client.SendCompletionRequest(ctx, payload)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	var completion CompletionResponse
+	if err := json.NewDecoder(resp.Body).Decode(&completion); err != nil {
+		return "", fmt.Errorf("invalid agent response: %w", err)
+	}
+	if len(completion.Choices) == 0 {
+		return "", errors.New("agent returned no choices")
+	}
+	return strings.TrimSpace(completion.Choices[0].Message.Content), nil
+}
+
+// AgentTeam is a coordinator that splits a prompt into subtasks for its agents.
+type AgentTeam struct {
+	config      AgentsConfig
+	coordinator *Agent
+	agents      map[string]*Agent
+	order       []string // agent names in config order
+}
+
+// NewAgentTeam creates the team from its configuration.
+func NewAgentTeam(config AgentsConfig, services []ServiceConfig) (*AgentTeam, error) {
+	if len(config.Agents) == 0 {
+		return nil, errors.New("an agent team needs at least one agent")
+	}
+
+	config.Coordinator.Name = coordinatorName
+	coordinator, err := newAgent(config.Coordinator, services)
+	if err != nil {
+		return nil, err
+	}
+
+	team := &AgentTeam{config: config, coordinator: coordinator, agents: make(map[string]*Agent)}
+	for _, agentConfig := range config.Agents {
+		switch {
+		case agentConfig.Name == "":
+			return nil, errors.New("agent name is required")
+		case agentConfig.Name == coordinatorName || agentConfig.Name == "user":
+			return nil, fmt.Errorf("agent name %q is reserved", agentConfig.Name)
+		case team.agents[agentConfig.Name] != nil:
+			return nil, fmt.Errorf("duplicate agent %q", agentConfig.Name)
+		}
+		agent, err := newAgent(agentConfig, services)
+		if err != nil {
+			return nil, err
+		}
+		team.agents[agentConfig.Name] = agent
+		team.order = append(team.order, agentConfig.Name)
+	}
+	return team, nil
+}
+
+// timeout is how long a single agent call may take.
+func (t *AgentTeam) timeout() time.Duration {
+	if t.config.TimeoutSeconds > 0 {
+		return time.Duration(t.config.TimeoutSeconds) * time.Second
+	}
+	return defaultAgentTimeout
+}
+
+// Run has the coordinator plan subtasks for the prompt, runs them one after the other and returns
+// the agents' replies. Every message is recorded in the transcript of the current turn.
+func (t *AgentTeam) Run(ctx context.Context, prompt string) (result string, err error) {
+	span, ctx := startSpan(ctx, "agents.run")
+	defer func() { finishSpan(span, err) }()
+
+	logger := loggerFromContext(ctx)
+	recordAgentMessage(ctx, "user", coordinatorName, prompt, nil)
+
+	subtasks := t.plan(ctx, prompt)
+	span.SetTag("subtasks", len(subtasks))
+
+	var out strings.Builder
+	var failed int
+	for _, subtask := range subtasks {
+		recordAgentMessage(ctx, coordinatorName, subtask.Agent, subtask.Task, nil)
+
+		agentSpan, agentCtx := startSpan(ctx, "agents.ask", opentracing.Tag{Key: "agent", Value: subtask.Agent})
+		reply, askErr := t.agents[subtask.Agent].Ask(agentCtx, agentTask(prompt, subtask.Task), t.timeout())
+		finishSpan(agentSpan, askErr)
+
+		recordAgentMessage(ctx, subtask.Agent, coordinatorName, reply, askErr)
+		if askErr != nil {
+			logger.Warn("agent failed", "agent", subtask.Agent, "error", askErr)
+			failed++
+			continue
+		}
+		fmt.Fprintf(&out, "Agent %s, task: %s\n%s\n\n", subtask.Agent, subtask.Task, reply)
+	}
+
+	if failed == len(subtasks) {
+		return "", fmt.Errorf("all %d agents failed", failed)
+	}
+	return out.String(), nil
+}
+
+// plan asks the coordinator for subtasks. When the coordinator fails every agent gets the prompt.
+func (t *AgentTeam) plan(ctx context.Context, prompt string) []Subtask {
+	logger := loggerFromContext(ctx)
+
+	var list strings.Builder
+	for _, name := range t.order {
+		desc := t.agents[name].config.Description
+		if desc == "" {
+			desc = name
+		}
+		fmt.Fprintf(&list, "- %s: %s\n", name, desc)
+	}
+	task := "You coordinate a team of agents. The agents are:\n" + list.String() +
+		fmt.Sprintf("Split the following request into at most %d subtasks for the agents that can help. ", t.maxSubtasks()) +
+		"Reply with a JSON array of objects with the fields \"agent\" and \"task\" only, " +
+		"for example [{\"agent\": \"researcher\", \"task\": \"...\"}].\n\nRequest: " + prompt
+
+	reply, err := t.coordinator.Ask(ctx, task, t.timeout())
+	var subtasks []Subtask
+	if err == nil {
+		subtasks, err = t.parsePlan(reply)
+	}
+	if err != nil {
+		logger.Warn("coordinator failed, asking every agent", "error", err)
+		subtasks = nil
+		for _, name := range t.order {
+			subtasks = append(subtasks, Subtask{Agent: name, Task: prompt})
+		}
+	}
+	return subtasks[:min(len(subtasks), t.maxSubtasks())]
+}
+
+// parsePlan reads the subtasks from a coordinator reply, dropping those for unknown agents.
+func (t *AgentTeam) parsePlan(reply string) ([]Subtask, error) {
+	plan, ok := extractJSON(reply, true)
+	if !ok {
+		return nil, fmt.Errorf("coordinator reply has no plan: %s", truncateForLog(reply, 100))
+	}
+
+	var planned []Subtask
+	if err := json.Unmarshal([]byte(plan), &planned); err != nil {
+		return nil, fmt.Errorf("coordinator reply has an invalid plan: %w", err)
+	}
+
+	var subtasks []Subtask
+	for _, subtask := range planned {
+		if t.agents[subtask.Agent] != nil && strings.TrimSpace(subtask.Task) != "" {
+			subtasks = append(subtasks, subtask)
+		}
+	}
+	if len(subtasks) == 0 {
+		return nil, errors.New("coordinator planned no subtasks")
+	}
+	return subtasks, nil
+}
+
+// maxSubtasks is the number of subtasks run per prompt.
+func (t *AgentTeam) maxSubtasks() int {
+	if t.config.MaxSubtasks > 0 {
+		return t.config.MaxSubtasks
+	}
+	return defaultAgentMaxSubtasks
+}
+
+// agentTask gives an agent its subtask along with the request it is part of.
+func agentTask(prompt, task string) string {
+	if task == prompt {
+		return prompt
+	}
+	return fmt.Sprintf("%s\n\nThis is part of the request: %s", task, prompt)
+}
+
+// agentTeam is the team the teams tool asks, nil when no agents are configured.
+var agentTeam *AgentTeam
+
+// configureAgentTeam creates the agent team from the config.
+func configureAgentTeam(config *Config) error {
+	if len(config.Agents.Agents) == 0 {
+		agentTeam = nil
+		return nil
+	}
+	team, err := NewAgentTeam(config.Agents, config.Services)
+	if err != nil {
+		return err
+	}
+	agentTeam = team
+	return nil
+}
+
+// recordAgentMessage stores a message of the current turn's transcript.
+func recordAgentMessage(ctx context.Context, sender, recipient, content string, err error) {
+	if db == nil {
+		return
+	}
+
+	turn := turnFromContext(ctx)
+	message := AgentMessage{
+		SessionID: turn.SessionID,
+		TurnID:    turn.TurnID,
+		Sender:    sender,
+		Recipient: recipient,
+		Content:   content,
+	}
+	if err != nil {
+		message.Error = err.Error()
+	}
+	if err := db.Create(&message); err != nil {
+		loggerFromContext(ctx).Warn("failed to record agent message", "sender", sender, "error", err)
+	}
+}
+
+// GetAgentMessages returns the transcript of a turn or session in the order it was recorded.
+func (sqldb *SQLiteDB) GetAgentMessages(sessionID, turnID string) ([]AgentMessage, error) {
+	query := sqldb.db.Model(&AgentMessage{})
+	if sessionID != "" {
+		query = query.Where("session_id = ?", sessionID)
+	}
+	if turnID != "" {
+		query = query.Where("turn_id = ?", turnID)
+	}
+
+	var messages []AgentMessage
+	err := query.Order("id").Find(&messages).Error
+	return messages, err
+}
+
+// handleGetAgents lists the configured agents.
+func handleGetAgents(c echo.Context, config *Config) error {
+	agents := config.Agents.Agents
+	if agents == nil {
+		agents = []AgentConfig{}
+	}
+	return c.JSON(http.StatusOK, agents)
+}
+
+// handleGetAgentTranscript returns the messages passed between the agents in a turn or session.
+func handleGetAgentTranscript(c echo.Context) error {
+	sessionID := c.QueryParam("session_id")
+	turnID := c.QueryParam("turn_id")
+	if sessionID == "" && turnID == "" {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "session_id or turn_id is required"})
+	}
+
+	messages, err := db.GetAgentMessages(sessionID, turnID)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to load agent transcript"})
+	}
+	return c.JSON(http.StatusOK, messages)
+}
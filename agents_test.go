@@ -0,0 +1,103 @@
+// agents_test.go
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// agentServer answers as the model named in the request: the coordinator replies with plan, the
+// agents echo their system prompt. The failing model returns an error.
+func agentServer(t *testing.T, plan string) *httptest.Server {
+	return completionServer(t, func(request CompletionRequest) (string, error) {
+		switch request.Model {
+		case "failing":
+			return "", errors.New("overloaded")
+		case "coordinator":
+			return plan, nil
+		}
+		return request.Messages[0].Content + " done: " + request.Messages[len(request.Messages)-1].Content, nil
+	})
+}
+
+func TestNewAgentTeamValidation(t *testing.T) {
+	services := []ServiceConfig{{Name: "teams", Host: "localhost", Port: 32185}}
+
+	_, err := NewAgentTeam(AgentsConfig{}, services)
+	assert.Error(t, err)
+	_, err = NewAgentTeam(AgentsConfig{Agents: []AgentConfig{{Name: "a"}, {Name: "a"}}}, services)
+	assert.ErrorContains(t, err, "duplicate agent")
+	_, err = NewAgentTeam(AgentsConfig{Agents: []AgentConfig{{Name: coordinatorName}}}, services)
+	assert.ErrorContains(t, err, "reserved")
+	_, err = NewAgentTeam(AgentsConfig{Agents: []AgentConfig{{Name: "a", Service: "missing"}}}, services)
+	assert.ErrorContains(t, err, "unknown service")
+
+	team, err := NewAgentTeam(AgentsConfig{Agents: []AgentConfig{{Name: "a", Service: "teams"}}}, services)
+	require.NoError(t, err)
+	assert.Equal(t, "http://localhost:32185/v1", team.agents["a"].client.(*Client).BaseURL)
+}
+
+func TestAgentTeamRun(t *testing.T) {
+	testDB, err := NewSQLiteDB(t.TempDir())
+	require.NoError(t, err)
+	require.NoError(t, testDB.AutoMigrate(&AgentMessage{}))
+
+	saved := db
+	db = testDB
+	defer func() { db = saved }()
+
+	server := agentServer(t, `Plan: [{"agent": "researcher", "task": "find facts"}, {"agent": "unknown", "task": "x"}, {"agent": "critic", "task": "review"}]`)
+
+	team, err := NewAgentTeam(AgentsConfig{
+		Coordinator: AgentConfig{Endpoint: server.URL, Model: "coordinator"},
+		Agents: []AgentConfig{
+			{Name: "researcher", Endpoint: server.URL, Model: "small", SystemPrompt: "researcher"},
+			{Name: "critic", Endpoint: server.URL, Model: "failing", SystemPrompt: "critic"},
+		},
+	}, nil)
+	require.NoError(t, err)
+
+	ctx := withTurn(context.Background(), TurnInfo{SessionID: "s1", TurnID: "t1"})
+	out, err := team.Run(ctx, "Is Go fast?")
+	require.NoError(t, err)
+	assert.Contains(t, out, "Agent researcher, task: find facts\nresearcher done: find facts")
+	assert.NotContains(t, out, "critic")
+
+	messages, err := db.GetAgentMessages("", "t1")
+	require.NoError(t, err)
+	require.Len(t, messages, 5)
+	assert.Equal(t, [2]string{"user", coordinatorName}, [2]string{messages[0].Sender, messages[0].Recipient})
+	assert.Equal(t, [2]string{coordinatorName, "researcher"}, [2]string{messages[1].Sender, messages[1].Recipient})
+	assert.Equal(t, "find facts", messages[1].Content)
+	assert.Equal(t, "researcher", messages[2].Sender)
+	assert.Equal(t, "critic", messages[4].Sender)
+	assert.NotEmpty(t, messages[4].Error)
+}
+
+func TestAgentTeamPlanFallback(t *testing.T) {
+	server := agentServer(t, "I would ask the researcher")
+
+	team, err := NewAgentTeam(AgentsConfig{
+		Coordinator: AgentConfig{Endpoint: server.URL, Model: "coordinator"},
+		Agents: []AgentConfig{
+			{Name: "researcher", Endpoint: server.URL},
+			{Name: "writer", Endpoint: server.URL},
+		},
+		MaxSubtasks: 1,
+	}, nil)
+	require.NoError(t, err)
+
+	subtasks := team.plan(context.Background(), "Is Go fast?")
+	assert.Equal(t, []Subtask{{Agent: "researcher", Task: "Is Go fast?"}}, subtasks)
+}
+
+func TestAgentTask(t *testing.T) {
+	assert.Equal(t, "prompt", agentTask("prompt", "prompt"))
+	assert.True(t, strings.HasPrefix(agentTask("prompt", "task"), "task\n\n"))
+}
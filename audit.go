@@ -0,0 +1,243 @@
+// manifold/audit.go
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+const (
+	auditMaxBodyBytes = 64 << 10
+	defaultAuditLimit = 100
+	maxAuditLimit     = 1000
+)
+
+// auditSecretFields are payload fields whose values are never stored.
+var auditSecretFields = []string{"key", "api_key", "apikey", "token", "password", "secret", "authorization", "private_key"}
+
+// AuditEntry is an administrative action. The table is append-only, see createAuditTriggers.
+type AuditEntry struct {
+	ID        uint      `gorm:"primaryKey" json:"id"`
+	Actor     string    `gorm:"index" json:"actor"` // the API key name, or anonymous when auth is disabled
+	RemoteIP  string    `json:"remote_ip"`
+	Action    string    `gorm:"index" json:"action"`
+	Method    string    `json:"method"`
+	Path      string    `json:"path"`
+	Status    int       `json:"status"`
+	Payload   string    `gorm:"type:text" json:"payload"` // path parameters, query and body as JSON, secrets removed
+	CreatedAt time.Time `gorm:"index" json:"created_at"`
+}
+
+// createAuditTriggers makes the audit table reject updates and deletes.
+func createAuditTriggers(sqldb *SQLiteDB) error {
+	for _, op := range []string{"UPDATE", "DELETE"} {
+		stmt := `CREATE TRIGGER IF NOT EXISTS audit_entries_no_` + strings.ToLower(op) + ` BEFORE ` + op + ` ON audit_entries
+			BEGIN SELECT RAISE(ABORT, 'the audit log is append-only'); END;`
+		if err := sqldb.db.Exec(stmt).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// audit records the request as an administrative action once the handler is done. It is the first
+// middleware of a route so requests rejected by requireAdmin are recorded too.
+func audit(action string) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			body := captureAuditBody(c.Request())
+			err := next(c)
+
+			status := c.Response().Status
+			if err != nil {
+				if he, ok := err.(*echo.HTTPError); ok {
+					status = he.Code
+				} else if !c.Response().Committed {
+					status = http.StatusInternalServerError
+				}
+			}
+			recordAudit(c, action, status, body)
+			return err
+		}
+	}
+}
+
+// captureAuditBody reads a JSON or form body for the audit log and puts it back for the handler.
+// Uploads are not stored.
+func captureAuditBody(req *http.Request) interface{} {
+	contentType := req.Header.Get(echo.HeaderContentType)
+	if req.Body == nil || !(strings.HasPrefix(contentType, echo.MIMEApplicationJSON) || strings.HasPrefix(contentType, echo.MIMEApplicationForm)) {
+		if strings.HasPrefix(contentType, echo.MIMEMultipartForm) {
+			return "multipart upload"
+		}
+		return nil
+	}
+
+	buf, err := io.ReadAll(io.LimitReader(req.Body, auditMaxBodyBytes))
+	req.Body = io.NopCloser(io.MultiReader(bytes.NewReader(buf), req.Body))
+	if err != nil || len(buf) == 0 {
+		return nil
+	}
+
+	if strings.HasPrefix(contentType, echo.MIMEApplicationForm) {
+		values, err := parseAuditForm(string(buf))
+		if err != nil {
+			return nil
+		}
+		return values
+	}
+
+	var body interface{}
+	if err := json.Unmarshal(buf, &body); err != nil {
+		return truncateForLog(string(buf), 1000)
+	}
+	return body
+}
+
+// parseAuditForm decodes a URL encoded form into single values.
+func parseAuditForm(form string) (map[string]interface{}, error) {
+	req, err := http.NewRequest(http.MethodPost, "/", strings.NewReader(form))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationForm)
+	if err := req.ParseForm(); err != nil {
+		return nil, err
+	}
+	values := make(map[string]interface{}, len(req.PostForm))
+	for k := range req.PostForm {
+		values[k] = req.PostForm.Get(k)
+	}
+	return values, nil
+}
+
+// recordAudit stores an audit entry. Failing to store it is logged, the request is not failed.
+func recordAudit(c echo.Context, action string, status int, body interface{}) {
+	payload := map[string]interface{}{}
+	if names := c.ParamNames(); len(names) > 0 {
+		params := make(map[string]interface{}, len(names))
+		for i, name := range names {
+			params[name] = c.ParamValues()[i]
+		}
+		payload["params"] = params
+	}
+	if query := c.QueryParams(); len(query) > 0 {
+		values := make(map[string]interface{}, len(query))
+		for k := range query {
+			values[k] = query.Get(k)
+		}
+		payload["query"] = values
+	}
+	if body != nil {
+		payload["body"] = body
+	}
+	encoded, _ := json.Marshal(scrubAuditPayload(payload))
+
+	actor := authKeyName(c)
+	if actor == "" {
+		actor = "anonymous"
+	}
+	entry := AuditEntry{
+		Actor:    actor,
+		RemoteIP: c.RealIP(),
+		Action:   action,
+		Method:   c.Request().Method,
+		Path:     c.Request().URL.Path,
+		Status:   status,
+		Payload:  string(encoded),
+	}
+
+	slog.Info("audit", "actor", entry.Actor, "action", action, "path", entry.Path, "status", status)
+	if db == nil {
+		return
+	}
+	if err := db.Create(&entry); err != nil {
+		slog.Error("failed to record audit entry", "action", action, "error", err)
+	}
+}
+
+// scrubAuditPayload replaces the values of secret fields, at any depth.
+func scrubAuditPayload(v interface{}) interface{} {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		for k, value := range v {
+			if isAuditSecret(k) {
+				v[k] = "***"
+			} else {
+				v[k] = scrubAuditPayload(value)
+			}
+		}
+	case []interface{}:
+		for i, value := range v {
+			v[i] = scrubAuditPayload(value)
+		}
+	}
+	return v
+}
+
+// isAuditSecret reports whether a field name holds a credential.
+func isAuditSecret(name string) bool {
+	name = strings.ToLower(name)
+	for _, secret := range auditSecretFields {
+		if name == secret || strings.HasSuffix(name, "_"+secret) {
+			return true
+		}
+	}
+	return false
+}
+
+// GetAuditEntries returns the newest entries matching the filter fields that are set.
+func (sqldb *SQLiteDB) GetAuditEntries(filter AuditEntry, since time.Time, limit int) ([]AuditEntry, error) {
+	query := sqldb.db.Model(&AuditEntry{})
+	if filter.Actor != "" {
+		query = query.Where("actor = ?", filter.Actor)
+	}
+	if filter.Action != "" {
+		query = query.Where("action = ?", filter.Action)
+	}
+	if !since.IsZero() {
+		query = query.Where("created_at >= ?", since)
+	}
+
+	var entries []AuditEntry
+	err := query.Order("id DESC").Limit(limit).Find(&entries).Error
+	return entries, err
+}
+
+// handleGetAuditLog lists administrative actions, newest first. They can be filtered by actor,
+// action and since (RFC 3339), and limit caps the number returned.
+func handleGetAuditLog(c echo.Context) error {
+	limit := defaultAuditLimit
+	if v := c.QueryParam("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 {
+			return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid limit"})
+		}
+		limit = min(n, maxAuditLimit)
+	}
+
+	var since time.Time
+	if v := c.QueryParam("since"); v != "" {
+		parsed, err := time.Parse(time.RFC3339, v)
+		if err != nil {
+			return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid since, use RFC 3339"})
+		}
+		since = parsed
+	}
+
+	filter := AuditEntry{Actor: c.QueryParam("actor"), Action: c.QueryParam("action")}
+	entries, err := db.GetAuditEntries(filter, since, limit)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to load audit log"})
+	}
+	return c.JSON(http.StatusOK, entries)
+}
@@ -0,0 +1,77 @@
+// audit_test.go
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAuditMiddleware(t *testing.T) {
+	testDB, err := NewSQLiteDB(t.TempDir())
+	require.NoError(t, err)
+	require.NoError(t, testDB.AutoMigrate(&AuditEntry{}))
+	require.NoError(t, createAuditTriggers(testDB))
+
+	saved := db
+	db = testDB
+	defer func() { db = saved }()
+
+	auth := &AuthConfig{Enabled: true, Keys: []APIKeyConfig{{Name: "ops", Key: "k-admin", Admin: true}, {Name: "reader", Key: "k-user"}}}
+	e := echo.New()
+	e.Use(authMiddleware(auth))
+	e.PUT("/v1/tools/:toolName/params", func(c echo.Context) error {
+		var body map[string]interface{}
+		require.NoError(t, json.NewDecoder(c.Request().Body).Decode(&body), "the handler still reads the body")
+		return c.JSON(http.StatusOK, map[string]string{"status": "success"})
+	}, audit("tool.params"), requireAdmin)
+
+	send := func(key string) int {
+		req := httptest.NewRequest(http.MethodPut, "/v1/tools/websearch/params?dry=1", strings.NewReader(`{"top_n": 3, "api_key": "sk-secret"}`))
+		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		req.Header.Set("Authorization", "Bearer "+key)
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, req)
+		return rec.Code
+	}
+	assert.Equal(t, http.StatusOK, send("k-admin"))
+	assert.Equal(t, http.StatusForbidden, send("k-user"))
+
+	entries, err := db.GetAuditEntries(AuditEntry{}, time.Time{}, 10)
+	require.NoError(t, err)
+	require.Len(t, entries, 2)
+	assert.Equal(t, "reader", entries[0].Actor)
+	assert.Equal(t, http.StatusForbidden, entries[0].Status)
+
+	entry := entries[1]
+	assert.Equal(t, "ops", entry.Actor)
+	assert.Equal(t, "tool.params", entry.Action)
+	assert.Equal(t, http.MethodPut, entry.Method)
+	assert.Equal(t, http.StatusOK, entry.Status)
+	assert.JSONEq(t, `{"params": {"toolName": "websearch"}, "query": {"dry": "1"}, "body": {"top_n": 3, "api_key": "***"}}`, entry.Payload)
+
+	// Entries cannot be changed or removed
+	assert.Error(t, db.db.Model(&AuditEntry{}).Where("id = ?", entry.ID).Update("actor", "someone").Error)
+	assert.Error(t, db.db.Delete(&AuditEntry{}, entry.ID).Error)
+
+	byAction, err := db.GetAuditEntries(AuditEntry{Actor: "ops", Action: "tool.params"}, time.Time{}, 10)
+	require.NoError(t, err)
+	assert.Len(t, byAction, 1)
+}
+
+func TestCaptureAuditBodyForm(t *testing.T) {
+	req := httptest.NewRequest(http.MethodPost, "/v1/models/select", strings.NewReader("modelName=llama&password=x"))
+	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationForm)
+
+	body := captureAuditBody(req)
+	assert.Equal(t, map[string]interface{}{"modelName": "llama", "password": "x"}, body)
+	assert.Equal(t, map[string]interface{}{"modelName": "llama", "password": "***"}, scrubAuditPayload(body))
+	assert.Equal(t, "llama", req.FormValue("modelName"), "the handler still reads the form")
+}
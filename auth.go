@@ -0,0 +1,221 @@
+// manifold/auth.go
+
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/labstack/echo/v4/middleware"
+
+	"manifold/internal/apiguard"
+)
+
+const (
+	// sessionCookieName is the cookie set by /v1/auth/login for browser clients.
+	sessionCookieName = "manifold_session"
+
+	// sessionTTL is how long a browser session stays valid.
+	sessionTTL = 24 * time.Hour
+
+	// authKeyContextKey is the echo context key holding the authenticated APIKeyConfig.
+	authKeyContextKey = "auth_key"
+)
+
+// session is a browser session created from a valid API key.
+type session struct {
+	key     *APIKeyConfig
+	expires time.Time
+}
+
+// sessionStore keeps the active browser sessions in memory.
+type sessionStore struct {
+	mu       sync.Mutex
+	sessions map[string]session
+}
+
+var sessions = &sessionStore{sessions: make(map[string]session)}
+
+// create starts a new session for the given key and returns its token.
+func (s *sessionStore) create(key *APIKeyConfig) (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	token := hex.EncodeToString(b)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.sessions[token] = session{key: key, expires: time.Now().Add(sessionTTL)}
+	return token, nil
+}
+
+// lookup returns the key for a session token, dropping it if it has expired.
+func (s *sessionStore) lookup(token string) (*APIKeyConfig, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	sess, ok := s.sessions[token]
+	if !ok {
+		return nil, false
+	}
+	if time.Now().After(sess.expires) {
+		delete(s.sessions, token)
+		return nil, false
+	}
+	return sess.key, true
+}
+
+// delete removes a session token.
+func (s *sessionStore) delete(token string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.sessions, token)
+}
+
+// findAPIKey returns the configured key matching the given value using a constant time comparison.
+func findAPIKey(auth *AuthConfig, value string) (*APIKeyConfig, bool) {
+	return apiguard.FindKey(auth.Keys, value)
+}
+
+// requestAPIKey extracts an API key from the Authorization or X-API-Key headers, falling back to the
+// api_key query parameter since browsers cannot set headers on websocket upgrades.
+func requestAPIKey(c echo.Context) string {
+	return apiguard.RequestKey(c.Request())
+}
+
+// isPublicPath reports whether a path is served without authentication: the UI, static assets and login.
+func isPublicPath(path string) bool {
+	if path == "/v1/auth/login" {
+		return true
+	}
+	return !strings.HasPrefix(path, "/v1/") && path != "/ws"
+}
+
+// authMiddleware rejects requests to the API without a valid API key or session cookie.
+// It is a no-op unless auth is enabled in the config.
+func authMiddleware(auth *AuthConfig) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			if !auth.Enabled || isPublicPath(c.Request().URL.Path) {
+				return next(c)
+			}
+
+			if key, ok := findAPIKey(auth, requestAPIKey(c)); ok {
+				c.Set(authKeyContextKey, key)
+				return next(c)
+			}
+
+			if cookie, err := c.Cookie(sessionCookieName); err == nil {
+				if key, ok := sessions.lookup(cookie.Value); ok {
+					c.Set(authKeyContextKey, key)
+					return next(c)
+				}
+			}
+
+			return c.JSON(http.StatusUnauthorized, map[string]string{"error": "Authentication required"})
+		}
+	}
+}
+
+// requireAdmin restricts a route to API keys with the admin scope.
+func requireAdmin(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		key, ok := c.Get(authKeyContextKey).(*APIKeyConfig)
+		if !ok {
+			// Auth is disabled, every caller is trusted
+			return next(c)
+		}
+		if !key.Admin {
+			return c.JSON(http.StatusForbidden, map[string]string{"error": "Admin scope required"})
+		}
+		return next(c)
+	}
+}
+
+// authKeyName returns the name of the API key used for the request, or an empty string.
+func authKeyName(c echo.Context) string {
+	if key, ok := c.Get(authKeyContextKey).(*APIKeyConfig); ok {
+		return key.Name
+	}
+	return ""
+}
+
+// handleLogin exchanges an API key for a session cookie.
+func handleLogin(c echo.Context, auth *AuthConfig) error {
+	var req struct {
+		APIKey string `json:"api_key" form:"api_key"`
+	}
+	if err := c.Bind(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid request payload"})
+	}
+
+	key, ok := findAPIKey(auth, req.APIKey)
+	if !ok {
+		return c.JSON(http.StatusUnauthorized, map[string]string{"error": "Invalid API key"})
+	}
+
+	token, err := sessions.create(key)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to create session"})
+	}
+
+	c.SetCookie(&http.Cookie{
+		Name:     sessionCookieName,
+		Value:    token,
+		Path:     "/",
+		Expires:  time.Now().Add(sessionTTL),
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	})
+
+	return c.JSON(http.StatusOK, map[string]string{"status": "success", "name": key.Name})
+}
+
+// handleLogout ends the current browser session.
+func handleLogout(c echo.Context) error {
+	if cookie, err := c.Cookie(sessionCookieName); err == nil {
+		sessions.delete(cookie.Value)
+	}
+
+	c.SetCookie(&http.Cookie{
+		Name:     sessionCookieName,
+		Value:    "",
+		Path:     "/",
+		MaxAge:   -1,
+		HttpOnly: true,
+	})
+
+	return c.JSON(http.StatusOK, map[string]string{"status": "success"})
+}
+
+// corsMiddleware allows every origin unless cors_origins is configured, in which case only those
+// origins are allowed and credentials (the session cookie) are accepted.
+func corsMiddleware(auth *AuthConfig) echo.MiddlewareFunc {
+	if len(auth.CORSOrigins) == 0 {
+		return middleware.CORS()
+	}
+	return middleware.CORSWithConfig(middleware.CORSConfig{
+		AllowOrigins:     auth.CORSOrigins,
+		AllowCredentials: true,
+	})
+}
+
+// originAllowed reports whether a websocket upgrade from origin is allowed by the CORS configuration.
+func originAllowed(auth *AuthConfig, origin string) bool {
+	if len(auth.CORSOrigins) == 0 || origin == "" {
+		return true
+	}
+	for _, allowed := range auth.CORSOrigins {
+		if allowed == "*" || strings.EqualFold(allowed, origin) {
+			return true
+		}
+	}
+	return false
+}
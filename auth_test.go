@@ -0,0 +1,205 @@
+// auth_test.go
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newAuthServer returns a server behind the auth and CORS middlewares, with a route of each kind.
+func newAuthServer(auth *AuthConfig) *echo.Echo {
+	ok := func(c echo.Context) error {
+		return c.JSON(http.StatusOK, map[string]string{"key": authKeyName(c)})
+	}
+	e := echo.New()
+	e.Use(corsMiddleware(auth))
+	e.Use(authMiddleware(auth))
+	e.GET("/", ok)
+	e.GET("/ws", ok)
+	e.GET("/v1/models", ok)
+	e.POST("/v1/models/select", ok, requireAdmin)
+	e.POST("/v1/auth/login", func(c echo.Context) error {
+		return handleLogin(c, auth)
+	})
+	e.POST("/v1/auth/logout", handleLogout)
+	return e
+}
+
+// serveAuth sends a request to the server and returns its response.
+func serveAuth(e *echo.Echo, req *http.Request) *httptest.ResponseRecorder {
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestIsPublicPath(t *testing.T) {
+	tests := []struct {
+		path   string
+		public bool
+	}{
+		{"/", true},
+		{"/css/main.css", true},
+		{"/v1/auth/login", true},
+		{"/v1/auth/logout", false},
+		{"/v1/config", false},
+		{"/ws", false},
+		{"/wsx", true},
+	}
+	for _, tt := range tests {
+		assert.Equal(t, tt.public, isPublicPath(tt.path), tt.path)
+	}
+}
+
+func TestAuthMiddleware(t *testing.T) {
+	auth := &AuthConfig{Enabled: true, Keys: []APIKeyConfig{{Name: "ops", Key: "k-admin", Admin: true}, {Name: "reader", Key: "k-user"}}}
+	e := newAuthServer(auth)
+
+	tests := []struct {
+		name   string
+		method string
+		target string
+		header string // Authorization
+		status int
+		key    string
+	}{
+		{"public path", http.MethodGet, "/", "", http.StatusOK, ""},
+		{"no key", http.MethodGet, "/v1/models", "", http.StatusUnauthorized, ""},
+		{"wrong key", http.MethodGet, "/v1/models", "Bearer nope", http.StatusUnauthorized, ""},
+		{"bearer key", http.MethodGet, "/v1/models", "Bearer k-user", http.StatusOK, "reader"},
+		{"websocket query key", http.MethodGet, "/ws?api_key=k-user", "", http.StatusOK, "reader"},
+		{"admin route as user", http.MethodPost, "/v1/models/select", "Bearer k-user", http.StatusForbidden, ""},
+		{"admin route as admin", http.MethodPost, "/v1/models/select", "Bearer k-admin", http.StatusOK, "ops"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, tt.target, nil)
+			if tt.header != "" {
+				req.Header.Set("Authorization", tt.header)
+			}
+			rec := serveAuth(e, req)
+			assert.Equal(t, tt.status, rec.Code)
+			if tt.status == http.StatusOK {
+				assert.JSONEq(t, `{"key": "`+tt.key+`"}`, rec.Body.String())
+			}
+		})
+	}
+
+	// Without auth every route is open, admin ones included
+	rec := serveAuth(newAuthServer(&AuthConfig{}), httptest.NewRequest(http.MethodPost, "/v1/models/select", nil))
+	assert.Equal(t, http.StatusOK, rec.Code)
+}
+
+func TestLoginSessions(t *testing.T) {
+	auth := &AuthConfig{Enabled: true, Keys: []APIKeyConfig{{Name: "reader", Key: "k-user"}}}
+	e := newAuthServer(auth)
+	login := func(body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/v1/auth/login", strings.NewReader(body))
+		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		return serveAuth(e, req)
+	}
+	withCookie := func(method, target string, cookie *http.Cookie) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, target, nil)
+		req.AddCookie(cookie)
+		return serveAuth(e, req)
+	}
+
+	assert.Equal(t, http.StatusUnauthorized, login(`{"api_key": "nope"}`).Code)
+	assert.Equal(t, http.StatusBadRequest, login(`{"api_key":`).Code)
+
+	rec := login(`{"api_key": "k-user"}`)
+	require.Equal(t, http.StatusOK, rec.Code)
+	cookies := rec.Result().Cookies()
+	require.Len(t, cookies, 1)
+	cookie := cookies[0]
+	assert.Equal(t, sessionCookieName, cookie.Name)
+	assert.True(t, cookie.HttpOnly)
+	assert.Equal(t, http.StatusOK, withCookie(http.MethodGet, "/v1/models", cookie).Code)
+
+	// Logging out ends the session and clears the cookie
+	rec = withCookie(http.MethodPost, "/v1/auth/logout", cookie)
+	require.Equal(t, http.StatusOK, rec.Code)
+	require.Len(t, rec.Result().Cookies(), 1)
+	assert.Equal(t, -1, rec.Result().Cookies()[0].MaxAge)
+	assert.Equal(t, http.StatusUnauthorized, withCookie(http.MethodGet, "/v1/models", cookie).Code)
+
+	// Expired sessions are rejected and dropped
+	cookie = login(`{"api_key": "k-user"}`).Result().Cookies()[0]
+	sessions.mu.Lock()
+	sess := sessions.sessions[cookie.Value]
+	sess.expires = time.Now().Add(-time.Second)
+	sessions.sessions[cookie.Value] = sess
+	sessions.mu.Unlock()
+	assert.Equal(t, http.StatusUnauthorized, withCookie(http.MethodGet, "/v1/models", cookie).Code)
+	sessions.mu.Lock()
+	assert.NotContains(t, sessions.sessions, cookie.Value)
+	sessions.mu.Unlock()
+}
+
+func TestCORS(t *testing.T) {
+	tests := []struct {
+		name        string
+		origins     []string
+		origin      string
+		allowed     bool
+		allowHeader string // Access-Control-Allow-Origin
+		credentials bool
+	}{
+		{"any origin by default", nil, "https://a.example", true, "*", false},
+		{"listed origin", []string{"https://a.example"}, "https://a.example", true, "https://a.example", true},
+		{"websocket origins in any case", []string{"https://A.example"}, "https://a.example", true, "", false},
+		{"unlisted origin", []string{"https://a.example"}, "https://b.example", false, "", false},
+		{"wildcard", []string{"*"}, "https://b.example", true, "*", false},
+		{"no origin", []string{"https://a.example"}, "", true, "", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			auth := &AuthConfig{CORSOrigins: tt.origins}
+			assert.Equal(t, tt.allowed, originAllowed(auth, tt.origin))
+
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			if tt.origin != "" {
+				req.Header.Set(echo.HeaderOrigin, tt.origin)
+			}
+			rec := serveAuth(newAuthServer(auth), req)
+			if tt.allowHeader != "" {
+				assert.Equal(t, tt.allowHeader, rec.Header().Get(echo.HeaderAccessControlAllowOrigin))
+			}
+			if !tt.allowed {
+				assert.Empty(t, rec.Header().Get(echo.HeaderAccessControlAllowOrigin))
+			}
+			if tt.credentials {
+				assert.Equal(t, "true", rec.Header().Get(echo.HeaderAccessControlAllowCredentials))
+			}
+		})
+	}
+}
+
+func TestGetConfigRedactsSecrets(t *testing.T) {
+	config := &Config{
+		OpenAIAPIKey:     "sk-openai",
+		GoogleAPIKey:     "g-key",
+		HuggingFaceToken: "hf-token",
+		DataPath:         "/data",
+		Auth:             AuthConfig{Enabled: true, Keys: []APIKeyConfig{{Name: "ops", Key: "k-admin"}}},
+	}
+	config.Documents.OCR.APIKey = "ocr-key"
+
+	e := echo.New()
+	rec := httptest.NewRecorder()
+	require.NoError(t, handleGetConfig(e.NewContext(httptest.NewRequest(http.MethodGet, "/v1/config", nil), rec), config))
+	require.Equal(t, http.StatusOK, rec.Code)
+	for _, secret := range []string{"sk-openai", "g-key", "hf-token", "k-admin", "ocr-key"} {
+		assert.NotContains(t, rec.Body.String(), secret)
+	}
+	var body map[string]interface{}
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &body))
+	assert.Equal(t, "/data", body["DataPath"])
+}
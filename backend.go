@@ -0,0 +1,190 @@
+// manifold/backend.go
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// swapDrainTimeout is how long in-flight completions may keep using an old instance after a swap.
+const swapDrainTimeout = 2 * time.Minute
+
+var (
+	// backendMu guards llmClient, completionsService, completionsCtx and cancel.
+	backendMu sync.RWMutex
+
+	// swapMu serializes model swaps so two selections cannot start instances on the same port.
+	swapMu sync.Mutex
+)
+
+// currentBackend returns the active completions client. The returned release func must be called
+// when the request is done so the instance can be drained before it is stopped.
+func currentBackend() (LLMClient, func()) {
+	backendMu.RLock()
+	defer backendMu.RUnlock()
+
+	idleMonitor.Begin()
+
+	if completionsService == nil {
+		return llmClient, idleMonitor.End
+	}
+
+	service := completionsService
+	service.inflight.Add(1)
+	return llmClient, func() {
+		service.inflight.Done()
+		idleMonitor.End()
+	}
+}
+
+// embeddingClient returns the client sending embedding requests. They go to the embeddings service
+// rather than the completions instance, so they neither hold it from draining nor keep it loaded.
+func embeddingClient() LLMClient {
+	backendMu.RLock()
+	defer backendMu.RUnlock()
+	return llmClient
+}
+
+// installBackend atomically switches the completions client and service and returns the previous
+// service and its cancel func.
+func installBackend(client LLMClient, service *ExternalService, ctx context.Context, cancelFn context.CancelFunc) (*ExternalService, context.CancelFunc) {
+	backendMu.Lock()
+	defer backendMu.Unlock()
+
+	oldService, oldCancel := completionsService, cancel
+	llmClient, completionsService, completionsCtx, cancel = client, service, ctx, cancelFn
+	return oldService, oldCancel
+}
+
+// installRemoteBackend switches the completions client to a remote API. The local instance serving
+// until now is no longer supervised, and is drained and stopped in the background.
+func installRemoteBackend(client LLMClient) {
+	ctx, cancelFn := context.WithCancel(context.Background())
+	oldService, oldCancel := installBackend(client, nil, ctx, cancelFn)
+	if oldService != nil {
+		supervisor.Unregister("completions")
+	}
+	backendReadiness.MarkReady()
+
+	go drainAndStop(oldService, oldCancel)
+}
+
+// alternatePort returns the port the next instance of a local backend should listen on: the
+// configured alt_port (or port+1) when the active instance uses the primary port, otherwise the primary.
+func alternatePort(config ServiceConfig) int {
+	altPort := config.AltPort
+	if altPort == 0 {
+		altPort = config.Port + 1
+	}
+
+	backendMu.RLock()
+	defer backendMu.RUnlock()
+
+	if completionsService != nil && completionsService.config.Port == config.Port {
+		return altPort
+	}
+	return config.Port
+}
+
+// warmSwap starts a new local backend instance, waits until it answers on /v1/models, switches the
+// completions client to it and then drains and stops the previous instance in the background.
+// If the new instance fails to start or never becomes ready, the previous instance keeps serving.
+func warmSwap(serviceConfig ServiceConfig, config *Config, verbose bool) error {
+	service := NewExternalService(serviceConfig, verbose)
+	ctx, cancelFn := context.WithCancel(context.Background())
+
+	if err := service.Start(ctx); err != nil {
+		cancelFn()
+		return err
+	}
+
+	baseURL := fmt.Sprintf("http://%s:%d/v1", serviceConfig.Host, serviceConfig.Port)
+
+	timeout := time.Duration(config.Readiness.TimeoutSeconds) * time.Second
+	if timeout <= 0 {
+		timeout = defaultReadinessTimeout
+	}
+	waitCtx, waitCancel := context.WithTimeout(ctx, timeout)
+	defer waitCancel()
+
+	if err := waitForBackend(waitCtx, baseURL); err != nil {
+		stopCtx, stopCancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer stopCancel()
+		if stopErr := service.Stop(stopCtx); stopErr != nil {
+			slog.Warn("failed to stop new completions service", "service", serviceConfig.Name, "error", stopErr)
+		}
+		cancelFn()
+		return fmt.Errorf("%s did not become ready on port %d: %w", serviceConfig.Name, serviceConfig.Port, err)
+	}
+
+	oldService, oldCancel := installBackend(NewLocalLLMClient(baseURL, "", ""), service, ctx, cancelFn)
+	supervisor.Register("completions", service)
+	backendReadiness.MarkReady()
+
+	slog.Info("completions backend swapped", "service", serviceConfig.Name, "port", serviceConfig.Port)
+
+	go drainAndStop(oldService, oldCancel)
+	return nil
+}
+
+// waitForBackend polls baseURL/models until it returns 200 or ctx is done.
+func waitForBackend(ctx context.Context, baseURL string) error {
+	client := &http.Client{Timeout: healthCheckTimeout}
+	ticker := time.NewTicker(readinessPollInterval)
+	defer ticker.Stop()
+
+	for {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, baseURL+"/models", nil)
+		if err != nil {
+			return err
+		}
+		if resp, err := client.Do(req); err == nil {
+			resp.Body.Close()
+			if resp.StatusCode == http.StatusOK {
+				return nil
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+// drainAndStop waits for in-flight completions on an old instance, then stops it.
+func drainAndStop(service *ExternalService, cancelFn context.CancelFunc) {
+	if service == nil {
+		if cancelFn != nil {
+			cancelFn()
+		}
+		return
+	}
+
+	drained := make(chan struct{})
+	go func() {
+		service.inflight.Wait()
+		close(drained)
+	}()
+
+	select {
+	case <-drained:
+	case <-time.After(swapDrainTimeout):
+		slog.Warn("timed out draining completions service", "service", service.config.Name, "timeout", swapDrainTimeout)
+	}
+
+	ctx, cancelTimeout := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancelTimeout()
+	if err := service.Stop(ctx); err != nil {
+		slog.Warn("failed to stop completions service", "service", service.config.Name, "error", err)
+	}
+	if cancelFn != nil {
+		cancelFn()
+	}
+}
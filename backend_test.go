@@ -0,0 +1,172 @@
+// backend_test.go
+package main
+
+import (
+	"context"
+	"net"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeLocalBackend stands in for a local llama-server or mlx instance: a process that runs until it
+// is stopped, and a server answering /v1/models once ready is set.
+type fakeLocalBackend struct {
+	server  *httptest.Server
+	ready   atomic.Bool
+	command string
+	port    int
+}
+
+// newFakeLocalBackend starts a fake backend, ready unless notReady is set.
+func newFakeLocalBackend(t *testing.T, notReady bool) *fakeLocalBackend {
+	b := &fakeLocalBackend{}
+	b.ready.Store(!notReady)
+	b.server = newModelsServer(t, &b.ready)
+
+	_, port, err := net.SplitHostPort(b.server.Listener.Addr().String())
+	require.NoError(t, err)
+	b.port, err = strconv.Atoi(port)
+	require.NoError(t, err)
+
+	// The backend flags are ignored, the process only has to run until it is stopped
+	b.command = filepath.Join(t.TempDir(), "backend")
+	require.NoError(t, os.WriteFile(b.command, []byte("#!/bin/sh\nexec sleep 60\n"), 0o755))
+	return b
+}
+
+// serviceConfig returns the config of an instance of the backend.
+func (b *fakeLocalBackend) serviceConfig(name string) ServiceConfig {
+	return ServiceConfig{Name: name, Host: "127.0.0.1", Port: b.port, Command: b.command}
+}
+
+// useTestBackend gives a test its own completions backend, supervisor, readiness and idle monitor,
+// stopping the instance it leaves running.
+func useTestBackend(t *testing.T) {
+	backendMu.Lock()
+	oldClient, oldService, oldCtx, oldCancel := llmClient, completionsService, completionsCtx, cancel
+	llmClient, completionsService, completionsCtx, cancel = nil, nil, nil, nil
+	backendMu.Unlock()
+	oldSupervisor, oldReadiness, oldIdle := supervisor, backendReadiness, idleMonitor
+	supervisor, backendReadiness, idleMonitor = NewServiceSupervisor(SupervisorConfig{}), NewBackendReadiness(), &IdleMonitor{}
+
+	t.Cleanup(func() {
+		backendMu.Lock()
+		service, cancelFn := completionsService, cancel
+		llmClient, completionsService, completionsCtx, cancel = oldClient, oldService, oldCtx, oldCancel
+		backendMu.Unlock()
+		drainAndStop(service, cancelFn)
+		supervisor, backendReadiness, idleMonitor = oldSupervisor, oldReadiness, oldIdle
+	})
+}
+
+// activeService returns the completions service in use.
+func activeService() *ExternalService {
+	backendMu.RLock()
+	defer backendMu.RUnlock()
+	return completionsService
+}
+
+// supervised reports whether the supervisor watches a service under name.
+func supervised(name string) bool {
+	for _, status := range supervisor.Statuses() {
+		if status.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// serviceExited returns a condition for assert.Eventually reporting whether service exited.
+func serviceExited(service *ExternalService) func() bool {
+	return func() bool {
+		exited, _ := service.Exited()
+		return exited
+	}
+}
+
+func TestWarmSwapDrainsOldInstance(t *testing.T) {
+	useTestBackend(t)
+	backend := newFakeLocalBackend(t, false)
+	config := &Config{}
+
+	require.NoError(t, warmSwap(backend.serviceConfig("llama-server"), config, false))
+	first := activeService()
+	require.NotNil(t, first)
+	assert.True(t, backendReadiness.Ready())
+	assert.True(t, supervised("completions"))
+
+	// A completion still streaming from the first instance holds it until it is done
+	_, release := currentBackend()
+	require.NoError(t, warmSwap(backend.serviceConfig("llama-server"), config, false))
+	second := activeService()
+	require.NotNil(t, second)
+	assert.NotSame(t, first, second)
+	assert.Never(t, serviceExited(first), 200*time.Millisecond, 20*time.Millisecond)
+
+	release()
+	assert.Eventually(t, serviceExited(first), 5*time.Second, 20*time.Millisecond)
+	assert.True(t, first.Stopped())
+	exited, _ := second.Exited()
+	assert.False(t, exited)
+}
+
+func TestWarmSwapKeepsOldInstanceWhenNotReady(t *testing.T) {
+	useTestBackend(t)
+	backend := newFakeLocalBackend(t, false)
+	config := &Config{Readiness: ReadinessConfig{TimeoutSeconds: 1}}
+
+	require.NoError(t, warmSwap(backend.serviceConfig("llama-server"), config, false))
+	first := activeService()
+
+	backend.ready.Store(false)
+	err := warmSwap(backend.serviceConfig("llama-server"), config, false)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "did not become ready")
+	assert.Same(t, first, activeService())
+	exited, _ := first.Exited()
+	assert.False(t, exited, "the old instance keeps serving")
+}
+
+func TestRestartCompletionsServiceRemoteDrainsLocal(t *testing.T) {
+	for _, config := range []*Config{
+		{LLMBackend: "openai", OpenAIAPIKey: "sk-test"},
+		{LLMBackend: "gemini", GoogleAPIKey: "g-test"},
+	} {
+		t.Run(config.LLMBackend, func(t *testing.T) {
+			useTestBackend(t)
+			backend := newFakeLocalBackend(t, false)
+
+			require.NoError(t, warmSwap(backend.serviceConfig("completions"), &Config{}, false))
+			local := activeService()
+			_, release := currentBackend()
+
+			require.NoError(t, restartCompletionsService(config, false))
+			assert.Nil(t, activeService())
+			assert.True(t, backendReadiness.Ready())
+			assert.False(t, supervised("completions"), "the supervisor must not restart the old instance")
+			assert.Never(t, serviceExited(local), 200*time.Millisecond, 20*time.Millisecond)
+
+			release()
+			assert.Eventually(t, serviceExited(local), 5*time.Second, 20*time.Millisecond)
+			assert.True(t, local.Stopped())
+		})
+	}
+
+	useTestBackend(t)
+	assert.Error(t, restartCompletionsService(&Config{LLMBackend: "openai"}, false), "no API key")
+}
+
+func TestDrainAndStopWithoutService(t *testing.T) {
+	ctx, cancelFn := context.WithCancel(context.Background())
+	drainAndStop(nil, cancelFn)
+	assert.Error(t, ctx.Err())
+	drainAndStop(nil, nil)
+}
@@ -0,0 +1,274 @@
+// manifold/breaker.go
+
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+const (
+	defaultRetryBackoff      = 500 * time.Millisecond
+	defaultRetryMaxBackoff   = 5 * time.Second
+	defaultBreakerThreshold  = 5
+	defaultBreakerCooldown   = 60 * time.Second
+	CircuitClosed            = "closed"
+	CircuitOpen              = "open"
+	CircuitHalfOpen          = "half-open"
+	breakerLastErrorMaxBytes = 300
+)
+
+// ErrCircuitOpen is returned for a tool whose circuit breaker is open.
+var ErrCircuitOpen = errors.New("circuit breaker is open")
+
+// RetryPolicy retries a failed tool call with exponential backoff.
+type RetryPolicy struct {
+	Attempts     int `yaml:"attempts,omitempty"`       // calls per prompt including the first, 1 when unset
+	BackoffMS    int `yaml:"backoff_ms,omitempty"`     // wait before the first retry, doubled for every further one, 500 when unset
+	MaxBackoffMS int `yaml:"max_backoff_ms,omitempty"` // 5000 when unset
+}
+
+// BreakerPolicy opens a tool's circuit after consecutive failed prompts. While open the tool is
+// skipped. After the cooldown one prompt tries it again and closes the circuit when it succeeds.
+type BreakerPolicy struct {
+	Threshold       int `yaml:"threshold,omitempty"`        // consecutive failures that open the circuit, 5 when unset, -1 disables the breaker
+	CooldownSeconds int `yaml:"cooldown_seconds,omitempty"` // 60 when unset
+}
+
+// ToolPolicy is how failures of a tool are handled.
+type ToolPolicy struct {
+	Retry   RetryPolicy
+	Breaker BreakerPolicy
+}
+
+// backoff returns the wait before retry n, counted from 1.
+func (p RetryPolicy) backoff(n int) time.Duration {
+	wait := defaultRetryBackoff
+	if p.BackoffMS > 0 {
+		wait = time.Duration(p.BackoffMS) * time.Millisecond
+	}
+	limit := defaultRetryMaxBackoff
+	if p.MaxBackoffMS > 0 {
+		limit = time.Duration(p.MaxBackoffMS) * time.Millisecond
+	}
+	for i := 1; i < n && wait < limit; i++ {
+		wait *= 2
+	}
+	return min(wait, limit)
+}
+
+// CircuitStatus is the state of a tool's circuit breaker as reported by the API.
+type CircuitStatus struct {
+	State     string     `json:"state"`
+	Failures  int        `json:"failures"`
+	OpenUntil *time.Time `json:"open_until,omitempty"`
+	LastError string     `json:"last_error,omitempty"`
+}
+
+// CircuitBreaker tracks the consecutive failures of a tool.
+type CircuitBreaker struct {
+	mu        sync.Mutex
+	threshold int
+	cooldown  time.Duration
+	failures  int
+	openUntil time.Time
+	probing   bool // a half-open trial call is running
+	lastError string
+	now       func() time.Time
+}
+
+// NewCircuitBreaker creates a closed breaker for a policy.
+func NewCircuitBreaker(policy BreakerPolicy) *CircuitBreaker {
+	threshold := policy.Threshold
+	if threshold == 0 {
+		threshold = defaultBreakerThreshold
+	}
+	cooldown := defaultBreakerCooldown
+	if policy.CooldownSeconds > 0 {
+		cooldown = time.Duration(policy.CooldownSeconds) * time.Second
+	}
+	return &CircuitBreaker{threshold: threshold, cooldown: cooldown, now: time.Now}
+}
+
+// state must be called with the lock held.
+func (b *CircuitBreaker) state() string {
+	switch {
+	case b.openUntil.IsZero():
+		return CircuitClosed
+	case b.now().Before(b.openUntil):
+		return CircuitOpen
+	default:
+		return CircuitHalfOpen
+	}
+}
+
+// Allow reports whether the tool may run. Once the cooldown is over a single trial call is let through.
+func (b *CircuitBreaker) Allow() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	switch b.state() {
+	case CircuitOpen:
+		return false
+	case CircuitHalfOpen:
+		if b.probing {
+			return false
+		}
+		b.probing = true
+	}
+	return true
+}
+
+// Success closes the circuit.
+func (b *CircuitBreaker) Success() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.failures = 0
+	b.openUntil = time.Time{}
+	b.probing = false
+	b.lastError = ""
+}
+
+// Failure counts a failed call and opens the circuit at the threshold or when a trial call fails.
+func (b *CircuitBreaker) Failure(err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.failures++
+	b.probing = false
+	if err != nil {
+		b.lastError = truncateForLog(err.Error(), breakerLastErrorMaxBytes)
+	}
+	if b.threshold > 0 && (b.failures >= b.threshold || !b.openUntil.IsZero()) {
+		b.openUntil = b.now().Add(b.cooldown)
+	}
+}
+
+// Status returns the current state of the breaker.
+func (b *CircuitBreaker) Status() CircuitStatus {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	status := CircuitStatus{State: b.state(), Failures: b.failures, LastError: b.lastError}
+	if status.State == CircuitOpen {
+		until := b.openUntil
+		status.OpenUntil = &until
+	}
+	return status
+}
+
+// toolPolicies holds the retry and breaker policy of every configured tool and their breakers,
+// which outlive a tool being toggled off and on.
+var toolPolicies = struct {
+	sync.Mutex
+	policies map[string]ToolPolicy
+	breakers map[string]*CircuitBreaker
+}{policies: make(map[string]ToolPolicy), breakers: make(map[string]*CircuitBreaker)}
+
+// configureToolPolicies records the policies declared for tools and plugins.
+func configureToolPolicies(config *Config) {
+	toolPolicies.Lock()
+	defer toolPolicies.Unlock()
+
+	for _, tool := range config.Tools {
+		toolPolicies.policies[tool.Name] = ToolPolicy{Retry: tool.Retry, Breaker: tool.CircuitBreaker}
+	}
+	for _, plugin := range config.Plugins {
+		toolPolicies.policies[plugin.Name] = ToolPolicy{Retry: plugin.Retry, Breaker: plugin.CircuitBreaker}
+	}
+	toolPolicies.breakers = make(map[string]*CircuitBreaker)
+}
+
+// toolPolicy returns the policy of a tool and its breaker, created on first use.
+func toolPolicy(name string) (ToolPolicy, *CircuitBreaker) {
+	toolPolicies.Lock()
+	defer toolPolicies.Unlock()
+
+	policy := toolPolicies.policies[name]
+	breaker, ok := toolPolicies.breakers[name]
+	if !ok {
+		breaker = NewCircuitBreaker(policy.Breaker)
+		toolPolicies.breakers[name] = breaker
+	}
+	return policy, breaker
+}
+
+// processWithPolicy runs a tool, retrying failures with backoff, unless its circuit is open.
+// progress, if set, receives the status updates of streaming tools.
+func processWithPolicy(ctx context.Context, wrapper ToolWrapper, prompt string, progress func(string)) (string, error) {
+	policy, breaker := toolPolicy(wrapper.Name)
+	if !breaker.Allow() {
+		return "", ErrCircuitOpen
+	}
+
+	attempts := max(policy.Retry.Attempts, 1)
+	logger := loggerFromContext(ctx)
+
+	var err error
+	for attempt := 1; attempt <= attempts; attempt++ {
+		if attempt > 1 {
+			wait := policy.Retry.backoff(attempt - 1)
+			logger.Warn("retrying tool", "attempt", attempt, "backoff", wait, "error", err)
+			select {
+			case <-time.After(wait):
+			case <-ctx.Done():
+				breaker.Failure(ctx.Err())
+				return "", ctx.Err()
+			}
+		}
+
+		var output string
+		output, err = processTool(ctx, wrapper.Tool, prompt, progress)
+		if err == nil {
+			breaker.Success()
+			return output, nil
+		}
+	}
+
+	breaker.Failure(err)
+	if attempts > 1 {
+		return "", fmt.Errorf("failed after %d attempts: %w", attempts, err)
+	}
+	return "", err
+}
+
+// ToolStatus is a tool with its enabled state and circuit breaker status.
+type ToolStatus struct {
+	Name    string        `json:"name"`
+	Enabled bool          `json:"enabled"`
+	Circuit CircuitStatus `json:"circuit"`
+}
+
+// handleGetToolStatus returns every tool with the state of its circuit breaker.
+func handleGetToolStatus(c echo.Context) error {
+	tools, err := db.GetToolsMetadata()
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to fetch tools metadata"})
+	}
+
+	statuses := make([]ToolStatus, 0, len(tools))
+	for _, tool := range tools {
+		_, breaker := toolPolicy(tool.Name)
+		statuses = append(statuses, ToolStatus{Name: tool.Name, Enabled: tool.Enabled, Circuit: breaker.Status()})
+	}
+	return c.JSON(http.StatusOK, statuses)
+}
+
+// handleResetToolCircuit closes a tool's circuit so it runs on the next prompt.
+func handleResetToolCircuit(c echo.Context) error {
+	name := c.Param("toolName")
+	if _, err := db.GetToolMetadataByName(name); err != nil {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": fmt.Sprintf("Tool '%s' not found", name)})
+	}
+
+	_, breaker := toolPolicy(name)
+	breaker.Success()
+	return c.JSON(http.StatusOK, map[string]string{"status": "success", "tool": name})
+}
@@ -0,0 +1,94 @@
+// breaker_test.go
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// flakyTool fails its first failures calls.
+type flakyTool struct {
+	failures int
+	calls    int
+}
+
+func (t *flakyTool) Process(ctx context.Context, input string) (string, error) {
+	t.calls++
+	if t.calls <= t.failures {
+		return "", errors.New("service unavailable")
+	}
+	return "ok", nil
+}
+
+func (t *flakyTool) Enabled() bool                                                 { return true }
+func (t *flakyTool) SetParams(params map[string]interface{}, config *Config) error { return nil }
+func (t *flakyTool) GetParams() map[string]interface{}                             { return nil }
+
+func TestRetryPolicyBackoff(t *testing.T) {
+	p := RetryPolicy{BackoffMS: 100, MaxBackoffMS: 350}
+	assert.Equal(t, 100*time.Millisecond, p.backoff(1))
+	assert.Equal(t, 200*time.Millisecond, p.backoff(2))
+	assert.Equal(t, 350*time.Millisecond, p.backoff(3))
+	assert.Equal(t, defaultRetryBackoff, RetryPolicy{}.backoff(1))
+}
+
+func TestCircuitBreaker(t *testing.T) {
+	now := time.Now()
+	b := NewCircuitBreaker(BreakerPolicy{Threshold: 2, CooldownSeconds: 30})
+	b.now = func() time.Time { return now }
+
+	assert.True(t, b.Allow())
+	b.Failure(errors.New("boom"))
+	assert.Equal(t, CircuitClosed, b.Status().State)
+	b.Failure(errors.New("boom"))
+	assert.Equal(t, CircuitOpen, b.Status().State)
+	assert.Equal(t, "boom", b.Status().LastError)
+	assert.False(t, b.Allow())
+
+	// After the cooldown a single trial call is allowed, and its failure reopens the circuit
+	now = now.Add(31 * time.Second)
+	assert.Equal(t, CircuitHalfOpen, b.Status().State)
+	assert.True(t, b.Allow())
+	assert.False(t, b.Allow())
+	b.Failure(errors.New("still down"))
+	assert.Equal(t, CircuitOpen, b.Status().State)
+
+	now = now.Add(31 * time.Second)
+	assert.True(t, b.Allow())
+	b.Success()
+	assert.Equal(t, CircuitStatus{State: CircuitClosed}, b.Status())
+}
+
+func TestCircuitBreakerDisabled(t *testing.T) {
+	b := NewCircuitBreaker(BreakerPolicy{Threshold: -1})
+	for i := 0; i < 10; i++ {
+		b.Failure(errors.New("boom"))
+	}
+	assert.True(t, b.Allow())
+}
+
+func TestProcessWithPolicy(t *testing.T) {
+	configureToolPolicies(&Config{Tools: []ToolConfig{
+		{Name: "flaky", Retry: RetryPolicy{Attempts: 3, BackoffMS: 1}, CircuitBreaker: BreakerPolicy{Threshold: 1}},
+		{Name: "broken", CircuitBreaker: BreakerPolicy{Threshold: 1}},
+	}})
+	ctx := context.Background()
+
+	flaky := &flakyTool{failures: 2}
+	out, err := processWithPolicy(ctx, ToolWrapper{Name: "flaky", Tool: flaky}, "prompt", nil)
+	require.NoError(t, err)
+	assert.Equal(t, "ok", out)
+	assert.Equal(t, 3, flaky.calls)
+
+	broken := &flakyTool{failures: 100}
+	_, err = processWithPolicy(ctx, ToolWrapper{Name: "broken", Tool: broken}, "prompt", nil)
+	assert.EqualError(t, err, "service unavailable")
+	_, err = processWithPolicy(ctx, ToolWrapper{Name: "broken", Tool: broken}, "prompt", nil)
+	assert.ErrorIs(t, err, ErrCircuitOpen)
+	assert.Equal(t, 1, broken.calls)
+}
@@ -0,0 +1,340 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"manifold/internal/coderag"
+)
+
+const usage = `Usage: coderag [flags] <command> <argument>
+
+Commands:
+  function <name>   the function, its code, callers and callees
+  calltree <name>   the functions the function calls, down to -depth levels
+  related <query>   the functions best matching a natural-language query
+  refactor          the refactoring opportunities, hottest first
+
+Flags:
+`
+
+// reference is a function a function calls or is called by.
+type reference struct {
+	ID       string `json:"id"`
+	Name     string `json:"name,omitempty"`
+	FilePath string `json:"file_path,omitempty"`
+	Line     int    `json:"line,omitempty"`
+	External bool   `json:"external,omitempty"`
+}
+
+// functionOutput is the output of the function command.
+type functionOutput struct {
+	reference
+	Type     string      `json:"type"`
+	Package  string      `json:"package"`
+	Comments string      `json:"comments,omitempty"`
+	Summary  string      `json:"summary,omitempty"`
+	Code     string      `json:"code"`
+	Calls    []reference `json:"calls"`
+	CalledBy []reference `json:"called_by"`
+}
+
+// callNode is a function of a call tree.
+type callNode struct {
+	reference
+	Calls []*callNode `json:"calls,omitempty"`
+}
+
+// relatedOutput is a function found by the related command.
+type relatedOutput struct {
+	reference
+	Score   float64 `json:"score"`
+	Summary string  `json:"summary,omitempty"`
+}
+
+func main() {
+	storePath := flag.String("store", filepath.Join(os.Getenv("HOME"), ".manifold", "datasets", "coderag.db"), "SQLite file of the saved code indexes")
+	repoPath := flag.String("repo", ".", "Go module to query")
+	format := flag.String("format", "text", "Output format: text, json or md")
+	depth := flag.Int("depth", 3, "Levels of the call tree")
+	k := flag.Int("k", 10, "Number of related functions")
+	reindex := flag.Bool("index", false, "Bring the index up to date before querying")
+	flag.Usage = func() {
+		fmt.Fprint(flag.CommandLine.Output(), usage)
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	if *format != "text" && *format != "json" && *format != "md" {
+		log.Fatalf("Unknown format %q, use text, json or md", *format)
+	}
+	args := flag.Args()
+	if len(args) == 0 || (args[0] != "refactor" && len(args) < 2) {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	index, err := openIndex(*storePath, *repoPath, *reindex)
+	if err != nil {
+		log.Fatalf("Failed to open the code index: %v", err)
+	}
+
+	argument := strings.Join(args[1:], " ")
+	switch args[0] {
+	case "function":
+		fn, err := index.GetFunctionInfo(argument)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if err := render(os.Stdout, *format, newFunctionOutput(index, fn)); err != nil {
+			log.Fatal(err)
+		}
+	case "calltree":
+		fn, err := index.GetFunctionInfo(argument)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if err := render(os.Stdout, *format, buildCallTree(index, fn.ID, *depth, map[string]bool{})); err != nil {
+			log.Fatal(err)
+		}
+	case "related":
+		results, err := index.Search(argument, *k)
+		if err != nil {
+			log.Fatal(err)
+		}
+		related := make([]relatedOutput, 0, len(results))
+		for _, result := range results {
+			related = append(related, relatedOutput{reference: newReference(index, result.Function.ID), Score: result.Score, Summary: result.Function.Summary})
+		}
+		if err := render(os.Stdout, *format, related); err != nil {
+			log.Fatal(err)
+		}
+	case "refactor":
+		// The opportunities in the most churned functions first
+		opportunities := append([]coderag.RefactoringOpportunity{}, index.RefactoringOpportunities...)
+		sort.SliceStable(opportunities, func(i, j int) bool { return opportunities[i].Churn > opportunities[j].Churn })
+		if err := render(os.Stdout, *format, opportunities); err != nil {
+			log.Fatal(err)
+		}
+	default:
+		flag.Usage()
+		os.Exit(2)
+	}
+}
+
+// openIndex loads the saved index of the repository, indexing it when the store has none or when
+// asked to. Functions are summarized and embedded when the coderag environment is configured.
+func openIndex(storePath, repoPath string, reindex bool) (*coderag.CodeIndex, error) {
+	store, err := coderag.OpenStore(storePath)
+	if err != nil {
+		return nil, err
+	}
+	cfg, cfgErr := coderag.LoadConfig()
+	if cfgErr != nil {
+		cfg = &coderag.Config{}
+	}
+	cfg.CodebasePath = ""
+
+	index, err := store.Load(repoPath)
+	if err == nil && !reindex {
+		index.SetConfig(cfg)
+		return index, nil
+	}
+	if err != nil && !errors.Is(err, coderag.ErrNotIndexed) {
+		return nil, err
+	}
+
+	if cfgErr != nil {
+		log.Printf("Indexing without summaries: %v", cfgErr)
+	}
+	index, err = store.OpenCodeIndex(repoPath)
+	if err != nil {
+		return nil, err
+	}
+	if err := index.IndexRepository(repoPath, cfg); err != nil {
+		return nil, err
+	}
+	return index, nil
+}
+
+// newReference describes the function with the given ID, external when it isn't indexed.
+func newReference(index *coderag.CodeIndex, id string) reference {
+	fn, err := index.GetFunctionInfo(id)
+	if err != nil || fn.ID != id {
+		return reference{ID: id, External: true}
+	}
+	return reference{ID: fn.ID, Name: fn.Name, FilePath: fn.FilePath, Line: fn.LineNumber}
+}
+
+func newFunctionOutput(index *coderag.CodeIndex, fn *coderag.FunctionInfo) functionOutput {
+	output := functionOutput{
+		reference: newReference(index, fn.ID),
+		Type:      fn.Type,
+		Package:   fn.Package,
+		Comments:  fn.Comments,
+		Summary:   fn.Summary,
+		Code:      fn.Code,
+		Calls:     []reference{},
+		CalledBy:  []reference{},
+	}
+	for _, id := range fn.Calls {
+		output.Calls = append(output.Calls, newReference(index, id))
+	}
+	for _, id := range fn.CalledBy {
+		output.CalledBy = append(output.CalledBy, newReference(index, id))
+	}
+	return output
+}
+
+// buildCallTree returns the call tree of a function down to depth levels. Functions already in the
+// tree aren't expanded again, so recursion ends.
+func buildCallTree(index *coderag.CodeIndex, id string, depth int, seen map[string]bool) *callNode {
+	node := &callNode{reference: newReference(index, id)}
+	if node.External || seen[id] || depth == 0 {
+		return node
+	}
+	seen[id] = true
+	fn, _ := index.GetFunctionInfo(id)
+	for _, called := range fn.Calls {
+		node.Calls = append(node.Calls, buildCallTree(index, called, depth-1, seen))
+	}
+	return node
+}
+
+// render writes the output of a command in the format.
+func render(w io.Writer, format string, output interface{}) error {
+	if format == "json" {
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(output)
+	}
+
+	md := format == "md"
+	var b strings.Builder
+	switch output := output.(type) {
+	case functionOutput:
+		writeFunction(&b, output, md)
+	case *callNode:
+		if md {
+			fmt.Fprintf(&b, "## Call tree of `%s`\n\n", output.Name)
+		}
+		writeCallTree(&b, output, 0, md)
+	case []relatedOutput:
+		writeRelated(&b, output, md)
+	case []coderag.RefactoringOpportunity:
+		writeOpportunities(&b, output, md)
+	}
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+func (r reference) location() string {
+	if r.External {
+		return "external"
+	}
+	return fmt.Sprintf("%s:%d", r.FilePath, r.Line)
+}
+
+func writeFunction(b *strings.Builder, fn functionOutput, md bool) {
+	if !md {
+		fmt.Fprintf(b, "Function: %s\nLocation: %s\n", fn.ID, fn.location())
+		if fn.Comments != "" {
+			fmt.Fprintf(b, "\nComments:\n%s\n", fn.Comments)
+		}
+		if fn.Summary != "" {
+			fmt.Fprintf(b, "\nSummary:\n%s\n", fn.Summary)
+		}
+		fmt.Fprintf(b, "\nSource Code:\n%s\n", fn.Code)
+		for _, list := range []struct {
+			title string
+			refs  []reference
+		}{{"Calls", fn.Calls}, {"Called By", fn.CalledBy}} {
+			fmt.Fprintf(b, "\n%s:\n", list.title)
+			if len(list.refs) == 0 {
+				b.WriteString("  None\n")
+			}
+			for i, ref := range list.refs {
+				fmt.Fprintf(b, "  %d. %s (%s)\n", i+1, ref.ID, ref.location())
+			}
+		}
+		return
+	}
+
+	fmt.Fprintf(b, "## `%s`\n\n", fn.Name)
+	fmt.Fprintf(b, "- **ID:** `%s`\n- **Type:** %s\n- **Location:** `%s`\n", fn.ID, fn.Type, fn.location())
+	if fn.Comments != "" {
+		fmt.Fprintf(b, "\n%s\n", fn.Comments)
+	}
+	if fn.Summary != "" {
+		fmt.Fprintf(b, "\n### Summary\n\n%s\n", fn.Summary)
+	}
+	fmt.Fprintf(b, "\n```go\n%s\n```\n", strings.TrimRight(fn.Code, "\n"))
+	for _, list := range []struct {
+		title string
+		refs  []reference
+	}{{"Calls", fn.Calls}, {"Called by", fn.CalledBy}} {
+		fmt.Fprintf(b, "\n### %s\n\n", list.title)
+		if len(list.refs) == 0 {
+			b.WriteString("None\n")
+		}
+		for _, ref := range list.refs {
+			fmt.Fprintf(b, "- `%s` (%s)\n", ref.ID, ref.location())
+		}
+	}
+}
+
+func writeCallTree(b *strings.Builder, node *callNode, depth int, md bool) {
+	name := node.Name
+	if node.External {
+		name = node.ID
+	}
+	if md {
+		fmt.Fprintf(b, "%s- `%s` (%s)\n", strings.Repeat("  ", depth), name, node.location())
+	} else {
+		fmt.Fprintf(b, "%s%s (%s)\n", strings.Repeat("  ", depth), name, node.location())
+	}
+	for _, child := range node.Calls {
+		writeCallTree(b, child, depth+1, md)
+	}
+}
+
+func writeRelated(b *strings.Builder, related []relatedOutput, md bool) {
+	if md {
+		b.WriteString("| Function | Location | Score | Summary |\n|---|---|---|---|\n")
+		for _, r := range related {
+			summary := strings.ReplaceAll(strings.ReplaceAll(r.Summary, "\n", " "), "|", "\\|")
+			fmt.Fprintf(b, "| `%s` | `%s` | %.3f | %s |\n", r.Name, r.location(), r.Score, summary)
+		}
+		return
+	}
+	if len(related) == 0 {
+		b.WriteString("No related functions found.\n")
+	}
+	for i, r := range related {
+		fmt.Fprintf(b, "%d. %s (%s) score %.3f\n", i+1, r.Name, r.location(), r.Score)
+	}
+}
+
+func writeOpportunities(b *strings.Builder, opportunities []coderag.RefactoringOpportunity, md bool) {
+	if md {
+		b.WriteString("| Severity | Kind | Location | Churn | Description |\n|---|---|---|---|---|\n")
+		for _, opp := range opportunities {
+			fmt.Fprintf(b, "| %s | %s | `%s` | %d | %s |\n", opp.Severity, opp.Kind, opp.Location, opp.Churn, strings.ReplaceAll(opp.Description, "|", "\\|"))
+		}
+		return
+	}
+	if len(opportunities) == 0 {
+		b.WriteString("No refactoring opportunities found.\n")
+	}
+	for i, opp := range opportunities {
+		fmt.Fprintf(b, "%d. %s\n   Location: %s\n   Severity: %s\n\n", i+1, opp.Description, opp.Location, opp.Severity)
+	}
+}
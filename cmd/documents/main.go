@@ -2,6 +2,8 @@ package main
 
 import (
 	"encoding/json"
+	"errors"
+	"flag"
 	"fmt"
 	"io"
 	"net/http"
@@ -10,13 +12,25 @@ import (
 	"strings"
 	"sync"
 
+	"manifold/internal/apiguard"
 	"manifold/internal/documents"
+	"manifold/internal/ingest"
 )
 
 var docManager *documents.DocumentManager
 var indexManager *documents.IndexManager
 
 func main() {
+	addr := flag.String("addr", ":8080", "Address to listen on")
+	apiKey := flag.String("api-key", os.Getenv("DOCUMENTS_API_KEY"), "API key clients must send as a bearer token or X-API-Key, DOCUMENTS_API_KEY")
+	noAuth := flag.Bool("no-auth", false, "Serve without an API key")
+	maxUploadMB := flag.Int("max-upload-mb", 50, "Largest PDF accepted, in MB")
+	flag.Parse()
+	if *apiKey == "" && !*noAuth {
+		fmt.Println("An API key is required, set -api-key or DOCUMENTS_API_KEY, or -no-auth to serve without one")
+		os.Exit(2)
+	}
+
 	// Initialize IndexManager
 	var err error
 	indexManager, err = documents.NewIndexManager("/tmp/bleve_index")
@@ -28,15 +42,22 @@ func main() {
 	// Initialize the DocumentManager with chunk size, overlap size, and IndexManager
 	docManager = documents.NewDocumentManager(2048, 0, indexManager)
 
-	// Setup HTTP routes
-	http.HandleFunc("/ingest/git", handleGitIngest)
-	http.HandleFunc("/ingest/pdf", handlePDFIngest)
-	http.HandleFunc("/split", handleSplitDocuments)
-	http.HandleFunc("/query", handleQueryChunks)
+	// Setup HTTP routes, PDF uploads are limited to max-upload-mb
+	mux := http.NewServeMux()
+	mux.HandleFunc("/ingest/git", handleGitIngest)
+	mux.Handle("/ingest/pdf", apiguard.LimitBody(int64(*maxUploadMB)<<20)(
+		apiguard.RequireContentType("multipart/form-data")(http.HandlerFunc(handlePDFIngest))))
+	mux.HandleFunc("/split", handleSplitDocuments)
+	mux.HandleFunc("/query", handleQueryChunks)
+
+	var handler http.Handler = mux
+	if !*noAuth {
+		handler = apiguard.RequireKey([]apiguard.Key{{Name: "documents", Key: *apiKey}}, nil)(mux)
+	}
 
 	// Start HTTP server
-	fmt.Println("Server is running on http://localhost:8080")
-	if err := http.ListenAndServe(":8080", nil); err != nil {
+	fmt.Printf("Server is running on %s\n", *addr)
+	if err := http.ListenAndServe(*addr, handler); err != nil {
 		fmt.Println("Failed to start server:", err)
 	}
 }
@@ -76,17 +97,22 @@ func handleGitIngest(w http.ResponseWriter, r *http.Request) {
 	// Read branch parameter (optional, defaults to empty string)
 	branch := r.URL.Query().Get("branch")
 
-	repoPath := "/tmp/git_repo" // Local path for cloning the repo
-	privateKeyPath := ""        // Leave empty for public repositories
+	repoPath := documents.ClonePath(filepath.Join(os.TempDir(), "git_repos"), cloneURL)
+	privateKeyPath := "" // Leave empty for public repositories
 
 	var wg sync.WaitGroup
+	var err error
 	wg.Add(1)
 	go func() {
 		defer wg.Done()
-		docManager.IngestGitRepo(repoPath, cloneURL, branch, privateKeyPath, nil, false)
+		err = docManager.IngestGitRepo(repoPath, cloneURL, branch, privateKeyPath, nil, false)
 	}()
 
 	wg.Wait()
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to load Git repository: %s", err), http.StatusInternalServerError)
+		return
+	}
 
 	w.WriteHeader(http.StatusOK)
 	fmt.Fprintln(w, "Git repository ingested and indexed successfully.")
@@ -102,6 +128,11 @@ func handlePDFIngest(w http.ResponseWriter, r *http.Request) {
 
 	// Parse the uploaded file
 	file, handler, err := r.FormFile("pdf")
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		http.Error(w, fmt.Sprintf("Uploaded file is larger than %d bytes", tooLarge.Limit), http.StatusRequestEntityTooLarge)
+		return
+	}
 	if err != nil {
 		http.Error(w, "Error parsing uploaded file", http.StatusBadRequest)
 		return
@@ -159,7 +190,7 @@ func handleSplitDocuments(w http.ResponseWriter, r *http.Request) {
 	go func() {
 		defer wg.Done()
 		// Split the documents using the DocumentManager
-		splits, err := docManager.SplitDocuments()
+		splits, err := ingest.SplitDocuments(r.Context(), docManager, nil)
 		if err != nil {
 			fmt.Printf("Error: Failed to split documents: %s\n", err)
 			http.Error(w, fmt.Sprintf("Failed to split documents: %s", err), http.StatusInternalServerError)
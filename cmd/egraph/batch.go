@@ -0,0 +1,235 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+)
+
+// mutationKind is the kind of change of a mutation.
+type mutationKind int
+
+const (
+	mutationCreateNode mutationKind = iota
+	mutationUpdateNode
+	mutationDeleteNode
+	mutationCreateRelationship
+	mutationDeleteRelationship
+)
+
+// mutation is a change of a graph. Node is set by the node mutations, relationship by the
+// relationship ones, and id by the deletions.
+type mutation struct {
+	kind         mutationKind
+	node         *Node
+	relationship *Relationship
+	id           int
+}
+
+// Batch collects changes applied together by Graph.Apply: all of them or, when one fails, none.
+// The nodes and relationships it creates get their IDs when they are added, so that later changes
+// of the batch can refer to them. A batch is applied once, before any other change of the graph.
+type Batch struct {
+	graph               *Graph
+	mutations           []mutation
+	firstNodeID         int // of the graph when the batch was created
+	firstRelationshipID int
+	nextNodeID          int
+	nextRelationshipID  int
+}
+
+// NewBatch returns an empty batch of changes of the graph.
+func (g *Graph) NewBatch() *Batch {
+	return &Batch{
+		graph:               g,
+		firstNodeID:         g.nextNodeID,
+		firstRelationshipID: g.nextRelationshipID,
+		nextNodeID:          g.nextNodeID,
+		nextRelationshipID:  g.nextRelationshipID,
+	}
+}
+
+// Len returns the number of changes of the batch.
+func (b *Batch) Len() int {
+	return len(b.mutations)
+}
+
+// CreateNode adds the creation of a node.
+func (b *Batch) CreateNode(nodeType string, data map[string]interface{}) *Node {
+	node := &Node{ID: b.nextNodeID, Type: nodeType, Data: data}
+	b.nextNodeID++
+	b.mutations = append(b.mutations, mutation{kind: mutationCreateNode, node: node})
+	return node
+}
+
+// UpdateNode adds the replacement of the data of a node.
+func (b *Batch) UpdateNode(id int, data map[string]interface{}) {
+	b.mutations = append(b.mutations, mutation{kind: mutationUpdateNode, node: &Node{ID: id, Data: data}})
+}
+
+// DeleteNode adds the deletion of a node and of its relationships.
+func (b *Batch) DeleteNode(id int) {
+	b.mutations = append(b.mutations, mutation{kind: mutationDeleteNode, id: id})
+}
+
+// CreateRelationship adds the creation of a relationship.
+func (b *Batch) CreateRelationship(from, to int, relationshipType string, data map[string]interface{}) *Relationship {
+	relationship := &Relationship{ID: b.nextRelationshipID, From: from, To: to, Type: relationshipType, Data: data}
+	b.nextRelationshipID++
+	b.mutations = append(b.mutations, mutation{kind: mutationCreateRelationship, relationship: relationship})
+	return relationship
+}
+
+// DeleteRelationship adds the deletion of a relationship.
+func (b *Batch) DeleteRelationship(id int) {
+	b.mutations = append(b.mutations, mutation{kind: mutationDeleteRelationship, id: id})
+}
+
+// Apply applies the changes of a batch of the graph, writing them to the store first in a single
+// transaction. Changes referring to nodes or relationships that don't exist, at their point of the
+// batch, fail the whole batch. Deleting a node deletes its relationships, those the batch creates
+// before included.
+func (g *Graph) Apply(b *Batch) error {
+	// The IDs the batch allocated must still be free
+	if b.graph != g || b.firstNodeID != g.nextNodeID || b.firstRelationshipID != g.nextRelationshipID {
+		return fmt.Errorf("batch of another graph or out of date")
+	}
+	mutations, err := g.validate(b.mutations)
+	if err != nil {
+		return err
+	}
+	if g.store != nil {
+		if err := g.store.Apply(mutations, b.nextNodeID, b.nextRelationshipID); err != nil {
+			return fmt.Errorf("error storing changes: %v", err)
+		}
+	}
+	for _, m := range mutations {
+		g.apply(m)
+	}
+	g.nextNodeID, g.nextRelationshipID = b.nextNodeID, b.nextRelationshipID
+	b.mutations = nil
+	return nil
+}
+
+// validate checks the mutations in order against the graph as the previous ones leave it, and
+// returns them with the type of the nodes updated and a deletion of the relationships of each node
+// deleted.
+func (g *Graph) validate(mutations []mutation) ([]mutation, error) {
+	// The nodes and relationships changed by the batch so far, nil once deleted
+	nodes := make(map[int]*Node)
+	relationships := make(map[int]*Relationship)
+	node := func(id int) *Node {
+		if n, ok := nodes[id]; ok {
+			return n
+		}
+		return g.Nodes[id]
+	}
+	relationship := func(id int) *Relationship {
+		if r, ok := relationships[id]; ok {
+			return r
+		}
+		return g.Relationships[id]
+	}
+
+	var validated []mutation
+	for _, m := range mutations {
+		switch m.kind {
+		case mutationCreateNode:
+			nodes[m.node.ID] = m.node
+		case mutationUpdateNode:
+			current := node(m.node.ID)
+			if current == nil {
+				return nil, fmt.Errorf("unknown node %d", m.node.ID)
+			}
+			m.node = &Node{ID: current.ID, Type: current.Type, Data: m.node.Data}
+			nodes[m.node.ID] = m.node
+		case mutationDeleteNode:
+			if node(m.id) == nil {
+				return nil, fmt.Errorf("unknown node %d", m.id)
+			}
+			// Delete its relationships first, those of the graph and those of the batch
+			attached := make(map[int]bool)
+			for _, r := range g.outgoing[m.id] {
+				attached[r.ID] = true
+			}
+			for _, r := range g.incoming[m.id] {
+				attached[r.ID] = true
+			}
+			for id, r := range relationships {
+				if r != nil && (r.From == m.id || r.To == m.id) {
+					attached[id] = true
+				}
+			}
+			for _, id := range sortedIDs(attached) {
+				if relationship(id) != nil {
+					validated = append(validated, mutation{kind: mutationDeleteRelationship, id: id})
+					relationships[id] = nil
+				}
+			}
+			nodes[m.id] = nil
+		case mutationCreateRelationship:
+			if node(m.relationship.From) == nil || node(m.relationship.To) == nil {
+				return nil, fmt.Errorf("relationship between unknown nodes %d and %d", m.relationship.From, m.relationship.To)
+			}
+			relationships[m.relationship.ID] = m.relationship
+		case mutationDeleteRelationship:
+			if relationship(m.id) == nil {
+				return nil, fmt.Errorf("unknown relationship %d", m.id)
+			}
+			relationships[m.id] = nil
+		}
+		validated = append(validated, m)
+	}
+	return validated, nil
+}
+
+// apply applies a validated mutation in memory.
+func (g *Graph) apply(m mutation) {
+	switch m.kind {
+	case mutationCreateNode:
+		g.Nodes[m.node.ID] = m.node
+		g.index.add(m.node)
+	case mutationUpdateNode:
+		node := g.Nodes[m.node.ID]
+		g.index.remove(node)
+		node.Data = m.node.Data
+		g.index.add(node)
+	case mutationDeleteNode:
+		g.index.remove(g.Nodes[m.id])
+		delete(g.Nodes, m.id)
+	case mutationCreateRelationship:
+		g.addRelationship(m.relationship)
+	case mutationDeleteRelationship:
+		relationship := g.Relationships[m.id]
+		delete(g.Relationships, m.id)
+		g.outgoing[relationship.From] = without(g.outgoing[relationship.From], relationship)
+		g.incoming[relationship.To] = without(g.incoming[relationship.To], relationship)
+	}
+}
+
+// addRelationship adds a relationship to the graph and its adjacency lists.
+func (g *Graph) addRelationship(relationship *Relationship) {
+	g.Relationships[relationship.ID] = relationship
+	g.outgoing[relationship.From] = append(g.outgoing[relationship.From], relationship)
+	g.incoming[relationship.To] = append(g.incoming[relationship.To], relationship)
+}
+
+// without returns the relationships but one, nil when none are left.
+func without(relationships []*Relationship, removed *Relationship) []*Relationship {
+	var kept []*Relationship
+	for _, relationship := range relationships {
+		if relationship != removed {
+			kept = append(kept, relationship)
+		}
+	}
+	return kept
+}
+
+// sortedIDs returns the IDs of a set in order.
+func sortedIDs(set map[int]bool) []int {
+	ids := make([]int, 0, len(set))
+	for id := range set {
+		ids = append(ids, id)
+	}
+	sort.Ints(ids)
+	return ids
+}
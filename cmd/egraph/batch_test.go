@@ -0,0 +1,87 @@
+package main
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBatchApply(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "graph.db")
+	store, err := OpenSQLiteStore(path)
+	require.NoError(t, err)
+	graph, err := NewGraph(store)
+	require.NoError(t, err)
+
+	// Later changes of a batch refer to the nodes it creates
+	b := graph.NewBatch()
+	alice := b.CreateNode("Person", map[string]interface{}{"name": "Alice"})
+	bob := b.CreateNode("Person", map[string]interface{}{"name": "Bob"})
+	knows := b.CreateRelationship(alice.ID, bob.ID, "KNOWS", nil)
+	b.UpdateNode(bob.ID, map[string]interface{}{"name": "Robert"})
+	assert.Equal(t, 4, b.Len())
+	assert.Empty(t, graph.Nodes, "nothing is applied before Apply")
+	require.NoError(t, graph.Apply(b))
+	assert.Equal(t, "Person", graph.Nodes[bob.ID].Type, "updates keep the type")
+	assert.Equal(t, []int{bob.ID}, nodeIDs(graph.FindNodes("", map[string]interface{}{"name": "Robert"})))
+	assert.Equal(t, knows, graph.Relationships[knows.ID])
+
+	// A batch failing part way changes nothing, in memory or in the store
+	b = graph.NewBatch()
+	carol := b.CreateNode("Person", map[string]interface{}{"name": "Carol"})
+	b.DeleteRelationship(knows.ID)
+	b.DeleteNode(42)
+	assert.ErrorContains(t, graph.Apply(b), "unknown node 42")
+	assert.Len(t, graph.Nodes, 2)
+	assert.NotContains(t, graph.Nodes, carol.ID)
+	assert.Contains(t, graph.Relationships, knows.ID)
+
+	// A batch allocates IDs from the graph as it was created
+	stale := graph.NewBatch()
+	stale.CreateNode("Person", nil)
+	dave, err := graph.CreateNode("Person", map[string]interface{}{"name": "Dave"})
+	require.NoError(t, err)
+	assert.ErrorContains(t, graph.Apply(stale), "out of date")
+
+	// Deleting a node deletes the relationships created earlier in the batch too
+	b = graph.NewBatch()
+	eve := b.CreateNode("Person", nil)
+	b.CreateRelationship(eve.ID, alice.ID, "KNOWS", nil)
+	b.DeleteNode(alice.ID)
+	require.NoError(t, graph.Apply(b))
+	assert.Empty(t, graph.Relationships)
+	assert.Empty(t, graph.outgoing[eve.ID])
+	assert.Empty(t, graph.incoming[bob.ID])
+	require.NoError(t, graph.Close())
+
+	// IDs are never reused, even those of the last nodes and relationships deleted
+	store, err = OpenSQLiteStore(path)
+	require.NoError(t, err)
+	graph, err = NewGraph(store)
+	require.NoError(t, err)
+	defer graph.Close()
+	assert.Equal(t, []int{bob.ID, dave.ID, eve.ID}, nodeIDs(graph.sortedNodes()))
+	assert.Empty(t, graph.Relationships)
+	node, err := graph.CreateNode("Person", nil)
+	require.NoError(t, err)
+	assert.Equal(t, eve.ID+1, node.ID)
+	relationship, err := graph.CreateRelationship(bob.ID, node.ID, "KNOWS", nil)
+	require.NoError(t, err)
+	assert.Equal(t, 2, relationship.ID)
+}
+
+func TestDeleteRelationship(t *testing.T) {
+	graph := newTraversalGraph(t)
+	path, err := graph.ShortestPath(0, 3, TraversalOptions{})
+	require.NoError(t, err)
+	require.Equal(t, 3, path.Len())
+
+	deleted := path.Relationships[1].ID
+	require.NoError(t, graph.DeleteRelationship(deleted))
+	path, err = graph.ShortestPath(0, 3, TraversalOptions{})
+	require.NoError(t, err)
+	assert.Nil(t, path)
+	assert.ErrorContains(t, graph.DeleteRelationship(deleted), "unknown relationship")
+}
@@ -0,0 +1,397 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// binding maps the variables of a query to the nodes and relationships matched.
+type binding map[string]interface{}
+
+// Result is the table of the rows a query returns. A column of a variable holds the *Node or
+// *Relationship matched, a column of a property its value, nil when it is missing.
+type Result struct {
+	Columns []string
+	Rows    [][]interface{}
+}
+
+// step binds the node of the pattern at index to, from the node at index from already bound,
+// through the relationship pattern between them. Filters are the conditions of the WHERE clause
+// that can be checked once the step is done.
+type step struct {
+	from, to     int
+	relationship int
+	outgoing     bool // follow the relationships from the node bound, rather than to it
+	either       bool // follow the relationships both ways
+	filters      []expr
+}
+
+// queryPlan matches the pattern from its most selective node, then binds the others one
+// relationship at a time, checking each condition as soon as its variables are bound.
+type queryPlan struct {
+	start      int
+	candidates []*Node // of the start node, through the indexes when they apply
+	filters    []expr  // checked once the start node is bound
+	steps      []step
+}
+
+// Query runs a query on the graph, see query.go for the language.
+func (g *Graph) Query(query string) (*Result, error) {
+	q, err := parseQuery(query)
+	if err != nil {
+		return nil, err
+	}
+	plan := g.plan(q)
+
+	result := &Result{}
+	for _, item := range q.returns {
+		result.Columns = append(result.Columns, item.alias)
+	}
+	ex := &executor{graph: g, query: q, plan: plan, result: result}
+	for _, node := range plan.candidates {
+		if q.limit >= 0 && len(result.Rows) >= q.limit {
+			break
+		}
+		b := binding{q.nodes[plan.start].variable: node}
+		if checkFilters(plan.filters, b) {
+			ex.match(b, 0, make(map[*Relationship]bool))
+		}
+	}
+	return result, nil
+}
+
+// plan picks the node of the pattern matching the fewest nodes of the graph to start from, looked
+// up by type and by the properties the pattern or the WHERE clause sets, and attaches each
+// condition of the WHERE clause to the first step binding all of its variables.
+func (g *Graph) plan(q *parsedQuery) *queryPlan {
+	plan := &queryPlan{}
+	equal := equalities(q.where)
+	for i, pattern := range q.nodes {
+		candidates := g.candidates(pattern, equal[pattern.variable])
+		if i == 0 || len(candidates) < len(plan.candidates) {
+			plan.start, plan.candidates = i, candidates
+		}
+	}
+
+	// Extend the path to the right of the start node, then to its left
+	for i := plan.start; i < len(q.nodes)-1; i++ {
+		direction := q.relationships[i].direction
+		plan.steps = append(plan.steps, step{from: i, to: i + 1, relationship: i, outgoing: direction == directionRight, either: direction == directionEither})
+	}
+	for i := plan.start; i > 0; i-- {
+		direction := q.relationships[i-1].direction
+		plan.steps = append(plan.steps, step{from: i, to: i - 1, relationship: i - 1, outgoing: direction == directionLeft, either: direction == directionEither})
+	}
+
+	if q.where == nil {
+		return plan
+	}
+	bound := map[string]bool{q.nodes[plan.start].variable: true}
+	boundAll := func(variables []string) bool {
+		for _, variable := range variables {
+			if !bound[variable] {
+				return false
+			}
+		}
+		return true
+	}
+	pending := conjuncts(q.where)
+	pending, plan.filters = partition(pending, func(e expr) bool { return boundAll(e.variables()) })
+	for i := range plan.steps {
+		bound[q.relationships[plan.steps[i].relationship].variable] = true
+		bound[q.nodes[plan.steps[i].to].variable] = true
+		pending, plan.steps[i].filters = partition(pending, func(e expr) bool { return boundAll(e.variables()) })
+	}
+	return plan
+}
+
+// equalities returns the properties the conditions ANDed in a WHERE clause set to a literal, by
+// variable.
+func equalities(where expr) map[string]map[string]interface{} {
+	equal := make(map[string]map[string]interface{})
+	if where == nil {
+		return equal
+	}
+	for _, condition := range conjuncts(where) {
+		compare, ok := condition.(*compareExpr)
+		if !ok || compare.op != "=" {
+			continue
+		}
+		property, isProperty := compare.left.(*propertyExpr)
+		literal, isLiteral := compare.right.(*literalExpr)
+		if !isProperty || !isLiteral {
+			property, isProperty = compare.right.(*propertyExpr)
+			literal, isLiteral = compare.left.(*literalExpr)
+		}
+		if !isProperty || !isLiteral || property.property == "" {
+			continue
+		}
+		if equal[property.variable] == nil {
+			equal[property.variable] = make(map[string]interface{})
+		}
+		equal[property.variable][property.property] = literal.value
+	}
+	return equal
+}
+
+// conjuncts splits a condition into the conditions it ANDs.
+func conjuncts(e expr) []expr {
+	if logical, ok := e.(*logicalExpr); ok && logical.op == "AND" {
+		return append(conjuncts(logical.left), conjuncts(logical.right)...)
+	}
+	return []expr{e}
+}
+
+// partition splits the expressions into those rejected and those accepted by the predicate.
+func partition(exprs []expr, accept func(expr) bool) (rejected, accepted []expr) {
+	for _, e := range exprs {
+		if accept(e) {
+			accepted = append(accepted, e)
+		} else {
+			rejected = append(rejected, e)
+		}
+	}
+	return rejected, accepted
+}
+
+// executor runs a plan.
+type executor struct {
+	graph  *Graph
+	query  *parsedQuery
+	plan   *queryPlan
+	result *Result
+}
+
+// match binds the rest of the pattern from the step, adding a row for each complete match. A
+// relationship is matched once per path.
+func (ex *executor) match(b binding, stepIndex int, used map[*Relationship]bool) {
+	if ex.query.limit >= 0 && len(ex.result.Rows) >= ex.query.limit {
+		return
+	}
+	if stepIndex == len(ex.plan.steps) {
+		ex.emit(b)
+		return
+	}
+
+	s := ex.plan.steps[stepIndex]
+	from := b[ex.query.nodes[s.from].variable].(*Node)
+	relationshipPattern := ex.query.relationships[s.relationship]
+	nodePattern := ex.query.nodes[s.to]
+	try := func(relationship *Relationship, other int) {
+		if used[relationship] || !matchesRelationship(relationship, relationshipPattern) {
+			return
+		}
+		node := ex.graph.Nodes[other]
+		if bound, ok := b[nodePattern.variable]; ok && bound != node {
+			// The variable appears twice in the pattern
+			return
+		}
+		if !matchesNode(node, nodePattern) {
+			return
+		}
+		next := make(binding, len(b)+2)
+		for variable, value := range b {
+			next[variable] = value
+		}
+		next[relationshipPattern.variable] = relationship
+		next[nodePattern.variable] = node
+		if !checkFilters(s.filters, next) {
+			return
+		}
+		used[relationship] = true
+		ex.match(next, stepIndex+1, used)
+		delete(used, relationship)
+	}
+	if s.outgoing || s.either {
+		for _, relationship := range ex.graph.outgoing[from.ID] {
+			try(relationship, relationship.To)
+		}
+	}
+	if !s.outgoing || s.either {
+		for _, relationship := range ex.graph.incoming[from.ID] {
+			try(relationship, relationship.From)
+		}
+	}
+}
+
+// emit adds the row of the RETURN clause of a match.
+func (ex *executor) emit(b binding) {
+	row := make([]interface{}, len(ex.query.returns))
+	for i, item := range ex.query.returns {
+		row[i] = item.expr.eval(b)
+	}
+	ex.result.Rows = append(ex.result.Rows, row)
+}
+
+// checkFilters reports whether the binding satisfies all the conditions.
+func checkFilters(filters []expr, b binding) bool {
+	for _, filter := range filters {
+		if value, ok := filter.eval(b).(bool); !ok || !value {
+			return false
+		}
+	}
+	return true
+}
+
+// matchesNode reports whether a node has the type and properties of a pattern.
+func matchesNode(node *Node, pattern nodePattern) bool {
+	if pattern.nodeType != "" && node.Type != pattern.nodeType {
+		return false
+	}
+	return matchesProperties(node.Data, pattern.properties)
+}
+
+// matchesRelationship reports whether a relationship has the type and properties of a pattern.
+func matchesRelationship(relationship *Relationship, pattern relationshipPattern) bool {
+	if pattern.relType != "" && relationship.Type != pattern.relType {
+		return false
+	}
+	return matchesProperties(relationship.Data, pattern.properties)
+}
+
+// matchesProperties reports whether data has the properties.
+func matchesProperties(data, properties map[string]interface{}) bool {
+	for key, value := range properties {
+		if compare("=", data[key], value) != true {
+			return false
+		}
+	}
+	return true
+}
+
+func (e *literalExpr) eval(b binding) interface{} { return e.value }
+
+func (e *propertyExpr) eval(b binding) interface{} {
+	value := b[e.variable]
+	if e.property == "" {
+		return value
+	}
+	switch element := value.(type) {
+	case *Node:
+		return element.Data[e.property]
+	case *Relationship:
+		return element.Data[e.property]
+	}
+	return nil
+}
+
+func (e *compareExpr) eval(b binding) interface{} {
+	return compare(e.op, e.left.eval(b), e.right.eval(b))
+}
+
+func (e *logicalExpr) eval(b binding) interface{} {
+	left, _ := e.left.eval(b).(bool)
+	if e.op == "AND" && !left {
+		return false
+	}
+	if e.op == "OR" && left {
+		return true
+	}
+	right, _ := e.right.eval(b).(bool)
+	return right
+}
+
+func (e *notExpr) eval(b binding) interface{} {
+	value, ok := e.operand.eval(b).(bool)
+	if !ok {
+		return nil
+	}
+	return !value
+}
+
+// compare applies a comparison operator, nil when the values can't be compared, such as missing
+// properties or a string and a number. Numbers of any type compare by value.
+func compare(op string, left, right interface{}) interface{} {
+	if l, ok := toFloat(left); ok {
+		r, ok := toFloat(right)
+		if !ok {
+			return nil
+		}
+		switch op {
+		case "=":
+			return l == r
+		case "<>":
+			return l != r
+		case "<":
+			return l < r
+		case "<=":
+			return l <= r
+		case ">":
+			return l > r
+		case ">=":
+			return l >= r
+		}
+		return nil
+	}
+	switch l := left.(type) {
+	case string:
+		r, ok := right.(string)
+		if !ok {
+			return nil
+		}
+		switch op {
+		case "=":
+			return l == r
+		case "<>":
+			return l != r
+		case "<":
+			return l < r
+		case "<=":
+			return l <= r
+		case ">":
+			return l > r
+		case ">=":
+			return l >= r
+		case "CONTAINS":
+			return strings.Contains(l, r)
+		}
+	case bool:
+		r, ok := right.(bool)
+		if !ok {
+			return nil
+		}
+		switch op {
+		case "=":
+			return l == r
+		case "<>":
+			return l != r
+		}
+	case *Node, *Relationship:
+		switch op {
+		case "=":
+			return left == right
+		case "<>":
+			return left != right
+		}
+	}
+	return nil
+}
+
+// toFloat returns the value of a number of any type.
+func toFloat(value interface{}) (float64, bool) {
+	switch v := value.(type) {
+	case int:
+		return float64(v), true
+	case int64:
+		return float64(v), true
+	case float32:
+		return float64(v), true
+	case float64:
+		return v, true
+	}
+	return 0, false
+}
+
+// formatValue formats a value of a result row, nodes as (id:Type data) and relationships as
+// (from)-[:TYPE data]->(to).
+func formatValue(value interface{}) string {
+	switch v := value.(type) {
+	case *Node:
+		return fmt.Sprintf("(%d:%s %v)", v.ID, v.Type, v.Data)
+	case *Relationship:
+		return fmt.Sprintf("(%d)-[:%s %v]->(%d)", v.From, v.Type, v.Data, v.To)
+	case nil:
+		return "null"
+	}
+	return fmt.Sprint(value)
+}
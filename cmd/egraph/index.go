@@ -0,0 +1,138 @@
+package main
+
+import "sort"
+
+// nodeSet is a set of nodes by ID.
+type nodeSet map[int]*Node
+
+// graphIndex indexes the nodes of a graph by type and by the value of each of their properties.
+// Only string, number and boolean values are indexed, numbers by their float64 value so that 1 and
+// 1.0 are the same.
+type graphIndex struct {
+	byType     map[string]nodeSet
+	byProperty map[string]map[interface{}]nodeSet
+}
+
+func newGraphIndex() *graphIndex {
+	return &graphIndex{byType: make(map[string]nodeSet), byProperty: make(map[string]map[interface{}]nodeSet)}
+}
+
+// indexKey returns the key of a property value in the index, false for values not indexed.
+func indexKey(value interface{}) (interface{}, bool) {
+	if number, ok := toFloat(value); ok {
+		return number, true
+	}
+	switch value.(type) {
+	case string, bool:
+		return value, true
+	}
+	return nil, false
+}
+
+// add indexes a node.
+func (ix *graphIndex) add(node *Node) {
+	if ix.byType[node.Type] == nil {
+		ix.byType[node.Type] = make(nodeSet)
+	}
+	ix.byType[node.Type][node.ID] = node
+	for property, value := range node.Data {
+		key, ok := indexKey(value)
+		if !ok {
+			continue
+		}
+		values := ix.byProperty[property]
+		if values == nil {
+			values = make(map[interface{}]nodeSet)
+			ix.byProperty[property] = values
+		}
+		if values[key] == nil {
+			values[key] = make(nodeSet)
+		}
+		values[key][node.ID] = node
+	}
+}
+
+// remove drops a node from the index, as it was indexed.
+func (ix *graphIndex) remove(node *Node) {
+	if nodes := ix.byType[node.Type]; nodes != nil {
+		delete(nodes, node.ID)
+		if len(nodes) == 0 {
+			delete(ix.byType, node.Type)
+		}
+	}
+	for property, value := range node.Data {
+		key, ok := indexKey(value)
+		if !ok {
+			continue
+		}
+		if nodes := ix.byProperty[property][key]; nodes != nil {
+			delete(nodes, node.ID)
+			if len(nodes) == 0 {
+				delete(ix.byProperty[property], key)
+			}
+		}
+	}
+}
+
+// lookup returns the nodes of a type, when set, with the properties, intersecting the smallest
+// sets of the index first. ok is false when no condition can use the index, and every node must be
+// scanned.
+func (ix *graphIndex) lookup(nodeType string, properties map[string]interface{}) (nodes []*Node, ok bool) {
+	var sets []nodeSet
+	if nodeType != "" {
+		sets = append(sets, ix.byType[nodeType])
+	}
+	for property, value := range properties {
+		if key, indexed := indexKey(value); indexed {
+			sets = append(sets, ix.byProperty[property][key])
+		}
+	}
+	if len(sets) == 0 {
+		return nil, false
+	}
+
+	sort.Slice(sets, func(i, j int) bool { return len(sets[i]) < len(sets[j]) })
+	for id, node := range sets[0] {
+		found := true
+		for _, set := range sets[1:] {
+			if _, found = set[id]; !found {
+				break
+			}
+		}
+		if found {
+			nodes = append(nodes, node)
+		}
+	}
+	sort.Slice(nodes, func(i, j int) bool { return nodes[i].ID < nodes[j].ID })
+	return nodes, true
+}
+
+// FindNodes returns the nodes of a type, any when empty, with the properties, through the indexes.
+func (g *Graph) FindNodes(nodeType string, properties map[string]interface{}) []*Node {
+	return g.candidates(nodePattern{nodeType: nodeType, properties: properties}, nil)
+}
+
+// candidates returns the nodes matching a pattern and the properties it must equal, looked up in
+// the index when it can be, scanned otherwise.
+func (g *Graph) candidates(pattern nodePattern, equal map[string]interface{}) []*Node {
+	properties := make(map[string]interface{}, len(pattern.properties)+len(equal))
+	for property, value := range equal {
+		properties[property] = value
+	}
+	for property, value := range pattern.properties {
+		properties[property] = value
+	}
+	nodes, indexed := g.index.lookup(pattern.nodeType, properties)
+	if !indexed {
+		nodes = g.sortedNodes()
+	}
+
+	var matched []*Node
+	for _, node := range nodes {
+		// The index only narrows the nodes down, values it doesn't index are still compared
+		if matchesNode(node, pattern) && matchesProperties(node.Data, equal) {
+			matched = append(matched, node)
+		}
+	}
+	return matched
+}
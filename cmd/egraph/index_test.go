@@ -0,0 +1,106 @@
+package main
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// nodeIDs returns the IDs of nodes, in order.
+func nodeIDs(nodes []*Node) []int {
+	ids := []int{}
+	for _, node := range nodes {
+		ids = append(ids, node.ID)
+	}
+	return ids
+}
+
+func TestGraphIndexLookup(t *testing.T) {
+	ix := newGraphIndex()
+	nodes := []*Node{
+		{ID: 0, Type: "Person", Data: map[string]interface{}{"name": "Alice", "age": 30}},
+		{ID: 1, Type: "Person", Data: map[string]interface{}{"name": "Bob", "age": 30.0}},
+		{ID: 2, Type: "Movie", Data: map[string]interface{}{"name": "Alice", "tags": []interface{}{"x"}}},
+	}
+	for _, node := range nodes {
+		ix.add(node)
+	}
+
+	tests := []struct {
+		name       string
+		nodeType   string
+		properties map[string]interface{}
+		want       []int
+		indexed    bool
+	}{
+		{"type", "Person", nil, []int{0, 1}, true},
+		{"numbers by value", "", map[string]interface{}{"age": int64(30)}, []int{0, 1}, true},
+		{"intersection", "Person", map[string]interface{}{"name": "Alice"}, []int{0}, true},
+		{"no match", "Movie", map[string]interface{}{"age": 30}, []int{}, true},
+		{"unknown type", "Director", nil, []int{}, true},
+		{"nothing indexed", "", map[string]interface{}{"tags": []interface{}{"x"}}, []int{}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			found, indexed := ix.lookup(tt.nodeType, tt.properties)
+			assert.Equal(t, tt.indexed, indexed)
+			assert.Equal(t, tt.want, nodeIDs(found))
+		})
+	}
+
+	ix.remove(nodes[0])
+	found, _ := ix.lookup("", map[string]interface{}{"name": "Alice"})
+	assert.Equal(t, []int{2}, nodeIDs(found))
+	assert.NotContains(t, ix.byProperty["age"], 0, "empty sets are dropped")
+}
+
+func TestFindNodes(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "graph.db")
+	store, err := OpenSQLiteStore(path)
+	require.NoError(t, err)
+	graph, err := NewGraph(store)
+	require.NoError(t, err)
+
+	alice, err := graph.CreateNode("Person", map[string]interface{}{"name": "Alice", "city": "Paris"})
+	require.NoError(t, err)
+	bob, err := graph.CreateNode("Person", map[string]interface{}{"name": "Bob", "city": "Paris"})
+	require.NoError(t, err)
+	matrix, err := graph.CreateNode("Movie", map[string]interface{}{"title": "The Matrix"})
+	require.NoError(t, err)
+	_, err = graph.CreateRelationship(bob.ID, matrix.ID, "WATCHED", nil)
+	require.NoError(t, err)
+
+	assert.Equal(t, []int{0, 1}, nodeIDs(graph.FindNodes("Person", map[string]interface{}{"city": "Paris"})))
+
+	// Updates move the node in the index, deletes drop it and its relationships
+	_, err = graph.UpdateNode(alice.ID, map[string]interface{}{"name": "Alice", "city": "Rome"})
+	require.NoError(t, err)
+	require.NoError(t, graph.DeleteNode(bob.ID))
+	assert.Equal(t, []int{}, nodeIDs(graph.FindNodes("", map[string]interface{}{"city": "Paris"})))
+	assert.Equal(t, []int{0}, nodeIDs(graph.FindNodes("Person", map[string]interface{}{"city": "Rome"})))
+	assert.Empty(t, graph.Relationships)
+	assert.Error(t, graph.DeleteNode(bob.ID))
+	_, err = graph.UpdateNode(7, nil)
+	assert.Error(t, err)
+	require.NoError(t, graph.Close())
+
+	// The index is rebuilt when loading
+	store, err = OpenSQLiteStore(path)
+	require.NoError(t, err)
+	graph, err = NewGraph(store)
+	require.NoError(t, err)
+	defer graph.Close()
+	require.Len(t, graph.Nodes, 2)
+	assert.NotContains(t, graph.Nodes, 1)
+	assert.Empty(t, graph.Relationships)
+	assert.Equal(t, []int{0}, nodeIDs(graph.FindNodes("", map[string]interface{}{"city": "Rome"})))
+
+	result, err := graph.Query("MATCH (p) WHERE p.city = 'Rome' RETURN p.name")
+	require.NoError(t, err)
+	assert.Equal(t, [][]interface{}{{"Alice"}}, result.Rows)
+	result, err = graph.Query("MATCH (n) RETURN n")
+	require.NoError(t, err)
+	assert.Len(t, result.Rows, 2, "deleted nodes aren't scanned")
+}
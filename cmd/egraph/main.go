@@ -1,8 +1,14 @@
 package main
 
-import "fmt"
+import (
+	"flag"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+)
 
-// Node represents a node in the graph database.
+// Node represents a node in the graph database. IDs are never reused, even once deleted.
 type Node struct {
 	ID   int
 	Type string
@@ -11,6 +17,7 @@ type Node struct {
 
 // Relationship represents a relationship between two nodes.
 type Relationship struct {
+	ID   int
 	From int
 	To   int
 	Type string
@@ -19,64 +26,160 @@ type Relationship struct {
 
 // Graph represents the graph database.
 type Graph struct {
-	Nodes         []*Node
-	Relationships []*Relationship
+	Nodes              map[int]*Node
+	Relationships      map[int]*Relationship
+	nextNodeID         int
+	nextRelationshipID int
+	store              Store // nil keeps the graph in memory only
+	index              *graphIndex
+	outgoing           map[int][]*Relationship // by From
+	incoming           map[int][]*Relationship // by To
 }
 
-// CreateNode creates a new node in the graph.
-func (g *Graph) CreateNode(nodeType string, data map[string]interface{}) *Node {
-	node := &Node{
-		ID:   len(g.Nodes),
-		Type: nodeType,
-		Data: data,
+// NewGraph returns the graph of the store, loaded from it, or an empty in-memory graph when the
+// store is nil.
+func NewGraph(store Store) (*Graph, error) {
+	g := &Graph{
+		Nodes:         make(map[int]*Node),
+		Relationships: make(map[int]*Relationship),
+		store:         store,
+		index:         newGraphIndex(),
+		outgoing:      make(map[int][]*Relationship),
+		incoming:      make(map[int][]*Relationship),
+	}
+	if store == nil {
+		return g, nil
+	}
+	snapshot, err := store.Load()
+	if err != nil {
+		return nil, fmt.Errorf("error loading graph: %v", err)
+	}
+	for _, node := range snapshot.Nodes {
+		g.Nodes[node.ID] = node
+		g.index.add(node)
 	}
-	g.Nodes = append(g.Nodes, node)
-	return node
+	for _, relationship := range snapshot.Relationships {
+		g.addRelationship(relationship)
+	}
+	g.nextNodeID, g.nextRelationshipID = snapshot.NextNodeID, snapshot.NextRelationshipID
+	return g, nil
 }
 
-// CreateRelationship creates a new relationship between two nodes.
-func (g *Graph) CreateRelationship(from, to int, relationshipType string, data map[string]interface{}) *Relationship {
-	relationship := &Relationship{
-		From: from,
-		To:   to,
-		Type: relationshipType,
-		Data: data,
-	}
-	g.Relationships = append(g.Relationships, relationship)
-	return relationship
+// node returns the node of an ID, nil when there is none.
+func (g *Graph) node(id int) *Node {
+	return g.Nodes[id]
 }
 
-// Query executes a simple query on the graph.
-func (g *Graph) Query(query string) ([]*Node, error) {
-	// Implement query execution logic here.
-	// This is a simplified example and does not support full Cypher query language.
-	var results []*Node
+// sortedNodes returns the nodes by ID.
+func (g *Graph) sortedNodes() []*Node {
+	nodes := make([]*Node, 0, len(g.Nodes))
 	for _, node := range g.Nodes {
-		if node.Type == "Person" {
-			results = append(results, node)
-		}
+		nodes = append(nodes, node)
 	}
-	return results, nil
+	sort.Slice(nodes, func(i, j int) bool { return nodes[i].ID < nodes[j].ID })
+	return nodes
+}
+
+// CreateNode creates a new node in the graph, stored before it is added.
+func (g *Graph) CreateNode(nodeType string, data map[string]interface{}) (*Node, error) {
+	b := g.NewBatch()
+	node := b.CreateNode(nodeType, data)
+	return node, g.Apply(b)
+}
+
+// UpdateNode replaces the data of a node, stored before it is changed.
+func (g *Graph) UpdateNode(id int, data map[string]interface{}) (*Node, error) {
+	b := g.NewBatch()
+	b.UpdateNode(id, data)
+	if err := g.Apply(b); err != nil {
+		return nil, err
+	}
+	return g.Nodes[id], nil
+}
+
+// DeleteNode deletes a node and its relationships, from the store first.
+func (g *Graph) DeleteNode(id int) error {
+	b := g.NewBatch()
+	b.DeleteNode(id)
+	return g.Apply(b)
+}
+
+// CreateRelationship creates a new relationship between two nodes, stored before it is added.
+func (g *Graph) CreateRelationship(from, to int, relationshipType string, data map[string]interface{}) (*Relationship, error) {
+	b := g.NewBatch()
+	relationship := b.CreateRelationship(from, to, relationshipType, data)
+	return relationship, g.Apply(b)
+}
+
+// DeleteRelationship deletes a relationship, from the store first.
+func (g *Graph) DeleteRelationship(id int) error {
+	b := g.NewBatch()
+	b.DeleteRelationship(id)
+	return g.Apply(b)
+}
+
+// Close closes the store of the graph.
+func (g *Graph) Close() error {
+	if g.store == nil {
+		return nil
+	}
+	return g.store.Close()
 }
 
 func main() {
-	// Create a new graph database
-	graph := &Graph{}
+	dbPath := flag.String("db", "", "SQLite database the graph is loaded from and stored in, in memory when empty")
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "Usage: egraph [-db path] [query]\n")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
 
-	// Create nodes
-	alice := graph.CreateNode("Person", map[string]interface{}{"name": "Alice"})
-	matrix := graph.CreateNode("Movie", map[string]interface{}{"title": "The Matrix"})
+	// Open the graph database
+	var store Store
+	if *dbPath != "" {
+		sqliteStore, err := OpenSQLiteStore(*dbPath)
+		if err != nil {
+			log.Fatalf("Failed to open graph database: %v", err)
+		}
+		store = sqliteStore
+	}
+	graph, err := NewGraph(store)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer graph.Close()
 
-	// Create a relationship
-	graph.CreateRelationship(alice.ID, matrix.ID, "WATCHED", nil)
+	// Create the example nodes and relationship the first time
+	if len(graph.Nodes) == 0 {
+		alice, err := graph.CreateNode("Person", map[string]interface{}{"name": "Alice"})
+		if err != nil {
+			log.Fatal(err)
+		}
+		matrix, err := graph.CreateNode("Movie", map[string]interface{}{"title": "The Matrix"})
+		if err != nil {
+			log.Fatal(err)
+		}
+		if _, err := graph.CreateRelationship(alice.ID, matrix.ID, "WATCHED", nil); err != nil {
+			log.Fatal(err)
+		}
+	}
 
 	// Query the database
-	results, err := graph.Query("MATCH (p:Person) RETURN p")
+	query := "MATCH (p:Person)-[:WATCHED]->(m:Movie) RETURN p.name, m.title"
+	if flag.NArg() > 0 {
+		query = strings.Join(flag.Args(), " ")
+	}
+	result, err := graph.Query(query)
 	if err != nil {
-		panic(err)
+		log.Fatal(err)
 	}
 
-	for _, node := range results {
-		fmt.Printf("%s\n", node.Data["name"])
+	fmt.Println(strings.Join(result.Columns, "\t"))
+	for _, row := range result.Rows {
+		values := make([]string, len(row))
+		for i, value := range row {
+			values[i] = formatValue(value)
+		}
+		fmt.Println(strings.Join(values, "\t"))
 	}
 }
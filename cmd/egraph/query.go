@@ -0,0 +1,587 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// The query language is a subset of Cypher matching a single path pattern:
+//
+//	MATCH (a:Person {name: 'Alice'})-[r:WATCHED]->(m:Movie)<-[:DIRECTED]-(d)
+//	WHERE m.year >= 1999 AND NOT d.name CONTAINS 'Smith'
+//	RETURN a.name, m.title AS title, d
+//	LIMIT 10
+//
+// Node and relationship variables, types and properties are optional. Relationships are directed
+// with -> or <-, or match either direction with -[...]-. WHERE supports =, <>, <, <=, >, >=,
+// CONTAINS, AND, OR, NOT and parentheses over properties and string, number and boolean literals.
+
+// tokenKind is the kind of a token of a query.
+type tokenKind int
+
+const (
+	tokenEOF tokenKind = iota
+	tokenIdent
+	tokenString
+	tokenNumber
+	tokenSymbol
+)
+
+// token is a lexeme of a query, and its position for errors.
+type token struct {
+	kind tokenKind
+	text string
+	pos  int
+}
+
+// twoCharSymbols are the symbols of two characters, lexed before those of one.
+var twoCharSymbols = []string{"<>", "<=", ">="}
+
+// lex splits a query into tokens.
+func lex(query string) ([]token, error) {
+	var tokens []token
+	runes := []rune(query)
+	for i := 0; i < len(runes); {
+		r := runes[i]
+		switch {
+		case unicode.IsSpace(r):
+			i++
+		case unicode.IsLetter(r) || r == '_':
+			start := i
+			for i < len(runes) && (unicode.IsLetter(runes[i]) || unicode.IsDigit(runes[i]) || runes[i] == '_') {
+				i++
+			}
+			tokens = append(tokens, token{kind: tokenIdent, text: string(runes[start:i]), pos: start})
+		case unicode.IsDigit(r) || r == '-' && i+1 < len(runes) && unicode.IsDigit(runes[i+1]):
+			// A minus before a digit is the sign of a number, the arrows of a pattern never are
+			start := i
+			for i++; i < len(runes) && (unicode.IsDigit(runes[i]) || runes[i] == '.'); {
+				i++
+			}
+			tokens = append(tokens, token{kind: tokenNumber, text: string(runes[start:i]), pos: start})
+		case r == '\'' || r == '"':
+			start := i
+			var text strings.Builder
+			for i++; ; i++ {
+				if i >= len(runes) {
+					return nil, fmt.Errorf("unterminated string at %d", start)
+				}
+				if runes[i] == '\\' && i+1 < len(runes) {
+					i++
+				} else if runes[i] == r {
+					break
+				}
+				text.WriteRune(runes[i])
+			}
+			i++
+			tokens = append(tokens, token{kind: tokenString, text: text.String(), pos: start})
+		default:
+			text := string(r)
+			for _, symbol := range twoCharSymbols {
+				if strings.HasPrefix(string(runes[i:min(i+2, len(runes))]), symbol) {
+					text = symbol
+				}
+			}
+			if !strings.Contains("()[]{}:,.-<>=*", string(r)) {
+				return nil, fmt.Errorf("unexpected %q at %d", r, i)
+			}
+			tokens = append(tokens, token{kind: tokenSymbol, text: text, pos: i})
+			i += len([]rune(text))
+		}
+	}
+	return append(tokens, token{kind: tokenEOF, pos: len(runes)}), nil
+}
+
+// nodePattern matches a node of the path pattern.
+type nodePattern struct {
+	variable   string
+	nodeType   string // any type when empty
+	properties map[string]interface{}
+}
+
+// Directions of a relationship pattern, from the node before it to the node after it.
+const (
+	directionEither = 0
+	directionRight  = 1  // (a)-[]->(b)
+	directionLeft   = -1 // (a)<-[]-(b)
+)
+
+// relationshipPattern matches a relationship between two nodes of the path pattern.
+type relationshipPattern struct {
+	variable   string
+	relType    string // any type when empty
+	properties map[string]interface{}
+	direction  int
+}
+
+// returnItem is a column of the result, a variable or the property of one.
+type returnItem struct {
+	expr  *propertyExpr
+	alias string
+}
+
+// parsedQuery is the syntax tree of a query.
+type parsedQuery struct {
+	nodes         []nodePattern
+	relationships []relationshipPattern // between nodes i and i+1
+	where         expr                  // nil without WHERE
+	returns       []returnItem
+	limit         int // no limit when negative
+}
+
+// expr is an expression of a WHERE clause.
+type expr interface {
+	eval(b binding) interface{}
+	variables() []string
+}
+
+// literalExpr is a string, number or boolean.
+type literalExpr struct{ value interface{} }
+
+// propertyExpr is a variable, or a property of one when property is set.
+type propertyExpr struct {
+	variable string
+	property string
+}
+
+// compareExpr compares two expressions.
+type compareExpr struct {
+	op          string
+	left, right expr
+}
+
+// logicalExpr combines conditions with AND or OR.
+type logicalExpr struct {
+	op          string
+	left, right expr
+}
+
+// notExpr negates a condition.
+type notExpr struct{ operand expr }
+
+// parser parses the tokens of a query.
+type parser struct {
+	tokens    []token
+	pos       int
+	anonymous int // variables named for anonymous patterns
+}
+
+// parseQuery parses a query.
+func parseQuery(query string) (*parsedQuery, error) {
+	tokens, err := lex(query)
+	if err != nil {
+		return nil, err
+	}
+	p := &parser{tokens: tokens}
+	q, err := p.parse()
+	if err != nil {
+		return nil, fmt.Errorf("invalid query: %v", err)
+	}
+	return q, nil
+}
+
+func (p *parser) peek() token {
+	return p.tokens[p.pos]
+}
+
+func (p *parser) next() token {
+	t := p.tokens[p.pos]
+	if t.kind != tokenEOF {
+		p.pos++
+	}
+	return t
+}
+
+// isSymbol reports whether the next token is the symbol.
+func (p *parser) isSymbol(symbol string) bool {
+	t := p.peek()
+	return t.kind == tokenSymbol && t.text == symbol
+}
+
+// isKeyword reports whether the next token is the keyword, in any case.
+func (p *parser) isKeyword(keyword string) bool {
+	t := p.peek()
+	return t.kind == tokenIdent && strings.EqualFold(t.text, keyword)
+}
+
+// expectSymbol consumes the symbol.
+func (p *parser) expectSymbol(symbol string) error {
+	if !p.isSymbol(symbol) {
+		return p.unexpected("'" + symbol + "'")
+	}
+	p.next()
+	return nil
+}
+
+// expectKeyword consumes the keyword.
+func (p *parser) expectKeyword(keyword string) error {
+	if !p.isKeyword(keyword) {
+		return p.unexpected(keyword)
+	}
+	p.next()
+	return nil
+}
+
+// expectIdent consumes an identifier.
+func (p *parser) expectIdent() (string, error) {
+	if p.peek().kind != tokenIdent {
+		return "", p.unexpected("a name")
+	}
+	return p.next().text, nil
+}
+
+func (p *parser) unexpected(expected string) error {
+	t := p.peek()
+	if t.kind == tokenEOF {
+		return fmt.Errorf("expected %s at end of query", expected)
+	}
+	return fmt.Errorf("expected %s at %d, found %q", expected, t.pos, t.text)
+}
+
+func (p *parser) parse() (*parsedQuery, error) {
+	q := &parsedQuery{limit: -1}
+	if err := p.expectKeyword("MATCH"); err != nil {
+		return nil, err
+	}
+	if err := p.parsePattern(q); err != nil {
+		return nil, err
+	}
+
+	if p.isKeyword("WHERE") {
+		p.next()
+		where, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		q.where = where
+	}
+
+	if err := p.expectKeyword("RETURN"); err != nil {
+		return nil, err
+	}
+	for {
+		item, err := p.parseReturnItem()
+		if err != nil {
+			return nil, err
+		}
+		q.returns = append(q.returns, item)
+		if !p.isSymbol(",") {
+			break
+		}
+		p.next()
+	}
+
+	if p.isKeyword("LIMIT") {
+		p.next()
+		t := p.next()
+		limit, err := strconv.Atoi(t.text)
+		if t.kind != tokenNumber || err != nil || limit < 0 {
+			return nil, fmt.Errorf("invalid limit %q", t.text)
+		}
+		q.limit = limit
+	}
+	if p.peek().kind != tokenEOF {
+		return nil, p.unexpected("end of query")
+	}
+
+	bound := make(map[string]bool)
+	for _, node := range q.nodes {
+		bound[node.variable] = true
+	}
+	for _, relationship := range q.relationships {
+		bound[relationship.variable] = true
+	}
+	var used []string
+	if q.where != nil {
+		used = q.where.variables()
+	}
+	for _, item := range q.returns {
+		used = append(used, item.expr.variable)
+	}
+	for _, variable := range used {
+		if !bound[variable] {
+			return nil, fmt.Errorf("unknown variable %s", variable)
+		}
+	}
+	return q, nil
+}
+
+// parsePattern parses a path of nodes linked by relationships.
+func (p *parser) parsePattern(q *parsedQuery) error {
+	node, err := p.parseNode()
+	if err != nil {
+		return err
+	}
+	q.nodes = append(q.nodes, node)
+	for p.isSymbol("-") || p.isSymbol("<") {
+		relationship, err := p.parseRelationship()
+		if err != nil {
+			return err
+		}
+		node, err := p.parseNode()
+		if err != nil {
+			return err
+		}
+		q.relationships = append(q.relationships, relationship)
+		q.nodes = append(q.nodes, node)
+	}
+	return nil
+}
+
+// parseNode parses (variable:Type {properties}).
+func (p *parser) parseNode() (nodePattern, error) {
+	var node nodePattern
+	if err := p.expectSymbol("("); err != nil {
+		return node, err
+	}
+	var err error
+	if node.variable, node.nodeType, node.properties, err = p.parseElement(); err != nil {
+		return node, err
+	}
+	return node, p.expectSymbol(")")
+}
+
+// parseRelationship parses -[variable:TYPE {properties}]-> and its other directions. The brackets
+// may be left out to match any relationship.
+func (p *parser) parseRelationship() (relationshipPattern, error) {
+	var relationship relationshipPattern
+	left := p.isSymbol("<")
+	if left {
+		p.next()
+	}
+	if err := p.expectSymbol("-"); err != nil {
+		return relationship, err
+	}
+	if p.isSymbol("[") {
+		p.next()
+		var err error
+		if relationship.variable, relationship.relType, relationship.properties, err = p.parseElement(); err != nil {
+			return relationship, err
+		}
+		if err := p.expectSymbol("]"); err != nil {
+			return relationship, err
+		}
+	} else {
+		relationship.variable = p.anonymousVariable()
+	}
+	if err := p.expectSymbol("-"); err != nil {
+		return relationship, err
+	}
+	right := p.isSymbol(">")
+	if right {
+		p.next()
+	}
+	switch {
+	case left && right:
+		return relationship, fmt.Errorf("relationship at %d points both ways", p.peek().pos)
+	case left:
+		relationship.direction = directionLeft
+	case right:
+		relationship.direction = directionRight
+	}
+	return relationship, nil
+}
+
+// parseElement parses the variable, type and properties of a node or relationship, naming it when
+// it has no variable.
+func (p *parser) parseElement() (variable, elementType string, properties map[string]interface{}, err error) {
+	if p.peek().kind == tokenIdent {
+		variable = p.next().text
+	} else {
+		variable = p.anonymousVariable()
+	}
+	if p.isSymbol(":") {
+		p.next()
+		if elementType, err = p.expectIdent(); err != nil {
+			return
+		}
+	}
+	if p.isSymbol("{") {
+		p.next()
+		properties = make(map[string]interface{})
+		for !p.isSymbol("}") {
+			var key string
+			if key, err = p.expectIdent(); err != nil {
+				return
+			}
+			if err = p.expectSymbol(":"); err != nil {
+				return
+			}
+			var value expr
+			if value, err = p.parseOperand(); err != nil {
+				return
+			}
+			literal, ok := value.(*literalExpr)
+			if !ok {
+				err = fmt.Errorf("property %s must be a literal", key)
+				return
+			}
+			properties[key] = literal.value
+			if !p.isSymbol(",") {
+				break
+			}
+			p.next()
+		}
+		err = p.expectSymbol("}")
+	}
+	return
+}
+
+// anonymousVariable names a node or relationship without a variable, with a name queries can't use.
+func (p *parser) anonymousVariable() string {
+	p.anonymous++
+	return fmt.Sprintf(" %d", p.anonymous)
+}
+
+// parseReturnItem parses variable[.property] [AS alias].
+func (p *parser) parseReturnItem() (returnItem, error) {
+	operand, err := p.parseOperand()
+	if err != nil {
+		return returnItem{}, err
+	}
+	property, ok := operand.(*propertyExpr)
+	if !ok {
+		return returnItem{}, fmt.Errorf("RETURN takes variables and properties")
+	}
+	item := returnItem{expr: property, alias: property.String()}
+	if p.isKeyword("AS") {
+		p.next()
+		if item.alias, err = p.expectIdent(); err != nil {
+			return item, err
+		}
+	}
+	return item, nil
+}
+
+func (p *parser) parseOr() (expr, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for p.isKeyword("OR") {
+		p.next()
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		left = &logicalExpr{op: "OR", left: left, right: right}
+	}
+	return left, nil
+}
+
+func (p *parser) parseAnd() (expr, error) {
+	left, err := p.parseNot()
+	if err != nil {
+		return nil, err
+	}
+	for p.isKeyword("AND") {
+		p.next()
+		right, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		left = &logicalExpr{op: "AND", left: left, right: right}
+	}
+	return left, nil
+}
+
+func (p *parser) parseNot() (expr, error) {
+	if p.isKeyword("NOT") {
+		p.next()
+		operand, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		return &notExpr{operand: operand}, nil
+	}
+	return p.parseComparison()
+}
+
+// comparisonOperators are the operators comparing two operands.
+var comparisonOperators = []string{"=", "<>", "<", "<=", ">", ">="}
+
+func (p *parser) parseComparison() (expr, error) {
+	if p.isSymbol("(") {
+		p.next()
+		inner, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		return inner, p.expectSymbol(")")
+	}
+	left, err := p.parseOperand()
+	if err != nil {
+		return nil, err
+	}
+	var op string
+	if p.isKeyword("CONTAINS") {
+		op = "CONTAINS"
+	} else {
+		for _, operator := range comparisonOperators {
+			if p.isSymbol(operator) {
+				op = operator
+			}
+		}
+	}
+	if op == "" {
+		// A lone operand is a condition when it is a boolean
+		return left, nil
+	}
+	p.next()
+	right, err := p.parseOperand()
+	if err != nil {
+		return nil, err
+	}
+	return &compareExpr{op: op, left: left, right: right}, nil
+}
+
+// parseOperand parses a literal, a variable or a property.
+func (p *parser) parseOperand() (expr, error) {
+	t := p.peek()
+	switch {
+	case t.kind == tokenString:
+		p.next()
+		return &literalExpr{value: t.text}, nil
+	case t.kind == tokenNumber:
+		p.next()
+		value, err := strconv.ParseFloat(t.text, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid number %q at %d", t.text, t.pos)
+		}
+		return &literalExpr{value: value}, nil
+	case t.kind == tokenIdent && (strings.EqualFold(t.text, "true") || strings.EqualFold(t.text, "false")):
+		p.next()
+		return &literalExpr{value: strings.EqualFold(t.text, "true")}, nil
+	case t.kind == tokenIdent:
+		p.next()
+		property := &propertyExpr{variable: t.text}
+		if p.isSymbol(".") {
+			p.next()
+			var err error
+			if property.property, err = p.expectIdent(); err != nil {
+				return nil, err
+			}
+		}
+		return property, nil
+	}
+	return nil, p.unexpected("a value")
+}
+
+func (e *propertyExpr) String() string {
+	if e.property == "" {
+		return e.variable
+	}
+	return e.variable + "." + e.property
+}
+
+func (e *literalExpr) variables() []string  { return nil }
+func (e *propertyExpr) variables() []string { return []string{e.variable} }
+func (e *compareExpr) variables() []string {
+	return append(e.left.variables(), e.right.variables()...)
+}
+func (e *logicalExpr) variables() []string {
+	return append(e.left.variables(), e.right.variables()...)
+}
+func (e *notExpr) variables() []string { return e.operand.variables() }
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLex(t *testing.T) {
+	tests := []struct {
+		query string
+		want  []token
+	}{
+		{
+			query: "(a:Person)-[r]->(b)",
+			want: []token{
+				{tokenSymbol, "(", 0}, {tokenIdent, "a", 1}, {tokenSymbol, ":", 2}, {tokenIdent, "Person", 3}, {tokenSymbol, ")", 9},
+				{tokenSymbol, "-", 10}, {tokenSymbol, "[", 11}, {tokenIdent, "r", 12}, {tokenSymbol, "]", 13}, {tokenSymbol, "-", 14},
+				{tokenSymbol, ">", 15}, {tokenSymbol, "(", 16}, {tokenIdent, "b", 17}, {tokenSymbol, ")", 18}, {tokenEOF, "", 19},
+			},
+		},
+		{
+			query: "a.x >= -1.5",
+			want: []token{
+				{tokenIdent, "a", 0}, {tokenSymbol, ".", 1}, {tokenIdent, "x", 2}, {tokenSymbol, ">=", 4}, {tokenNumber, "-1.5", 7},
+				{tokenEOF, "", 11},
+			},
+		},
+		{
+			query: "x<>-2",
+			want:  []token{{tokenIdent, "x", 0}, {tokenSymbol, "<>", 1}, {tokenNumber, "-2", 3}, {tokenEOF, "", 5}},
+		},
+		{
+			query: `'it\'s' "two"`,
+			want:  []token{{tokenString, "it's", 0}, {tokenString, "two", 8}, {tokenEOF, "", 13}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.query, func(t *testing.T) {
+			tokens, err := lex(tt.query)
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, tokens)
+		})
+	}
+}
+
+func TestParseQuery(t *testing.T) {
+	q, err := parseQuery("MATCH (a:Person {name: 'Alice', age: -3})<-[:KNOWS]-(b)-[r]-(c) WHERE NOT a.x = -1 RETURN a.name AS name, c LIMIT 2")
+	require.NoError(t, err)
+
+	require.Len(t, q.nodes, 3)
+	assert.Equal(t, nodePattern{variable: "a", nodeType: "Person", properties: map[string]interface{}{"name": "Alice", "age": -3.0}}, q.nodes[0])
+	assert.Equal(t, "b", q.nodes[1].variable)
+	require.Len(t, q.relationships, 2)
+	assert.Equal(t, "KNOWS", q.relationships[0].relType)
+	assert.Equal(t, directionLeft, q.relationships[0].direction)
+	assert.Equal(t, "r", q.relationships[1].variable)
+	assert.Equal(t, directionEither, q.relationships[1].direction)
+
+	assert.Equal(t, &notExpr{operand: &compareExpr{op: "=", left: &propertyExpr{variable: "a", property: "x"}, right: &literalExpr{value: -1.0}}}, q.where)
+	require.Len(t, q.returns, 2)
+	assert.Equal(t, "name", q.returns[0].alias)
+	assert.Equal(t, "c", q.returns[1].alias)
+	assert.Equal(t, 2, q.limit)
+}
+
+func TestParseQueryErrors(t *testing.T) {
+	tests := []struct {
+		name  string
+		query string
+		err   string
+	}{
+		{"no match", "RETURN a", "expected MATCH"},
+		{"unknown character", "MATCH (a) RETURN a;", "unexpected ';'"},
+		{"unterminated string", "MATCH (a {name: 'Alice}) RETURN a", "unterminated string"},
+		{"both ways", "MATCH (a)<-[r]->(b) RETURN a", "points both ways"},
+		{"unknown variable", "MATCH (a) RETURN b", "unknown variable b"},
+		{"unknown variable in where", "MATCH (a) WHERE b.x = 1 RETURN a", "unknown variable b"},
+		{"return literal", "MATCH (a) RETURN 1", "RETURN takes variables and properties"},
+		{"negative limit", "MATCH (a) RETURN a LIMIT -1", "invalid limit"},
+		{"invalid number", "MATCH (a) WHERE a.x = 1.2.3 RETURN a", "invalid number"},
+		{"trailing tokens", "MATCH (a) RETURN a a", "end of query"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := parseQuery(tt.query)
+			require.Error(t, err)
+			assert.Contains(t, err.Error(), tt.err)
+		})
+	}
+}
+
+func TestQuery(t *testing.T) {
+	graph, err := NewGraph(nil)
+	require.NoError(t, err)
+	create := func(nodeType string, data map[string]interface{}) *Node {
+		node, err := graph.CreateNode(nodeType, data)
+		require.NoError(t, err)
+		return node
+	}
+	alice := create("Person", map[string]interface{}{"name": "Alice", "balance": -1})
+	bob := create("Person", map[string]interface{}{"name": "Bob", "balance": 10})
+	matrix := create("Movie", map[string]interface{}{"title": "The Matrix", "year": 1999})
+	heat := create("Movie", map[string]interface{}{"title": "Heat", "year": 1995})
+	for _, r := range []struct {
+		from, to *Node
+		relType  string
+	}{{alice, matrix, "WATCHED"}, {bob, matrix, "WATCHED"}, {bob, heat, "WATCHED"}, {alice, bob, "KNOWS"}} {
+		_, err := graph.CreateRelationship(r.from.ID, r.to.ID, r.relType, nil)
+		require.NoError(t, err)
+	}
+
+	tests := []struct {
+		name  string
+		query string
+		want  [][]interface{}
+	}{
+		{"negative number", "MATCH (p:Person) WHERE p.balance = -1 RETURN p.name", [][]interface{}{{"Alice"}}},
+		{"negative property", "MATCH (p:Person {balance: -1}) RETURN p.name", [][]interface{}{{"Alice"}}},
+		{"outgoing", "MATCH (p:Person {name: 'Bob'})-[:WATCHED]->(m) RETURN m.title", [][]interface{}{{"The Matrix"}, {"Heat"}}},
+		{"incoming", "MATCH (m:Movie)<-[:WATCHED]-(p) WHERE m.year < 1999 RETURN p.name", [][]interface{}{{"Bob"}}},
+		{"either way", "MATCH (a {name: 'Bob'})-[:KNOWS]-(b) RETURN b.name", [][]interface{}{{"Alice"}}},
+		{"path", "MATCH (a)-[:KNOWS]->(b)-[:WATCHED]->(m) WHERE m.title CONTAINS 'eat' RETURN a.name, m.year", [][]interface{}{{"Alice", 1995}}},
+		{"or", "MATCH (m:Movie) WHERE m.year = 1995 OR m.title = 'The Matrix' RETURN m.title", [][]interface{}{{"The Matrix"}, {"Heat"}}},
+		{"missing property", "MATCH (p:Person) RETURN p.year", [][]interface{}{{nil}, {nil}}},
+		{"limit", "MATCH (p:Person) RETURN p.name LIMIT 1", [][]interface{}{{"Alice"}}},
+		{"variable", "MATCH (m:Movie {year: 1995}) RETURN m", [][]interface{}{{heat}}},
+		{"no match", "MATCH (p:Person)-[:DIRECTED]->(m) RETURN p", nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := graph.Query(tt.query)
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, result.Rows)
+		})
+	}
+}
@@ -0,0 +1,225 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+// Snapshot is the content of a store: its nodes and relationships, in the order they were created,
+// and the IDs of the next ones.
+type Snapshot struct {
+	Nodes              []*Node
+	Relationships      []*Relationship
+	NextNodeID         int
+	NextRelationshipID int
+}
+
+// Store persists the nodes and relationships of a graph. The graph writes every change to its
+// store before applying it, so a graph loaded from the store has every change that succeeded.
+type Store interface {
+	Load() (*Snapshot, error)
+	// Apply stores the validated changes of a batch, and the IDs of the next nodes and
+	// relationships, all or none of them.
+	Apply(mutations []mutation, nextNodeID, nextRelationshipID int) error
+	Close() error
+}
+
+// SQLiteStore keeps a graph in SQLite tables, in write-ahead logging mode with every commit synced
+// to disk.
+type SQLiteStore struct {
+	db *sql.DB
+}
+
+// OpenSQLiteStore opens the SQLite database at path, creating it and its tables when missing.
+func OpenSQLiteStore(path string) (*SQLiteStore, error) {
+	db, err := sql.Open("sqlite3", "file:"+path+"?_journal_mode=WAL&_synchronous=FULL&_busy_timeout=5000")
+	if err != nil {
+		return nil, err
+	}
+	// A single connection serializes the writes
+	db.SetMaxOpenConns(1)
+	_, err = db.Exec(`
+		CREATE TABLE IF NOT EXISTS nodes (
+			id INTEGER PRIMARY KEY,
+			type TEXT NOT NULL,
+			data TEXT
+		);
+		CREATE TABLE IF NOT EXISTS relationships (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			from_id INTEGER NOT NULL REFERENCES nodes(id),
+			to_id INTEGER NOT NULL REFERENCES nodes(id),
+			type TEXT NOT NULL,
+			data TEXT
+		);
+		CREATE INDEX IF NOT EXISTS relationships_from ON relationships(from_id);
+		CREATE INDEX IF NOT EXISTS relationships_to ON relationships(to_id);
+		CREATE TABLE IF NOT EXISTS counters (
+			name TEXT PRIMARY KEY,
+			value INTEGER NOT NULL
+		);`)
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("error creating tables: %v", err)
+	}
+	return &SQLiteStore{db: db}, nil
+}
+
+// Load reads the nodes and relationships, in the order they were created, and the next IDs, past
+// those of the nodes and relationships deleted too.
+func (s *SQLiteStore) Load() (*Snapshot, error) {
+	snapshot := &Snapshot{}
+	rows, err := s.db.Query("SELECT id, type, data FROM nodes ORDER BY id")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		node := &Node{}
+		var data sql.NullString
+		if err := rows.Scan(&node.ID, &node.Type, &data); err != nil {
+			return nil, err
+		}
+		if node.Data, err = decodeData(data); err != nil {
+			return nil, fmt.Errorf("node %d: %v", node.ID, err)
+		}
+		snapshot.Nodes = append(snapshot.Nodes, node)
+		snapshot.NextNodeID = max(snapshot.NextNodeID, node.ID+1)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	rows, err = s.db.Query("SELECT id, from_id, to_id, type, data FROM relationships ORDER BY id")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		relationship := &Relationship{}
+		var data sql.NullString
+		if err := rows.Scan(&relationship.ID, &relationship.From, &relationship.To, &relationship.Type, &data); err != nil {
+			return nil, err
+		}
+		if relationship.Data, err = decodeData(data); err != nil {
+			return nil, fmt.Errorf("relationship %d: %v", relationship.ID, err)
+		}
+		snapshot.Relationships = append(snapshot.Relationships, relationship)
+		snapshot.NextRelationshipID = max(snapshot.NextRelationshipID, relationship.ID+1)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	// The counters keep the IDs of the last nodes and relationships deleted from being reused
+	rows, err = s.db.Query("SELECT name, value FROM counters")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var name string
+		var value int
+		if err := rows.Scan(&name, &value); err != nil {
+			return nil, err
+		}
+		switch name {
+		case "nodes":
+			snapshot.NextNodeID = max(snapshot.NextNodeID, value)
+		case "relationships":
+			snapshot.NextRelationshipID = max(snapshot.NextRelationshipID, value)
+		}
+	}
+	return snapshot, rows.Err()
+}
+
+// Apply stores the changes of a batch in a single transaction.
+func (s *SQLiteStore) Apply(mutations []mutation, nextNodeID, nextRelationshipID int) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	for _, m := range mutations {
+		if err := applyMutation(tx, m); err != nil {
+			return err
+		}
+	}
+	_, err = tx.Exec("INSERT OR REPLACE INTO counters (name, value) VALUES ('nodes', ?), ('relationships', ?)",
+		nextNodeID, nextRelationshipID)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// applyMutation stores a change in a transaction.
+func applyMutation(tx *sql.Tx, m mutation) error {
+	switch m.kind {
+	case mutationCreateNode:
+		data, err := encodeData(m.node.Data)
+		if err != nil {
+			return err
+		}
+		_, err = tx.Exec("INSERT INTO nodes (id, type, data) VALUES (?, ?, ?)", m.node.ID, m.node.Type, data)
+		return err
+	case mutationUpdateNode:
+		data, err := encodeData(m.node.Data)
+		if err != nil {
+			return err
+		}
+		_, err = tx.Exec("UPDATE nodes SET data = ? WHERE id = ?", data, m.node.ID)
+		return err
+	case mutationDeleteNode:
+		// Validation deleted its relationships already, this keeps the table consistent regardless
+		if _, err := tx.Exec("DELETE FROM relationships WHERE from_id = ? OR to_id = ?", m.id, m.id); err != nil {
+			return err
+		}
+		_, err := tx.Exec("DELETE FROM nodes WHERE id = ?", m.id)
+		return err
+	case mutationCreateRelationship:
+		r := m.relationship
+		data, err := encodeData(r.Data)
+		if err != nil {
+			return err
+		}
+		_, err = tx.Exec("INSERT INTO relationships (id, from_id, to_id, type, data) VALUES (?, ?, ?, ?, ?)",
+			r.ID, r.From, r.To, r.Type, data)
+		return err
+	case mutationDeleteRelationship:
+		_, err := tx.Exec("DELETE FROM relationships WHERE id = ?", m.id)
+		return err
+	}
+	return fmt.Errorf("unknown mutation %d", m.kind)
+}
+
+// Close closes the database.
+func (s *SQLiteStore) Close() error {
+	return s.db.Close()
+}
+
+// encodeData encodes the properties of a node or relationship as JSON, NULL when there are none.
+func encodeData(data map[string]interface{}) (sql.NullString, error) {
+	if data == nil {
+		return sql.NullString{}, nil
+	}
+	encoded, err := json.Marshal(data)
+	if err != nil {
+		return sql.NullString{}, fmt.Errorf("error encoding data: %v", err)
+	}
+	return sql.NullString{String: string(encoded), Valid: true}, nil
+}
+
+// decodeData decodes the properties encoded by encodeData.
+func decodeData(data sql.NullString) (map[string]interface{}, error) {
+	if !data.Valid {
+		return nil, nil
+	}
+	var decoded map[string]interface{}
+	if err := json.Unmarshal([]byte(data.String), &decoded); err != nil {
+		return nil, fmt.Errorf("error decoding data: %v", err)
+	}
+	return decoded, nil
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSQLiteStore(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "graph.db")
+	store, err := OpenSQLiteStore(path)
+	require.NoError(t, err)
+	graph, err := NewGraph(store)
+	require.NoError(t, err)
+
+	alice, err := graph.CreateNode("Person", map[string]interface{}{"name": "Alice", "age": 30})
+	require.NoError(t, err)
+	matrix, err := graph.CreateNode("Movie", nil)
+	require.NoError(t, err)
+	_, err = graph.CreateRelationship(alice.ID, matrix.ID, "WATCHED", map[string]interface{}{"rating": 5})
+	require.NoError(t, err)
+	_, err = graph.CreateRelationship(alice.ID, 7, "WATCHED", nil)
+	assert.Error(t, err, "unknown nodes are rejected")
+	require.NoError(t, graph.Close())
+
+	// The graph loaded has the nodes and relationships in the order they were created
+	store, err = OpenSQLiteStore(path)
+	require.NoError(t, err)
+	graph, err = NewGraph(store)
+	require.NoError(t, err)
+	defer graph.Close()
+	require.Len(t, graph.Nodes, 2)
+	assert.Equal(t, &Node{ID: 0, Type: "Person", Data: map[string]interface{}{"name": "Alice", "age": 30.0}}, graph.Nodes[0])
+	assert.Equal(t, &Node{ID: 1, Type: "Movie"}, graph.Nodes[1])
+	require.Len(t, graph.Relationships, 1)
+	assert.Equal(t, &Relationship{From: 0, To: 1, Type: "WATCHED", Data: map[string]interface{}{"rating": 5.0}}, graph.Relationships[0])
+
+	// Nodes created after loading continue the IDs
+	bob, err := graph.CreateNode("Person", map[string]interface{}{"name": "Bob"})
+	require.NoError(t, err)
+	assert.Equal(t, 2, bob.ID)
+}
+
+func TestInMemoryGraph(t *testing.T) {
+	graph, err := NewGraph(nil)
+	require.NoError(t, err)
+	node, err := graph.CreateNode("Person", nil)
+	require.NoError(t, err)
+	_, err = graph.CreateRelationship(node.ID, node.ID, "KNOWS", nil)
+	require.NoError(t, err)
+	assert.Len(t, graph.Relationships, 1)
+	assert.NoError(t, graph.Close())
+}
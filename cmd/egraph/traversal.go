@@ -0,0 +1,186 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+	"sort"
+	"strings"
+)
+
+// Direction selects the relationships a traversal follows from a node.
+type Direction int
+
+const (
+	Outgoing Direction = iota // from the node
+	Incoming                  // to the node
+	Both
+)
+
+// TraversalOptions filter the relationships traversals follow.
+type TraversalOptions struct {
+	Direction Direction
+	Types     []string // relationship types followed, all when empty
+	MaxDepth  int      // hops from the start node, unlimited when 0
+}
+
+// Path is a walk through the graph: Relationships[i] links Nodes[i] and Nodes[i+1], in either
+// direction.
+type Path struct {
+	Nodes         []*Node
+	Relationships []*Relationship
+}
+
+// Len returns the number of relationships of the path.
+func (p Path) Len() int {
+	return len(p.Relationships)
+}
+
+// End returns the last node of the path.
+func (p Path) End() *Node {
+	return p.Nodes[len(p.Nodes)-1]
+}
+
+// extend returns the path followed by a relationship to a node, leaving the path unchanged.
+func (p Path) extend(relationship *Relationship, node *Node) Path {
+	return Path{
+		Nodes:         append(slices.Clip(p.Nodes), node),
+		Relationships: append(slices.Clip(p.Relationships), relationship),
+	}
+}
+
+// String formats the path like a query pattern, e.g. (0:Person)-[:KNOWS]->(1:Person).
+func (p Path) String() string {
+	var b strings.Builder
+	for i, node := range p.Nodes {
+		if i > 0 {
+			relationship := p.Relationships[i-1]
+			if relationship.To == node.ID {
+				fmt.Fprintf(&b, "-[:%s]->", relationship.Type)
+			} else {
+				fmt.Fprintf(&b, "<-[:%s]-", relationship.Type)
+			}
+		}
+		fmt.Fprintf(&b, "(%d:%s)", node.ID, node.Type)
+	}
+	return b.String()
+}
+
+// neighbors returns the relationships of a node the options follow, with the node at their other
+// end.
+func (g *Graph) neighbors(id int, opts TraversalOptions) (relationships []*Relationship, nodes []*Node) {
+	follow := func(relationship *Relationship, other int) {
+		if len(opts.Types) == 0 || slices.Contains(opts.Types, relationship.Type) {
+			relationships = append(relationships, relationship)
+			nodes = append(nodes, g.Nodes[other])
+		}
+	}
+	if opts.Direction == Outgoing || opts.Direction == Both {
+		for _, relationship := range g.outgoing[id] {
+			follow(relationship, relationship.To)
+		}
+	}
+	if opts.Direction == Incoming || opts.Direction == Both {
+		for _, relationship := range g.incoming[id] {
+			follow(relationship, relationship.From)
+		}
+	}
+	return relationships, nodes
+}
+
+// BFS visits the nodes reachable from the start node breadth first, each once with the shortest
+// path to it, the start node first. Visiting stops when visit returns false.
+func (g *Graph) BFS(start int, opts TraversalOptions, visit func(path Path) bool) error {
+	node := g.node(start)
+	if node == nil {
+		return fmt.Errorf("unknown node %d", start)
+	}
+	visited := map[int]bool{start: true}
+	queue := []Path{{Nodes: []*Node{node}}}
+	for len(queue) > 0 {
+		path := queue[0]
+		queue = queue[1:]
+		if !visit(path) {
+			return nil
+		}
+		if opts.MaxDepth > 0 && path.Len() >= opts.MaxDepth {
+			continue
+		}
+		relationships, nodes := g.neighbors(path.End().ID, opts)
+		for i, next := range nodes {
+			if !visited[next.ID] {
+				visited[next.ID] = true
+				queue = append(queue, path.extend(relationships[i], next))
+			}
+		}
+	}
+	return nil
+}
+
+// DFS visits the nodes reachable from the start node depth first, each once with the path it was
+// reached by, the start node first. Visiting stops when visit returns false.
+func (g *Graph) DFS(start int, opts TraversalOptions, visit func(path Path) bool) error {
+	node := g.node(start)
+	if node == nil {
+		return fmt.Errorf("unknown node %d", start)
+	}
+	visited := make(map[int]bool)
+	var walk func(path Path) bool
+	walk = func(path Path) bool {
+		visited[path.End().ID] = true
+		if !visit(path) {
+			return false
+		}
+		if opts.MaxDepth > 0 && path.Len() >= opts.MaxDepth {
+			return true
+		}
+		relationships, nodes := g.neighbors(path.End().ID, opts)
+		for i, next := range nodes {
+			if !visited[next.ID] && !walk(path.extend(relationships[i], next)) {
+				return false
+			}
+		}
+		return true
+	}
+	walk(Path{Nodes: []*Node{node}})
+	return nil
+}
+
+// ShortestPath returns a path with the fewest relationships from one node to another, nil when
+// there is none within the options.
+func (g *Graph) ShortestPath(from, to int, opts TraversalOptions) (*Path, error) {
+	if g.node(to) == nil {
+		return nil, fmt.Errorf("unknown node %d", to)
+	}
+	var shortest *Path
+	err := g.BFS(from, opts, func(path Path) bool {
+		if path.End().ID == to {
+			shortest = &path
+			return false
+		}
+		return true
+	})
+	return shortest, err
+}
+
+// Neighborhood returns the shortest path to each node within k hops of the start node, the start
+// node excluded, the closest first and then by ID.
+func (g *Graph) Neighborhood(start, k int, opts TraversalOptions) ([]Path, error) {
+	if k <= 0 {
+		return nil, fmt.Errorf("invalid number of hops %d", k)
+	}
+	opts.MaxDepth = k
+	var paths []Path
+	err := g.BFS(start, opts, func(path Path) bool {
+		if path.Len() > 0 {
+			paths = append(paths, path)
+		}
+		return true
+	})
+	sort.SliceStable(paths, func(i, j int) bool {
+		if paths[i].Len() != paths[j].Len() {
+			return paths[i].Len() < paths[j].Len()
+		}
+		return paths[i].End().ID < paths[j].End().ID
+	})
+	return paths, err
+}
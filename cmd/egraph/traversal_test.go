@@ -0,0 +1,104 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newTraversalGraph returns the graph
+//
+//	0 -KNOWS-> 1 -KNOWS-> 2 -KNOWS-> 3
+//	0 -LIKES-> 4 <-KNOWS- 3
+//
+// with a node 5 left on its own.
+func newTraversalGraph(t *testing.T) *Graph {
+	graph, err := NewGraph(nil)
+	require.NoError(t, err)
+	for i := 0; i < 6; i++ {
+		_, err := graph.CreateNode("Person", nil)
+		require.NoError(t, err)
+	}
+	for _, r := range []struct {
+		from, to int
+		relType  string
+	}{{0, 1, "KNOWS"}, {1, 2, "KNOWS"}, {2, 3, "KNOWS"}, {0, 4, "LIKES"}, {3, 4, "KNOWS"}} {
+		_, err := graph.CreateRelationship(r.from, r.to, r.relType, nil)
+		require.NoError(t, err)
+	}
+	return graph
+}
+
+// pathEnds returns the IDs of the last nodes of paths.
+func pathEnds(paths []Path) []int {
+	ids := []int{}
+	for _, path := range paths {
+		ids = append(ids, path.End().ID)
+	}
+	return ids
+}
+
+func TestBFSAndDFS(t *testing.T) {
+	graph := newTraversalGraph(t)
+	tests := []struct {
+		name     string
+		start    int
+		opts     TraversalOptions
+		bfs, dfs []int
+	}{
+		{"outgoing", 0, TraversalOptions{}, []int{0, 1, 4, 2, 3}, []int{0, 1, 2, 3, 4}},
+		{"incoming", 4, TraversalOptions{Direction: Incoming}, []int{4, 0, 3, 2, 1}, []int{4, 0, 3, 2, 1}},
+		{"types", 0, TraversalOptions{Types: []string{"LIKES"}}, []int{0, 4}, []int{0, 4}},
+		{"max depth", 0, TraversalOptions{MaxDepth: 2}, []int{0, 1, 4, 2}, []int{0, 1, 2, 4}},
+		{"both ways", 2, TraversalOptions{Direction: Both, MaxDepth: 1}, []int{2, 3, 1}, []int{2, 3, 1}},
+		{"isolated", 5, TraversalOptions{Direction: Both}, []int{5}, []int{5}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var bfs, dfs []Path
+			require.NoError(t, graph.BFS(tt.start, tt.opts, func(path Path) bool { bfs = append(bfs, path); return true }))
+			require.NoError(t, graph.DFS(tt.start, tt.opts, func(path Path) bool { dfs = append(dfs, path); return true }))
+			assert.Equal(t, tt.bfs, pathEnds(bfs))
+			assert.Equal(t, tt.dfs, pathEnds(dfs))
+		})
+	}
+
+	// Visiting stops when visit returns false
+	visited := 0
+	require.NoError(t, graph.BFS(0, TraversalOptions{}, func(path Path) bool { visited++; return visited < 2 }))
+	assert.Equal(t, 2, visited)
+	assert.Error(t, graph.DFS(9, TraversalOptions{}, func(Path) bool { return true }))
+}
+
+func TestShortestPath(t *testing.T) {
+	graph := newTraversalGraph(t)
+
+	path, err := graph.ShortestPath(0, 3, TraversalOptions{Direction: Both})
+	require.NoError(t, err)
+	require.NotNil(t, path)
+	assert.Equal(t, "(0:Person)-[:LIKES]->(4:Person)<-[:KNOWS]-(3:Person)", path.String())
+
+	path, err = graph.ShortestPath(0, 3, TraversalOptions{Types: []string{"KNOWS"}})
+	require.NoError(t, err)
+	require.NotNil(t, path)
+	assert.Equal(t, 3, path.Len())
+
+	path, err = graph.ShortestPath(0, 5, TraversalOptions{Direction: Both})
+	require.NoError(t, err)
+	assert.Nil(t, path, "no path")
+	_, err = graph.ShortestPath(0, 9, TraversalOptions{})
+	assert.Error(t, err)
+}
+
+func TestNeighborhood(t *testing.T) {
+	graph := newTraversalGraph(t)
+
+	paths, err := graph.Neighborhood(3, 2, TraversalOptions{Direction: Both})
+	require.NoError(t, err)
+	assert.Equal(t, []int{2, 4, 0, 1}, pathEnds(paths))
+	assert.Equal(t, []int{1, 1, 2, 2}, []int{paths[0].Len(), paths[1].Len(), paths[2].Len(), paths[3].Len()})
+
+	_, err = graph.Neighborhood(3, 0, TraversalOptions{})
+	assert.Error(t, err)
+}
@@ -7,26 +7,29 @@ import (
 	"crypto/tls"
 	"encoding/json"
 	"fmt"
-	"io/ioutil"
 	"log"
 	"net/http"
 	"os"
 	"strings"
 	"sync"
+	"time"
 
 	"manifold/internal/coderag"
 
 	elasticsearch "github.com/elastic/go-elasticsearch/v8"
+	"github.com/elastic/go-elasticsearch/v8/esutil"
 )
 
-// Updated FunctionInfo matching main.go's struct
+// FunctionInfo is the document of a function in the functions index.
 type FunctionInfo struct {
+	ID            string    `json:"id"`
 	Name          string    `json:"name"`
 	Comments      string    `json:"comments"`
 	Code          string    `json:"code"`
+	Summary       string    `json:"summary,omitempty"`
 	CallsCount    int       `json:"calls_count"`
 	CalledByCount int       `json:"called_by_count"`
-	Embedding     []float64 `json:"embedding"`
+	Embedding     []float32 `json:"embedding,omitempty"`
 }
 
 // Updated RelationshipInfo matching main.go's struct
@@ -43,28 +46,29 @@ type RelationshipInfo struct {
 	CalledByFilePaths []string `json:"called_by_file_paths"`
 }
 
-type EmbeddingRequest struct {
-	Input          []string `json:"input"`
-	Model          string   `json:"model"`
-	EncodingFormat string   `json:"encoding_format"`
-}
-
-type EmbeddingResponse struct {
-	Object string       `json:"object"`
-	Data   []Embedding  `json:"data"`
-	Model  string       `json:"model"`
-	Usage  UsageMetrics `json:"usage"`
-}
-
-type UsageMetrics struct {
-	PromptTokens int `json:"prompt_tokens"`
-	TotalTokens  int `json:"total_tokens"`
-}
+const (
+	functionsIndex  = "functions"
+	bulkWorkers     = 4
+	bulkFlushBytes  = 5 << 20
+	bulkAttempts    = 5
+	maxRetries      = 5
+	retryBaseDelay  = 500 * time.Millisecond
+	retryMaxDelay   = 30 * time.Second
+	indexingTimeout = 30 * time.Minute
+
+	searchSize    = 5
+	knnCandidates = 100
+	textBoost     = 0.3 // share of the BM25 score in the score of search hits
+	knnBoost      = 0.7 // share of the embedding similarity
+)
 
-type Embedding struct {
-	Object    string    `json:"object"`
-	Embedding []float64 `json:"embedding"`
-	Index     int       `json:"index"`
+// retryBackoff returns the exponential delay before a retry, capped at retryMaxDelay.
+func retryBackoff(attempt int) time.Duration {
+	delay := retryBaseDelay << (attempt - 1)
+	if delay <= 0 || delay > retryMaxDelay {
+		return retryMaxDelay
+	}
+	return delay
 }
 
 func main() {
@@ -122,7 +126,7 @@ func main() {
 		}
 
 		// Handle the user's prompt by querying Elasticsearch
-		relationshipInfo, err := queryElasticsearchForFunction(prompt, esClient)
+		relationshipInfo, err := queryElasticsearchForFunction(prompt, cfg, esClient)
 		if err != nil {
 			fmt.Printf("Error processing query: %v\n", err)
 			continue
@@ -133,7 +137,8 @@ func main() {
 	}
 }
 
-// initElasticsearchClient initializes the Elasticsearch client.
+// initElasticsearchClient initializes the Elasticsearch client. Requests rejected because the
+// cluster is overloaded are retried with exponential backoff.
 func initElasticsearchClient() (*elasticsearch.Client, error) {
 	cfg := elasticsearch.Config{
 		Addresses: []string{
@@ -145,61 +150,112 @@ func initElasticsearchClient() (*elasticsearch.Client, error) {
 		Transport: &http.Transport{
 			TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
 		},
+		RetryOnStatus: []int{http.StatusTooManyRequests, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout},
+		MaxRetries:    maxRetries,
+		RetryBackoff:  retryBackoff,
 	}
 	return elasticsearch.NewClient(cfg)
 }
 
-// generateEmbeddings makes a request to the local embeddings service to generate embeddings for a given input text.
-func generateEmbeddings(text string) ([]float64, error) {
-	url := "http://localhost:32184/embeddings"
-
-	// Create the embeddings request payload
-	payload := EmbeddingRequest{
-		Input:          []string{text},
-		Model:          "nomic-embed-text-v1.5",
-		EncodingFormat: "json",
+// functionsMapping returns the mapping of the functions index, with an embedding field of dims
+// dimensions compared by cosine similarity.
+func functionsMapping(dims int) map[string]interface{} {
+	return map[string]interface{}{
+		"mappings": map[string]interface{}{
+			"properties": map[string]interface{}{
+				"id": map[string]interface{}{"type": "keyword"},
+				"name": map[string]interface{}{
+					"type":   "text",
+					"fields": map[string]interface{}{"keyword": map[string]interface{}{"type": "keyword"}},
+				},
+				"comments":        map[string]interface{}{"type": "text"},
+				"code":            map[string]interface{}{"type": "text"},
+				"summary":         map[string]interface{}{"type": "text"},
+				"calls_count":     map[string]interface{}{"type": "integer"},
+				"called_by_count": map[string]interface{}{"type": "integer"},
+				"embedding": map[string]interface{}{
+					"type":       "dense_vector",
+					"dims":       dims,
+					"index":      true,
+					"similarity": "cosine",
+				},
+			},
+		},
 	}
+}
 
-	payloadBytes, err := json.Marshal(payload)
+// ensureFunctionsIndex creates the functions index with its mapping unless it exists. An existing
+// index must have been created for embeddings of the same size.
+func ensureFunctionsIndex(esClient *elasticsearch.Client, dims int) error {
+	res, err := esClient.Indices.Exists([]string{functionsIndex})
 	if err != nil {
-		return nil, fmt.Errorf("error marshaling request payload: %v", err)
+		return fmt.Errorf("error checking the %s index: %v", functionsIndex, err)
 	}
+	res.Body.Close()
 
-	req, err := http.NewRequest("POST", url, bytes.NewReader(payloadBytes))
-	if err != nil {
-		return nil, fmt.Errorf("error creating request: %v", err)
+	if res.StatusCode == http.StatusOK {
+		res, err := esClient.Indices.GetMapping(esClient.Indices.GetMapping.WithIndex(functionsIndex))
+		if err != nil {
+			return fmt.Errorf("error getting the %s mapping: %v", functionsIndex, err)
+		}
+		defer res.Body.Close()
+		if res.IsError() {
+			return fmt.Errorf("error response getting the %s mapping: %s", functionsIndex, res.String())
+		}
+
+		var mappings map[string]struct {
+			Mappings struct {
+				Properties struct {
+					Embedding struct {
+						Dims int `json:"dims"`
+					} `json:"embedding"`
+				} `json:"properties"`
+			} `json:"mappings"`
+		}
+		if err := json.NewDecoder(res.Body).Decode(&mappings); err != nil {
+			return fmt.Errorf("error parsing the %s mapping: %v", functionsIndex, err)
+		}
+		for name, mapping := range mappings {
+			if existing := mapping.Mappings.Properties.Embedding.Dims; existing != dims {
+				return fmt.Errorf("index %s has %d dimensional embeddings, the embedding model returns %d; delete the index to re-create it", name, existing, dims)
+			}
+		}
+		return nil
 	}
-	req.Header.Set("Content-Type", "application/json")
 
-	client := &http.Client{}
-	res, err := client.Do(req)
+	body, err := json.Marshal(functionsMapping(dims))
 	if err != nil {
-		return nil, fmt.Errorf("error making request to embeddings service: %v", err)
+		return fmt.Errorf("error marshaling the %s mapping: %v", functionsIndex, err)
+	}
+	res, err = esClient.Indices.Create(functionsIndex, esClient.Indices.Create.WithBody(bytes.NewReader(body)))
+	if err != nil {
+		return fmt.Errorf("error creating the %s index: %v", functionsIndex, err)
 	}
 	defer res.Body.Close()
-
-	if res.StatusCode != http.StatusOK {
-		body, _ := ioutil.ReadAll(res.Body)
-		return nil, fmt.Errorf("error response from embeddings service: %s", body)
+	if res.IsError() {
+		return fmt.Errorf("error response creating the %s index: %s", functionsIndex, res.String())
 	}
+	log.Printf("Created the %s index with %d dimensional embeddings", functionsIndex, dims)
+	return nil
+}
 
-	// Parse the response from the embeddings service
-	var embeddingResponse EmbeddingResponse
-	if err := json.NewDecoder(res.Body).Decode(&embeddingResponse); err != nil {
-		return nil, fmt.Errorf("error decoding response: %v", err)
+// embeddingDims returns the size of the embeddings of the functions, asking the embedding model
+// when none is embedded.
+func embeddingDims(cfg *coderag.Config, functions map[string]*coderag.FunctionInfo) (int, error) {
+	for _, function := range functions {
+		if len(function.Embedding) > 0 {
+			return len(function.Embedding), nil
+		}
 	}
-
-	// Extract the embeddings from the response
-	if len(embeddingResponse.Data) == 0 {
-		return nil, fmt.Errorf("no embeddings found in response")
+	embeddings, err := coderag.Embed(cfg, []string{"func main() {}"})
+	if err != nil {
+		return 0, fmt.Errorf("error probing the embedding model: %v", err)
 	}
-
-	vector := embeddingResponse.Data[0].Embedding
-
-	return vector, nil
+	return len(embeddings[0]), nil
 }
 
-// indexRepositoryToElasticsearch indexes the repository data into Elasticsearch with embeddings.
+// indexRepositoryToElasticsearch indexes the functions of the repository, with their embeddings,
+// into Elasticsearch.
 func indexRepositoryToElasticsearch(repoPath string, cfg *coderag.Config, esClient *elasticsearch.Client) error {
 	// Validate the Elasticsearch connection before proceeding
 	res, err := esClient.Info()
@@ -212,83 +268,180 @@ func indexRepositoryToElasticsearch(repoPath string, cfg *coderag.Config, esClie
 		return fmt.Errorf("error response from Elasticsearch: %s", res.String())
 	}
 
-	// Index repository data using the existing logic in coderag
+	// Index repository data, with embeddings, using the existing logic in coderag
 	index := coderag.NewCodeIndex()
 	if err := index.IndexRepository(repoPath, cfg); err != nil {
 		return err
 	}
 
-	// Convert and ingest each function data into Elasticsearch as it's processed
-	var wg sync.WaitGroup
+	dims, err := embeddingDims(cfg, index.Functions)
+	if err != nil {
+		return err
+	}
+	if err := ensureFunctionsIndex(esClient, dims); err != nil {
+		return err
+	}
+
+	documents := make([]FunctionInfo, 0, len(index.Functions))
 	for _, function := range index.Functions {
-		wg.Add(1)
-		go func(function coderag.FunctionInfo) {
-			defer wg.Done()
-			// Generate embeddings for the function's code
-			embedding, err := generateEmbeddings(function.Code)
-			if err != nil {
-				log.Printf("error generating embeddings: %v", err)
-				return
-			}
+		documents = append(documents, FunctionInfo{
+			ID:            function.ID,
+			Name:          function.Name,
+			Comments:      function.Comments,
+			Code:          function.Code,
+			Summary:       function.Summary,
+			CallsCount:    len(function.Calls),
+			CalledByCount: len(function.CalledBy),
+			Embedding:     function.Embedding,
+		})
+	}
 
-			// Add embeddings to the function data
-			functionWithEmbedding := FunctionInfo{
-				Name:          function.Name,
-				Comments:      function.Comments,
-				Code:          function.Code,
-				CallsCount:    len(function.Calls),
-				CalledByCount: len(function.CalledBy),
-				Embedding:     embedding,
-			}
+	ctx, cancel := context.WithTimeout(context.Background(), indexingTimeout)
+	defer cancel()
 
-			docJSON, err := json.Marshal(functionWithEmbedding)
-			if err != nil {
-				log.Printf("error marshaling function data: %v", err)
-				return
-			}
+	// Documents rejected because the cluster is overloaded are sent again after a backoff
+	for attempt := 1; len(documents) > 0; attempt++ {
+		rejected, err := bulkIndexFunctions(ctx, esClient, documents)
+		if err != nil {
+			return err
+		}
+		if len(rejected) == 0 {
+			break
+		}
+		if attempt == bulkAttempts {
+			return fmt.Errorf("%d functions rejected after %d attempts", len(rejected), attempt)
+		}
+		delay := retryBackoff(attempt)
+		log.Printf("%d functions rejected, retrying in %s", len(rejected), delay)
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+		documents = rejected
+	}
+	return nil
+}
 
-			// Ingest each document into Elasticsearch immediately
-			res, err := esClient.Index(
-				"functions",
-				bytes.NewReader(docJSON),
-			)
-			if err != nil {
-				log.Printf("error indexing function data: %v", err)
-				return
-			}
-			defer res.Body.Close()
+// bulkIndexFunctions indexes the documents with the Bulk API, in batches of up to bulkFlushBytes
+// sent by bulkWorkers workers. Functions are indexed by ID, so indexing the repository again
+// updates them. It returns the documents rejected with 429 Too Many Requests, which can be retried,
+// and fails when other documents are rejected.
+func bulkIndexFunctions(ctx context.Context, esClient *elasticsearch.Client, documents []FunctionInfo) ([]FunctionInfo, error) {
+	indexer, err := esutil.NewBulkIndexer(esutil.BulkIndexerConfig{
+		Client:     esClient,
+		Index:      functionsIndex,
+		NumWorkers: bulkWorkers,
+		FlushBytes: bulkFlushBytes,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error creating the bulk indexer: %v", err)
+	}
 
-			if res.IsError() {
-				log.Printf("error response when indexing document: %s", res.String())
-				return
-			}
-		}(*function)
+	var mu sync.Mutex
+	var rejected []FunctionInfo
+	var failures []string
+	for _, document := range documents {
+		docJSON, err := json.Marshal(document)
+		if err != nil {
+			return nil, fmt.Errorf("error marshaling function %s: %v", document.ID, err)
+		}
+		err = indexer.Add(ctx, esutil.BulkIndexerItem{
+			Action:     "index",
+			DocumentID: document.ID,
+			Body:       bytes.NewReader(docJSON),
+			OnFailure: func(ctx context.Context, item esutil.BulkIndexerItem, res esutil.BulkIndexerResponseItem, err error) {
+				mu.Lock()
+				defer mu.Unlock()
+				switch {
+				case err != nil:
+					failures = append(failures, fmt.Sprintf("%s: %v", document.ID, err))
+				case res.Status == http.StatusTooManyRequests:
+					rejected = append(rejected, document)
+				default:
+					failures = append(failures, fmt.Sprintf("%s: %s: %s", document.ID, res.Error.Type, res.Error.Reason))
+				}
+			},
+		})
+		if err != nil {
+			return nil, fmt.Errorf("error adding function %s: %v", document.ID, err)
+		}
+	}
+	if err := indexer.Close(ctx); err != nil {
+		return nil, fmt.Errorf("error closing the bulk indexer: %v", err)
 	}
-	wg.Wait()
 
-	return nil
+	stats := indexer.Stats()
+	log.Printf("Indexed %d functions in %d bulk requests", stats.NumIndexed, stats.NumRequests)
+	if len(failures) > 0 {
+		for _, failure := range failures {
+			log.Printf("Failed to index %s", failure)
+		}
+		return nil, fmt.Errorf("%d functions failed to index", len(failures))
+	}
+	return rejected, nil
 }
 
-// queryElasticsearchForFunction queries Elasticsearch for function details based on the user's prompt.
-func queryElasticsearchForFunction(prompt string, esClient *elasticsearch.Client) (*RelationshipInfo, error) {
-	query := fmt.Sprintf(`{
-		"query": {
-			"match": {
-				"name": "%s"
-			}
+// searchQuery returns the body of a search for the functions matching the prompt: BM25 on the
+// name, comments and summary, combined with a kNN search of the function embeddings when the
+// prompt embedding is known. The scores of both add up, so functions matching the words and the
+// meaning of the prompt rank first.
+func searchQuery(prompt string, embedding []float32) map[string]interface{} {
+	query := map[string]interface{}{
+		"size": searchSize,
+		"query": map[string]interface{}{
+			"multi_match": map[string]interface{}{
+				"query":  prompt,
+				"fields": []string{"name^3", "comments", "summary"},
+				"boost":  textBoost,
+			},
+		},
+		"_source": map[string]interface{}{"excludes": []string{"embedding"}},
+	}
+	if len(embedding) > 0 {
+		query["knn"] = map[string]interface{}{
+			"field":          "embedding",
+			"query_vector":   embedding,
+			"k":              searchSize,
+			"num_candidates": knnCandidates,
+			"boost":          knnBoost,
 		}
-	}`, prompt)
+	}
+	return query
+}
+
+// queryElasticsearchForFunction queries Elasticsearch for the function best matching the user's
+// prompt, by name or by meaning. Without an embedding for the prompt, only the text is matched.
+func queryElasticsearchForFunction(prompt string, cfg *coderag.Config, esClient *elasticsearch.Client) (*RelationshipInfo, error) {
+	var embedding []float32
+	if cfg.EmbeddingsEndpoint != "" {
+		embeddings, err := coderag.Embed(cfg, []string{prompt})
+		if err != nil {
+			log.Printf("Searching by text only, failed to embed the query: %v", err)
+		} else {
+			embedding = embeddings[0]
+		}
+	}
+
+	query, err := json.Marshal(searchQuery(prompt, embedding))
+	if err != nil {
+		return nil, fmt.Errorf("error marshaling query: %v", err)
+	}
 
 	res, err := esClient.Search(
 		esClient.Search.WithContext(context.Background()),
-		esClient.Search.WithIndex("functions"),
-		esClient.Search.WithBody(bytes.NewReader([]byte(query))),
+		esClient.Search.WithIndex(functionsIndex),
+		esClient.Search.WithBody(bytes.NewReader(query)),
 	)
 	if err != nil {
 		return nil, fmt.Errorf("error querying Elasticsearch: %v", err)
 	}
 	defer res.Body.Close()
 
+	if res.IsError() {
+		return nil, fmt.Errorf("error response from Elasticsearch: %s", res.String())
+	}
+
 	// Parse the search results
 	var searchResults map[string]interface{}
 	if err := json.NewDecoder(res.Body).Decode(&searchResults); err != nil {
@@ -312,8 +465,9 @@ func queryElasticsearchForFunction(prompt string, esClient *elasticsearch.Client
 		Code:          source["code"].(string),
 		CallsCount:    int(source["calls_count"].(float64)),
 		CalledByCount: int(source["called_by_count"].(float64)),
-		// Populate other fields as necessary, e.g., Calls, CalledBy, Summary, etc.
+		// Populate other fields as necessary, e.g., Calls, CalledBy, etc.
 	}
+	functionInfo.Summary, _ = source["summary"].(string)
 
 	return &functionInfo, nil
 }
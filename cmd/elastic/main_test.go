@@ -0,0 +1,215 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"manifold/internal/coderag"
+
+	elasticsearch "github.com/elastic/go-elasticsearch/v8"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeElasticsearch serves the parts of the Elasticsearch API used to index and search functions.
+type fakeElasticsearch struct {
+	mu       sync.Mutex
+	dims     int                    // dims of the existing functions index, no index when 0
+	created  map[string]interface{} // body of the index creation
+	bulkIDs  [][]string             // document IDs of every bulk request
+	statuses map[string]int         // bulk status of a document ID, 201 when unset
+	searches []map[string]interface{}
+	hits     []map[string]interface{}
+}
+
+func (f *fakeElasticsearch) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	w.Header().Set("X-Elastic-Product", "Elasticsearch")
+	w.Header().Set("Content-Type", "application/json")
+	switch {
+	case r.Method == http.MethodHead && r.URL.Path == "/"+functionsIndex:
+		if f.dims == 0 {
+			w.WriteHeader(http.StatusNotFound)
+		}
+
+	case r.Method == http.MethodGet && r.URL.Path == "/"+functionsIndex+"/_mapping":
+		fmt.Fprintf(w, `{"%s": {"mappings": {"properties": {"embedding": {"type": "dense_vector", "dims": %d}}}}}`, functionsIndex, f.dims)
+
+	case r.Method == http.MethodPut && r.URL.Path == "/"+functionsIndex:
+		if err := json.NewDecoder(r.Body).Decode(&f.created); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		fmt.Fprint(w, `{"acknowledged": true}`)
+
+	case r.Method == http.MethodPost && strings.HasSuffix(r.URL.Path, "/_bulk"):
+		f.bulk(w, r.Body)
+
+	case r.Method == http.MethodPost && r.URL.Path == "/"+functionsIndex+"/_search":
+		var search map[string]interface{}
+		if err := json.NewDecoder(r.Body).Decode(&search); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		f.searches = append(f.searches, search)
+		json.NewEncoder(w).Encode(map[string]interface{}{"hits": map[string]interface{}{"hits": f.hits}})
+
+	default:
+		http.Error(w, "unexpected request "+r.Method+" "+r.URL.Path, http.StatusNotFound)
+	}
+}
+
+// bulk answers a bulk request with the status of every document.
+func (f *fakeElasticsearch) bulk(w http.ResponseWriter, body io.Reader) {
+	var ids []string
+	var items []map[string]interface{}
+	scanner := bufio.NewScanner(body)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		var action struct {
+			Index struct {
+				ID string `json:"_id"`
+			} `json:"index"`
+		}
+		if err := json.Unmarshal(scanner.Bytes(), &action); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		scanner.Scan() // the document
+		id := action.Index.ID
+		ids = append(ids, id)
+
+		status, ok := f.statuses[id]
+		if !ok {
+			status = http.StatusCreated
+		}
+		item := map[string]interface{}{"_index": functionsIndex, "_id": id, "status": status}
+		if status >= 300 {
+			item["error"] = map[string]interface{}{"type": "rejected", "reason": "status " + fmt.Sprint(status)}
+		}
+		items = append(items, map[string]interface{}{"index": item})
+	}
+	f.bulkIDs = append(f.bulkIDs, ids)
+	json.NewEncoder(w).Encode(map[string]interface{}{"took": 1, "errors": len(f.statuses) > 0, "items": items})
+}
+
+// newFakeElasticsearch starts a fake Elasticsearch and returns a client for it.
+func newFakeElasticsearch(t *testing.T) (*fakeElasticsearch, *elasticsearch.Client) {
+	fake := &fakeElasticsearch{}
+	server := httptest.NewServer(fake)
+	t.Cleanup(server.Close)
+
+	esClient, err := elasticsearch.NewClient(elasticsearch.Config{Addresses: []string{server.URL}, DisableRetry: true})
+	require.NoError(t, err)
+	return fake, esClient
+}
+
+func TestRetryBackoff(t *testing.T) {
+	assert.Equal(t, retryBaseDelay, retryBackoff(1))
+	assert.Equal(t, 4*retryBaseDelay, retryBackoff(3))
+	assert.Equal(t, retryMaxDelay, retryBackoff(10))
+	assert.Equal(t, retryMaxDelay, retryBackoff(100))
+}
+
+func TestEnsureFunctionsIndex(t *testing.T) {
+	fake, esClient := newFakeElasticsearch(t)
+
+	// A missing index is created with its embedding mapping
+	require.NoError(t, ensureFunctionsIndex(esClient, 768))
+	embedding := fake.created["mappings"].(map[string]interface{})["properties"].(map[string]interface{})["embedding"].(map[string]interface{})
+	assert.Equal(t, "dense_vector", embedding["type"])
+	assert.Equal(t, float64(768), embedding["dims"])
+	assert.Equal(t, "cosine", embedding["similarity"])
+
+	// An existing index is kept when its embeddings have the same size
+	fake.created, fake.dims = nil, 768
+	require.NoError(t, ensureFunctionsIndex(esClient, 768))
+	assert.Nil(t, fake.created)
+
+	err := ensureFunctionsIndex(esClient, 384)
+	assert.ErrorContains(t, err, "768 dimensional embeddings, the embedding model returns 384")
+}
+
+func TestBulkIndexFunctions(t *testing.T) {
+	fake, esClient := newFakeElasticsearch(t)
+	documents := []FunctionInfo{
+		{ID: "app.Run", Name: "Run", Embedding: []float32{1, 0}},
+		{ID: "store.Open", Name: "Open", Embedding: []float32{0, 1}},
+		{ID: "store.load", Name: "load"},
+	}
+
+	rejected, err := bulkIndexFunctions(context.Background(), esClient, documents)
+	require.NoError(t, err)
+	assert.Empty(t, rejected)
+	require.Len(t, fake.bulkIDs, 1)
+	assert.ElementsMatch(t, []string{"app.Run", "store.Open", "store.load"}, fake.bulkIDs[0])
+
+	// Documents rejected by an overloaded cluster are returned to be sent again
+	fake.statuses = map[string]int{"store.Open": http.StatusTooManyRequests}
+	rejected, err = bulkIndexFunctions(context.Background(), esClient, documents)
+	require.NoError(t, err)
+	require.Len(t, rejected, 1)
+	assert.Equal(t, "store.Open", rejected[0].ID)
+
+	// Other rejections fail the indexing
+	fake.statuses = map[string]int{"store.load": http.StatusBadRequest}
+	_, err = bulkIndexFunctions(context.Background(), esClient, documents)
+	assert.ErrorContains(t, err, "1 functions failed to index")
+}
+
+func TestSearchQuery(t *testing.T) {
+	query := searchQuery("open the store", nil)
+	assert.NotContains(t, query, "knn")
+	assert.Equal(t, textBoost, query["query"].(map[string]interface{})["multi_match"].(map[string]interface{})["boost"])
+
+	query = searchQuery("open the store", []float32{0.5, 0.5})
+	knn := query["knn"].(map[string]interface{})
+	assert.Equal(t, "embedding", knn["field"])
+	assert.Equal(t, []float32{0.5, 0.5}, knn["query_vector"])
+	assert.Equal(t, knnBoost, knn["boost"])
+}
+
+func TestQueryElasticsearchForFunction(t *testing.T) {
+	fake, esClient := newFakeElasticsearch(t)
+	fake.hits = []map[string]interface{}{{"_source": map[string]interface{}{
+		"name": "Open", "comments": "Open opens the store.", "code": "func Open() {}", "summary": "Opens the store",
+		"calls_count": 1, "called_by_count": 2,
+	}}}
+
+	embeddings := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"data": [{"embedding": [0.6, 0.8], "index": 0}]}`)
+	}))
+	t.Cleanup(embeddings.Close)
+
+	// The prompt is matched by meaning with its embedding and by its words
+	info, err := queryElasticsearchForFunction("how is the store opened?", &coderag.Config{EmbeddingsEndpoint: embeddings.URL}, esClient)
+	require.NoError(t, err)
+	assert.Equal(t, "Open", info.Name)
+	assert.Equal(t, "Opens the store", info.Summary)
+	assert.Equal(t, 2, info.CalledByCount)
+	require.Len(t, fake.searches, 1)
+	knn := fake.searches[0]["knn"].(map[string]interface{})
+	assert.Equal(t, []interface{}{0.6, 0.8}, knn["query_vector"])
+	assert.Contains(t, fake.searches[0], "query")
+
+	// Without an embedding the search falls back on the words
+	embeddings.Close()
+	_, err = queryElasticsearchForFunction("how is the store opened?", &coderag.Config{EmbeddingsEndpoint: embeddings.URL}, esClient)
+	require.NoError(t, err)
+	require.Len(t, fake.searches, 2)
+	assert.NotContains(t, fake.searches[1], "knn")
+
+	fake.hits = nil
+	_, err = queryElasticsearchForFunction("unknown", &coderag.Config{}, esClient)
+	assert.ErrorContains(t, err, "no function found")
+}
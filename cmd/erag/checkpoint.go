@@ -0,0 +1,219 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"manifold/internal/sqlitevec"
+)
+
+// progressInterval is how often the progress of a load is logged.
+const progressInterval = 10 * time.Second
+
+// createCheckpointTables creates the tables tracking the ingestion, in new and existing databases:
+// the rows of each parquet file ingested so far, and the hashes of the passages ingested.
+func (sqldb *SQLiteDB) createCheckpointTables() error {
+	_, err := sqldb.db.Exec(`
+		CREATE TABLE IF NOT EXISTS ingest_checkpoints (
+			file TEXT PRIMARY KEY,
+			rows_done INTEGER NOT NULL,
+			total_rows INTEGER NOT NULL,
+			updated_at TIMESTAMP NOT NULL
+		);
+		CREATE TABLE IF NOT EXISTS ingested_passages (
+			hash TEXT PRIMARY KEY,
+			chat_id INTEGER NOT NULL
+		);
+	`)
+	if err != nil {
+		return fmt.Errorf("failed to create checkpoint tables: %v", err)
+	}
+	return nil
+}
+
+// passageHash returns the hex SHA-256 of a passage.
+func passageHash(passage string) string {
+	sum := sha256.Sum256([]byte(passage))
+	return hex.EncodeToString(sum[:])
+}
+
+// Checkpoint returns the number of rows of the file ingested so far, 0 for a new file.
+func (sqldb *SQLiteDB) Checkpoint(ctx context.Context, file string) (int, error) {
+	var rowsDone int
+	err := sqldb.db.QueryRowContext(ctx, `SELECT rows_done FROM ingest_checkpoints WHERE file = ?`, file).Scan(&rowsDone)
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, fmt.Errorf("failed to read checkpoint of %s: %v", file, err)
+	}
+	return rowsDone, nil
+}
+
+// SaveCheckpoint records that the first rowsDone rows of the file are ingested.
+func (sqldb *SQLiteDB) SaveCheckpoint(ctx context.Context, file string, rowsDone, totalRows int) error {
+	_, err := sqldb.db.ExecContext(ctx, `
+		INSERT INTO ingest_checkpoints (file, rows_done, total_rows, updated_at)
+		VALUES (?, ?, ?, ?)
+		ON CONFLICT(file) DO UPDATE SET rows_done = excluded.rows_done, total_rows = excluded.total_rows, updated_at = excluded.updated_at;
+	`, file, rowsDone, totalRows, time.Now())
+	if err != nil {
+		return fmt.Errorf("failed to save checkpoint of %s: %v", file, err)
+	}
+	return nil
+}
+
+// IngestedHashes returns the hashes, among the given ones, of passages already ingested.
+func (sqldb *SQLiteDB) IngestedHashes(ctx context.Context, hashes []string) (map[string]bool, error) {
+	ingested := make(map[string]bool)
+	for _, hash := range hashes {
+		var chatID int64
+		err := sqldb.db.QueryRowContext(ctx, `SELECT chat_id FROM ingested_passages WHERE hash = ?`, hash).Scan(&chatID)
+		if errors.Is(err, sql.ErrNoRows) {
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to look up passage: %v", err)
+		}
+		ingested[hash] = true
+	}
+	return ingested, nil
+}
+
+// InsertPassage inserts a passage into the FTS5, chats and vec_items tables and records its hash, in
+// a single transaction so an interrupted load leaves no partial passage behind. It reports false
+// when a passage with the same hash was ingested first.
+func (sqldb *SQLiteDB) InsertPassage(ctx context.Context, passage, hash string, embedding []float32) (inserted bool, err error) {
+	serializedEmbedding := sqlitevec.SerializeFloat32(embedding)
+
+	tx, err := sqldb.db.BeginTx(ctx, nil)
+	if err != nil {
+		return false, fmt.Errorf("failed to begin transaction: %v", err)
+	}
+	defer func() {
+		if err != nil || !inserted {
+			tx.Rollback()
+		}
+	}()
+
+	result, err := tx.ExecContext(ctx, `
+		INSERT INTO chats (prompt, response, model_name, embedding)
+		VALUES (?, '', 'assistant', ?);
+	`, passage, serializedEmbedding)
+	if err != nil {
+		return false, fmt.Errorf("failed to insert into chats table: %v", err)
+	}
+	chatID, err := result.LastInsertId()
+	if err != nil {
+		return false, fmt.Errorf("failed to retrieve last insert ID: %v", err)
+	}
+
+	// Passages are unique by hash, a duplicate loaded concurrently is dropped with the transaction
+	result, err = tx.ExecContext(ctx, `INSERT OR IGNORE INTO ingested_passages (hash, chat_id) VALUES (?, ?);`, hash, chatID)
+	if err != nil {
+		return false, fmt.Errorf("failed to record passage: %v", err)
+	}
+	if n, err := result.RowsAffected(); err != nil || n == 0 {
+		return false, err
+	}
+
+	if _, err = tx.ExecContext(ctx, `INSERT INTO chat_fts(prompt, response, modelName) VALUES (?, '', 'assistant');`, passage); err != nil {
+		return false, fmt.Errorf("failed to insert into FTS5: %v", err)
+	}
+	if err = vecTable.Upsert(ctx, tx, chatID, embedding); err != nil {
+		return false, err
+	}
+
+	if err = tx.Commit(); err != nil {
+		return false, fmt.Errorf("failed to commit transaction: %v", err)
+	}
+	return true, nil
+}
+
+// checkpointer advances the checkpoint of a file as its rows are ingested. Rows complete out of
+// order, so the checkpoint is the end of the rows ingested without a gap; a failed row holds it
+// back, and the next load starts from it again.
+type checkpointer struct {
+	db    *SQLiteDB
+	file  string
+	total int
+
+	mu   sync.Mutex
+	next int          // the first row not ingested
+	done map[int]bool // rows ingested after next
+}
+
+func newCheckpointer(db *SQLiteDB, file string, offset, total int) *checkpointer {
+	return &checkpointer{db: db, file: file, total: total, next: offset, done: make(map[int]bool)}
+}
+
+// complete records that the rows are ingested, and saves the checkpoint when it advances.
+func (c *checkpointer) complete(ctx context.Context, rows ...int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for _, row := range rows {
+		c.done[row] = true
+	}
+	start := c.next
+	for c.done[c.next] {
+		delete(c.done, c.next)
+		c.next++
+	}
+	if c.next == start {
+		return
+	}
+	if err := c.db.SaveCheckpoint(ctx, c.file, c.next, c.total); err != nil {
+		log.Printf("Failed to save checkpoint: %v", err)
+	}
+}
+
+// progress counts the rows of a file loaded so far.
+type progress struct {
+	file      string
+	total     int
+	start     time.Time
+	processed atomic.Int64 // rows read, including those of the previous loads
+	inserted  atomic.Int64
+	skipped   atomic.Int64 // passages already ingested
+	failed    atomic.Int64
+}
+
+func newProgress(file string, total, offset int) *progress {
+	p := &progress{file: file, total: total, start: time.Now()}
+	p.processed.Store(int64(offset))
+	return p
+}
+
+func (p *progress) log() {
+	processed := p.processed.Load()
+	percent := 100.0
+	if p.total > 0 {
+		percent = float64(processed) / float64(p.total) * 100
+	}
+	log.Printf("%s: %d/%d rows (%.1f%%), %d inserted, %d skipped, %d failed, %s elapsed",
+		p.file, processed, p.total, percent, p.inserted.Load(), p.skipped.Load(), p.failed.Load(), time.Since(p.start).Round(time.Second))
+}
+
+// report logs the progress every progressInterval until stop is closed, and a last time then.
+func (p *progress) report(stop <-chan struct{}) {
+	ticker := time.NewTicker(progressInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			p.log()
+		case <-stop:
+			p.log()
+			return
+		}
+	}
+}
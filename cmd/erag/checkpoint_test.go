@@ -0,0 +1,149 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/xitongsys/parquet-go-source/local"
+	"github.com/xitongsys/parquet-go/writer"
+
+	"manifold/internal/ingest"
+)
+
+// passageRow is a row of a test dataset.
+type passageRow struct {
+	Passage string `parquet:"name=Passage, type=BYTE_ARRAY, convertedtype=UTF8"`
+}
+
+// writeParquet writes the passages to a parquet file and returns its path.
+func writeParquet(t *testing.T, passages ...string) string {
+	path := filepath.Join(t.TempDir(), "passages.parquet")
+	fw, err := local.NewLocalFileWriter(path)
+	require.NoError(t, err)
+	pw, err := writer.NewParquetWriter(fw, new(passageRow), 1)
+	require.NoError(t, err)
+	for _, passage := range passages {
+		require.NoError(t, pw.Write(passageRow{Passage: passage}))
+	}
+	require.NoError(t, pw.WriteStop())
+	require.NoError(t, fw.Close())
+	return path
+}
+
+// useEmbedder makes the loads of a test embed with fail, failing the passages it returns true for.
+func useEmbedder(t *testing.T, fail func(string) bool) {
+	saved := embedder
+	embedder = ingest.EmbedderFunc(func(ctx context.Context, texts []string) ([][]float32, error) {
+		embeddings := make([][]float32, len(texts))
+		for i, text := range texts {
+			if fail(text) {
+				return nil, errors.New("embedding service unavailable")
+			}
+			embeddings[i] = make([]float32, embeddingDim)
+			embeddings[i][len(text)%embeddingDim] = 1
+		}
+		return embeddings, nil
+	})
+	t.Cleanup(func() { embedder = saved })
+}
+
+// openTestDB creates a database in a temporary directory. The FTS5 table needs the sqlite_fts5
+// build tag, like the loader itself.
+func openTestDB(t *testing.T) *SQLiteDB {
+	sqldb, err := initializeDatabase(t.TempDir())
+	if err != nil && strings.Contains(err.Error(), "no such module: fts5") {
+		t.Skip("needs -tags sqlite_fts5")
+	}
+	require.NoError(t, err)
+	t.Cleanup(func() { sqldb.db.Close() })
+	return sqldb
+}
+
+// count returns the number of rows of a table.
+func count(t *testing.T, sqldb *SQLiteDB, table string) int {
+	var n int
+	require.NoError(t, sqldb.db.QueryRow("SELECT COUNT(*) FROM "+table).Scan(&n))
+	return n
+}
+
+func TestInsertPassageDuplicate(t *testing.T) {
+	sqldb := openTestDB(t)
+	ctx := context.Background()
+	embedding := make([]float32, embeddingDim)
+	embedding[0] = 1
+
+	inserted, err := sqldb.InsertPassage(ctx, "The cat sat.", passageHash("The cat sat."), embedding)
+	require.NoError(t, err)
+	assert.True(t, inserted)
+
+	// A passage with the same hash leaves nothing behind
+	inserted, err = sqldb.InsertPassage(ctx, "The cat sat.", passageHash("The cat sat."), embedding)
+	require.NoError(t, err)
+	assert.False(t, inserted)
+	for _, table := range []string{"chats", "chat_fts", "vec_items", "ingested_passages"} {
+		assert.Equal(t, 1, count(t, sqldb, table), table)
+	}
+
+	ingested, err := sqldb.IngestedHashes(ctx, []string{passageHash("The cat sat."), passageHash("The dog ran.")})
+	require.NoError(t, err)
+	assert.Equal(t, map[string]bool{passageHash("The cat sat."): true}, ingested)
+}
+
+func TestCheckpointer(t *testing.T) {
+	db, err := sql.Open("sqlite3", filepath.Join(t.TempDir(), "checkpoints.db"))
+	require.NoError(t, err)
+	t.Cleanup(func() { db.Close() })
+	sqldb := &SQLiteDB{db: db}
+	require.NoError(t, sqldb.createCheckpointTables())
+
+	ctx := context.Background()
+	checkpoints := newCheckpointer(sqldb, "passages.parquet", 2, 6)
+
+	// Rows after a gap don't advance the checkpoint until the gap is filled
+	checkpoints.complete(ctx, 3, 4)
+	offset, err := sqldb.Checkpoint(ctx, "passages.parquet")
+	require.NoError(t, err)
+	assert.Equal(t, 0, offset)
+
+	checkpoints.complete(ctx, 2)
+	offset, err = sqldb.Checkpoint(ctx, "passages.parquet")
+	require.NoError(t, err)
+	assert.Equal(t, 5, offset)
+}
+
+func TestLoadParquetDataResumes(t *testing.T) {
+	sqldb := openTestDB(t)
+	ctx := context.Background()
+	path := writeParquet(t, "one", "two", "three", "four", "two", "five")
+	opts := &options{Column: "Passage", ChunkSize: 1, Workers: 1, BatchSize: 2}
+	file, err := filepath.Abs(path)
+	require.NoError(t, err)
+
+	// The failed row holds the checkpoint back, the rows after it are ingested anyway
+	useEmbedder(t, func(text string) bool { return strings.HasPrefix(text, "three") })
+	require.NoError(t, sqldb.LoadParquetData(ctx, path, opts))
+	offset, err := sqldb.Checkpoint(ctx, file)
+	require.NoError(t, err)
+	assert.Equal(t, 2, offset)
+	assert.Equal(t, 4, count(t, sqldb, "chats"), "the duplicate passage is ingested once")
+
+	// The next load starts from the checkpoint and skips the passages already ingested
+	useEmbedder(t, func(string) bool { return false })
+	require.NoError(t, sqldb.LoadParquetData(ctx, path, opts))
+	offset, err = sqldb.Checkpoint(ctx, file)
+	require.NoError(t, err)
+	assert.Equal(t, 6, offset)
+	assert.Equal(t, 5, count(t, sqldb, "chats"))
+	assert.Equal(t, 5, count(t, sqldb, "vec_items"))
+
+	// A file loaded in full is not read again
+	useEmbedder(t, func(string) bool { return true })
+	require.NoError(t, sqldb.LoadParquetData(ctx, path, opts))
+	assert.Equal(t, 5, count(t, sqldb, "chats"))
+}
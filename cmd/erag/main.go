@@ -1,97 +1,171 @@
 package main
 
 import (
-	"bytes"
 	"context"
 	"database/sql"
-	"encoding/json"
+	"errors"
+	"flag"
 	"fmt"
-	"io"
 	"log"
-	"math/rand"
-	"net/http"
 	"os"
 	"path/filepath"
-	"sync"
-	"time"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
 
-	sqlite_vec "github.com/asg017/sqlite-vec-go-bindings/cgo"
 	_ "github.com/mattn/go-sqlite3"
 	"github.com/xitongsys/parquet-go-source/local"
 	"github.com/xitongsys/parquet-go/reader"
+	"gopkg.in/yaml.v2"
+
+	"manifold/internal/ingest"
+	"manifold/internal/sqlitevec"
 )
 
 // Constants
 const (
-	embeddingDim         = 768         // Dimension of the embeddings
-	vecTableName         = "vec_items" // Name of the vector table
-	vecTableCreationStmt = `CREATE VIRTUAL TABLE IF NOT EXISTS vec_items USING vec0(embedding float[768]);`
+	embeddingDim = 768 // Dimension of the embeddings
 )
 
-// Chat represents an entry in the regular "chats" table.
-type Chat struct {
-	ID        int64  `json:"id"`
-	Prompt    string `json:"prompt"`
-	Response  string `json:"response"`
-	ModelName string `json:"modelName"`
-}
-
-// ChatEntry represents an entry in the FTS5 table with both prompt and response.
-type ChatEntry struct {
-	Prompt    string `json:"prompt"`   // Corresponds to the passage from Parquet
-	Response  string `json:"response"` // Initialized as empty
-	ModelName string `json:"modelName"`
-}
+// vecTable holds the embeddings of the chats, by chat id.
+var vecTable = sqlitevec.Table{Name: "vec_items", Dimensions: embeddingDim}
 
 // SQLiteDB structure to hold the *sql.DB object
 type SQLiteDB struct {
 	db *sql.DB
 }
 
-// EmbeddingRequest represents the JSON structure sent to the embeddings endpoint.
-type EmbeddingRequest struct {
-	Input []string `json:"input"`
+// embedder embeds passages and queries, set from the options.
+var embedder ingest.Embedder
+
+// options configure the loader. They are read from the YAML file given with -config, when there is
+// one, and the flags set on the command line override it.
+type options struct {
+	DataPath      string `yaml:"data_path"`      // directory of eternaldata.db
+	Parquet       string `yaml:"parquet"`        // parquet file, or glob of the parts of a dataset
+	Column        string `yaml:"column"`         // column of the passages
+	ChunkSize     int    `yaml:"chunk_size"`     // passages embedded per request
+	Workers       int    `yaml:"workers"`        // concurrent embedding requests
+	BatchSize     int    `yaml:"batch_size"`     // rows read from the parquet file at a time
+	EmbeddingsURL string `yaml:"embeddings_url"` // OpenAI compatible embeddings endpoint
 }
 
-// Embedding represents a single embedding data point.
-type Embedding struct {
-	Object    string    `json:"object"`
-	Embedding []float64 `json:"embedding"`
-	Index     int       `json:"index"`
+// envOr returns the environment variable, or fallback when it isn't set.
+func envOr(name, fallback string) string {
+	if value := os.Getenv(name); value != "" {
+		return value
+	}
+	return fallback
 }
 
-// UsageMetrics details the token usage of the Embeddings API request.
-type UsageMetrics struct {
-	PromptTokens int `json:"prompt_tokens"`
-	TotalTokens  int `json:"total_tokens"`
-}
+// parseOptions reads the options from the config file and the command line. Without a parquet
+// path, every parquet file of the data path is loaded.
+func parseOptions(args []string) (*options, error) {
+	defaults := options{
+		DataPath:      envOr("DATA_PATH", filepath.Join(os.Getenv("HOME"), ".manifold", "datasets")),
+		Column:        "Passage",
+		ChunkSize:     10,
+		Workers:       3,
+		BatchSize:     1000,
+		EmbeddingsURL: envOr("EMBEDDINGS_URL", "http://localhost:32184/embeddings"),
+	}
+
+	fs := flag.NewFlagSet("erag", flag.ContinueOnError)
+	configPath := fs.String("config", "", "YAML file of the options, overridden by the flags set")
+	dataPath := fs.String("data", defaults.DataPath, "Directory of the SQLite database, DATA_PATH")
+	parquet := fs.String("parquet", "", "Parquet file or glob of parquet parts, all parquet files of the data directory when empty")
+	column := fs.String("column", defaults.Column, "Column of the passages")
+	chunkSize := fs.Int("chunk-size", defaults.ChunkSize, "Passages embedded per request")
+	workers := fs.Int("workers", defaults.Workers, "Concurrent embedding requests")
+	batchSize := fs.Int("batch-size", defaults.BatchSize, "Rows read from the parquet file at a time")
+	embeddings := fs.String("embeddings", defaults.EmbeddingsURL, "Embeddings endpoint, EMBEDDINGS_URL")
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
 
-// EmbeddingResponse represents the expected JSON structure received from the embeddings endpoint.
-type EmbeddingResponse struct {
-	Object string       `json:"object"`
-	Data   []Embedding  `json:"data"`
-	Model  string       `json:"model"`
-	Usage  UsageMetrics `json:"usage"`
+	opts := defaults
+	if *configPath != "" {
+		data, err := os.ReadFile(*configPath)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read config file: %v", err)
+		}
+		if err := yaml.Unmarshal(data, &opts); err != nil {
+			return nil, fmt.Errorf("failed to parse config file: %v", err)
+		}
+	}
+	fs.Visit(func(f *flag.Flag) {
+		switch f.Name {
+		case "data":
+			opts.DataPath = *dataPath
+		case "parquet":
+			opts.Parquet = *parquet
+		case "column":
+			opts.Column = *column
+		case "chunk-size":
+			opts.ChunkSize = *chunkSize
+		case "workers":
+			opts.Workers = *workers
+		case "batch-size":
+			opts.BatchSize = *batchSize
+		case "embeddings":
+			opts.EmbeddingsURL = *embeddings
+		}
+	})
+
+	if opts.Parquet == "" {
+		opts.Parquet = filepath.Join(opts.DataPath, "*.parquet")
+	}
+	if opts.Column == "" {
+		return nil, fmt.Errorf("the column of the passages is required")
+	}
+	if opts.ChunkSize < 1 || opts.Workers < 1 || opts.BatchSize < 1 {
+		return nil, fmt.Errorf("chunk size, workers and batch size must be positive")
+	}
+	return &opts, nil
 }
 
-// Initialize a single http.Client to reuse connections
-var httpClient = &http.Client{
-	Timeout: 30 * time.Second,
+// parquetFiles returns the parquet files matching the pattern, in order.
+func parquetFiles(pattern string) ([]string, error) {
+	files, err := filepath.Glob(pattern)
+	if err != nil {
+		return nil, fmt.Errorf("invalid parquet pattern %q: %v", pattern, err)
+	}
+	if len(files) == 0 {
+		return nil, fmt.Errorf("no parquet files match %s", pattern)
+	}
+	sort.Strings(files)
+	return files, nil
 }
 
-// Initialize the embeddings server URL from environment variable or use default
-var embeddingsURL = func() string {
-	url := os.Getenv("EMBEDDINGS_URL")
-	if url == "" {
-		url = "http://192.168.0.110:32184/embeddings"
+// columnValue returns the string value of the column of a row read without a schema, a struct
+// with a field per column. Parquet column names are matched case insensitively, as the reader
+// capitalizes them, and optional columns are pointers.
+func columnValue(row interface{}, column string) (string, bool) {
+	value := reflect.ValueOf(row)
+	if value.Kind() == reflect.Pointer {
+		value = value.Elem()
 	}
-	return url
-}()
+	if value.Kind() != reflect.Struct {
+		return "", false
+	}
+	field := value.FieldByNameFunc(func(name string) bool { return strings.EqualFold(name, column) })
+	if field.Kind() == reflect.Pointer {
+		if field.IsNil() {
+			return "", false
+		}
+		field = field.Elem()
+	}
+	if field.Kind() != reflect.String {
+		return "", false
+	}
+	return field.String(), true
+}
 
 // initializeDatabase initializes the SQLite database, registers sqlite-vec, and creates necessary tables.
 func initializeDatabase(dataPath string) (*SQLiteDB, error) {
 	// Register the sqlite-vec extension
-	sqlite_vec.Auto()
+	sqlitevec.Register()
 
 	dbPath := filepath.Join(dataPath, "eternaldata.db")
 	dbExists := fileExists(dbPath)
@@ -149,10 +223,9 @@ func initializeDatabase(dataPath string) (*SQLiteDB, error) {
 		}
 
 		// Create the vec_items virtual table for vector embeddings
-		_, err = tx.Exec(vecTableCreationStmt)
-		if err != nil {
+		if err := vecTable.Create(context.Background(), tx); err != nil {
 			tx.Rollback()
-			return nil, fmt.Errorf("failed to create vec_items table: %v", err)
+			return nil, err
 		}
 
 		// Commit the transaction
@@ -165,7 +238,11 @@ func initializeDatabase(dataPath string) (*SQLiteDB, error) {
 		log.Println("Existing database found.")
 	}
 
-	return &SQLiteDB{db: db}, nil
+	sqldb := &SQLiteDB{db: db}
+	if err := sqldb.createCheckpointTables(); err != nil {
+		return nil, err
+	}
+	return sqldb, nil
 }
 
 // fileExists checks if a file exists at the given path.
@@ -174,198 +251,17 @@ func fileExists(filename string) bool {
 	return err == nil
 }
 
-// InsertChatEntry inserts a chat entry into the FTS5 table.
-func (sqldb *SQLiteDB) InsertChatEntry(ctx context.Context, entry ChatEntry) error {
-	query := `
-		INSERT INTO chat_fts(prompt, response, modelName)
-		VALUES (?, ?, ?);
-	`
-	_, err := sqldb.db.ExecContext(ctx, query, entry.Prompt, entry.Response, "assistant")
-	if err != nil {
-		return fmt.Errorf("failed to insert into FTS5: %v", err)
-	}
-	return nil
-}
-
-// UpsertChat upserts a chat entry into the regular "chats" table and inserts the embedding into vec_items.
-func (sqldb *SQLiteDB) UpsertChat(ctx context.Context, chat *Chat, embedding []float32) error {
-	var tx *sql.Tx
-	var err error
-
-	// Start a transaction
-	tx, err = sqldb.db.BeginTx(ctx, nil)
-	if err != nil {
-		return fmt.Errorf("failed to begin transaction: %v", err)
-	}
-
-	defer func() {
-		if err != nil {
-			tx.Rollback()
-		}
-	}()
-
-	// Serialize the embedding
-	serializedEmbedding, err := sqlite_vec.SerializeFloat32(embedding)
-	if err != nil {
-		return fmt.Errorf("failed to serialize embedding: %v", err)
-	}
-
-	// Insert or update the chats table
-	if chat.ID == 0 {
-		// Insert new chat
-		insertChatQuery := `
-			INSERT INTO chats (prompt, response, model_name, embedding)
-			VALUES (?, ?, ?, ?);
-		`
-		result, err := tx.ExecContext(ctx, insertChatQuery, chat.Prompt, chat.Response, "assistant", serializedEmbedding)
-		if err != nil {
-			return fmt.Errorf("failed to insert into chats table: %v", err)
-		}
-		chat.ID, err = result.LastInsertId()
-		if err != nil {
-			return fmt.Errorf("failed to retrieve last insert ID: %v", err)
-		}
-	} else {
-		// Update existing chat
-		updateChatQuery := `
-			UPDATE chats
-			SET prompt = ?, response = ?, modelName = ?
-			WHERE id = ?;
-		`
-		_, err = tx.ExecContext(ctx, updateChatQuery, chat.Prompt, chat.Response, "assistant", chat.ID)
-		if err != nil {
-			return fmt.Errorf("failed to update chats table: %v", err)
-		}
-	}
-
-	// Insert or replace the embedding in vec_items using REPLACE INTO
-	insertVecQuery := `
-		REPLACE INTO vec_items(rowid, embedding)
-		VALUES (?, ?);
-	`
-	_, err = tx.ExecContext(ctx, insertVecQuery, chat.ID, serializedEmbedding)
+// LoadParquetData loads the passages of the column of the Parquet file, generates embeddings, and
+// inserts data into FTS5, chats, and vec_items tables. Loading resumes from the checkpoint of the
+// file, and passages already ingested are skipped.
+func (sqldb *SQLiteDB) LoadParquetData(ctx context.Context, parquetFilePath string, opts *options) error {
+	file, err := filepath.Abs(parquetFilePath)
 	if err != nil {
-		return fmt.Errorf("failed to upsert into vec_items table: %v", err)
-	}
-
-	// Commit the transaction
-	if err = tx.Commit(); err != nil {
-		return fmt.Errorf("failed to commit transaction: %v", err)
+		return err
 	}
 
-	return nil
-}
-
-// fetchEmbeddings sends a batch POST request to the embeddings endpoint and retrieves the embeddings.
-func fetchEmbeddings(ctx context.Context, prompts []string) ([][]float32, error) {
-	var embeddings [][]float32
-	var retries = 3
-	var maxBackoff = 30 * time.Second
-
-	for attempt := 1; attempt <= retries; attempt++ {
-		// Create the request payload
-		reqBody := EmbeddingRequest{
-			Input: prompts,
-		}
-		reqBytes, err := json.Marshal(reqBody)
-		if err != nil {
-			return nil, fmt.Errorf("failed to marshal embedding request: %v", err)
-		}
-
-		// Create the HTTP request
-		req, err := http.NewRequestWithContext(ctx, "POST", embeddingsURL, bytes.NewBuffer(reqBytes))
-		if err != nil {
-			return nil, fmt.Errorf("failed to create HTTP request for embedding: %v", err)
-		}
-		req.Header.Set("Content-Type", "application/json")
-
-		// Send the request using the shared http.Client
-		resp, err := httpClient.Do(req)
-		if err != nil {
-			log.Printf("Attempt %d: failed to send HTTP request for embedding: %v", attempt, err)
-			// Exponential backoff with cap
-			sleepDuration := time.Duration(attempt*attempt) * time.Second
-			if sleepDuration > maxBackoff {
-				sleepDuration = maxBackoff
-			}
-			time.Sleep(sleepDuration + time.Duration(rand.Intn(1000))*time.Millisecond)
-			continue
-		}
-
-		// Read and parse the response
-		respBytes, err := io.ReadAll(resp.Body)
-		resp.Body.Close()
-		if err != nil {
-			log.Printf("Attempt %d: failed to read embedding response: %v", attempt, err)
-			// Exponential backoff with cap
-			sleepDuration := time.Duration(attempt*attempt) * time.Second
-			if sleepDuration > maxBackoff {
-				sleepDuration = maxBackoff
-			}
-			time.Sleep(sleepDuration + time.Duration(rand.Intn(1000))*time.Millisecond)
-			continue
-		}
-
-		// Check for non-200 status codes
-		if resp.StatusCode != http.StatusOK {
-			log.Printf("Attempt %d: non-OK HTTP status: %s, body: %s", attempt, resp.Status, string(respBytes))
-			// Exponential backoff with cap
-			sleepDuration := time.Duration(attempt*attempt) * time.Second
-			if sleepDuration > maxBackoff {
-				sleepDuration = maxBackoff
-			}
-			time.Sleep(sleepDuration + time.Duration(rand.Intn(1000))*time.Millisecond)
-			continue
-		}
-
-		// Unmarshal the response
-		var embeddingResp EmbeddingResponse
-		err = json.Unmarshal(respBytes, &embeddingResp)
-		if err != nil {
-			log.Printf("Attempt %d: failed to unmarshal embedding response: %v", attempt, err)
-			// Exponential backoff with cap
-			sleepDuration := time.Duration(attempt*attempt) * time.Second
-			if sleepDuration > maxBackoff {
-				sleepDuration = maxBackoff
-			}
-			time.Sleep(sleepDuration + time.Duration(rand.Intn(1000))*time.Millisecond)
-			continue
-		}
-
-		// Ensure we have embeddings in the response
-		if len(embeddingResp.Data) == 0 {
-			log.Printf("Attempt %d: no embedding data returned in response", attempt)
-			// Exponential backoff with cap
-			sleepDuration := time.Duration(attempt*attempt) * time.Second
-			if sleepDuration > maxBackoff {
-				sleepDuration = maxBackoff
-			}
-			time.Sleep(sleepDuration + time.Duration(rand.Intn(1000))*time.Millisecond)
-			continue
-		}
-
-		// Convert embeddings from []float64 to []float32
-		embeddings = make([][]float32, len(embeddingResp.Data))
-		for i, data := range embeddingResp.Data {
-			if len(data.Embedding) != embeddingDim {
-				log.Printf("Embedding dimension mismatch for index %d: expected %d, got %d", i, embeddingDim, len(data.Embedding))
-				return nil, fmt.Errorf("embedding dimension mismatch at index %d", i)
-			}
-			embeddings[i] = make([]float32, embeddingDim)
-			for j, val := range data.Embedding {
-				embeddings[i][j] = float32(val)
-			}
-		}
-		return embeddings, nil
-	}
-
-	return nil, fmt.Errorf("failed to fetch embeddings after %d attempts", retries)
-}
-
-// LoadParquetData loads data from the Parquet file, generates embeddings, and inserts data into FTS5, chats, and vec_items tables.
-func (sqldb *SQLiteDB) LoadParquetData(ctx context.Context, parquetFilePath string) error {
 	// Open the Parquet file
-	fr, err := local.NewLocalFileReader(parquetFilePath)
+	fr, err := local.NewLocalFileReader(file)
 	if err != nil {
 		return fmt.Errorf("failed to open parquet file: %v", err)
 	}
@@ -381,130 +277,174 @@ func (sqldb *SQLiteDB) LoadParquetData(ctx context.Context, parquetFilePath stri
 	// Get the number of rows in the Parquet file
 	numRows := int(pr.GetNumRows())
 
-	// Read all rows into a slice
-	rows, err := pr.ReadByNumber(numRows)
+	offset, err := sqldb.Checkpoint(ctx, file)
 	if err != nil {
-		return fmt.Errorf("can't read parquet file: %v", err)
+		return err
 	}
-
-	// Convert the rows to JSON
-	jsonBs, err := json.Marshal(rows)
-	if err != nil {
-		return fmt.Errorf("failed to convert parquet data to JSON: %v", err)
-	}
-
-	// Log the JSON for debugging (optional)
-	log.Println("JSON Data from Parquet:", string(jsonBs))
-
-	// Unmarshal JSON data into ChatEntry structs with empty responses
-	var rawPassages []map[string]interface{}
-	err = json.Unmarshal(jsonBs, &rawPassages)
-	if err != nil {
-		return fmt.Errorf("failed to unmarshal JSON data: %v", err)
+	if offset >= numRows {
+		log.Printf("%s: all %d rows already ingested", file, numRows)
+		return nil
 	}
-
-	var chatEntries []ChatEntry
-	var chats []*Chat
-	for _, raw := range rawPassages {
-		// Assuming the Parquet data has a field named "Passage"
-		prompt, ok := raw["Passage"].(string)
-		if !ok {
-			log.Printf("Invalid passage format: %v", raw["Passage"])
-			continue
-		}
-
-		// Create the ChatEntry for the FTS5 table
-		chatEntries = append(chatEntries, ChatEntry{
-			Prompt:    prompt,
-			Response:  "", // Initialize response as an empty string
-			ModelName: "assistant",
-		})
-
-		// Initialize the Chat struct with default values
-		chats = append(chats, &Chat{
-			Prompt:    prompt,
-			Response:  "",          // Initialize response as an empty string
-			ModelName: "assistant", // Default model name
-		})
-	}
-
-	// Process embeddings in chunks of 10 with concurrency control
-	chunkSize := 10
-	maxConcurrentWorkers := 3
-	sem := make(chan struct{}, maxConcurrentWorkers)
-	var wg sync.WaitGroup
-
-	for i := 0; i < len(chatEntries); i += chunkSize {
-		end := i + chunkSize
-		if end > len(chatEntries) {
-			end = len(chatEntries)
-		}
-
-		// Extract the prompts for the current chunk
-		chunkPrompts := make([]string, end-i)
-		for j := i; j < end; j++ {
-			chunkPrompts[j-i] = chatEntries[j].Prompt
+	if offset > 0 {
+		log.Printf("%s: resuming after row %d", file, offset)
+		if err := pr.SkipRows(int64(offset)); err != nil {
+			return fmt.Errorf("can't skip ingested rows: %v", err)
 		}
+	}
 
-		wg.Add(1)
-		sem <- struct{}{}
-		go func(start, end int, prompts []string) {
-			defer wg.Done()
-			defer func() { <-sem }()
-
-			// Fetch embeddings for the chunk
-			embeddings, err := fetchEmbeddings(ctx, prompts)
+	checkpoints := newCheckpointer(sqldb, file, offset, numRows)
+	stats := newProgress(file, numRows, offset)
+	stop := make(chan struct{})
+	go stats.report(stop)
+	defer close(stop)
+
+	// Each row is a document of a single passage, identified by its hash. Rows are read in batches
+	// as the workers free up, so at most a batch and a chunk per worker are in memory.
+	pipeline := &ingest.Pipeline{
+		Loader: ingest.LoaderFunc(func(ctx context.Context, emit func(ingest.Document) error) error {
+			for batchStart := offset; batchStart < numRows; batchStart += opts.BatchSize {
+				rows, err := pr.ReadByNumber(min(opts.BatchSize, numRows-batchStart))
+				if err != nil {
+					return fmt.Errorf("can't read parquet rows from %d: %v", batchStart, err)
+				}
+				if len(rows) == 0 {
+					break
+				}
+				for i, row := range rows {
+					passage, ok := columnValue(row, opts.Column)
+					if !ok {
+						log.Printf("Invalid passage format in column %s of row %v", opts.Column, row)
+						stats.processed.Add(1)
+						stats.failed.Add(1)
+						checkpoints.complete(ctx, batchStart+i)
+						continue
+					}
+					err := emit(ingest.Document{
+						ID:       passageHash(passage),
+						Content:  passage,
+						Metadata: map[string]string{"row": strconv.Itoa(batchStart + i)},
+					})
+					if err != nil {
+						return err
+					}
+				}
+			}
+			return nil
+		}),
+		Embedder:  embedder,
+		Filter:    sqldb.filterIngested(stats),
+		Sinks:     []ingest.Sink{sqldb.passageSink(stats)},
+		BatchSize: opts.ChunkSize,
+		Workers:   opts.Workers,
+		OnBatch: func(batch []ingest.Chunk, err error) {
+			stats.processed.Add(int64(len(batch)))
 			if err != nil {
-				log.Printf("Failed to fetch embeddings for chunk starting at %d: %v. Skipping chunk.", start, err)
+				log.Printf("Chunk of %d rows not fully loaded, it is retried by the next load: %v", len(batch), err)
 				return
 			}
+			rows := make([]int, len(batch))
+			for i, chunk := range batch {
+				rows[i], _ = strconv.Atoi(chunk.Metadata["row"])
+			}
+			checkpoints.complete(ctx, rows...)
+		},
+	}
+	if _, err := pipeline.Run(ctx); err != nil && !errors.Is(err, ingest.ErrFailedChunks) {
+		return err
+	}
 
-			// Update Chat entries with embeddings
-			for j := start; j < end; j++ {
-				embedding := embeddings[j-start]
+	log.Println("Parquet data with embeddings inserted successfully into FTS5, chats, and vec_items tables.")
+	return nil
+}
 
-				// Insert the chat entry into the FTS5 table
-				err = sqldb.InsertChatEntry(ctx, chatEntries[j])
-				if err != nil {
-					log.Printf("Failed to insert chat entry into FTS5 for prompt ID %d: %v. Skipping entry.", j, err)
-					continue
-				}
+// filterIngested drops the passages already ingested from the chunks to load.
+func (sqldb *SQLiteDB) filterIngested(stats *progress) func(context.Context, []ingest.Chunk) ([]ingest.Chunk, error) {
+	return func(ctx context.Context, chunks []ingest.Chunk) ([]ingest.Chunk, error) {
+		hashes := make([]string, len(chunks))
+		for i, chunk := range chunks {
+			hashes[i] = chunk.DocumentID
+		}
+		ingested, err := sqldb.IngestedHashes(ctx, hashes)
+		if err != nil {
+			stats.failed.Add(int64(len(chunks)))
+			return nil, err
+		}
 
-				// Upsert the Chat entry into the regular "chats" table and insert into vec_items
-				err = sqldb.UpsertChat(ctx, chats[j], embedding)
-				if err != nil {
-					log.Printf("Failed to upsert chat entry and embedding for prompt ID %d: %v. Skipping entry.", j, err)
-					continue
-				}
+		var pending []ingest.Chunk
+		for _, chunk := range chunks {
+			if ingested[chunk.DocumentID] {
+				stats.skipped.Add(1)
+				continue
 			}
-		}(i, end, chunkPrompts)
+			pending = append(pending, chunk)
+		}
+		return pending, nil
 	}
+}
 
-	wg.Wait()
-
-	log.Println("Parquet data with embeddings inserted successfully into FTS5, chats, and vec_items tables.")
-	return nil
+// passageSink inserts embedded passages, trying them all before failing with the first error.
+func (sqldb *SQLiteDB) passageSink(stats *progress) ingest.Sink {
+	return ingest.SinkFunc(func(ctx context.Context, chunks []ingest.Chunk) error {
+		var firstErr error
+		for _, chunk := range chunks {
+			inserted, err := sqldb.InsertPassage(ctx, chunk.Text, chunk.DocumentID, chunk.Embedding)
+			switch {
+			case err != nil:
+				log.Printf("Failed to insert passage: %v. Skipping entry.", err)
+				stats.failed.Add(1)
+				if firstErr == nil {
+					firstErr = err
+				}
+			case inserted:
+				stats.inserted.Add(1)
+			default:
+				stats.skipped.Add(1)
+			}
+		}
+		return firstErr
+	})
 }
 
+// main loads parquet datasets, or with the query and serve commands, searches what was loaded.
 func main() {
-	// Define the data path where the SQLite database will be stored
-	dataPath := "/Users/arturoaquino/.manifold/datasets"
+	if len(os.Args) > 1 && (os.Args[1] == "query" || os.Args[1] == "serve") {
+		if err := runQuery(os.Args[1], os.Args[2:]); err != nil && !errors.Is(err, flag.ErrHelp) {
+			log.Fatal(err)
+		}
+		return
+	}
 
-	// Define the path to the Parquet file
-	parquetFilePath := "/Users/arturoaquino/.manifold/datasets/part.0.parquet"
+	opts, err := parseOptions(os.Args[1:])
+	if err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			os.Exit(0)
+		}
+		log.Fatalf("Invalid options: %v", err)
+	}
+	embedder = &ingest.HTTPEmbedder{URL: opts.EmbeddingsURL, Dimensions: embeddingDim}
+
+	files, err := parquetFiles(opts.Parquet)
+	if err != nil {
+		log.Fatal(err)
+	}
 
 	// Initialize the SQLite database
-	db, err := initializeDatabase(dataPath)
+	if err := os.MkdirAll(opts.DataPath, 0755); err != nil {
+		log.Fatalf("Failed to create data directory: %v", err)
+	}
+	db, err := initializeDatabase(opts.DataPath)
 	if err != nil {
 		log.Fatalf("Failed to initialize database: %v", err)
 	}
 	defer db.db.Close()
 
-	// Load and insert data from the Parquet file into the SQLite database
+	// Load and insert data from the Parquet files into the SQLite database
 	ctx := context.Background()
-	err = db.LoadParquetData(ctx, parquetFilePath)
-	if err != nil {
-		log.Fatalf("Failed to load data from Parquet file: %v", err)
+	for _, file := range files {
+		log.Printf("Loading %s", file)
+		if err := db.LoadParquetData(ctx, file, opts); err != nil {
+			log.Fatalf("Failed to load data from Parquet file %s: %v", file, err)
+		}
 	}
 
 	log.Println("Database setup and Parquet data insertion complete")
@@ -0,0 +1,222 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"manifold/internal/ingest"
+)
+
+const (
+	defaultQueryK   = 10
+	queryCandidates = 50 // results of each retriever fused
+	rrfK            = 60 // the usual constant of reciprocal rank fusion
+)
+
+// Passage is a passage matching a query, with its rank in the vector and full text results, 0 when
+// it isn't in them.
+type Passage struct {
+	Text       string  `json:"text"`
+	Score      float64 `json:"score"` // reciprocal rank fusion of both rankings
+	VectorRank int     `json:"vector_rank,omitempty"`
+	Distance   float64 `json:"distance,omitempty"`
+	FTSRank    int     `json:"fts_rank,omitempty"`
+}
+
+// ftsQuery turns free text into an FTS5 query matching any of its words, quoted so punctuation and
+// FTS5 operators in the text are searched for literally.
+func ftsQuery(text string) string {
+	var terms []string
+	for _, word := range strings.FieldsFunc(text, func(r rune) bool {
+		return !(r == '_' || r == '\'' || r >= '0' && r <= '9' || r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r > 127)
+	}) {
+		terms = append(terms, `"`+strings.ReplaceAll(word, `"`, `""`)+`"`)
+	}
+	return strings.Join(terms, " OR ")
+}
+
+// vectorSearch returns the passages nearest to the embedding in vec_items, nearest first.
+func (sqldb *SQLiteDB) vectorSearch(ctx context.Context, embedding []float32, k int) ([]Passage, error) {
+	matches, err := vecTable.Search(ctx, sqldb.db, embedding, k)
+	if err != nil {
+		return nil, err
+	}
+
+	var passages []Passage
+	for _, match := range matches {
+		passage := Passage{VectorRank: len(passages) + 1, Distance: match.Distance}
+		err := sqldb.db.QueryRowContext(ctx, `SELECT prompt FROM chats WHERE id = ?;`, match.RowID).Scan(&passage.Text)
+		if errors.Is(err, sql.ErrNoRows) {
+			continue
+		} else if err != nil {
+			return nil, fmt.Errorf("failed to read chat %d: %v", match.RowID, err)
+		}
+		passages = append(passages, passage)
+	}
+	return passages, nil
+}
+
+// ftsSearch returns the passages of chat_fts best matching the text by BM25, best first.
+func (sqldb *SQLiteDB) ftsSearch(ctx context.Context, text string, k int) ([]Passage, error) {
+	query := ftsQuery(text)
+	if query == "" {
+		return nil, nil
+	}
+	rows, err := sqldb.db.QueryContext(ctx, `
+		SELECT prompt FROM chat_fts WHERE chat_fts MATCH ? ORDER BY bm25(chat_fts) LIMIT ?;
+	`, query, k)
+	if err != nil {
+		return nil, fmt.Errorf("failed to search chat_fts: %v", err)
+	}
+	defer rows.Close()
+
+	var passages []Passage
+	for rows.Next() {
+		passage := Passage{FTSRank: len(passages) + 1}
+		if err := rows.Scan(&passage.Text); err != nil {
+			return nil, err
+		}
+		passages = append(passages, passage)
+	}
+	return passages, rows.Err()
+}
+
+// Query returns the k passages best matching the text: the kNN results of its embedding in
+// vec_items fused with the BM25 results of chat_fts by reciprocal rank fusion. Passages are matched
+// across both by text, as the rows of the tables aren't linked. When the text can't be embedded,
+// the full text results are returned alone.
+func (sqldb *SQLiteDB) Query(ctx context.Context, text string, k int) ([]Passage, error) {
+	var vector []Passage
+	embeddings, err := embedder.Embed(ctx, []string{text})
+	if err != nil {
+		log.Printf("Searching by full text only, failed to embed the query: %v", err)
+	} else if vector, err = sqldb.vectorSearch(ctx, embeddings[0], max(k, queryCandidates)); err != nil {
+		return nil, err
+	}
+	fts, err := sqldb.ftsSearch(ctx, text, max(k, queryCandidates))
+	if err != nil {
+		return nil, err
+	}
+
+	fused := make(map[string]*Passage)
+	var order []*Passage
+	for _, results := range [][]Passage{vector, fts} {
+		for rank, result := range results {
+			passage, ok := fused[result.Text]
+			if !ok {
+				passage = &Passage{Text: result.Text}
+				fused[result.Text] = passage
+				order = append(order, passage)
+			}
+			if result.VectorRank > 0 && passage.VectorRank == 0 {
+				passage.VectorRank, passage.Distance = result.VectorRank, result.Distance
+			}
+			if result.FTSRank > 0 && passage.FTSRank == 0 {
+				passage.FTSRank = result.FTSRank
+			}
+			passage.Score += 1 / float64(rrfK+rank+1)
+		}
+	}
+	sort.SliceStable(order, func(i, j int) bool { return order[i].Score > order[j].Score })
+
+	passages := make([]Passage, 0, min(k, len(order)))
+	for _, passage := range order[:min(k, len(order))] {
+		passages = append(passages, *passage)
+	}
+	return passages, nil
+}
+
+// writePassages prints ranked passages.
+func writePassages(w io.Writer, passages []Passage) {
+	if len(passages) == 0 {
+		fmt.Fprintln(w, "No passages found.")
+	}
+	for i, passage := range passages {
+		fmt.Fprintf(w, "%d. score %.4f (vector rank %d, fts rank %d)\n%s\n\n", i+1, passage.Score, passage.VectorRank, passage.FTSRank, passage.Text)
+	}
+}
+
+// handleQuery serves /query?q=&k=, the passages best matching q as JSON.
+func (sqldb *SQLiteDB) handleQuery(w http.ResponseWriter, r *http.Request) {
+	text := r.URL.Query().Get("q")
+	if text == "" {
+		http.Error(w, "q is required", http.StatusBadRequest)
+		return
+	}
+	k := defaultQueryK
+	if value := r.URL.Query().Get("k"); value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 1 {
+			http.Error(w, fmt.Sprintf("invalid k %q", value), http.StatusBadRequest)
+			return
+		}
+		k = n
+	}
+
+	passages, err := sqldb.Query(r.Context(), text, k)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(passages)
+}
+
+// runQuery runs the query and serve commands: query prints the passages best matching its
+// arguments, serve answers the queries of /query over HTTP.
+func runQuery(command string, args []string) error {
+	fs := flag.NewFlagSet("erag "+command, flag.ContinueOnError)
+	dataPath := fs.String("data", envOr("DATA_PATH", filepath.Join(os.Getenv("HOME"), ".manifold", "datasets")), "Directory of the SQLite database, DATA_PATH")
+	embeddings := fs.String("embeddings", envOr("EMBEDDINGS_URL", "http://localhost:32184/embeddings"), "Embeddings endpoint, EMBEDDINGS_URL")
+	k := fs.Int("k", defaultQueryK, "Number of passages")
+	asJSON := fs.Bool("json", false, "Print the passages as JSON")
+	port := fs.Int("port", 8080, "Port of the HTTP server")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	embedder = &ingest.HTTPEmbedder{URL: *embeddings, Dimensions: embeddingDim}
+
+	dbPath := filepath.Join(*dataPath, "eternaldata.db")
+	if !fileExists(dbPath) {
+		return fmt.Errorf("no database at %s, load a dataset first", dbPath)
+	}
+	db, err := initializeDatabase(*dataPath)
+	if err != nil {
+		return err
+	}
+	defer db.db.Close()
+
+	if command == "serve" {
+		http.HandleFunc("/query", db.handleQuery)
+		log.Printf("Serving queries on :%d/query", *port)
+		return http.ListenAndServe(fmt.Sprintf(":%d", *port), nil)
+	}
+
+	text := strings.Join(fs.Args(), " ")
+	if text == "" {
+		return errors.New("usage: erag query [flags] <text>")
+	}
+	passages, err := db.Query(context.Background(), text, *k)
+	if err != nil {
+		return err
+	}
+	if *asJSON {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(passages)
+	}
+	writePassages(os.Stdout, passages)
+	return nil
+}
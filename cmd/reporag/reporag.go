@@ -4,11 +4,14 @@ import (
 	"bufio"
 	"context"
 	"database/sql"
+	"flag"
 	"fmt"
 	"log"
 	"os"
+	"path/filepath"
 	"strings"
 	"sync"
+	"time"
 
 	"manifold/internal/coderag"
 
@@ -17,7 +20,6 @@ import (
 
 // Constants for database
 const (
-	dbPath               = "/Users/arturoaquino/.manifold/datasets/eternaldata.db" // Path to SQLite DB
 	chatFTSTableCreation = `
 		CREATE VIRTUAL TABLE IF NOT EXISTS chat_fts USING fts5(
 			prompt,
@@ -143,8 +145,22 @@ func insertChunksIntoDB(ctx context.Context, sqldb *SQLiteDB, index *coderag.Cod
 }
 
 func main() {
+	home, _ := os.UserHomeDir()
+	datasets := filepath.Join(home, ".manifold", "datasets")
+	dbPath := flag.String("db", filepath.Join(datasets, "eternaldata.db"), "SQLite database the chunks are inserted into")
+	codeIndexPath := flag.String("store", filepath.Join(datasets, "coderag.db"), "SQLite file of the saved code indexes")
+	repoPath := flag.String("repo", ".", "Go module to index")
+	numWorkers := flag.Int("workers", 4, "Queries handled concurrently")
+	summaryWorkers := flag.Int("summary-workers", 0, "Concurrent summary requests, CODERAG_SUMMARY_WORKERS when 0")
+	port := flag.Int("port", 8080, "Port of the API server")
+	flag.Parse()
+
+	if *numWorkers < 1 {
+		log.Fatalf("-workers must be at least 1")
+	}
+
 	// Initialize the SQLite database
-	sqldb, err := initializeDatabase(dbPath)
+	sqldb, err := initializeDatabase(*dbPath)
 	if err != nil {
 		log.Fatalf("Database initialization error: %v", err)
 	}
@@ -155,9 +171,21 @@ func main() {
 	if err != nil {
 		log.Fatalf("Configuration error: %v", err)
 	}
+	if *summaryWorkers > 0 {
+		cfg.SummaryWorkers = *summaryWorkers
+	}
+
+	// Open the CodeIndex saved by the last run, if any
+	store, err := coderag.OpenStore(*codeIndexPath)
+	if err != nil {
+		log.Fatalf("Code index store error: %v", err)
+	}
+	defer store.Close()
 
-	// Initialize the CodeIndex
-	index := coderag.NewCodeIndex()
+	index, err := store.OpenCodeIndex(*repoPath)
+	if err != nil {
+		log.Fatalf("Failed to load the code index: %v", err)
+	}
 
 	// Channel to handle repository indexing
 	indexingChan := make(chan struct{})
@@ -167,12 +195,18 @@ func main() {
 	wg.Add(1)
 	go func() {
 		defer wg.Done()
-		repoPath := "/Users/arturoaquino/Documents/manifold"
-		fmt.Printf("Indexing repository at: %s\n", repoPath)
-		if err := index.IndexRepository(repoPath, cfg); err != nil {
+		fmt.Printf("Indexing repository at: %s\n", *repoPath)
+		if err := index.IndexRepository(*repoPath, cfg); err != nil {
 			log.Fatalf("Indexing failed: %v", err)
 		}
 		fmt.Println("Indexing completed successfully.")
+
+		// Keep the index up to date as files change
+		go func() {
+			if err := index.Watch(context.Background(), *repoPath, cfg, 2*time.Second); err != nil {
+				log.Printf("Watching %s failed: %v", *repoPath, err)
+			}
+		}()
 		indexingChan <- struct{}{} // Notify that indexing is done
 
 		// After indexing, insert chunks into the SQLite FTS5 database
@@ -186,7 +220,7 @@ func main() {
 	wg.Add(1)
 	go func() {
 		defer wg.Done()
-		index.StartAPIServer(8080)
+		index.StartAPIServer(*port)
 	}()
 
 	// Create a buffered reader for user input
@@ -202,8 +236,7 @@ func main() {
 	queryChan := make(chan string)
 
 	// Start a worker pool to handle user queries concurrently
-	numWorkers := 4
-	for i := 0; i < numWorkers; i++ {
+	for i := 0; i < *numWorkers; i++ {
 		wg.Add(1)
 		go worker(queryChan, index, sqldb, &wg)
 	}
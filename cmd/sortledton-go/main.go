@@ -3,461 +3,147 @@
 package main
 
 import (
+	"flag"
 	"fmt"
-	"sort"
+	"log"
+	"math/rand"
+	"runtime"
 	"sync"
 	"time"
-)
 
-const (
-	MAX_CAPACITY = 256
-	MIN_CAPACITY = 64
+	"manifold/internal/sortledton"
 )
 
-type Edge struct {
-	targetVertexId int
-	version        int64 // Timestamp for MVCC
-	isDeleted      bool  // Flag to indicate logical deletion
-}
-
-type EdgeBlock struct {
-	edges []Edge     // A sorted list of edges
-	next  *EdgeBlock // Pointer to the next block in the skip list
-	mu    sync.RWMutex
-}
-
-func (eb *EdgeBlock) isFull() bool {
-	return len(eb.edges) >= MAX_CAPACITY
-}
-
-func (eb *EdgeBlock) insertEdge(edge Edge) {
-	eb.mu.Lock()
-	defer eb.mu.Unlock()
-
-	// Insert edge into edges maintaining sorted order
-	idx := sort.Search(len(eb.edges), func(i int) bool {
-		return eb.edges[i].targetVertexId >= edge.targetVertexId
-	})
-
-	if idx < len(eb.edges) && eb.edges[idx].targetVertexId == edge.targetVertexId {
-		// Edge already exists, update it
-		eb.edges[idx] = edge
-	} else {
-		eb.edges = append(eb.edges, Edge{})    // make space
-		copy(eb.edges[idx+1:], eb.edges[idx:]) // shift
-		eb.edges[idx] = edge                   // insert
-	}
-
-	if eb.isFull() {
-		eb.split()
-	}
-}
-
-func (eb *EdgeBlock) split() {
-	midIndex := len(eb.edges) / 2
-	newBlock := &EdgeBlock{
-		edges: append([]Edge(nil), eb.edges[midIndex:]...),
-		next:  eb.next,
-	}
-	eb.edges = eb.edges[:midIndex]
-	eb.next = newBlock
-}
-
-func (eb *EdgeBlock) checkUnderutilization() {
-	eb.mu.Lock()
-	defer eb.mu.Unlock()
-	if len(eb.edges) < MIN_CAPACITY {
-		// Try to merge with next block
-		if eb.next != nil && len(eb.edges)+len(eb.next.edges) <= MAX_CAPACITY {
-			eb.edges = append(eb.edges, eb.next.edges...)
-			eb.next = eb.next.next
-		}
-	}
-}
-
-type UnrolledSkipList struct {
-	head          *EdgeBlock
-	levelPointers []*EdgeBlock // Pointers for skip list levels
-	mu            sync.RWMutex
-}
-
-func NewUnrolledSkipList() *UnrolledSkipList {
-	return &UnrolledSkipList{
-		head:          nil,
-		levelPointers: nil,
-	}
-}
-
-func (usl *UnrolledSkipList) search(targetVertexId int) (*Edge, *EdgeBlock) {
-	usl.mu.RLock()
-	defer usl.mu.RUnlock()
-
-	currentBlock := usl.head
-	for currentBlock != nil {
-		currentBlock.mu.RLock()
-		if len(currentBlock.edges) > 0 && targetVertexId <= currentBlock.edges[len(currentBlock.edges)-1].targetVertexId {
-			idx := sort.Search(len(currentBlock.edges), func(i int) bool {
-				return currentBlock.edges[i].targetVertexId >= targetVertexId
-			})
-			if idx < len(currentBlock.edges) && currentBlock.edges[idx].targetVertexId == targetVertexId {
-				edge := &currentBlock.edges[idx]
-				currentBlock.mu.RUnlock()
-				return edge, currentBlock
-			}
-			currentBlock.mu.RUnlock()
-			return nil, currentBlock
-		}
-		currentBlock.mu.RUnlock()
-		currentBlock = currentBlock.next
-	}
-	return nil, nil
-}
-
-func (usl *UnrolledSkipList) insert(edge Edge) {
-	usl.mu.Lock()
-	defer usl.mu.Unlock()
-
-	if usl.head == nil {
-		usl.head = &EdgeBlock{
-			edges: []Edge{edge},
-			next:  nil,
-		}
+func main() {
+	dir := flag.String("dir", "", "Directory the graph is persisted in, in memory only when empty")
+	bench := flag.Int("bench", 0, "Benchmark the analytics on a random graph of this many vertices")
+	flag.Parse()
+	if *bench > 0 {
+		runBenchmark(*bench, 8)
 		return
 	}
-
-	currentBlock := usl.head
-	for currentBlock != nil {
-		currentBlock.mu.Lock()
-		if len(currentBlock.edges) == 0 || edge.targetVertexId <= currentBlock.edges[len(currentBlock.edges)-1].targetVertexId {
-			currentBlock.insertEdge(edge)
-			currentBlock.mu.Unlock()
-			return
-		}
-		nextBlock := currentBlock.next
-		currentBlock.mu.Unlock()
-		currentBlock = nextBlock
-	}
-
-	// Append to the last block
-	newBlock := &EdgeBlock{
-		edges: []Edge{edge},
-		next:  nil,
+	if *dir != "" {
+		runDurable(*dir)
+		return
 	}
-	currentBlock.next = newBlock
-}
 
-func (usl *UnrolledSkipList) delete(targetVertexId int, version int64) {
-	usl.mu.Lock()
-	defer usl.mu.Unlock()
+	graph := sortledton.New()
 
-	currentBlock := usl.head
-	for currentBlock != nil {
-		currentBlock.mu.Lock()
-		if len(currentBlock.edges) > 0 && targetVertexId <= currentBlock.edges[len(currentBlock.edges)-1].targetVertexId {
-			idx := sort.Search(len(currentBlock.edges), func(i int) bool {
-				return currentBlock.edges[i].targetVertexId >= targetVertexId
-			})
-			if idx < len(currentBlock.edges) && currentBlock.edges[idx].targetVertexId == targetVertexId {
-				currentBlock.edges[idx].isDeleted = true
-				currentBlock.edges[idx].version = version
-				currentBlock.checkUnderutilization()
-			}
-			currentBlock.mu.Unlock()
-			return
-		}
-		nextBlock := currentBlock.next
-		currentBlock.mu.Unlock()
-		currentBlock = nextBlock
-	}
-}
+	// Insert edges
+	graph.InsertEdge(1, 2)
+	graph.InsertEdge(1, 3)
+	graph.InsertEdge(2, 3)
 
-func (usl *UnrolledSkipList) getActiveEdges() []int {
-	usl.mu.RLock()
-	defer usl.mu.RUnlock()
+	// Delete edge
+	graph.DeleteEdge(1, 2)
 
-	var activeEdges []int
-	currentBlock := usl.head
-	currentTime := time.Now().UnixNano()
-	for currentBlock != nil {
-		currentBlock.mu.RLock()
-		for _, edge := range currentBlock.edges {
-			if edge.version <= currentTime && !edge.isDeleted {
-				activeEdges = append(activeEdges, edge.targetVertexId)
-			}
-		}
-		nextBlock := currentBlock.next
-		currentBlock.mu.RUnlock()
-		currentBlock = nextBlock
-	}
-	return activeEdges
-}
+	// Retrieve neighbors
+	neighborsOf1 := graph.Neighbors(1)
+	fmt.Println("Neighbors of 1:", neighborsOf1) // Should return [3]
 
-type Vertex struct {
-	id            int
-	adjacencyList *UnrolledSkipList
-	mu            sync.RWMutex
-}
+	// Intersect neighbors
+	commonNeighbors := graph.IntersectNeighbors(1, 2)
+	fmt.Println("Common neighbors of 1 and 2:", commonNeighbors) // Should return [3]
 
-type VertexIdManager struct {
-	externalToInternal map[int]int
-	internalToExternal []int
-	mu                 sync.Mutex
-}
+	// Similarity graph between document chunks, weighted by their similarity
+	chunks := sortledton.New()
+	chunks.InsertWeightedEdge(10, 30, 0.92, map[string]interface{}{"document": "a.md"})
+	chunks.InsertWeightedEdge(10, 40, 0.81, nil)
+	chunks.InsertWeightedEdge(20, 30, 0.87, map[string]interface{}{"document": "b.md"})
+	chunks.InsertWeightedEdge(20, 40, 0.64, nil)
 
-func NewVertexIdManager() *VertexIdManager {
-	return &VertexIdManager{
-		externalToInternal: make(map[int]int),
-		internalToExternal: make([]int, 0),
+	if edge, ok := chunks.Edge(10, 30); ok {
+		document, _ := edge.StringProperty("document")
+		fmt.Printf("Similarity of chunks 10 and 30: %.2f (%s)\n", edge.Weight(), document)
 	}
-}
-
-func (vm *VertexIdManager) getInternalId(externalId int) int {
-	vm.mu.Lock()
-	defer vm.mu.Unlock()
-	if internalId, exists := vm.externalToInternal[externalId]; exists {
-		return internalId
+	for _, common := range chunks.IntersectWeightedNeighbors(10, 20) {
+		fmt.Printf("Chunk %d is similar to 10 (%.2f) and 20 (%.2f)\n", common.VertexId, common.First.Weight(), common.Second.Weight())
 	}
-	internalId := len(vm.internalToExternal)
-	vm.externalToInternal[externalId] = internalId
-	vm.internalToExternal = append(vm.internalToExternal, externalId)
-	return internalId
-}
 
-func (vm *VertexIdManager) getExternalId(internalId int) int {
-	vm.mu.Lock()
-	defer vm.mu.Unlock()
-	return vm.internalToExternal[internalId]
-}
+	// Analytics over a snapshot of the graph
+	snapshot := graph.Snapshot()
+	fmt.Println("PageRank:", snapshot.PageRank(0.85, 50, 1e-6))
+	fmt.Println("Triangles:", snapshot.TriangleCount())              // Should return 0
+	fmt.Println("Components:", snapshot.WeaklyConnectedComponents()) // Should map every vertex to 1
 
-type SortledtonGraph struct {
-	vertices        map[int]*Vertex
-	readWriteMu     sync.RWMutex // For concurrency control
-	vertexIdManager *VertexIdManager
+	// Delete a vertex, with the edges to it, and collect the garbage
+	graph.DeleteVertex(3)
+	graph.GarbageCollect()
+	fmt.Println("Neighbors of 1 without 3:", graph.Neighbors(1)) // Should return []
 }
 
-func NewSortledtonGraph() *SortledtonGraph {
-	return &SortledtonGraph{
-		vertices:        make(map[int]*Vertex),
-		vertexIdManager: NewVertexIdManager(),
+// runDurable runs the example on a graph persisted in a directory, recovering what previous runs
+// left there.
+func runDurable(dir string) {
+	graph, err := sortledton.Open(dir, 1000)
+	if err != nil {
+		log.Fatalf("Failed to open graph: %v", err)
 	}
-}
-
-func (g *SortledtonGraph) insertEdge(sourceId, targetId int) {
-	internalSourceId := g.vertexIdManager.getInternalId(sourceId)
-	internalTargetId := g.vertexIdManager.getInternalId(targetId)
+	defer graph.Close()
+	graph.StartSnapshotter(time.Minute)
 
-	g.readWriteMu.RLock()
-	vertex, exists := g.vertices[internalSourceId]
-	if !exists {
-		g.readWriteMu.RUnlock()
-		g.readWriteMu.Lock()
-		// Double-checking pattern
-		if vertex, exists = g.vertices[internalSourceId]; !exists {
-			vertex = &Vertex{
-				id:            internalSourceId,
-				adjacencyList: NewUnrolledSkipList(),
-			}
-			g.vertices[internalSourceId] = vertex
+	fmt.Println("Recovered neighbors of 1:", graph.Graph().Neighbors(1))
+	for _, edge := range [][2]int{{1, 2}, {1, 3}, {2, 3}} {
+		if err := graph.InsertEdge(edge[0], edge[1]); err != nil {
+			log.Fatal(err)
 		}
-		g.readWriteMu.Unlock()
-	} else {
-		g.readWriteMu.RUnlock()
-	}
-
-	// Lock the vertex
-	vertex.mu.Lock()
-	defer vertex.mu.Unlock()
-
-	currentVersion := time.Now().UnixNano()
-	edge := Edge{
-		targetVertexId: internalTargetId,
-		version:        currentVersion,
-		isDeleted:      false,
-	}
-	vertex.adjacencyList.insert(edge)
-}
-
-func (g *SortledtonGraph) deleteEdge(sourceId, targetId int) {
-	internalSourceId := g.vertexIdManager.getInternalId(sourceId)
-	internalTargetId := g.vertexIdManager.getInternalId(targetId)
-
-	g.readWriteMu.RLock()
-	vertex, exists := g.vertices[internalSourceId]
-	g.readWriteMu.RUnlock()
-	if !exists {
-		return
-	}
-
-	// Lock the vertex
-	vertex.mu.Lock()
-	defer vertex.mu.Unlock()
-
-	currentVersion := time.Now().UnixNano()
-	vertex.adjacencyList.delete(internalTargetId, currentVersion)
-}
-
-func (g *SortledtonGraph) getNeighbors(vertexId int) []int {
-	internalId := g.vertexIdManager.getInternalId(vertexId)
-
-	g.readWriteMu.RLock()
-	vertex, exists := g.vertices[internalId]
-	g.readWriteMu.RUnlock()
-	if !exists {
-		return []int{}
 	}
-
-	vertex.mu.RLock()
-	defer vertex.mu.RUnlock()
-
-	internalNeighbors := vertex.adjacencyList.getActiveEdges()
-	neighbors := make([]int, len(internalNeighbors))
-	for i, internalNeighborId := range internalNeighbors {
-		neighbors[i] = g.vertexIdManager.getExternalId(internalNeighborId)
+	if err := graph.DeleteEdge(1, 2); err != nil {
+		log.Fatal(err)
 	}
-	return neighbors
-}
-
-func intersectSortedSlices(a, b []int) []int {
-	var intersection []int
-	i, j := 0, 0
-	for i < len(a) && j < len(b) {
-		if a[i] == b[j] {
-			intersection = append(intersection, a[i])
-			i++
-			j++
-		} else if a[i] < b[j] {
-			i++
-		} else {
-			j++
-		}
+	fmt.Println("Neighbors of 1:", graph.Graph().Neighbors(1))
+	if err := graph.SaveSnapshot(); err != nil {
+		log.Fatal(err)
 	}
-	return intersection
 }
 
-func (g *SortledtonGraph) intersectNeighbors(vertexId1, vertexId2 int) []int {
-	internalId1 := g.vertexIdManager.getInternalId(vertexId1)
-	internalId2 := g.vertexIdManager.getInternalId(vertexId2)
-
-	// Acquire locks in a global order to prevent deadlocks
-	var firstVertex, secondVertex *Vertex
-	if internalId1 < internalId2 {
-		firstVertex = g.getVertex(internalId1)
-		secondVertex = g.getVertex(internalId2)
-	} else {
-		firstVertex = g.getVertex(internalId2)
-		secondVertex = g.getVertex(internalId1)
-	}
+// runBenchmark times inserting a random graph and running the analytics on a snapshot of it.
+func runBenchmark(vertices, edgesPerVertex int) {
+	graph := sortledton.New()
+	random := rand.New(rand.NewSource(1))
 
-	if firstVertex == nil || secondVertex == nil {
-		return []int{}
+	start := time.Now()
+	var wg sync.WaitGroup
+	workers := runtime.GOMAXPROCS(0)
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func(seed int64) {
+			defer wg.Done()
+			random := rand.New(rand.NewSource(seed))
+			for i := 0; i < vertices*edgesPerVertex/workers; i++ {
+				graph.InsertEdge(random.Intn(vertices), random.Intn(vertices))
+			}
+		}(random.Int63())
 	}
+	wg.Wait()
+	timed("Insert", start)
 
-	firstVertex.mu.RLock()
-	defer firstVertex.mu.RUnlock()
-
-	secondVertex.mu.RLock()
-	defer secondVertex.mu.RUnlock()
-
-	internalNeighbors1 := firstVertex.adjacencyList.getActiveEdges()
-	internalNeighbors2 := secondVertex.adjacencyList.getActiveEdges()
-
-	return intersectInternalIdsToExternal(g.vertexIdManager, internalNeighbors1, internalNeighbors2)
-}
-
-func (g *SortledtonGraph) getVertex(internalId int) *Vertex {
-	g.readWriteMu.RLock()
-	vertex, exists := g.vertices[internalId]
-	g.readWriteMu.RUnlock()
-	if !exists {
-		return nil
-	}
-	return vertex
-}
+	start = time.Now()
+	snapshot := graph.Snapshot()
+	timed(fmt.Sprintf("Snapshot (%d vertices, %d edges)", snapshot.NumVertices(), snapshot.NumEdges()), start)
 
-func intersectInternalIdsToExternal(vm *VertexIdManager, a, b []int) []int {
-	var intersection []int
-	i, j := 0, 0
-	for i < len(a) && j < len(b) {
-		if a[i] == b[j] {
-			externalId := vm.getExternalId(a[i])
-			intersection = append(intersection, externalId)
-			i++
-			j++
-		} else if a[i] < b[j] {
-			i++
-		} else {
-			j++
+	start = time.Now()
+	ranks := snapshot.PageRank(0.85, 50, 1e-6)
+	top, topRank := -1, 0.0
+	for id, rank := range ranks {
+		if rank > topRank {
+			top, topRank = id, rank
 		}
 	}
-	return intersection
-}
-
-func (g *SortledtonGraph) garbageCollect() {
-	g.readWriteMu.Lock()
-	defer g.readWriteMu.Unlock()
+	timed(fmt.Sprintf("PageRank (top vertex %d: %.6f)", top, topRank), start)
 
-	oldestActiveTransactionTimestamp := int64(0) // Placeholder
+	start = time.Now()
+	triangles := snapshot.TriangleCount()
+	timed(fmt.Sprintf("Triangle counting (%d triangles)", triangles), start)
 
-	for _, vertex := range g.vertices {
-		vertex.mu.Lock()
-		adjacencyList := vertex.adjacencyList
-		currentBlock := adjacencyList.head
-		var prevBlock *EdgeBlock = nil
-		for currentBlock != nil {
-			currentBlock.mu.Lock()
-			newEdges := currentBlock.edges[:0]
-			for _, edge := range currentBlock.edges {
-				if edge.version < oldestActiveTransactionTimestamp && edge.isDeleted {
-					continue
-				}
-				newEdges = append(newEdges, edge)
-			}
-			currentBlock.edges = newEdges
-			if len(currentBlock.edges) == 0 {
-				if prevBlock != nil {
-					prevBlock.next = currentBlock.next
-				} else {
-					adjacencyList.head = currentBlock.next
-				}
-				nextBlock := currentBlock.next
-				currentBlock.mu.Unlock()
-				currentBlock = nextBlock
-				continue
-			}
-			prevBlock = currentBlock
-			nextBlock := currentBlock.next
-			currentBlock.mu.Unlock()
-			currentBlock = nextBlock
-		}
-		vertex.mu.Unlock()
+	start = time.Now()
+	components := make(map[int]bool)
+	for _, component := range snapshot.WeaklyConnectedComponents() {
+		components[component] = true
 	}
+	timed(fmt.Sprintf("Weakly connected components (%d components)", len(components)), start)
 }
 
-func (g *SortledtonGraph) StartGarbageCollector(interval time.Duration) {
-	go func() {
-		for {
-			time.Sleep(interval)
-			g.garbageCollect()
-		}
-	}()
-}
-
-func main() {
-	graph := NewSortledtonGraph()
-
-	// Insert edges
-	graph.insertEdge(1, 2)
-	graph.insertEdge(1, 3)
-	graph.insertEdge(2, 3)
-
-	// Delete edge
-	graph.deleteEdge(1, 2)
-
-	// Retrieve neighbors
-	neighborsOf1 := graph.getNeighbors(1)
-	fmt.Println("Neighbors of 1:", neighborsOf1) // Should return [3]
-
-	// Intersect neighbors
-	commonNeighbors := graph.intersectNeighbors(1, 2)
-	fmt.Println("Common neighbors of 1 and 2:", commonNeighbors) // Should return [3]
+func timed(name string, start time.Time) {
+	fmt.Printf("%-50s %v\n", name, time.Since(start).Round(time.Microsecond))
 }
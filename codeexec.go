@@ -0,0 +1,356 @@
+// manifold/codeexec.go
+
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	defaultCodeExecTimeout  = 10 * time.Second
+	defaultCodeExecOutputKB = 64
+	defaultCodeExecSnippets = 3
+	codeExecFileSizeKB      = 10 << 10
+)
+
+// codeBlockPattern matches fenced code blocks with a language tag.
+var codeBlockPattern = regexp.MustCompile("(?s)```([A-Za-z0-9_+-]+)[^\\n]*\\n(.*?)```")
+
+// codeExecLanguage is how snippets of a language are stored and run.
+type codeExecLanguage struct {
+	file string
+	run  []string // the snippet's path is appended
+}
+
+var codeExecLanguages = map[string]codeExecLanguage{
+	"python":     {file: "main.py", run: []string{"python3"}},
+	"go":         {file: "main.go", run: []string{"go", "run"}},
+	"javascript": {file: "main.js", run: []string{"node"}},
+}
+
+var codeExecAliases = map[string]string{
+	"py":      "python",
+	"python3": "python",
+	"golang":  "go",
+	"js":      "javascript",
+	"node":    "javascript",
+}
+
+// CodeSnippet is a fenced code block taken from the prompt.
+type CodeSnippet struct {
+	Language string
+	Code     string
+}
+
+// CodeExecResult is the outcome of running a snippet.
+type CodeExecResult struct {
+	Stdout    string
+	Stderr    string
+	ExitCode  int
+	Truncated bool
+}
+
+// CodeExecTool runs the Python, Go and JavaScript snippets in the prompt and hands their output to
+// the model, so it can reason over real results. Snippets run either as local subprocesses with
+// rlimits, a private temp dir and, on Linux, an empty network namespace, or inside a sandbox
+// container that is started as an ExternalService.
+type CodeExecTool struct {
+	enabled     bool
+	languages   map[string]bool
+	timeout     time.Duration
+	memoryMB    int
+	maxOutputKB int
+	maxSnippets int
+	image       string // sandbox container image, local subprocesses when empty
+
+	mu        sync.Mutex
+	container *ExternalService
+	name      string
+}
+
+// Process runs the snippets found in the input and returns their output.
+func (t *CodeExecTool) Process(ctx context.Context, input string) (string, error) {
+	snippets := extractSnippets(input)
+	if len(snippets) == 0 {
+		return "", nil
+	}
+
+	var out strings.Builder
+	out.WriteString("Code execution results:\n")
+	ran := 0
+	for _, snippet := range snippets {
+		if !t.languages[snippet.Language] {
+			continue
+		}
+		if ran == t.maxSnippets {
+			break
+		}
+		ran++
+
+		result, err := t.run(ctx, snippet)
+		if err != nil {
+			fmt.Fprintf(&out, "\n[%s snippet %d] failed to run: %v\n", snippet.Language, ran, err)
+			continue
+		}
+		fmt.Fprintf(&out, "\n[%s snippet %d] exit code %d\n", snippet.Language, ran, result.ExitCode)
+		if result.Stdout != "" {
+			fmt.Fprintf(&out, "stdout:\n%s\n", result.Stdout)
+		}
+		if result.Stderr != "" {
+			fmt.Fprintf(&out, "stderr:\n%s\n", result.Stderr)
+		}
+		if result.Truncated {
+			out.WriteString("(output truncated)\n")
+		}
+	}
+
+	if ran == 0 {
+		return "", nil
+	}
+	return out.String(), nil
+}
+
+// Relevant reports whether the prompt has code blocks to run.
+func (t *CodeExecTool) Relevant(prompt string) bool {
+	return len(extractSnippets(prompt)) > 0
+}
+
+// Enabled returns the enabled status of the tool.
+func (t *CodeExecTool) Enabled() bool {
+	return t.enabled
+}
+
+// SetParams configures the tool with provided parameters.
+func (t *CodeExecTool) SetParams(params map[string]interface{}, config *Config) error {
+	if enabled, ok := params["enabled"].(bool); ok {
+		t.enabled = enabled
+	}
+
+	t.languages = map[string]bool{"python": true, "go": true, "javascript": true}
+	if langs, ok := params["languages"]; ok {
+		t.languages = make(map[string]bool)
+		for _, lang := range interfaceToStringSlice(langs) {
+			if alias, ok := codeExecAliases[lang]; ok {
+				lang = alias
+			}
+			if _, ok := codeExecLanguages[lang]; !ok {
+				return fmt.Errorf("unsupported language %q", lang)
+			}
+			t.languages[lang] = true
+		}
+	}
+
+	t.timeout = defaultCodeExecTimeout
+	if seconds, ok := params["timeout_seconds"].(int); ok && seconds > 0 {
+		t.timeout = time.Duration(seconds) * time.Second
+	}
+	t.maxOutputKB = defaultCodeExecOutputKB
+	if kb, ok := params["max_output_kb"].(int); ok && kb > 0 {
+		t.maxOutputKB = kb
+	}
+	t.maxSnippets = defaultCodeExecSnippets
+	if n, ok := params["max_snippets"].(int); ok && n > 0 {
+		t.maxSnippets = n
+	}
+	if mb, ok := params["memory_mb"].(int); ok {
+		t.memoryMB = mb
+	}
+	if image, ok := params["image"].(string); ok {
+		t.image = image
+	}
+
+	if t.image == "" && !networkIsolationAvailable {
+		return fmt.Errorf("codeexec needs a sandbox image on this platform, local snippets cannot be cut off from the network")
+	}
+	return nil
+}
+
+// GetParams returns the tool's parameters.
+func (t *CodeExecTool) GetParams() map[string]interface{} {
+	var languages []string
+	for lang := range t.languages {
+		languages = append(languages, lang)
+	}
+	return map[string]interface{}{
+		"enabled":         t.enabled,
+		"languages":       languages,
+		"timeout_seconds": int(t.timeout.Seconds()),
+		"memory_mb":       t.memoryMB,
+		"max_output_kb":   t.maxOutputKB,
+		"max_snippets":    t.maxSnippets,
+		"image":           t.image,
+	}
+}
+
+// extractSnippets returns the fenced code blocks of the supported languages, in order.
+func extractSnippets(input string) []CodeSnippet {
+	var snippets []CodeSnippet
+	for _, match := range codeBlockPattern.FindAllStringSubmatch(input, -1) {
+		lang := strings.ToLower(match[1])
+		if alias, ok := codeExecAliases[lang]; ok {
+			lang = alias
+		}
+		if _, ok := codeExecLanguages[lang]; !ok {
+			continue
+		}
+		snippets = append(snippets, CodeSnippet{Language: lang, Code: match[2]})
+	}
+	return snippets
+}
+
+// run executes one snippet in the configured sandbox.
+func (t *CodeExecTool) run(ctx context.Context, snippet CodeSnippet) (result CodeExecResult, err error) {
+	span, ctx := startSpan(ctx, "codeexec.run")
+	span.SetTag("language", snippet.Language)
+	defer func() { finishSpan(span, err) }()
+
+	ctx, cancel := context.WithTimeout(ctx, t.timeout)
+	defer cancel()
+
+	lang := codeExecLanguages[snippet.Language]
+	stdout := &limitedBuffer{max: t.maxOutputKB << 10}
+	stderr := &limitedBuffer{max: t.maxOutputKB << 10}
+
+	var cmd *exec.Cmd
+	if t.image != "" {
+		if cmd, err = t.containerCommand(ctx, lang, snippet); err != nil {
+			return result, err
+		}
+	} else {
+		dir, err := os.MkdirTemp("", "manifold-codeexec-")
+		if err != nil {
+			return result, err
+		}
+		defer os.RemoveAll(dir)
+		if cmd, err = t.localCommand(ctx, dir, lang, snippet); err != nil {
+			return result, err
+		}
+	}
+	cmd.Stdout = stdout
+	cmd.Stderr = stderr
+	cmd.WaitDelay = time.Second
+
+	err = cmd.Run()
+	result = CodeExecResult{
+		Stdout:    stdout.buf.String(),
+		Stderr:    stderr.buf.String(),
+		Truncated: stdout.truncated || stderr.truncated,
+	}
+	if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		return result, fmt.Errorf("timed out after %s", t.timeout)
+	}
+
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		result.ExitCode = exitErr.ExitCode()
+		return result, nil
+	}
+	return result, err
+}
+
+// localCommand runs a snippet as a subprocess of manifold in dir, with CPU, file size and optional
+// memory rlimits, a minimal environment and no network.
+func (t *CodeExecTool) localCommand(ctx context.Context, dir string, lang codeExecLanguage, snippet CodeSnippet) (*exec.Cmd, error) {
+	path := filepath.Join(dir, lang.file)
+	if err := os.WriteFile(path, []byte(snippet.Code), 0644); err != nil {
+		return nil, err
+	}
+
+	limits := fmt.Sprintf("ulimit -t %d; ulimit -f %d;", int(t.timeout.Seconds())+1, codeExecFileSizeKB)
+	if t.memoryMB > 0 {
+		limits += fmt.Sprintf(" ulimit -v %d;", t.memoryMB<<10)
+	}
+
+	args := append([]string{"-c", limits + ` exec "$@"`, "sh"}, lang.run...)
+	cmd := exec.CommandContext(ctx, "sh", append(args, path)...)
+	cmd.Dir = dir
+	cmd.Env = []string{
+		"PATH=" + os.Getenv("PATH"),
+		"HOME=" + dir,
+		"TMPDIR=" + dir,
+		"GOCACHE=" + filepath.Join(os.TempDir(), "manifold-codeexec-gocache"),
+		"GOPATH=" + filepath.Join(dir, "go"),
+		"GOFLAGS=-mod=mod",
+		"GOTOOLCHAIN=local",
+	}
+	cmd.SysProcAttr = sandboxSysProcAttr()
+	return cmd, nil
+}
+
+// containerCommand runs a snippet inside the sandbox container, starting it on first use.
+func (t *CodeExecTool) containerCommand(ctx context.Context, lang codeExecLanguage, snippet CodeSnippet) (*exec.Cmd, error) {
+	if err := t.ensureContainer(); err != nil {
+		return nil, err
+	}
+
+	// Every snippet gets its own directory in the container's tmpfs. Canceling the context only kills
+	// the docker CLI, so the snippet is killed inside the container by timeout.
+	dir := "/sandbox/" + newSessionID()
+	script := fmt.Sprintf(`mkdir -p %[1]s && cd %[1]s && cat > %[2]s && timeout -s KILL %[4]d %[3]s %[2]s; code=$?; rm -rf %[1]s; exit $code`,
+		dir, lang.file, strings.Join(lang.run, " "), int(t.timeout.Seconds())+1)
+
+	cmd := exec.CommandContext(ctx, "docker", "exec", "-i", "-e", "HOME="+dir, t.name, "sh", "-c", script)
+	cmd.Stdin = strings.NewReader(snippet.Code)
+	return cmd, nil
+}
+
+// ensureContainer starts the sandbox container as a supervised ExternalService. It has no network,
+// a read-only root filesystem and capped memory, CPU and processes.
+func (t *CodeExecTool) ensureContainer() error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.container != nil {
+		return nil
+	}
+
+	t.name = "manifold-codeexec"
+	args := []string{
+		"run", "--rm", "--name", t.name,
+		"--network", "none",
+		"--read-only",
+		"--tmpfs", "/sandbox:rw,exec,size=256m",
+		"--pids-limit", "128",
+		"--cpus", "1",
+		"--security-opt", "no-new-privileges",
+	}
+	if t.memoryMB > 0 {
+		args = append(args, "--memory", fmt.Sprintf("%dm", t.memoryMB))
+	}
+	args = append(args, t.image, "sleep", "infinity")
+
+	service := NewExternalService(ServiceConfig{Name: t.name, Command: "docker", Args: args}, false)
+	if err := service.Start(context.Background()); err != nil {
+		return fmt.Errorf("failed to start sandbox container: %w", err)
+	}
+
+	// docker run returns before the container accepts exec calls
+	deadline := time.Now().Add(30 * time.Second)
+	for time.Now().Before(deadline) {
+		if exec.Command("docker", "exec", t.name, "true").Run() == nil {
+			supervisor.Register("codeexec", service)
+			t.container = service
+			return nil
+		}
+		time.Sleep(500 * time.Millisecond)
+	}
+
+	// The next snippet starts it again from scratch
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := service.Stop(ctx); err != nil {
+		slog.Warn("failed to stop sandbox container", "container", t.name, "error", err)
+	}
+	exec.Command("docker", "rm", "-f", t.name).Run()
+	return fmt.Errorf("sandbox container %s did not start", t.name)
+}
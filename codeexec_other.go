@@ -0,0 +1,15 @@
+// manifold/codeexec_other.go
+
+//go:build !linux
+
+package main
+
+import "syscall"
+
+// networkIsolationAvailable reports whether local snippets can run without network access.
+const networkIsolationAvailable = false
+
+// sandboxSysProcAttr has no namespaces to offer outside Linux, snippets need the sandbox container.
+func sandboxSysProcAttr() *syscall.SysProcAttr {
+	return nil
+}
@@ -0,0 +1,46 @@
+// codeexec_test.go
+package main
+
+import (
+	"context"
+	"os/exec"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestExtractSnippets(t *testing.T) {
+	input := "Compute this:\n```py\nprint(1 + 1)\n```\nand\n```bash\nrm -rf /\n```\n```JavaScript\nconsole.log(2)\n```"
+
+	snippets := extractSnippets(input)
+	assert.Equal(t, []CodeSnippet{
+		{Language: "python", Code: "print(1 + 1)\n"},
+		{Language: "javascript", Code: "console.log(2)\n"},
+	}, snippets)
+}
+
+func TestCodeExecToolLocal(t *testing.T) {
+	if !networkIsolationAvailable {
+		t.Skip("local snippets need network namespaces")
+	}
+	if _, err := exec.LookPath("python3"); err != nil {
+		t.Skip("python3 not installed")
+	}
+	if exec.Command("unshare", "-rn", "true").Run() != nil {
+		t.Skip("user namespaces are not available")
+	}
+
+	tool := &CodeExecTool{}
+	require.NoError(t, tool.SetParams(map[string]interface{}{"enabled": true, "languages": []interface{}{"python"}}, nil))
+
+	out, err := tool.Process(context.Background(), "```python\nimport socket\nprint(6 * 7)\ntry:\n    socket.create_connection(('1.1.1.1', 53), timeout=1)\nexcept OSError:\n    print('offline')\n```")
+	require.NoError(t, err)
+	assert.Contains(t, out, "exit code 0")
+	assert.Contains(t, out, "42")
+	assert.Contains(t, out, "offline")
+
+	out, err = tool.Process(context.Background(), "```python\nraise SystemExit(3)\n```")
+	require.NoError(t, err)
+	assert.Contains(t, out, "exit code 3")
+}
@@ -0,0 +1,313 @@
+// manifold/coderag.go
+
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"manifold/internal/coderag"
+)
+
+const (
+	defaultCodeRAGTopK      = 5
+	defaultCodeRAGCallDepth = 2
+	codeRAGMaxCodeBytes     = 4000
+	codeRAGWatchDebounce    = 2 * time.Second
+)
+
+var (
+	// codeQuestionPattern detects prompts about code.
+	codeQuestionPattern = regexp.MustCompile(`(?i)\b(func(tion)?s?|methods?|code|calls?|callers?|called|implement(s|ed|ation)?|interfaces?|structs?|packages?|refactor\w*|repo(sitory)?|codebase)\b`)
+
+	// identifierPattern matches words that look like Go identifiers rather than prose, e.g.
+	// GetFunctionInfo, CodeIndex.Search or handleChat().
+	identifierPattern = regexp.MustCompile(`\b[A-Za-z_]\w*(?:\.[A-Za-z_]\w*)+\b|\b[a-z]+[A-Z]\w*\b|\b[A-Z][a-z0-9]+[A-Z]\w*\b|\b\w+\(\)`)
+)
+
+// codeIndex is the index of a repository, shared by the coderag tools configured for it so
+// toggling the tool doesn't index the repository again.
+type codeIndex struct {
+	index  *coderag.CodeIndex
+	ready  atomic.Bool // the first indexing is done
+	failed atomic.Bool // the first indexing failed, the index is opened again on next use
+}
+
+var (
+	codeIndexesMu sync.Mutex
+	codeIndexes   = make(map[string]*codeIndex)
+)
+
+// openCodeIndex returns the index of the repository at repoPath, loading it from the store and
+// bringing it up to date in the background the first time. With watch, the index follows the
+// changes of the repository afterwards. An index whose first indexing failed is forgotten so the
+// next call tries again.
+func openCodeIndex(repoPath, storePath string, cfg *coderag.Config, watch bool) (*codeIndex, error) {
+	codeIndexesMu.Lock()
+	defer codeIndexesMu.Unlock()
+
+	if state, ok := codeIndexes[repoPath]; ok {
+		return state, nil
+	}
+
+	store, err := coderag.OpenStore(storePath)
+	if err != nil {
+		return nil, err
+	}
+	index, err := store.OpenCodeIndex(repoPath)
+	if err != nil {
+		store.Close()
+		return nil, err
+	}
+
+	state := &codeIndex{index: index}
+	codeIndexes[repoPath] = state
+	go func() {
+		slog.Info("indexing code repository", "repo", repoPath)
+		if err := index.IndexRepository(repoPath, cfg); err != nil {
+			slog.Error("failed to index code repository", "repo", repoPath, "error", err)
+			codeIndexesMu.Lock()
+			if codeIndexes[repoPath] == state {
+				delete(codeIndexes, repoPath)
+			}
+			codeIndexesMu.Unlock()
+			store.Close()
+			state.failed.Store(true)
+			return
+		}
+		state.ready.Store(true)
+		slog.Info("code repository indexed", "repo", repoPath, "functions", index.FunctionCount())
+
+		if watch {
+			if err := index.Watch(context.Background(), repoPath, cfg, codeRAGWatchDebounce); err != nil {
+				slog.Error("failed to watch code repository", "repo", repoPath, "error", err)
+			}
+		}
+	}()
+	return state, nil
+}
+
+// CodeRAGTool answers questions about the code of a repository in the chat. It indexes the
+// functions of the configured Go module, with their calls and optionally LLM summaries and
+// embeddings, and adds the function the prompt names with its call tree, and the functions most
+// relevant to the prompt, to the context.
+type CodeRAGTool struct {
+	enabled   bool
+	repoPath  string
+	storePath string
+	watch     bool
+	topK      int
+	callDepth int
+	cfg       *coderag.Config
+
+	mu    sync.Mutex
+	state *codeIndex
+}
+
+// Process returns the code context of the input, nothing while the repository is being indexed.
+func (t *CodeRAGTool) Process(ctx context.Context, input string) (string, error) {
+	logger := loggerFromContext(ctx)
+	index := t.readyIndex()
+	if index == nil {
+		logger.Debug("code index not ready", "repo", t.repoPath)
+		return "", nil
+	}
+
+	var out strings.Builder
+	shown := make(map[string]bool)
+	if relationship, err := index.HandleUserPrompt(input); err == nil {
+		fn, err := index.GetFunctionInfo(relationship.FunctionName)
+		if err == nil {
+			writeCodeFunction(&out, fn)
+			shown[fn.ID] = true
+
+			fmt.Fprintf(&out, "\nCall tree of %s:\n", fn.Name)
+			writeCallTree(&out, index, fn.ID, 0, t.callDepth, map[string]bool{})
+			if len(fn.CalledBy) > 0 {
+				fmt.Fprintf(&out, "\nCalled by: %s\n", strings.Join(fn.CalledBy, ", "))
+			}
+		}
+	} else {
+		logger.Debug("no function named in the prompt", "error", err)
+	}
+
+	results, err := index.Search(input, t.topK)
+	if err != nil {
+		logger.Debug("code search failed", "error", err)
+	}
+	for _, result := range results {
+		if !shown[result.Function.ID] {
+			writeCodeFunction(&out, result.Function)
+			shown[result.Function.ID] = true
+		}
+	}
+
+	if out.Len() == 0 {
+		return "", nil
+	}
+	return fmt.Sprintf("Code from the repository %s:\n%s", t.repoPath, out.String()), nil
+}
+
+// writeCodeFunction writes the location, summary and code of a function.
+func writeCodeFunction(out *strings.Builder, fn *coderag.FunctionInfo) {
+	fmt.Fprintf(out, "\n%s %s (%s:%d)\n", fn.Type, fn.Name, fn.FilePath, fn.LineNumber)
+	if fn.Summary != "" {
+		fmt.Fprintf(out, "Summary: %s\n", fn.Summary)
+	}
+	code := fn.Code
+	if len(code) > codeRAGMaxCodeBytes {
+		code = code[:codeRAGMaxCodeBytes] + "\n// ... truncated"
+	}
+	fmt.Fprintf(out, "```go\n%s\n```\n", strings.TrimRight(code, "\n"))
+}
+
+// writeCallTree writes the functions a function calls, down to maxDepth levels. Functions outside
+// the repository are leaves, functions already shown aren't expanded again.
+func writeCallTree(out *strings.Builder, index *coderag.CodeIndex, id string, depth, maxDepth int, seen map[string]bool) {
+	fn, err := index.GetFunctionInfo(id)
+	if err != nil || fn.ID != id {
+		fmt.Fprintf(out, "%s- %s (external)\n", strings.Repeat("  ", depth), id)
+		return
+	}
+	fmt.Fprintf(out, "%s- %s\n", strings.Repeat("  ", depth), fn.Name)
+	if seen[id] || depth >= maxDepth {
+		return
+	}
+	seen[id] = true
+	for _, called := range fn.Calls {
+		writeCallTree(out, index, called, depth+1, maxDepth, seen)
+	}
+}
+
+// Relevant reports whether the prompt asks about code or names a function of the repository.
+func (t *CodeRAGTool) Relevant(prompt string) bool {
+	if codeQuestionPattern.MatchString(prompt) {
+		return true
+	}
+	index := t.readyIndex()
+	if index == nil {
+		return false
+	}
+	for _, name := range identifierPattern.FindAllString(prompt, -1) {
+		name = strings.TrimSuffix(name, "()")
+		// Package qualified names, e.g. store.Open, are indexed by the function name
+		if _, err := index.GetFunctionInfo(name); err == nil {
+			return true
+		}
+		if _, err := index.GetFunctionInfo(name[strings.LastIndex(name, ".")+1:]); err == nil {
+			return true
+		}
+	}
+	return false
+}
+
+// readyIndex returns the index once the repository is indexed, or nil. An index whose first
+// indexing failed is opened again.
+func (t *CodeRAGTool) readyIndex() *coderag.CodeIndex {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.state != nil && t.state.failed.Load() {
+		state, err := openCodeIndex(t.repoPath, t.storePath, t.cfg, t.watch)
+		if err != nil {
+			slog.Error("failed to open the code index", "repo", t.repoPath, "error", err)
+			return nil
+		}
+		t.state = state
+	}
+	if t.state == nil || !t.state.ready.Load() {
+		return nil
+	}
+	return t.state.index
+}
+
+// Enabled returns the enabled status of the tool.
+func (t *CodeRAGTool) Enabled() bool {
+	return t.enabled
+}
+
+// SetParams configures the tool with provided parameters and starts indexing the repository.
+func (t *CodeRAGTool) SetParams(params map[string]interface{}, config *Config) error {
+	if enabled, ok := params["enabled"].(bool); ok {
+		t.enabled = enabled
+	}
+	if config == nil {
+		config = &Config{}
+	}
+
+	t.repoPath = ""
+	if repoPath, ok := params["repo_path"].(string); ok && repoPath != "" {
+		abs, err := filepath.Abs(expandHome(repoPath))
+		if err != nil {
+			return fmt.Errorf("invalid repo_path %s: %w", repoPath, err)
+		}
+		t.repoPath = abs
+	}
+
+	t.storePath = filepath.Join(expandHome(config.DataPath), "coderag.db")
+	if storePath, ok := params["store_path"].(string); ok && storePath != "" {
+		t.storePath = expandHome(storePath)
+	}
+	t.watch = true
+	if watch, ok := params["watch"].(bool); ok {
+		t.watch = watch
+	}
+	t.topK = defaultCodeRAGTopK
+	if k, ok := params["top_k"].(int); ok && k > 0 {
+		t.topK = k
+	}
+	t.callDepth = defaultCodeRAGCallDepth
+	if depth, ok := params["call_depth"].(int); ok && depth >= 0 {
+		t.callDepth = depth
+	}
+
+	t.cfg = &coderag.Config{OpenAIAPIKey: config.OpenAIAPIKey}
+	t.cfg.OpenAIEndpoint, _ = params["summary_endpoint"].(string)
+	t.cfg.OpenAIModel, _ = params["summary_model"].(string)
+	t.cfg.EmbeddingsEndpoint, _ = params["embeddings_endpoint"].(string)
+	t.cfg.EmbeddingsModel, _ = params["embeddings_model"].(string)
+	if workers, ok := params["summary_workers"].(int); ok {
+		t.cfg.SummaryWorkers = workers
+	}
+
+	if !t.enabled {
+		return nil
+	}
+	if t.repoPath == "" {
+		return errors.New("coderag needs a repo_path")
+	}
+	state, err := openCodeIndex(t.repoPath, t.storePath, t.cfg, t.watch)
+	if err != nil {
+		return fmt.Errorf("failed to open the code index of %s: %w", t.repoPath, err)
+	}
+	t.state = state
+	return nil
+}
+
+// GetParams returns the tool's parameters.
+func (t *CodeRAGTool) GetParams() map[string]interface{} {
+	params := map[string]interface{}{
+		"enabled":    t.enabled,
+		"repo_path":  t.repoPath,
+		"store_path": t.storePath,
+		"watch":      t.watch,
+		"top_k":      t.topK,
+		"call_depth": t.callDepth,
+	}
+	if t.cfg != nil {
+		params["summary_endpoint"] = t.cfg.OpenAIEndpoint
+		params["summary_model"] = t.cfg.OpenAIModel
+		params["embeddings_endpoint"] = t.cfg.EmbeddingsEndpoint
+		params["embeddings_model"] = t.cfg.EmbeddingsModel
+		params["summary_workers"] = t.cfg.SummaryWorkers
+	}
+	return params
+}
@@ -0,0 +1,94 @@
+// coderag_test.go
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCodeRAGTool(t *testing.T) {
+	repo := t.TempDir()
+	files := map[string]string{
+		"go.mod": "module example.com/demo\n\ngo 1.22\n",
+		"store/store.go": `package store
+
+// Open opens the store.
+func Open() string { return load() }
+
+func load() string { return "data" }
+`,
+		"app/app.go": `package app
+
+import "example.com/demo/store"
+
+// Run starts the application.
+func Run() string { return store.Open() }
+`,
+	}
+	for name, content := range files {
+		require.NoError(t, os.MkdirAll(filepath.Dir(filepath.Join(repo, name)), 0755))
+		require.NoError(t, os.WriteFile(filepath.Join(repo, name), []byte(content), 0644))
+	}
+
+	tool := &CodeRAGTool{}
+	require.NoError(t, tool.SetParams(map[string]interface{}{
+		"enabled":    true,
+		"repo_path":  repo,
+		"store_path": filepath.Join(t.TempDir(), "coderag.db"),
+		"watch":      false,
+	}, &Config{DataPath: t.TempDir()}))
+	require.Eventually(t, tool.state.ready.Load, 30*time.Second, 50*time.Millisecond)
+
+	out, err := tool.Process(context.Background(), "What does the function Run do?")
+	require.NoError(t, err)
+	assert.Contains(t, out, "function Run ("+filepath.Join(repo, "app", "app.go")+":6)")
+	assert.Contains(t, out, "Call tree of Run:\n- Run\n  - Open\n    - load\n")
+
+	// Prompts without a function name get the functions matching their words
+	out, err = tool.Process(context.Background(), "where is the store opened?")
+	require.NoError(t, err)
+	assert.Contains(t, out, "// Open opens the store.\nfunc Open() string")
+
+	assert.True(t, tool.Relevant("why does store.Open fail?"))
+	assert.True(t, tool.Relevant("who calls load()"))
+	assert.False(t, tool.Relevant("what is the capital of France?"))
+
+	// Tools configured for the same repository share its index
+	other := &CodeRAGTool{}
+	require.NoError(t, other.SetParams(map[string]interface{}{"enabled": true, "repo_path": repo}, &Config{DataPath: t.TempDir()}))
+	assert.Same(t, tool.state, other.state)
+}
+
+func TestCodeRAGToolNeedsRepoPath(t *testing.T) {
+	tool := &CodeRAGTool{}
+	assert.Error(t, tool.SetParams(map[string]interface{}{"enabled": true}, &Config{}))
+	assert.NoError(t, tool.SetParams(map[string]interface{}{"enabled": false}, &Config{}))
+}
+
+func TestCodeRAGToolRetriesFailedIndexing(t *testing.T) {
+	// The first indexing fails while the repository is missing
+	repo := filepath.Join(t.TempDir(), "repo")
+	tool := &CodeRAGTool{}
+	require.NoError(t, tool.SetParams(map[string]interface{}{
+		"enabled":    true,
+		"repo_path":  repo,
+		"store_path": filepath.Join(t.TempDir(), "coderag.db"),
+		"watch":      false,
+	}, &Config{DataPath: t.TempDir()}))
+	failed := tool.state
+	require.Eventually(t, failed.failed.Load, 30*time.Second, 50*time.Millisecond)
+
+	require.NoError(t, os.MkdirAll(repo, 0755))
+	require.NoError(t, os.WriteFile(filepath.Join(repo, "go.mod"), []byte("module example.com/demo\n\ngo 1.22\n"), 0644))
+	require.NoError(t, os.WriteFile(filepath.Join(repo, "main.go"), []byte("package main\n\nfunc main() {}\n"), 0644))
+
+	// The next use indexes the repository again
+	require.Eventually(t, func() bool { return tool.readyIndex() != nil }, 30*time.Second, 50*time.Millisecond)
+	assert.NotSame(t, failed, tool.state)
+}
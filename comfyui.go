@@ -0,0 +1,505 @@
+// manifold/comfyui.go
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"html"
+	"io"
+	"log/slog"
+	"math/rand"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+const (
+	comfyUIRepo = "https://github.com/comfyanonymous/ComfyUI"
+
+	// comfyUIPollInterval is how often the history of a queued prompt is checked.
+	comfyUIPollInterval = 500 * time.Millisecond
+
+	// generatedImagesRoute serves the images saved under DataPath/tmp.
+	generatedImagesRoute = "/tmp"
+)
+
+// defaultComfyUIWorkflow is a text to image workflow in ComfyUI's API format. Workflows exported
+// from ComfyUI with "Save (API Format)" can replace it; they are templates with the same fields.
+const defaultComfyUIWorkflow = `{
+  "3": {
+    "class_type": "KSampler",
+    "inputs": {
+      "seed": {{.Seed}},
+      "steps": {{.Steps}},
+      "cfg": 7,
+      "sampler_name": "euler",
+      "scheduler": "normal",
+      "denoise": 1,
+      "model": ["4", 0],
+      "positive": ["6", 0],
+      "negative": ["7", 0],
+      "latent_image": ["5", 0]
+    }
+  },
+  "4": {
+    "class_type": "CheckpointLoaderSimple",
+    "inputs": {"ckpt_name": {{json .Checkpoint}}}
+  },
+  "5": {
+    "class_type": "EmptyLatentImage",
+    "inputs": {"width": {{.Width}}, "height": {{.Height}}, "batch_size": {{.BatchSize}}}
+  },
+  "6": {
+    "class_type": "CLIPTextEncode",
+    "inputs": {"text": {{json .Prompt}}, "clip": ["4", 1]}
+  },
+  "7": {
+    "class_type": "CLIPTextEncode",
+    "inputs": {"text": {{json .NegativePrompt}}, "clip": ["4", 1]}
+  },
+  "8": {
+    "class_type": "VAEDecode",
+    "inputs": {"samples": ["3", 0], "vae": ["4", 2]}
+  },
+  "9": {
+    "class_type": "SaveImage",
+    "inputs": {"filename_prefix": "manifold", "images": ["8", 0]}
+  }
+}`
+
+// ComfyUI states reported by /v1/images/status.
+const (
+	ComfyUIDisabled   = "disabled"
+	ComfyUIInstalling = "installing"
+	ComfyUIStarting   = "starting"
+	ComfyUIReady      = "ready"
+	ComfyUIFailed     = "failed"
+)
+
+// ComfyUIStatus is the state of the ComfyUI server, which can take minutes to install and start.
+type ComfyUIStatus struct {
+	Status string `json:"status"`
+	Error  string `json:"error,omitempty"`
+}
+
+var (
+	// comfyUIService is the ComfyUI server started when comfyui.enabled is set.
+	comfyUIService *ExternalService
+
+	// comfyUIMu guards comfyUIStatus.
+	comfyUIMu     sync.Mutex
+	comfyUIStatus = ComfyUIStatus{Status: ComfyUIDisabled}
+)
+
+// setComfyUIStatus records the state of the ComfyUI server and the error that made it fail.
+func setComfyUIStatus(status string, err error) {
+	comfyUIMu.Lock()
+	defer comfyUIMu.Unlock()
+	comfyUIStatus = ComfyUIStatus{Status: status}
+	if err != nil {
+		comfyUIStatus.Error = err.Error()
+	}
+}
+
+// getComfyUIStatus returns the state of the ComfyUI server.
+func getComfyUIStatus() ComfyUIStatus {
+	comfyUIMu.Lock()
+	defer comfyUIMu.Unlock()
+	return comfyUIStatus
+}
+
+// ImageGenerationRequest is the OpenAI-compatible image generation request body, with the
+// extra ComfyUI sampler settings.
+type ImageGenerationRequest struct {
+	Prompt         string `json:"prompt"`
+	NegativePrompt string `json:"negative_prompt,omitempty"`
+	N              int    `json:"n,omitempty"`
+	Size           string `json:"size,omitempty"` // WIDTHxHEIGHT
+	Steps          int    `json:"steps,omitempty"`
+	Seed           int64  `json:"seed,omitempty"`
+}
+
+// GeneratedImage is an image saved under DataPath/tmp.
+type GeneratedImage struct {
+	URL string `json:"url"`
+}
+
+// workflowParams are the fields available to a workflow template.
+type workflowParams struct {
+	Prompt         string
+	NegativePrompt string
+	Checkpoint     string
+	Width          int
+	Height         int
+	Steps          int
+	Seed           int64
+	BatchSize      int
+}
+
+// comfyUIImage is an output image in ComfyUI's history.
+type comfyUIImage struct {
+	Filename  string `json:"filename"`
+	Subfolder string `json:"subfolder"`
+	Type      string `json:"type"`
+}
+
+// comfyUIBaseURL returns the address of the ComfyUI server.
+func comfyUIBaseURL(config *Config) string {
+	return fmt.Sprintf("http://%s:%d", config.ComfyUI.Service.Host, config.ComfyUI.Service.Port)
+}
+
+// setupComfyUI clones ComfyUI into the data path and installs its requirements, once.
+func setupComfyUI(dataPath string) (string, error) {
+	comfyDir := filepath.Join(dataPath, "ComfyUI")
+	if fileExists(filepath.Join(comfyDir, "main.py")) {
+		return comfyDir, nil
+	}
+
+	slog.Info("installing comfyui", "path", comfyDir)
+	cmd := exec.Command("git", "clone", "--depth", "1", comfyUIRepo, comfyDir)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	if err := cmd.Run(); err != nil {
+		return "", fmt.Errorf("failed to clone comfyui: %w", err)
+	}
+
+	if err := installPythonRequirements(filepath.Join(comfyDir, "requirements.txt")); err != nil {
+		return "", fmt.Errorf("failed to install comfyui requirements: %w", err)
+	}
+	return comfyDir, nil
+}
+
+// startComfyUIService launches ComfyUI, hands it to the supervisor and waits for it to answer.
+// Without a configured command ComfyUI is installed under the data path and run from there, which
+// takes minutes the first time, so it is meant to run in its own goroutine; its progress is
+// reported by getComfyUIStatus.
+func startComfyUIService(ctx context.Context, config *Config, verbose bool) {
+	if !config.ComfyUI.Enabled {
+		return
+	}
+
+	service := config.ComfyUI.Service
+	if service.Name == "" {
+		service.Name = "comfyui"
+	}
+	if service.Command == "" {
+		setComfyUIStatus(ComfyUIInstalling, nil)
+		comfyDir, err := setupComfyUI(config.DataPath)
+		if err != nil {
+			slog.Error("failed to set up comfyui", "error", err)
+			setComfyUIStatus(ComfyUIFailed, err)
+			return
+		}
+		service.Command = "python3"
+		service.Args = append([]string{
+			filepath.Join(comfyDir, "main.py"),
+			"--listen", service.Host,
+			"--port", strconv.Itoa(service.Port),
+		}, service.Args...)
+	}
+
+	setComfyUIStatus(ComfyUIStarting, nil)
+	comfyUIService = NewExternalService(service, verbose)
+	if err := comfyUIService.Start(ctx); err != nil {
+		slog.Error("failed to start comfyui", "service", service.Name, "error", err)
+		setComfyUIStatus(ComfyUIFailed, err)
+		return
+	}
+	supervisor.Register("comfyui", comfyUIService)
+
+	// ComfyUI loads its custom nodes before it listens
+	baseURL := comfyUIBaseURL(config)
+	for {
+		var stats map[string]interface{}
+		if err := comfyUIRequest(ctx, http.MethodGet, baseURL+"/system_stats", nil, &stats); err == nil {
+			break
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(comfyUIPollInterval):
+		}
+	}
+	setComfyUIStatus(ComfyUIReady, nil)
+	slog.Info("comfyui ready", "url", baseURL)
+}
+
+// renderWorkflow fills the configured workflow template, or the default one, with the request.
+func renderWorkflow(config *Config, params workflowParams) (map[string]interface{}, error) {
+	text := defaultComfyUIWorkflow
+	if config.ComfyUI.Workflow != "" {
+		data, err := os.ReadFile(config.ComfyUI.Workflow)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read workflow: %w", err)
+		}
+		text = string(data)
+	}
+
+	tmpl, err := template.New("workflow").Funcs(template.FuncMap{
+		"json": func(v interface{}) (string, error) {
+			b, err := json.Marshal(v)
+			return string(b), err
+		},
+	}).Parse(text)
+	if err != nil {
+		return nil, fmt.Errorf("invalid workflow template: %w", err)
+	}
+
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, params); err != nil {
+		return nil, fmt.Errorf("failed to render workflow: %w", err)
+	}
+
+	var workflow map[string]interface{}
+	if err := json.Unmarshal(buf.Bytes(), &workflow); err != nil {
+		return nil, fmt.Errorf("workflow is not valid json: %w", err)
+	}
+	return workflow, nil
+}
+
+// workflowParamsFor applies the configured defaults to a generation request.
+func workflowParamsFor(config *Config, req ImageGenerationRequest) (workflowParams, error) {
+	params := workflowParams{
+		Prompt:         req.Prompt,
+		NegativePrompt: req.NegativePrompt,
+		Checkpoint:     config.ComfyUI.Checkpoint,
+		Width:          512,
+		Height:         512,
+		Steps:          req.Steps,
+		Seed:           req.Seed,
+		BatchSize:      req.N,
+	}
+
+	if req.Size != "" {
+		w, h, ok := strings.Cut(req.Size, "x")
+		width, errW := strconv.Atoi(w)
+		height, errH := strconv.Atoi(h)
+		if !ok || errW != nil || errH != nil || width <= 0 || height <= 0 {
+			return params, fmt.Errorf("invalid size %q, expected WIDTHxHEIGHT", req.Size)
+		}
+		params.Width, params.Height = width, height
+	}
+	if params.Steps <= 0 {
+		params.Steps = config.ComfyUI.Steps
+	}
+	if params.Steps <= 0 {
+		params.Steps = 20
+	}
+	if params.Seed == 0 {
+		params.Seed = rand.Int63()
+	}
+	if params.BatchSize <= 0 {
+		params.BatchSize = 1
+	}
+	return params, nil
+}
+
+// generateImages queues the workflow on ComfyUI, waits for it to finish and saves the output
+// images under DataPath/tmp.
+func generateImages(ctx context.Context, config *Config, req ImageGenerationRequest) (images []GeneratedImage, err error) {
+	params, err := workflowParamsFor(config, req)
+	if err != nil {
+		return nil, err
+	}
+	workflow, err := renderWorkflow(config, params)
+	if err != nil {
+		return nil, err
+	}
+
+	span, ctx := startSpan(ctx, "comfyui.generate")
+	span.SetTag("steps", params.Steps)
+	span.SetTag("size", fmt.Sprintf("%dx%d", params.Width, params.Height))
+	defer func() { finishSpan(span, err) }()
+
+	baseURL := comfyUIBaseURL(config)
+
+	body, err := json.Marshal(map[string]interface{}{"prompt": workflow, "client_id": newSessionID()})
+	if err != nil {
+		return nil, err
+	}
+	var queued struct {
+		PromptID string `json:"prompt_id"`
+	}
+	if err := comfyUIRequest(ctx, http.MethodPost, baseURL+"/prompt", bytes.NewReader(body), &queued); err != nil {
+		return nil, err
+	}
+	span.SetTag("prompt_id", queued.PromptID)
+
+	outputs, err := waitForComfyUI(ctx, baseURL, queued.PromptID)
+	if err != nil {
+		return nil, err
+	}
+
+	tmpDir := filepath.Join(config.DataPath, "tmp")
+	if err := os.MkdirAll(tmpDir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create tmp directory: %w", err)
+	}
+
+	for i, output := range outputs {
+		name := fmt.Sprintf("comfyui-%s-%d%s", queued.PromptID, i, filepath.Ext(output.Filename))
+		if err := saveComfyUIImage(ctx, baseURL, output, filepath.Join(tmpDir, name)); err != nil {
+			return nil, err
+		}
+		images = append(images, GeneratedImage{URL: generatedImagesRoute + "/" + name})
+	}
+	return images, nil
+}
+
+// waitForComfyUI polls the history of a prompt until it has output images.
+func waitForComfyUI(ctx context.Context, baseURL, promptID string) ([]comfyUIImage, error) {
+	for {
+		var history map[string]struct {
+			Outputs map[string]struct {
+				Images []comfyUIImage `json:"images"`
+			} `json:"outputs"`
+			Status struct {
+				StatusStr string `json:"status_str"`
+			} `json:"status"`
+		}
+		if err := comfyUIRequest(ctx, http.MethodGet, baseURL+"/history/"+url.PathEscape(promptID), nil, &history); err != nil {
+			return nil, err
+		}
+
+		if entry, ok := history[promptID]; ok {
+			if entry.Status.StatusStr == "error" {
+				return nil, fmt.Errorf("comfyui failed to run prompt %s", promptID)
+			}
+			var images []comfyUIImage
+			for _, output := range entry.Outputs {
+				for _, image := range output.Images {
+					if image.Type == "output" {
+						images = append(images, image)
+					}
+				}
+			}
+			if len(images) == 0 {
+				return nil, fmt.Errorf("comfyui prompt %s produced no images", promptID)
+			}
+			return images, nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(comfyUIPollInterval):
+		}
+	}
+}
+
+// saveComfyUIImage downloads an output image from ComfyUI to path.
+func saveComfyUIImage(ctx context.Context, baseURL string, image comfyUIImage, path string) error {
+	query := url.Values{"filename": {image.Filename}, "subfolder": {image.Subfolder}, "type": {image.Type}}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, baseURL+"/view?"+query.Encode(), nil)
+	if err != nil {
+		return err
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("failed to fetch image %s: status %d", image.Filename, resp.StatusCode)
+	}
+
+	out, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(out, resp.Body)
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(path)
+	}
+	return err
+}
+
+// comfyUIRequest sends a request to ComfyUI and decodes the JSON response into out.
+func comfyUIRequest(ctx context.Context, method, url string, body io.Reader, out interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, method, url, body)
+	if err != nil {
+		return err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	injectSpan(ctx, req)
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("comfyui request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("comfyui request failed with status %d: %s", resp.StatusCode, msg)
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+// handleGetComfyUIStatus reports whether ComfyUI is installed and ready to generate images.
+func handleGetComfyUIStatus(c echo.Context) error {
+	return c.JSON(http.StatusOK, getComfyUIStatus())
+}
+
+// handleImageGeneration generates images from a prompt with ComfyUI. htmx requests from the chat
+// UI get the images as HTML, everything else the OpenAI response format.
+func handleImageGeneration(c echo.Context, config *Config) error {
+	if !config.ComfyUI.Enabled {
+		return c.JSON(http.StatusServiceUnavailable, map[string]string{"error": "Image generation is not configured"})
+	}
+	if status := getComfyUIStatus(); status.Status != ComfyUIReady {
+		if status.Status != ComfyUIFailed {
+			c.Response().Header().Set("Retry-After", strconv.Itoa(int(defaultRetryAfter.Seconds())))
+		}
+		return c.JSON(http.StatusServiceUnavailable, map[string]string{"error": "Image generation is " + status.Status})
+	}
+
+	var req ImageGenerationRequest
+	if err := c.Bind(&req); err != nil || req.Prompt == "" {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Prompt is required"})
+	}
+
+	timeout := time.Duration(config.ComfyUI.TimeoutSeconds) * time.Second
+	if timeout <= 0 {
+		timeout = 5 * time.Minute
+	}
+	ctx, cancel := context.WithTimeout(c.Request().Context(), timeout)
+	defer cancel()
+
+	images, err := generateImages(ctx, config, req)
+	if err != nil {
+		loggerFromContext(ctx).Error("image generation failed", "error", err)
+		return c.JSON(http.StatusBadGateway, map[string]string{"error": err.Error()})
+	}
+
+	if c.Request().Header.Get("HX-Request") == "true" {
+		var b strings.Builder
+		for _, image := range images {
+			fmt.Fprintf(&b, `<img src="%s" class="img-fluid rounded-2 my-2" alt="%s">`, html.EscapeString(image.URL), html.EscapeString(req.Prompt))
+		}
+		return c.HTML(http.StatusOK, b.String())
+	}
+
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"created": time.Now().Unix(),
+		"data":    images,
+	})
+}
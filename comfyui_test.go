@@ -0,0 +1,141 @@
+// comfyui_test.go
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRenderWorkflow(t *testing.T) {
+	config := &Config{ComfyUI: ComfyUIConfig{Checkpoint: "sd.safetensors"}}
+	params, err := workflowParamsFor(config, ImageGenerationRequest{Prompt: `a "quoted" cat`, Size: "768x512", Seed: 7})
+	require.NoError(t, err)
+
+	workflow, err := renderWorkflow(config, params)
+	require.NoError(t, err)
+
+	prompt := workflow["6"].(map[string]interface{})["inputs"].(map[string]interface{})
+	assert.Equal(t, `a "quoted" cat`, prompt["text"])
+	latent := workflow["5"].(map[string]interface{})["inputs"].(map[string]interface{})
+	assert.Equal(t, 768.0, latent["width"])
+	assert.Equal(t, 512.0, latent["height"])
+	sampler := workflow["3"].(map[string]interface{})["inputs"].(map[string]interface{})
+	assert.Equal(t, 7.0, sampler["seed"])
+	assert.Equal(t, 20.0, sampler["steps"])
+
+	_, err = workflowParamsFor(config, ImageGenerationRequest{Prompt: "cat", Size: "big"})
+	assert.Error(t, err)
+}
+
+func TestGenerateImages(t *testing.T) {
+	png := []byte("\x89PNG\r\n\x1a\n")
+	mux := http.NewServeMux()
+	mux.HandleFunc("/prompt", func(w http.ResponseWriter, r *http.Request) {
+		var body map[string]interface{}
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&body))
+		assert.Contains(t, body["prompt"], "9")
+		w.Write([]byte(`{"prompt_id":"abc"}`))
+	})
+	mux.HandleFunc("/history/abc", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"abc":{"outputs":{"9":{"images":[{"filename":"manifold_00001_.png","subfolder":"","type":"output"}]}}}}`))
+	})
+	mux.HandleFunc("/view", func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "manifold_00001_.png", r.URL.Query().Get("filename"))
+		w.Write(png)
+	})
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	host, port, err := net.SplitHostPort(server.Listener.Addr().String())
+	require.NoError(t, err)
+	portNum, _ := strconv.Atoi(port)
+
+	config := &Config{
+		DataPath: t.TempDir(),
+		ComfyUI:  ComfyUIConfig{Enabled: true, Service: ServiceConfig{Host: host, Port: portNum}},
+	}
+
+	images, err := generateImages(context.Background(), config, ImageGenerationRequest{Prompt: "a cat"})
+	require.NoError(t, err)
+	require.Len(t, images, 1)
+	assert.Equal(t, "/tmp/comfyui-abc-0.png", images[0].URL)
+
+	data, err := os.ReadFile(filepath.Join(config.DataPath, "tmp", "comfyui-abc-0.png"))
+	require.NoError(t, err)
+	assert.Equal(t, png, data)
+}
+
+func TestStartComfyUIServiceReportsReadiness(t *testing.T) {
+	var listening atomic.Bool
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/system_stats" || !listening.Load() {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.Write([]byte(`{"system": {}}`))
+	}))
+	defer server.Close()
+	host, port, err := net.SplitHostPort(server.Listener.Addr().String())
+	require.NoError(t, err)
+	portNum, _ := strconv.Atoi(port)
+
+	command := filepath.Join(t.TempDir(), "comfyui")
+	require.NoError(t, os.WriteFile(command, []byte("#!/bin/sh\nexec sleep 60\n"), 0o755))
+	config := &Config{ComfyUI: ComfyUIConfig{Enabled: true, Service: ServiceConfig{Host: host, Port: portNum, Command: command}}}
+
+	savedService, savedStatus, savedSupervisor := comfyUIService, getComfyUIStatus(), supervisor
+	supervisor = NewServiceSupervisor(SupervisorConfig{})
+	ctx, cancel := context.WithCancel(context.Background())
+	defer func() {
+		cancel()
+		comfyUIService, supervisor = savedService, savedSupervisor
+		setComfyUIStatus(savedStatus.Status, nil)
+	}()
+
+	generate := func() *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/v1/images/generations", strings.NewReader(`{"prompt": "a cat"}`))
+		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		rec := httptest.NewRecorder()
+		require.NoError(t, handleImageGeneration(echo.New().NewContext(req, rec), config))
+		return rec
+	}
+
+	setComfyUIStatus(ComfyUIDisabled, nil)
+	go startComfyUIService(ctx, config, false)
+	assert.Eventually(t, func() bool { return getComfyUIStatus().Status == ComfyUIStarting }, 5*time.Second, 10*time.Millisecond)
+
+	// Requests are turned away until ComfyUI answers
+	rec := generate()
+	assert.Equal(t, http.StatusServiceUnavailable, rec.Code)
+	assert.Equal(t, "5", rec.Header().Get("Retry-After"))
+	assert.Contains(t, rec.Body.String(), "starting")
+
+	listening.Store(true)
+	assert.Eventually(t, func() bool { return getComfyUIStatus().Status == ComfyUIReady }, 5*time.Second, 10*time.Millisecond)
+	rec = httptest.NewRecorder()
+	require.NoError(t, handleGetComfyUIStatus(echo.New().NewContext(httptest.NewRequest(http.MethodGet, "/v1/images/status", nil), rec)))
+	assert.JSONEq(t, `{"status": "ready"}`, rec.Body.String())
+	assert.Len(t, supervisor.Statuses(), 1)
+
+	// A failed start is reported with its error
+	setComfyUIStatus(ComfyUIFailed, errors.New("failed to clone comfyui"))
+	rec = generate()
+	assert.Equal(t, http.StatusServiceUnavailable, rec.Code)
+	assert.Empty(t, rec.Header().Get("Retry-After"))
+	assert.Equal(t, "failed to clone comfyui", getComfyUIStatus().Error)
+}
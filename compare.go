@@ -0,0 +1,331 @@
+// manifold/compare.go
+
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/gorilla/websocket"
+	"github.com/labstack/echo/v4"
+	"gorm.io/gorm"
+
+	"manifold/internal/web"
+)
+
+// CompareBackend is one of the two models answering in comparison mode.
+type CompareBackend struct {
+	Name     string `yaml:"name" json:"name"`                             // shown above its response and stored as its model
+	Service  string `yaml:"service,omitempty" json:"service,omitempty"`   // name of a service in the services section that serves the model
+	Endpoint string `yaml:"endpoint,omitempty" json:"endpoint,omitempty"` // OpenAI compatible base URL, the chat backend when this and service are empty
+	Model    string `yaml:"model,omitempty" json:"model,omitempty"`
+	APIKey   string `yaml:"api_key,omitempty" json:"-"`
+}
+
+// CompareConfig configures the comparison mode of the chat, which sends the same prompt to two
+// backends and streams both responses side by side.
+type CompareConfig struct {
+	Enabled  bool             `yaml:"enabled"`
+	Backends []CompareBackend `yaml:"backends,omitempty"` // exactly two
+}
+
+// CompareResult is the response of one backend of a comparison.
+type CompareResult struct {
+	Name    string
+	Content string
+	Err     error
+}
+
+// Comparison streams a prompt to two backends at once.
+type Comparison struct {
+	backends []CompareBackend
+	clients  []LLMClient // nil entries use the chat backend
+}
+
+// NewComparison creates the comparison of the configured backends, resolving the services that
+// serve them.
+func NewComparison(config CompareConfig, services []ServiceConfig) (*Comparison, error) {
+	if len(config.Backends) != 2 {
+		return nil, fmt.Errorf("compare needs exactly two backends, got %d", len(config.Backends))
+	}
+	if config.Backends[0].Name == "" || config.Backends[0].Name == config.Backends[1].Name {
+		return nil, errors.New("compare backends need distinct names")
+	}
+
+	cmp := &Comparison{backends: config.Backends}
+	for _, backend := range config.Backends {
+		// Backends are resolved the same way as the agents of the team
+		agent, err := newAgent(AgentConfig{Name: backend.Name, Service: backend.Service, Endpoint: backend.Endpoint, Model: backend.Model, APIKey: backend.APIKey}, services)
+		if err != nil {
+			return nil, err
+		}
+		cmp.clients = append(cmp.clients, agent.client)
+	}
+	return cmp, nil
+}
+
+// Names returns the names of the backends in order.
+func (cmp *Comparison) Names() []string {
+	names := make([]string, len(cmp.backends))
+	for i, backend := range cmp.backends {
+		names[i] = backend.Name
+	}
+	return names
+}
+
+// Run streams the prompt of a chat turn to both backends, stores the turn with the responses it
+// got and asks the user which one they prefer. A turn only one backend answered is stored like a
+// chat turn and can be rated. chat is the chat backend, used by backends without their own
+// endpoint.
+func (cmp *Comparison) Run(ctx context.Context, ws *websocket.Conn, chat LLMClient, payload *CompletionRequest, turn ChatTurn) error {
+	results, err := cmp.Stream(ctx, ws, chat, payload)
+	if err != nil {
+		return err
+	}
+
+	for _, result := range results {
+		if result.Err == nil && result.Content != "" {
+			turn.Responses = append(turn.Responses, ChatResponse{Content: result.Content, Model: result.Name})
+		}
+	}
+	if len(turn.Responses) == 0 || !recordChatTurn(ctx, &turn) {
+		return nil
+	}
+	if len(turn.Responses) == 1 {
+		return ws.WriteMessage(websocket.TextMessage, []byte(feedbackHTML(TurnCounter, turn.Responses[0].ID)))
+	}
+	return ws.WriteMessage(websocket.TextMessage, []byte(preferenceHTML(TurnCounter, turn.Responses)))
+}
+
+// Stream runs the prompt through the tools once and streams it to both backends, each response
+// into its own column of the current turn.
+func (cmp *Comparison) Stream(ctx context.Context, ws *websocket.Conn, chat LLMClient, payload *CompletionRequest) ([]CompareResult, error) {
+	last := len(payload.Messages) - 1
+	processed, err := globalWM.Run(ctx, payload.Messages[last].Content, ws)
+	if err != nil {
+		loggerFromContext(ctx).Error("error processing prompt through WorkflowManager", "error", err)
+	}
+
+	// Writes to the websocket are not safe from several goroutines
+	var mu sync.Mutex
+	write := func(message string) error {
+		mu.Lock()
+		defer mu.Unlock()
+		return ws.WriteMessage(websocket.TextMessage, []byte(message))
+	}
+	if err := write(responseHTML(0, []byte(compareLayoutHTML(TurnCounter, cmp.Names())))); err != nil {
+		return nil, err
+	}
+
+	results := make([]CompareResult, len(cmp.backends))
+	var wg sync.WaitGroup
+	for i, backend := range cmp.backends {
+		request := *payload
+		request.Messages = append([]Message(nil), payload.Messages...)
+		request.Messages[last].Content = processed
+		client := cmp.clients[i]
+		if client == nil {
+			client = chat
+		} else {
+			// Adapters and slots belong to the chat backend
+			request.Model, request.Lora, request.IDSlot, request.CachePrompt = backend.Model, nil, nil, false
+		}
+
+		wg.Add(1)
+		go func(i int, name string) {
+			defer wg.Done()
+			results[i] = cmp.stream(ctx, client, name, &request, func(content []byte) error {
+				return write(compareColumnHTML(TurnCounter, i, content))
+			})
+		}(i, backend.Name)
+	}
+	wg.Wait()
+	return results, nil
+}
+
+// stream sends the request to one backend and renders its response as it arrives. The guardrails
+// filter it like a chat response.
+func (cmp *Comparison) stream(ctx context.Context, client LLMClient, name string, request *CompletionRequest, render func([]byte) error) CompareResult {
+	span, ctx := startSpan(ctx, "compare.stream")
+	span.SetTag("backend", name)
+	logger := loggerFromContext(ctx).With("backend", name)
+
+	var content strings.Builder
+	err := streamCompletion(ctx, client, request, func(delta string) error {
+		content.WriteString(delta)
+		shown := content.String()
+		if guardrails != nil {
+			result := guardrails.CheckRules(GuardrailResponse, shown)
+			if result.Blocked {
+				return errGuardrailBlocked
+			}
+			shown = result.Text
+		}
+		return render(web.MarkdownToHTML([]byte(shown)))
+	})
+
+	// Like chat responses, the guardrails have a last look at the whole response
+	response := content.String()
+	if (err == nil || errors.Is(err, errGuardrailBlocked)) && guardrails != nil {
+		if result := guardrails.Check(ctx, GuardrailResponse, response); result.Blocked {
+			response = guardrails.BlockMessage()
+			err = render([]byte(html.EscapeString(response)))
+		} else {
+			response = result.Text
+			err = render(web.MarkdownToHTML([]byte(response)))
+		}
+	}
+	if err != nil {
+		logger.Error("comparison stream failed", "error", err)
+		render([]byte(html.EscapeString("Error: " + err.Error())))
+	}
+	finishSpan(span, err)
+	return CompareResult{Name: name, Content: response, Err: err}
+}
+
+var errGuardrailBlocked = errors.New("blocked by a guardrail")
+
+// streamCompletion sends a streaming completion request and calls onDelta with every piece of
+// content until the stream ends.
+func streamCompletion(ctx context.Context, client LLMClient, payload *CompletionRequest, onDelta func(string) error) error {
+	payload.Stream = true
+	resp, err := client.SendCompletionRequest(ctx, payload)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("completion request failed with status %d: %s", resp.StatusCode, truncateForLog(string(body), 200))
+	}
+
+	scanner := bufio.NewScanner(resp.Body)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if !strings.HasPrefix(line, "data: ") || line == "data: [DONE]" {
+			continue
+		}
+		var data struct {
+			Choices []struct {
+				FinishReason string `json:"finish_reason"`
+				Delta        struct {
+					Content string `json:"content"`
+				} `json:"delta"`
+			} `json:"choices"`
+		}
+		if err := json.Unmarshal([]byte(line[6:]), &data); err != nil {
+			return fmt.Errorf("invalid stream chunk: %w", err)
+		}
+		for _, choice := range data.Choices {
+			if choice.Delta.Content != "" {
+				if err := onDelta(choice.Delta.Content); err != nil {
+					return err
+				}
+			}
+			if choice.FinishReason != "" {
+				return nil
+			}
+		}
+	}
+	return scanner.Err()
+}
+
+// compareLayoutHTML renders the two columns of a comparison, each headed by its backend.
+func compareLayoutHTML(turnID int, names []string) string {
+	var b strings.Builder
+	b.WriteString("<div class='row'>")
+	for i, name := range names {
+		fmt.Fprintf(&b, "<div class='col-6'><span class='badge bg-secondary mb-2'>%s</span><div id='compare-%d-%d'></div></div>", html.EscapeString(name), turnID, i)
+	}
+	b.WriteString("</div>")
+	return b.String()
+}
+
+// compareColumnHTML renders the response of a backend into its column.
+func compareColumnHTML(turnID, column int, content []byte) string {
+	return fmt.Sprintf("<div id='compare-%d-%d' class='mx-1' hx-trigger='load'>%s</div>", turnID, column, content)
+}
+
+// preferenceHTML renders the buttons that record which response the user preferred. They replace
+// the feedback placeholder of the chat turn.
+func preferenceHTML(turnID int, responses []ChatResponse) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "<div id='feedback-%d' class='chat-feedback mx-1'>", turnID)
+	for _, response := range responses {
+		fmt.Fprintf(&b, `<button type='button' class='btn btn-outline-secondary btn-sm me-2' hx-post='/v1/compare/preference' hx-vals='{"response_id": %d}' hx-swap='none' `+
+			`hx-on::after-request='this.parentElement.querySelectorAll("button").forEach(b => b.classList.remove("active")); this.classList.add("active")'>Prefer %s</button>`,
+			response.ID, html.EscapeString(response.Model))
+	}
+	b.WriteString("</div>")
+	return b.String()
+}
+
+// PreferChatResponse records a response as the one the user preferred among the responses of its
+// turn.
+func (sqldb *SQLiteDB) PreferChatResponse(id int64) (ChatResponse, error) {
+	var response ChatResponse
+	if err := sqldb.db.First(&response, id).Error; err != nil {
+		return response, err
+	}
+	err := sqldb.db.Model(&ChatTurn{}).Where("id = ?", response.TurnID).Update("preferred_response_id", response.ID).Error
+	return response, err
+}
+
+// comparison answers chat turns in comparison mode, nil when disabled.
+var comparison *Comparison
+
+// configureComparison creates the comparison from the config.
+func configureComparison(config *Config) error {
+	comparison = nil
+	if !config.Compare.Enabled {
+		return nil
+	}
+	cmp, err := NewComparison(config.Compare, config.Services)
+	if err != nil {
+		return err
+	}
+	comparison = cmp
+	return nil
+}
+
+// handleGetComparison returns whether comparison mode is available and its backends.
+func handleGetComparison(c echo.Context) error {
+	if comparison == nil {
+		return c.JSON(http.StatusOK, map[string]interface{}{"enabled": false})
+	}
+	return c.JSON(http.StatusOK, map[string]interface{}{"enabled": true, "backends": comparison.Names()})
+}
+
+// handlePreferResponse records which response of a comparison the user preferred.
+func handlePreferResponse(c echo.Context) error {
+	var req struct {
+		ResponseID int64 `json:"response_id" form:"response_id"`
+	}
+	if err := c.Bind(&req); err != nil || req.ResponseID <= 0 {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid request body"})
+	}
+
+	response, err := db.PreferChatResponse(req.ResponseID)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "Response not found"})
+	}
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to save preference"})
+	}
+
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"status":      "success",
+		"response_id": response.ID,
+		"model":       response.Model,
+	})
+}
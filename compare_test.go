@@ -0,0 +1,140 @@
+// compare_test.go
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// streamServer streams the words of reply as completion chunks.
+func streamServer(t *testing.T, reply string, requests *[]CompletionRequest) *httptest.Server {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var request CompletionRequest
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&request))
+		if requests != nil {
+			*requests = append(*requests, request)
+		}
+		for _, word := range strings.SplitAfter(reply, " ") {
+			chunk, _ := json.Marshal(map[string]interface{}{"choices": []map[string]interface{}{{"delta": map[string]string{"content": word}}}})
+			fmt.Fprintf(w, "data: %s\n\n", chunk)
+		}
+		fmt.Fprint(w, "data: {\"choices\": [{\"delta\": {}, \"finish_reason\": \"stop\"}]}\n\ndata: [DONE]\n\n")
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestNewComparisonValidation(t *testing.T) {
+	_, err := NewComparison(CompareConfig{Backends: []CompareBackend{{Name: "local"}}}, nil)
+	assert.ErrorContains(t, err, "exactly two")
+	_, err = NewComparison(CompareConfig{Backends: []CompareBackend{{Name: "a"}, {Name: "a"}}}, nil)
+	assert.ErrorContains(t, err, "distinct names")
+	_, err = NewComparison(CompareConfig{Backends: []CompareBackend{{Name: "a"}, {Name: "b", Service: "missing"}}}, nil)
+	assert.ErrorContains(t, err, "unknown service")
+}
+
+func TestComparisonRun(t *testing.T) {
+	testDB, err := NewSQLiteDB(t.TempDir())
+	require.NoError(t, err)
+	require.NoError(t, testDB.AutoMigrate(&ChatSession{}, &ChatTurn{}, &ChatResponse{}))
+
+	savedDB, savedWM := db, globalWM
+	db, globalWM = testDB, &WorkflowManager{}
+	defer func() { db, globalWM = savedDB, savedWM }()
+
+	var remote []CompletionRequest
+	chat := streamServer(t, "Local answer here", nil)
+	other := streamServer(t, "Remote answer here", &remote)
+
+	cmp, err := NewComparison(CompareConfig{Backends: []CompareBackend{
+		{Name: "local"},
+		{Name: "mini", Endpoint: other.URL, Model: "gpt-4o-mini"},
+	}}, nil)
+	require.NoError(t, err)
+
+	payload := &CompletionRequest{Model: "local.gguf", CachePrompt: true, Messages: []Message{{Role: "system", Content: "Be brief"}, {Role: "user", Content: "{Hi}"}}}
+	ctx := withTurn(context.Background(), TurnInfo{SessionID: "s1", TurnID: "t1"})
+	require.NoError(t, cmp.Run(ctx, testWebSocket(t), NewLocalLLMClient(chat.URL, "", ""), payload, ChatTurn{Instructions: "Be brief", UserPrompt: "Hi"}))
+
+	require.Len(t, remote, 1)
+	assert.Equal(t, "gpt-4o-mini", remote[0].Model)
+	assert.False(t, remote[0].CachePrompt, "slot settings stay with the chat backend")
+	assert.Equal(t, "Be brief", remote[0].Messages[0].Content)
+
+	turns, err := db.GetDatasetTurns(DatasetFilter{})
+	require.NoError(t, err)
+	require.Len(t, turns, 1)
+	require.Len(t, turns[0].Responses, 2)
+	assert.Equal(t, "local", turns[0].Responses[0].Model)
+	assert.Equal(t, "Local answer here", turns[0].Responses[0].Content)
+	assert.Equal(t, "mini", turns[0].Responses[1].Model)
+
+	// The preferred response is the one exported
+	preferred := turns[0].Responses[0]
+	e := echo.New()
+	req := httptest.NewRequest(http.MethodPost, "/v1/compare/preference", strings.NewReader(fmt.Sprintf(`{"response_id": %d}`, preferred.ID)))
+	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+	rec := httptest.NewRecorder()
+	require.NoError(t, handlePreferResponse(e.NewContext(req, rec)))
+	assert.Equal(t, http.StatusOK, rec.Code)
+
+	turns, err = db.GetDatasetTurns(DatasetFilter{})
+	require.NoError(t, err)
+	assert.Equal(t, preferred.ID, turns[0].PreferredResponseID)
+	examples := datasetExamples(turns, DatasetFilter{})
+	require.Len(t, examples, 1)
+	assert.Equal(t, "Local answer here", examples[0][2].Content)
+}
+
+func TestStreamCompletionError(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "model not found", http.StatusNotFound)
+	}))
+	defer server.Close()
+
+	err := streamCompletion(context.Background(), NewLocalLLMClient(server.URL, "", ""), &CompletionRequest{}, func(string) error { return nil })
+	assert.ErrorContains(t, err, "status 404")
+}
+
+func TestComparisonRunStoresSingleResponse(t *testing.T) {
+	testDB, err := NewSQLiteDB(t.TempDir())
+	require.NoError(t, err)
+	require.NoError(t, testDB.AutoMigrate(&ChatSession{}, &ChatTurn{}, &ChatResponse{}))
+
+	savedDB, savedWM := db, globalWM
+	db, globalWM = testDB, &WorkflowManager{}
+	defer func() { db, globalWM = savedDB, savedWM }()
+
+	chat := streamServer(t, "Local answer here", nil)
+	down := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "unavailable", http.StatusServiceUnavailable)
+	}))
+	t.Cleanup(down.Close)
+
+	cmp, err := NewComparison(CompareConfig{Backends: []CompareBackend{
+		{Name: "local"},
+		{Name: "mini", Endpoint: down.URL, Model: "gpt-4o-mini"},
+	}}, nil)
+	require.NoError(t, err)
+
+	// The turn is kept with the response that arrived
+	payload := &CompletionRequest{Model: "local.gguf", Messages: []Message{{Role: "user", Content: "{Hi}"}}}
+	ctx := withTurn(context.Background(), TurnInfo{SessionID: "s1", TurnID: "t1"})
+	require.NoError(t, cmp.Run(ctx, testWebSocket(t), NewLocalLLMClient(chat.URL, "", ""), payload, ChatTurn{UserPrompt: "Hi"}))
+
+	turns, err := db.GetDatasetTurns(DatasetFilter{})
+	require.NoError(t, err)
+	require.Len(t, turns, 1)
+	require.Len(t, turns[0].Responses, 1)
+	assert.Equal(t, "local", turns[0].Responses[0].Model)
+	assert.Equal(t, "Local answer here", turns[0].Responses[0].Content)
+}
@@ -9,7 +9,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"io"
-	"log"
+	"log/slog"
 	"net/http"
 	"strings"
 	"time"
@@ -38,9 +38,11 @@ type ChatDocument struct {
 }
 
 type CompletionsRole struct {
-	ID           uint   `gorm:"primaryKey" yaml:"-"`
-	Name         string `gorm:"uniqueIndex" yaml:"name"`
-	Instructions string `gorm:"type:text" yaml:"instructions"`
+	ID           uint           `gorm:"primaryKey" yaml:"-"`
+	Name         string         `gorm:"uniqueIndex" yaml:"name"`
+	Instructions string         `gorm:"type:text" yaml:"instructions"`
+	Variables    []RoleVariable `gorm:"serializer:json" yaml:"variables,omitempty"` // {name} placeholders in the instructions
+	FewShotK     int            `yaml:"few_shot_k,omitempty"`                       // examples prepended per turn, 3 when 0, none when negative
 }
 
 type ChatRole interface {
@@ -57,8 +59,9 @@ func (r *CompletionsRole) GetInstructions() string {
 }
 
 type Message struct {
-	Role    string `json:"role"`
-	Content string `json:"content"`
+	Role    string   `json:"role"`
+	Content string   `json:"content"`
+	Images  []string `json:"-"` // image URLs or base64 data URLs, sent as content parts
 }
 
 // PromptTemplate represents a template for generating string prompts.
@@ -80,12 +83,15 @@ type Model struct {
 
 // CompletionRequest represents the payload for the completion API.
 type CompletionRequest struct {
-	Model       string    `json:"model,omitempty"`
-	Messages    []Message `json:"messages"`
-	Temperature float64   `json:"temperature,omitempty"`
-	TopP        float64   `json:"top_p,omitempty"`
-	MaxTokens   int       `json:"max_tokens,omitempty"`
-	Stream      bool      `json:"stream,omitempty"`
+	Model       string      `json:"model,omitempty"`
+	Messages    []Message   `json:"messages"`
+	Temperature float64     `json:"temperature,omitempty"`
+	TopP        float64     `json:"top_p,omitempty"`
+	MaxTokens   int         `json:"max_tokens,omitempty"`
+	Stream      bool        `json:"stream,omitempty"`
+	Lora        []LoraScale `json:"lora,omitempty"` // llama-server adapters applied to this request
+	IDSlot      *int        `json:"id_slot,omitempty"`
+	CachePrompt bool        `json:"cache_prompt,omitempty"`
 }
 
 // Choice represents a choice for the completion response.
@@ -177,21 +183,24 @@ func (cpt *ChatPromptTemplate) FormatMessages(vars map[string]string) []Message
 	return formattedMessages
 }
 
-func (client *Client) SendCompletionRequest(payload *CompletionRequest) (*http.Response, error) {
+func (client *Client) SendCompletionRequest(ctx context.Context, payload *CompletionRequest) (resp *http.Response, err error) {
+	span, ctx := startSpan(ctx, "llm.completion_request")
+	defer func() { finishSpan(span, err) }()
+
+	// Completions of a chat turn apply its LoRA adapters unless they choose their own
+	if payload.Lora == nil {
+		payload.Lora = loraScalesFor(ctx, client)
+	}
 
 	// TODO: Add a better way to handle the model selection using the frontend
 	// Jank way to set the model to gpt-4o-mini if the client url is openai
 	// if the client url is openai, set the payload model to gpt-4o-mini
 	// this should not be hard coded but loaded from app config instead
 	if client.BaseURL == "https://api.openai.com/v1" {
-		// Print the client base url
-		fmt.Println(client.BaseURL)
 		payload.Model = "chatgpt-4o-latest"
 	}
 
 	if client.BaseURL == "https://generativelanguage.googleapis.com/v1beta/openai" {
-		// Print the client base url
-		fmt.Println(client.BaseURL)
 		payload.Model = "gemini-2.0-flash-exp"
 		//payload.MaxTokens = 8193
 	}
@@ -202,35 +211,55 @@ func (client *Client) SendCompletionRequest(payload *CompletionRequest) (*http.R
 		return nil, err
 	}
 
+	span.SetTag("model", payload.Model)
+	span.SetTag("stream", payload.Stream)
+
 	url := client.BaseURL + "/chat/completions"
-	req, err := http.NewRequest("POST", url, bytes.NewBuffer(jsonPayload))
+	req, err := http.NewRequestWithContext(ctx, "POST", url, bytes.NewBuffer(jsonPayload))
 	if err != nil {
 		return nil, err
 	}
 
 	req.Header.Set("Content-Type", "application/json")
 	req.Header.Set("Authorization", "Bearer "+client.APIKey)
+	injectSpan(ctx, req)
+
+	slog.Debug("sending completion request", "url", url, "model", payload.Model, "stream", payload.Stream)
+
+	resp, err = http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	span.SetTag("http.status_code", resp.StatusCode)
 
-	return http.DefaultClient.Do(req)
+	return resp, nil
 }
 
-func StreamCompletionToWebSocket(c *websocket.Conn, llmClient LLMClient, chatID int, model string, payload *CompletionRequest, responseBuffer *bytes.Buffer) error {
+func StreamCompletionToWebSocket(ctx context.Context, c *websocket.Conn, llmClient LLMClient, chatID int, model string, payload *CompletionRequest, responseBuffer *bytes.Buffer) (err error) {
+	logger := loggerFromContext(ctx).With("model", model)
+	ctx = withLogger(ctx, logger)
+
+	// The user prompt is the last message, after the system message and any few-shot examples.
 	// Get the string in between brackets for the user prompt
-	userPrompt := payload.Messages[1].Content
+	last := len(payload.Messages) - 1
+	userPrompt := payload.Messages[last].Content
 	userPrompt = userPrompt[1 : len(userPrompt)-1]
 
-	// Print the user prompt
-	fmt.Println("USER PROMPT")
-	fmt.Println(userPrompt)
+	logger.Debug("user prompt received", "prompt", truncateForLog(userPrompt, 200))
 
-	// Process the user prompt through the WorkflowManager
-	processedPrompt, err := globalWM.Run(context.Background(), payload.Messages[1].Content, c)
+	// Process the user prompt through the WorkflowManager, planning the tool calls in plan mode
+	var processedPrompt string
+	if planModeFromContext(ctx) {
+		processedPrompt, err = globalWM.RunPlan(ctx, payload.Messages[last].Content, c, llmClient, payload.Model)
+	} else {
+		processedPrompt, err = globalWM.Run(ctx, payload.Messages[last].Content, c)
+	}
 	if err != nil {
-		log.Printf("Error processing prompt through WorkflowManager: %v", err)
+		logger.Error("error processing prompt through WorkflowManager", "error", err)
 	}
 
 	// Prepend the processed prompt to the messages
-	payload.Messages[1].Content = processedPrompt
+	payload.Messages[last].Content = processedPrompt
 
 	timestamp := time.Now().Format(time.RFC3339)
 
@@ -238,30 +267,31 @@ func StreamCompletionToWebSocket(c *websocket.Conn, llmClient LLMClient, chatID
 	formattedContent := fmt.Sprintf("<div id='progress' class='progress-bar placeholder-wave fs-5' style='width: 100%%;'>%s</div>", statusMsg)
 	c.WriteMessage(websocket.TextMessage, []byte(formattedContent))
 
+	// The stream span covers the request and every streamed token until the finish reason arrives
+	span, streamCtx := startSpan(ctx, "llm.stream")
+	span.SetTag("model", model)
+	defer func() {
+		span.SetTag("response_bytes", responseBuffer.Len())
+		finishSpan(span, err)
+	}()
+
 	// Use llmClient to send the request
-	resp, err := llmClient.SendCompletionRequest(payload)
+	resp, err := llmClient.SendCompletionRequest(streamCtx, payload)
 	if err != nil {
 		return err
 	}
 	defer resp.Body.Close()
 
-	// Log the entire response body for debugging
-	bodyBytes, err := io.ReadAll(resp.Body)
-	if err != nil {
-		log.Printf("Error reading response body: %v", err)
-		return err
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		logger.Error("completion request failed", "status", resp.StatusCode, "body", truncateForLog(string(body), 500))
+		return fmt.Errorf("completion request failed with status %d", resp.StatusCode)
 	}
-	log.Printf("Full response body:\n%s\n", string(bodyBytes))
-
-	// Reset resp.Body so that it can be read again by the scanner.
-	resp.Body = io.NopCloser(bytes.NewBuffer(bodyBytes))
 
 	scanner := bufio.NewScanner(resp.Body)
 	for scanner.Scan() {
 		line := scanner.Text()
 
-		log.Printf("Raw API Line: %s", line)
-
 		if strings.HasPrefix(line, "data: ") {
 			jsonStr := line[6:] // Strip the "data: " prefix
 			var data struct {
@@ -271,13 +301,14 @@ func StreamCompletionToWebSocket(c *websocket.Conn, llmClient LLMClient, chatID
 						Content string `json:"content"`
 					} `json:"delta"`
 				} `json:"choices"`
+				Timings *LlamaTimings `json:"timings,omitempty"`
 			}
 
 			if err := json.Unmarshal([]byte(jsonStr), &data); err != nil {
 				// Print the user prompt
-				err := SaveChatTurn(userPrompt, responseBuffer.String(), timestamp)
+				err := SaveChatTurn(ctx, userPrompt, responseBuffer.String(), timestamp)
 				if err != nil {
-					log.Printf("Error saving chat turn: %v", err)
+					logger.Error("error saving chat turn", "error", err)
 				}
 
 				return fmt.Errorf("%s", responseBuffer.String())
@@ -286,9 +317,18 @@ func StreamCompletionToWebSocket(c *websocket.Conn, llmClient LLMClient, chatID
 			for _, choice := range data.Choices {
 				responseBuffer.WriteString(choice.Delta.Content)
 
-				htmlMsg := web.MarkdownToHTML(responseBuffer.Bytes())
-				turnIDStr := fmt.Sprint(chatID + TurnCounter)
-				formattedContent := fmt.Sprintf("<div id='response-content-%s' class='mx-1' hx-trigger='load'>%s</div>\n<codapi-snippet engine='browser' sandbox='javascript' editor='basic'></codapi-snippet>", turnIDStr, htmlMsg)
+				// The guardrail rules run on every chunk so redacted text is never shown
+				shown := responseBuffer.Bytes()
+				if guardrails != nil {
+					result := guardrails.CheckRules(GuardrailResponse, responseBuffer.String())
+					if result.Blocked {
+						return checkStreamedResponse(ctx, c, chatID, responseBuffer)
+					}
+					shown = []byte(result.Text)
+				}
+
+				htmlMsg := web.MarkdownToHTML(shown)
+				formattedContent := responseHTML(chatID, htmlMsg)
 
 				if err := c.WriteMessage(websocket.TextMessage, []byte(formattedContent)); err != nil {
 					return err
@@ -296,15 +336,22 @@ func StreamCompletionToWebSocket(c *websocket.Conn, llmClient LLMClient, chatID
 
 				// Handle different finish reasons
 				if choice.FinishReason != "" {
-					log.Printf("Finish reason: %s", choice.FinishReason)
+					logger.Debug("completion finished", "finish_reason", choice.FinishReason, "response_bytes", responseBuffer.Len())
+
+					span.SetTag("finish_reason", choice.FinishReason)
+
+					// llama-server reports generation speed and draft acceptance on the last chunk
+					if data.Timings != nil {
+						speculativeStats.Record(*data.Timings)
+					}
 
 					if choice.FinishReason == "stop" {
-						// Normal completion, do nothing special here
-						return nil
+						// Normal completion, only the guardrails have a last look
+						return checkStreamedResponse(ctx, c, chatID, responseBuffer)
 					} else if choice.FinishReason == "length" {
 						// Reached token limit
-						log.Println("Response truncated due to length limit.")
-						return nil // Treat as normal completion
+						logger.Warn("response truncated due to length limit")
+						return checkStreamedResponse(ctx, c, chatID, responseBuffer) // Treat as normal completion
 					} else {
 						// Other finish reasons (e.g., content_filter)
 						return fmt.Errorf("Unexpected finish reason: %s", choice.FinishReason)
@@ -318,7 +365,13 @@ func StreamCompletionToWebSocket(c *websocket.Conn, llmClient LLMClient, chatID
 		return err
 	}
 
-	return nil
+	return checkStreamedResponse(ctx, c, chatID, responseBuffer)
+}
+
+// responseHTML renders the assistant's response of the current turn.
+func responseHTML(chatID int, htmlMsg []byte) string {
+	turnIDStr := fmt.Sprint(chatID + TurnCounter)
+	return fmt.Sprintf("<div id='response-content-%s' class='mx-1' hx-trigger='load'>%s</div>\n<codapi-snippet engine='browser' sandbox='javascript' editor='basic'></codapi-snippet>", turnIDStr, htmlMsg)
 }
 
 // IncrementTurn increments the turn counter.
@@ -333,6 +386,26 @@ func handleChatSubmit(c echo.Context) error {
 	userPrompt := c.FormValue("userprompt")
 	roleInstructions := c.FormValue("role_instructions")
 	endpoint := c.FormValue("endpoint")
+	images := c.FormValue("images")
+	role := c.FormValue("role")
+
+	// LoRA scales by name, a JSON object passed on to the chat session
+	loras := c.FormValue("loras")
+	if loras != "" {
+		var selection LoraSelection
+		if err := json.Unmarshal([]byte(loras), &selection); err != nil {
+			return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+		}
+	}
+
+	// A stored prompt template, as name or name@version, wraps the message
+	if ref := c.FormValue("template"); ref != "" {
+		prompt, err := chatTemplatePrompt(ref, c.FormValue("template_variables"), userPrompt)
+		if err != nil {
+			return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+		}
+		userPrompt = prompt
+	}
 
 	// Stream the completion response to the client
 
@@ -348,6 +421,9 @@ func handleChatSubmit(c echo.Context) error {
 		"wsRoute":          "",
 		"endpoint":         endpoint,
 		"roleInstructions": roleInstructions,
+		"images":           images,
+		"role":             role,
+		"loras":            loras,
 	})
 }
 
@@ -5,37 +5,170 @@ package main
 import (
 	"fmt"
 	"os"
+	"sync"
 
 	"gopkg.in/yaml.v2"
+
+	"manifold/internal/apiguard"
 )
 
 type ServiceConfig struct {
 	Name      string   `yaml:"name"`
 	Host      string   `yaml:"host"`
 	Port      int      `yaml:"port"`
+	AltPort   int      `yaml:"alt_port,omitempty"`
 	Command   string   `yaml:"command"`
 	GPULayers string   `yaml:"gpu_layers,omitempty"`
 	Args      []string `yaml:"args,omitempty"`
 	Model     string   `yaml:"model,omitempty"`
 }
 
+// APIKeyConfig is an API key allowed to call the server. Admin keys can manage models and tools.
+type APIKeyConfig = apiguard.Key
+
+// AuthConfig controls API key authentication and the allowed CORS origins.
+type AuthConfig struct {
+	Enabled     bool           `yaml:"enabled"`
+	Keys        []APIKeyConfig `yaml:"keys,omitempty" json:"-"`
+	CORSOrigins []string       `yaml:"cors_origins,omitempty"`
+}
+
+// RateLimit is a token bucket limit applied per API key, or per client IP when auth is disabled.
+type RateLimit struct {
+	RequestsPerSecond float64 `yaml:"requests_per_second"`
+	Burst             int     `yaml:"burst"`
+}
+
+// RateLimitConfig holds the limits for each class of endpoint.
+type RateLimitConfig struct {
+	Enabled bool      `yaml:"enabled"`
+	Chat    RateLimit `yaml:"chat"`
+	Ingest  RateLimit `yaml:"ingest"`
+	Search  RateLimit `yaml:"search"`
+}
+
+// SupervisorConfig controls how often local services are health checked, how long crashed
+// services wait before being restarted and how many failed checks in a row mark a service as hung.
+type SupervisorConfig struct {
+	IntervalSeconds   int `yaml:"interval_seconds"`
+	MaxBackoffSeconds int `yaml:"max_backoff_seconds"`
+	MaxFailedChecks   int `yaml:"max_failed_checks"`
+}
+
+// ReadinessConfig controls how completions are gated while the backend loads a model.
+type ReadinessConfig struct {
+	TimeoutSeconds    int `yaml:"timeout_seconds"`
+	QueueSeconds      int `yaml:"queue_seconds"`
+	RetryAfterSeconds int `yaml:"retry_after_seconds"`
+}
+
+// ModelPoolConfig controls the additional models that can run alongside the selected model.
+type ModelPoolConfig struct {
+	BasePort  int      `yaml:"base_port"`
+	MaxModels int      `yaml:"max_models"`
+	Preload   []string `yaml:"preload,omitempty"`
+}
+
+// SpeculativeConfig enables speculative decoding on gguf launches with a small draft model
+// that proposes tokens for the main model to verify.
+type SpeculativeConfig struct {
+	Enabled        bool    `yaml:"enabled" json:"enabled"`
+	DraftModel     string  `yaml:"draft_model" json:"draft_model"` // name of a gguf model
+	DraftMax       int     `yaml:"draft_max,omitempty" json:"draft_max,omitempty"`
+	DraftMin       int     `yaml:"draft_min,omitempty" json:"draft_min,omitempty"`
+	DraftPMin      float64 `yaml:"draft_p_min,omitempty" json:"draft_p_min,omitempty"`
+	GPULayersDraft *int    `yaml:"gpu_layers_draft,omitempty" json:"gpu_layers_draft,omitempty"`
+}
+
+// SlotsConfig controls how chat sessions are pinned to llama-server slots.
+type SlotsConfig struct {
+	Affinity         bool    `yaml:"affinity"`                    // pin each session to a slot to reuse its KV cache
+	Persist          bool    `yaml:"persist"`                     // save evicted slots under data_path/slots
+	PromptSimilarity float64 `yaml:"prompt_similarity,omitempty"` // --slot-prompt-similarity
+}
+
+// TTSConfig selects the text to speech provider: OpenAI, or a local OpenAI-compatible piper/kokoro
+// server launched as an external service.
+type TTSConfig struct {
+	Provider string        `yaml:"provider"` // "openai" or "local"
+	Model    string        `yaml:"model,omitempty"`
+	Voice    string        `yaml:"voice,omitempty"`
+	Format   string        `yaml:"format,omitempty"` // mp3, opus, aac, flac, wav or pcm
+	Service  ServiceConfig `yaml:"service,omitempty"`
+}
+
+// STTConfig selects the speech to text provider: OpenAI, or a local whisper.cpp server launched
+// as an external service.
+type STTConfig struct {
+	Provider string        `yaml:"provider"` // "openai" or "local"
+	Model    string        `yaml:"model,omitempty"`
+	Language string        `yaml:"language,omitempty"`
+	Service  ServiceConfig `yaml:"service,omitempty"`
+}
+
+// ComfyUIConfig runs ComfyUI as an external service for image generation. Without a service command
+// ComfyUI is cloned into the data path and started with python3.
+type ComfyUIConfig struct {
+	Enabled        bool          `yaml:"enabled"`
+	Checkpoint     string        `yaml:"checkpoint,omitempty"`      // ckpt_name in ComfyUI's models/checkpoints
+	Workflow       string        `yaml:"workflow,omitempty"`        // API format workflow template, the built-in text to image one when empty
+	Steps          int           `yaml:"steps,omitempty"`           // sampler steps, 20 when unset
+	TimeoutSeconds int           `yaml:"timeout_seconds,omitempty"` // per generation, 300 when unset
+	Service        ServiceConfig `yaml:"service,omitempty"`
+}
+
 type ToolConfig struct {
-	Name       string                 `yaml:"name"`
-	Parameters map[string]interface{} `yaml:"parameters"`
+	Name           string                 `yaml:"name"`
+	Parameters     map[string]interface{} `yaml:"parameters"`
+	Retry          RetryPolicy            `yaml:"retry,omitempty"`
+	CircuitBreaker BreakerPolicy          `yaml:"circuit_breaker,omitempty"`
 }
 
 type Config struct {
-	OpenAIAPIKey   string            `yaml:"openai_api_key,omitempty"`
-	GoogleAPIKey   string            `yaml:"google_api_key,omitempty"`
-	DataPath       string            `yaml:"data_path"`
-	LLMBackend     string            `yaml:"llm_backend"`
-	Services       []ServiceConfig   `yaml:"services"`
-	Tools          []ToolConfig      `yaml:"tools"`
-	Roles          []CompletionsRole `yaml:"roles"`
-	LanguageModels []LanguageModel   `json:"language_models"`
-	SelectedModels SelectedModels    `json:"selected_models"`
+	OpenAIAPIKey      string                 `yaml:"openai_api_key,omitempty" json:"-"`
+	GoogleAPIKey      string                 `yaml:"google_api_key,omitempty" json:"-"`
+	HuggingFaceToken  string                 `yaml:"huggingface_token,omitempty" json:"-"` // for gated and private repos
+	DataPath          string                 `yaml:"data_path"`
+	LogLevel          string                 `yaml:"log_level,omitempty"`
+	LogFormat         string                 `yaml:"log_format,omitempty"`
+	Auth              AuthConfig             `yaml:"auth,omitempty"`
+	RateLimit         RateLimitConfig        `yaml:"rate_limit,omitempty"`
+	Supervisor        SupervisorConfig       `yaml:"supervisor,omitempty"`
+	Readiness         ReadinessConfig        `yaml:"readiness,omitempty"`
+	ModelPool         ModelPoolConfig        `yaml:"model_pool,omitempty"`
+	IdleUnloadMinutes int                    `yaml:"idle_unload_minutes,omitempty"` // 0 disables idle unloading
+	Llama             map[string]interface{} `yaml:"llama,omitempty"`               // llama-server flags, see GGUFOptions
+	GPUMemoryGB       int                    `yaml:"gpu_memory_gb,omitempty"`       // discrete GPU memory used to tune gpu-layers and ctx-size
+	Speculative       SpeculativeConfig      `yaml:"speculative,omitempty"`
+	Slots             SlotsConfig            `yaml:"slots,omitempty"`
+	TTS               TTSConfig              `yaml:"tts,omitempty"`
+	STT               STTConfig              `yaml:"stt,omitempty"`
+	MaxImageMB        int                    `yaml:"max_image_mb,omitempty"`  // chat image upload limit, 10 when unset
+	MaxUploadMB       int                    `yaml:"max_upload_mb,omitempty"` // document upload limit, 50 when unset
+	ComfyUI           ComfyUIConfig          `yaml:"comfyui,omitempty"`
+	LLMBackend        string                 `yaml:"llm_backend"`
+	Services          []ServiceConfig        `yaml:"services"`
+	Tools             []ToolConfig           `yaml:"tools"`
+	ToolRouter        ToolRouterConfig       `yaml:"tool_router,omitempty"`
+	Plugins           []PluginConfig         `yaml:"plugins,omitempty"`
+	PII               PIIConfig              `yaml:"pii,omitempty"`
+	Guardrails        GuardrailsConfig       `yaml:"guardrails,omitempty"`
+	Planner           PlannerConfig          `yaml:"planner,omitempty"`
+	Agents            AgentsConfig           `yaml:"agents,omitempty"`
+	Compare           CompareConfig          `yaml:"compare,omitempty"`
+	Evals             EvalConfig             `yaml:"evals,omitempty"`
+	Retrieval         RetrievalConfig        `yaml:"retrieval,omitempty"`
+	Documents         DocumentsConfig        `yaml:"documents,omitempty"`
+	EData             EDataConfig            `yaml:"edata,omitempty"`
+	Roles             []CompletionsRole      `yaml:"roles"`
+	LanguageModels    []LanguageModel        `json:"language_models"`
+	SelectedModels    SelectedModels         `json:"selected_models"`
 }
 
+// configMu guards the config fields changed while the server runs, such as the registered models and
+// the speculative decoding settings.
+var configMu sync.RWMutex
+
 func LoadConfig(filename string) (*Config, error) {
 	data, err := os.ReadFile(filename)
 	if err != nil {
@@ -0,0 +1,353 @@
+// manifold/datacatalog.go
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/labstack/echo/v4"
+
+	"manifold/internal/datasets"
+	"manifold/internal/documents"
+)
+
+const (
+	defaultDatasetPreviewRows = 5
+	maxDatasetPreviewRows     = 100
+)
+
+// Statuses of dataset ingestions.
+const (
+	ingestionRunning   = "running"
+	ingestionCompleted = "completed"
+	ingestionFailed    = "failed"
+)
+
+// DatasetMapping maps the columns of a dataset to the documents ingested from its rows.
+type DatasetMapping struct {
+	Text     []string `json:"text"`               // columns of the content, each labeled with its name when there are several
+	ID       string   `json:"id,omitempty"`       // column keying the documents, the row number when empty
+	Metadata []string `json:"metadata,omitempty"` // columns copied into the metadata
+}
+
+// DatasetIngestion is an ingestion of a dataset of DataPath/datasets into a collection, the name
+// its documents are tagged and keyed with, and how it went.
+type DatasetIngestion struct {
+	ID         uint           `gorm:"primaryKey" json:"id"`
+	Dataset    string         `gorm:"index" json:"dataset"`
+	Collection string         `gorm:"index" json:"collection"`
+	Mapping    DatasetMapping `gorm:"serializer:json" json:"mapping"`
+	Status     string         `json:"status"` // running, completed or failed
+	Rows       int            `json:"rows"`   // rows read so far
+	Documents  int            `json:"documents"`
+	Chunks     int            `json:"chunks"`
+	Failed     int            `json:"failed"` // documents that failed to index
+	Error      string         `json:"error,omitempty"`
+	StartedAt  time.Time      `gorm:"index" json:"started_at"`
+	FinishedAt *time.Time     `json:"finished_at,omitempty"`
+}
+
+// DatasetIngestRequest ingests a dataset into a collection, the name of the dataset without its
+// extension when empty. Limit, when positive, ingests the first rows only.
+type DatasetIngestRequest struct {
+	Collection string `json:"collection"`
+	DatasetMapping
+	Limit int `json:"limit"`
+}
+
+// DatasetEntry is a dataset of the catalog with its ingestions, the latest first.
+type DatasetEntry struct {
+	datasets.Info
+	Preview    []datasets.Row     `json:"preview,omitempty"`
+	Ingestions []DatasetIngestion `json:"ingestions"`
+}
+
+// runningIngestions are the datasets being ingested, by dataset and collection.
+var (
+	runningIngestionsMu sync.Mutex
+	runningIngestions   = map[string]bool{}
+)
+
+// datasetsDir returns the directory of the datasets of the catalog.
+func datasetsDir(config *Config) string {
+	return filepath.Join(config.DataPath, "datasets")
+}
+
+// datasetPath returns the path of a dataset of the catalog by name, or an error for names outside
+// of it and files that aren't datasets.
+func datasetPath(config *Config, name string) (string, error) {
+	if name == "" || name != filepath.Base(name) || strings.HasPrefix(name, ".") || datasets.Format(name) == "" {
+		return "", fmt.Errorf("invalid dataset %q", name)
+	}
+	path := filepath.Join(datasetsDir(config), name)
+	if _, err := os.Stat(path); err != nil {
+		return "", fmt.Errorf("dataset %s not found", name)
+	}
+	return path, nil
+}
+
+// GetDatasetIngestions returns the ingestions of a dataset, of a collection, or of all when both are
+// empty, the latest first.
+func (sqldb *SQLiteDB) GetDatasetIngestions(dataset, collection string) ([]DatasetIngestion, error) {
+	query := sqldb.db.Order("started_at DESC, id DESC")
+	if dataset != "" {
+		query = query.Where("dataset = ?", dataset)
+	}
+	if collection != "" {
+		query = query.Where("collection = ?", collection)
+	}
+	ingestions := []DatasetIngestion{}
+	err := query.Find(&ingestions).Error
+	return ingestions, err
+}
+
+// handleGetDatasets lists the datasets of the catalog with their columns, row counts and
+// ingestions.
+func handleGetDatasets(c echo.Context, config *Config) error {
+	infos, err := datasets.List(datasetsDir(config))
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": err.Error()})
+	}
+	ingestions, err := db.GetDatasetIngestions("", "")
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to load dataset ingestions"})
+	}
+	byDataset := make(map[string][]DatasetIngestion)
+	for _, ingestion := range ingestions {
+		byDataset[ingestion.Dataset] = append(byDataset[ingestion.Dataset], ingestion)
+	}
+
+	entries := make([]DatasetEntry, len(infos))
+	for i, info := range infos {
+		entries[i] = DatasetEntry{Info: info, Ingestions: byDataset[info.Name]}
+		if entries[i].Ingestions == nil {
+			entries[i].Ingestions = []DatasetIngestion{}
+		}
+	}
+	return c.JSON(http.StatusOK, entries)
+}
+
+// handleGetDataset describes a dataset of the catalog with a preview of its first rows, 5 or the
+// rows query parameter, and its ingestions.
+func handleGetDataset(c echo.Context, config *Config) error {
+	path, err := datasetPath(config, c.Param("name"))
+	if err != nil {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": err.Error()})
+	}
+	n := defaultDatasetPreviewRows
+	if rows := c.QueryParam("rows"); rows != "" {
+		if n, err = strconv.Atoi(rows); err != nil || n < 0 || n > maxDatasetPreviewRows {
+			return c.JSON(http.StatusBadRequest, map[string]string{"error": fmt.Sprintf("rows must be between 0 and %d", maxDatasetPreviewRows)})
+		}
+	}
+
+	info, err := datasets.Inspect(path)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": err.Error()})
+	}
+	entry := DatasetEntry{Info: info}
+	if entry.Preview, err = datasets.Preview(path, n); err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": err.Error()})
+	}
+	if entry.Ingestions, err = db.GetDatasetIngestions(info.Name, ""); err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to load dataset ingestions"})
+	}
+	return c.JSON(http.StatusOK, entry)
+}
+
+// handleGetDatasetIngestions lists the dataset ingestions, filtered by the dataset and collection
+// query parameters.
+func handleGetDatasetIngestions(c echo.Context) error {
+	ingestions, err := db.GetDatasetIngestions(c.QueryParam("dataset"), c.QueryParam("collection"))
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to load dataset ingestions"})
+	}
+	return c.JSON(http.StatusOK, ingestions)
+}
+
+// handleIngestDataset starts ingesting a dataset of the catalog into a collection in the background.
+// It responds 202 Accepted with the ingestion, whose progress is listed by the catalog.
+func handleIngestDataset(c echo.Context, config *Config) error {
+	if docManager == nil || docManager.IndexManager == nil {
+		return c.JSON(http.StatusServiceUnavailable, map[string]string{"error": "document store is not initialized"})
+	}
+	name := c.Param("name")
+	path, err := datasetPath(config, name)
+	if err != nil {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": err.Error()})
+	}
+
+	var req DatasetIngestRequest
+	if err := c.Bind(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid request body"})
+	}
+	if req.Collection == "" {
+		req.Collection = strings.TrimSuffix(name, filepath.Ext(name))
+	}
+	if len(req.Text) == 0 {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "text columns are required"})
+	}
+	info, err := datasets.Inspect(path)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": err.Error()})
+	}
+	columns := make(map[string]bool, len(info.Columns))
+	for _, column := range info.Columns {
+		columns[column.Name] = true
+	}
+	mapped := append(append([]string{}, req.Text...), req.Metadata...)
+	if req.ID != "" {
+		mapped = append(mapped, req.ID)
+	}
+	for _, column := range mapped {
+		if !columns[column] {
+			return c.JSON(http.StatusBadRequest, map[string]string{"error": fmt.Sprintf("dataset %s has no column %q", name, column)})
+		}
+	}
+
+	key := name + "\x00" + req.Collection
+	runningIngestionsMu.Lock()
+	if runningIngestions[key] {
+		runningIngestionsMu.Unlock()
+		return c.JSON(http.StatusConflict, map[string]string{"error": fmt.Sprintf("%s is already being ingested into %s", name, req.Collection)})
+	}
+	runningIngestions[key] = true
+	runningIngestionsMu.Unlock()
+
+	ingestion := DatasetIngestion{Dataset: name, Collection: req.Collection, Mapping: req.DatasetMapping, Status: ingestionRunning, StartedAt: time.Now().UTC()}
+	if err := db.Create(&ingestion); err != nil {
+		runningIngestionsMu.Lock()
+		delete(runningIngestions, key)
+		runningIngestionsMu.Unlock()
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to record dataset ingestion"})
+	}
+
+	running := ingestion
+	go func() {
+		defer func() {
+			runningIngestionsMu.Lock()
+			delete(runningIngestions, key)
+			runningIngestionsMu.Unlock()
+		}()
+		ingestDataset(context.Background(), path, &running, req.Limit)
+	}()
+	return c.JSON(http.StatusAccepted, ingestion)
+}
+
+// ingestDataset stores the rows of a dataset as documents of the collection of the ingestion, a
+// batch at a time, recording its progress and outcome.
+func ingestDataset(ctx context.Context, path string, ingestion *DatasetIngestion, limit int) {
+	err := func() error {
+		r, err := datasets.Open(path)
+		if err != nil {
+			return err
+		}
+		defer r.Close()
+		for limit <= 0 || ingestion.Rows < limit {
+			n := maxStoreDocuments
+			if limit > 0 {
+				n = min(n, limit-ingestion.Rows)
+			}
+			rows, err := r.Read(n)
+			if err == io.EOF {
+				return nil
+			}
+			if err != nil {
+				return err
+			}
+
+			docs := make([]documents.Document, 0, len(rows))
+			for i, row := range rows {
+				if doc, ok := datasetDocument(ingestion, row, ingestion.Rows+i); ok {
+					docs = append(docs, doc)
+				}
+			}
+			ingestion.Rows += len(rows)
+			if len(docs) > 0 {
+				results, err := storeDocuments(ctx, docs)
+				if err != nil {
+					return err
+				}
+				for _, result := range results {
+					if result.Error != "" {
+						ingestion.Failed++
+						continue
+					}
+					ingestion.Documents++
+					ingestion.Chunks += result.Chunks
+				}
+			}
+			if err := db.db.Save(ingestion).Error; err != nil {
+				slog.Error("failed to record dataset ingestion progress", "dataset", ingestion.Dataset, "error", err)
+			}
+		}
+		return nil
+	}()
+
+	finished := time.Now().UTC()
+	ingestion.FinishedAt = &finished
+	ingestion.Status = ingestionCompleted
+	if err != nil {
+		ingestion.Status, ingestion.Error = ingestionFailed, err.Error()
+		slog.Error("failed to ingest dataset", "dataset", ingestion.Dataset, "collection", ingestion.Collection, "error", err)
+	} else {
+		slog.Info("ingested dataset", "dataset", ingestion.Dataset, "collection", ingestion.Collection, "documents", ingestion.Documents, "chunks", ingestion.Chunks, "failed", ingestion.Failed)
+	}
+	if err := db.db.Save(ingestion).Error; err != nil {
+		slog.Error("failed to record dataset ingestion", "dataset", ingestion.Dataset, "error", err)
+	}
+	notifyIngest(IngestEvent{Job: "dataset", Source: ingestion.Dataset, Documents: ingestion.Documents, Chunks: ingestion.Chunks, Failed: ingestion.Failed}, err)
+}
+
+// datasetDocument returns the document of a row of a dataset, with the columns of the mapping. Rows
+// without text are skipped.
+func datasetDocument(ingestion *DatasetIngestion, row datasets.Row, index int) (documents.Document, bool) {
+	mapping := ingestion.Mapping
+	var content []string
+	for _, column := range mapping.Text {
+		value := strings.TrimSpace(row[column])
+		switch {
+		case value == "":
+		case len(mapping.Text) == 1:
+			content = append(content, value)
+		default:
+			content = append(content, column+": "+value)
+		}
+	}
+	if len(content) == 0 {
+		return documents.Document{}, false
+	}
+
+	id := strconv.Itoa(index)
+	if mapping.ID != "" && row[mapping.ID] != "" {
+		id = row[mapping.ID]
+	}
+	metadata := make(map[string]string, len(mapping.Metadata)+5)
+	for _, column := range mapping.Metadata {
+		if value, ok := row[column]; ok {
+			metadata[column] = value
+		}
+	}
+	metadata["source"] = fmt.Sprintf("datasets/%s/%s/%s", ingestion.Collection, ingestion.Dataset, id)
+	metadata["file_path"] = ingestion.Dataset
+	metadata["dataset"] = ingestion.Dataset
+	metadata["collection"] = ingestion.Collection
+	metadata["row"] = strconv.Itoa(index)
+	return documents.Document{PageContent: strings.Join(content, "\n"), Metadata: metadata}, true
+}
+
+// failRunningIngestions marks the ingestions left running by a previous process as failed.
+func failRunningIngestions(sqldb *SQLiteDB) error {
+	return sqldb.db.Model(&DatasetIngestion{}).Where("status = ?", ingestionRunning).
+		Updates(map[string]interface{}{"status": ingestionFailed, "error": "interrupted by a restart"}).Error
+}
@@ -0,0 +1,112 @@
+// datacatalog_test.go
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"manifold/internal/documents"
+)
+
+func TestDatasetCatalog(t *testing.T) {
+	testDB, err := NewSQLiteDB(t.TempDir())
+	require.NoError(t, err)
+	require.NoError(t, testDB.AutoMigrate(&DatasetIngestion{}))
+	savedDB := db
+	db = testDB
+	defer func() { db = savedDB }()
+
+	index, err := documents.NewIndexManager(filepath.Join(t.TempDir(), "index"))
+	require.NoError(t, err)
+	defer index.Index.Close()
+	savedManager := docManager
+	docManager = documents.NewDocumentManager(2048, 0, index)
+	defer func() { docManager = savedManager }()
+
+	config := &Config{DataPath: t.TempDir()}
+	require.NoError(t, os.MkdirAll(datasetsDir(config), 0o755))
+	faq := "id,question,answer,topic\nq1,What is bleve?,A text index.,search\nq2,What is parquet?,A columnar format.,storage\nq3,,,empty\n"
+	require.NoError(t, os.WriteFile(filepath.Join(datasetsDir(config), "faq.csv"), []byte(faq), 0o644))
+
+	e := echo.New()
+	call := func(method, target, body string, handler echo.HandlerFunc, name string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, target, strings.NewReader(body))
+		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		rec := httptest.NewRecorder()
+		c := e.NewContext(req, rec)
+		if name != "" {
+			c.SetParamNames("name")
+			c.SetParamValues(name)
+		}
+		require.NoError(t, handler(c))
+		return rec
+	}
+	withConfig := func(handler func(echo.Context, *Config) error) echo.HandlerFunc {
+		return func(c echo.Context) error { return handler(c, config) }
+	}
+
+	rec := call(http.MethodGet, "/v1/datasets/faq.csv?rows=1", "", withConfig(handleGetDataset), "faq.csv")
+	require.Equal(t, http.StatusOK, rec.Code)
+	var entry DatasetEntry
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &entry))
+	assert.EqualValues(t, 3, entry.Rows)
+	assert.Len(t, entry.Columns, 4)
+	require.Len(t, entry.Preview, 1)
+	assert.Equal(t, "What is bleve?", entry.Preview[0]["question"])
+
+	rec = call(http.MethodGet, "/v1/datasets/..", "", withConfig(handleGetDataset), "../faq.csv")
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+	rec = call(http.MethodPost, "/v1/datasets/faq.csv/ingest", `{"text": ["question", "missing"]}`, withConfig(handleIngestDataset), "faq.csv")
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+
+	rec = call(http.MethodPost, "/v1/datasets/faq.csv/ingest", `{"collection": "kb", "text": ["question", "answer"], "id": "id", "metadata": ["topic"]}`, withConfig(handleIngestDataset), "faq.csv")
+	require.Equal(t, http.StatusAccepted, rec.Code)
+	var ingestion DatasetIngestion
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &ingestion))
+	assert.Equal(t, ingestionRunning, ingestion.Status)
+
+	require.Eventually(t, func() bool {
+		ingestions, err := testDB.GetDatasetIngestions("faq.csv", "kb")
+		require.NoError(t, err)
+		require.Len(t, ingestions, 1)
+		ingestion = ingestions[0]
+		return ingestion.Status != ingestionRunning
+	}, 5*time.Second, 10*time.Millisecond)
+	assert.Equal(t, ingestionCompleted, ingestion.Status)
+	assert.Equal(t, 3, ingestion.Rows)
+	assert.Equal(t, 2, ingestion.Documents)
+	assert.Equal(t, 2, ingestion.Chunks)
+	assert.Equal(t, []string{"question", "answer"}, ingestion.Mapping.Text)
+	require.NotNil(t, ingestion.FinishedAt)
+
+	version, _, err := index.DocumentVersion("datasets/kb/faq.csv/q2")
+	require.NoError(t, err)
+	assert.Equal(t, 1, version)
+	var stored documents.Document
+	for _, doc := range docManager.Documents {
+		if doc.Metadata["source"] == "datasets/kb/faq.csv/q2" {
+			stored = doc
+		}
+	}
+	assert.Equal(t, "question: What is parquet?\nanswer: A columnar format.", stored.PageContent)
+	assert.Equal(t, "storage", stored.Metadata["topic"])
+	assert.Equal(t, "kb", stored.Metadata["collection"])
+
+	rec = call(http.MethodGet, "/v1/datasets", "", withConfig(handleGetDatasets), "")
+	require.Equal(t, http.StatusOK, rec.Code)
+	var entries []DatasetEntry
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &entries))
+	require.Len(t, entries, 1)
+	require.Len(t, entries[0].Ingestions, 1)
+	assert.Equal(t, "kb", entries[0].Ingestions[0].Collection)
+}
@@ -0,0 +1,304 @@
+// manifold/dataset.go
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"gorm.io/gorm"
+)
+
+const (
+	DatasetFormatOpenAI   = "openai"
+	DatasetFormatShareGPT = "sharegpt"
+)
+
+// DatasetFilter selects the chat turns exported as a fine-tuning dataset. Fields that are not set
+// match every turn.
+type DatasetFilter struct {
+	Sessions   []string  // websocket session ids
+	Model      string    // the model that answered
+	Role       string    // the completions role of the session
+	Since      time.Time // turns at or after
+	Until      time.Time // turns before
+	Rating     int       // only responses with this rating, 1 for thumbs up and -1 for thumbs down
+	PerSession bool      // one example per session with all of its turns, otherwise one per turn
+}
+
+// datasetMessage is a message of an exported example.
+type datasetMessage struct {
+	Role    string `json:"role"`
+	Content string `json:"content"`
+}
+
+// shareGPTMessage is a message of an example in the ShareGPT format.
+type shareGPTMessage struct {
+	From  string `json:"from"`
+	Value string `json:"value"`
+}
+
+var shareGPTRoles = map[string]string{"system": "system", "user": "human", "assistant": "gpt"}
+
+var (
+	chatHostOnce sync.Once
+	chatHost     SystemInfo
+)
+
+// hostSystemInfo describes the machine answering chats. It is looked up once.
+func hostSystemInfo() SystemInfo {
+	chatHostOnce.Do(func() {
+		host := NewHostInfoProvider()
+		chatHost = SystemInfo{
+			OS:     host.GetOS(),
+			Arch:   host.GetArch(),
+			CPUs:   host.GetCPUs(),
+			Memory: Memory{Total: int64(host.GetMemory())},
+		}
+		if gpus, err := host.GetGPUs(); err == nil {
+			for _, gpu := range gpus {
+				chatHost.GPUs = append(chatHost.GPUs, GPU{Model: gpu.GetModel(), TotalNumberOfCores: gpu.GetTotalNumberOfCores(), MetalSupport: gpu.GetMetalSupport()})
+			}
+		}
+	})
+	return chatHost
+}
+
+// SaveChatHistory stores a turn and its responses under the session, creating the session the
+// first time it is seen.
+func (sqldb *SQLiteDB) SaveChatHistory(sessionKey string, turn *ChatTurn) error {
+	return sqldb.db.Transaction(func(tx *gorm.DB) error {
+		var session ChatSession
+		if err := tx.Where(ChatSession{Key: sessionKey}).FirstOrCreate(&session).Error; err != nil {
+			return err
+		}
+		if err := tx.Model(&session).Update("updated_at", time.Now()).Error; err != nil {
+			return err
+		}
+		turn.SessionID = session.ID
+		return tx.Create(turn).Error
+	})
+}
+
+// recordChatHistory stores an answered chat turn so it can be rated and exported later, and
+// returns the id of the stored response. Personal data is redacted first when configured, and
+// failures are only logged.
+func recordChatHistory(ctx context.Context, turn ChatTurn, model, response string) int64 {
+	if response == "" {
+		return 0
+	}
+	turn.Responses = []ChatResponse{{Content: response, Model: model}}
+	if !recordChatTurn(ctx, &turn) {
+		return 0
+	}
+	return turn.Responses[0].ID
+}
+
+// recordChatTurn stores a turn with its responses under the session of the context and reports
+// whether it was stored.
+func recordChatTurn(ctx context.Context, turn *ChatTurn) bool {
+	info := turnFromContext(ctx)
+	if info.SessionID == "" || db == nil {
+		return false
+	}
+	turn.Key = info.TurnID
+	host := hostSystemInfo()
+	for i := range turn.Responses {
+		_, turn.Responses[i].Content = redactChatTurn(ctx, "", turn.Responses[i].Content)
+		turn.Responses[i].Host = host
+	}
+	turn.UserPrompt, _ = redactChatTurn(ctx, turn.UserPrompt, "")
+
+	if err := db.SaveChatHistory(info.SessionID, turn); err != nil {
+		loggerFromContext(ctx).Error("failed to save chat history", "error", err)
+		return false
+	}
+	return true
+}
+
+// GetDatasetTurns returns the turns matching the filter with their responses, oldest first and
+// grouped by session.
+func (sqldb *SQLiteDB) GetDatasetTurns(filter DatasetFilter) ([]ChatTurn, error) {
+	query := sqldb.db.Model(&ChatTurn{})
+	if len(filter.Sessions) > 0 {
+		query = query.Where("session_id IN (?)", sqldb.db.Model(&ChatSession{}).Select("id").Where("`key` IN ?", filter.Sessions))
+	}
+	if filter.Role != "" {
+		query = query.Where("role = ?", filter.Role)
+	}
+	if !filter.Since.IsZero() {
+		query = query.Where("created_at >= ?", filter.Since)
+	}
+	if !filter.Until.IsZero() {
+		query = query.Where("created_at < ?", filter.Until)
+	}
+	if filter.Model != "" || filter.Rating != 0 {
+		responses := sqldb.db.Model(&ChatResponse{}).Select("1").Where("chat_responses.turn_id = chat_turns.id")
+		if filter.Model != "" {
+			responses = responses.Where("model = ?", filter.Model)
+		}
+		if filter.Rating != 0 {
+			responses = responses.Where("rating = ?", filter.Rating)
+		}
+		query = query.Where("EXISTS (?)", responses)
+	}
+
+	var turns []ChatTurn
+	err := query.Preload("Responses", func(tx *gorm.DB) *gorm.DB {
+		return tx.Order("id")
+	}).Order("session_id, id").Find(&turns).Error
+	return turns, err
+}
+
+// datasetResponse picks the response of a turn to train on among those matching the filter's
+// model and rating: the one the user preferred in a comparison, otherwise the latest.
+func datasetResponse(turn ChatTurn, filter DatasetFilter) (ChatResponse, bool) {
+	matches := func(response ChatResponse) bool {
+		return response.Content != "" && (filter.Model == "" || response.Model == filter.Model) && (filter.Rating == 0 || response.Rating == filter.Rating)
+	}
+	for _, response := range turn.Responses {
+		if response.ID == turn.PreferredResponseID && matches(response) {
+			return response, true
+		}
+	}
+	for i := len(turn.Responses) - 1; i >= 0; i-- {
+		if matches(turn.Responses[i]) {
+			return turn.Responses[i], true
+		}
+	}
+	return ChatResponse{}, false
+}
+
+// datasetExamples turns chat turns into conversations, one per turn or one per session. A
+// conversation starts with the system prompt of its first turn.
+func datasetExamples(turns []ChatTurn, filter DatasetFilter) [][]datasetMessage {
+	var examples [][]datasetMessage
+	var current []datasetMessage
+	var session int64
+	for _, turn := range turns {
+		response, ok := datasetResponse(turn, filter)
+		if !ok {
+			continue
+		}
+		if current != nil && (!filter.PerSession || turn.SessionID != session) {
+			examples = append(examples, current)
+			current = nil
+		}
+		if current == nil && turn.Instructions != "" {
+			current = append(current, datasetMessage{Role: "system", Content: turn.Instructions})
+		}
+		current = append(current,
+			datasetMessage{Role: "user", Content: turn.UserPrompt},
+			datasetMessage{Role: "assistant", Content: response.Content})
+		session = turn.SessionID
+	}
+	if current != nil {
+		examples = append(examples, current)
+	}
+	return examples
+}
+
+// WriteDataset writes the examples as JSONL in the OpenAI chat fine-tuning or ShareGPT format and
+// returns the number written.
+func WriteDataset(w io.Writer, format string, examples [][]datasetMessage) (int, error) {
+	encoder := json.NewEncoder(w)
+	encoder.SetEscapeHTML(false)
+	for i, messages := range examples {
+		var line interface{}
+		switch format {
+		case DatasetFormatOpenAI:
+			line = map[string][]datasetMessage{"messages": messages}
+		case DatasetFormatShareGPT:
+			conversation := make([]shareGPTMessage, len(messages))
+			for j, m := range messages {
+				conversation[j] = shareGPTMessage{From: shareGPTRoles[m.Role], Value: m.Content}
+			}
+			line = map[string][]shareGPTMessage{"conversations": conversation}
+		default:
+			return i, fmt.Errorf("unknown dataset format %q", format)
+		}
+		if err := encoder.Encode(line); err != nil {
+			return i, err
+		}
+	}
+	return len(examples), nil
+}
+
+// parseDatasetTime parses an RFC 3339 time or a date. A date used as an upper bound includes the
+// whole day.
+func parseDatasetTime(v string, endOfDay bool) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, v); err == nil {
+		return t, nil
+	}
+	t, err := time.Parse(time.DateOnly, v)
+	if err != nil {
+		return time.Time{}, err
+	}
+	if endOfDay {
+		t = t.AddDate(0, 0, 1)
+	}
+	return t, nil
+}
+
+// handleExportDataset exports stored chats as a JSONL fine-tuning dataset. The format is openai
+// (default) or sharegpt, and turns can be filtered by session (comma separated), model, role,
+// since and until (RFC 3339 or a date), and rating (up or down). group=session exports whole
+// sessions as conversations.
+func handleExportDataset(c echo.Context) error {
+	format := c.QueryParam("format")
+	if format == "" {
+		format = DatasetFormatOpenAI
+	}
+	if format != DatasetFormatOpenAI && format != DatasetFormatShareGPT {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid format, use openai or sharegpt"})
+	}
+
+	filter := DatasetFilter{
+		Model:      c.QueryParam("model"),
+		Role:       c.QueryParam("role"),
+		PerSession: c.QueryParam("group") == "session",
+	}
+	if v := c.QueryParam("session"); v != "" {
+		filter.Sessions = strings.Split(v, ",")
+	}
+	var err error
+	if v := c.QueryParam("rating"); v != "" {
+		if filter.Rating, err = parseRating(v); err != nil || filter.Rating == 0 {
+			return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid rating, use up or down"})
+		}
+	}
+	if v := c.QueryParam("since"); v != "" {
+		if filter.Since, err = parseDatasetTime(v, false); err != nil {
+			return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid since, use RFC 3339 or YYYY-MM-DD"})
+		}
+	}
+	if v := c.QueryParam("until"); v != "" {
+		if filter.Until, err = parseDatasetTime(v, true); err != nil {
+			return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid until, use RFC 3339 or YYYY-MM-DD"})
+		}
+	}
+
+	turns, err := db.GetDatasetTurns(filter)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to load chat history"})
+	}
+
+	filename := fmt.Sprintf("manifold-%s-%s.jsonl", format, time.Now().Format("20060102"))
+	c.Response().Header().Set(echo.HeaderContentType, "application/jsonl")
+	c.Response().Header().Set(echo.HeaderContentDisposition, fmt.Sprintf("attachment; filename=%q", filename))
+	c.Response().WriteHeader(http.StatusOK)
+	n, err := WriteDataset(c.Response(), format, datasetExamples(turns, filter))
+	if err != nil {
+		loggerFromContext(c.Request().Context()).Error("failed to write dataset", "error", err)
+		return nil
+	}
+	loggerFromContext(c.Request().Context()).Info("exported dataset", "format", format, "examples", n)
+	return nil
+}
@@ -0,0 +1,94 @@
+// dataset_test.go
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestExportDataset(t *testing.T) {
+	testDB, err := NewSQLiteDB(t.TempDir())
+	require.NoError(t, err)
+	require.NoError(t, testDB.AutoMigrate(&ChatSession{}, &ChatTurn{}, &ChatResponse{}))
+
+	saved := db
+	db = testDB
+	defer func() { db = saved }()
+
+	record := func(session, role, prompt, model, response string) {
+		ctx := withTurn(context.Background(), TurnInfo{SessionID: session, TurnID: newSessionID()})
+		recordChatHistory(ctx, ChatTurn{Role: role, Instructions: "Be " + role, UserPrompt: prompt}, model, response)
+	}
+	record("s1", "coder", "What is Go?", "llama", "A <language>.")
+	record("s1", "coder", "Who made it?", "llama", "Google.")
+	record("s2", "poet", "A haiku", "qwen", "Leaves fall")
+	record("s2", "poet", "Unanswered", "qwen", "")
+
+	e := echo.New()
+	export := func(query string) (int, []map[string]interface{}) {
+		req := httptest.NewRequest(http.MethodGet, "/v1/datasets/export?"+query, nil)
+		rec := httptest.NewRecorder()
+		require.NoError(t, handleExportDataset(e.NewContext(req, rec)))
+		var lines []map[string]interface{}
+		scanner := bufio.NewScanner(rec.Body)
+		for scanner.Scan() {
+			var line map[string]interface{}
+			require.NoError(t, json.Unmarshal(scanner.Bytes(), &line), scanner.Text())
+			lines = append(lines, line)
+		}
+		return rec.Code, lines
+	}
+
+	code, lines := export("")
+	assert.Equal(t, http.StatusOK, code)
+	require.Len(t, lines, 3, "one example per answered turn")
+	first, _ := json.Marshal(lines[0])
+	assert.JSONEq(t, `{"messages": [
+		{"role": "system", "content": "Be coder"},
+		{"role": "user", "content": "What is Go?"},
+		{"role": "assistant", "content": "A <language>."}]}`, string(first))
+
+	_, lines = export("group=session&session=s1")
+	require.Len(t, lines, 1)
+	assert.Len(t, lines[0]["messages"], 5, "the system prompt and both turns")
+
+	_, lines = export("format=sharegpt&model=qwen")
+	require.Len(t, lines, 1)
+	only, _ := json.Marshal(lines[0])
+	assert.JSONEq(t, `{"conversations": [
+		{"from": "system", "value": "Be poet"},
+		{"from": "human", "value": "A haiku"},
+		{"from": "gpt", "value": "Leaves fall"}]}`, string(only))
+
+	_, lines = export("role=coder&since=" + time.Now().Add(-time.Hour).Format(time.RFC3339))
+	assert.Len(t, lines, 2)
+	_, lines = export("until=2000-01-01")
+	assert.Empty(t, lines)
+
+	code, _ = export("format=alpaca")
+	assert.Equal(t, http.StatusBadRequest, code)
+	code, _ = export("since=yesterday")
+	assert.Equal(t, http.StatusBadRequest, code)
+}
+
+func TestParseDatasetTime(t *testing.T) {
+	until, err := parseDatasetTime("2024-05-01", true)
+	require.NoError(t, err)
+	assert.Equal(t, time.Date(2024, 5, 2, 0, 0, 0, 0, time.UTC), until)
+
+	since, err := parseDatasetTime("2024-05-01T10:00:00Z", true)
+	require.NoError(t, err)
+	assert.Equal(t, 10, since.Hour())
+
+	_, err = parseDatasetTime("May 1st", false)
+	assert.Error(t, err)
+}
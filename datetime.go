@@ -0,0 +1,114 @@
+// manifold/datetime.go
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+)
+
+const defaultDateTimeFormat = "Monday, January 2, 2006 15:04 MST"
+
+var (
+	// dateTimePattern detects prompts that depend on the current date or time.
+	dateTimePattern = regexp.MustCompile(`(?i)\b(time|date|today|tonight|tomorrow|yesterday|weekday|this (?:week|month|year)|what day|how long until|how many days)\b`)
+
+	// timezonePattern matches IANA timezone names such as Europe/Berlin or America/New_York.
+	timezonePattern = regexp.MustCompile(`\b[A-Z][A-Za-z]+/[A-Z][A-Za-z_]+(?:/[A-Z][A-Za-z_]+)?\b`)
+)
+
+// DateTimeTool tells the model the current date and time, which it otherwise does not know. It
+// answers in the configured timezone and in every IANA timezone named in the prompt.
+type DateTimeTool struct {
+	enabled  bool
+	always   bool
+	timezone *time.Location
+	format   string
+	now      func() time.Time
+}
+
+// newDateTimeTool creates the tool with the default timezone and format, for tools toggled on at
+// runtime which are created without parameters.
+func newDateTimeTool() *DateTimeTool {
+	return &DateTimeTool{timezone: time.Local, format: defaultDateTimeFormat, now: time.Now}
+}
+
+// Process returns the current date and time when the input refers to them, or always when configured.
+func (t *DateTimeTool) Process(ctx context.Context, input string) (string, error) {
+	if !t.always && !dateTimePattern.MatchString(input) {
+		return "", nil
+	}
+
+	now := t.now()
+	var out strings.Builder
+	fmt.Fprintf(&out, "Current date and time: %s (%s)\n", now.In(t.timezone).Format(t.format), t.timezone)
+
+	seen := map[string]bool{t.timezone.String(): true}
+	for _, name := range timezonePattern.FindAllString(input, -1) {
+		if seen[name] {
+			continue
+		}
+		seen[name] = true
+		loc, err := time.LoadLocation(name)
+		if err != nil {
+			continue
+		}
+		fmt.Fprintf(&out, "In %s: %s\n", name, now.In(loc).Format(t.format))
+	}
+	return out.String(), nil
+}
+
+// Relevant reports whether the prompt depends on the current date or time.
+func (t *DateTimeTool) Relevant(prompt string) bool {
+	return t.always || dateTimePattern.MatchString(prompt)
+}
+
+// Enabled returns the enabled status of the tool.
+func (t *DateTimeTool) Enabled() bool {
+	return t.enabled
+}
+
+// SetParams configures the tool with provided parameters.
+func (t *DateTimeTool) SetParams(params map[string]interface{}, config *Config) error {
+	if enabled, ok := params["enabled"].(bool); ok {
+		t.enabled = enabled
+	}
+	if always, ok := params["always"].(bool); ok {
+		t.always = always
+	}
+
+	t.timezone = time.Local
+	if name, ok := params["timezone"].(string); ok && name != "" {
+		loc, err := time.LoadLocation(name)
+		if err != nil {
+			return fmt.Errorf("invalid timezone %q: %w", name, err)
+		}
+		t.timezone = loc
+	}
+
+	t.format = defaultDateTimeFormat
+	if format, ok := params["format"].(string); ok && format != "" {
+		t.format = format
+	}
+	if t.now == nil {
+		t.now = time.Now
+	}
+	return nil
+}
+
+// GetParams returns the tool's parameters.
+func (t *DateTimeTool) GetParams() map[string]interface{} {
+	timezone := ""
+	if t.timezone != nil {
+		timezone = t.timezone.String()
+	}
+	return map[string]interface{}{
+		"enabled":  t.enabled,
+		"always":   t.always,
+		"timezone": timezone,
+		"format":   t.format,
+	}
+}
@@ -0,0 +1,56 @@
+// datetime_test.go
+package main
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDateTimeTool(t *testing.T) {
+	tool := &DateTimeTool{now: func() time.Time { return time.Date(2026, 10, 16, 12, 30, 0, 0, time.UTC) }}
+	require.NoError(t, tool.SetParams(map[string]interface{}{
+		"enabled":  true,
+		"timezone": "UTC",
+	}, &Config{}))
+
+	out, err := tool.Process(context.Background(), "What time is it in Asia/Tokyo and Not/AZone?")
+	require.NoError(t, err)
+	assert.Equal(t, "Current date and time: Friday, October 16, 2026 12:30 UTC (UTC)\nIn Asia/Tokyo: Friday, October 16, 2026 21:30 JST\n", out)
+
+	out, err = tool.Process(context.Background(), "Explain goroutines")
+	require.NoError(t, err)
+	assert.Empty(t, out)
+
+	require.NoError(t, tool.SetParams(map[string]interface{}{"always": true, "timezone": "UTC", "format": time.DateOnly}, &Config{}))
+	out, err = tool.Process(context.Background(), "Explain goroutines")
+	require.NoError(t, err)
+	assert.Contains(t, out, "2026-10-16")
+}
+
+func TestDateTimeToolInvalidTimezone(t *testing.T) {
+	tool := &DateTimeTool{}
+	assert.Error(t, tool.SetParams(map[string]interface{}{"timezone": "Mars/Olympus"}, &Config{}))
+}
+
+func TestDateTimeToolCreatedAtRuntime(t *testing.T) {
+	tool, err := CreateToolByName("datetime")
+	require.NoError(t, err)
+
+	// Tools toggled on at runtime answer concurrent turns without being configured first
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			out, err := tool.Process(context.Background(), "What is the date today?")
+			assert.NoError(t, err)
+			assert.Contains(t, out, "Current date and time:")
+		}()
+	}
+	wg.Wait()
+}
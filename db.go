@@ -3,18 +3,19 @@ package main
 
 import (
 	"context"
-	"encoding/binary"
 	"errors"
 	"fmt"
 	"io/ioutil"
 	"log"
-	"math"
+	"log/slog"
 	"os"
 	"path/filepath"
 	"regexp"
 	"strings"
 	"time"
 
+	"manifold/internal/sqlitevec"
+
 	"gorm.io/driver/sqlite"
 	"gorm.io/gorm"
 	"gorm.io/gorm/logger"
@@ -25,25 +26,34 @@ type SQLiteDB struct {
 }
 
 type ChatSession struct {
-	ID        int64 `json:"id"`
+	ID        int64  `json:"id"`
+	Key       string `gorm:"uniqueIndex" json:"key"` // the websocket session id
 	CreatedAt time.Time
 	UpdatedAt time.Time
-	ChatTurns []ChatTurn `json:"chat_turns"`
+	ChatTurns []ChatTurn `gorm:"foreignKey:SessionID" json:"chat_turns"`
 }
 
 type ChatTurn struct {
-	ID         int64 `json:"id"`
-	SessionID  int64
-	UserPrompt string
-	Responses  []ChatResponse `json:"responses"`
+	ID                  int64  `json:"id"`
+	SessionID           int64  `gorm:"index"`
+	Key                 string `gorm:"index" json:"key"` // the turn id of the tool history and traces
+	Role                string `gorm:"index" json:"role,omitempty"`
+	Instructions        string `json:"instructions,omitempty"` // the system prompt
+	UserPrompt          string
+	Responses           []ChatResponse `gorm:"foreignKey:TurnID" json:"responses"`
+	PreferredResponseID int64          `json:"preferred_response_id,omitempty"` // the response the user preferred in a comparison
+	CreatedAt           time.Time      `gorm:"index"`
 }
 
 type ChatResponse struct {
 	ID        int64 `json:"id"`
-	TurnID    int64
+	TurnID    int64 `gorm:"index"`
 	Content   string
-	Model     string // Identifier for the LLM model used
-	Host      SystemInfo
+	Model     string     `gorm:"index"` // Identifier for the LLM model used
+	Host      SystemInfo `gorm:"serializer:json"`
+	Rating    int        `gorm:"index" json:"rating"` // 1 for thumbs up, -1 for thumbs down, 0 when not rated
+	Feedback  string     `json:"feedback,omitempty"`
+	RatedAt   *time.Time `json:"rated_at,omitempty"`
 	CreatedAt time.Time
 }
 
@@ -88,6 +98,25 @@ type LanguageModel struct {
 	TopK              int     `json:"top_k"`
 	RepetitionPenalty float64 `json:"repetition_penalty"`
 	Ctx               int     `json:"ctx"`
+	Architecture      string  `json:"architecture,omitempty"`    // From the GGUF header
+	ParameterCount    int64   `json:"parameter_count,omitempty"` // Total tensor elements
+	Quantization      string  `json:"quantization,omitempty"`    // e.g. Q4_K_M
+	ContextLength     int     `json:"context_length,omitempty"`  // Trained context length
+	ChatTemplate      string  `json:"chat_template,omitempty"`   // Jinja chat template
+}
+
+// applyGGUFMetadata reads the GGUF header of the model file and stores its metadata on the model.
+func (m *LanguageModel) applyGGUFMetadata() error {
+	meta, err := ReadGGUFMetadata(m.Path)
+	if err != nil {
+		return err
+	}
+	m.Architecture = meta.Architecture
+	m.ParameterCount = meta.ParameterCount
+	m.Quantization = meta.Quantization
+	m.ContextLength = meta.ContextLength
+	m.ChatTemplate = meta.ChatTemplate
+	return nil
 }
 
 // TableName sets the table name for GORM.
@@ -117,11 +146,24 @@ type Chat struct {
 	Response  string `json:"response"`
 	ModelName string `json:"modelName"`
 	Embedding []byte `json:"embedding"`
+	AudioPath string `json:"audio_path,omitempty"` // Spoken response, see handleSpeakChat
 }
 
+// URLTracking is a tracked source, re-ingested on its schedule by the source scheduler: a web page,
+// a Git repository or an RSS or Atom feed. It records how its last refresh went.
 type URLTracking struct {
-	ID  int64  `json:"id"`
-	URL string `json:"url"`
+	ID       int64  `json:"id"`
+	URL      string `gorm:"index" json:"url"`
+	Kind     string `json:"kind"`               // url, git or rss
+	Branch   string `json:"branch,omitempty"`   // of git sources, the default branch when empty
+	Schedule string `json:"schedule,omitempty"` // cron expression, refreshed on demand only when empty
+
+	LastRefreshAt *time.Time `json:"last_refresh_at,omitempty"`
+	LastStatus    string     `json:"last_status,omitempty"` // ok or failed
+	LastError     string     `json:"last_error,omitempty"`
+	LastDocuments int        `json:"last_documents"`
+	LastChunks    int        `json:"last_chunks"`
+	NextRefreshAt *time.Time `gorm:"index" json:"next_refresh_at,omitempty"`
 }
 
 type ToolMetadata struct {
@@ -158,6 +200,9 @@ func NewSQLiteDB(dataPath string) (*SQLiteDB, error) {
 		},
 	)
 
+	// Load sqlite-vec in the connections of the database, for the vec0 tables of CreateVectorTables
+	sqlitevec.Register()
+
 	db, err := gorm.Open(sqlite.Open(dbPath), &gorm.Config{
 		Logger: newLogger,
 	})
@@ -185,19 +230,6 @@ func (sqldb *SQLiteDB) EnableSQLiteExtensionLoading() error {
 	return nil
 }
 
-// Load the sqlite-vec extension
-func (sqldb *SQLiteDB) LoadVecExtension() error {
-	// Load the sqlite-vec extension
-	db := sqldb.db
-
-	// Use Exec().Error to get the error result
-	if err := db.Exec("SELECT load_extension('libsqlitevec.dylib', 'sqlite3_vec_init');").Error; err != nil {
-		return fmt.Errorf("could not load sqlite-vec extension: %w", err)
-	}
-
-	return nil
-}
-
 func (sqldb *SQLiteDB) AutoMigrate(models ...interface{}) error {
 	for _, model := range models {
 		if err := sqldb.db.AutoMigrate(model); err != nil {
@@ -243,18 +275,16 @@ func loadCompletionsRolesToDB(db *SQLiteDB, roles []CompletionsRole) error {
 		var existingRole CompletionsRole
 		err := db.First(role.Name, &existingRole)
 
-		if err != nil {
-			if errors.Is(err, gorm.ErrRecordNotFound) {
-				if err := db.Create(&role); err != nil {
-					return err
-				}
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			if err := db.Create(&role); err != nil {
+				return err
 			}
-		} else {
+		} else if err != nil {
 			return err
 		}
 	}
 
-	log.Println("Completions roles data loaded to database")
+	slog.Info("completions roles loaded to database", "count", len(roles))
 
 	return nil
 }
@@ -324,28 +354,47 @@ func DeleteChat(db *gorm.DB, id int64) error {
 	return db.Delete(&Chat{}, id).Error
 }
 
-func (sqldb *SQLiteDB) CreateURLTracking(url string) error {
-	var existingURLTracking URLTracking
-
-	err := sqldb.First(url, &existingURLTracking)
+// CreateURLTracking tracks a source. A source of the same kind is tracked once per URL; tracking it
+// again returns the existing one.
+func (sqldb *SQLiteDB) CreateURLTracking(tracking *URLTracking) error {
+	var existing URLTracking
+	err := sqldb.db.Where("url = ? AND kind = ?", tracking.URL, tracking.Kind).First(&existing).Error
 	if err == nil {
+		*tracking = existing
 		return nil
 	} else if !errors.Is(err, gorm.ErrRecordNotFound) {
 		return err
 	}
-
-	urlTracking := URLTracking{URL: url}
-	return sqldb.Create(&urlTracking)
+	return sqldb.Create(tracking)
 }
 
 func (sqldb *SQLiteDB) ListURLTrackings() ([]URLTracking, error) {
 	var urlTrackings []URLTracking
-	err := sqldb.Find(&urlTrackings)
+	err := sqldb.db.Order("id").Find(&urlTrackings).Error
 	return urlTrackings, err
 }
 
-func (sqldb *SQLiteDB) DeleteURLTracking(url string) error {
-	return sqldb.db.Where("url = ?", url).Delete(&URLTracking{}).Error
+// GetURLTracking returns a tracked source by ID.
+func (sqldb *SQLiteDB) GetURLTracking(id int64) (URLTracking, error) {
+	var tracking URLTracking
+	err := sqldb.db.First(&tracking, id).Error
+	return tracking, err
+}
+
+// DueURLTrackings returns the scheduled sources whose next refresh is at or before now.
+func (sqldb *SQLiteDB) DueURLTrackings(now time.Time) ([]URLTracking, error) {
+	var urlTrackings []URLTracking
+	err := sqldb.db.Where("schedule <> '' AND next_refresh_at IS NOT NULL AND next_refresh_at <= ?", now).Order("next_refresh_at").Find(&urlTrackings).Error
+	return urlTrackings, err
+}
+
+// SaveURLTracking updates a tracked source.
+func (sqldb *SQLiteDB) SaveURLTracking(tracking *URLTracking) error {
+	return sqldb.db.Save(tracking).Error
+}
+
+func (sqldb *SQLiteDB) DeleteURLTracking(id int64) error {
+	return sqldb.db.Delete(&URLTracking{}, id).Error
 }
 
 // CreateToolMetadata adds a new tool to the database
@@ -391,7 +440,7 @@ func (sqldb *SQLiteDB) GetToolMetadataByName(name string) (*ToolMetadata, error)
 // UpdateToolMetadataByName updates the 'enabled' status of a tool by its name.
 func (sqldb *SQLiteDB) UpdateToolMetadataByName(name string, enabled bool) error {
 
-	log.Printf("Updating tool '%s' status to %v", name, enabled)
+	slog.Debug("updating tool status", "tool", name, "enabled", enabled)
 
 	result := sqldb.db.Model(&ToolMetadata{}).Where("name = ?", name).Update("enabled", enabled)
 	if result.Error != nil {
@@ -435,7 +484,7 @@ func loadToolsToDB(db *SQLiteDB, tools []ToolConfig) error {
 			}
 		}
 	}
-	log.Println("Tools metadata loaded to database")
+	slog.Info("tools metadata loaded to database", "count", len(tools))
 	return nil
 }
 
@@ -489,7 +538,7 @@ func sanitizeFTSQuery(query string) string {
 
 func (sqldb *SQLiteDB) RetrieveTopNDocuments(ctx context.Context, query string, topN int) ([]string, error) {
 
-	log.Printf("Retrieving top %d documents for query: %s", topN, query)
+	slog.Debug("retrieving top documents", "top_n", topN, "query", truncateForLog(query, 200))
 
 	// Step 1: Initial query sanitization and execution
 	sanitizedQuery := sanitizeFTSQuery(query)
@@ -563,7 +612,7 @@ func (sqldb *SQLiteDB) executeFTSQuery(ftsQuery string, topN int) ([]string, err
 	}
 
 	// Log the query for debugging purposes
-	log.Printf("Executing FTS5 Query: %s", ftsQuery)
+	slog.Debug("executing FTS5 query", "query", ftsQuery)
 
 	// Execute the FTS5 query with match syntax
 	err := sqldb.db.Raw(`
@@ -587,23 +636,6 @@ func (sqldb *SQLiteDB) executeFTSQuery(ftsQuery string, topN int) ([]string, err
 	return formattedResults, nil
 }
 
-func embeddingToBlob(embedding []float64) []byte {
-	buf := make([]byte, len(embedding)*8) // Each float64 is 8 bytes
-	for i, v := range embedding {
-		binary.LittleEndian.PutUint64(buf[i*8:(i+1)*8], math.Float64bits(v))
-	}
-	return buf
-}
-
-// blobToEmbedding converts a byte slice (BLOB) back into a slice of float64 values.
-func blobToEmbedding(blob []byte) []float64 {
-	embedding := make([]float64, len(blob)/8) // Each float64 is 8 bytes
-	for i := 0; i < len(embedding); i++ {
-		embedding[i] = math.Float64frombits(binary.LittleEndian.Uint64(blob[i*8:]))
-	}
-	return embedding
-}
-
 // ScanGGUFModels scans the "models-gguf" directory and returns a list of models.
 func ScanGGUFModels(modelsDir string) ([]LanguageModel, error) {
 	var ggufModels []LanguageModel
@@ -621,14 +653,15 @@ func ScanGGUFModels(modelsDir string) ([]LanguageModel, error) {
 
 			files, err := ioutil.ReadDir(modelDir)
 			if err != nil {
-				log.Printf("Failed to read directory %s: %v", modelDir, err)
+				slog.Warn("failed to read model directory", "path", modelDir, "error", err)
 				continue
 			}
 
 			for _, file := range files {
-				if !file.IsDir() && strings.HasSuffix(file.Name(), ".gguf") {
+				// Multimodal projectors are loaded alongside their model, not served on their own
+				if !file.IsDir() && strings.HasSuffix(file.Name(), ".gguf") && !strings.HasPrefix(file.Name(), "mmproj") {
 					fullPath := filepath.Join(modelDir, file.Name())
-					ggufModels = append(ggufModels, LanguageModel{
+					model := LanguageModel{
 						Name:              modelName,
 						Path:              fullPath,
 						ModelType:         "gguf",
@@ -637,7 +670,11 @@ func ScanGGUFModels(modelsDir string) ([]LanguageModel, error) {
 						TopK:              50,
 						RepetitionPenalty: 1.1,
 						Ctx:               4096,
-					})
+					}
+					if err := model.applyGGUFMetadata(); err != nil {
+						slog.Warn("failed to read model metadata", "path", fullPath, "error", err)
+					}
+					ggufModels = append(ggufModels, model)
 					break // Only first gguf file per model
 				}
 			}
@@ -664,7 +701,7 @@ func ScanMLXModels(modelsDir string) ([]LanguageModel, error) {
 
 			files, err := os.ReadDir(modelDir)
 			if err != nil {
-				log.Printf("Failed to read directory %s: %v", modelDir, err)
+				slog.Warn("failed to read model directory", "path", modelDir, "error", err)
 				continue
 			}
 
@@ -704,16 +741,24 @@ func (sqldb *SQLiteDB) SyncModels(models []LanguageModel) error {
 		if errors.Is(err, gorm.ErrRecordNotFound) {
 			// Insert new model
 			if err := sqldb.db.Create(&model).Error; err != nil {
-				log.Printf("Failed to insert model %s (%s): %v", model.Name, model.ModelType, err)
+				slog.Error("failed to insert model", "model", model.Name, "type", model.ModelType, "error", err)
 				continue
 			}
-			log.Printf("Inserted new model: %s (%s)", model.Name, model.ModelType)
+			slog.Info("inserted new model", "model", model.Name, "type", model.ModelType)
 		} else if err != nil {
-			log.Printf("Error querying model %s (%s): %v", model.Name, model.ModelType, err)
+			slog.Error("error querying model", "model", model.Name, "type", model.ModelType, "error", err)
 			continue
-		} else {
-			// Model already exists, optionally update fields if needed
-			// For now, do nothing
+		} else if existing.Architecture == "" && model.Architecture != "" {
+			// Backfill the metadata of models scanned before it was read
+			if err := sqldb.db.Model(&existing).Updates(LanguageModel{
+				Architecture:   model.Architecture,
+				ParameterCount: model.ParameterCount,
+				Quantization:   model.Quantization,
+				ContextLength:  model.ContextLength,
+				ChatTemplate:   model.ChatTemplate,
+			}).Error; err != nil {
+				slog.Error("failed to update model metadata", "model", model.Name, "error", err)
+			}
 		}
 	}
 
@@ -726,16 +771,35 @@ func (sqldb *SQLiteDB) SyncModels(models []LanguageModel) error {
 	for _, dbModel := range dbModels {
 		if !fileExists(dbModel.Path) {
 			if err := sqldb.db.Delete(&dbModel).Error; err != nil {
-				log.Printf("Failed to delete model %s (%s): %v", dbModel.Name, dbModel.ModelType, err)
+				slog.Error("failed to delete model", "model", dbModel.Name, "type", dbModel.ModelType, "error", err)
 				continue
 			}
-			log.Printf("Deleted missing model: %s (%s)", dbModel.Name, dbModel.ModelType)
+			slog.Info("deleted missing model", "model", dbModel.Name, "type", dbModel.ModelType)
 		}
 	}
 
 	return nil
 }
 
+// UpdateGGUFMetadata reads the GGUF header of every gguf model without metadata and stores it.
+func (sqldb *SQLiteDB) UpdateGGUFMetadata() error {
+	var models []LanguageModel
+	if err := sqldb.db.Where("model_type = ? AND (architecture IS NULL OR architecture = '')", "gguf").Find(&models).Error; err != nil {
+		return fmt.Errorf("failed to retrieve models from DB: %v", err)
+	}
+
+	for _, model := range models {
+		if err := model.applyGGUFMetadata(); err != nil {
+			slog.Warn("failed to read model metadata", "model", model.Name, "path", model.Path, "error", err)
+			continue
+		}
+		if err := sqldb.db.Save(&model).Error; err != nil {
+			slog.Error("failed to update model metadata", "model", model.Name, "error", err)
+		}
+	}
+	return nil
+}
+
 func GetModelsByBackend(db *gorm.DB, backend string) ([]LanguageModel, error) {
 	var models []LanguageModel
 	err := db.Where("model_type = ?", backend).Find(&models).Error
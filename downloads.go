@@ -0,0 +1,415 @@
+// manifold/downloads.go
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+// downloadProgressInterval limits how often progress events are published for a job.
+const downloadProgressInterval = 500 * time.Millisecond
+
+// Download job states.
+const (
+	DownloadRunning   = "running"
+	DownloadPaused    = "paused"
+	DownloadCompleted = "completed"
+	DownloadFailed    = "failed"
+	DownloadCanceled  = "canceled"
+)
+
+// errDownloadPaused is the cancel cause of a paused job, so it is not treated as a failure.
+var errDownloadPaused = errors.New("download paused")
+
+// DownloadJob is a model download running in the background.
+type DownloadJob struct {
+	ID        string    `json:"id"`
+	Repo      string    `json:"repo"`
+	File      string    `json:"file"`
+	Dest      string    `json:"dest"`
+	Checksum  string    `json:"sha256,omitempty"`
+	Size      int64     `json:"size"`
+	Done      int64     `json:"done"`
+	Status    string    `json:"status"`
+	Error     string    `json:"error,omitempty"`
+	Started   time.Time `json:"started"`
+	Updated   time.Time `json:"updated"`
+	cancel    context.CancelCauseFunc
+	published time.Time
+}
+
+// DownloadManager runs model downloads as background jobs and publishes their progress to
+// subscribers, such as the /v1/downloads/events SSE stream.
+type DownloadManager struct {
+	mu          sync.Mutex
+	jobs        map[string]*DownloadJob
+	subscribers map[chan DownloadJob]struct{}
+}
+
+var downloadManager = NewDownloadManager()
+
+// NewDownloadManager creates a download manager without jobs.
+func NewDownloadManager() *DownloadManager {
+	return &DownloadManager{
+		jobs:        make(map[string]*DownloadJob),
+		subscribers: make(map[chan DownloadJob]struct{}),
+	}
+}
+
+// Start looks up file in repo and downloads it into models-gguf in the background.
+func (m *DownloadManager) Start(ctx context.Context, config *Config, repo, file string) (DownloadJob, error) {
+	files, err := NewHFClient(config.HuggingFaceToken).ListGGUFFiles(ctx, repo)
+	if err != nil {
+		return DownloadJob{}, err
+	}
+
+	var found *HFFile
+	for i := range files {
+		if files[i].Path == file {
+			found = &files[i]
+		}
+	}
+	if found == nil {
+		return DownloadJob{}, fmt.Errorf("file %s not found in %s", file, repo)
+	}
+
+	dest := ggufModelPath(config.DataPath, found.Path)
+
+	m.mu.Lock()
+	for _, job := range m.jobs {
+		if job.Dest == dest && (job.Status == DownloadRunning || job.Status == DownloadPaused) {
+			m.mu.Unlock()
+			return DownloadJob{}, fmt.Errorf("%s is already being downloaded", file)
+		}
+	}
+	job := &DownloadJob{
+		ID:       newSessionID(),
+		Repo:     repo,
+		File:     found.Path,
+		Dest:     dest,
+		Checksum: found.SHA256,
+		Size:     found.Size,
+		Started:  time.Now(),
+	}
+	m.jobs[job.ID] = job
+	ctx, cancel := m.begin(job)
+	m.mu.Unlock()
+
+	m.run(ctx, cancel, config, job)
+	return m.snapshot(job), nil
+}
+
+// begin marks a job as running and returns the context of its download. Callers must hold m.mu, so
+// that no other request can start the same job.
+func (m *DownloadManager) begin(job *DownloadJob) (context.Context, context.CancelCauseFunc) {
+	ctx, cancel := context.WithCancelCause(context.Background())
+	job.cancel = cancel
+	job.Status = DownloadRunning
+	job.Error = ""
+	return ctx, cancel
+}
+
+// run starts the download goroutine of a job marked running with begin. Partial files are resumed.
+func (m *DownloadManager) run(ctx context.Context, cancel context.CancelCauseFunc, config *Config, job *DownloadJob) {
+	m.publish(job, true)
+
+	go func() {
+		defer cancel(nil)
+
+		client := NewHFClient(config.HuggingFaceToken)
+		err := client.Download(ctx, job.Repo, job.File, job.Dest, job.Checksum, func(done, total int64) {
+			m.mu.Lock()
+			job.Done = done
+			if total > 0 {
+				job.Size = total
+			}
+			m.mu.Unlock()
+			m.publish(job, false)
+		})
+
+		if err == nil {
+			err = registerGGUFModels(config)
+		}
+
+		m.mu.Lock()
+		switch {
+		case err == nil:
+			job.Status = DownloadCompleted
+			job.Done = job.Size
+		case errors.Is(context.Cause(ctx), errDownloadPaused):
+			job.Status = DownloadPaused
+		case errors.Is(context.Cause(ctx), context.Canceled):
+			job.Status = DownloadCanceled
+			delete(m.jobs, job.ID)
+		default:
+			job.Status = DownloadFailed
+			job.Error = err.Error()
+		}
+		status := job.Status
+		m.mu.Unlock()
+
+		// Paused and failed downloads keep their partial file to be resumed, canceled ones are dropped
+		if status == DownloadCanceled {
+			removePartialDownload(job)
+		}
+
+		if status == DownloadFailed {
+			slog.Error("model download failed", "repo", job.Repo, "file", job.File, "error", err)
+		} else {
+			slog.Info("model download finished", "repo", job.Repo, "file", job.File, "status", status)
+		}
+		m.publish(job, true)
+	}()
+}
+
+// Pause stops a running download and keeps its partial file so it can be resumed.
+func (m *DownloadManager) Pause(id string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	job, ok := m.jobs[id]
+	if !ok {
+		return fmt.Errorf("download %s not found", id)
+	}
+	if job.Status != DownloadRunning {
+		return fmt.Errorf("download %s is %s", id, job.Status)
+	}
+	job.cancel(errDownloadPaused)
+	return nil
+}
+
+// Resume restarts a paused or failed download from its partial file. A download failing its checksum
+// has no partial file left and starts over.
+func (m *DownloadManager) Resume(config *Config, id string) (DownloadJob, error) {
+	m.mu.Lock()
+	job, ok := m.jobs[id]
+	if !ok {
+		m.mu.Unlock()
+		return DownloadJob{}, fmt.Errorf("download %s not found", id)
+	}
+	if job.Status != DownloadPaused && job.Status != DownloadFailed {
+		m.mu.Unlock()
+		return DownloadJob{}, fmt.Errorf("download %s is %s", id, job.Status)
+	}
+	ctx, cancel := m.begin(job)
+	m.mu.Unlock()
+
+	m.run(ctx, cancel, config, job)
+	return m.snapshot(job), nil
+}
+
+// Cancel stops a download, removes its partial file and removes the job from the list. A running job is
+// removed once its download has stopped.
+func (m *DownloadManager) Cancel(id string) error {
+	m.mu.Lock()
+	job, ok := m.jobs[id]
+	if !ok {
+		m.mu.Unlock()
+		return fmt.Errorf("download %s not found", id)
+	}
+
+	if job.Status == DownloadRunning {
+		job.cancel(context.Canceled)
+		m.mu.Unlock()
+		return nil
+	}
+
+	delete(m.jobs, id)
+	partial := job.Status == DownloadPaused || job.Status == DownloadFailed
+	job.Status = DownloadCanceled
+	m.mu.Unlock()
+
+	if partial {
+		removePartialDownload(job)
+	}
+	m.publish(job, true)
+	return nil
+}
+
+// removePartialDownload removes the partial file of a job that will not be resumed.
+func removePartialDownload(job *DownloadJob) {
+	if err := os.Remove(job.Dest + ".part"); err != nil && !os.IsNotExist(err) {
+		slog.Warn("failed to remove partial download", "path", job.Dest+".part", "error", err)
+	}
+}
+
+// Get returns a job by id.
+func (m *DownloadManager) Get(id string) (DownloadJob, bool) {
+	m.mu.Lock()
+	job, ok := m.jobs[id]
+	m.mu.Unlock()
+	if !ok {
+		return DownloadJob{}, false
+	}
+	return m.snapshot(job), true
+}
+
+// List returns all jobs, most recent first.
+func (m *DownloadManager) List() []DownloadJob {
+	m.mu.Lock()
+	jobs := make([]DownloadJob, 0, len(m.jobs))
+	for _, job := range m.jobs {
+		jobs = append(jobs, *job)
+	}
+	m.mu.Unlock()
+
+	sort.Slice(jobs, func(i, j int) bool { return jobs[i].Started.After(jobs[j].Started) })
+	return jobs
+}
+
+// snapshot copies a job under the lock.
+func (m *DownloadManager) snapshot(job *DownloadJob) DownloadJob {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return *job
+}
+
+// Subscribe returns a channel receiving job updates and a func to unsubscribe.
+func (m *DownloadManager) Subscribe() (<-chan DownloadJob, func()) {
+	ch := make(chan DownloadJob, 16)
+
+	m.mu.Lock()
+	m.subscribers[ch] = struct{}{}
+	m.mu.Unlock()
+
+	return ch, func() {
+		m.mu.Lock()
+		delete(m.subscribers, ch)
+		m.mu.Unlock()
+	}
+}
+
+// publish sends a job update to subscribers. Progress updates are throttled and dropped for slow
+// subscribers; state changes (force) are always attempted.
+func (m *DownloadManager) publish(job *DownloadJob, force bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := time.Now()
+	if !force && now.Sub(job.published) < downloadProgressInterval {
+		return
+	}
+	job.published = now
+	job.Updated = now
+
+	for ch := range m.subscribers {
+		select {
+		case ch <- *job:
+		default:
+		}
+	}
+}
+
+// DownloadRequest selects a GGUF file of a Hugging Face repository to download.
+type DownloadRequest struct {
+	Repo string `json:"repo"`
+	File string `json:"file"`
+}
+
+// handleStartDownload starts downloading a model in the background.
+func handleStartDownload(c echo.Context, config *Config) error {
+	var req DownloadRequest
+	if err := c.Bind(&req); err != nil || req.Repo == "" || req.File == "" {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Repository and file are required"})
+	}
+
+	job, err := downloadManager.Start(c.Request().Context(), config, req.Repo, req.File)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+	}
+	return c.JSON(http.StatusAccepted, job)
+}
+
+// handleListDownloads lists the download jobs.
+func handleListDownloads(c echo.Context) error {
+	return c.JSON(http.StatusOK, downloadManager.List())
+}
+
+// handleGetDownload returns a download job.
+func handleGetDownload(c echo.Context) error {
+	job, ok := downloadManager.Get(c.Param("id"))
+	if !ok {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "Download not found"})
+	}
+	return c.JSON(http.StatusOK, job)
+}
+
+// handlePauseDownload pauses a running download.
+func handlePauseDownload(c echo.Context) error {
+	if err := downloadManager.Pause(c.Param("id")); err != nil {
+		return c.JSON(http.StatusConflict, map[string]string{"error": err.Error()})
+	}
+	return c.JSON(http.StatusOK, map[string]string{"status": "success"})
+}
+
+// handleResumeDownload resumes a paused or failed download.
+func handleResumeDownload(c echo.Context, config *Config) error {
+	job, err := downloadManager.Resume(config, c.Param("id"))
+	if err != nil {
+		return c.JSON(http.StatusConflict, map[string]string{"error": err.Error()})
+	}
+	return c.JSON(http.StatusOK, job)
+}
+
+// handleCancelDownload cancels a download and removes its partial file.
+func handleCancelDownload(c echo.Context) error {
+	if err := downloadManager.Cancel(c.Param("id")); err != nil {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": err.Error()})
+	}
+	return c.JSON(http.StatusOK, map[string]string{"status": "success"})
+}
+
+// handleDownloadEvents streams download job updates as server-sent events.
+func handleDownloadEvents(c echo.Context) error {
+	updates, unsubscribe := downloadManager.Subscribe()
+	defer unsubscribe()
+
+	w := c.Response()
+	w.Header().Set(echo.HeaderContentType, "text/event-stream")
+	w.Header().Set(echo.HeaderCacheControl, "no-cache")
+	w.Header().Set(echo.HeaderConnection, "keep-alive")
+	w.WriteHeader(http.StatusOK)
+
+	// Send the current state first so clients do not miss jobs started before they connected
+	for _, job := range downloadManager.List() {
+		if err := writeDownloadEvent(w, job); err != nil {
+			return nil
+		}
+	}
+
+	ctx := c.Request().Context()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case job := <-updates:
+			if err := writeDownloadEvent(w, job); err != nil {
+				return nil
+			}
+		}
+	}
+}
+
+// writeDownloadEvent writes a job as a "download" event and flushes it.
+func writeDownloadEvent(w *echo.Response, job DownloadJob) error {
+	data, err := json.Marshal(job)
+	if err != nil {
+		return err
+	}
+	if _, err := fmt.Fprintf(w, "event: download\ndata: %s\n\n", data); err != nil {
+		return err
+	}
+	w.Flush()
+	return nil
+}
@@ -0,0 +1,207 @@
+// downloads_test.go
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// Behaviors of the fake Hub file downloads.
+const (
+	hubServe = iota // serve the file, resuming from ranges
+	hubStall        // send half the file and wait for the client to leave
+	hubAbort        // send half the file and drop the connection
+)
+
+// newFakeHub starts a Hugging Face Hub serving one GGUF file of repo user/tiny and points the
+// downloads at it, returning the file content.
+func newFakeHub(t *testing.T, mode *atomic.Int32) []byte {
+	content := bytes.Repeat([]byte("manifold"), 4096)
+	sum := sha256.Sum256(content)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/api/models/user/tiny/tree/main":
+			json.NewEncoder(w).Encode([]hfTreeEntry{{Type: "file", Path: "tiny-Q4_K_M.gguf", LFS: &struct {
+				OID  string `json:"oid"`
+				Size int64  `json:"size"`
+			}{hex.EncodeToString(sum[:]), int64(len(content))}}})
+		case "/user/tiny/resolve/main/tiny-Q4_K_M.gguf":
+			if mode.Load() == hubServe {
+				http.ServeContent(w, r, "tiny.gguf", time.Time{}, bytes.NewReader(content))
+				return
+			}
+			w.Header().Set("Content-Length", strconv.Itoa(len(content)))
+			w.Write(content[:len(content)/2])
+			w.(http.Flusher).Flush()
+			if mode.Load() == hubAbort {
+				panic(http.ErrAbortHandler)
+			}
+			<-r.Context().Done()
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	t.Cleanup(server.Close)
+
+	saved := huggingFaceURL
+	huggingFaceURL = server.URL
+	t.Cleanup(func() { huggingFaceURL = saved })
+	return content
+}
+
+// newDownloadsConfig returns a config downloading into a temporary directory, registering the
+// models in a test database.
+func newDownloadsConfig(t *testing.T) *Config {
+	testDB, err := NewSQLiteDB(t.TempDir())
+	require.NoError(t, err)
+	require.NoError(t, testDB.AutoMigrate(&LanguageModel{}))
+	saved := db
+	db = testDB
+	t.Cleanup(func() { db = saved })
+	return &Config{DataPath: t.TempDir()}
+}
+
+// jobStatus returns a condition for assert.Eventually reporting whether a job reached status.
+func jobStatus(m *DownloadManager, id, status string) func() bool {
+	return func() bool {
+		job, ok := m.Get(id)
+		return ok && job.Status == status
+	}
+}
+
+func TestDownloadPauseResume(t *testing.T) {
+	var mode atomic.Int32
+	mode.Store(hubStall)
+	content := newFakeHub(t, &mode)
+	config := newDownloadsConfig(t)
+	m := NewDownloadManager()
+
+	job, err := m.Start(context.Background(), config, "user/tiny", "tiny-Q4_K_M.gguf")
+	require.NoError(t, err)
+	assert.Equal(t, DownloadRunning, job.Status)
+	assert.Equal(t, filepath.Join(config.DataPath, "models-gguf", "tiny-Q4_K_M", "tiny-Q4_K_M.gguf"), job.Dest)
+	_, err = m.Start(context.Background(), config, "user/tiny", "tiny-Q4_K_M.gguf")
+	assert.ErrorContains(t, err, "already being downloaded")
+
+	assert.Eventually(t, func() bool {
+		job, _ := m.Get(job.ID)
+		return job.Done == int64(len(content)/2)
+	}, 5*time.Second, 10*time.Millisecond)
+	require.NoError(t, m.Pause(job.ID))
+	assert.Eventually(t, jobStatus(m, job.ID, DownloadPaused), 5*time.Second, 10*time.Millisecond)
+	part, err := os.ReadFile(job.Dest + ".part")
+	require.NoError(t, err)
+	assert.Len(t, part, len(content)/2)
+	assert.Error(t, m.Pause(job.ID), "a paused job cannot be paused again")
+
+	// The rest of the file is requested from where the download stopped
+	mode.Store(hubServe)
+	_, err = m.Resume(config, job.ID)
+	require.NoError(t, err)
+	assert.Eventually(t, jobStatus(m, job.ID, DownloadCompleted), 5*time.Second, 10*time.Millisecond)
+	data, err := os.ReadFile(job.Dest)
+	require.NoError(t, err)
+	assert.Equal(t, content, data)
+	assert.NoFileExists(t, job.Dest+".part")
+
+	// The downloaded model is registered
+	require.Len(t, config.LanguageModels, 1)
+	assert.Equal(t, "tiny-Q4_K_M", config.LanguageModels[0].Name)
+	assert.Equal(t, job.Dest, config.LanguageModels[0].Path)
+	models, err := db.GetModels()
+	require.NoError(t, err)
+	assert.Len(t, models, 1)
+
+	_, err = m.Resume(config, job.ID)
+	assert.ErrorContains(t, err, "is completed")
+}
+
+func TestDownloadFailureResumes(t *testing.T) {
+	var mode atomic.Int32
+	mode.Store(hubAbort)
+	content := newFakeHub(t, &mode)
+	config := newDownloadsConfig(t)
+	m := NewDownloadManager()
+
+	job, err := m.Start(context.Background(), config, "user/tiny", "tiny-Q4_K_M.gguf")
+	require.NoError(t, err)
+	assert.Eventually(t, jobStatus(m, job.ID, DownloadFailed), 5*time.Second, 10*time.Millisecond)
+	failed, _ := m.Get(job.ID)
+	assert.NotEmpty(t, failed.Error)
+	assert.Empty(t, config.LanguageModels)
+
+	// The failed download keeps its partial file and resumes from it
+	part, err := os.ReadFile(job.Dest + ".part")
+	require.NoError(t, err)
+	assert.Len(t, part, len(content)/2)
+
+	mode.Store(hubServe)
+	_, err = m.Resume(config, job.ID)
+	require.NoError(t, err)
+	assert.Eventually(t, jobStatus(m, job.ID, DownloadCompleted), 5*time.Second, 10*time.Millisecond)
+	data, err := os.ReadFile(job.Dest)
+	require.NoError(t, err)
+	assert.Equal(t, content, data)
+}
+
+func TestDownloadCancel(t *testing.T) {
+	var mode atomic.Int32
+	mode.Store(hubStall)
+	newFakeHub(t, &mode)
+	config := newDownloadsConfig(t)
+	m := NewDownloadManager()
+	updates, unsubscribe := m.Subscribe()
+	defer unsubscribe()
+
+	// A running download is stopped and dropped with its partial file
+	job, err := m.Start(context.Background(), config, "user/tiny", "tiny-Q4_K_M.gguf")
+	require.NoError(t, err)
+	assert.Eventually(t, func() bool { return fileExists(job.Dest + ".part") }, 5*time.Second, 10*time.Millisecond)
+	require.NoError(t, m.Cancel(job.ID))
+	assert.Eventually(t, func() bool {
+		_, ok := m.Get(job.ID)
+		return !ok
+	}, 5*time.Second, 10*time.Millisecond)
+	assert.NoFileExists(t, job.Dest+".part")
+	assert.Empty(t, m.List())
+	assert.Eventually(t, func() bool {
+		for {
+			select {
+			case update := <-updates:
+				if update.ID == job.ID && update.Status == DownloadCanceled {
+					return true
+				}
+			default:
+				return false
+			}
+		}
+	}, 5*time.Second, 10*time.Millisecond)
+
+	// So is a failed one
+	mode.Store(hubAbort)
+	job, err = m.Start(context.Background(), config, "user/tiny", "tiny-Q4_K_M.gguf")
+	require.NoError(t, err)
+	assert.Eventually(t, jobStatus(m, job.ID, DownloadFailed), 5*time.Second, 10*time.Millisecond)
+	require.FileExists(t, job.Dest+".part")
+	require.NoError(t, m.Cancel(job.ID))
+	_, ok := m.Get(job.ID)
+	assert.False(t, ok)
+	assert.NoFileExists(t, job.Dest+".part")
+
+	assert.Error(t, m.Cancel(job.ID))
+}
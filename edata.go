@@ -0,0 +1,210 @@
+// manifold/edata.go
+
+package main
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"sync"
+
+	"github.com/labstack/echo/v4"
+	"gorm.io/gorm"
+
+	"manifold/internal/documents"
+	"manifold/internal/edata"
+	"manifold/internal/ingest"
+)
+
+// EDataConfig enables the edata store of chat turns and documents, with their embeddings and a
+// graph of them, in DataPath/edata.db.
+type EDataConfig struct {
+	Enabled  bool           `yaml:"enabled"`
+	GraphRAG GraphRAGConfig `yaml:"graph_rag,omitempty"`
+}
+
+// lastChatDocument is the edata document of the last chat turn saved, which the next one is linked
+// to.
+var lastChatDocument struct {
+	sync.Mutex
+	id uint
+}
+
+// saveChatTurnData stores a chat turn in edata, when enabled, linked from the previous turn.
+// Failures are logged, the turn is saved anyway.
+func saveChatTurnData(ctx context.Context, source, text string, embedding []float64) {
+	if !edata.Enabled() {
+		return
+	}
+	doc, err := edata.SaveSourceDocument(edata.KindChat, source, text, embedding)
+	if err != nil {
+		loggerFromContext(ctx).Error("failed to save chat turn in edata", "error", err)
+		return
+	}
+
+	lastChatDocument.Lock()
+	defer lastChatDocument.Unlock()
+	if lastChatDocument.id != 0 {
+		if err := edata.AddGraphEdge(lastChatDocument.id, doc.ID); err != nil {
+			loggerFromContext(ctx).Error("failed to link chat turn in edata", "error", err)
+		}
+	}
+	lastChatDocument.id = doc.ID
+}
+
+// storeDocuments stores the documents as ingest.StoreDocuments, and those stored in edata too when
+// it is enabled. Their chunks are embedded in vec_chunks when the retrieval backend is sqlitevec.
+func storeDocuments(ctx context.Context, docs []documents.Document) ([]ingest.StoreResult, error) {
+	results, err := ingest.StoreDocuments(ctx, docManager, docs, docChunker)
+	if err == nil && embedIngestedChunks {
+		// Embedding takes a request per chunk, the documents are searchable by text meanwhile
+		go db.embedStoredChunks(context.WithoutCancel(ctx), docManager.IndexManager, results)
+	}
+	if err != nil || !edata.Enabled() {
+		return results, err
+	}
+	var stored []edata.SourceDocument
+	var storedResults []ingest.StoreResult
+	for i, result := range results {
+		if result.Error != "" {
+			continue
+		}
+		text := docs[i].PageContent
+		if docManager.Redact != nil {
+			text = docManager.Redact(text)
+		}
+		stored = append(stored, edata.SourceDocument{Source: result.ID, Text: text})
+		storedResults = append(storedResults, result)
+	}
+	if len(stored) == 0 {
+		return results, nil
+	}
+	saved, err := edata.SaveSourceDocuments(edata.KindDocument, stored)
+	if err != nil {
+		slog.Error("failed to save documents in edata", "documents", len(stored), "error", err)
+		return results, nil
+	}
+	if graphExtractor != nil {
+		// Extraction takes a model call per chunk, the documents are searchable meanwhile
+		go graphExtractor.ExtractDocuments(context.WithoutCancel(ctx), docManager.IndexManager, storedResults, saved)
+	}
+	return results, nil
+}
+
+// EDataEdgeRequest links a document to another in the edata graph.
+type EDataEdgeRequest struct {
+	From uint `json:"from"`
+	To   uint `json:"to"`
+}
+
+// EDataSimilarRequest searches the edata documents with an embedding similar to a vector, or to
+// the embedding of a text.
+type EDataSimilarRequest struct {
+	Text      string    `json:"text,omitempty"`
+	Vector    []float64 `json:"vector,omitempty"`
+	Threshold float64   `json:"threshold"` // minimum cosine similarity, 0.7 when unset
+}
+
+// edataDocumentParam returns the edata document of the id path parameter, or responds with an
+// error.
+func edataDocumentParam(c echo.Context) (*edata.Document, error) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil {
+		return nil, c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid document id"})
+	}
+	doc, err := edata.GetDocument(uint(id))
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, c.JSON(http.StatusNotFound, map[string]string{"error": "Document not found"})
+	}
+	if err != nil {
+		return nil, c.JSON(http.StatusInternalServerError, map[string]string{"error": err.Error()})
+	}
+	return doc, nil
+}
+
+// requireEData responds 404 to the edata routes when it is disabled.
+func requireEData(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		if !edata.Enabled() {
+			return c.JSON(http.StatusNotFound, map[string]string{"error": "edata is not enabled"})
+		}
+		return next(c)
+	}
+}
+
+// handleGetEDataDocument returns an edata document.
+func handleGetEDataDocument(c echo.Context) error {
+	doc, err := edataDocumentParam(c)
+	if doc == nil {
+		return err
+	}
+	return c.JSON(http.StatusOK, doc)
+}
+
+// handleGetConnectedEDataDocuments returns the edata documents reachable from a document through
+// the graph, the document first.
+func handleGetConnectedEDataDocuments(c echo.Context) error {
+	doc, err := edataDocumentParam(c)
+	if doc == nil {
+		return err
+	}
+	docs, err := edata.GetConnectedDocuments(doc.ID)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": err.Error()})
+	}
+	return c.JSON(http.StatusOK, docs)
+}
+
+// handleCreateEDataEdge links a document to another in the edata graph.
+func handleCreateEDataEdge(c echo.Context) error {
+	var req EDataEdgeRequest
+	if err := c.Bind(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid request body"})
+	}
+	for _, id := range []uint{req.From, req.To} {
+		if _, err := edata.GetDocument(id); errors.Is(err, gorm.ErrRecordNotFound) {
+			return c.JSON(http.StatusNotFound, map[string]string{"error": "Document not found"})
+		} else if err != nil {
+			return c.JSON(http.StatusInternalServerError, map[string]string{"error": err.Error()})
+		}
+	}
+	if err := edata.AddGraphEdge(req.From, req.To); err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": err.Error()})
+	}
+	return c.JSON(http.StatusCreated, req)
+}
+
+// handleSearchSimilarEDataDocuments returns the edata documents with an embedding similar to the
+// vector or text of the request.
+func handleSearchSimilarEDataDocuments(c echo.Context) error {
+	var req EDataSimilarRequest
+	if err := c.Bind(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid request body"})
+	}
+	if req.Threshold == 0 {
+		req.Threshold = 0.7
+	}
+	vector := req.Vector
+	if len(vector) == 0 {
+		if req.Text == "" {
+			return c.JSON(http.StatusBadRequest, map[string]string{"error": "Text or vector is required"})
+		}
+		var err error
+		if vector, err = generateEDataEmbedding(c.Request().Context(), req.Text); err != nil {
+			return c.JSON(http.StatusBadGateway, map[string]string{"error": err.Error()})
+		}
+	}
+	docs, err := edata.GetSimilarDocuments(vector, req.Threshold)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": err.Error()})
+	}
+	if docs == nil {
+		docs = []edata.Document{}
+	}
+	return c.JSON(http.StatusOK, docs)
+}
+
+// generateEDataEmbedding embeds the text of similarity searches, stubbed in tests.
+var generateEDataEmbedding = GenerateEmbedding
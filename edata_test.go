@@ -0,0 +1,120 @@
+// edata_test.go
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"manifold/internal/documents"
+	"manifold/internal/edata"
+)
+
+// edataOnce opens the edata database the tests share, as it is opened once per process.
+var edataOnce sync.Once
+
+func initTestEData(t *testing.T) {
+	edataOnce.Do(func() {
+		dir, err := os.MkdirTemp("", "manifold-edata")
+		require.NoError(t, err)
+		require.NoError(t, edata.InitDB(filepath.Join(dir, "edata.db")))
+	})
+	require.True(t, edata.Enabled())
+}
+
+func TestEData(t *testing.T) {
+	initTestEData(t)
+
+	index, err := documents.NewIndexManager(filepath.Join(t.TempDir(), "index"))
+	require.NoError(t, err)
+	defer index.Index.Close()
+	savedManager := docManager
+	docManager = documents.NewDocumentManager(2048, 0, index)
+	defer func() { docManager = savedManager }()
+	docManager.Redact = func(text string) string { return strings.ReplaceAll(text, "secret", "[REDACTED]") }
+
+	ctx := context.Background()
+	saveChatTurnData(ctx, "1-a", "User: hi\nAssistant: hello", []float64{1, 0})
+	saveChatTurnData(ctx, "2-b", "User: how?\nAssistant: like this", []float64{0.9, 0.1})
+	results, err := storeDocuments(ctx, []documents.Document{{PageContent: "the secret plan", Metadata: map[string]string{"source": "notes/plan.md"}}})
+	require.NoError(t, err)
+	require.Len(t, results, 1)
+	assert.Empty(t, results[0].Error)
+
+	e := echo.New()
+	call := func(method, target, body string, handler echo.HandlerFunc, id string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, target, strings.NewReader(body))
+		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		rec := httptest.NewRecorder()
+		c := e.NewContext(req, rec)
+		if id != "" {
+			c.SetParamNames("id")
+			c.SetParamValues(id)
+		}
+		require.NoError(t, requireEData(handler)(c))
+		return rec
+	}
+
+	rec := call(http.MethodPost, "/v1/edata/similar", `{"vector": [1, 0], "threshold": 0.9}`, handleSearchSimilarEDataDocuments, "")
+	require.Equal(t, http.StatusOK, rec.Code)
+	var similar []edata.Document
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &similar))
+	require.Len(t, similar, 2)
+	first, second := similar[0], similar[1]
+	assert.Equal(t, edata.KindChat, first.Kind)
+	assert.Equal(t, "1-a", first.Source)
+
+	// Chat turns are linked to the previous one
+	rec = call(http.MethodGet, "/v1/edata/documents/1/connected", "", handleGetConnectedEDataDocuments, fmt.Sprint(first.ID))
+	require.Equal(t, http.StatusOK, rec.Code)
+	var connected []edata.Document
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &connected))
+	require.Len(t, connected, 2)
+	assert.Equal(t, second.ID, connected[1].ID)
+
+	// Texts are embedded to search similar documents
+	savedEmbed := generateEDataEmbedding
+	generateEDataEmbedding = func(ctx context.Context, text string) ([]float64, error) { return []float64{0, 1}, nil }
+	defer func() { generateEDataEmbedding = savedEmbed }()
+	rec = call(http.MethodPost, "/v1/edata/similar", `{"text": "unrelated"}`, handleSearchSimilarEDataDocuments, "")
+	require.Equal(t, http.StatusOK, rec.Code)
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &similar))
+	assert.Empty(t, similar)
+
+	// Documents stored are redacted, and can be linked
+	doc, err := edata.GetDocument(second.ID + 1)
+	require.NoError(t, err)
+	assert.Equal(t, "notes/plan.md", doc.Source)
+	rec = call(http.MethodGet, "/v1/edata/documents/x", "", handleGetEDataDocument, fmt.Sprint(doc.ID))
+	require.Equal(t, http.StatusOK, rec.Code)
+	var got edata.Document
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &got))
+	assert.Equal(t, "the [REDACTED] plan", got.Text)
+	assert.Equal(t, edata.KindDocument, got.Kind)
+
+	rec = call(http.MethodPost, "/v1/edata/edges", fmt.Sprintf(`{"from": %d, "to": %d}`, second.ID, doc.ID), handleCreateEDataEdge, "")
+	require.Equal(t, http.StatusCreated, rec.Code)
+	rec = call(http.MethodGet, "/v1/edata/documents/1/connected", "", handleGetConnectedEDataDocuments, fmt.Sprint(first.ID))
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &connected))
+	assert.Len(t, connected, 3)
+
+	rec = call(http.MethodPost, "/v1/edata/edges", `{"from": 1, "to": 999}`, handleCreateEDataEdge, "")
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+	rec = call(http.MethodGet, "/v1/edata/documents/999", "", handleGetEDataDocument, "999")
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+	rec = call(http.MethodGet, "/v1/edata/documents/x", "", handleGetEDataDocument, "x")
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+	rec = call(http.MethodPost, "/v1/edata/similar", `{}`, handleSearchSimilarEDataDocuments, "")
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+}
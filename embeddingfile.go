@@ -0,0 +1,355 @@
+// manifold/embeddingfile.go
+
+package main
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+// Embeddings are persisted to an append-only log of records, each setting or removing the embedding
+// of a word, so that a save writes only the embeddings changed since the last one. The log is
+// rewritten with its live embeddings once most of its records are stale.
+//
+// The log starts with embeddingFileMagic. A record is an op byte, the word length as a uvarint and
+// the word, for puts the vector length as a uvarint and the vector as little-endian float64s then
+// the number of metadata as a uvarint and each key and value as length-prefixed strings, then the
+// CRC-32 of all of it. Logs of version 1 have no metadata, and are converted on save. A torn record
+// at the end of the log, left by an interrupted save, is discarded.
+
+const (
+	embeddingFileMagic   = "MFEMB\x02"
+	embeddingFileVersion = 2
+)
+
+// embeddingFileMagicPrefix starts logs of all versions, followed by a version byte.
+const embeddingFileMagicPrefix = "MFEMB"
+
+const (
+	recordPut    byte = 1
+	recordRemove byte = 2
+)
+
+// compactMinRecords is the fewest records a log holds before it is compacted.
+const compactMinRecords = 1024
+
+// embeddingFile is what a DB knows of the file it last loaded or saved.
+type embeddingFile struct {
+	path    string
+	size    int64 // length of the valid records
+	records int
+	offsets map[string]int64 // offsets of the records of the embeddings in the file
+	version int
+	legacy  bool // the file is a JSON map of the embeddings or a log of an earlier version
+}
+
+// stale reports whether most records of the file are stale.
+func (f *embeddingFile) stale() bool {
+	return f.records >= compactMinRecords && f.records > 2*len(f.offsets)
+}
+
+// readEmbeddingFile reads the embeddings of a log or of a legacy JSON file.
+func readEmbeddingFile(path string) (map[string]Embeddings, *embeddingFile, error) {
+	file := &embeddingFile{path: path, offsets: make(map[string]int64), version: embeddingFileVersion}
+	embeddings := make(map[string]Embeddings)
+	content, err := os.Open(path)
+	if err != nil {
+		return nil, file, err
+	}
+	defer content.Close()
+
+	r := bufio.NewReader(content)
+	magic, _ := r.Peek(len(embeddingFileMagic))
+	if len(magic) == 0 {
+		return embeddings, file, nil
+	}
+	if len(magic) < len(embeddingFileMagic) || string(magic[:len(embeddingFileMagicPrefix)]) != embeddingFileMagicPrefix {
+		// Files saved before the log were a JSON map
+		if err := json.NewDecoder(r).Decode(&embeddings); err != nil {
+			return nil, file, fmt.Errorf("error reading embeddings: %v", err)
+		}
+		file.legacy = true
+		return embeddings, file, nil
+	}
+	version := int(magic[len(embeddingFileMagicPrefix)])
+	if version > embeddingFileVersion {
+		return nil, file, fmt.Errorf("embeddings file version %d is newer than supported", version)
+	}
+	file.version = version
+	file.legacy = version < embeddingFileVersion
+
+	r.Discard(len(embeddingFileMagic))
+	file.size = int64(len(embeddingFileMagic))
+	for {
+		op, embedding, n, err := readEmbeddingRecord(r, version)
+		if err == io.EOF || errors.Is(err, errTornRecord) {
+			return embeddings, file, nil
+		}
+		if err != nil {
+			return nil, file, fmt.Errorf("error reading embeddings: %v", err)
+		}
+		if op == recordPut {
+			embeddings[embedding.Word] = embedding
+			file.offsets[embedding.Word] = file.size
+		} else {
+			delete(embeddings, embedding.Word)
+			delete(file.offsets, embedding.Word)
+		}
+		file.size += n
+		file.records++
+	}
+}
+
+var errTornRecord = errors.New("torn record")
+
+// readEmbeddingRecord reads a record of a log of the version and returns its op, embedding and
+// length.
+func readEmbeddingRecord(r *bufio.Reader, version int) (byte, Embeddings, int64, error) {
+	op, err := r.ReadByte()
+	if err != nil {
+		return 0, Embeddings{}, 0, err
+	}
+	record := []byte{op}
+	readUvarint := func() (uint64, error) {
+		value, err := binary.ReadUvarint(r)
+		record = binary.AppendUvarint(record, value)
+		return value, err
+	}
+	readBytes := func(n uint64) ([]byte, error) {
+		if n > math.MaxInt32 {
+			return nil, errTornRecord
+		}
+		start := len(record)
+		record = append(record, make([]byte, n)...)
+		_, err := io.ReadFull(r, record[start:])
+		return record[start:], err
+	}
+
+	if op != recordPut && op != recordRemove {
+		return 0, Embeddings{}, 0, errTornRecord
+	}
+	wordLen, err := readUvarint()
+	if err != nil {
+		return 0, Embeddings{}, 0, errTornRecord
+	}
+	word, err := readBytes(wordLen)
+	if err != nil {
+		return 0, Embeddings{}, 0, errTornRecord
+	}
+	embedding := Embeddings{Word: string(word)}
+	if op == recordPut {
+		dim, err := readUvarint()
+		if err != nil {
+			return 0, Embeddings{}, 0, errTornRecord
+		}
+		values, err := readBytes(8 * dim)
+		if err != nil {
+			return 0, Embeddings{}, 0, errTornRecord
+		}
+		embedding.Vector = make([]float64, dim)
+		for i := range embedding.Vector {
+			embedding.Vector[i] = math.Float64frombits(binary.LittleEndian.Uint64(values[8*i:]))
+		}
+		if version >= 2 {
+			count, err := readUvarint()
+			if err != nil || count > math.MaxInt32 {
+				return 0, Embeddings{}, 0, errTornRecord
+			}
+			readString := func() (string, error) {
+				n, err := readUvarint()
+				if err != nil {
+					return "", err
+				}
+				value, err := readBytes(n)
+				return string(value), err
+			}
+			for i := uint64(0); i < count; i++ {
+				key, err := readString()
+				if err != nil {
+					return 0, Embeddings{}, 0, errTornRecord
+				}
+				value, err := readString()
+				if err != nil {
+					return 0, Embeddings{}, 0, errTornRecord
+				}
+				if embedding.Metadata == nil {
+					embedding.Metadata = make(map[string]string, count)
+				}
+				embedding.Metadata[key] = value
+			}
+		}
+	}
+	var checksum [4]byte
+	if _, err := io.ReadFull(r, checksum[:]); err != nil || binary.LittleEndian.Uint32(checksum[:]) != crc32.ChecksumIEEE(record) {
+		return 0, Embeddings{}, 0, errTornRecord
+	}
+	return op, embedding, int64(len(record) + len(checksum)), nil
+}
+
+// appendEmbeddingRecord encodes a record setting the embedding of a word, or removing it.
+func appendEmbeddingRecord(buf []byte, embedding Embeddings, remove bool) []byte {
+	start := len(buf)
+	if remove {
+		buf = append(buf, recordRemove)
+	} else {
+		buf = append(buf, recordPut)
+	}
+	buf = binary.AppendUvarint(buf, uint64(len(embedding.Word)))
+	buf = append(buf, embedding.Word...)
+	if !remove {
+		buf = binary.AppendUvarint(buf, uint64(len(embedding.Vector)))
+		for _, value := range embedding.Vector {
+			buf = binary.LittleEndian.AppendUint64(buf, math.Float64bits(value))
+		}
+		keys := make([]string, 0, len(embedding.Metadata))
+		for key := range embedding.Metadata {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		buf = binary.AppendUvarint(buf, uint64(len(keys)))
+		for _, key := range keys {
+			buf = binary.AppendUvarint(buf, uint64(len(key)))
+			buf = append(buf, key...)
+			buf = binary.AppendUvarint(buf, uint64(len(embedding.Metadata[key])))
+			buf = append(buf, embedding.Metadata[key]...)
+		}
+	}
+	return binary.LittleEndian.AppendUint32(buf, crc32.ChecksumIEEE(buf[start:]))
+}
+
+// appendEmbeddings appends the records of the embeddings changed since the last save to the file,
+// over any torn record.
+func (db *EmbeddingDB) appendEmbeddings() error {
+	if len(db.dirty) == 0 {
+		return nil
+	}
+	f, err := os.OpenFile(db.file.path, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return fmt.Errorf("error opening file: %v", err)
+	}
+	defer f.Close()
+
+	var buf []byte
+	if db.file.size == 0 {
+		buf = append(buf, embeddingFileMagic...)
+	}
+	offsets := make(map[string]int64, len(db.dirty))
+	for _, word := range sortedWords(db.dirty) {
+		embedding, ok := db.Embeddings[word]
+		if !ok {
+			embedding = Embeddings{Word: word}
+		} else {
+			offsets[word] = db.file.size + int64(len(buf))
+		}
+		buf = appendEmbeddingRecord(buf, embedding, !ok)
+	}
+	if err := f.Truncate(db.file.size); err != nil {
+		return fmt.Errorf("error truncating file: %v", err)
+	}
+	if _, err := f.WriteAt(buf, db.file.size); err != nil {
+		return fmt.Errorf("error writing to file: %v", err)
+	}
+	if err := f.Sync(); err != nil {
+		return fmt.Errorf("error syncing file: %v", err)
+	}
+
+	db.file.size += int64(len(buf))
+	db.file.records += len(db.dirty)
+	for word := range db.dirty {
+		if offset, ok := offsets[word]; ok {
+			db.file.offsets[word] = offset
+		} else {
+			delete(db.file.offsets, word)
+		}
+	}
+	db.dirty = make(map[string]struct{})
+	return f.Close()
+}
+
+// CompactEmbeddings rewrites the file with its live embeddings only, and those changed since the
+// last save.
+func (db *EmbeddingDB) CompactEmbeddings(path string) error {
+	embeddings, _, err := readEmbeddingFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if embeddings == nil {
+		embeddings = make(map[string]Embeddings)
+	}
+	if db.file != nil && db.file.path == path {
+		for word := range db.dirty {
+			if embedding, ok := db.Embeddings[word]; ok {
+				embeddings[word] = embedding
+			} else {
+				delete(embeddings, word)
+			}
+		}
+	} else {
+		db.restoreVectors()
+		for word, embedding := range db.Embeddings {
+			embeddings[word] = embedding
+		}
+	}
+
+	file := &embeddingFile{path: path, offsets: make(map[string]int64, len(embeddings)), version: embeddingFileVersion}
+	buf := []byte(embeddingFileMagic)
+	words := make([]string, 0, len(embeddings))
+	for word := range embeddings {
+		words = append(words, word)
+	}
+	sort.Strings(words)
+	for _, word := range words {
+		embedding := embeddings[word]
+		embedding.Word = word
+		file.offsets[word] = int64(len(buf))
+		buf = appendEmbeddingRecord(buf, embedding, false)
+	}
+	file.size, file.records = int64(len(buf)), len(words)
+
+	// Write aside and rename so that the file is never half-written
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return fmt.Errorf("error creating file: %v", err)
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(buf); err != nil {
+		tmp.Close()
+		return fmt.Errorf("error writing to file: %v", err)
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return fmt.Errorf("error syncing file: %v", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("error closing file: %v", err)
+	}
+	if err := os.Chmod(tmp.Name(), 0644); err != nil {
+		return fmt.Errorf("error setting file mode: %v", err)
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return fmt.Errorf("error replacing file: %v", err)
+	}
+
+	db.file = file
+	db.dirty = make(map[string]struct{})
+	return nil
+}
+
+// sortedWords returns the words of a set in order.
+func sortedWords(set map[string]struct{}) []string {
+	words := make([]string, 0, len(set))
+	for word := range set {
+		words = append(words, word)
+	}
+	sort.Strings(words)
+	return words
+}
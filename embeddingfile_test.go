@@ -0,0 +1,127 @@
+// embeddingfile_test.go
+package main
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"hash/crc32"
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEmbeddingFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "embeddings.db")
+	size := func() int64 {
+		info, err := os.Stat(path)
+		require.NoError(t, err)
+		return info.Size()
+	}
+	load := func() map[string]Embeddings {
+		embeddings, err := NewEmbeddingDB().LoadEmbeddings(path)
+		require.NoError(t, err)
+		return embeddings
+	}
+
+	edb := NewEmbeddingDB()
+	edb.AddEmbeddings([]Embeddings{{Word: "a", Vector: []float64{1, 2}}, {Word: "b", Vector: []float64{3, 4}}})
+	require.NoError(t, edb.SaveEmbeddings(path))
+	assert.Equal(t, map[string]Embeddings{"a": {Word: "a", Vector: []float64{1, 2}}, "b": {Word: "b", Vector: []float64{3, 4}}}, load())
+
+	// Saves append the changes only
+	before := size()
+	require.NoError(t, edb.SaveEmbeddings(path))
+	assert.Equal(t, before, size())
+	edb.AddEmbedding(Embeddings{Word: "c", Vector: []float64{5, 6}})
+	edb.RemoveEmbedding("a")
+	require.NoError(t, edb.SaveEmbeddings(path))
+	record := int64(len(appendEmbeddingRecord(nil, Embeddings{Word: "c", Vector: []float64{5, 6}}, false)) + len(appendEmbeddingRecord(nil, Embeddings{Word: "a"}, true)))
+	assert.Equal(t, before+record, size())
+	assert.Equal(t, []string{"b", "c"}, sortedWords(wordSet(load())))
+
+	// A torn record is discarded, and written over by the next save
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0644)
+	require.NoError(t, err)
+	_, err = f.Write(appendEmbeddingRecord(nil, Embeddings{Word: "torn", Vector: []float64{7, 8}}, false)[:10])
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+	reloaded := NewEmbeddingDB()
+	reloaded.Embeddings, err = reloaded.LoadEmbeddings(path)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"b", "c"}, sortedWords(wordSet(reloaded.Embeddings)))
+	reloaded.AddEmbedding(Embeddings{Word: "d", Vector: []float64{9, 10}})
+	require.NoError(t, reloaded.SaveEmbeddings(path))
+	assert.Equal(t, []string{"b", "c", "d"}, sortedWords(wordSet(load())))
+
+	// Saving to another file merges all the embeddings into it
+	other := filepath.Join(t.TempDir(), "other.db")
+	require.NoError(t, NewEmbeddingDB().SaveEmbeddings(other))
+	second := NewEmbeddingDB()
+	second.AddEmbedding(Embeddings{Word: "e", Vector: []float64{1}})
+	require.NoError(t, second.SaveEmbeddings(other))
+	reloaded.file = nil
+	require.NoError(t, reloaded.SaveEmbeddings(other))
+	merged, err := NewEmbeddingDB().LoadEmbeddings(other)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"b", "c", "d", "e"}, sortedWords(wordSet(merged)))
+
+	// Overwriting the same embeddings compacts the file
+	for i := 0; i < 600; i++ {
+		reloaded.AddEmbedding(Embeddings{Word: "b", Vector: []float64{float64(i)}})
+		reloaded.AddEmbedding(Embeddings{Word: "c", Vector: []float64{float64(i)}})
+		require.NoError(t, reloaded.SaveEmbeddings(path))
+	}
+	assert.Less(t, reloaded.file.records, compactMinRecords)
+	embeddings := load()
+	assert.Equal(t, []float64{599}, embeddings["b"].Vector)
+	assert.Len(t, embeddings, 3)
+
+	// JSON files of earlier versions are read and converted on save
+	legacy := filepath.Join(t.TempDir(), "embeddings.json")
+	content, err := json.Marshal(map[string]Embeddings{"x": {Word: "x", Vector: []float64{1, 1}}})
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(legacy, content, 0644))
+	converted := NewEmbeddingDB()
+	converted.Embeddings, err = converted.LoadEmbeddings(legacy)
+	require.NoError(t, err)
+	converted.AddEmbedding(Embeddings{Word: "y", Vector: []float64{2, 2}})
+	require.NoError(t, converted.SaveEmbeddings(legacy))
+	content, err = os.ReadFile(legacy)
+	require.NoError(t, err)
+	assert.Equal(t, embeddingFileMagic, string(content[:len(embeddingFileMagic)]))
+	embeddings, err = NewEmbeddingDB().LoadEmbeddings(legacy)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"x", "y"}, sortedWords(wordSet(embeddings)))
+
+	// So are logs of version 1, without metadata
+	v1 := []byte("MFEMB\x01")
+	start := len(v1)
+	v1 = append(v1, recordPut, 1, 'v', 1)
+	v1 = binary.LittleEndian.AppendUint64(v1, math.Float64bits(3))
+	v1 = binary.LittleEndian.AppendUint32(v1, crc32.ChecksumIEEE(v1[start:]))
+	old := filepath.Join(t.TempDir(), "v1.db")
+	require.NoError(t, os.WriteFile(old, v1, 0644))
+	upgraded := NewEmbeddingDB()
+	upgraded.Embeddings, err = upgraded.LoadEmbeddings(old)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]Embeddings{"v": {Word: "v", Vector: []float64{3}}}, upgraded.Embeddings)
+	tagged := Embeddings{Word: "w", Vector: []float64{4}, Metadata: map[string]string{"source": "docs/a.md", "tags": "go,rag"}}
+	upgraded.AddEmbedding(tagged)
+	require.NoError(t, upgraded.SaveEmbeddings(old))
+	embeddings, err = NewEmbeddingDB().LoadEmbeddings(old)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]Embeddings{"v": {Word: "v", Vector: []float64{3}}, "w": tagged}, embeddings)
+}
+
+// wordSet returns the words of the embeddings.
+func wordSet(embeddings map[string]Embeddings) map[string]struct{} {
+	words := make(map[string]struct{}, len(embeddings))
+	for word := range embeddings {
+		words[word] = struct{}{}
+	}
+	return words
+}
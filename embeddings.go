@@ -2,7 +2,7 @@ package main
 
 import (
 	"encoding/json"
-	"fmt"
+	"log/slog"
 	"net/http"
 
 	"github.com/labstack/echo/v4"
@@ -40,13 +40,13 @@ func handleEmbeddingRequest(c echo.Context) error {
 	}
 
 	// Send the request to the LLM
-	resp, err := llmClient.SendEmbeddingRequest(&embeddingRequest)
+	resp, err := embeddingClient().SendEmbeddingRequest(c.Request().Context(), &embeddingRequest)
 	if err != nil {
 		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to get embeddings"})
 	}
 	defer resp.Body.Close()
 
-	fmt.Printf("Response: %v\n", resp)
+	slog.Debug("embeddings response received", "status", resp.StatusCode)
 
 	var embeddingResponse EmbeddingResponse
 	if err := json.NewDecoder(resp.Body).Decode(&embeddingResponse); err != nil {
@@ -0,0 +1,534 @@
+// manifold/eval.go
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"gopkg.in/yaml.v2"
+	"gorm.io/gorm"
+)
+
+const (
+	EvalRegex      = "regex"
+	EvalSimilarity = "similarity"
+	EvalJudge      = "judge"
+
+	defaultEvalSimilarity = 0.8
+	defaultEvalMaxTokens  = 1024
+	defaultEvalTimeout    = 120 * time.Second
+	maxEvalRuns           = 100
+)
+
+var evalSuiteName = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+
+// EvalJudgeConfig is the OpenAI compatible model grading answers against a rubric.
+type EvalJudgeConfig struct {
+	Endpoint string `yaml:"endpoint,omitempty"` // the chat backend when empty
+	Model    string `yaml:"model,omitempty"`
+	APIKey   string `yaml:"api_key,omitempty" json:"-"`
+}
+
+// EvalConfig configures the evaluation suites run with /v1/evals/runs.
+type EvalConfig struct {
+	SuitesPath     string          `yaml:"suites_path,omitempty"`     // data_path/evals when empty
+	TimeoutSeconds int             `yaml:"timeout_seconds,omitempty"` // per case, 120 when unset
+	Judge          EvalJudgeConfig `yaml:"judge,omitempty"`
+	RAG            RAGEvalConfig   `yaml:"rag,omitempty"` // retrieval evaluation
+}
+
+// EvalCriterion is a check of a response.
+type EvalCriterion struct {
+	Type      string  `yaml:"type" json:"type"`                               // regex, similarity or judge
+	Pattern   string  `yaml:"pattern,omitempty" json:"pattern,omitempty"`     // regex: the response must match
+	Negate    bool    `yaml:"negate,omitempty" json:"negate,omitempty"`       // regex: the response must not match
+	Reference string  `yaml:"reference,omitempty" json:"reference,omitempty"` // similarity: the expected answer
+	Threshold float64 `yaml:"threshold,omitempty" json:"threshold,omitempty"` // similarity: minimum cosine similarity, 0.8 when unset
+	Rubric    string  `yaml:"rubric,omitempty" json:"rubric,omitempty"`       // judge: what a passing answer does
+
+	re *regexp.Regexp
+}
+
+// EvalCase is a prompt of a suite and the criteria its response must meet.
+type EvalCase struct {
+	Name     string          `yaml:"name" json:"name"`
+	System   string          `yaml:"system,omitempty" json:"system,omitempty"` // the suite's system prompt when empty
+	Prompt   string          `yaml:"prompt" json:"prompt"`
+	Criteria []EvalCriterion `yaml:"criteria" json:"criteria"`
+}
+
+// EvalSuite is a YAML file of cases in the suites directory, named after the file.
+type EvalSuite struct {
+	Name        string     `yaml:"-" json:"name"`
+	Description string     `yaml:"description,omitempty" json:"description,omitempty"`
+	System      string     `yaml:"system,omitempty" json:"system,omitempty"`
+	Temperature float64    `yaml:"temperature,omitempty" json:"temperature,omitempty"`
+	MaxTokens   int        `yaml:"max_tokens,omitempty" json:"max_tokens,omitempty"` // 1024 when unset
+	Cases       []EvalCase `yaml:"cases" json:"cases"`
+}
+
+// EvalCriterionResult is the outcome of a criterion.
+type EvalCriterionResult struct {
+	Type   string  `json:"type"`
+	Passed bool    `json:"passed"`
+	Score  float64 `json:"score,omitempty"` // the similarity
+	Detail string  `json:"detail,omitempty"`
+}
+
+// EvalCaseResult is the outcome of a case.
+type EvalCaseResult struct {
+	Name       string                `json:"name"`
+	Response   string                `json:"response"`
+	Passed     bool                  `json:"passed"`
+	Criteria   []EvalCriterionResult `json:"criteria"`
+	Error      string                `json:"error,omitempty"`
+	DurationMs int64                 `json:"duration_ms"`
+}
+
+// EvalReport is the outcome of a suite run.
+type EvalReport struct {
+	Passed int              `json:"passed"`
+	Failed int              `json:"failed"`
+	Cases  []EvalCaseResult `json:"cases"`
+}
+
+// EvalRun is a run of a suite against a backend.
+type EvalRun struct {
+	ID         uint       `gorm:"primaryKey" json:"id"`
+	Suite      string     `gorm:"index" json:"suite"`
+	Model      string     `json:"model,omitempty"`
+	Endpoint   string     `json:"endpoint,omitempty"` // the chat backend or a pool model when empty
+	Status     string     `json:"status"`             // running, done or failed
+	Error      string     `json:"error,omitempty"`
+	Report     EvalReport `gorm:"serializer:json" json:"report"`
+	CreatedAt  time.Time  `json:"created_at"`
+	FinishedAt *time.Time `json:"finished_at,omitempty"`
+}
+
+// LoadEvalSuite reads and validates a suite file.
+func LoadEvalSuite(path string) (*EvalSuite, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var suite EvalSuite
+	if err := yaml.Unmarshal(data, &suite); err != nil {
+		return nil, fmt.Errorf("invalid eval suite %s: %w", path, err)
+	}
+	suite.Name = strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
+
+	if len(suite.Cases) == 0 {
+		return nil, fmt.Errorf("eval suite %s has no cases", suite.Name)
+	}
+	seen := make(map[string]bool)
+	for i := range suite.Cases {
+		c := &suite.Cases[i]
+		if c.Name == "" || seen[c.Name] {
+			return nil, fmt.Errorf("eval suite %s: case %d needs a unique name", suite.Name, i+1)
+		}
+		seen[c.Name] = true
+		if c.Prompt == "" || len(c.Criteria) == 0 {
+			return nil, fmt.Errorf("eval case %q needs a prompt and criteria", c.Name)
+		}
+		for j := range c.Criteria {
+			if err := c.Criteria[j].compile(); err != nil {
+				return nil, fmt.Errorf("eval case %q: %w", c.Name, err)
+			}
+		}
+	}
+	return &suite, nil
+}
+
+// compile validates the criterion and compiles its pattern.
+func (cr *EvalCriterion) compile() error {
+	switch cr.Type {
+	case EvalRegex:
+		re, err := regexp.Compile(cr.Pattern)
+		if err != nil || cr.Pattern == "" {
+			return fmt.Errorf("invalid regex criterion %q", cr.Pattern)
+		}
+		cr.re = re
+	case EvalSimilarity:
+		if cr.Reference == "" {
+			return errors.New("similarity criterion needs a reference")
+		}
+	case EvalJudge:
+		if cr.Rubric == "" {
+			return errors.New("judge criterion needs a rubric")
+		}
+	default:
+		return fmt.Errorf("unknown criterion type %q", cr.Type)
+	}
+	return nil
+}
+
+// evalSuitesPath returns the directory of the suite files.
+func evalSuitesPath(config *Config) string {
+	if config.Evals.SuitesPath != "" {
+		return config.Evals.SuitesPath
+	}
+	return filepath.Join(config.DataPath, "evals")
+}
+
+// findEvalSuite loads a suite of the suites directory by name.
+func findEvalSuite(config *Config, name string) (*EvalSuite, error) {
+	if !evalSuiteName.MatchString(name) {
+		return nil, fmt.Errorf("invalid suite name %q", name)
+	}
+	for _, ext := range []string{".yml", ".yaml"} {
+		path := filepath.Join(evalSuitesPath(config), name+ext)
+		if _, err := os.Stat(path); err == nil {
+			return LoadEvalSuite(path)
+		}
+	}
+	return nil, os.ErrNotExist
+}
+
+// EvalRunner runs suites against a backend.
+type EvalRunner struct {
+	client     LLMClient // the backend under test, nil uses the pool model or the chat backend
+	model      string
+	judge      LLMClient // nil uses the chat backend
+	judgeModel string
+	timeout    time.Duration
+	embed      func(ctx context.Context, texts []string) ([][]float64, error)
+}
+
+// Run runs every case of the suite in order.
+func (r *EvalRunner) Run(ctx context.Context, suite *EvalSuite) EvalReport {
+	span, ctx := startSpan(ctx, "eval.run")
+	span.SetTag("suite", suite.Name)
+	defer span.Finish()
+
+	var report EvalReport
+	for _, c := range suite.Cases {
+		result := r.runCase(ctx, suite, c)
+		if result.Passed {
+			report.Passed++
+		} else {
+			report.Failed++
+		}
+		report.Cases = append(report.Cases, result)
+	}
+	span.SetTag("failed", report.Failed)
+	return report
+}
+
+// runCase sends the case's prompt to the backend and checks the response against every criterion.
+func (r *EvalRunner) runCase(ctx context.Context, suite *EvalSuite, c EvalCase) EvalCaseResult {
+	start := time.Now()
+	result := EvalCaseResult{Name: c.Name}
+	defer func() { result.DurationMs = time.Since(start).Milliseconds() }()
+
+	system := c.System
+	if system == "" {
+		system = suite.System
+	}
+	var messages []Message
+	if system != "" {
+		messages = append(messages, Message{Role: "system", Content: system})
+	}
+	messages = append(messages, Message{Role: "user", Content: c.Prompt})
+	maxTokens := suite.MaxTokens
+	if maxTokens <= 0 {
+		maxTokens = defaultEvalMaxTokens
+	}
+
+	client, release := r.backend()
+	response, err := r.complete(ctx, client, &CompletionRequest{Model: r.model, Messages: messages, Temperature: suite.Temperature, MaxTokens: maxTokens})
+	release()
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+	result.Response = response
+
+	result.Passed = true
+	for _, criterion := range c.Criteria {
+		outcome := r.check(ctx, c, criterion, response)
+		result.Passed = result.Passed && outcome.Passed
+		result.Criteria = append(result.Criteria, outcome)
+	}
+	return result
+}
+
+// check evaluates one criterion. A criterion that cannot be evaluated fails.
+func (r *EvalRunner) check(ctx context.Context, c EvalCase, criterion EvalCriterion, response string) EvalCriterionResult {
+	outcome := EvalCriterionResult{Type: criterion.Type}
+	switch criterion.Type {
+	case EvalRegex:
+		outcome.Passed = criterion.re.MatchString(response) != criterion.Negate
+
+	case EvalSimilarity:
+		if r.embed == nil {
+			outcome.Detail = "no embeddings backend"
+			break
+		}
+		vectors, err := r.embed(ctx, []string{response, criterion.Reference})
+		if err != nil || len(vectors) != 2 || len(vectors[0]) == 0 || len(vectors[0]) != len(vectors[1]) {
+			outcome.Detail = fmt.Sprintf("embedding failed: %v", err)
+			break
+		}
+		threshold := criterion.Threshold
+		if threshold <= 0 {
+			threshold = defaultEvalSimilarity
+		}
+		outcome.Score = CosineSimilarity(vectors[0], vectors[1])
+		outcome.Passed = outcome.Score >= threshold
+
+	case EvalJudge:
+		passed, reason, err := r.grade(ctx, c.Prompt, response, criterion.Rubric)
+		if err != nil {
+			outcome.Detail = "judge failed: " + err.Error()
+			break
+		}
+		outcome.Passed, outcome.Detail = passed, reason
+	}
+	return outcome
+}
+
+// grade asks the judge whether the response meets the rubric.
+func (r *EvalRunner) grade(ctx context.Context, prompt, response, rubric string) (bool, string, error) {
+	payload := &CompletionRequest{
+		Model: r.judgeModel,
+		Messages: []Message{
+			{Role: "system", Content: "You grade answers to a question against a rubric. Reply with a JSON object only, " +
+				`{"pass": true or false, "reason": "one sentence"}.`},
+			{Role: "user", Content: "Question:\n" + prompt + "\n\nAnswer:\n" + response + "\n\nRubric:\n" + rubric},
+		},
+		Temperature: 0,
+		MaxTokens:   256,
+	}
+	judge, release := r.judgeBackend()
+	reply, err := r.complete(ctx, judge, payload)
+	release()
+	if err != nil {
+		return false, "", err
+	}
+
+	object, ok := extractJSON(reply, false)
+	if !ok {
+		return false, "", fmt.Errorf("judge reply has no verdict: %s", truncateForLog(reply, 100))
+	}
+	var verdict struct {
+		Pass   bool   `json:"pass"`
+		Reason string `json:"reason"`
+	}
+	if err := json.Unmarshal([]byte(object), &verdict); err != nil {
+		return false, "", fmt.Errorf("judge reply has an invalid verdict: %w", err)
+	}
+	return verdict.Pass, verdict.Reason, nil
+}
+
+// complete sends a completion request and returns the reply.
+func (r *EvalRunner) complete(ctx context.Context, client LLMClient, payload *CompletionRequest) (string, error) {
+	if client == nil {
+		return "", errors.New("no completions backend")
+	}
+	timeout := r.timeout
+	if timeout <= 0 {
+		timeout = defaultEvalTimeout
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	resp, err := client.SendCompletionRequest(ctx, payload)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("completion request failed with status %d", resp.StatusCode)
+	}
+
+	var completion CompletionResponse
+	if err := json.NewDecoder(resp.Body).Decode(&completion); err != nil {
+		return "", fmt.Errorf("invalid completion response: %w", err)
+	}
+	if len(completion.Choices) == 0 {
+		return "", errors.New("completion returned no choices")
+	}
+	return completion.Choices[0].Message.Content, nil
+}
+
+// backend returns the backend under test. Without a client of its own the runner holds the pool
+// model or the chat backend for one call at a time, so a model swap is not held up by a suite.
+func (r *EvalRunner) backend() (LLMClient, func()) {
+	if r.client != nil {
+		return r.client, func() {}
+	}
+	return backendFor(r.model)
+}
+
+// judgeBackend returns the judge, holding the chat backend for one call when there is no judge
+// endpoint.
+func (r *EvalRunner) judgeBackend() (LLMClient, func()) {
+	if r.judge != nil {
+		return r.judge, func() {}
+	}
+	return currentBackend()
+}
+
+// embedWith returns an embedding func that uses the client's embeddings endpoint.
+func embedWith(client LLMClient) func(ctx context.Context, texts []string) ([][]float64, error) {
+	return func(ctx context.Context, texts []string) ([][]float64, error) {
+		resp, err := client.SendEmbeddingRequest(ctx, &EmbeddingRequest{Input: texts, EncodingFormat: "float"})
+		if err != nil {
+			return nil, err
+		}
+		defer resp.Body.Close()
+
+		var embeddings EmbeddingResponse
+		if err := json.NewDecoder(resp.Body).Decode(&embeddings); err != nil {
+			return nil, err
+		}
+		vectors := make([][]float64, len(texts))
+		for _, e := range embeddings.Data {
+			if e.Index >= 0 && e.Index < len(vectors) {
+				vectors[e.Index] = e.Embedding
+			}
+		}
+		return vectors, nil
+	}
+}
+
+// EvalRunRequest selects the suite and the backend to run it against: an OpenAI compatible
+// endpoint, otherwise the pool model named by model or the chat backend.
+type EvalRunRequest struct {
+	Suite    string `json:"suite"`
+	Model    string `json:"model"`
+	Endpoint string `json:"endpoint"`
+	APIKey   string `json:"api_key"`
+}
+
+// startEvalRun records a run and runs the suite in the background.
+func startEvalRun(config *Config, suite *EvalSuite, req EvalRunRequest) (*EvalRun, error) {
+	run := &EvalRun{Suite: suite.Name, Model: req.Model, Endpoint: req.Endpoint, Status: "running"}
+	if err := db.Create(run); err != nil {
+		return nil, err
+	}
+	started := *run
+
+	go func() {
+		runner := &EvalRunner{
+			model:      req.Model,
+			judgeModel: config.Evals.Judge.Model,
+			timeout:    time.Duration(config.Evals.TimeoutSeconds) * time.Second,
+			embed: func(ctx context.Context, texts []string) ([][]float64, error) {
+				return embedWith(embeddingClient())(ctx, texts)
+			},
+		}
+		if req.Endpoint != "" {
+			runner.client = NewLocalLLMClient(req.Endpoint, req.Model, req.APIKey)
+		}
+		if config.Evals.Judge.Endpoint != "" {
+			runner.judge = NewLocalLLMClient(config.Evals.Judge.Endpoint, config.Evals.Judge.Model, config.Evals.Judge.APIKey)
+		}
+
+		// The backends are held for each call rather than for the whole suite
+		client, release := runner.backend()
+		release()
+
+		run.Status = "done"
+		if client == nil {
+			run.Status, run.Error = "failed", "no completions backend"
+		} else {
+			run.Report = runner.Run(context.Background(), suite)
+		}
+		finished := time.Now()
+		run.FinishedAt = &finished
+		if err := db.db.Save(run).Error; err != nil {
+			loggerFromContext(context.Background()).Error("failed to save eval run", "run", run.ID, "error", err)
+		}
+	}()
+	return &started, nil
+}
+
+// handleGetEvalSuites lists the suites of the suites directory.
+func handleGetEvalSuites(c echo.Context, config *Config) error {
+	entries, err := os.ReadDir(evalSuitesPath(config))
+	if err != nil && !os.IsNotExist(err) {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to list eval suites"})
+	}
+
+	suites := []EvalSuite{}
+	for _, entry := range entries {
+		ext := filepath.Ext(entry.Name())
+		if entry.IsDir() || (ext != ".yml" && ext != ".yaml") {
+			continue
+		}
+		suite, err := LoadEvalSuite(filepath.Join(evalSuitesPath(config), entry.Name()))
+		if err != nil {
+			loggerFromContext(c.Request().Context()).Warn("skipping invalid eval suite", "file", entry.Name(), "error", err)
+			continue
+		}
+		suites = append(suites, *suite)
+	}
+	sort.Slice(suites, func(i, j int) bool { return suites[i].Name < suites[j].Name })
+	return c.JSON(http.StatusOK, suites)
+}
+
+// handleStartEvalRun runs a suite against a backend in the background.
+func handleStartEvalRun(c echo.Context, config *Config) error {
+	var req EvalRunRequest
+	if err := c.Bind(&req); err != nil || req.Suite == "" {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Suite is required"})
+	}
+
+	suite, err := findEvalSuite(config, req.Suite)
+	if errors.Is(err, os.ErrNotExist) {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "Suite not found"})
+	}
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+	}
+
+	run, err := startEvalRun(config, suite, req)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to start eval run"})
+	}
+	return c.JSON(http.StatusAccepted, run)
+}
+
+// handleGetEvalRuns lists the runs, newest first, optionally of one suite. The reports are left
+// out, see handleGetEvalRun.
+func handleGetEvalRuns(c echo.Context) error {
+	query := db.db.Model(&EvalRun{}).Omit("report")
+	if suite := c.QueryParam("suite"); suite != "" {
+		query = query.Where("suite = ?", suite)
+	}
+	var runs []EvalRun
+	if err := query.Order("id DESC").Limit(maxEvalRuns).Find(&runs).Error; err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to load eval runs"})
+	}
+	return c.JSON(http.StatusOK, runs)
+}
+
+// handleGetEvalRun returns a run with its report.
+func handleGetEvalRun(c echo.Context) error {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid run id"})
+	}
+	var run EvalRun
+	err = db.db.First(&run, id).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "Eval run not found"})
+	}
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to load eval run"})
+	}
+	return c.JSON(http.StatusOK, run)
+}
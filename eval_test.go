@@ -0,0 +1,166 @@
+// eval_test.go
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testEvalSuite = `
+system: Answer briefly.
+cases:
+  - name: capital
+    prompt: What is the capital of France?
+    criteria:
+      - {type: regex, pattern: "(?i)paris"}
+      - {type: regex, pattern: "(?i)london", negate: true}
+      - {type: similarity, reference: Paris is the capital of France.}
+  - name: judged
+    prompt: Name a prime number.
+    criteria:
+      - {type: judge, rubric: Names a prime number.}
+`
+
+func TestLoadEvalSuite(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "smoke.yml")
+	require.NoError(t, os.WriteFile(path, []byte(testEvalSuite), 0644))
+
+	suite, err := LoadEvalSuite(path)
+	require.NoError(t, err)
+	assert.Equal(t, "smoke", suite.Name)
+	assert.Len(t, suite.Cases, 2)
+
+	invalid := map[string]string{
+		"no cases":       "system: x",
+		"duplicate case": "cases: [{name: a, prompt: p, criteria: [{type: regex, pattern: x}]}, {name: a, prompt: p, criteria: [{type: regex, pattern: x}]}]",
+		"bad regex":      "cases: [{name: a, prompt: p, criteria: [{type: regex, pattern: '('}]}]",
+		"unknown type":   "cases: [{name: a, prompt: p, criteria: [{type: bleu}]}]",
+		"no rubric":      "cases: [{name: a, prompt: p, criteria: [{type: judge}]}]",
+	}
+	for name, content := range invalid {
+		require.NoError(t, os.WriteFile(path, []byte(content), 0644))
+		_, err := LoadEvalSuite(path)
+		assert.Error(t, err, name)
+	}
+
+	_, err = findEvalSuite(&Config{DataPath: dir}, "../smoke")
+	assert.ErrorContains(t, err, "invalid suite name")
+	_, err = findEvalSuite(&Config{DataPath: dir}, "missing")
+	assert.ErrorIs(t, err, os.ErrNotExist)
+}
+
+func TestEvalRunner(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "smoke.yml")
+	require.NoError(t, os.WriteFile(path, []byte(testEvalSuite), 0644))
+	suite, err := LoadEvalSuite(path)
+	require.NoError(t, err)
+
+	backend := completionServer(t, func(request CompletionRequest) (string, error) {
+		assert.Equal(t, "Answer briefly.", request.Messages[0].Content)
+		if strings.Contains(request.Messages[1].Content, "France") {
+			return "Paris.", nil
+		}
+		return "Nine.", nil
+	})
+	judge := completionServer(t, func(request CompletionRequest) (string, error) {
+		if strings.Contains(request.Messages[1].Content, "Nine.") {
+			return `Verdict: {"pass": false, "reason": "9 is not prime"}`, nil
+		}
+		return `{"pass": true, "reason": "ok"}`, nil
+	})
+
+	runner := &EvalRunner{
+		client: NewLocalLLMClient(backend.URL, "", ""),
+		judge:  NewLocalLLMClient(judge.URL, "", ""),
+		embed: func(ctx context.Context, texts []string) ([][]float64, error) {
+			return [][]float64{{1, 0.1}, {1, 0}}, nil
+		},
+	}
+	report := runner.Run(context.Background(), suite)
+	assert.Equal(t, 1, report.Passed)
+	assert.Equal(t, 1, report.Failed)
+	require.Len(t, report.Cases, 2)
+
+	capital := report.Cases[0]
+	assert.True(t, capital.Passed)
+	assert.Equal(t, "Paris.", capital.Response)
+	require.Len(t, capital.Criteria, 3)
+	assert.InDelta(t, 0.995, capital.Criteria[2].Score, 0.001)
+
+	judged := report.Cases[1]
+	assert.False(t, judged.Passed)
+	assert.Equal(t, "9 is not prime", judged.Criteria[0].Detail)
+
+	// A criterion that cannot be checked fails the case
+	runner.embed = nil
+	report = runner.Run(context.Background(), suite)
+	assert.False(t, report.Cases[0].Passed)
+	assert.Equal(t, "no embeddings backend", report.Cases[0].Criteria[2].Detail)
+}
+
+func TestHandleStartEvalRunValidation(t *testing.T) {
+	config := &Config{DataPath: t.TempDir()}
+	e := echo.New()
+	start := func(body string) int {
+		req := httptest.NewRequest(http.MethodPost, "/v1/evals/runs", strings.NewReader(body))
+		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		rec := httptest.NewRecorder()
+		require.NoError(t, handleStartEvalRun(e.NewContext(req, rec), config))
+		return rec.Code
+	}
+	assert.Equal(t, http.StatusBadRequest, start(`{}`))
+	assert.Equal(t, http.StatusBadRequest, start(`{"suite": "a/b"}`))
+	assert.Equal(t, http.StatusNotFound, start(`{"suite": "missing"}`))
+}
+
+func TestEvalRunnerHoldsChatBackendPerCall(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "smoke.yml")
+	require.NoError(t, os.WriteFile(path, []byte(testEvalSuite), 0644))
+	suite, err := LoadEvalSuite(path)
+	require.NoError(t, err)
+
+	useTestBackend(t)
+	held := func() int {
+		idleMonitor.mu.Lock()
+		defer idleMonitor.mu.Unlock()
+		return idleMonitor.active
+	}
+
+	// The chat backend judges, it is only held while it answers
+	endpoint := completionServer(t, func(request CompletionRequest) (string, error) {
+		assert.Equal(t, 0, held(), "the chat backend is held while the endpoint answers")
+		return "Paris.", nil
+	})
+	var judged atomic.Int32
+	chat := completionServer(t, func(request CompletionRequest) (string, error) {
+		judged.Add(1)
+		assert.Equal(t, 1, held())
+		return `{"pass": true, "reason": "ok"}`, nil
+	})
+	backendMu.Lock()
+	llmClient = NewLocalLLMClient(chat.URL, "", "")
+	backendMu.Unlock()
+
+	runner := &EvalRunner{
+		client: NewLocalLLMClient(endpoint.URL, "", ""),
+		embed: func(ctx context.Context, texts []string) ([][]float64, error) {
+			return [][]float64{{1, 0}, {1, 0}}, nil
+		},
+	}
+	report := runner.Run(context.Background(), suite)
+	require.Len(t, report.Cases, 2)
+	assert.Empty(t, report.Cases[1].Error)
+	assert.Positive(t, judged.Load())
+	assert.Equal(t, 0, held())
+}
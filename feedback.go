@@ -0,0 +1,161 @@
+// manifold/feedback.go
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"gorm.io/gorm"
+)
+
+const maxFeedbackChars = 2000
+
+// FeedbackRequest rates a chat response. Rating is up, down or none to clear it.
+type FeedbackRequest struct {
+	ResponseID int64  `json:"response_id" form:"response_id"`
+	Rating     string `json:"rating" form:"rating"`
+	Comment    string `json:"comment" form:"comment"`
+}
+
+// FeedbackStats aggregates the responses and ratings of a model or role.
+type FeedbackStats struct {
+	Name       string  `json:"name"`
+	Responses  int64   `json:"responses"`
+	ThumbsUp   int64   `json:"thumbs_up"`
+	ThumbsDown int64   `json:"thumbs_down"`
+	Approval   float64 `json:"approval"` // share of the rated responses rated up
+}
+
+// UsageStats summarizes the stored chat history.
+type UsageStats struct {
+	Sessions int64           `json:"sessions"`
+	Turns    int64           `json:"turns"`
+	Models   []FeedbackStats `json:"models"`
+	Roles    []FeedbackStats `json:"roles"`
+}
+
+// parseRating converts up, down or none to the stored rating.
+func parseRating(v string) (int, error) {
+	switch strings.ToLower(strings.TrimSpace(v)) {
+	case "up", "1", "+1":
+		return 1, nil
+	case "down", "-1":
+		return -1, nil
+	case "none", "0", "":
+		return 0, nil
+	}
+	return 0, fmt.Errorf("unknown rating %q", v)
+}
+
+// RateChatResponse stores the rating and comment of a response.
+func (sqldb *SQLiteDB) RateChatResponse(id int64, rating int, comment string) (ChatResponse, error) {
+	var response ChatResponse
+	if err := sqldb.db.First(&response, id).Error; err != nil {
+		return response, err
+	}
+	now := time.Now()
+	err := sqldb.db.Model(&response).Updates(map[string]interface{}{"rating": rating, "feedback": comment, "rated_at": &now}).Error
+	return response, err
+}
+
+// GetUsageStats counts the sessions, turns and responses since the given time, with the
+// responses and their ratings grouped by model and by role.
+func (sqldb *SQLiteDB) GetUsageStats(since time.Time) (UsageStats, error) {
+	var stats UsageStats
+	if err := sqldb.db.Model(&ChatSession{}).Where("updated_at >= ?", since).Count(&stats.Sessions).Error; err != nil {
+		return stats, err
+	}
+	if err := sqldb.db.Model(&ChatTurn{}).Where("created_at >= ?", since).Count(&stats.Turns).Error; err != nil {
+		return stats, err
+	}
+
+	aggregate := func(group string) ([]FeedbackStats, error) {
+		var rows []FeedbackStats
+		err := sqldb.db.Model(&ChatResponse{}).
+			Select(group+" AS name, COUNT(*) AS responses, "+
+				"SUM(CASE WHEN chat_responses.rating > 0 THEN 1 ELSE 0 END) AS thumbs_up, "+
+				"SUM(CASE WHEN chat_responses.rating < 0 THEN 1 ELSE 0 END) AS thumbs_down").
+			Joins("JOIN chat_turns ON chat_turns.id = chat_responses.turn_id").
+			Where("chat_responses.created_at >= ?", since).
+			Group(group).Order("responses DESC").Scan(&rows).Error
+		for i, row := range rows {
+			if rated := row.ThumbsUp + row.ThumbsDown; rated > 0 {
+				rows[i].Approval = float64(row.ThumbsUp) / float64(rated)
+			}
+		}
+		return rows, err
+	}
+
+	var err error
+	if stats.Models, err = aggregate("chat_responses.model"); err != nil {
+		return stats, err
+	}
+	stats.Roles, err = aggregate("chat_turns.role")
+	return stats, err
+}
+
+// feedbackHTML renders the rating buttons of a response. The websocket swaps them into the
+// feedback placeholder of the chat turn.
+func feedbackHTML(turnID int, responseID int64) string {
+	button := func(rating, label, title string) string {
+		return fmt.Sprintf(`<button type='button' class='btn btn-link btn-sm' title='%s' hx-post='/v1/feedback' hx-vals='{"response_id": %d, "rating": "%s"}' hx-swap='none' `+
+			`hx-on::after-request='this.parentElement.querySelectorAll("button").forEach(b => b.classList.remove("active")); this.classList.add("active")'>%s</button>`,
+			title, responseID, rating, label)
+	}
+	return fmt.Sprintf("<div id='feedback-%d' class='chat-feedback mx-1'>%s%s</div>", turnID,
+		button("up", "&#128077;", "Good response"), button("down", "&#128078;", "Bad response"))
+}
+
+// handleFeedback rates a chat response. The rating feeds the usage stats and the dataset
+// exporter.
+func handleFeedback(c echo.Context) error {
+	var req FeedbackRequest
+	if err := c.Bind(&req); err != nil || req.ResponseID <= 0 {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid request body"})
+	}
+	rating, err := parseRating(req.Rating)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid rating, use up, down or none"})
+	}
+	if len(req.Comment) > maxFeedbackChars {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": fmt.Sprintf("Comment is longer than %d characters", maxFeedbackChars)})
+	}
+
+	response, err := db.RateChatResponse(req.ResponseID, rating, strings.TrimSpace(req.Comment))
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "Response not found"})
+	}
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to save feedback"})
+	}
+
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"status":      "success",
+		"response_id": response.ID,
+		"rating":      rating,
+	})
+}
+
+// handleGetUsage returns the usage stats of the chat history, since (RFC 3339) limiting them
+// to recent chats.
+func handleGetUsage(c echo.Context) error {
+	var since time.Time
+	if v := c.QueryParam("since"); v != "" {
+		parsed, err := time.Parse(time.RFC3339, v)
+		if err != nil {
+			return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid since, use RFC 3339"})
+		}
+		since = parsed
+	}
+
+	stats, err := db.GetUsageStats(since)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to load usage stats"})
+	}
+	return c.JSON(http.StatusOK, stats)
+}
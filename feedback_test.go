@@ -0,0 +1,96 @@
+// feedback_test.go
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFeedback(t *testing.T) {
+	testDB, err := NewSQLiteDB(t.TempDir())
+	require.NoError(t, err)
+	require.NoError(t, testDB.AutoMigrate(&ChatSession{}, &ChatTurn{}, &ChatResponse{}))
+
+	saved := db
+	db = testDB
+	defer func() { db = saved }()
+
+	record := func(role, model, response string) int64 {
+		ctx := withTurn(context.Background(), TurnInfo{SessionID: "s1", TurnID: newSessionID()})
+		return recordChatHistory(ctx, ChatTurn{Role: role, UserPrompt: "question"}, model, response)
+	}
+	good := record("coder", "llama", "good answer")
+	bad := record("coder", "qwen", "bad answer")
+	record("poet", "llama", "unrated answer")
+	require.NotZero(t, good)
+
+	e := echo.New()
+	rate := func(body string) int {
+		req := httptest.NewRequest(http.MethodPost, "/v1/feedback", strings.NewReader(body))
+		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		rec := httptest.NewRecorder()
+		require.NoError(t, handleFeedback(e.NewContext(req, rec)))
+		return rec.Code
+	}
+	assert.Equal(t, http.StatusOK, rate(`{"response_id": `+strconv.FormatInt(good, 10)+`, "rating": "up"}`))
+	assert.Equal(t, http.StatusOK, rate(`{"response_id": `+strconv.FormatInt(bad, 10)+`, "rating": "down", "comment": "wrong"}`))
+	assert.Equal(t, http.StatusNotFound, rate(`{"response_id": 999, "rating": "up"}`))
+	assert.Equal(t, http.StatusBadRequest, rate(`{"response_id": `+strconv.FormatInt(good, 10)+`, "rating": "meh"}`))
+	assert.Equal(t, http.StatusBadRequest, rate(`{"rating": "up"}`))
+
+	var response ChatResponse
+	require.NoError(t, db.db.First(&response, bad).Error)
+	assert.Equal(t, -1, response.Rating)
+	assert.Equal(t, "wrong", response.Feedback)
+	assert.NotNil(t, response.RatedAt)
+
+	stats, err := db.GetUsageStats(time.Time{})
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), stats.Sessions)
+	assert.Equal(t, int64(3), stats.Turns)
+	assert.Equal(t, []FeedbackStats{
+		{Name: "llama", Responses: 2, ThumbsUp: 1, Approval: 1},
+		{Name: "qwen", Responses: 1, ThumbsDown: 1},
+	}, stats.Models)
+	assert.Equal(t, []FeedbackStats{
+		{Name: "coder", Responses: 2, ThumbsUp: 1, ThumbsDown: 1, Approval: 0.5},
+		{Name: "poet", Responses: 1},
+	}, stats.Roles)
+
+	// Only thumbs up rated turns are exported
+	turns, err := db.GetDatasetTurns(DatasetFilter{Rating: 1})
+	require.NoError(t, err)
+	examples := datasetExamples(turns, DatasetFilter{Rating: 1})
+	require.Len(t, examples, 1)
+	assert.Equal(t, "good answer", examples[0][1].Content)
+}
+
+func TestParseRating(t *testing.T) {
+	for in, want := range map[string]int{"up": 1, "Down": -1, "none": 0, "": 0, "+1": 1} {
+		got, err := parseRating(in)
+		require.NoError(t, err, in)
+		assert.Equal(t, want, got, in)
+	}
+	_, err := parseRating("5 stars")
+	assert.Error(t, err)
+}
+
+func TestFeedbackHTML(t *testing.T) {
+	html := feedbackHTML(4, 12)
+	assert.True(t, strings.HasPrefix(html, "<div id='feedback-4'"))
+
+	start := strings.Index(html, "hx-vals='") + len("hx-vals='")
+	var vals map[string]interface{}
+	require.NoError(t, json.Unmarshal([]byte(html[start:start+strings.Index(html[start:], "'")]), &vals))
+	assert.Equal(t, map[string]interface{}{"response_id": float64(12), "rating": "up"}, vals)
+}
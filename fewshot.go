@@ -0,0 +1,204 @@
+// manifold/fewshot.go
+
+package main
+
+import (
+	"context"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/labstack/echo/v4"
+
+	"manifold/internal/sqlitevec"
+)
+
+// defaultFewShotK is the number of examples prepended when a role does not set few_shot_k.
+const defaultFewShotK = 3
+
+// RoleExample is a labeled input and output pair shown to the model as an earlier exchange when
+// the role is used. The embedding of the input picks the examples closest to the current prompt.
+type RoleExample struct {
+	ID        uint      `gorm:"primaryKey" json:"id"`
+	RoleName  string    `gorm:"index" json:"role"`
+	Label     string    `json:"label,omitempty"`
+	Input     string    `gorm:"type:text" json:"input"`
+	Output    string    `gorm:"type:text" json:"output"`
+	Embedding []byte    `json:"-"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// GetRoleExamples returns the examples attached to a role, newest first.
+func (sqldb *SQLiteDB) GetRoleExamples(roleName string) ([]RoleExample, error) {
+	var examples []RoleExample
+	err := sqldb.db.Where("role_name = ?", roleName).Order("created_at DESC").Find(&examples).Error
+	return examples, err
+}
+
+// similarity is the cosine similarity of two embeddings, 0 when they cannot be compared.
+func similarity(a, b []float64) float64 {
+	if len(a) == 0 || len(a) != len(b) {
+		return 0
+	}
+	var dot, normA, normB float64
+	for i := range a {
+		dot += a[i] * b[i]
+		normA += a[i] * a[i]
+		normB += b[i] * b[i]
+	}
+	if normA == 0 || normB == 0 {
+		return 0
+	}
+	return dot / (math.Sqrt(normA) * math.Sqrt(normB))
+}
+
+// rankExamples orders examples by the similarity of their embedding to the prompt's and keeps k.
+// Examples without an embedding rank last, in their original order.
+func rankExamples(examples []RoleExample, prompt []float64, k int) []RoleExample {
+	scores := make(map[uint]float64, len(examples))
+	for _, ex := range examples {
+		embedding, _ := sqlitevec.DeserializeFloat64(ex.Embedding) // a corrupt embedding ranks last
+		scores[ex.ID] = similarity(embedding, prompt)
+	}
+
+	ranked := append([]RoleExample(nil), examples...)
+	sort.SliceStable(ranked, func(i, j int) bool {
+		return scores[ranked[i].ID] > scores[ranked[j].ID]
+	})
+	if len(ranked) > k {
+		ranked = ranked[:k]
+	}
+	return ranked
+}
+
+// selectRoleExamples returns the k examples of a role most relevant to the prompt. Examples saved
+// while the embeddings backend was down are embedded now. When the prompt cannot be embedded the
+// newest examples are used.
+func selectRoleExamples(ctx context.Context, role *CompletionsRole, prompt string) []RoleExample {
+	k := role.FewShotK
+	if k == 0 {
+		k = defaultFewShotK
+	}
+	if k < 0 {
+		return nil
+	}
+
+	examples, err := db.GetRoleExamples(role.Name)
+	if err != nil || len(examples) == 0 {
+		return nil
+	}
+	if len(examples) <= k {
+		return examples
+	}
+
+	logger := loggerFromContext(ctx)
+	promptEmbedding, err := GenerateEmbedding(ctx, prompt)
+	if err != nil {
+		logger.Warn("failed to embed prompt for few-shot examples", "role", role.Name, "error", err)
+		return examples[:k]
+	}
+
+	for i := range examples {
+		if len(examples[i].Embedding) > 0 {
+			continue
+		}
+		if embedding, err := GenerateEmbedding(ctx, examples[i].Input); err == nil {
+			examples[i].Embedding = sqlitevec.SerializeFloat64(embedding)
+			db.db.Model(&examples[i]).Update("embedding", examples[i].Embedding)
+		}
+	}
+
+	return rankExamples(examples, promptEmbedding, k)
+}
+
+// fewShotMessages turns examples into user and assistant turns, the most relevant one closest to the prompt.
+func fewShotMessages(examples []RoleExample) []Message {
+	var messages []Message
+	for i := len(examples) - 1; i >= 0; i-- {
+		messages = append(messages,
+			Message{Role: "user", Content: examples[i].Input},
+			Message{Role: "assistant", Content: examples[i].Output},
+		)
+	}
+	return messages
+}
+
+// withRoleExamples inserts the role's most relevant examples between the system message and the prompt.
+func withRoleExamples(ctx context.Context, messages []Message, roleName, prompt string) []Message {
+	if roleName == "" {
+		roleName = defaultRoleName
+	}
+	role, err := db.GetRole(roleName)
+	if err != nil || len(messages) < 2 {
+		return messages
+	}
+
+	examples := fewShotMessages(selectRoleExamples(ctx, role, prompt))
+	if len(examples) == 0 {
+		return messages
+	}
+
+	last := len(messages) - 1
+	out := append([]Message(nil), messages[:last]...)
+	out = append(out, examples...)
+	return append(out, messages[last])
+}
+
+// handleGetRoleExamples lists the examples attached to a role.
+func handleGetRoleExamples(c echo.Context) error {
+	examples, err := db.GetRoleExamples(c.Param("name"))
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to load examples"})
+	}
+	return c.JSON(http.StatusOK, examples)
+}
+
+// handleCreateRoleExample attaches an example to a role and embeds its input.
+func handleCreateRoleExample(c echo.Context) error {
+	role, err := db.GetRole(c.Param("name"))
+	if err != nil {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "Role not found"})
+	}
+
+	var example RoleExample
+	if err := c.Bind(&example); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid request body"})
+	}
+	if example.Input == "" || example.Output == "" {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Input and output are required"})
+	}
+	example.ID = 0
+	example.RoleName = role.Name
+
+	// Without an embedding the example is embedded the first time it is ranked
+	ctx := c.Request().Context()
+	if embedding, err := GenerateEmbedding(ctx, example.Input); err != nil {
+		loggerFromContext(ctx).Warn("failed to embed example", "role", role.Name, "error", err)
+	} else {
+		example.Embedding = sqlitevec.SerializeFloat64(embedding)
+	}
+
+	if err := db.Create(&example); err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to save example"})
+	}
+	return c.JSON(http.StatusCreated, example)
+}
+
+// handleDeleteRoleExample removes an example from a role.
+func handleDeleteRoleExample(c echo.Context) error {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid example id"})
+	}
+
+	result := db.db.Where("id = ? AND role_name = ?", id, c.Param("name")).Delete(&RoleExample{})
+	if result.Error != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to delete example"})
+	}
+	if result.RowsAffected == 0 {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "Example not found"})
+	}
+	return c.JSON(http.StatusOK, map[string]string{"status": "success", "example": c.Param("id")})
+}
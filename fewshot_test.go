@@ -0,0 +1,41 @@
+// fewshot_test.go
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"manifold/internal/sqlitevec"
+)
+
+func TestRankExamples(t *testing.T) {
+	examples := []RoleExample{
+		{ID: 1, Input: "weather", Embedding: sqlitevec.SerializeFloat64([]float64{0, 1})},
+		{ID: 2, Input: "no embedding"},
+		{ID: 3, Input: "math", Embedding: sqlitevec.SerializeFloat64([]float64{1, 0.1})},
+		{ID: 4, Input: "sums", Embedding: sqlitevec.SerializeFloat64([]float64{1, 0})},
+	}
+
+	ranked := rankExamples(examples, []float64{1, 0}, 2)
+	assert.Len(t, ranked, 2)
+	assert.Equal(t, uint(4), ranked[0].ID)
+	assert.Equal(t, uint(3), ranked[1].ID)
+
+	// Mismatched dimensions score 0 instead of failing
+	assert.Equal(t, 0.0, similarity([]float64{1}, []float64{1, 0}))
+}
+
+func TestFewShotMessages(t *testing.T) {
+	messages := fewShotMessages([]RoleExample{
+		{Input: "best in", Output: "best out"},
+		{Input: "second in", Output: "second out"},
+	})
+
+	assert.Equal(t, []Message{
+		{Role: "user", Content: "second in"},
+		{Role: "assistant", Content: "second out"},
+		{Role: "user", Content: "best in"},
+		{Role: "assistant", Content: "best out"},
+	}, messages)
+}
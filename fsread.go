@@ -0,0 +1,290 @@
+// manifold/fsread.go
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+)
+
+const (
+	defaultFSMaxFileKB  = 256
+	defaultFSMaxTotalKB = 1024
+	defaultFSMaxEntries = 200
+	fsSniffBytes        = 8000
+)
+
+// fsReferencePattern matches @path references in the prompt, e.g. @src/main.go or @./docs.
+var fsReferencePattern = regexp.MustCompile(`(?:^|\s)@((?:[~./]|[A-Za-z0-9_-])[^\s` + "`" + `'"]*)`)
+
+// FSReadTool lets a chat look at local files without shell access. Files and directories mentioned
+// as @path in the prompt are read or listed when they lie under one of the configured roots.
+// Relative references resolve against each root in order. Symlinks are followed only when their
+// target stays inside a root, large files are cut off and binary files are skipped.
+type FSReadTool struct {
+	enabled    bool
+	roots      []string
+	maxFileKB  int
+	maxTotalKB int
+	maxEntries int
+}
+
+// Process reads the files and lists the directories referenced in the input.
+func (t *FSReadTool) Process(ctx context.Context, input string) (string, error) {
+	refs := extractFSReferences(input)
+	if len(refs) == 0 {
+		return "", nil
+	}
+
+	logger := loggerFromContext(ctx)
+	budget := t.maxTotalKB << 10
+
+	var out strings.Builder
+	out.WriteString("Local files:\n")
+	for _, ref := range refs {
+		path, err := t.resolve(ref)
+		if err != nil {
+			logger.Debug("skipping file reference", "ref", ref, "error", err)
+			fmt.Fprintf(&out, "\n%s: %v\n", ref, err)
+			continue
+		}
+
+		info, err := os.Stat(path)
+		if err != nil {
+			fmt.Fprintf(&out, "\n%s: %v\n", ref, err)
+			continue
+		}
+
+		if info.IsDir() {
+			listing, err := t.listDir(path)
+			if err != nil {
+				fmt.Fprintf(&out, "\n%s: %v\n", ref, err)
+				continue
+			}
+			fmt.Fprintf(&out, "\nDirectory %s:\n%s", ref, listing)
+			continue
+		}
+
+		if budget <= 0 {
+			fmt.Fprintf(&out, "\n%s: skipped, the read limit of %dKB is reached\n", ref, t.maxTotalKB)
+			continue
+		}
+		limit := min(t.maxFileKB<<10, budget)
+		content, truncated, err := readTextFile(path, limit)
+		if err != nil {
+			fmt.Fprintf(&out, "\n%s: %v\n", ref, err)
+			continue
+		}
+		budget -= len(content)
+
+		fmt.Fprintf(&out, "\nFile %s:\n```\n%s\n```\n", ref, strings.TrimRight(content, "\n"))
+		if truncated {
+			fmt.Fprintf(&out, "(truncated, the file is %d bytes)\n", info.Size())
+		}
+	}
+	return out.String(), nil
+}
+
+// Relevant reports whether the prompt references local files.
+func (t *FSReadTool) Relevant(prompt string) bool {
+	return len(extractFSReferences(prompt)) > 0
+}
+
+// Enabled returns the enabled status of the tool.
+func (t *FSReadTool) Enabled() bool {
+	return t.enabled
+}
+
+// SetParams configures the tool with provided parameters.
+func (t *FSReadTool) SetParams(params map[string]interface{}, config *Config) error {
+	if enabled, ok := params["enabled"].(bool); ok {
+		t.enabled = enabled
+	}
+
+	t.roots = nil
+	for _, root := range interfaceToStringSlice(params["roots"]) {
+		abs, err := filepath.Abs(expandHome(root))
+		if err != nil {
+			return fmt.Errorf("invalid root %s: %w", root, err)
+		}
+		if resolved, err := filepath.EvalSymlinks(abs); err == nil {
+			abs = resolved
+		}
+		t.roots = append(t.roots, abs)
+	}
+	if t.enabled && len(t.roots) == 0 {
+		return errors.New("fsread needs at least one root")
+	}
+
+	t.maxFileKB = defaultFSMaxFileKB
+	if kb, ok := params["max_file_kb"].(int); ok && kb > 0 {
+		t.maxFileKB = kb
+	}
+	t.maxTotalKB = defaultFSMaxTotalKB
+	if kb, ok := params["max_total_kb"].(int); ok && kb > 0 {
+		t.maxTotalKB = kb
+	}
+	t.maxEntries = defaultFSMaxEntries
+	if n, ok := params["max_entries"].(int); ok && n > 0 {
+		t.maxEntries = n
+	}
+	return nil
+}
+
+// GetParams returns the tool's parameters.
+func (t *FSReadTool) GetParams() map[string]interface{} {
+	return map[string]interface{}{
+		"enabled":      t.enabled,
+		"roots":        t.roots,
+		"max_file_kb":  t.maxFileKB,
+		"max_total_kb": t.maxTotalKB,
+		"max_entries":  t.maxEntries,
+	}
+}
+
+// extractFSReferences returns the distinct @path references in the input, in order.
+func extractFSReferences(input string) []string {
+	seen := make(map[string]bool)
+	var refs []string
+	for _, match := range fsReferencePattern.FindAllStringSubmatch(input, -1) {
+		ref := strings.TrimRight(match[1], ".,;:!?)")
+		if ref == "" || seen[ref] {
+			continue
+		}
+		seen[ref] = true
+		refs = append(refs, ref)
+	}
+	return refs
+}
+
+// expandHome replaces a leading ~ with the user's home directory.
+func expandHome(path string) string {
+	if path != "~" && !strings.HasPrefix(path, "~/") {
+		return path
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return path
+	}
+	return filepath.Join(home, path[1:])
+}
+
+// resolve maps a reference to a path inside one of the roots, following symlinks.
+func (t *FSReadTool) resolve(ref string) (string, error) {
+	ref = expandHome(ref)
+
+	var candidates []string
+	if filepath.IsAbs(ref) {
+		candidates = []string{ref}
+	} else {
+		for _, root := range t.roots {
+			candidates = append(candidates, filepath.Join(root, ref))
+		}
+	}
+
+	for _, candidate := range candidates {
+		resolved, err := filepath.EvalSymlinks(candidate)
+		if err != nil {
+			continue
+		}
+		if t.inRoots(resolved) {
+			return resolved, nil
+		}
+		return "", errors.New("outside the allowed paths")
+	}
+	return "", errors.New("not found under the allowed paths")
+}
+
+// inRoots reports whether path is one of the roots or inside one.
+func (t *FSReadTool) inRoots(path string) bool {
+	for _, root := range t.roots {
+		rel, err := filepath.Rel(root, path)
+		if err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+			return true
+		}
+	}
+	return false
+}
+
+// listDir lists a directory, directories first marked with a trailing slash.
+func (t *FSReadTool) listDir(path string) (string, error) {
+	entries, err := os.ReadDir(path)
+	if err != nil {
+		return "", err
+	}
+
+	var dirs, files []string
+	for _, entry := range entries {
+		if entry.IsDir() {
+			dirs = append(dirs, entry.Name()+"/")
+			continue
+		}
+		name := entry.Name()
+		if info, err := entry.Info(); err == nil && info.Mode().IsRegular() {
+			name = fmt.Sprintf("%s (%d bytes)", name, info.Size())
+		}
+		files = append(files, name)
+	}
+
+	var out strings.Builder
+	names := append(dirs, files...)
+	for i, name := range names {
+		if i == t.maxEntries {
+			fmt.Fprintf(&out, "... %d more entries\n", len(names)-i)
+			break
+		}
+		out.WriteString(name + "\n")
+	}
+	return out.String(), nil
+}
+
+// readTextFile reads up to limit bytes of a file. Files that look binary are rejected.
+func readTextFile(path string, limit int) (string, bool, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", false, err
+	}
+	defer f.Close()
+
+	data, err := io.ReadAll(io.LimitReader(f, int64(limit)+1))
+	if err != nil {
+		return "", false, err
+	}
+	if isBinary(data) {
+		return "", false, errors.New("binary file, not shown")
+	}
+
+	truncated := len(data) > limit
+	if truncated {
+		data = data[:limit]
+		// Do not cut a multi-byte character in half
+		for i := 0; i < utf8.UTFMax && len(data) > 0 && !utf8.Valid(data); i++ {
+			data = data[:len(data)-1]
+		}
+	}
+	return string(data), truncated, nil
+}
+
+// isBinary reports whether data looks like binary content: it has NUL bytes or is not UTF-8.
+func isBinary(data []byte) bool {
+	sniff := data
+	if len(sniff) > fsSniffBytes {
+		sniff = sniff[:fsSniffBytes]
+	}
+	if bytes.IndexByte(sniff, 0) >= 0 {
+		return true
+	}
+	// The sniffed prefix may end inside a multi-byte character
+	for i := 0; i < utf8.UTFMax && len(sniff) > 0 && !utf8.Valid(sniff); i++ {
+		sniff = sniff[:len(sniff)-1]
+	}
+	return !utf8.Valid(sniff)
+}
@@ -0,0 +1,59 @@
+// fsread_test.go
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestExtractFSReferences(t *testing.T) {
+	input := "Compare @src/main.go with @./docs, mail me at me@example.com and see @src/main.go."
+
+	assert.Equal(t, []string{"src/main.go", "./docs"}, extractFSReferences(input))
+}
+
+func TestIsBinary(t *testing.T) {
+	assert.False(t, isBinary([]byte("package main\n\nfunc main() {}\n")))
+	assert.False(t, isBinary([]byte("héllo wörld")))
+	assert.True(t, isBinary([]byte{0x7f, 'E', 'L', 'F', 0, 0, 1}))
+	assert.True(t, isBinary([]byte{0xff, 0xfe, 0xfd, 'a', 'b'}))
+}
+
+func TestFSReadTool(t *testing.T) {
+	root := t.TempDir()
+	outside := t.TempDir()
+	require.NoError(t, os.MkdirAll(filepath.Join(root, "src"), 0755))
+	require.NoError(t, os.WriteFile(filepath.Join(root, "src", "main.go"), []byte("package main\n"), 0644))
+	require.NoError(t, os.WriteFile(filepath.Join(root, "big.txt"), []byte(strings.Repeat("a", 2048)), 0644))
+	require.NoError(t, os.WriteFile(filepath.Join(root, "image.bin"), []byte{1, 0, 2, 0}, 0644))
+	require.NoError(t, os.WriteFile(filepath.Join(outside, "secret.txt"), []byte("secret"), 0644))
+	require.NoError(t, os.Symlink(filepath.Join(outside, "secret.txt"), filepath.Join(root, "link.txt")))
+
+	tool := &FSReadTool{}
+	require.NoError(t, tool.SetParams(map[string]interface{}{
+		"enabled":     true,
+		"roots":       []interface{}{root},
+		"max_file_kb": 1,
+	}, &Config{}))
+
+	out, err := tool.Process(context.Background(), "Look at @src/main.go, @src @big.txt @image.bin @link.txt @../secret.txt @"+filepath.Join(outside, "secret.txt"))
+	require.NoError(t, err)
+
+	assert.Contains(t, out, "File src/main.go:\n```\npackage main\n```")
+	assert.Contains(t, out, "Directory src:\nmain.go (13 bytes)\n")
+	assert.Contains(t, out, "(truncated, the file is 2048 bytes)")
+	assert.Contains(t, out, "image.bin: binary file, not shown")
+	assert.Contains(t, out, "link.txt: outside the allowed paths")
+	assert.NotContains(t, out, "secret\n")
+}
+
+func TestFSReadToolNeedsRoots(t *testing.T) {
+	tool := &FSReadTool{}
+	assert.Error(t, tool.SetParams(map[string]interface{}{"enabled": true}, &Config{}))
+}
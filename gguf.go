@@ -1,5 +1,14 @@
 package main
 
+import (
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// GGUFOptions mirrors the llama-server command line. Each field's flag tag lists the long flag
+// name followed by its short aliases.
 type GGUFOptions struct {
 	General   GeneralOptions
 	Sampling  SamplingOptions
@@ -29,7 +38,10 @@ type GeneralOptions struct {
 	ThreadsBatch       *int     `flag:"threads-batch,tb"`
 	ThreadsDraft       *int     `flag:"threads-draft,td"`
 	ThreadsBatchDraft  *int     `flag:"threads-batch-draft,tbd"`
-	Draft              *int     `flag:"draft"`
+	Draft              *int     `flag:"draft-max,draft,draft-n"`
+	DraftMin           *int     `flag:"draft-min,draft-n-min"`
+	DraftPMin          *float64 `flag:"draft-p-min"`
+	CtxSizeDraft       *int     `flag:"ctx-size-draft,cd"`
 	PSplit             *float64 `flag:"p-split,ps"`
 	LookupCacheStatic  *string  `flag:"lookup-cache-static,lcs"`
 	LookupCacheDynamic *string  `flag:"lookup-cache-dynamic,lcd"`
@@ -123,12 +135,21 @@ type ContextOptions struct {
 type ModelOptions struct {
 	CheckTensors            bool     `flag:"check-tensors"`
 	OverrideKv              []string `flag:"override-kv"`
-	Lora                    *string  `flag:"lora"`
+	Lora                    []string `flag:"lora"`
 	LoraScaled              []string `flag:"lora-scaled"`
 	LoraBase                *string  `flag:"lora-base"`
+	LoraInitWithoutApply    bool     `flag:"lora-init-without-apply"`
+	Mmproj                  *string  `flag:"mmproj"`
 	ControlVector           []string `flag:"control-vector"`
 	ControlVectorScaled     []string `flag:"control-vector-scaled"`
 	ControlVectorLayerRange []int    `flag:"control-vector-layer-range"`
+	GpuLayers               *int     `flag:"gpu-layers,ngl"`
+	GpuLayersDraft          *int     `flag:"gpu-layers-draft,ngld"`
+	SplitMode               *string  `flag:"split-mode,sm"`
+	TensorSplit             *string  `flag:"tensor-split,ts"`
+	MainGpu                 *int     `flag:"main-gpu,mg"`
+	Mlock                   bool     `flag:"mlock"`
+	NoMmap                  bool     `flag:"no-mmap"`
 	Model                   *string  `flag:"model,m"`
 	ModelDraft              *string  `flag:"model-draft,md"`
 	ModelUrl                *string  `flag:"model-url,mu"`
@@ -168,6 +189,8 @@ type ServerOptions struct {
 	Host                 *string  `flag:"host"`
 	Port                 *int     `flag:"port"`
 	Path                 *string  `flag:"path"`
+	Parallel             *int     `flag:"parallel,np"`
+	ContBatching         bool     `flag:"cont-batching,cb"`
 	Embedding            bool     `flag:"embedding"`
 	ApiKey               *string  `flag:"api-key"`
 	ApiKeyFile           *string  `flag:"api-key-file"`
@@ -203,3 +226,189 @@ type CVectorOptions struct {
 	PcaIter      *int    `flag:"pca-iter"`
 	Method       *string `flag:"method"`
 }
+
+// flagNames returns the long name and aliases from a flag struct tag.
+func flagNames(tag string) []string {
+	names := strings.Split(tag, ",")
+	for i := range names {
+		names[i] = strings.TrimSpace(names[i])
+	}
+	return names
+}
+
+// Args serializes the options into llama-server argv. Unset pointer fields and false booleans are
+// skipped, slices repeat the flag once per element, and flags follow the struct's field order.
+func (o *GGUFOptions) Args() []string {
+	var args []string
+	walkFlagTags(reflect.ValueOf(o).Elem(), func(names []string, field reflect.Value) {
+		args = append(args, flagArgs(names[0], field)...)
+	})
+	return args
+}
+
+// flagArgs returns the argv entries for a single field.
+func flagArgs(name string, field reflect.Value) []string {
+	flag := "--" + name
+
+	switch field.Kind() {
+	case reflect.Bool:
+		if field.Bool() {
+			return []string{flag}
+		}
+	case reflect.Ptr:
+		if !field.IsNil() {
+			return []string{flag, formatFlagValue(field.Elem())}
+		}
+	case reflect.Slice:
+		var args []string
+		for i := 0; i < field.Len(); i++ {
+			args = append(args, flag, formatFlagValue(field.Index(i)))
+		}
+		return args
+	}
+	return nil
+}
+
+// formatFlagValue formats a scalar flag value the way llama-server expects it.
+func formatFlagValue(v reflect.Value) string {
+	switch v.Kind() {
+	case reflect.Float64:
+		return strconv.FormatFloat(v.Float(), 'f', -1, 64)
+	case reflect.Int:
+		return strconv.FormatInt(v.Int(), 10)
+	case reflect.Bool:
+		return strconv.FormatBool(v.Bool())
+	default:
+		return v.String()
+	}
+}
+
+// ParseGGUFOptions populates GGUFOptions from a map of flag names (long or short, without dashes)
+// to values, as read from the llama section of config.yml. Unknown flags are an error.
+func ParseGGUFOptions(values map[string]interface{}) (*GGUFOptions, error) {
+	opts := &GGUFOptions{}
+
+	// Long names are registered before aliases so an alias never shadows another flag's long name.
+	// Flags shared by several option groups (e.g. chat-template) resolve to the first group.
+	fields := make(map[string]reflect.Value)
+	root := reflect.ValueOf(opts).Elem()
+	walkFlagTags(root, func(names []string, field reflect.Value) {
+		if _, ok := fields[names[0]]; !ok {
+			fields[names[0]] = field
+		}
+	})
+	walkFlagTags(root, func(names []string, field reflect.Value) {
+		for _, alias := range names[1:] {
+			if _, ok := fields[alias]; !ok {
+				fields[alias] = field
+			}
+		}
+	})
+
+	for name, value := range values {
+		field, ok := fields[strings.TrimLeft(name, "-")]
+		if !ok {
+			return nil, fmt.Errorf("unknown llama-server flag %q", name)
+		}
+		if err := setFlagField(field, value); err != nil {
+			return nil, fmt.Errorf("invalid value for llama-server flag %q: %w", name, err)
+		}
+	}
+
+	return opts, nil
+}
+
+// walkFlagTags calls fn with the flag names of every tagged field, descending into nested option groups.
+func walkFlagTags(v reflect.Value, fn func(names []string, field reflect.Value)) {
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		field := v.Field(i)
+		tag, ok := t.Field(i).Tag.Lookup("flag")
+		if !ok {
+			if field.Kind() == reflect.Struct {
+				walkFlagTags(field, fn)
+			}
+			continue
+		}
+		fn(flagNames(tag), field)
+	}
+}
+
+// setFlagField assigns a YAML-decoded value to an option field.
+func setFlagField(field reflect.Value, value interface{}) error {
+	switch field.Kind() {
+	case reflect.Bool:
+		b, ok := value.(bool)
+		if !ok {
+			return fmt.Errorf("expected a boolean, got %T", value)
+		}
+		field.SetBool(b)
+		return nil
+
+	case reflect.Ptr:
+		elem := reflect.New(field.Type().Elem())
+		if err := setScalar(elem.Elem(), value); err != nil {
+			return err
+		}
+		field.Set(elem)
+		return nil
+
+	case reflect.Slice:
+		items, ok := value.([]interface{})
+		if !ok {
+			items = []interface{}{value}
+		}
+		slice := reflect.MakeSlice(field.Type(), len(items), len(items))
+		for i, item := range items {
+			if err := setScalar(slice.Index(i), item); err != nil {
+				return err
+			}
+		}
+		field.Set(slice)
+		return nil
+	}
+
+	return fmt.Errorf("unsupported field type %s", field.Type())
+}
+
+// setScalar assigns a YAML-decoded scalar to a string, int, float64 or bool value.
+func setScalar(v reflect.Value, value interface{}) error {
+	switch v.Kind() {
+	case reflect.String:
+		v.SetString(fmt.Sprint(value))
+	case reflect.Int:
+		switch n := value.(type) {
+		case int:
+			v.SetInt(int64(n))
+		case int64:
+			v.SetInt(n)
+		case float64:
+			if n != float64(int64(n)) {
+				return fmt.Errorf("expected an integer, got %v", n)
+			}
+			v.SetInt(int64(n))
+		default:
+			return fmt.Errorf("expected an integer, got %T", value)
+		}
+	case reflect.Float64:
+		switch n := value.(type) {
+		case int:
+			v.SetFloat(float64(n))
+		case int64:
+			v.SetFloat(float64(n))
+		case float64:
+			v.SetFloat(n)
+		default:
+			return fmt.Errorf("expected a number, got %T", value)
+		}
+	case reflect.Bool:
+		b, ok := value.(bool)
+		if !ok {
+			return fmt.Errorf("expected a boolean, got %T", value)
+		}
+		v.SetBool(b)
+	default:
+		return fmt.Errorf("unsupported value type %s", v.Type())
+	}
+	return nil
+}
@@ -0,0 +1,68 @@
+// gguf_test.go
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGGUFOptionsArgs(t *testing.T) {
+	ctxSize := 8192
+	temp := 0.7
+	model := "models/test.gguf"
+	cacheType := "q8_0"
+
+	opts := &GGUFOptions{}
+	opts.General.CtxSize = &ctxSize
+	opts.General.FlashAttn = true
+	opts.Sampling.Temp = &temp
+	opts.Context.CacheTypeK = &cacheType
+	opts.Model.Model = &model
+	opts.Model.LoraScaled = []string{"a.gguf", "b.gguf"}
+
+	assert.Equal(t, []string{
+		"--ctx-size", "8192",
+		"--flash-attn",
+		"--temp", "0.7",
+		"--cache-type-k", "q8_0",
+		"--lora-scaled", "a.gguf",
+		"--lora-scaled", "b.gguf",
+		"--model", "models/test.gguf",
+	}, opts.Args())
+}
+
+func TestParseGGUFOptions(t *testing.T) {
+	opts, err := ParseGGUFOptions(map[string]interface{}{
+		"ctx-size":     32768,
+		"fa":           true,
+		"cache-type-v": "q4_0",
+		"temp":         1,
+		"ngl":          40,
+		"lora-scaled":  []interface{}{"a.gguf"},
+	})
+	require.NoError(t, err)
+
+	require.NotNil(t, opts.General.CtxSize)
+	assert.Equal(t, 32768, *opts.General.CtxSize)
+	assert.True(t, opts.General.FlashAttn)
+	require.NotNil(t, opts.Context.CacheTypeV)
+	assert.Equal(t, "q4_0", *opts.Context.CacheTypeV)
+	require.NotNil(t, opts.Sampling.Temp)
+	assert.Equal(t, 1.0, *opts.Sampling.Temp)
+	require.NotNil(t, opts.Model.GpuLayers)
+	assert.Equal(t, 40, *opts.Model.GpuLayers)
+	assert.Equal(t, []string{"a.gguf"}, opts.Model.LoraScaled)
+}
+
+func TestParseGGUFOptionsErrors(t *testing.T) {
+	_, err := ParseGGUFOptions(map[string]interface{}{"not-a-flag": 1})
+	assert.Error(t, err)
+
+	_, err = ParseGGUFOptions(map[string]interface{}{"ctx-size": "large"})
+	assert.Error(t, err)
+
+	_, err = ParseGGUFOptions(map[string]interface{}{"flash-attn": "yes"})
+	assert.Error(t, err)
+}
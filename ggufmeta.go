@@ -0,0 +1,287 @@
+// manifold/ggufmeta.go
+
+package main
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+)
+
+const ggufMagic = "GGUF"
+
+// maxGGUFStringLen guards against corrupt files declaring huge strings.
+const maxGGUFStringLen = 64 << 20
+
+// GGUF metadata value types.
+const (
+	ggufTypeUint8 uint32 = iota
+	ggufTypeInt8
+	ggufTypeUint16
+	ggufTypeInt16
+	ggufTypeUint32
+	ggufTypeInt32
+	ggufTypeFloat32
+	ggufTypeBool
+	ggufTypeString
+	ggufTypeArray
+	ggufTypeUint64
+	ggufTypeInt64
+	ggufTypeFloat64
+)
+
+// ggufFileTypes maps general.file_type to the quantization name used by llama.cpp.
+var ggufFileTypes = map[uint64]string{
+	0: "F32", 1: "F16", 2: "Q4_0", 3: "Q4_1", 7: "Q8_0", 8: "Q5_0", 9: "Q5_1",
+	10: "Q2_K", 11: "Q3_K_S", 12: "Q3_K_M", 13: "Q3_K_L", 14: "Q4_K_S", 15: "Q4_K_M",
+	16: "Q5_K_S", 17: "Q5_K_M", 18: "Q6_K", 19: "IQ2_XXS", 20: "IQ2_XS", 21: "Q2_K_S",
+	22: "IQ3_XS", 23: "IQ3_XXS", 24: "IQ1_S", 25: "IQ4_NL", 26: "IQ3_S", 27: "IQ3_M",
+	28: "IQ2_S", 29: "IQ2_M", 30: "IQ4_XS", 31: "IQ1_M", 32: "BF16",
+}
+
+// GGUFMetadata is the model information read from a GGUF file header.
+type GGUFMetadata struct {
+	Architecture   string
+	Name           string
+	ParameterCount int64
+	Quantization   string
+	ContextLength  int
+	BlockCount     int
+	ChatTemplate   string
+}
+
+// ggufReader decodes little-endian GGUF values. Version 1 files use 32-bit lengths and counts.
+type ggufReader struct {
+	r       *bufio.Reader
+	version uint32
+}
+
+// ReadGGUFMetadata reads the header of a GGUF file: the metadata key/values and the tensor shapes,
+// from which the parameter count is computed. Tensor data is not read.
+func ReadGGUFMetadata(path string) (*GGUFMetadata, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	meta, err := parseGGUFMetadata(f)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read gguf metadata from %s: %w", path, err)
+	}
+	return meta, nil
+}
+
+// parseGGUFMetadata decodes a GGUF header from r.
+func parseGGUFMetadata(r io.Reader) (*GGUFMetadata, error) {
+	gr := &ggufReader{r: bufio.NewReaderSize(r, 1<<20)}
+
+	magic := make([]byte, 4)
+	if _, err := io.ReadFull(gr.r, magic); err != nil {
+		return nil, err
+	}
+	if string(magic) != ggufMagic {
+		return nil, errors.New("not a gguf file")
+	}
+
+	if err := binary.Read(gr.r, binary.LittleEndian, &gr.version); err != nil {
+		return nil, err
+	}
+	if gr.version < 1 || gr.version > 3 {
+		return nil, fmt.Errorf("unsupported gguf version %d", gr.version)
+	}
+
+	tensorCount, err := gr.count()
+	if err != nil {
+		return nil, err
+	}
+	kvCount, err := gr.count()
+	if err != nil {
+		return nil, err
+	}
+
+	kv := make(map[string]interface{}, kvCount)
+	for i := uint64(0); i < kvCount; i++ {
+		key, err := gr.string()
+		if err != nil {
+			return nil, err
+		}
+		var valueType uint32
+		if err := binary.Read(gr.r, binary.LittleEndian, &valueType); err != nil {
+			return nil, err
+		}
+		value, err := gr.value(valueType)
+		if err != nil {
+			return nil, fmt.Errorf("key %s: %w", key, err)
+		}
+		kv[key] = value
+	}
+
+	meta := &GGUFMetadata{}
+	meta.Architecture, _ = kv["general.architecture"].(string)
+	meta.Name, _ = kv["general.name"].(string)
+	meta.ChatTemplate, _ = kv["tokenizer.chat_template"].(string)
+	if fileType, ok := ggufUint(kv["general.file_type"]); ok {
+		meta.Quantization = ggufFileTypes[fileType]
+	}
+	if n, ok := ggufUint(kv[meta.Architecture+".context_length"]); ok {
+		meta.ContextLength = int(n)
+	}
+	if n, ok := ggufUint(kv[meta.Architecture+".block_count"]); ok {
+		meta.BlockCount = int(n)
+	}
+
+	// Sum the elements of every tensor to get the parameter count
+	for i := uint64(0); i < tensorCount; i++ {
+		if _, err := gr.string(); err != nil {
+			return nil, err
+		}
+		var dims uint32
+		if err := binary.Read(gr.r, binary.LittleEndian, &dims); err != nil {
+			return nil, err
+		}
+		elements := int64(1)
+		for d := uint32(0); d < dims; d++ {
+			n, err := gr.count()
+			if err != nil {
+				return nil, err
+			}
+			elements *= int64(n)
+		}
+		// tensor type and data offset
+		if _, err := gr.r.Discard(12); err != nil {
+			return nil, err
+		}
+		meta.ParameterCount += elements
+	}
+
+	return meta, nil
+}
+
+// count reads a length or count, 32-bit in version 1 and 64-bit afterwards.
+func (gr *ggufReader) count() (uint64, error) {
+	if gr.version == 1 {
+		var n uint32
+		err := binary.Read(gr.r, binary.LittleEndian, &n)
+		return uint64(n), err
+	}
+	var n uint64
+	err := binary.Read(gr.r, binary.LittleEndian, &n)
+	return n, err
+}
+
+// string reads a length-prefixed string.
+func (gr *ggufReader) string() (string, error) {
+	n, err := gr.count()
+	if err != nil {
+		return "", err
+	}
+	if n > maxGGUFStringLen {
+		return "", fmt.Errorf("string of %d bytes exceeds limit", n)
+	}
+	buf := make([]byte, n)
+	if _, err := io.ReadFull(gr.r, buf); err != nil {
+		return "", err
+	}
+	return string(buf), nil
+}
+
+// value reads a metadata value of the given type. Arrays are skipped, since none of the keys
+// manifold uses are arrays and tokenizer vocabularies are large.
+func (gr *ggufReader) value(valueType uint32) (interface{}, error) {
+	switch valueType {
+	case ggufTypeUint8, ggufTypeInt8, ggufTypeBool:
+		b, err := gr.r.ReadByte()
+		if valueType == ggufTypeInt8 {
+			return int64(int8(b)), err
+		}
+		return uint64(b), err
+	case ggufTypeUint16, ggufTypeInt16:
+		var v uint16
+		err := binary.Read(gr.r, binary.LittleEndian, &v)
+		if valueType == ggufTypeInt16 {
+			return int64(int16(v)), err
+		}
+		return uint64(v), err
+	case ggufTypeUint32, ggufTypeInt32:
+		var v uint32
+		err := binary.Read(gr.r, binary.LittleEndian, &v)
+		if valueType == ggufTypeInt32 {
+			return int64(int32(v)), err
+		}
+		return uint64(v), err
+	case ggufTypeUint64, ggufTypeInt64:
+		var v uint64
+		err := binary.Read(gr.r, binary.LittleEndian, &v)
+		if valueType == ggufTypeInt64 {
+			return int64(v), err
+		}
+		return v, err
+	case ggufTypeFloat32:
+		var v uint32
+		err := binary.Read(gr.r, binary.LittleEndian, &v)
+		return float64(math.Float32frombits(v)), err
+	case ggufTypeFloat64:
+		var v uint64
+		err := binary.Read(gr.r, binary.LittleEndian, &v)
+		return math.Float64frombits(v), err
+	case ggufTypeString:
+		return gr.string()
+	case ggufTypeArray:
+		return nil, gr.skipArray()
+	default:
+		return nil, fmt.Errorf("unknown value type %d", valueType)
+	}
+}
+
+// skipArray discards an array value.
+func (gr *ggufReader) skipArray() error {
+	var elemType uint32
+	if err := binary.Read(gr.r, binary.LittleEndian, &elemType); err != nil {
+		return err
+	}
+	n, err := gr.count()
+	if err != nil {
+		return err
+	}
+
+	var size int
+	switch elemType {
+	case ggufTypeUint8, ggufTypeInt8, ggufTypeBool:
+		size = 1
+	case ggufTypeUint16, ggufTypeInt16:
+		size = 2
+	case ggufTypeUint32, ggufTypeInt32, ggufTypeFloat32:
+		size = 4
+	case ggufTypeUint64, ggufTypeInt64, ggufTypeFloat64:
+		size = 8
+	}
+	if size > 0 {
+		_, err := io.CopyN(io.Discard, gr.r, int64(n)*int64(size))
+		return err
+	}
+
+	for i := uint64(0); i < n; i++ {
+		if _, err := gr.value(elemType); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ggufUint returns an unsigned integer metadata value.
+func ggufUint(v interface{}) (uint64, bool) {
+	switch n := v.(type) {
+	case uint64:
+		return n, true
+	case int64:
+		if n >= 0 {
+			return uint64(n), true
+		}
+	}
+	return 0, false
+}
@@ -0,0 +1,88 @@
+// ggufmeta_test.go
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// ggufWriter builds GGUF v3 headers for tests.
+type ggufWriter struct{ bytes.Buffer }
+
+func (w *ggufWriter) put(v interface{}) { binary.Write(&w.Buffer, binary.LittleEndian, v) }
+
+func (w *ggufWriter) str(s string) {
+	w.put(uint64(len(s)))
+	w.WriteString(s)
+}
+
+func TestParseGGUFMetadata(t *testing.T) {
+	w := &ggufWriter{}
+	w.WriteString("GGUF")
+	w.put(uint32(3))
+	w.put(uint64(2)) // tensors
+	w.put(uint64(6)) // key/values
+
+	w.str("general.architecture")
+	w.put(ggufTypeString)
+	w.str("llama")
+
+	w.str("general.file_type")
+	w.put(ggufTypeUint32)
+	w.put(uint32(15))
+
+	w.str("llama.context_length")
+	w.put(ggufTypeUint32)
+	w.put(uint32(131072))
+
+	w.str("llama.block_count")
+	w.put(ggufTypeUint32)
+	w.put(uint32(32))
+
+	w.str("tokenizer.ggml.tokens")
+	w.put(ggufTypeArray)
+	w.put(ggufTypeString)
+	w.put(uint64(2))
+	w.str("<s>")
+	w.str("</s>")
+
+	w.str("tokenizer.chat_template")
+	w.put(ggufTypeString)
+	w.str("{{ messages }}")
+
+	w.str("token_embd.weight")
+	w.put(uint32(2))
+	w.put(uint64(4096))
+	w.put(uint64(1000))
+	w.put(uint32(12))
+	w.put(uint64(0))
+
+	w.str("output_norm.weight")
+	w.put(uint32(1))
+	w.put(uint64(4096))
+	w.put(uint32(0))
+	w.put(uint64(0))
+
+	meta, err := parseGGUFMetadata(&w.Buffer)
+	require.NoError(t, err)
+	assert.Equal(t, &GGUFMetadata{
+		Architecture:   "llama",
+		ParameterCount: 4096*1000 + 4096,
+		Quantization:   "Q4_K_M",
+		ContextLength:  131072,
+		BlockCount:     32,
+		ChatTemplate:   "{{ messages }}",
+	}, meta)
+}
+
+func TestParseGGUFMetadataInvalid(t *testing.T) {
+	_, err := parseGGUFMetadata(bytes.NewReader([]byte("GGML\x03\x00\x00\x00")))
+	assert.Error(t, err)
+
+	_, err = parseGGUFMetadata(bytes.NewReader([]byte("GGUF\x09\x00\x00\x00")))
+	assert.Error(t, err)
+}
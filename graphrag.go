@@ -0,0 +1,270 @@
+// manifold/graphrag.go
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/blevesearch/bleve/v2"
+
+	"manifold/internal/documents"
+	"manifold/internal/edata"
+	"manifold/internal/ingest"
+)
+
+const (
+	defaultGraphRAGHops      = 2
+	defaultGraphRAGMaxChunks = 5
+	defaultGraphRAGTimeout   = 60 * time.Second
+	graphRAGMaxQueryWords    = 4 // longest entity names looked up in queries
+)
+
+// GraphRAGConfig asks an OpenAI compatible model for the entities and relations of the chunks of the
+// documents stored, kept in the edata graph. Retrieval then adds the chunks mentioning the
+// entities named in the query and those related to them, for questions spanning documents.
+type GraphRAGConfig struct {
+	Enabled        bool   `yaml:"enabled"`
+	Endpoint       string `yaml:"endpoint,omitempty"`
+	Model          string `yaml:"model,omitempty"`
+	APIKey         string `yaml:"api_key,omitempty" json:"-"`
+	Hops           int    `yaml:"hops,omitempty"`            // relations followed from the entities of a query, 2 when unset
+	MaxChunks      int    `yaml:"max_chunks,omitempty"`      // chunks added to the results, 5 when unset
+	TimeoutSeconds int    `yaml:"timeout_seconds,omitempty"` // of each extraction, 60 when unset
+}
+
+// graphExtractor extracts the entities and relations of chunks, nil unless GraphRAG is enabled.
+var graphExtractor *GraphExtractor
+
+// GraphExtractor extracts entities and relations from chunks with a model.
+type GraphExtractor struct {
+	client  LLMClient
+	model   string
+	timeout time.Duration
+	mu      sync.Mutex // one document batch extracted at a time
+}
+
+// NewGraphExtractor creates an extractor of the configured model.
+func NewGraphExtractor(config GraphRAGConfig) (*GraphExtractor, error) {
+	if config.Endpoint == "" {
+		return nil, errors.New("graph_rag requires an endpoint")
+	}
+	timeout := defaultGraphRAGTimeout
+	if config.TimeoutSeconds > 0 {
+		timeout = time.Duration(config.TimeoutSeconds) * time.Second
+	}
+	return &GraphExtractor{
+		client:  NewLocalLLMClient(config.Endpoint, config.Model, config.APIKey),
+		model:   config.Model,
+		timeout: timeout,
+	}, nil
+}
+
+// graphExtraction is the reply of the model to an extraction.
+type graphExtraction struct {
+	Entities  []edata.ExtractedEntity   `json:"entities"`
+	Relations []edata.ExtractedRelation `json:"relations"`
+}
+
+// Extract asks the model for the entities of text and the relations between them. Entities not in
+// the text, which the model made up, are dropped with their relations.
+func (g *GraphExtractor) Extract(ctx context.Context, text string) ([]edata.ExtractedEntity, []edata.ExtractedRelation, error) {
+	ctx, cancel := context.WithTimeout(ctx, g.timeout)
+	defer cancel()
+
+	payload := &CompletionRequest{
+		Model: g.model,
+		Messages: []Message{
+			{Role: "system", Content: "You extract the named entities of the user's text, such as people, organizations, places, " +
+				"products and concepts, and the relationships the text states between them. Reply with a JSON object like " +
+				`{"entities": [{"name": "...", "type": "..."}], "relations": [{"from": "...", "type": "...", "to": "..."}]}, ` +
+				"with the names exactly as they are written in the text and relation types as short snake_case verbs such as " +
+				"founded or located_in. Reply with the JSON object only."},
+			{Role: "user", Content: text},
+		},
+		Temperature: 0,
+		MaxTokens:   2048,
+	}
+
+	resp, err := g.client.SendCompletionRequest(ctx, payload)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer resp.Body.Close()
+
+	var completion CompletionResponse
+	if err := json.NewDecoder(resp.Body).Decode(&completion); err != nil {
+		return nil, nil, fmt.Errorf("invalid extraction response: %w", err)
+	}
+	if len(completion.Choices) == 0 {
+		return nil, nil, errors.New("extraction returned no choices")
+	}
+
+	reply := completion.Choices[0].Message.Content
+	object, ok := extractJSON(reply, false)
+	if !ok {
+		return nil, nil, fmt.Errorf("extraction reply has no entities: %s", truncateForLog(reply, 100))
+	}
+	var extraction graphExtraction
+	if err := json.Unmarshal([]byte(object), &extraction); err != nil {
+		return nil, nil, fmt.Errorf("extraction reply has invalid entities: %w", err)
+	}
+
+	normalized := " " + edata.EntityKey(text) + " "
+	inText := func(name string) bool {
+		key := edata.EntityKey(name)
+		return key != "" && strings.Contains(normalized, " "+key+" ")
+	}
+	var entities []edata.ExtractedEntity
+	for _, entity := range extraction.Entities {
+		if inText(entity.Name) {
+			entities = append(entities, entity)
+		}
+	}
+	var relations []edata.ExtractedRelation
+	for _, relation := range extraction.Relations {
+		if inText(relation.From) && inText(relation.To) && relation.Type != "" {
+			relations = append(relations, relation)
+		}
+	}
+	return entities, relations, nil
+}
+
+// ExtractDocuments extracts the entities and relations of the chunks of the documents stored into
+// the edata graph, the documents of the edata store in the order of the results. Chunks failing are
+// logged and skipped.
+func (g *GraphExtractor) ExtractDocuments(ctx context.Context, im *documents.IndexManager, results []ingest.StoreResult, docs []edata.Document) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	logger := loggerFromContext(ctx)
+	for i, result := range results {
+		ids := make([]string, result.Chunks)
+		for j := range ids {
+			ids[j] = fmt.Sprintf("%s-%d", result.ID, j)
+		}
+		chunks, err := chunksByID(ctx, im, ids)
+		if err != nil {
+			logger.Error("failed to read chunks for graph extraction", "document", result.ID, "error", err)
+			continue
+		}
+		for _, chunk := range chunks {
+			entities, relations, err := g.Extract(ctx, chunk.Text)
+			if err != nil {
+				logger.Error("failed to extract entities", "chunk", chunk.ID, "error", err)
+				continue
+			}
+			if err := edata.SaveChunkEntities(docs[i].ID, chunk.ID, entities, relations); err != nil {
+				logger.Error("failed to save entities", "chunk", chunk.ID, "error", err)
+			}
+		}
+		logger.Debug("extracted document graph", "document", result.ID, "chunks", len(chunks))
+	}
+}
+
+// chunksByID returns the indexed chunks of the IDs in their order, skipping those not indexed.
+func chunksByID(ctx context.Context, im *documents.IndexManager, ids []string) ([]retrievedChunk, error) {
+	if len(ids) == 0 {
+		return nil, nil
+	}
+	request := bleve.NewSearchRequestOptions(bleve.NewDocIDQuery(ids), len(ids), 0, false)
+	request.Fields = []string{"file_path", "chunk", "full_content", "page", "section"}
+	result, err := im.Index.SearchInContext(ctx, request)
+	if err != nil {
+		return nil, err
+	}
+	found := make(map[string]retrievedChunk, len(result.Hits))
+	for _, hit := range result.Hits {
+		found[hit.ID] = chunkFromFields(hit.ID, hit.Fields)
+	}
+	chunks := make([]retrievedChunk, 0, len(found))
+	for _, id := range ids {
+		if chunk, ok := found[id]; ok {
+			chunks = append(chunks, chunk)
+		}
+	}
+	return chunks, nil
+}
+
+// queryEntityNames returns the word sequences of a query that may name an entity.
+func queryEntityNames(query string) []string {
+	words := strings.Fields(edata.EntityKey(query))
+	var names []string
+	for i := range words {
+		for j := i + 1; j <= min(i+graphRAGMaxQueryWords, len(words)); j++ {
+			names = append(names, strings.Join(words[i:j], " "))
+		}
+	}
+	return names
+}
+
+// graphRetriever adds to the chunks of another retriever those mentioning the entities named in the
+// query or related to them in the edata graph.
+type graphRetriever struct {
+	base      Retriever
+	index     *documents.IndexManager
+	hops      int
+	maxChunks int
+}
+
+// newGraphRetriever wraps a retriever with the graph expansion of the config.
+func newGraphRetriever(base Retriever, im *documents.IndexManager, config GraphRAGConfig) *graphRetriever {
+	r := &graphRetriever{base: base, index: im, hops: config.Hops, maxChunks: config.MaxChunks}
+	if r.hops <= 0 {
+		r.hops = defaultGraphRAGHops
+	}
+	if r.maxChunks <= 0 {
+		r.maxChunks = defaultGraphRAGMaxChunks
+	}
+	return r
+}
+
+func (r *graphRetriever) Retrieve(ctx context.Context, query string, n int) ([]retrievedChunk, error) {
+	chunks, err := r.base.Retrieve(ctx, query, n)
+	if err != nil {
+		return nil, err
+	}
+	related, err := r.relatedChunks(ctx, query, chunks)
+	if err != nil {
+		// The chunks retrieved are still relevant without the graph
+		loggerFromContext(ctx).Error("failed to expand retrieval through the graph", "error", err)
+		return chunks, nil
+	}
+	return append(chunks, related...), nil
+}
+
+// relatedChunks returns the chunks of the entities of the query and those related to them, but the
+// chunks already retrieved.
+func (r *graphRetriever) relatedChunks(ctx context.Context, query string, retrieved []retrievedChunk) ([]retrievedChunk, error) {
+	entities, err := edata.FindEntities(queryEntityNames(query))
+	if err != nil || len(entities) == 0 {
+		return nil, err
+	}
+	ids := make([]uint, len(entities))
+	for i, entity := range entities {
+		ids[i] = entity.ID
+	}
+	if ids, err = edata.ExpandEntities(ids, r.hops); err != nil {
+		return nil, err
+	}
+	candidates, err := edata.EntityChunks(ids, r.maxChunks+len(retrieved))
+	if err != nil {
+		return nil, err
+	}
+
+	seen := make(map[string]bool, len(retrieved))
+	for _, chunk := range retrieved {
+		seen[chunk.ID] = true
+	}
+	var missing []string
+	for _, id := range candidates {
+		if !seen[id] && len(missing) < r.maxChunks {
+			missing = append(missing, id)
+		}
+	}
+	return chunksByID(ctx, r.index, missing)
+}
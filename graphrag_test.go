@@ -0,0 +1,93 @@
+// graphrag_test.go
+package main
+
+import (
+	"context"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"manifold/internal/documents"
+	"manifold/internal/edata"
+	"manifold/internal/ingest"
+)
+
+func TestGraphRAG(t *testing.T) {
+	initTestEData(t)
+
+	replies := map[string]string{
+		"Ada Lovelace": `{"entities": [{"name": "Ada Lovelace", "type": "person"}, {"name": "Charles Babbage", "type": "person"}, {"name": "Alan Turing", "type": "person"}],
+			"relations": [{"from": "Ada Lovelace", "type": "worked_with", "to": "Charles Babbage"}, {"from": "Alan Turing", "type": "admired", "to": "Ada Lovelace"}]}`,
+		"Analytical Engine": "Sure! ```json\n" + `{"entities": [{"name": "Charles Babbage", "type": "person"}, {"name": "analytical engine", "type": "machine"}],
+			"relations": [{"from": "Charles Babbage", "type": "designed", "to": "Analytical Engine"}]}` + "\n```",
+		"Jacquard": `{"entities": [{"name": "Jacquard loom", "type": "machine"}], "relations": []}`,
+	}
+	server := completionServer(t, func(request CompletionRequest) (string, error) {
+		for marker, content := range replies {
+			if strings.Contains(request.Messages[1].Content, marker) {
+				return content, nil
+			}
+		}
+		return "no entities", nil
+	})
+
+	extractor, err := NewGraphExtractor(GraphRAGConfig{Enabled: true, Endpoint: server.URL})
+	require.NoError(t, err)
+	_, err = NewGraphExtractor(GraphRAGConfig{Enabled: true})
+	assert.Error(t, err)
+
+	// Made-up entities are dropped with their relations
+	ctx := context.Background()
+	entities, relations, err := extractor.Extract(ctx, "Ada Lovelace worked with Charles Babbage.")
+	require.NoError(t, err)
+	assert.Len(t, entities, 2)
+	assert.Equal(t, []edata.ExtractedRelation{{From: "Ada Lovelace", Type: "worked_with", To: "Charles Babbage"}}, relations)
+	_, _, err = extractor.Extract(ctx, "Unrelated text.")
+	assert.Error(t, err)
+
+	index, err := documents.NewIndexManager(filepath.Join(t.TempDir(), "index"))
+	require.NoError(t, err)
+	defer index.Index.Close()
+	texts := map[string]string{
+		"ada":      "Ada Lovelace worked with Charles Babbage.",
+		"engine":   "Charles Babbage designed the Analytical Engine, programmed with punched cards.",
+		"jacquard": "The Jacquard loom wove patterns from punched cards.",
+	}
+	var results []ingest.StoreResult
+	var sources []edata.SourceDocument
+	for _, id := range []string{"ada", "engine", "jacquard"} {
+		require.NoError(t, index.IndexDocumentChunk(id+"-0", texts[id], id+".md"))
+		results = append(results, ingest.StoreResult{ID: id, Chunks: 1})
+		sources = append(sources, edata.SourceDocument{Source: id, Text: texts[id]})
+	}
+	docs, err := edata.SaveSourceDocuments(edata.KindDocument, sources)
+	require.NoError(t, err)
+	extractor.ExtractDocuments(ctx, index, results, docs)
+
+	found, err := edata.FindEntities([]string{"charles babbage", "ANALYTICAL ENGINE.", "alan turing"})
+	require.NoError(t, err)
+	require.Len(t, found, 2)
+
+	// Retrieval adds the chunks of the entities of the query, and of those related to them
+	r := newGraphRetriever(&ftsRetriever{index: index}, index, GraphRAGConfig{Hops: 2})
+	chunks, err := r.Retrieve(ctx, "Who worked with Ada Lovelace?", 1)
+	require.NoError(t, err)
+	require.Len(t, chunks, 2)
+	assert.Equal(t, "ada-0", chunks[0].ID)
+	assert.Equal(t, "engine-0", chunks[1].ID)
+	assert.Equal(t, "engine.md", chunks[1].Path)
+
+	// Without related entities in reach, no chunk is added
+	r.hops = 0
+	chunks, err = r.Retrieve(ctx, "Who worked with Ada Lovelace?", 1)
+	require.NoError(t, err)
+	assert.Len(t, chunks, 1)
+	chunks, err = r.Retrieve(ctx, "punched cards", 5)
+	require.NoError(t, err)
+	assert.Len(t, chunks, 2)
+
+	assert.Equal(t, []string{"ada", "ada lovelace", "ada lovelace s", "lovelace", "lovelace s", "s"}, queryEntityNames("Ada Lovelace's"))
+}
@@ -0,0 +1,436 @@
+// manifold/guardrails.go
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html"
+	"io"
+	"net/http"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/labstack/echo/v4"
+)
+
+const (
+	GuardrailPrompt   = "prompt"
+	GuardrailResponse = "response"
+
+	GuardrailActionBlock  = "block"
+	GuardrailActionRedact = "redact"
+
+	defaultGuardrailReplacement  = "[redacted]"
+	defaultGuardrailBlockMessage = "This request was blocked by the content policy."
+	defaultModerationTimeout     = 10 * time.Second
+	defaultOpenAIModerationURL   = "https://api.openai.com/v1"
+	guardrailExcerptBytes        = 200
+)
+
+// GuardrailRule is a regular expression that blocks or redacts prompts, responses or both.
+type GuardrailRule struct {
+	Name        string `yaml:"name" json:"name"`
+	Pattern     string `yaml:"pattern" json:"pattern"`
+	Action      string `yaml:"action,omitempty" json:"action,omitempty"`           // block or redact, block when empty
+	Replacement string `yaml:"replacement,omitempty" json:"replacement,omitempty"` // redacted text, [redacted] when empty
+	Apply       string `yaml:"apply,omitempty" json:"apply,omitempty"`             // prompt or response, both when empty
+}
+
+// ModerationConfig selects a moderation model that checks whatever passed the rules.
+type ModerationConfig struct {
+	Provider       string `yaml:"provider,omitempty"` // openai or local, no moderation when empty
+	Endpoint       string `yaml:"endpoint,omitempty"` // base URL, the OpenAI API for openai, an OpenAI compatible chat server for local
+	Model          string `yaml:"model,omitempty"`
+	APIKey         string `yaml:"api_key,omitempty" json:"-"` // openai_api_key when empty
+	TimeoutSeconds int    `yaml:"timeout_seconds,omitempty"`  // 10 when unset
+	FailClosed     bool   `yaml:"fail_closed,omitempty"`      // block when the moderation call fails
+}
+
+// GuardrailsConfig filters prompts before they reach the model and responses before they reach
+// the user.
+type GuardrailsConfig struct {
+	Enabled      bool             `yaml:"enabled"`
+	Rules        []GuardrailRule  `yaml:"rules,omitempty"`
+	DenyTopics   []string         `yaml:"deny_topics,omitempty"`   // words and phrases that block a prompt or response
+	BlockMessage string           `yaml:"block_message,omitempty"` // shown instead of a blocked message
+	Moderation   ModerationConfig `yaml:"moderation,omitempty"`
+}
+
+// GuardrailEvent records a prompt or response that was blocked or redacted.
+type GuardrailEvent struct {
+	ID        uint      `gorm:"primaryKey" json:"id"`
+	SessionID string    `gorm:"index" json:"session_id"`
+	TurnID    string    `gorm:"index" json:"turn_id"`
+	Stage     string    `gorm:"index" json:"stage"` // prompt or response
+	Action    string    `json:"action"`             // block or redact
+	Rule      string    `json:"rule"`               // the rule, topic or moderation category
+	Excerpt   string    `json:"excerpt"`            // the start of the text, redacted when the action is redact
+	CreatedAt time.Time `gorm:"index" json:"created_at"`
+}
+
+// GuardrailResult is the outcome of a check. Text is the redacted text.
+type GuardrailResult struct {
+	Text     string
+	Blocked  bool
+	Reason   string   // what blocked the text
+	Redacted []string // the rules that redacted parts of the text
+}
+
+// compiledRule is a guardrail rule with its pattern compiled.
+type compiledRule struct {
+	GuardrailRule
+	re *regexp.Regexp
+}
+
+// Guardrails checks text against the configured rules, topics and moderation model.
+type Guardrails struct {
+	rules        []compiledRule
+	topics       *regexp.Regexp // nil without deny topics
+	blockMessage string
+	moderation   ModerationConfig
+	client       LLMClient // the local moderation model
+	httpClient   *http.Client
+}
+
+// NewGuardrails compiles the guardrails configuration.
+func NewGuardrails(config GuardrailsConfig, openAIKey string) (*Guardrails, error) {
+	g := &Guardrails{
+		blockMessage: config.BlockMessage,
+		moderation:   config.Moderation,
+		httpClient:   &http.Client{},
+	}
+	if g.blockMessage == "" {
+		g.blockMessage = defaultGuardrailBlockMessage
+	}
+
+	for _, rule := range config.Rules {
+		if rule.Name == "" {
+			rule.Name = rule.Pattern
+		}
+		switch rule.Action {
+		case "":
+			rule.Action = GuardrailActionBlock
+		case GuardrailActionBlock, GuardrailActionRedact:
+		default:
+			return nil, fmt.Errorf("guardrail rule %q: unknown action %q", rule.Name, rule.Action)
+		}
+		switch rule.Apply {
+		case "", GuardrailPrompt, GuardrailResponse:
+		default:
+			return nil, fmt.Errorf("guardrail rule %q: apply must be prompt or response", rule.Name)
+		}
+		if rule.Replacement == "" {
+			rule.Replacement = defaultGuardrailReplacement
+		}
+		re, err := regexp.Compile(rule.Pattern)
+		if err != nil {
+			return nil, fmt.Errorf("guardrail rule %q: %w", rule.Name, err)
+		}
+		g.rules = append(g.rules, compiledRule{GuardrailRule: rule, re: re})
+	}
+
+	if len(config.DenyTopics) > 0 {
+		quoted := make([]string, len(config.DenyTopics))
+		for i, topic := range config.DenyTopics {
+			quoted[i] = regexp.QuoteMeta(strings.TrimSpace(topic))
+		}
+		g.topics = regexp.MustCompile(`(?i)\b(` + strings.Join(quoted, "|") + `)\b`)
+	}
+
+	switch config.Moderation.Provider {
+	case "":
+	case "openai":
+		if g.moderation.Endpoint == "" {
+			g.moderation.Endpoint = defaultOpenAIModerationURL
+		}
+		if g.moderation.APIKey == "" {
+			g.moderation.APIKey = openAIKey
+		}
+		if g.moderation.APIKey == "" {
+			return nil, errors.New("openai moderation needs an api_key or openai_api_key")
+		}
+	case "local":
+		if config.Moderation.Endpoint == "" {
+			return nil, errors.New("local moderation needs an endpoint")
+		}
+		g.client = NewLocalLLMClient(config.Moderation.Endpoint, config.Moderation.Model, config.Moderation.APIKey)
+	default:
+		return nil, fmt.Errorf("unknown moderation provider %q", config.Moderation.Provider)
+	}
+	return g, nil
+}
+
+// BlockMessage is shown to the user instead of a blocked prompt or response.
+func (g *Guardrails) BlockMessage() string {
+	return g.blockMessage
+}
+
+// CheckRules applies the rules and deny topics of a stage. It is cheap enough to run on every
+// chunk of a streamed response.
+func (g *Guardrails) CheckRules(stage, text string) GuardrailResult {
+	result := GuardrailResult{Text: text}
+	if g.topics != nil {
+		if topic := g.topics.FindString(text); topic != "" {
+			return GuardrailResult{Blocked: true, Reason: "topic:" + strings.ToLower(topic)}
+		}
+	}
+	for _, rule := range g.rules {
+		if rule.Apply != "" && rule.Apply != stage {
+			continue
+		}
+		if !rule.re.MatchString(result.Text) {
+			continue
+		}
+		if rule.Action == GuardrailActionBlock {
+			return GuardrailResult{Blocked: true, Reason: rule.Name}
+		}
+		result.Text = rule.re.ReplaceAllString(result.Text, rule.Replacement)
+		result.Redacted = append(result.Redacted, rule.Name)
+	}
+	return result
+}
+
+// Check applies the rules and the moderation model to a prompt or response and records what was
+// blocked or redacted.
+func (g *Guardrails) Check(ctx context.Context, stage, text string) GuardrailResult {
+	span, ctx := startSpan(ctx, "guardrails.check")
+	span.SetTag("stage", stage)
+	defer span.Finish()
+
+	result := g.CheckRules(stage, text)
+	if !result.Blocked && g.moderation.Provider != "" {
+		category, err := g.moderate(ctx, result.Text)
+		switch {
+		case err != nil && g.moderation.FailClosed:
+			result = GuardrailResult{Blocked: true, Reason: "moderation unavailable"}
+		case err != nil:
+			loggerFromContext(ctx).Warn("moderation failed, allowing text", "stage", stage, "error", err)
+		case category != "":
+			result = GuardrailResult{Blocked: true, Reason: "moderation:" + category}
+		}
+	}
+
+	span.SetTag("blocked", result.Blocked)
+	if result.Blocked {
+		recordGuardrailEvent(ctx, stage, GuardrailActionBlock, result.Reason, text)
+	}
+	for _, rule := range result.Redacted {
+		recordGuardrailEvent(ctx, stage, GuardrailActionRedact, rule, result.Text)
+	}
+	return result
+}
+
+// moderate asks the moderation model about text. It returns the flagged category, or an empty
+// string when the text is fine.
+func (g *Guardrails) moderate(ctx context.Context, text string) (string, error) {
+	timeout := defaultModerationTimeout
+	if g.moderation.TimeoutSeconds > 0 {
+		timeout = time.Duration(g.moderation.TimeoutSeconds) * time.Second
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	if g.moderation.Provider == "local" {
+		return g.moderateLocal(ctx, text)
+	}
+	return g.moderateOpenAI(ctx, text)
+}
+
+// moderateOpenAI calls the OpenAI moderation endpoint.
+func (g *Guardrails) moderateOpenAI(ctx context.Context, text string) (string, error) {
+	body, err := json.Marshal(map[string]string{"input": text, "model": g.moderation.Model})
+	if err != nil {
+		return "", err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, strings.TrimSuffix(g.moderation.Endpoint, "/")+"/moderations", bytes.NewReader(body))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+g.moderation.APIKey)
+
+	resp, err := g.httpClient.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return "", fmt.Errorf("moderation failed with status %d: %s", resp.StatusCode, msg)
+	}
+
+	var moderation struct {
+		Results []struct {
+			Flagged    bool            `json:"flagged"`
+			Categories map[string]bool `json:"categories"`
+		} `json:"results"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&moderation); err != nil {
+		return "", fmt.Errorf("invalid moderation response: %w", err)
+	}
+	for _, result := range moderation.Results {
+		if !result.Flagged {
+			continue
+		}
+		var categories []string
+		for category, flagged := range result.Categories {
+			if flagged {
+				categories = append(categories, category)
+			}
+		}
+		if len(categories) == 0 {
+			return "flagged", nil
+		}
+		sort.Strings(categories)
+		return strings.Join(categories, ","), nil
+	}
+	return "", nil
+}
+
+// moderateLocal asks a local chat model to classify the text.
+func (g *Guardrails) moderateLocal(ctx context.Context, text string) (string, error) {
+	payload := &CompletionRequest{
+		Model: g.moderation.Model,
+		Messages: []Message{
+			{Role: "system", Content: "You are a content moderation classifier. Reply with SAFE if the user's text is acceptable, " +
+				"or with UNSAFE followed by a one word category such as violence, hate, sexual, self-harm or illegal. Reply with nothing else."},
+			{Role: "user", Content: text},
+		},
+		Temperature: 0,
+		MaxTokens:   16,
+	}
+
+	resp, err := g.client.SendCompletionRequest(ctx, payload)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	var completion CompletionResponse
+	if err := json.NewDecoder(resp.Body).Decode(&completion); err != nil {
+		return "", fmt.Errorf("invalid moderation response: %w", err)
+	}
+	if len(completion.Choices) == 0 {
+		return "", errors.New("moderation returned no choices")
+	}
+
+	verdict := strings.Fields(strings.ToLower(completion.Choices[0].Message.Content))
+	switch {
+	case len(verdict) == 0:
+		return "", errors.New("empty moderation verdict")
+	case strings.Trim(verdict[0], ".:") == "safe":
+		return "", nil
+	case strings.Trim(verdict[0], ".:") != "unsafe":
+		return "", fmt.Errorf("unexpected moderation verdict %q", truncateForLog(completion.Choices[0].Message.Content, 50))
+	case len(verdict) > 1:
+		return strings.Trim(verdict[1], ".:,"), nil
+	}
+	return "unsafe", nil
+}
+
+// checkStreamedResponse has the guardrails check a response once it is streamed. A blocked response
+// is replaced by the block message in the chat, and the buffer keeps only what the user may see.
+func checkStreamedResponse(ctx context.Context, c *websocket.Conn, chatID int, response *bytes.Buffer) error {
+	if guardrails == nil {
+		return nil
+	}
+
+	result := guardrails.Check(ctx, GuardrailResponse, response.String())
+	response.Reset()
+	if !result.Blocked {
+		response.WriteString(result.Text)
+		return nil
+	}
+	response.WriteString(guardrails.BlockMessage())
+	return c.WriteMessage(websocket.TextMessage, []byte(responseHTML(chatID, []byte(html.EscapeString(guardrails.BlockMessage())))))
+}
+
+// guardrails filters chat prompts and responses, nil when disabled.
+var guardrails *Guardrails
+
+// configureGuardrails creates the guardrails from the config.
+func configureGuardrails(config *Config) error {
+	if !config.Guardrails.Enabled {
+		guardrails = nil
+		return nil
+	}
+	g, err := NewGuardrails(config.Guardrails, config.OpenAIAPIKey)
+	if err != nil {
+		return err
+	}
+	guardrails = g
+	return nil
+}
+
+// recordGuardrailEvent logs and stores a blocked or redacted text of the current turn.
+func recordGuardrailEvent(ctx context.Context, stage, action, rule, text string) {
+	logger := loggerFromContext(ctx)
+	logger.Info("guardrail triggered", "stage", stage, "action", action, "rule", rule)
+	if db == nil {
+		return
+	}
+
+	turn := turnFromContext(ctx)
+	event := GuardrailEvent{
+		SessionID: turn.SessionID,
+		TurnID:    turn.TurnID,
+		Stage:     stage,
+		Action:    action,
+		Rule:      rule,
+	}
+	// A redacted text is stored without what was redacted, a blocked one only by its start
+	event.Excerpt = truncateForLog(text, guardrailExcerptBytes)
+	if err := db.Create(&event); err != nil {
+		logger.Warn("failed to record guardrail event", "error", err)
+	}
+}
+
+// GetGuardrailEvents returns the newest events matching the filter fields that are set.
+func (sqldb *SQLiteDB) GetGuardrailEvents(filter GuardrailEvent, limit int) ([]GuardrailEvent, error) {
+	query := sqldb.db.Model(&GuardrailEvent{})
+	if filter.SessionID != "" {
+		query = query.Where("session_id = ?", filter.SessionID)
+	}
+	if filter.Stage != "" {
+		query = query.Where("stage = ?", filter.Stage)
+	}
+	if filter.Action != "" {
+		query = query.Where("action = ?", filter.Action)
+	}
+
+	var events []GuardrailEvent
+	err := query.Order("id DESC").Limit(limit).Find(&events).Error
+	return events, err
+}
+
+// handleGetGuardrailEvents lists blocked and redacted prompts and responses, newest first,
+// filtered by session_id, stage and action.
+func handleGetGuardrailEvents(c echo.Context) error {
+	limit := defaultToolHistoryLimit
+	if v := c.QueryParam("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 {
+			return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid limit"})
+		}
+		limit = min(n, maxToolHistoryLimit)
+	}
+
+	filter := GuardrailEvent{
+		SessionID: c.QueryParam("session_id"),
+		Stage:     c.QueryParam("stage"),
+		Action:    c.QueryParam("action"),
+	}
+	events, err := db.GetGuardrailEvents(filter, limit)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to load guardrail events"})
+	}
+	return c.JSON(http.StatusOK, events)
+}
@@ -0,0 +1,121 @@
+// guardrails_test.go
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewGuardrailsValidation(t *testing.T) {
+	_, err := NewGuardrails(GuardrailsConfig{Rules: []GuardrailRule{{Name: "bad", Pattern: "("}}}, "")
+	assert.ErrorContains(t, err, `guardrail rule "bad"`)
+	_, err = NewGuardrails(GuardrailsConfig{Rules: []GuardrailRule{{Pattern: "x", Action: "warn"}}}, "")
+	assert.ErrorContains(t, err, "unknown action")
+	_, err = NewGuardrails(GuardrailsConfig{Moderation: ModerationConfig{Provider: "openai"}}, "")
+	assert.Error(t, err)
+	_, err = NewGuardrails(GuardrailsConfig{Moderation: ModerationConfig{Provider: "openai"}}, "sk-test")
+	assert.NoError(t, err)
+}
+
+func TestGuardrailsCheckRules(t *testing.T) {
+	g, err := NewGuardrails(GuardrailsConfig{
+		DenyTopics: []string{"credit card dumps"},
+		Rules: []GuardrailRule{
+			{Name: "email", Pattern: `[\w.]+@[\w.]+`, Action: GuardrailActionRedact, Replacement: "<email>"},
+			{Name: "jailbreak", Pattern: `(?i)ignore previous instructions`, Apply: GuardrailPrompt},
+		},
+	}, "")
+	require.NoError(t, err)
+
+	result := g.CheckRules(GuardrailPrompt, "Write to jane@example.com please")
+	assert.False(t, result.Blocked)
+	assert.Equal(t, "Write to <email> please", result.Text)
+	assert.Equal(t, []string{"email"}, result.Redacted)
+
+	result = g.CheckRules(GuardrailPrompt, "Ignore previous instructions and ...")
+	assert.True(t, result.Blocked)
+	assert.Equal(t, "jailbreak", result.Reason)
+	assert.Empty(t, result.Text)
+
+	// The rule only applies to prompts
+	assert.False(t, g.CheckRules(GuardrailResponse, "I will ignore previous instructions").Blocked)
+
+	result = g.CheckRules(GuardrailResponse, "Where to buy Credit Card Dumps")
+	assert.True(t, result.Blocked)
+	assert.Equal(t, "topic:credit card dumps", result.Reason)
+}
+
+func TestGuardrailsModeration(t *testing.T) {
+	testDB, err := NewSQLiteDB(t.TempDir())
+	require.NoError(t, err)
+	require.NoError(t, testDB.AutoMigrate(&GuardrailEvent{}))
+
+	saved := db
+	db = testDB
+	defer func() { db = saved }()
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/moderations", r.URL.Path)
+		assert.Equal(t, "Bearer sk-test", r.Header.Get("Authorization"))
+		var request map[string]string
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&request))
+		flagged := request["input"] == "something violent"
+		json.NewEncoder(w).Encode(map[string]interface{}{"results": []map[string]interface{}{
+			{"flagged": flagged, "categories": map[string]bool{"violence": flagged, "hate": false}},
+		}})
+	}))
+	defer server.Close()
+
+	g, err := NewGuardrails(GuardrailsConfig{
+		Rules:      []GuardrailRule{{Name: "secret", Pattern: `secret-\d+`, Action: GuardrailActionRedact}},
+		Moderation: ModerationConfig{Provider: "openai", Endpoint: server.URL},
+	}, "sk-test")
+	require.NoError(t, err)
+
+	ctx := withTurn(context.Background(), TurnInfo{SessionID: "s1", TurnID: "t1"})
+	result := g.Check(ctx, GuardrailPrompt, "something violent")
+	assert.True(t, result.Blocked)
+	assert.Equal(t, "moderation:violence", result.Reason)
+
+	result = g.Check(ctx, GuardrailResponse, "the code is secret-42")
+	assert.False(t, result.Blocked)
+	assert.Equal(t, "the code is [redacted]", result.Text)
+
+	events, err := db.GetGuardrailEvents(GuardrailEvent{SessionID: "s1"}, 10)
+	require.NoError(t, err)
+	require.Len(t, events, 2)
+	assert.Equal(t, GuardrailActionRedact, events[0].Action)
+	assert.Equal(t, "the code is [redacted]", events[0].Excerpt)
+	assert.Equal(t, GuardrailPrompt, events[1].Stage)
+	assert.Equal(t, GuardrailActionBlock, events[1].Action)
+}
+
+func TestGuardrailsLocalModeration(t *testing.T) {
+	verdict := "SAFE"
+	server := completionServer(t, func(CompletionRequest) (string, error) {
+		return verdict, nil
+	})
+
+	g, err := NewGuardrails(GuardrailsConfig{Moderation: ModerationConfig{Provider: "local", Endpoint: server.URL}}, "")
+	require.NoError(t, err)
+	ctx := context.Background()
+
+	assert.False(t, g.Check(ctx, GuardrailPrompt, "hello").Blocked)
+
+	verdict = "UNSAFE: self-harm."
+	result := g.Check(ctx, GuardrailPrompt, "hello")
+	assert.True(t, result.Blocked)
+	assert.Equal(t, "moderation:self-harm", result.Reason)
+
+	// An unclear verdict fails open unless fail_closed is set
+	verdict = "I cannot tell"
+	assert.False(t, g.Check(ctx, GuardrailPrompt, "hello").Blocked)
+	g.moderation.FailClosed = true
+	assert.True(t, g.Check(ctx, GuardrailPrompt, "hello").Blocked)
+}
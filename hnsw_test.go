@@ -0,0 +1,103 @@
+// hnsw_test.go
+package main
+
+import (
+	"fmt"
+	"math/rand"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"manifold/internal/hnsw"
+)
+
+func TestHNSWIndex(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	vector := func(dim int) []float64 {
+		v := make([]float64, dim)
+		for i := range v {
+			v[i] = rng.Float64()*2 - 1
+		}
+		return v
+	}
+
+	edb := NewEmbeddingDB()
+	for i := 0; i < 1000; i++ {
+		edb.AddEmbedding(Embeddings{Word: fmt.Sprintf("w%d", i), Vector: vector(24)})
+	}
+	edb.EnableIndex(hnsw.Config{M: 12, EfConstruction: 100})
+	for i := 1000; i < 3000; i++ {
+		edb.AddEmbedding(Embeddings{Word: fmt.Sprintf("w%d", i), Vector: vector(24)})
+	}
+	edb.AddEmbedding(Embeddings{Word: "zero", Vector: make([]float64, 24)})
+	assert.Equal(t, 3000, edb.Index.Len())
+
+	recall := func(ef int) float64 {
+		edb.Index.SetEfSearch(ef)
+		found, total := 0, 0
+		queries := rand.New(rand.NewSource(2))
+		for q := 0; q < 50; q++ {
+			query := make([]float64, 24)
+			for i := range query {
+				query[i] = queries.Float64()*2 - 1
+			}
+			exact := map[string]bool{}
+			for _, embedding := range edb.FindTopNSimilarEmbeddings(query, 10) {
+				exact[embedding.Word] = true
+			}
+			approximate := edb.SearchEmbeddings(query, 10)
+			require.Len(t, approximate, 10)
+			for _, embedding := range approximate {
+				if exact[embedding.Word] {
+					found++
+				}
+			}
+			total += 10
+		}
+		return float64(found) / float64(total)
+	}
+	high := recall(200)
+	assert.GreaterOrEqual(t, high, 0.95)
+	assert.GreaterOrEqual(t, high, recall(10))
+
+	// Results carry the stored vectors and exact similarities
+	query := edb.Embeddings["w42"].Vector
+	top := edb.SearchEmbeddings(query, 3)
+	require.Len(t, top, 3)
+	assert.Equal(t, "w42", top[0].Word)
+	assert.Equal(t, query, top[0].Vector)
+	assert.InDelta(t, 1, top[0].Similarity, 1e-6)
+	assert.GreaterOrEqual(t, top[0].Similarity, top[1].Similarity)
+
+	// A replaced embedding is found at its new place only
+	edb.AddEmbedding(Embeddings{Word: "w42", Vector: vector(24)})
+	assert.Equal(t, 3000, edb.Index.Len())
+	for _, embedding := range edb.SearchEmbeddings(query, 5) {
+		assert.NotEqual(t, "w42", embedding.Word)
+	}
+	top = edb.SearchEmbeddings(edb.Embeddings["w42"].Vector, 1)
+	require.Len(t, top, 1)
+	assert.Equal(t, "w42", top[0].Word)
+
+	// The index is persisted alongside the embeddings and completed on load
+	path := filepath.Join(t.TempDir(), "embeddings.json")
+	require.NoError(t, edb.SaveEmbeddings(path))
+	assert.FileExists(t, indexPath(path))
+	loaded := NewEmbeddingDB()
+	loaded.Embeddings, _ = loaded.LoadEmbeddings(path)
+	loaded.AddEmbedding(Embeddings{Word: "new", Vector: vector(24)})
+	require.NoError(t, loaded.LoadIndex(path, hnsw.Config{EfSearch: 100}))
+	assert.Equal(t, 3001, loaded.Index.Len())
+	assert.Equal(t, 100, loaded.Index.Config().EfSearch)
+	assert.Equal(t, 12, loaded.Index.Config().M)
+	top = loaded.SearchEmbeddings(loaded.Embeddings["new"].Vector, 1)
+	require.Len(t, top, 1)
+	assert.Equal(t, "new", top[0].Word)
+
+	// Without a persisted index one is built
+	require.NoError(t, NewEmbeddingDB().LoadIndex(filepath.Join(t.TempDir(), "missing.json"), hnsw.Config{}))
+	assert.Empty(t, edb.SearchEmbeddings(vector(8), 5))
+	assert.Empty(t, hnsw.New(hnsw.Config{}).Search(query, 5))
+}
@@ -0,0 +1,307 @@
+// manifold/huggingface.go
+
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/labstack/echo/v4"
+)
+
+var huggingFaceURL = "https://huggingface.co"
+
+// HFModel is a model repository returned by the Hugging Face search API.
+type HFModel struct {
+	ID        string   `json:"id"`
+	Downloads int      `json:"downloads"`
+	Likes     int      `json:"likes"`
+	Tags      []string `json:"tags,omitempty"`
+}
+
+// HFFile is a GGUF file in a Hugging Face repository.
+type HFFile struct {
+	Path         string `json:"path"`
+	Size         int64  `json:"size"`
+	SHA256       string `json:"sha256,omitempty"`
+	Quantization string `json:"quantization,omitempty"`
+}
+
+// hfTreeEntry is an entry of the repository tree API. LFS files carry their SHA256 as the oid.
+type hfTreeEntry struct {
+	Type string `json:"type"`
+	Path string `json:"path"`
+	Size int64  `json:"size"`
+	LFS  *struct {
+		OID  string `json:"oid"`
+		Size int64  `json:"size"`
+	} `json:"lfs,omitempty"`
+}
+
+// HFClient talks to the Hugging Face Hub API.
+type HFClient struct {
+	baseURL string
+	token   string
+	client  *http.Client
+}
+
+// NewHFClient creates a Hub client. The token is optional and only needed for gated repositories.
+func NewHFClient(token string) *HFClient {
+	return &HFClient{baseURL: huggingFaceURL, token: token, client: &http.Client{}}
+}
+
+// get sends an authenticated GET request to the Hub.
+func (h *HFClient) get(ctx context.Context, rawURL string, header http.Header) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, rawURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	if h.token != "" {
+		req.Header.Set("Authorization", "Bearer "+h.token)
+	}
+	return h.client.Do(req)
+}
+
+// getJSON decodes the JSON response of a Hub API call into out.
+func (h *HFClient) getJSON(ctx context.Context, rawURL string, out interface{}) error {
+	resp, err := h.get(ctx, rawURL, nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("hugging face request failed with status %d", resp.StatusCode)
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+// SearchModels returns GGUF repositories matching query, most downloaded first.
+func (h *HFClient) SearchModels(ctx context.Context, query string, limit int) ([]HFModel, error) {
+	params := url.Values{}
+	params.Set("search", query)
+	params.Set("filter", "gguf")
+	params.Set("sort", "downloads")
+	params.Set("direction", "-1")
+	params.Set("limit", strconv.Itoa(limit))
+
+	var models []HFModel
+	if err := h.getJSON(ctx, h.baseURL+"/api/models?"+params.Encode(), &models); err != nil {
+		return nil, err
+	}
+	return models, nil
+}
+
+// ListGGUFFiles returns the GGUF files of a repository with their sizes and quantizations.
+func (h *HFClient) ListGGUFFiles(ctx context.Context, repo string) ([]HFFile, error) {
+	var entries []hfTreeEntry
+	if err := h.getJSON(ctx, fmt.Sprintf("%s/api/models/%s/tree/main?recursive=true", h.baseURL, repo), &entries); err != nil {
+		return nil, err
+	}
+
+	var files []HFFile
+	for _, entry := range entries {
+		if entry.Type != "file" || !strings.HasSuffix(strings.ToLower(entry.Path), ".gguf") {
+			continue
+		}
+		file := HFFile{Path: entry.Path, Size: entry.Size, Quantization: quantizationFromName(entry.Path)}
+		if entry.LFS != nil {
+			file.SHA256 = entry.LFS.OID
+			file.Size = entry.LFS.Size
+		}
+		files = append(files, file)
+	}
+	return files, nil
+}
+
+// quantizationFromName returns the quantization named in a GGUF file name, if any.
+func quantizationFromName(name string) string {
+	upper := strings.ToUpper(filepath.Base(name))
+	for _, q := range quantBitsPerWeight {
+		if strings.Contains(upper, q.name) {
+			return q.name
+		}
+	}
+	return ""
+}
+
+// Download fetches a file of repo into dest. The file is written to dest.part first so an
+// interrupted download resumes where it stopped, and renamed once its SHA256 matches checksum.
+func (h *HFClient) Download(ctx context.Context, repo, file, dest, checksum string, progress func(done, total int64)) error {
+	fileURL := fmt.Sprintf("%s/%s/resolve/main/%s", h.baseURL, repo, file)
+	return downloadFile(ctx, h, fileURL, dest, checksum, progress)
+}
+
+// downloadFile downloads rawURL into dest with resume support and SHA256 verification.
+// progress, if set, is called with the bytes written so far and the total size.
+func downloadFile(ctx context.Context, h *HFClient, rawURL, dest, checksum string, progress func(done, total int64)) error {
+	if err := os.MkdirAll(filepath.Dir(dest), 0755); err != nil {
+		return err
+	}
+
+	partPath := dest + ".part"
+	out, err := os.OpenFile(partPath, os.O_CREATE|os.O_RDWR, 0644)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+
+	// Hash the bytes already on disk so the checksum covers the whole file after resuming
+	hash := sha256.New()
+	offset, err := io.Copy(hash, out)
+	if err != nil {
+		return err
+	}
+
+	header := http.Header{}
+	if offset > 0 {
+		header.Set("Range", fmt.Sprintf("bytes=%d-", offset))
+	}
+
+	resp, err := h.get(ctx, rawURL, header)
+	if err != nil {
+		return fmt.Errorf("failed to download %s: %w", rawURL, err)
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusPartialContent:
+		slog.Info("resuming download", "url", rawURL, "offset", offset)
+	case http.StatusOK:
+		// The server ignored the range, start over
+		if err := out.Truncate(0); err != nil {
+			return err
+		}
+		if _, err := out.Seek(0, io.SeekStart); err != nil {
+			return err
+		}
+		hash.Reset()
+		offset = 0
+	case http.StatusRequestedRangeNotSatisfiable:
+		// The part file is already complete
+	default:
+		return fmt.Errorf("failed to download %s, status code: %d", rawURL, resp.StatusCode)
+	}
+
+	total := offset
+	if resp.ContentLength > 0 {
+		total += resp.ContentLength
+	}
+
+	if resp.StatusCode != http.StatusRequestedRangeNotSatisfiable {
+		writer := io.MultiWriter(out, hash)
+		if progress != nil {
+			writer = &progressWriter{w: writer, done: offset, total: total, fn: progress}
+		}
+		if _, err := io.Copy(writer, resp.Body); err != nil {
+			return fmt.Errorf("failed to save %s: %w", dest, err)
+		}
+	}
+
+	if checksum != "" {
+		if sum := hex.EncodeToString(hash.Sum(nil)); !strings.EqualFold(sum, checksum) {
+			out.Close()
+			os.Remove(partPath)
+			return fmt.Errorf("checksum mismatch for %s: expected %s, got %s", dest, checksum, sum)
+		}
+	}
+
+	if err := out.Close(); err != nil {
+		return err
+	}
+	return os.Rename(partPath, dest)
+}
+
+// progressWriter reports the bytes written through it.
+type progressWriter struct {
+	w     io.Writer
+	done  int64
+	total int64
+	fn    func(done, total int64)
+}
+
+func (p *progressWriter) Write(b []byte) (int, error) {
+	n, err := p.w.Write(b)
+	p.done += int64(n)
+	p.fn(p.done, p.total)
+	return n, err
+}
+
+// ggufModelPath returns where a downloaded GGUF file is stored: its own directory under models-gguf,
+// named after the file, so every quantization is registered as a separate model.
+func ggufModelPath(dataPath, file string) string {
+	base := filepath.Base(file)
+	return filepath.Join(dataPath, "models-gguf", strings.TrimSuffix(base, filepath.Ext(base)), base)
+}
+
+// registerGGUFModels rescans the gguf models directory and syncs the database and config.
+func registerGGUFModels(config *Config) error {
+	ggufModels, err := ScanGGUFModels(config.DataPath)
+	if err != nil {
+		return err
+	}
+	mlxModels, err := ScanMLXModels(config.DataPath)
+	if err != nil {
+		slog.Warn("failed to scan mlx models", "error", err)
+	}
+
+	if err := db.SyncModels(append(ggufModels, mlxModels...)); err != nil {
+		return err
+	}
+
+	models, err := db.GetModels()
+	if err != nil {
+		return err
+	}
+	configMu.Lock()
+	config.LanguageModels = models
+	configMu.Unlock()
+	return nil
+}
+
+// handleSearchHFModels searches Hugging Face for GGUF repositories.
+func handleSearchHFModels(c echo.Context, config *Config) error {
+	query := c.QueryParam("q")
+	if query == "" {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Query is required"})
+	}
+
+	limit, _ := strconv.Atoi(c.QueryParam("limit"))
+	if limit <= 0 || limit > 100 {
+		limit = 20
+	}
+
+	models, err := NewHFClient(config.HuggingFaceToken).SearchModels(c.Request().Context(), query, limit)
+	if err != nil {
+		return c.JSON(http.StatusBadGateway, map[string]string{"error": err.Error()})
+	}
+	return c.JSON(http.StatusOK, models)
+}
+
+// handleListHFFiles lists the GGUF files of a Hugging Face repository.
+func handleListHFFiles(c echo.Context, config *Config) error {
+	repo := c.QueryParam("repo")
+	if repo == "" {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Repository is required"})
+	}
+
+	files, err := NewHFClient(config.HuggingFaceToken).ListGGUFFiles(c.Request().Context(), repo)
+	if err != nil {
+		return c.JSON(http.StatusBadGateway, map[string]string{"error": err.Error()})
+	}
+	return c.JSON(http.StatusOK, files)
+}
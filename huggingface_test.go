@@ -0,0 +1,52 @@
+// huggingface_test.go
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDownloadFileResumes(t *testing.T) {
+	content := bytes.Repeat([]byte("manifold"), 1024)
+	sum := sha256.Sum256(content)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.ServeContent(w, r, "model.gguf", time.Time{}, bytes.NewReader(content))
+	}))
+	defer server.Close()
+
+	dest := filepath.Join(t.TempDir(), "model.gguf")
+	require.NoError(t, os.WriteFile(dest+".part", content[:1000], 0644))
+
+	err := downloadFile(context.Background(), NewHFClient(""), server.URL, dest, hex.EncodeToString(sum[:]), nil)
+	require.NoError(t, err)
+
+	data, err := os.ReadFile(dest)
+	require.NoError(t, err)
+	assert.Equal(t, content, data)
+	assert.NoFileExists(t, dest+".part")
+}
+
+func TestDownloadFileChecksumMismatch(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("corrupt"))
+	}))
+	defer server.Close()
+
+	dest := filepath.Join(t.TempDir(), "model.gguf")
+	err := downloadFile(context.Background(), NewHFClient(""), server.URL, dest, "00", nil)
+	assert.ErrorContains(t, err, "checksum mismatch")
+	assert.NoFileExists(t, dest)
+	assert.NoFileExists(t, dest+".part")
+}
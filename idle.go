@@ -0,0 +1,138 @@
+// manifold/idle.go
+
+package main
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+// idleCheckInterval is how often the idle monitor checks for inactivity.
+const idleCheckInterval = 30 * time.Second
+
+// IdleMonitor stops the local completions service after a period without requests to free GPU memory,
+// and starts it again when the next chat request arrives.
+type IdleMonitor struct {
+	mu        sync.Mutex
+	config    *Config
+	verbose   bool
+	timeout   time.Duration
+	lastUsed  time.Time
+	active    int
+	unloaded  bool
+	reloading bool
+}
+
+var idleMonitor = &IdleMonitor{}
+
+// Configure enables the monitor when idle_unload_minutes is set and the backend runs locally.
+func (m *IdleMonitor) Configure(config *Config, verbose bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.config = config
+	m.verbose = verbose
+	m.lastUsed = time.Now()
+	m.timeout = 0
+	if config.IdleUnloadMinutes > 0 && (config.LLMBackend == "gguf" || config.LLMBackend == "mlx") {
+		m.timeout = time.Duration(config.IdleUnloadMinutes) * time.Minute
+	}
+}
+
+// Begin records the start of a request using the completions backend.
+func (m *IdleMonitor) Begin() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.active++
+	m.lastUsed = time.Now()
+}
+
+// End records the end of a request started with Begin.
+func (m *IdleMonitor) End() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.active--
+	m.lastUsed = time.Now()
+}
+
+// Unloaded reports whether the completions service is currently stopped for inactivity.
+func (m *IdleMonitor) Unloaded() bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.unloaded
+}
+
+// Run checks for inactivity until ctx is canceled.
+func (m *IdleMonitor) Run(ctx context.Context) {
+	ticker := time.NewTicker(idleCheckInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			m.unloadIfIdle()
+		}
+	}
+}
+
+// unloadIfIdle stops the completions service when no request has used it for the configured timeout.
+func (m *IdleMonitor) unloadIfIdle() {
+	m.mu.Lock()
+	if m.timeout == 0 || m.unloaded || m.reloading || m.active > 0 || time.Since(m.lastUsed) < m.timeout {
+		m.mu.Unlock()
+		return
+	}
+	m.unloaded = true
+	idleFor := time.Since(m.lastUsed)
+	m.mu.Unlock()
+
+	swapMu.Lock()
+	defer swapMu.Unlock()
+
+	supervisor.Unregister("completions")
+	backendReadiness.MarkNotReady()
+
+	backendMu.Lock()
+	service, cancelFn := completionsService, cancel
+	completionsService, cancel = nil, nil
+	backendMu.Unlock()
+
+	drainAndStop(service, cancelFn)
+	slog.Info("completions service unloaded after inactivity", "idle", idleFor.Round(time.Second))
+}
+
+// EnsureLoaded marks the backend as in use and, if it was unloaded for inactivity, starts it again in
+// the background. Callers then wait on backendReadiness as they would after any restart.
+func (m *IdleMonitor) EnsureLoaded() {
+	m.mu.Lock()
+	m.lastUsed = time.Now()
+	if !m.unloaded || m.reloading {
+		m.mu.Unlock()
+		return
+	}
+	m.reloading = true
+	config, verbose := m.config, m.verbose
+	m.mu.Unlock()
+
+	slog.Info("reloading completions service after inactivity")
+
+	go func() {
+		err := restartCompletionsService(config, verbose)
+
+		m.mu.Lock()
+		m.reloading = false
+		if err == nil {
+			m.unloaded = false
+			m.lastUsed = time.Now()
+		}
+		m.mu.Unlock()
+
+		if err != nil {
+			slog.Error("failed to reload completions service", "error", err)
+		}
+	}()
+}
@@ -0,0 +1,78 @@
+// idle_test.go
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestIdleMonitorConfigure(t *testing.T) {
+	tests := []struct {
+		backend string
+		minutes int
+		timeout time.Duration
+	}{
+		{"gguf", 5, 5 * time.Minute},
+		{"mlx", 1, time.Minute},
+		{"gguf", 0, 0},
+		{"openai", 5, 0},
+	}
+	for _, tt := range tests {
+		m := &IdleMonitor{}
+		m.Configure(&Config{LLMBackend: tt.backend, IdleUnloadMinutes: tt.minutes}, false)
+		assert.Equal(t, tt.timeout, m.timeout, tt.backend)
+	}
+}
+
+func TestIdleMonitorUnloadAndReload(t *testing.T) {
+	useTestBackend(t)
+	backend := newFakeLocalBackend(t, false)
+	config := &Config{
+		LLMBackend:        "mlx",
+		IdleUnloadMinutes: 1,
+		Services:          []ServiceConfig{{}, {}, backend.serviceConfig("mlx_lm.server")},
+		SelectedModels:    SelectedModels{ModelName: "tiny"},
+	}
+	idleMonitor.Configure(config, false)
+
+	require.NoError(t, restartCompletionsService(config, false))
+	loaded := activeService()
+	require.NotNil(t, loaded)
+
+	setIdleSince := func(since time.Duration) {
+		idleMonitor.mu.Lock()
+		idleMonitor.lastUsed = time.Now().Add(-since)
+		idleMonitor.mu.Unlock()
+	}
+
+	// A request in flight or recent activity keeps the model loaded
+	_, release := currentBackend()
+	setIdleSince(2 * time.Minute)
+	idleMonitor.unloadIfIdle()
+	assert.False(t, idleMonitor.Unloaded())
+	release()
+	idleMonitor.unloadIfIdle()
+	assert.False(t, idleMonitor.Unloaded(), "release counts as activity")
+
+	// Embeddings are served by another service and do not count as activity
+	setIdleSince(2 * time.Minute)
+	GenerateEmbedding(context.Background(), "hello")
+	idleMonitor.unloadIfIdle()
+	assert.True(t, idleMonitor.Unloaded())
+	assert.Nil(t, activeService())
+	assert.False(t, backendReadiness.Ready())
+	assert.False(t, supervised("completions"))
+	exited, _ := loaded.Exited()
+	assert.True(t, exited)
+
+	// The next request starts it again
+	idleMonitor.EnsureLoaded()
+	assert.Eventually(t, func() bool { return !idleMonitor.Unloaded() }, 5*time.Second, 20*time.Millisecond)
+	assert.NotNil(t, activeService())
+	assert.True(t, backendReadiness.Ready())
+	assert.True(t, supervised("completions"))
+}
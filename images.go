@@ -0,0 +1,197 @@
+// manifold/images.go
+
+package main
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/labstack/echo/v4"
+)
+
+// defaultMaxImageMB is the upload limit when max_image_mb is not set.
+const defaultMaxImageMB = 10
+
+// imageExtensions are the image types accepted for vision models, by sniffed content type.
+var imageExtensions = map[string]string{
+	"image/png":  ".png",
+	"image/jpeg": ".jpg",
+	"image/webp": ".webp",
+	"image/gif":  ".gif",
+}
+
+// imageIDPattern matches the file names handed out by handleUploadImage.
+var imageIDPattern = regexp.MustCompile(`^[0-9a-f]{32}\.(png|jpg|webp|gif)$`)
+
+// ContentPart is an element of a multimodal message: text or an image URL, which may be a base64
+// data URL. OpenAI, Gemini and llama-server with --mmproj all accept this format.
+type ContentPart struct {
+	Type     string    `json:"type"`
+	Text     string    `json:"text,omitempty"`
+	ImageURL *ImageURL `json:"image_url,omitempty"`
+}
+
+// ImageURL references an image in a content part.
+type ImageURL struct {
+	URL string `json:"url"`
+}
+
+// MarshalJSON sends content as a plain string, or as text and image parts when the message has images.
+func (m Message) MarshalJSON() ([]byte, error) {
+	if len(m.Images) == 0 {
+		return json.Marshal(struct {
+			Role    string `json:"role"`
+			Content string `json:"content"`
+		}{m.Role, m.Content})
+	}
+
+	parts := []ContentPart{{Type: "text", Text: m.Content}}
+	for _, url := range m.Images {
+		parts = append(parts, ContentPart{Type: "image_url", ImageURL: &ImageURL{URL: url}})
+	}
+	return json.Marshal(struct {
+		Role    string        `json:"role"`
+		Content []ContentPart `json:"content"`
+	}{m.Role, parts})
+}
+
+// UnmarshalJSON accepts content as a string, null or a list of parts.
+func (m *Message) UnmarshalJSON(data []byte) error {
+	var raw struct {
+		Role    string          `json:"role"`
+		Content json.RawMessage `json:"content"`
+	}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+
+	m.Role, m.Content, m.Images = raw.Role, "", nil
+	if len(raw.Content) == 0 || string(raw.Content) == "null" {
+		return nil
+	}
+	if raw.Content[0] == '"' {
+		return json.Unmarshal(raw.Content, &m.Content)
+	}
+
+	var parts []ContentPart
+	if err := json.Unmarshal(raw.Content, &parts); err != nil {
+		return err
+	}
+	var text []string
+	for _, part := range parts {
+		switch {
+		case part.Type == "text":
+			text = append(text, part.Text)
+		case part.Type == "image_url" && part.ImageURL != nil:
+			m.Images = append(m.Images, part.ImageURL.URL)
+		}
+	}
+	m.Content = strings.Join(text, "\n")
+	return nil
+}
+
+// maxImageBytes returns the configured image upload limit.
+func maxImageBytes(config *Config) int64 {
+	mb := config.MaxImageMB
+	if mb <= 0 {
+		mb = defaultMaxImageMB
+	}
+	return int64(mb) << 20
+}
+
+// imagesDir is where uploaded images are kept until they are sent to a model.
+func imagesDir(dataPath string) string {
+	return filepath.Join(dataPath, "uploads", "images")
+}
+
+// handleUploadImage stores an uploaded image for a later chat message and returns its id.
+func handleUploadImage(c echo.Context, config *Config) error {
+	limit := maxImageBytes(config)
+	c.Request().Body = http.MaxBytesReader(c.Response(), c.Request().Body, limit+1<<20)
+
+	file, err := c.FormFile("image")
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Image file is required"})
+	}
+	if file.Size > limit {
+		return c.JSON(http.StatusRequestEntityTooLarge, map[string]string{"error": fmt.Sprintf("Image exceeds %dMB", limit>>20)})
+	}
+
+	src, err := file.Open()
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Failed to read image"})
+	}
+	defer src.Close()
+
+	data, err := io.ReadAll(io.LimitReader(src, limit))
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Failed to read image"})
+	}
+
+	// Trust the bytes, not the client's content type
+	contentType := http.DetectContentType(data)
+	ext, ok := imageExtensions[contentType]
+	if !ok {
+		return c.JSON(http.StatusUnsupportedMediaType, map[string]string{"error": "Unsupported image type " + contentType})
+	}
+
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to store image"})
+	}
+	id := hex.EncodeToString(b) + ext
+
+	dir := imagesDir(config.DataPath)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to store image"})
+	}
+	if err := os.WriteFile(filepath.Join(dir, id), data, 0644); err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to store image"})
+	}
+
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"id":           id,
+		"content_type": contentType,
+		"size":         len(data),
+	})
+}
+
+// imageDataURLs loads uploaded images by id and returns them as base64 data URLs.
+func imageDataURLs(dataPath string, ids []string) ([]string, error) {
+	var urls []string
+	for _, id := range ids {
+		id = strings.TrimSpace(id)
+		if id == "" {
+			continue
+		}
+		if !imageIDPattern.MatchString(id) {
+			return nil, fmt.Errorf("invalid image id %q", id)
+		}
+
+		data, err := os.ReadFile(filepath.Join(imagesDir(dataPath), id))
+		if err != nil {
+			return nil, fmt.Errorf("image %s not found", id)
+		}
+		urls = append(urls, fmt.Sprintf("data:%s;base64,%s", http.DetectContentType(data), base64.StdEncoding.EncodeToString(data)))
+	}
+	return urls, nil
+}
+
+// findMmproj returns the multimodal projector stored next to a gguf model, used to serve
+// llava-style vision models with llama-server --mmproj.
+func findMmproj(modelPath string) string {
+	matches, _ := filepath.Glob(filepath.Join(filepath.Dir(modelPath), "mmproj*.gguf"))
+	if len(matches) == 0 {
+		return ""
+	}
+	return matches[0]
+}
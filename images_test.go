@@ -0,0 +1,49 @@
+// images_test.go
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMessageMarshalJSON(t *testing.T) {
+	data, err := json.Marshal(Message{Role: "user", Content: "hi"})
+	require.NoError(t, err)
+	assert.JSONEq(t, `{"role":"user","content":"hi"}`, string(data))
+
+	data, err = json.Marshal(Message{Role: "user", Content: "what is this?", Images: []string{"data:image/png;base64,AAAA"}})
+	require.NoError(t, err)
+	assert.JSONEq(t, `{"role":"user","content":[
+		{"type":"text","text":"what is this?"},
+		{"type":"image_url","image_url":{"url":"data:image/png;base64,AAAA"}}
+	]}`, string(data))
+
+	var msg Message
+	require.NoError(t, json.Unmarshal(data, &msg))
+	assert.Equal(t, "what is this?", msg.Content)
+	assert.Equal(t, []string{"data:image/png;base64,AAAA"}, msg.Images)
+
+	require.NoError(t, json.Unmarshal([]byte(`{"role":"assistant","content":null}`), &msg))
+	assert.Equal(t, Message{Role: "assistant"}, msg)
+}
+
+func TestImageDataURLs(t *testing.T) {
+	dir := t.TempDir()
+	require.NoError(t, os.MkdirAll(imagesDir(dir), 0755))
+
+	png := []byte("\x89PNG\r\n\x1a\n0000")
+	id := "0123456789abcdef0123456789abcdef.png"
+	require.NoError(t, os.WriteFile(filepath.Join(imagesDir(dir), id), png, 0644))
+
+	urls, err := imageDataURLs(dir, []string{id, ""})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"data:image/png;base64,iVBORw0KGgowMDAw"}, urls)
+
+	_, err = imageDataURLs(dir, []string{"../../etc/passwd"})
+	assert.Error(t, err)
+}
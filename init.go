@@ -3,11 +3,13 @@
 package main
 
 import (
+	"context"
 	"embed"
 	"fmt"
 	"io"
-	"log"
+	"log/slog"
 	"manifold/internal/documents"
+	"manifold/internal/edata"
 	"os"
 	"os/exec"
 	"path/filepath"
@@ -32,12 +34,34 @@ func initializeApplication(config *Config) (*SQLiteDB, error) {
 	indexPath := filepath.Join(config.DataPath, "searchindex")
 	indexManager, err = documents.NewIndexManager(indexPath)
 	if err != nil {
-		fmt.Println("Failed to initialize index:", err)
+		slog.Error("failed to initialize index", "path", indexPath, "error", err)
 		return nil, err
 	}
 
-	// Initialize the DocumentManager with chunk size, overlap size, and IndexManager
-	docManager = documents.NewDocumentManager(2048, 0, indexManager)
+	// Initialize the DocumentManager with the chunk sizes of the config and IndexManager
+	defaults := config.Documents.defaults()
+	docManager = documents.NewDocumentManager(defaults.ChunkSize, defaults.OverlapSize, indexManager)
+	if err := docManager.SetChunking(defaults, config.Documents.Types); err != nil {
+		return nil, fmt.Errorf("invalid documents config: %w", err)
+	}
+	docManager.NearDuplicateDistance = config.Documents.NearDuplicateDistance
+	docManager.OCR, docManager.OCRMinChars = newOCR(config.Documents.OCR), config.Documents.OCR.MinChars
+	docChunker = newDocChunker(config.Documents, docManager)
+	ingestWebhooks = newWebhookNotifier(config.Documents.Webhooks)
+
+	retriever, err = newRetriever(config.Retrieval, indexManager)
+	if err != nil {
+		return nil, err
+	}
+	if config.Retrieval.Backend == "sqlitevec" {
+		// Documents ingested before are embedded in the background, they are retrieved once embedded
+		embedIngestedChunks = true
+		go func() {
+			if err := db.backfillChunkVectors(context.Background(), indexManager); err != nil {
+				slog.Error("failed to embed indexed chunks", "error", err)
+			}
+		}()
+	}
 
 	// searchIndex, err = initializeSearchIndex(config.DataPath)
 	// if err != nil {
@@ -52,11 +76,18 @@ func initializeApplication(config *Config) (*SQLiteDB, error) {
 
 	// fmt.Printf("Search index contains %d documents\n", count)
 
-	// Initialize the edata database
-	// err = edata.InitDB(filepath.Join(config.DataPath, "edata.db"))
-	// if err != nil {
-	// 	return nil, nil, fmt.Errorf("failed to initialize edata database: %w", err)
-	// }
+	// Initialize the edata database of chat turns and documents and their graph
+	if config.EData.Enabled {
+		if err := edata.InitDB(filepath.Join(config.DataPath, "edata.db")); err != nil {
+			return nil, fmt.Errorf("failed to initialize edata database: %w", err)
+		}
+		if config.EData.GraphRAG.Enabled {
+			if graphExtractor, err = NewGraphExtractor(config.EData.GraphRAG); err != nil {
+				return nil, fmt.Errorf("invalid graph_rag config: %w", err)
+			}
+			retriever = newGraphRetriever(retriever, indexManager, config.EData.GraphRAG)
+		}
+	}
 
 	return db, nil
 }
@@ -81,7 +112,7 @@ func initializeServer(dataPath string) error {
 		return err
 	}
 
-	log.Println("Server initialized")
+	slog.Info("server initialized", "data_path", dataPath)
 
 	return nil
 }
@@ -98,13 +129,13 @@ func initializeDatabase(config *Config) (*SQLiteDB, error) {
 
 	// Enable SQLite extension loading if necessary
 	if err := db.EnableSQLiteExtensionLoading(); err != nil {
-		log.Fatalf("Failed to enable SQLite extensions: %v", err)
+		fatal("failed to enable SQLite extensions", "error", err)
 	}
 
-	// Load and execute the sqlite-vec extension if needed
-	// if err := db.LoadVecExtension(); err != nil {
-	// 	log.Fatalf("Failed to load sqlite-vec extension: %v", err)
-	// }
+	// Create the sqlite-vec tables of the chat and document embeddings
+	if err := db.CreateVectorTables(config.Retrieval.Dimensions); err != nil {
+		fatal("failed to create sqlite-vec tables", "error", err)
+	}
 
 	if !dbExists {
 		// Perform AutoMigrate for all relevant models
@@ -116,20 +147,36 @@ func initializeDatabase(config *Config) (*SQLiteDB, error) {
 			&LanguageModel{},
 			&SelectedModels{},
 			&URLTracking{},
+			&LoraAdapter{},
+			&PromptTemplateVersion{},
+			&RoleExample{},
+			&ToolInvocation{},
+			&AgentMessage{},
+			&PlanStep{},
+			&GuardrailEvent{},
+			&AuditEntry{},
+			&ChatSession{},
+			&ChatTurn{},
+			&ChatResponse{},
+			&EvalRun{},
+			&DatasetIngestion{},
 		)
 		if err != nil {
-			log.Fatal(err)
+			fatal("failed to migrate database", "error", err)
+		}
+		if err := createAuditTriggers(db); err != nil {
+			fatal("failed to create audit triggers", "error", err)
 		}
 
 		// Scan models directories
 		ggufModels, err := ScanGGUFModels(config.DataPath)
 		if err != nil {
-			log.Fatalf("Failed to scan gguf models: %v", err)
+			fatal("failed to scan gguf models", "error", err)
 		}
 
 		mlxModels, err := ScanMLXModels(config.DataPath)
 		if err != nil {
-			log.Fatalf("Failed to scan mlx models: %v", err)
+			fatal("failed to scan mlx models", "error", err)
 		}
 
 		// Combine all models
@@ -139,17 +186,27 @@ func initializeDatabase(config *Config) (*SQLiteDB, error) {
 
 		// Synchronize models with the database
 		if err := db.SyncModels(allModels); err != nil {
-			log.Fatalf("Failed to synchronize models: %v", err)
+			fatal("failed to synchronize models", "error", err)
+		}
+
+		if err := failRunningIngestions(db); err != nil {
+			slog.Warn("failed to update interrupted dataset ingestions", "error", err)
+		}
+		if err := syncLoraAdapters(db, config.DataPath); err != nil {
+			slog.Warn("failed to scan lora adapters", "error", err)
 		}
 
 		// Load tools data into the database
 		if err := loadToolsToDB(db, config.Tools); err != nil {
-			log.Fatal(err)
+			fatal("failed to load tools to database", "error", err)
+		}
+		if err := ensurePluginTools(db, config.Plugins); err != nil {
+			fatal("failed to load plugin tools to database", "error", err)
 		}
 
 		// Load completions roles into the database
 		if err := loadCompletionsRolesToDB(db, config.Roles); err != nil {
-			log.Fatal(err)
+			fatal("failed to load completions roles to database", "error", err)
 		}
 
 		// Create FTS5 table for full-text search on 'Prompt' and 'Response'
@@ -165,9 +222,39 @@ func initializeDatabase(config *Config) (*SQLiteDB, error) {
 			return nil, fmt.Errorf("failed to create FTS5 table: %v", err)
 		}
 
-		log.Println("Database created, migrated, and FTS5 table created")
+		slog.Info("database created, migrated, and FTS5 table created", "path", dbPath)
+	} else {
+		slog.Info("existing database found", "path", dbPath)
+
+		// Add new tables and columns, then backfill the model metadata from the GGUF headers
+		if err := db.AutoMigrate(&LanguageModel{}, &LoraAdapter{}, &Chat{}, &CompletionsRole{}, &PromptTemplateVersion{}, &RoleExample{}, &ToolInvocation{}, &AgentMessage{}, &PlanStep{}, &GuardrailEvent{}, &AuditEntry{}, &ChatSession{}, &ChatTurn{}, &ChatResponse{}, &EvalRun{}, &URLTracking{}, &DatasetIngestion{}); err != nil {
+			fatal("failed to migrate database", "error", err)
+		}
+		if err := createAuditTriggers(db); err != nil {
+			fatal("failed to create audit triggers", "error", err)
+		}
+		if err := db.UpdateGGUFMetadata(); err != nil {
+			slog.Warn("failed to update model metadata", "error", err)
+		}
+		if err := syncLoraAdapters(db, config.DataPath); err != nil {
+			slog.Warn("failed to scan lora adapters", "error", err)
+		}
+
+		if err := ensurePluginTools(db, config.Plugins); err != nil {
+			slog.Warn("failed to load plugin tools", "error", err)
+		}
+
+		// Roles edited through the API live in the database, add new ones from the config file
+		if err := loadCompletionsRolesToDB(db, config.Roles); err != nil {
+			slog.Warn("failed to load completions roles", "error", err)
+		}
+	}
+
+	// The database holds the roles edited through the API, serve those instead of the config file's
+	if roles, err := db.GetRoles(); err != nil {
+		slog.Warn("failed to load completions roles", "error", err)
 	} else {
-		log.Println("Existing database found")
+		config.Roles = roles
 	}
 
 	return db, nil
@@ -206,10 +293,6 @@ func initServer(configPath string) (string, error) {
 		return "", err
 	}
 
-	// if err := setupComfyUI(configPath); err != nil {
-	// 	return "", err
-	// }
-
 	// if err := setupComfyUIEssentials(configPath); err != nil {
 	// 	return "", err
 	// }
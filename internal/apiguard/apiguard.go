@@ -0,0 +1,116 @@
+// apiguard.go
+package apiguard
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"mime"
+	"net/http"
+	"strings"
+)
+
+// Key is an API key accepted by a server. Admin keys may use administrative endpoints.
+type Key struct {
+	Name  string `yaml:"name"`
+	Key   string `yaml:"key" json:"-"`
+	Admin bool   `yaml:"admin,omitempty"`
+}
+
+type contextKey struct{}
+
+// FindKey returns the key matching the given value using a constant time comparison.
+func FindKey(keys []Key, value string) (*Key, bool) {
+	if value == "" {
+		return nil, false
+	}
+	for i := range keys {
+		if subtle.ConstantTimeCompare([]byte(keys[i].Key), []byte(value)) == 1 {
+			return &keys[i], true
+		}
+	}
+	return nil, false
+}
+
+// RequestKey extracts an API key from the Authorization or X-API-Key headers, falling back to the
+// api_key query parameter since browsers cannot set headers on websocket upgrades.
+func RequestKey(r *http.Request) string {
+	if header := r.Header.Get("Authorization"); strings.HasPrefix(header, "Bearer ") {
+		return strings.TrimPrefix(header, "Bearer ")
+	}
+	if key := r.Header.Get("X-API-Key"); key != "" {
+		return key
+	}
+	return r.URL.Query().Get("api_key")
+}
+
+// KeyFromContext returns the key a request authenticated with, set by RequireKey.
+func KeyFromContext(ctx context.Context) (*Key, bool) {
+	key, ok := ctx.Value(contextKey{}).(*Key)
+	return key, ok
+}
+
+// writeError writes a JSON error, as the handlers of the API do.
+func writeError(w http.ResponseWriter, status int, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]string{"error": message})
+}
+
+// RequireKey rejects requests without one of the keys. Paths for which public returns true are served
+// without one; public may be nil.
+func RequireKey(keys []Key, public func(path string) bool) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if public != nil && public(r.URL.Path) {
+				next.ServeHTTP(w, r)
+				return
+			}
+			key, ok := FindKey(keys, RequestKey(r))
+			if !ok {
+				writeError(w, http.StatusUnauthorized, "Authentication required")
+				return
+			}
+			next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), contextKey{}, key)))
+		})
+	}
+}
+
+// LimitBody rejects request bodies over maxBytes: at once when their length is announced, else when
+// the handler reads past the limit, which fails with an *http.MaxBytesError.
+func LimitBody(maxBytes int64) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.ContentLength > maxBytes {
+				writeError(w, http.StatusRequestEntityTooLarge, fmt.Sprintf("request body is larger than %d bytes", maxBytes))
+				return
+			}
+			r.Body = http.MaxBytesReader(w, r.Body, maxBytes)
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// RequireContentType rejects requests with a body of another media type than the given ones, e.g.
+// "multipart/form-data". Requests without a body pass.
+func RequireContentType(mediaTypes ...string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.ContentLength == 0 || r.Method == http.MethodGet || r.Method == http.MethodHead {
+				next.ServeHTTP(w, r)
+				return
+			}
+			mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+			if err == nil {
+				for _, allowed := range mediaTypes {
+					if strings.EqualFold(mediaType, allowed) {
+						next.ServeHTTP(w, r)
+						return
+					}
+				}
+			}
+			writeError(w, http.StatusUnsupportedMediaType, fmt.Sprintf("content type must be %s", strings.Join(mediaTypes, " or ")))
+		})
+	}
+}
@@ -0,0 +1,95 @@
+// apiguard_test.go
+package apiguard
+
+import (
+	"bytes"
+	"errors"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRequireKey(t *testing.T) {
+	keys := []Key{{Name: "reader", Key: "r-key"}, {Name: "admin", Key: "a-key", Admin: true}}
+	handler := RequireKey(keys, func(path string) bool { return path == "/health" })(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if key, ok := KeyFromContext(r.Context()); ok {
+			w.Write([]byte(key.Name))
+		}
+	}))
+
+	serve := func(path string, header ...string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		if len(header) == 2 {
+			req.Header.Set(header[0], header[1])
+		}
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec
+	}
+
+	rec := serve("/query")
+	assert.Equal(t, http.StatusUnauthorized, rec.Code)
+	assert.JSONEq(t, `{"error": "Authentication required"}`, rec.Body.String())
+	assert.Equal(t, http.StatusUnauthorized, serve("/query", "Authorization", "Bearer wrong").Code)
+
+	assert.Equal(t, "admin", serve("/query", "Authorization", "Bearer a-key").Body.String())
+	assert.Equal(t, "reader", serve("/query", "X-API-Key", "r-key").Body.String())
+	assert.Equal(t, "reader", serve("/query?api_key=r-key").Body.String())
+	assert.Equal(t, http.StatusOK, serve("/health").Code)
+}
+
+func TestLimitBody(t *testing.T) {
+	var readErr error
+	handler := LimitBody(1024)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _, readErr = r.FormFile("pdf")
+	}))
+
+	upload := func(size int, chunked bool) *httptest.ResponseRecorder {
+		var body bytes.Buffer
+		writer := multipart.NewWriter(&body)
+		part, err := writer.CreateFormFile("pdf", "doc.pdf")
+		require.NoError(t, err)
+		part.Write(bytes.Repeat([]byte("x"), size))
+		require.NoError(t, writer.Close())
+
+		req := httptest.NewRequest(http.MethodPost, "/ingest/pdf", &body)
+		req.Header.Set("Content-Type", writer.FormDataContentType())
+		if chunked {
+			req.ContentLength = -1
+		}
+		rec := httptest.NewRecorder()
+		readErr = nil
+		handler.ServeHTTP(rec, req)
+		return rec
+	}
+
+	assert.Equal(t, http.StatusOK, upload(100, false).Code)
+	assert.NoError(t, readErr)
+	assert.Equal(t, http.StatusRequestEntityTooLarge, upload(4096, false).Code)
+
+	// Without a length, reading past the limit fails
+	upload(4096, true)
+	var tooLarge *http.MaxBytesError
+	assert.True(t, errors.As(readErr, &tooLarge), readErr)
+}
+
+func TestRequireContentType(t *testing.T) {
+	handler := RequireContentType("multipart/form-data")(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	serve := func(method, contentType string) int {
+		req := httptest.NewRequest(method, "/ingest/pdf", strings.NewReader("body"))
+		req.Header.Set("Content-Type", contentType)
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec.Code
+	}
+	assert.Equal(t, http.StatusOK, serve(http.MethodPost, "multipart/form-data; boundary=x"))
+	assert.Equal(t, http.StatusUnsupportedMediaType, serve(http.MethodPost, "application/json"))
+	assert.Equal(t, http.StatusUnsupportedMediaType, serve(http.MethodPost, ""))
+	assert.Equal(t, http.StatusOK, serve(http.MethodGet, "application/json"))
+}
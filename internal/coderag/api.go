@@ -0,0 +1,151 @@
+// api.go
+package coderag
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// FileSymbol is a function, type or variable declared in a file.
+type FileSymbol struct {
+	ID         string `json:"id"`
+	Name       string `json:"name"`
+	Kind       string `json:"kind"` // function, method, interface method, type or variable
+	LineNumber int    `json:"line_number"`
+}
+
+// DependencyFilter selects edges of the dependency graph. Empty fields match every edge.
+type DependencyFilter struct {
+	Node   string // edges from or to this node
+	Kind   string // edges of this kind, e.g. implements
+	Prefix string // edges between nodes starting with this prefix, e.g. a package path
+}
+
+// ListFiles returns the paths of the indexed files.
+func (idx *CodeIndex) ListFiles() []string {
+	idx.mu.RLock()
+	defer idx.mu.RUnlock()
+
+	files := make([]string, 0, len(idx.Files))
+	for file := range idx.Files {
+		files = append(files, file)
+	}
+	sort.Strings(files)
+	return files
+}
+
+// FileSymbols returns the symbols declared in a file, by line. Paths relative to the repository
+// are accepted.
+func (idx *CodeIndex) FileSymbols(path string) ([]FileSymbol, error) {
+	idx.mu.RLock()
+	defer idx.mu.RUnlock()
+
+	ids, exists := idx.Files[path]
+	if !exists && idx.repoPath != "" && !filepath.IsAbs(path) {
+		ids, exists = idx.Files[filepath.Join(idx.repoPath, path)]
+	}
+	if !exists {
+		return nil, fmt.Errorf("file %s not indexed", path)
+	}
+
+	symbols := make([]FileSymbol, 0, len(ids))
+	for _, id := range ids {
+		if fn, ok := idx.Functions[id]; ok {
+			symbols = append(symbols, FileSymbol{ID: id, Name: fn.Name, Kind: fn.Type, LineNumber: fn.LineNumber})
+		} else if info, ok := idx.Types[id]; ok {
+			symbols = append(symbols, FileSymbol{ID: id, Name: info.Name, Kind: "type", LineNumber: info.LineNumber})
+		} else if info, ok := idx.Variables[id]; ok {
+			symbols = append(symbols, FileSymbol{ID: id, Name: info.Name, Kind: "variable", LineNumber: info.LineNumber})
+		}
+	}
+	sort.SliceStable(symbols, func(i, j int) bool { return symbols[i].LineNumber < symbols[j].LineNumber })
+	return symbols, nil
+}
+
+// FilterDependencyGraph returns the edges of the dependency graph matching the filter, with their
+// nodes.
+func (idx *CodeIndex) FilterDependencyGraph(filter DependencyFilter) DependencyGraph {
+	idx.mu.RLock()
+	defer idx.mu.RUnlock()
+
+	graph := DependencyGraph{Nodes: []string{}, Edges: []DependencyEdge{}}
+	nodes := make(map[string]bool)
+	for _, edge := range idx.DependencyGraph.Edges {
+		if filter.Node != "" && edge.From != filter.Node && edge.To != filter.Node {
+			continue
+		}
+		if filter.Kind != "" && edge.Kind != filter.Kind {
+			continue
+		}
+		if filter.Prefix != "" && !(strings.HasPrefix(edge.From, filter.Prefix) && strings.HasPrefix(edge.To, filter.Prefix)) {
+			continue
+		}
+		graph.Edges = append(graph.Edges, edge)
+		nodes[edge.From], nodes[edge.To] = true, true
+	}
+	for node := range nodes {
+		graph.Nodes = append(graph.Nodes, node)
+	}
+	sort.Strings(graph.Nodes)
+	return graph
+}
+
+// handleFileQuery lists the symbols of a file, or the indexed files without a path.
+func (idx *CodeIndex) handleFileQuery(w http.ResponseWriter, r *http.Request) {
+	path := r.URL.Query().Get("path")
+	if path == "" {
+		json.NewEncoder(w).Encode(idx.ListFiles())
+		return
+	}
+
+	symbols, err := idx.FileSymbols(path)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+
+	json.NewEncoder(w).Encode(map[string]interface{}{"path": path, "symbols": symbols})
+}
+
+// handleRefactorQuery returns the refactoring opportunities, optionally of one severity. With
+// sort=churn, the opportunities in the functions changed the most recently come first.
+func (idx *CodeIndex) handleRefactorQuery(w http.ResponseWriter, r *http.Request) {
+	severity := r.URL.Query().Get("severity")
+	order := r.URL.Query().Get("sort")
+	if order != "" && order != "churn" {
+		http.Error(w, fmt.Sprintf("Unknown sort %q, use churn", order), http.StatusBadRequest)
+		return
+	}
+
+	idx.mu.RLock()
+	opportunities := []RefactoringOpportunity{}
+	for _, opportunity := range idx.RefactoringOpportunities {
+		if severity == "" || opportunity.Severity == severity {
+			opportunities = append(opportunities, opportunity)
+		}
+	}
+	idx.mu.RUnlock()
+
+	if order == "churn" {
+		sort.SliceStable(opportunities, func(i, j int) bool { return opportunities[i].Churn > opportunities[j].Churn })
+	}
+
+	json.NewEncoder(w).Encode(opportunities)
+}
+
+// handleDependencyQuery serves the dependency graph, filtered by the node, kind and prefix query
+// parameters.
+func (idx *CodeIndex) handleDependencyQuery(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	graph := idx.FilterDependencyGraph(DependencyFilter{
+		Node:   query.Get("node"),
+		Kind:   query.Get("kind"),
+		Prefix: query.Get("prefix"),
+	})
+
+	json.NewEncoder(w).Encode(graph)
+}
@@ -0,0 +1,74 @@
+package coderag
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAPIQueries(t *testing.T) {
+	dir := writeModule(t, map[string]string{
+		"tool/tool.go": `package tool
+
+type Tool interface{ Run() }
+
+type Echo struct{}
+
+func (Echo) Run() {}
+
+var Count int
+`,
+		"other/other.go": `package other
+
+type Stringer interface{ String() string }
+
+type Name string
+
+func (n Name) String() string { return string(n) }
+`,
+	})
+	idx := NewCodeIndex()
+	require.NoError(t, idx.indexPackages(dir))
+	idx.RefactoringOpportunities = []RefactoringOpportunity{{Description: "long", Severity: "major"}, {Description: "short", Severity: "minor"}}
+
+	get := func(handler http.HandlerFunc, target string, v interface{}) int {
+		rec := httptest.NewRecorder()
+		handler(rec, httptest.NewRequest(http.MethodGet, target, nil))
+		if rec.Code == http.StatusOK {
+			require.NoError(t, json.Unmarshal(rec.Body.Bytes(), v))
+		}
+		return rec.Code
+	}
+
+	var files []string
+	assert.Equal(t, http.StatusOK, get(idx.handleFileQuery, "/file", &files))
+	assert.Len(t, files, 2)
+
+	var file struct {
+		Symbols []FileSymbol `json:"symbols"`
+	}
+	assert.Equal(t, http.StatusOK, get(idx.handleFileQuery, "/file?path=tool/tool.go", &file))
+	require.Len(t, file.Symbols, 5)
+	assert.Equal(t, FileSymbol{ID: "example.com/demo/tool.Tool", Name: "Tool", Kind: "type", LineNumber: 3}, file.Symbols[0])
+	assert.Equal(t, "interface method", file.Symbols[1].Kind)
+	assert.Equal(t, "variable", file.Symbols[4].Kind)
+	assert.Equal(t, http.StatusNotFound, get(idx.handleFileQuery, "/file?path=missing.go", nil))
+
+	var opportunities []RefactoringOpportunity
+	assert.Equal(t, http.StatusOK, get(idx.handleRefactorQuery, "/refactor?severity=minor", &opportunities))
+	require.Len(t, opportunities, 1)
+	assert.Equal(t, "short", opportunities[0].Description)
+
+	var graph DependencyGraph
+	assert.Equal(t, http.StatusOK, get(idx.handleDependencyQuery, "/dependency", &graph))
+	assert.Len(t, graph.Edges, 2)
+	assert.Equal(t, http.StatusOK, get(idx.handleDependencyQuery, "/dependency?prefix=example.com/demo/tool.&kind=implements", &graph))
+	assert.Equal(t, []DependencyEdge{{From: "example.com/demo/tool.Echo", To: "example.com/demo/tool.Tool", Kind: "implements"}}, graph.Edges)
+	assert.Equal(t, []string{"example.com/demo/tool.Echo", "example.com/demo/tool.Tool"}, graph.Nodes)
+	assert.Equal(t, http.StatusOK, get(idx.handleDependencyQuery, "/dependency?node=example.com/demo/other.Name", &graph))
+	assert.Len(t, graph.Edges, 1)
+}
@@ -6,25 +6,37 @@ import (
 	"encoding/json"
 	"fmt"
 	"go/ast"
-	"go/parser"
 	"go/printer"
 	"go/token"
+	"go/types"
 	"io/ioutil"
 	"log"
 	"net/http"
 	"os"
 	"path/filepath"
 	"regexp"
+	"slices"
+	"sort"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
+
+	"golang.org/x/tools/go/packages"
 )
 
 // Config holds the configuration for the coderag package.
 type Config struct {
 	OpenAIAPIKey   string
-	OpenAIEndpoint string
+	OpenAIEndpoint string // chat completions endpoint summarizing functions, they aren't summarized when unset
 	OpenAIModel    string
+	CodebasePath   string // JSON dump of the index written after indexing, none when unset
+
+	SummaryWorkers           int     // concurrent summary requests, DefaultSummaryWorkers when unset
+	SummaryRequestsPerSecond float64 // rate limit of the summary requests, unlimited when unset
+
+	EmbeddingsEndpoint string // OpenAI compatible embeddings endpoint, functions aren't embedded when unset
+	EmbeddingsModel    string
 }
 
 // LoadConfig loads configuration from environment variables.
@@ -47,27 +59,73 @@ func LoadConfig() (*Config, error) {
 		model = "gpt-4o-mini"
 	}
 
+	workers := DefaultSummaryWorkers
+	if value := os.Getenv("CODERAG_SUMMARY_WORKERS"); value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 1 {
+			return nil, fmt.Errorf("invalid CODERAG_SUMMARY_WORKERS %q", value)
+		}
+		workers = n
+	}
+
+	var requestsPerSecond float64
+	if value := os.Getenv("CODERAG_SUMMARY_RPS"); value != "" {
+		rps, err := strconv.ParseFloat(value, 64)
+		if err != nil || rps < 0 {
+			return nil, fmt.Errorf("invalid CODERAG_SUMMARY_RPS %q", value)
+		}
+		requestsPerSecond = rps
+	}
+
+	embeddingsEndpoint := strings.TrimSpace(os.Getenv("EMBEDDINGS_ENDPOINT"))
+	if embeddingsEndpoint == "" {
+		embeddingsEndpoint = "http://localhost:32184/embeddings"
+	}
+
+	embeddingsModel := os.Getenv("EMBEDDINGS_MODEL")
+	if embeddingsModel == "" {
+		embeddingsModel = "nomic-embed-text-v1.5"
+	}
+
 	return &Config{
-		OpenAIAPIKey:   apiKey,
-		OpenAIEndpoint: endpoint,
-		OpenAIModel:    model,
+		OpenAIAPIKey:             apiKey,
+		OpenAIEndpoint:           endpoint,
+		OpenAIModel:              model,
+		CodebasePath:             "codebase.json",
+		SummaryWorkers:           workers,
+		SummaryRequestsPerSecond: requestsPerSecond,
+		EmbeddingsEndpoint:       embeddingsEndpoint,
+		EmbeddingsModel:          embeddingsModel,
 	}, nil
 }
 
 // FunctionInfo stores information about a function, method, or variable.
 type FunctionInfo struct {
-	Name       string   `json:"name"`
-	FilePath   string   `json:"file_path"`
-	Package    string   `json:"package"`
-	Type       string   `json:"type"` // e.g., function, method, variable
-	Parameters []string `json:"parameters,omitempty"`
-	Returns    []string `json:"returns,omitempty"`
-	Comments   string   `json:"comments,omitempty"`
-	Summary    string   `json:"summary,omitempty"`
-	Code       string   `json:"code,omitempty"`
-	CalledBy   []string `json:"called_by,omitempty"`
-	Calls      []string `json:"calls,omitempty"`
-	LineNumber int      `json:"line_number"`
+	ID         string    `json:"id"`   // fully-qualified, e.g. "(*manifold/internal/coderag.CodeIndex).GetFunctionInfo"
+	Name       string    `json:"name"` // e.g. "CodeIndex.GetFunctionInfo"
+	FilePath   string    `json:"file_path"`
+	Package    string    `json:"package"` // import path
+	Type       string    `json:"type"`    // e.g., function, method, interface method
+	Parameters []string  `json:"parameters,omitempty"`
+	Returns    []string  `json:"returns,omitempty"`
+	Comments   string    `json:"comments,omitempty"`
+	Summary    string    `json:"summary,omitempty"`
+	Code       string    `json:"code,omitempty"`
+	CodeHash   string    `json:"code_hash,omitempty"`  // the summary is kept while the code hashes the same
+	Embedding  []float32 `json:"embedding,omitempty"`  // of the code and summary, kept with the summary
+	CalledBy   []string  `json:"called_by,omitempty"`  // IDs of the callers
+	Calls      []string  `json:"calls,omitempty"`      // IDs of the callees, including those outside the repository
+	References []string  `json:"references,omitempty"` // IDs of the functions used as values, e.g. handlers
+	LineNumber int       `json:"line_number"`
+
+	Implements    []string `json:"implements,omitempty"`     // IDs of the interface methods a method implements
+	ImplementedBy []string `json:"implemented_by,omitempty"` // IDs of the methods implementing an interface method
+
+	// Git history of the lines of the function, from the blame of the committed file
+	LastCommit   string     `json:"last_commit,omitempty"`
+	LastAuthor   string     `json:"last_author,omitempty"`
+	LastModified *time.Time `json:"last_modified,omitempty"`
+	Churn        int        `json:"churn,omitempty"` // commits of the churn window that last changed its lines
 }
 
 // RelationshipInfo encapsulates the relationships of a function.
@@ -93,36 +151,58 @@ type VariableInfo struct {
 	LineNumber int    `json:"line_number"`
 }
 
-// DependencyGraph represents dependencies between functions.
+// TypeInfo stores information about a named type and the interfaces it implements.
+type TypeInfo struct {
+	ID            string   `json:"id"` // e.g. "manifold/internal/coderag.CodeIndex"
+	Name          string   `json:"name"`
+	Kind          string   `json:"kind"` // interface, struct, or the underlying type of other types
+	FilePath      string   `json:"file_path"`
+	Package       string   `json:"package"`
+	LineNumber    int      `json:"line_number"`
+	Implements    []string `json:"implements,omitempty"`     // IDs of the interfaces a concrete type implements
+	ImplementedBy []string `json:"implemented_by,omitempty"` // IDs of the concrete types implementing an interface
+}
+
+// DependencyEdge is an edge of the dependency graph.
+type DependencyEdge struct {
+	From string `json:"from"`
+	To   string `json:"to"`
+	Kind string `json:"kind,omitempty"` // e.g. implements
+}
+
+// DependencyGraph represents dependencies between functions and types.
 type DependencyGraph struct {
-	Nodes []string `json:"nodes"`
-	Edges []struct {
-		From string `json:"from"`
-		To   string `json:"to"`
-	} `json:"edges"`
+	Nodes []string         `json:"nodes"`
+	Edges []DependencyEdge `json:"edges"`
 }
 
 // RefactoringOpportunity represents potential refactoring suggestions.
 type RefactoringOpportunity struct {
 	Description string `json:"description"`
-	Location    string `json:"location"` // File and line number
-	Severity    string `json:"severity"` // e.g., minor, major, critical
+	Location    string `json:"location"`           // File and line number
+	Severity    string `json:"severity"`           // e.g., minor, major, critical
+	Kind        string `json:"kind,omitempty"`     // e.g., long function, complexity, duplicate
+	Function    string `json:"function,omitempty"` // ID of the function
+	Churn       int    `json:"churn,omitempty"`    // recent churn of the function
 }
 
 // Codebase encapsulates all extracted information.
 type Codebase struct {
 	Functions                map[string]*FunctionInfo `json:"functions"`
 	Variables                map[string]*VariableInfo `json:"variables"`
+	Types                    map[string]*TypeInfo     `json:"types"`
 	Files                    map[string][]string      `json:"files"`
 	Packages                 map[string][]string      `json:"packages"`
 	DependencyGraph          DependencyGraph          `json:"dependency_graph"`
 	RefactoringOpportunities []RefactoringOpportunity `json:"refactoring_opportunities"`
 }
 
-// CodeIndex stores the indexed information about the codebase.
+// CodeIndex stores the indexed information about the codebase. Functions are keyed by their ID and
+// variables by their package path and name.
 type CodeIndex struct {
 	Functions                map[string]*FunctionInfo
 	Variables                map[string]*VariableInfo
+	Types                    map[string]*TypeInfo
 	Files                    map[string][]string
 	Packages                 map[string][]string
 	DependencyGraph          DependencyGraph
@@ -130,6 +210,18 @@ type CodeIndex struct {
 	fset                     *token.FileSet
 	mu                       sync.RWMutex
 
+	// State of the last indexing, to re-index only the packages whose files changed
+	updateMu   sync.Mutex
+	repoPath   string
+	fileHashes map[string]string   // Go file path to the hash of its content
+	pkgFiles   map[string][]string // package path to its Go files
+	pkgImports map[string][]string // package path to the packages of the repository it imports
+	store      *Store              // saved to after indexing when set
+
+	summaryCache map[string]string     // code hash to summary
+	blames       map[string]*fileBlame // file path to the blame of its committed content
+	cfg          *Config               // of the last indexing, to embed search queries
+
 	// New fields for chunks and summaries
 	chunksMu    sync.RWMutex
 	chunks      []string
@@ -142,11 +234,17 @@ func NewCodeIndex() *CodeIndex {
 	return &CodeIndex{
 		Functions:                make(map[string]*FunctionInfo),
 		Variables:                make(map[string]*VariableInfo),
+		Types:                    make(map[string]*TypeInfo),
 		Files:                    make(map[string][]string),
 		Packages:                 make(map[string][]string),
 		DependencyGraph:          DependencyGraph{},
 		RefactoringOpportunities: []RefactoringOpportunity{},
 		fset:                     token.NewFileSet(),
+		fileHashes:               make(map[string]string),
+		pkgFiles:                 make(map[string][]string),
+		pkgImports:               make(map[string][]string),
+		summaryCache:             make(map[string]string),
+		blames:                   make(map[string]*fileBlame),
 		chunks:                   []string{},
 		summaries:                []string{},
 	}
@@ -172,39 +270,80 @@ func (ci *CodeIndex) GetChunksAndSummaries() ([]string, []string) {
 }
 
 // extractFunctionName parses the user prompt to extract the function name.
-// It handles patterns like "function SaveChatTurn" or "SaveChatTurn function".
+// It handles patterns like "function SaveChatTurn", "method CodeIndex.GetFunctionInfo" or "SaveChatTurn function".
 func extractFunctionName(prompt string) (string, error) {
-	re := regexp.MustCompile(`(?i)(?:function|method)\s+([A-Za-z0-9_]+)|([A-Za-z0-9_]+)\s+(?:function|method)`)
-	matches := re.FindStringSubmatch(prompt)
-	if len(matches) < 2 {
-		return "", fmt.Errorf("unable to extract function name from prompt")
-	}
-	if matches[1] != "" {
-		return matches[1], nil
+	// "function X" is tried first, so "the method X" does not name "the"
+	for _, re := range []*regexp.Regexp{
+		regexp.MustCompile(`(?i)(?:function|method)\s+([A-Za-z0-9_.]+)`),
+		regexp.MustCompile(`(?i)([A-Za-z0-9_.]+)\s+(?:function|method)`),
+	} {
+		if matches := re.FindStringSubmatch(prompt); matches != nil {
+			if name := strings.Trim(matches[1], "."); name != "" {
+				return name, nil
+			}
+		}
 	}
-	return matches[2], nil
+	return "", fmt.Errorf("unable to extract function name from prompt")
 }
 
-// GetFunctionInfo retrieves information about a specific function or variable by name.
+// GetFunctionInfo retrieves information about a function by its ID, or by its short name
+// ("Name" or "Type.Method", or a method's name alone) when only one function has it.
 func (idx *CodeIndex) GetFunctionInfo(funcName string) (*FunctionInfo, error) {
 	idx.mu.RLock()
 	defer idx.mu.RUnlock()
 
+	return idx.lookup(funcName)
+}
+
+// FunctionCount returns the number of indexed functions.
+func (idx *CodeIndex) FunctionCount() int {
+	idx.mu.RLock()
+	defer idx.mu.RUnlock()
+
+	return len(idx.Functions)
+}
+
+// lookup implements GetFunctionInfo. The caller holds idx.mu.
+func (idx *CodeIndex) lookup(funcName string) (*FunctionInfo, error) {
 	if info, exists := idx.Functions[funcName]; exists {
 		return info, nil
 	}
-	return nil, fmt.Errorf("function or variable %s not found", funcName)
+
+	var matches []string
+	for id, info := range idx.Functions {
+		if info.Name == funcName {
+			matches = append(matches, id)
+		}
+	}
+	if len(matches) == 0 {
+		for id, info := range idx.Functions {
+			if strings.HasSuffix(info.Name, "."+funcName) {
+				matches = append(matches, id)
+			}
+		}
+	}
+	switch len(matches) {
+	case 0:
+		return nil, fmt.Errorf("function or variable %s not found", funcName)
+	case 1:
+		return idx.Functions[matches[0]], nil
+	}
+	sort.Strings(matches)
+	return nil, fmt.Errorf("function %s is ambiguous, use one of: %s", funcName, strings.Join(matches, ", "))
 }
 
 // HandleUserPrompt processes a user prompt, matches it to a function, and returns its relationships along with code and comments.
+// Prompts that don't name a function are matched to the best result of a semantic search.
 func (idx *CodeIndex) HandleUserPrompt(prompt string) (*RelationshipInfo, error) {
+	var info *FunctionInfo
 	funcName, err := extractFunctionName(prompt)
 	if err != nil {
-		return nil, err
-	}
-
-	info, err := idx.GetFunctionInfo(funcName)
-	if err != nil {
+		results, searchErr := idx.Search(prompt, 1)
+		if searchErr != nil || len(results) == 0 {
+			return nil, err
+		}
+		info = results[0].Function
+	} else if info, err = idx.GetFunctionInfo(funcName); err != nil {
 		return nil, err
 	}
 
@@ -231,7 +370,7 @@ func (idx *CodeIndex) HandleUserPrompt(prompt string) (*RelationshipInfo, error)
 	}
 
 	relationship := &RelationshipInfo{
-		FunctionName:      funcName,
+		FunctionName:      info.ID,
 		Comments:          info.Comments,
 		Code:              info.Code,
 		Summary:           info.Summary,
@@ -246,12 +385,61 @@ func (idx *CodeIndex) HandleUserPrompt(prompt string) (*RelationshipInfo, error)
 	return relationship, nil
 }
 
+// extractTypeName parses the user prompt to extract the name of a type. It handles patterns like
+// "who implements Tool", "implementations of Tool" or "interface Tool".
+func extractTypeName(prompt string) (string, error) {
+	re := regexp.MustCompile(`(?i)(?:implements|implementations\s+of|type|interface)\s+([A-Za-z0-9_.]+)`)
+	matches := re.FindStringSubmatch(prompt)
+	if matches == nil || strings.Trim(matches[1], ".") == "" {
+		return "", fmt.Errorf("unable to extract type name from prompt")
+	}
+	return strings.Trim(matches[1], "."), nil
+}
+
+// GetTypeInfo retrieves information about a named type by its ID, or by its name when only one
+// type has it.
+func (idx *CodeIndex) GetTypeInfo(typeName string) (*TypeInfo, error) {
+	idx.mu.RLock()
+	defer idx.mu.RUnlock()
+
+	if info, exists := idx.Types[typeName]; exists {
+		return info, nil
+	}
+	var matches []string
+	for id, info := range idx.Types {
+		if info.Name == typeName {
+			matches = append(matches, id)
+		}
+	}
+	switch len(matches) {
+	case 0:
+		return nil, fmt.Errorf("type %s not found", typeName)
+	case 1:
+		return idx.Types[matches[0]], nil
+	}
+	sort.Strings(matches)
+	return nil, fmt.Errorf("type %s is ambiguous, use one of: %s", typeName, strings.Join(matches, ", "))
+}
+
+// HandleTypePrompt processes a user prompt about a type, e.g. "who implements Tool", and returns the
+// type with the interfaces it implements or the types implementing it.
+func (idx *CodeIndex) HandleTypePrompt(prompt string) (*TypeInfo, error) {
+	typeName, err := extractTypeName(prompt)
+	if err != nil {
+		return nil, err
+	}
+	return idx.GetTypeInfo(typeName)
+}
+
 // StartAPIServer starts an HTTP server for querying the codebase.
 func (idx *CodeIndex) StartAPIServer(port int) {
 	http.HandleFunc("/function", idx.handleFunctionQuery)
-	//http.HandleFunc("/file", idx.handleFileQuery)
-	//http.HandleFunc("/refactor", idx.handleRefactorQuery)
-	//http.HandleFunc("/dependency", idx.handleDependencyQuery)
+	http.HandleFunc("/type", idx.handleTypeQuery)
+	http.HandleFunc("/file", idx.handleFileQuery)
+	http.HandleFunc("/refactor", idx.handleRefactorQuery)
+	http.HandleFunc("/dependency", idx.handleDependencyQuery)
+	http.HandleFunc("/dependency/export", idx.handleDependencyExport)
+	http.HandleFunc("/search", idx.handleSearchQuery)
 
 	addr := fmt.Sprintf(":%d", port)
 	log.Printf("Starting API server at %s", addr)
@@ -271,108 +459,244 @@ func (idx *CodeIndex) handleFunctionQuery(w http.ResponseWriter, r *http.Request
 	idx.mu.RLock()
 	defer idx.mu.RUnlock()
 
-	fn, exists := idx.Functions[funcName]
-	if !exists {
-		http.Error(w, "Function not found", http.StatusNotFound)
+	fn, err := idx.lookup(funcName)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
 		return
 	}
 
 	json.NewEncoder(w).Encode(fn)
 }
 
-// Implement the extractVariables method to process variable declarations.
-func (idx *CodeIndex) extractVariables(genDecl *ast.GenDecl, path, packageName string) {
+// handleTypeQuery returns a type with its implementation edges.
+func (idx *CodeIndex) handleTypeQuery(w http.ResponseWriter, r *http.Request) {
+	typeName := r.URL.Query().Get("name")
+	if typeName == "" {
+		http.Error(w, "Missing 'name' query parameter", http.StatusBadRequest)
+		return
+	}
+
+	info, err := idx.GetTypeInfo(typeName)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+
+	json.NewEncoder(w).Encode(info)
+}
+
+// extractVariables records the package-level variables and constants of a declaration.
+func (idx *CodeIndex) extractVariables(genDecl *ast.GenDecl, pkg *packages.Package, path string) {
+	if genDecl.Tok == token.TYPE {
+		idx.extractTypes(genDecl, pkg, path)
+		return
+	}
+	if genDecl.Tok != token.VAR && genDecl.Tok != token.CONST {
+		return
+	}
 	for _, spec := range genDecl.Specs {
 		if valueSpec, ok := spec.(*ast.ValueSpec); ok {
 			for _, name := range valueSpec.Names {
+				if name.Name == "_" {
+					continue
+				}
 				position := idx.fset.Position(name.Pos())
-				var varType string
-				if valueSpec.Type != nil {
+				varType := "unknown"
+				if obj := pkg.TypesInfo.Defs[name]; obj != nil {
+					varType = types.TypeString(obj.Type(), types.RelativeTo(pkg.Types))
+				} else if valueSpec.Type != nil {
 					varType = idx.exprToString(valueSpec.Type)
-				} else {
-					varType = "unknown"
 				}
 
+				id := pkg.PkgPath + "." + name.Name
 				idx.mu.Lock()
-				idx.Variables[name.Name] = &VariableInfo{
+				idx.Variables[id] = &VariableInfo{
 					Name:       name.Name,
 					Type:       varType,
-					Scope:      "package", // Assuming package-level scope
+					Scope:      "package",
 					FilePath:   path,
 					LineNumber: position.Line,
 				}
-				idx.Files[path] = append(idx.Files[path], name.Name)
+				idx.Files[path] = append(idx.Files[path], id)
 				idx.mu.Unlock()
 			}
 		}
 	}
 }
 
-// Implement the getFunctionName method to get the function name, including receiver if any.
+// extractTypes records the named types of a declaration, and the methods of its interfaces as
+// functions so that calls through an interface have a callee.
+func (idx *CodeIndex) extractTypes(genDecl *ast.GenDecl, pkg *packages.Package, path string) {
+	for _, spec := range genDecl.Specs {
+		typeSpec, ok := spec.(*ast.TypeSpec)
+		if !ok || typeSpec.Assign.IsValid() {
+			continue // aliases name types declared elsewhere
+		}
+		obj, ok := pkg.TypesInfo.Defs[typeSpec.Name].(*types.TypeName)
+		if !ok {
+			continue
+		}
+
+		kind := types.TypeString(obj.Type().Underlying(), types.RelativeTo(pkg.Types))
+		switch obj.Type().Underlying().(type) {
+		case *types.Interface:
+			kind = "interface"
+		case *types.Struct:
+			kind = "struct"
+		}
+		typeInfo := &TypeInfo{
+			ID:         typeID(obj),
+			Name:       obj.Name(),
+			Kind:       kind,
+			FilePath:   path,
+			Package:    pkg.PkgPath,
+			LineNumber: idx.fset.Position(typeSpec.Pos()).Line,
+		}
+		idx.mu.Lock()
+		idx.Types[typeInfo.ID] = typeInfo
+		idx.Files[path] = append(idx.Files[path], typeInfo.ID)
+		idx.mu.Unlock()
+
+		iface, ok := typeSpec.Type.(*ast.InterfaceType)
+		if !ok {
+			continue
+		}
+		for _, field := range iface.Methods.List {
+			if len(field.Names) == 0 {
+				continue // embedded interfaces and type constraints
+			}
+			method, ok := pkg.TypesInfo.Defs[field.Names[0]].(*types.Func)
+			if !ok {
+				continue
+			}
+			comments := ""
+			if field.Doc != nil {
+				comments = strings.TrimSpace(field.Doc.Text())
+			}
+			id := funcID(method)
+			idx.mu.Lock()
+			idx.Functions[id] = &FunctionInfo{
+				ID:         id,
+				Name:       obj.Name() + "." + method.Name(),
+				FilePath:   path,
+				Package:    pkg.PkgPath,
+				CalledBy:   []string{},
+				Calls:      []string{},
+				LineNumber: idx.fset.Position(field.Pos()).Line,
+				Type:       "interface method",
+				Comments:   comments,
+				Code:       method.Name() + strings.TrimPrefix(idx.exprToString(field.Type), "func"),
+			}
+			idx.Files[path] = append(idx.Files[path], id)
+			idx.mu.Unlock()
+		}
+	}
+}
+
+// typeID returns the fully-qualified name of a named type, e.g. "manifold/internal/coderag.CodeIndex".
+func typeID(obj *types.TypeName) string {
+	if obj.Pkg() == nil {
+		return obj.Name() // predeclared, e.g. error
+	}
+	return obj.Pkg().Path() + "." + obj.Name()
+}
+
+// getFunctionName returns the short name of a function, "Type.Method" for a method.
 func (idx *CodeIndex) getFunctionName(fn *ast.FuncDecl) string {
 	funcName := fn.Name.Name
 	if fn.Recv != nil && len(fn.Recv.List) > 0 {
-		recvType := ""
-		switch t := fn.Recv.List[0].Type.(type) {
-		case *ast.StarExpr:
-			if ident, ok := t.X.(*ast.Ident); ok {
-				recvType = ident.Name
-			}
-		case *ast.Ident:
-			recvType = t.Name
+		recv := fn.Recv.List[0].Type
+		if star, ok := recv.(*ast.StarExpr); ok {
+			recv = star.X
+		}
+		// Receivers of generic types carry their type parameters, e.g. List[T]
+		switch t := recv.(type) {
+		case *ast.IndexExpr:
+			recv = t.X
+		case *ast.IndexListExpr:
+			recv = t.X
 		}
-		if recvType != "" {
-			funcName = recvType + "." + funcName
+		if ident, ok := recv.(*ast.Ident); ok {
+			funcName = ident.Name + "." + funcName
 		}
 	}
 	return funcName
 }
 
-// analyzeCallExpr analyzes a call expression within a function to build relationships.
-func (idx *CodeIndex) analyzeCallExpr(callExpr *ast.CallExpr, currentFunc *FunctionInfo) {
-	if currentFunc == nil || currentFunc.Type != "function" {
+// funcID returns the fully-qualified name of a function, e.g. "manifold/internal/coderag.NewCodeIndex",
+// or "(*manifold/internal/coderag.CodeIndex).GetFunctionInfo" for a method. Instances of generic
+// functions share the ID of their declaration.
+func funcID(fn *types.Func) string {
+	return fn.Origin().FullName()
+}
+
+// declID returns the ID of a declared function. Functions the type checker could not resolve
+// fall back to their short name in the package.
+func (idx *CodeIndex) declID(pkg *packages.Package, fn *ast.FuncDecl) string {
+	if obj, ok := pkg.TypesInfo.Defs[fn.Name].(*types.Func); ok {
+		return funcID(obj)
+	}
+	return pkg.PkgPath + "." + idx.getFunctionName(fn)
+}
+
+// analyzeCallExpr records a call made by a function. The callers of functions are linked once all
+// calls are known.
+func (idx *CodeIndex) analyzeCallExpr(callExpr *ast.CallExpr, info *types.Info, currentFunc *FunctionInfo) {
+	callee := resolveCalledFunction(callExpr, info)
+	if callee == nil {
 		return
 	}
+	calledFunc := funcID(callee)
 
-	calledFunc, _ := idx.resolveCalledFunction(callExpr)
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+	if slices.Contains(currentFunc.Calls, calledFunc) {
+		return
+	}
+	currentFunc.Calls = append(currentFunc.Calls, calledFunc)
+}
 
-	if calledFunc != "" {
-		idx.mu.Lock()
-		currentFunc.Calls = append(currentFunc.Calls, calledFunc)
-		if calledInfo, exists := idx.Functions[calledFunc]; exists {
-			calledInfo.CalledBy = append(calledInfo.CalledBy, currentFunc.Name)
-		}
-		idx.mu.Unlock()
+// addReference records that a function uses another one as a value.
+func (idx *CodeIndex) addReference(currentFunc *FunctionInfo, referenced string) {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+	if referenced == currentFunc.ID || slices.Contains(currentFunc.References, referenced) {
+		return
 	}
+	currentFunc.References = append(currentFunc.References, referenced)
+}
 
-	for _, arg := range callExpr.Args {
-		if nestedCall, ok := arg.(*ast.CallExpr); ok {
-			idx.analyzeCallExpr(nestedCall, currentFunc)
-		}
+// resolveCalledFunction returns the function or method a call expression invokes, nil for calls of
+// function values, conversions and builtins. Methods resolve to the receiver type they are
+// declared on, interface methods to the interface.
+func resolveCalledFunction(callExpr *ast.CallExpr, info *types.Info) *types.Func {
+	ident := calledIdent(callExpr)
+	if ident == nil {
+		return nil
 	}
+	fn, _ := info.Uses[ident].(*types.Func)
+	return fn
 }
 
-// resolveCalledFunction determines the name of the called function and whether it's a method call.
-func (idx *CodeIndex) resolveCalledFunction(callExpr *ast.CallExpr) (string, bool) {
-	var calledFunc string
-	isMethodCall := false
+// calledIdent returns the identifier naming the function of a call expression, e.g. Close in
+// f.Close(), nil when the function is an expression.
+func calledIdent(callExpr *ast.CallExpr) *ast.Ident {
+	fun := ast.Unparen(callExpr.Fun)
+	// Explicit instantiations of generic functions, e.g. Map[int, string](xs, f)
+	switch x := fun.(type) {
+	case *ast.IndexExpr:
+		fun = x.X
+	case *ast.IndexListExpr:
+		fun = x.X
+	}
 
-	switch fn := callExpr.Fun.(type) {
+	switch f := fun.(type) {
 	case *ast.Ident:
-		calledFunc = fn.Name
+		return f
 	case *ast.SelectorExpr:
-		switch x := fn.X.(type) {
-		case *ast.Ident:
-			if _, isPackage := idx.Packages[x.Name]; isPackage {
-				calledFunc = x.Name + "." + fn.Sel.Name
-			} else {
-				calledFunc = fn.Sel.Name
-				isMethodCall = true
-			}
-		}
+		return f.Sel
 	}
-
-	return calledFunc, isMethodCall
+	return nil
 }
 
 // SummarizeCode sends the code to OpenAI API and returns the summary.
@@ -444,44 +768,20 @@ func (idx *CodeIndex) SummarizeCode(code string, cfg *Config) (string, error) {
 	return strings.TrimSpace(openAIResp.Choices[0].Message.Content), nil
 }
 
-// GenerateSummaries generates summaries for all functions using OpenAI API one at a time.
-func (idx *CodeIndex) GenerateSummaries(cfg *Config) error {
-	for _, fn := range idx.Functions {
-		if fn.Type != "function" && fn.Type != "method" {
-			continue
-		}
-
-		// Retry mechanism for each function in case of failure
-		success := false
-		for attempt := 1; attempt <= 3; attempt++ {
-			log.Printf("Summarizing function %s (attempt %d)...", fn.Name, attempt)
-			summary, err := idx.SummarizeCode(fn.Code, cfg)
-			if err != nil {
-				log.Printf("Failed to summarize function %s: %v", fn.Name, err)
-				time.Sleep(2 * time.Second) // Backoff before retrying
-			} else {
-				fn.Summary = summary
-				log.Printf("Successfully summarized function %s.", fn.Name)
-				success = true
-				break
-			}
-		}
+// rebuildChunks populates the chunks and summaries with the code and summary of every function.
+func (idx *CodeIndex) rebuildChunks() {
+	idx.summariesMu.Lock()
+	defer idx.summariesMu.Unlock()
+	idx.chunksMu.Lock()
+	defer idx.chunksMu.Unlock()
 
-		// If after retries it still fails, mark the summary as unavailable
-		if !success {
-			fn.Summary = "Summary not available."
+	idx.chunks, idx.summaries = []string{}, []string{}
+	for _, fn := range idx.Functions {
+		if fn.Type == "function" || fn.Type == "method" {
+			idx.chunks = append(idx.chunks, fn.Code)
+			idx.summaries = append(idx.summaries, fn.Summary)
 		}
-
-		// Populate chunks and summaries
-		idx.chunksMu.Lock()
-		idx.chunks = append(idx.chunks, fn.Code)
-		idx.chunksMu.Unlock()
-
-		idx.summariesMu.Lock()
-		idx.summaries = append(idx.summaries, fn.Summary)
-		idx.summariesMu.Unlock()
 	}
-	return nil
 }
 
 // SerializeToJSON serializes the CodeIndex into a JSON file.
@@ -492,6 +792,7 @@ func (idx *CodeIndex) SerializeToJSON(outputPath string) error {
 	codebase := Codebase{
 		Functions:                make(map[string]*FunctionInfo),
 		Variables:                make(map[string]*VariableInfo),
+		Types:                    make(map[string]*TypeInfo),
 		Files:                    make(map[string][]string),
 		Packages:                 make(map[string][]string),
 		DependencyGraph:          idx.DependencyGraph,
@@ -506,6 +807,10 @@ func (idx *CodeIndex) SerializeToJSON(outputPath string) error {
 		codebase.Variables[name] = varInfo
 	}
 
+	for name, typeInfo := range idx.Types {
+		codebase.Types[name] = typeInfo
+	}
+
 	for file, funcs := range idx.Files {
 		codebase.Files[file] = funcs
 	}
@@ -521,81 +826,253 @@ func (idx *CodeIndex) SerializeToJSON(outputPath string) error {
 	return ioutil.WriteFile(outputPath, data, 0644)
 }
 
-// AnalyzeCodeSmells detects functions that exceed a specified number of lines.
-func (idx *CodeIndex) AnalyzeCodeSmells(maxLines int) {
-	for _, fn := range idx.Functions {
-		if fn.Type != "function" && fn.Type != "method" {
-			continue
+// IndexRepository loads and type-checks all Go packages of the module at repoPath and indexes their
+// function relationships. Later runs re-index only the packages whose files changed and re-summarize
+// only the functions whose code changed.
+func (idx *CodeIndex) IndexRepository(repoPath string, cfg *Config) error {
+	if err := idx.indexPackages(repoPath); err != nil {
+		return err
+	}
+
+	if err := idx.GenerateSummaries(cfg); err != nil {
+		return err
+	}
+
+	// Search falls back to matching names without embeddings
+	idx.cfg = cfg
+	if err := idx.GenerateEmbeddings(cfg); err != nil {
+		log.Printf("Failed to embed functions: %v", err)
+	}
+
+	if err := idx.EnrichHistory(repoPath); err != nil {
+		log.Printf("Skipping the git history of %s: %v", repoPath, err)
+	}
+
+	idx.AnalyzeCodeSmells(DefaultSmellThresholds)
+
+	if cfg.CodebasePath != "" {
+		if err := idx.SerializeToJSON(cfg.CodebasePath); err != nil {
+			return err
 		}
-		lineCount := strings.Count(fn.Code, "\n")
-		if lineCount > maxLines {
-			opportunity := RefactoringOpportunity{
-				Description: fmt.Sprintf("Function '%s' is too long (%d lines). Consider breaking it into smaller functions.", fn.Name, lineCount),
-				Location:    fmt.Sprintf("%s:%d", fn.FilePath, fn.LineNumber),
-				Severity:    "major",
-			}
-			idx.RefactoringOpportunities = append(idx.RefactoringOpportunities, opportunity)
+	}
+
+	if idx.store != nil {
+		if err := idx.store.Save(idx); err != nil {
+			return fmt.Errorf("failed to save the index: %v", err)
 		}
 	}
+
+	return nil
 }
 
-// IndexRepository walks through the repository and indexes all Go files for function relationships.
-func (idx *CodeIndex) IndexRepository(repoPath string, cfg *Config) error {
-	if err := filepath.Walk(repoPath, idx.indexDeclarations); err != nil {
+// indexPackages brings the index up to date with the Go files under repoPath. The packages with
+// changed files are loaded again, with the packages importing them since their calls and
+// implementations may have changed too. Declarations are indexed before calls, so calls across
+// packages find the functions they call.
+func (idx *CodeIndex) indexPackages(repoPath string) error {
+	idx.updateMu.Lock()
+	defer idx.updateMu.Unlock()
+
+	repoPath, err := filepath.Abs(repoPath)
+	if err != nil {
 		return err
 	}
-
-	if err := filepath.Walk(repoPath, idx.indexCallRelationships); err != nil {
+	if repoPath != idx.repoPath {
+		// Everything indexed from another repository is stale
+		idx.fileHashes = make(map[string]string)
+	}
+	hashes, err := hashGoFiles(repoPath)
+	if err != nil {
 		return err
 	}
+	dirs := changedDirs(idx.fileHashes, hashes)
+	if len(dirs) == 0 && repoPath == idx.repoPath {
+		return nil
+	}
 
-	if err := idx.GenerateSummaries(cfg); err != nil {
-		return err
+	stale := idx.stalePackages(dirs, repoPath != idx.repoPath)
+	patterns := []string{}
+	for dir := range dirs {
+		if _, err := os.Stat(dir); err == nil {
+			patterns = append(patterns, packagePattern(repoPath, dir))
+		}
+	}
+	for _, pkgPath := range stale {
+		if files := idx.pkgFiles[pkgPath]; len(files) > 0 && !dirs[filepath.Dir(files[0])] {
+			patterns = append(patterns, packagePattern(repoPath, filepath.Dir(files[0])))
+		}
 	}
 
-	idx.AnalyzeCodeSmells(100)
+	var pkgs []*packages.Package
+	if len(patterns) > 0 {
+		if pkgs, err = idx.loadPackages(repoPath, patterns); err != nil {
+			return err
+		}
+	}
+	local := make(map[string]bool)
+	for pkgPath := range idx.pkgFiles {
+		local[pkgPath] = true
+	}
+	for _, pkg := range pkgs {
+		local[pkg.PkgPath] = true
+		stale = append(stale, pkg.PkgPath)
+	}
+	previous := idx.removePackages(stale)
 
-	if err := idx.SerializeToJSON("codebase.json"); err != nil {
-		return err
+	for _, pkg := range pkgs {
+		idx.indexDeclarations(pkg, previous, local)
 	}
+	for _, pkg := range pkgs {
+		idx.indexCallRelationships(pkg)
+	}
+	idx.linkCallers()
+	idx.indexImplementations(pkgs, local)
+	idx.buildDependencyGraph()
 
+	idx.repoPath, idx.fileHashes = repoPath, hashes
+	log.Printf("Indexed %d packages of %s", len(pkgs), repoPath)
 	return nil
 }
 
-// indexDeclarations processes each file to extract function and variable declarations.
-func (idx *CodeIndex) indexDeclarations(path string, info os.FileInfo, err error) error {
-	if err != nil || !strings.HasSuffix(path, ".go") || strings.Contains(path, "vendor/") {
-		return err
+// indexImplementations links the concrete types of the packages and the packages of the repository
+// they import to the interfaces they implement, through a value or a pointer, and their methods
+// to the interface methods. Generic types are left out, as they implement interfaces only once
+// instantiated.
+func (idx *CodeIndex) indexImplementations(pkgs []*packages.Package, local map[string]bool) {
+	var interfaces, concrete []*types.TypeName
+	packages.Visit(pkgs, func(pkg *packages.Package) bool {
+		return local[pkg.PkgPath]
+	}, func(pkg *packages.Package) {
+		if !local[pkg.PkgPath] || pkg.Types == nil {
+			return
+		}
+		scope := pkg.Types.Scope()
+		for _, name := range scope.Names() {
+			obj, ok := scope.Lookup(name).(*types.TypeName)
+			if !ok || obj.IsAlias() {
+				continue
+			}
+			named, ok := obj.Type().(*types.Named)
+			if !ok || named.TypeParams().Len() > 0 {
+				continue
+			}
+			if iface, ok := named.Underlying().(*types.Interface); ok {
+				// Constraints and the empty interface say nothing about a type
+				if iface.IsMethodSet() && iface.NumMethods() > 0 {
+					interfaces = append(interfaces, obj)
+				}
+			} else {
+				concrete = append(concrete, obj)
+			}
+		}
+	})
+
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+	for _, ifaceObj := range interfaces {
+		iface := ifaceObj.Type().Underlying().(*types.Interface)
+		for _, obj := range concrete {
+			var impl types.Type = obj.Type()
+			if !types.Implements(impl, iface) {
+				impl = types.NewPointer(impl)
+				if !types.Implements(impl, iface) {
+					continue
+				}
+			}
+			idx.addImplementation(typeID(obj), typeID(ifaceObj))
+
+			for i := 0; i < iface.NumMethods(); i++ {
+				ifaceMethod := iface.Method(i)
+				found, _, _ := types.LookupFieldOrMethod(impl, true, ifaceMethod.Pkg(), ifaceMethod.Name())
+				method, ok := found.(*types.Func)
+				if !ok {
+					continue
+				}
+				methodID, ifaceMethodID := funcID(method), funcID(ifaceMethod)
+				if info, exists := idx.Functions[methodID]; exists && !slices.Contains(info.Implements, ifaceMethodID) {
+					info.Implements = append(info.Implements, ifaceMethodID)
+				}
+				if info, exists := idx.Functions[ifaceMethodID]; exists && !slices.Contains(info.ImplementedBy, methodID) {
+					info.ImplementedBy = append(info.ImplementedBy, methodID)
+				}
+			}
+		}
+	}
+}
+
+// addImplementation records that a type implements an interface. The caller holds idx.mu.
+func (idx *CodeIndex) addImplementation(typ, iface string) {
+	if info, exists := idx.Types[typ]; exists && !slices.Contains(info.Implements, iface) {
+		info.Implements = append(info.Implements, iface)
 	}
+	if info, exists := idx.Types[iface]; exists && !slices.Contains(info.ImplementedBy, typ) {
+		info.ImplementedBy = append(info.ImplementedBy, typ)
+	}
+}
 
-	file, err := parser.ParseFile(idx.fset, path, nil, parser.ParseComments)
+// loadPackages parses and type-checks the packages of the patterns in repoPath. Packages with errors
+// are indexed as far as the type checker got. Dependencies are type-checked from source, since the
+// export data of the toolchain may be newer than this loader reads.
+func (idx *CodeIndex) loadPackages(repoPath string, patterns []string) ([]*packages.Package, error) {
+	cfg := &packages.Config{
+		Mode: packages.NeedName | packages.NeedFiles | packages.NeedImports | packages.NeedDeps | packages.NeedSyntax | packages.NeedTypes | packages.NeedTypesInfo,
+		Dir:  repoPath,
+		Fset: idx.fset,
+	}
+	loaded, err := packages.Load(cfg, patterns...)
 	if err != nil {
-		return fmt.Errorf("failed to parse %s: %v", path, err)
+		return nil, fmt.Errorf("failed to load packages in %s: %v", repoPath, err)
 	}
+	var pkgs []*packages.Package
+	for _, pkg := range loaded {
+		for _, pkgErr := range pkg.Errors {
+			log.Printf("Package %s: %v", pkg.PkgPath, pkgErr)
+		}
+		// Directories without Go files are loaded as empty packages
+		if len(pkg.Syntax) > 0 && pkg.TypesInfo != nil {
+			pkgs = append(pkgs, pkg)
+		}
+	}
+	return pkgs, nil
+}
 
-	packageName := file.Name.Name
+// indexDeclarations extracts the function and variable declarations of a package. previous holds the
+// functions of the package before it was loaded again, whose summaries are kept while their code
+// is the same.
+func (idx *CodeIndex) indexDeclarations(pkg *packages.Package, previous map[string]*FunctionInfo, local map[string]bool) {
 	idx.mu.Lock()
-	if _, exists := idx.Packages[packageName]; !exists {
-		idx.Packages[packageName] = []string{}
+	if _, exists := idx.Packages[pkg.PkgPath]; !exists {
+		idx.Packages[pkg.PkgPath] = []string{}
+	}
+	idx.pkgFiles[pkg.PkgPath] = nil
+	idx.pkgImports[pkg.PkgPath] = nil
+	for importPath := range pkg.Imports {
+		if local[importPath] {
+			idx.pkgImports[pkg.PkgPath] = append(idx.pkgImports[pkg.PkgPath], importPath)
+		}
 	}
 	idx.mu.Unlock()
 
-	ast.Inspect(file, func(n ast.Node) bool {
-		switch node := n.(type) {
-		case *ast.FuncDecl:
-			idx.extractFunction(node, path, packageName)
-		case *ast.GenDecl:
-			idx.extractVariables(node, path, packageName)
+	for _, file := range pkg.Syntax {
+		path := idx.fset.Position(file.Pos()).Filename
+		idx.mu.Lock()
+		idx.pkgFiles[pkg.PkgPath] = append(idx.pkgFiles[pkg.PkgPath], path)
+		idx.mu.Unlock()
+		for _, decl := range file.Decls {
+			switch node := decl.(type) {
+			case *ast.FuncDecl:
+				idx.extractFunction(node, pkg, path, previous[idx.declID(pkg, node)])
+			case *ast.GenDecl:
+				idx.extractVariables(node, pkg, path)
+			}
 		}
-		return true
-	})
-
-	return nil
+	}
 }
 
-// extractFunction extracts function/method declarations from the AST.
-func (idx *CodeIndex) extractFunction(fn *ast.FuncDecl, path, packageName string) {
-	funcName := idx.getFunctionName(fn)
+// extractFunction extracts function/method declarations from the AST. The summary of the previous
+// version of the function is kept when its code did not change.
+func (idx *CodeIndex) extractFunction(fn *ast.FuncDecl, pkg *packages.Package, path string, previous *FunctionInfo) {
+	id := idx.declID(pkg, fn)
 	position := idx.fset.Position(fn.Pos())
 
 	comments := ""
@@ -613,28 +1090,43 @@ func (idx *CodeIndex) extractFunction(fn *ast.FuncDecl, path, packageName string
 		code = fmt.Sprintf("Error extracting code: %v", err)
 	}
 
+	funcType := "function"
+	if fn.Recv != nil {
+		funcType = "method"
+	}
+
 	parameters := idx.extractParameters(fn)
 	returns := idx.extractReturns(fn)
 
+	codeHash := hashContent([]byte(code))
+	summary := ""
+	var embedding []float32
+	if previous != nil && previous.CodeHash == codeHash {
+		summary, embedding = previous.Summary, previous.Embedding
+	}
+
 	idx.mu.Lock()
-	idx.Functions[funcName] = &FunctionInfo{
-		Name:       funcName,
+	idx.Functions[id] = &FunctionInfo{
+		ID:         id,
+		Name:       idx.getFunctionName(fn),
 		FilePath:   path,
-		Package:    packageName,
+		Package:    pkg.PkgPath,
 		CalledBy:   []string{},
 		Calls:      []string{},
 		LineNumber: position.Line,
-		Type:       "function",
+		Type:       funcType,
 		Comments:   comments,
 		Code:       code,
+		CodeHash:   codeHash,
 		Parameters: parameters,
 		Returns:    returns,
-		Summary:    "",
+		Summary:    summary,
+		Embedding:  embedding,
 	}
 
-	idx.Files[path] = append(idx.Files[path], funcName)
+	idx.Files[path] = append(idx.Files[path], id)
 	if ast.IsExported(fn.Name.Name) {
-		idx.Packages[packageName] = append(idx.Packages[packageName], funcName)
+		idx.Packages[pkg.PkgPath] = append(idx.Packages[pkg.PkgPath], id)
 	}
 	idx.mu.Unlock()
 }
@@ -671,31 +1163,38 @@ func (idx *CodeIndex) exprToString(expr ast.Expr) string {
 	return buf.String()
 }
 
-// indexCallRelationships processes each file to analyze call expressions within functions.
-func (idx *CodeIndex) indexCallRelationships(path string, info os.FileInfo, err error) error {
-	if err != nil || !strings.HasSuffix(path, ".go") || strings.Contains(path, "vendor/") {
-		return err
-	}
-
-	file, err := parser.ParseFile(idx.fset, path, nil, parser.ParseComments)
-	if err != nil {
-		return fmt.Errorf("failed to parse %s: %v", path, err)
-	}
-
-	var currentFunc *FunctionInfo
-
-	ast.Inspect(file, func(n ast.Node) bool {
-		switch node := n.(type) {
-		case *ast.FuncDecl:
-			funcName := idx.getFunctionName(node)
+// indexCallRelationships analyzes the calls made in the bodies of a package's functions.
+func (idx *CodeIndex) indexCallRelationships(pkg *packages.Package) {
+	for _, file := range pkg.Syntax {
+		for _, decl := range file.Decls {
+			fn, ok := decl.(*ast.FuncDecl)
+			if !ok || fn.Body == nil {
+				continue
+			}
 			idx.mu.RLock()
-			currentFunc = idx.Functions[funcName]
+			currentFunc := idx.Functions[idx.declID(pkg, fn)]
 			idx.mu.RUnlock()
-		case *ast.CallExpr:
-			idx.analyzeCallExpr(node, currentFunc)
-		}
-		return true
-	})
+			if currentFunc == nil {
+				continue
+			}
 
-	return nil
+			// Calls in function literals belong to the enclosing function. Call expressions are visited
+			// before their identifiers, so the remaining uses of functions are references.
+			called := make(map[*ast.Ident]bool)
+			ast.Inspect(fn.Body, func(n ast.Node) bool {
+				switch x := n.(type) {
+				case *ast.CallExpr:
+					if ident := calledIdent(x); ident != nil {
+						called[ident] = true
+					}
+					idx.analyzeCallExpr(x, pkg.TypesInfo, currentFunc)
+				case *ast.Ident:
+					if referenced, ok := pkg.TypesInfo.Uses[x].(*types.Func); ok && !called[x] {
+						idx.addReference(currentFunc, funcID(referenced))
+					}
+				}
+				return true
+			})
+		}
+	}
 }
@@ -0,0 +1,198 @@
+package coderag
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// writeModule writes a module of the given files to a temporary directory.
+func writeModule(t *testing.T, files map[string]string) string {
+	dir := t.TempDir()
+	files["go.mod"] = "module example.com/demo\n\ngo 1.22\n"
+	for name, content := range files {
+		path := filepath.Join(dir, name)
+		require.NoError(t, os.MkdirAll(filepath.Dir(path), 0755))
+		require.NoError(t, os.WriteFile(path, []byte(content), 0644))
+	}
+	return dir
+}
+
+func TestIndexPackagesResolvesCalls(t *testing.T) {
+	dir := writeModule(t, map[string]string{
+		"store/store.go": `package store
+
+type File struct{}
+
+func (f *File) Close() error { return nil }
+
+type Conn struct{}
+
+func (c Conn) Close() error { return nil }
+
+func Open() *File { return &File{} }
+
+func Map[T, U any](xs []T, f func(T) U) []U { return nil }
+`,
+		"app/app.go": `package app
+
+import (
+	"strings"
+
+	"example.com/demo/store"
+)
+
+var Version = "1.0"
+
+func Run() {
+	f := store.Open()
+	defer f.Close()
+	store.Map(nil, func(s string) int { return len(strings.TrimSpace(s)) })
+}
+`,
+	})
+
+	idx := NewCodeIndex()
+	require.NoError(t, idx.indexPackages(dir))
+
+	run, err := idx.GetFunctionInfo("Run")
+	require.NoError(t, err)
+	assert.Equal(t, "example.com/demo/app.Run", run.ID)
+	assert.Equal(t, "example.com/demo/app", run.Package)
+	assert.ElementsMatch(t, []string{
+		"example.com/demo/store.Open",
+		"(*example.com/demo/store.File).Close",
+		"example.com/demo/store.Map",
+		"strings.TrimSpace",
+	}, run.Calls)
+
+	// Methods of the same name on different types stay apart
+	closeFile := idx.Functions["(*example.com/demo/store.File).Close"]
+	require.NotNil(t, closeFile)
+	assert.Equal(t, "File.Close", closeFile.Name)
+	assert.Equal(t, "method", closeFile.Type)
+	assert.Equal(t, []string{run.ID}, closeFile.CalledBy)
+	assert.Empty(t, idx.Functions["(example.com/demo/store.Conn).Close"].CalledBy)
+	assert.Equal(t, []string{run.ID}, idx.Functions["example.com/demo/store.Map"].CalledBy)
+
+	_, err = idx.GetFunctionInfo("Close")
+	assert.ErrorContains(t, err, "ambiguous")
+	info, err := idx.GetFunctionInfo("Conn.Close")
+	require.NoError(t, err)
+	assert.Equal(t, "(example.com/demo/store.Conn).Close", info.ID)
+
+	version := idx.Variables["example.com/demo/app.Version"]
+	require.NotNil(t, version)
+	assert.Equal(t, "string", version.Type)
+}
+
+func TestExtractFunctionName(t *testing.T) {
+	name, err := extractFunctionName("What does the method CodeIndex.GetFunctionInfo do?")
+	require.NoError(t, err)
+	assert.Equal(t, "CodeIndex.GetFunctionInfo", name)
+
+	name, err = extractFunctionName("Explain the SaveChatTurn function.")
+	require.NoError(t, err)
+	assert.Equal(t, "SaveChatTurn", name)
+}
+
+func TestIndexImplementations(t *testing.T) {
+	dir := writeModule(t, map[string]string{
+		"tool/tool.go": `package tool
+
+type Tool interface {
+	// Run runs the tool.
+	Run(input string) (string, error)
+}
+
+type Echo struct{}
+
+func (Echo) Run(input string) (string, error) { return input, nil }
+
+type Search struct{}
+
+func (s *Search) Run(input string) (string, error) { return "", nil }
+
+type Plain struct{}
+
+func Call(t Tool) { t.Run("x") }
+`,
+	})
+
+	idx := NewCodeIndex()
+	require.NoError(t, idx.indexPackages(dir))
+
+	tool, err := idx.HandleTypePrompt("who implements Tool?")
+	require.NoError(t, err)
+	assert.Equal(t, "interface", tool.Kind)
+	assert.ElementsMatch(t, []string{"example.com/demo/tool.Echo", "example.com/demo/tool.Search"}, tool.ImplementedBy)
+	assert.Equal(t, []string{"example.com/demo/tool.Tool"}, idx.Types["example.com/demo/tool.Search"].Implements)
+	assert.Empty(t, idx.Types["example.com/demo/tool.Plain"].Implements)
+
+	// Calls through the interface reach its implementations
+	run := idx.Functions["(example.com/demo/tool.Tool).Run"]
+	require.NotNil(t, run)
+	assert.Equal(t, "interface method", run.Type)
+	assert.Equal(t, "Run runs the tool.", run.Comments)
+	assert.Equal(t, []string{"example.com/demo/tool.Call"}, run.CalledBy)
+	assert.ElementsMatch(t, []string{"(example.com/demo/tool.Echo).Run", "(*example.com/demo/tool.Search).Run"}, run.ImplementedBy)
+	assert.Equal(t, []string{run.ID}, idx.Functions["(*example.com/demo/tool.Search).Run"].Implements)
+
+	assert.Contains(t, idx.DependencyGraph.Edges, DependencyEdge{From: "example.com/demo/tool.Echo", To: "example.com/demo/tool.Tool", Kind: "implements"})
+}
+
+func TestIndexPackagesIncremental(t *testing.T) {
+	dir := writeModule(t, map[string]string{
+		"store/store.go": `package store
+
+func Open() string { return "open" }
+
+func Close() {}
+`,
+		"app/app.go": `package app
+
+import "example.com/demo/store"
+
+func Run() string { return store.Open() }
+`,
+		"util/util.go": `package util
+
+func Trim() {}
+`,
+	})
+
+	idx := NewCodeIndex()
+	require.NoError(t, idx.indexPackages(dir))
+	for _, fn := range idx.Functions {
+		fn.Summary = "summary of " + fn.Name
+	}
+	trim := idx.Functions["example.com/demo/util.Trim"]
+
+	// Nothing changed, nothing is loaded again
+	require.NoError(t, idx.indexPackages(dir))
+	assert.Same(t, trim, idx.Functions["example.com/demo/util.Trim"])
+
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "store", "store.go"), []byte(`package store
+
+func Open() string { return "opened" }
+
+func Close() {}
+`), 0644))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "store", "extra.go"), []byte("package store\n\nfunc Extra() {}\n"), 0644))
+	require.NoError(t, idx.indexPackages(dir))
+
+	assert.Same(t, trim, idx.Functions["example.com/demo/util.Trim"], "packages without changes are kept")
+	assert.Empty(t, idx.Functions["example.com/demo/store.Open"].Summary, "changed functions are summarized again")
+	assert.Equal(t, "summary of Close", idx.Functions["example.com/demo/store.Close"].Summary)
+	assert.Equal(t, "summary of Run", idx.Functions["example.com/demo/app.Run"].Summary)
+	assert.Equal(t, []string{"example.com/demo/app.Run"}, idx.Functions["example.com/demo/store.Open"].CalledBy)
+	assert.NotNil(t, idx.Functions["example.com/demo/store.Extra"])
+
+	require.NoError(t, os.Remove(filepath.Join(dir, "store", "extra.go")))
+	require.NoError(t, idx.indexPackages(dir))
+	assert.Nil(t, idx.Functions["example.com/demo/store.Extra"])
+	assert.Len(t, idx.Functions, 4)
+}
@@ -0,0 +1,160 @@
+// export.go
+package coderag
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// Formats of the dependency graph export.
+const (
+	GraphFormatDOT     = "dot"
+	GraphFormatGraphML = "graphml"
+	GraphFormatJSON    = "json"
+)
+
+// ExportDependencyGraph writes the edges of the dependency graph matching the filter in a format
+// Graphviz (dot) or Gephi (graphml) can open, or as JSON. Nodes are labeled with their short name.
+func (idx *CodeIndex) ExportDependencyGraph(w io.Writer, format string, filter DependencyFilter) error {
+	graph := idx.FilterDependencyGraph(filter)
+	switch format {
+	case GraphFormatDOT:
+		return writeDOT(w, graph, idx.nodeLabel)
+	case GraphFormatGraphML:
+		return writeGraphML(w, graph, idx.nodeLabel)
+	case GraphFormatJSON:
+		return json.NewEncoder(w).Encode(graph)
+	}
+	return fmt.Errorf("unknown graph format %q", format)
+}
+
+// nodeLabel returns the short name of a function or type of the graph.
+func (idx *CodeIndex) nodeLabel(id string) string {
+	idx.mu.RLock()
+	defer idx.mu.RUnlock()
+
+	if fn, exists := idx.Functions[id]; exists {
+		return fn.Name
+	}
+	if info, exists := idx.Types[id]; exists {
+		return info.Name
+	}
+	return id
+}
+
+// writeDOT writes the graph in the Graphviz DOT language. Implementation edges are dashed.
+func writeDOT(w io.Writer, graph DependencyGraph, label func(string) string) error {
+	quote := strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace
+
+	var b strings.Builder
+	b.WriteString("digraph dependencies {\n\trankdir=LR;\n\tnode [shape=box];\n")
+	for _, node := range graph.Nodes {
+		fmt.Fprintf(&b, "\t\"%s\" [label=\"%s\"];\n", quote(node), quote(label(node)))
+	}
+	for _, edge := range graph.Edges {
+		style := ""
+		if edge.Kind == "implements" {
+			style = ", style=dashed"
+		}
+		fmt.Fprintf(&b, "\t\"%s\" -> \"%s\" [label=\"%s\"%s];\n", quote(edge.From), quote(edge.To), quote(edge.Kind), style)
+	}
+	b.WriteString("}\n")
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+type graphML struct {
+	XMLName xml.Name     `xml:"graphml"`
+	Xmlns   string       `xml:"xmlns,attr"`
+	Keys    []graphMLKey `xml:"key"`
+	Graph   struct {
+		ID          string        `xml:"id,attr"`
+		EdgeDefault string        `xml:"edgedefault,attr"`
+		Nodes       []graphMLNode `xml:"node"`
+		Edges       []graphMLEdge `xml:"edge"`
+	} `xml:"graph"`
+}
+
+type graphMLKey struct {
+	ID       string `xml:"id,attr"`
+	For      string `xml:"for,attr"`
+	AttrName string `xml:"attr.name,attr"`
+	AttrType string `xml:"attr.type,attr"`
+}
+
+type graphMLData struct {
+	Key   string `xml:"key,attr"`
+	Value string `xml:",chardata"`
+}
+
+type graphMLNode struct {
+	ID   string        `xml:"id,attr"`
+	Data []graphMLData `xml:"data"`
+}
+
+type graphMLEdge struct {
+	Source string        `xml:"source,attr"`
+	Target string        `xml:"target,attr"`
+	Data   []graphMLData `xml:"data"`
+}
+
+// writeGraphML writes the graph as GraphML, with the label of nodes and the kind of edges as
+// attributes.
+func writeGraphML(w io.Writer, graph DependencyGraph, label func(string) string) error {
+	doc := graphML{
+		Xmlns: "http://graphml.graphdrawing.org/xmlns",
+		Keys: []graphMLKey{
+			{ID: "label", For: "node", AttrName: "label", AttrType: "string"},
+			{ID: "kind", For: "edge", AttrName: "kind", AttrType: "string"},
+		},
+	}
+	doc.Graph.ID, doc.Graph.EdgeDefault = "dependencies", "directed"
+	for _, node := range graph.Nodes {
+		doc.Graph.Nodes = append(doc.Graph.Nodes, graphMLNode{ID: node, Data: []graphMLData{{Key: "label", Value: label(node)}}})
+	}
+	for _, edge := range graph.Edges {
+		doc.Graph.Edges = append(doc.Graph.Edges, graphMLEdge{Source: edge.From, Target: edge.To, Data: []graphMLData{{Key: "kind", Value: edge.Kind}}})
+	}
+
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+	encoder := xml.NewEncoder(w)
+	encoder.Indent("", "  ")
+	if err := encoder.Encode(doc); err != nil {
+		return err
+	}
+	_, err := io.WriteString(w, "\n")
+	return err
+}
+
+// handleDependencyExport exports the dependency graph in the format query parameter, dot, graphml
+// or json, filtered like /dependency.
+func (idx *CodeIndex) handleDependencyExport(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	format := query.Get("format")
+	if format == "" {
+		format = GraphFormatDOT
+	}
+	contentTypes := map[string]string{
+		GraphFormatDOT:     "text/vnd.graphviz",
+		GraphFormatGraphML: "application/graphml+xml",
+		GraphFormatJSON:    "application/json",
+	}
+	contentType, ok := contentTypes[format]
+	if !ok {
+		http.Error(w, fmt.Sprintf("Unknown format %q, use dot, graphml or json", format), http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=dependencies.%s", format))
+	filter := DependencyFilter{Node: query.Get("node"), Kind: query.Get("kind"), Prefix: query.Get("prefix")}
+	if err := idx.ExportDependencyGraph(w, format, filter); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
@@ -0,0 +1,58 @@
+package coderag
+
+import (
+	"encoding/xml"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestExportDependencyGraph(t *testing.T) {
+	dir := writeModule(t, map[string]string{
+		"tool/tool.go": `package tool
+
+type Tool interface{ Run() }
+
+type Echo struct{}
+
+func (Echo) Run() {}
+
+func Call(t Tool) { t.Run() }
+`,
+	})
+	idx := NewCodeIndex()
+	require.NoError(t, idx.indexPackages(dir))
+	assert.Equal(t, []DependencyEdge{
+		{From: "example.com/demo/tool.Call", To: "(example.com/demo/tool.Tool).Run", Kind: "calls"},
+		{From: "example.com/demo/tool.Echo", To: "example.com/demo/tool.Tool", Kind: "implements"},
+	}, idx.DependencyGraph.Edges)
+
+	export := func(target string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		idx.handleDependencyExport(rec, httptest.NewRequest(http.MethodGet, target, nil))
+		return rec
+	}
+
+	rec := export("/dependency/export")
+	require.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "text/vnd.graphviz", rec.Header().Get("Content-Type"))
+	dot := rec.Body.String()
+	assert.Contains(t, dot, "digraph dependencies {")
+	assert.Contains(t, dot, `"(example.com/demo/tool.Tool).Run" [label="Tool.Run"];`)
+	assert.Contains(t, dot, `"example.com/demo/tool.Echo" -> "example.com/demo/tool.Tool" [label="implements", style=dashed];`)
+
+	rec = export("/dependency/export?format=graphml&kind=calls")
+	require.Equal(t, http.StatusOK, rec.Code)
+	var doc graphML
+	require.NoError(t, xml.Unmarshal(rec.Body.Bytes(), &doc))
+	assert.Len(t, doc.Graph.Nodes, 2)
+	require.Len(t, doc.Graph.Edges, 1)
+	assert.Equal(t, "example.com/demo/tool.Call", doc.Graph.Edges[0].Source)
+	assert.Equal(t, "calls", doc.Graph.Edges[0].Data[0].Value)
+
+	assert.Equal(t, "application/json", export("/dependency/export?format=json").Header().Get("Content-Type"))
+	assert.Equal(t, http.StatusBadRequest, export("/dependency/export?format=svg").Code)
+}
@@ -0,0 +1,140 @@
+// history.go
+package coderag
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+	"time"
+
+	gogit "github.com/go-git/go-git/v5"
+)
+
+// churnWindow is how far back from the last commit changes count as churn.
+const churnWindow = 90 * 24 * time.Hour
+
+// blameLine is the commit that last changed a line.
+type blameLine struct {
+	Hash   string
+	Author string
+	Date   time.Time
+}
+
+// fileBlame is the blame of a file at a commit. Files with uncommitted changes have no lines.
+type fileBlame struct {
+	key   string // commit and hash of the file content it was computed for
+	lines []blameLine
+}
+
+// EnrichHistory records the last commit, author and recent churn of the functions from the blame of
+// their files in the git repository containing repoPath. Files are blamed again when they or the
+// head commit change. Functions of untracked files or of files with uncommitted changes have no
+// history until they are committed.
+func (idx *CodeIndex) EnrichHistory(repoPath string) error {
+	idx.updateMu.Lock()
+	defer idx.updateMu.Unlock()
+
+	repo, err := gogit.PlainOpenWithOptions(repoPath, &gogit.PlainOpenOptions{DetectDotGit: true})
+	if err != nil {
+		return err
+	}
+	head, err := repo.Head()
+	if err != nil {
+		return err
+	}
+	commit, err := repo.CommitObject(head.Hash())
+	if err != nil {
+		return err
+	}
+	tree, err := commit.Tree()
+	if err != nil {
+		return err
+	}
+	worktree, err := repo.Worktree()
+	if err != nil {
+		return err
+	}
+	root, err := filepath.EvalSymlinks(worktree.Filesystem.Root())
+	if err != nil {
+		return err
+	}
+
+	idx.mu.RLock()
+	files := make(map[string]string, len(idx.Files))
+	for path := range idx.Files {
+		files[path] = idx.fileHashes[path]
+	}
+	idx.mu.RUnlock()
+
+	for path, hash := range files {
+		key := head.Hash().String() + ":" + hash
+		if blame, exists := idx.blames[path]; exists && blame.key == key {
+			continue
+		}
+		blame := &fileBlame{key: key}
+		idx.blames[path] = blame
+
+		resolved, err := filepath.EvalSymlinks(path)
+		if err != nil {
+			continue
+		}
+		rel, err := filepath.Rel(root, resolved)
+		if err != nil || strings.HasPrefix(rel, "..") {
+			continue
+		}
+		rel = filepath.ToSlash(rel)
+		file, err := tree.File(rel)
+		if err != nil {
+			continue // untracked
+		}
+		content, err := file.Contents()
+		if err != nil {
+			return fmt.Errorf("failed to read %s at %s: %v", rel, head.Hash(), err)
+		}
+		if hashContent([]byte(content)) != hash {
+			continue // the lines of the index aren't the committed ones
+		}
+
+		result, err := gogit.Blame(commit, rel)
+		if err != nil {
+			return fmt.Errorf("failed to blame %s: %v", rel, err)
+		}
+		for _, line := range result.Lines {
+			blame.lines = append(blame.lines, blameLine{Hash: line.Hash.String(), Author: line.AuthorName, Date: line.Date})
+		}
+	}
+	for path := range idx.blames {
+		if _, exists := files[path]; !exists {
+			delete(idx.blames, path)
+		}
+	}
+
+	since := commit.Committer.When.Add(-churnWindow)
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+	for _, fn := range idx.Functions {
+		fn.LastCommit, fn.LastAuthor, fn.LastModified, fn.Churn = "", "", nil, 0
+		blame := idx.blames[fn.FilePath]
+		if blame == nil {
+			continue
+		}
+
+		first, last := fn.LineNumber, fn.LineNumber+strings.Count(fn.Code, "\n")
+		recent := make(map[string]bool)
+		var latest *blameLine
+		for i := first; i <= last && i <= len(blame.lines); i++ {
+			line := &blame.lines[i-1]
+			if latest == nil || line.Date.After(latest.Date) {
+				latest = line
+			}
+			if !line.Date.Before(since) {
+				recent[line.Hash] = true
+			}
+		}
+		if latest != nil {
+			date := latest.Date
+			fn.LastCommit, fn.LastAuthor, fn.LastModified, fn.Churn = latest.Hash, latest.Author, &date, len(recent)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,83 @@
+package coderag
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	gogit "github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEnrichHistory(t *testing.T) {
+	dir := writeModule(t, map[string]string{
+		"app/app.go": "package app\n\nfunc Stable() int {\n\treturn 1\n}\n\nfunc Hot() int {\n\treturn 1\n}\n",
+	})
+	repo, err := gogit.PlainInit(dir, false)
+	require.NoError(t, err)
+	worktree, err := repo.Worktree()
+	require.NoError(t, err)
+	commit := func(author string, when time.Time) string {
+		_, err := worktree.Add(".")
+		require.NoError(t, err)
+		hash, err := worktree.Commit("change", &gogit.CommitOptions{Author: &object.Signature{Name: author, Email: author + "@example.com", When: when}})
+		require.NoError(t, err)
+		return hash.String()
+	}
+
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	commit("alice", start)
+	path := filepath.Join(dir, "app", "app.go")
+	require.NoError(t, os.WriteFile(path, []byte("package app\n\nfunc Stable() int {\n\treturn 1\n}\n\nfunc Hot() int {\n\tx := 2\n\treturn 1\n}\n"), 0644))
+	commit("bob", start.AddDate(0, 6, 0))
+	require.NoError(t, os.WriteFile(path, []byte("package app\n\nfunc Stable() int {\n\treturn 1\n}\n\nfunc Hot() int {\n\tx := 2\n\treturn x\n}\n"), 0644))
+	last := commit("carol", start.AddDate(0, 6, 1))
+
+	idx := NewCodeIndex()
+	require.NoError(t, idx.indexPackages(dir))
+	require.NoError(t, idx.EnrichHistory(dir))
+
+	stable := idx.Functions["example.com/demo/app.Stable"]
+	assert.Equal(t, "alice", stable.LastAuthor)
+	assert.Zero(t, stable.Churn, "changes older than the churn window don't count")
+	hot := idx.Functions["example.com/demo/app.Hot"]
+	assert.Equal(t, last, hot.LastCommit)
+	assert.Equal(t, "carol", hot.LastAuthor)
+	require.NotNil(t, hot.LastModified)
+	assert.True(t, hot.LastModified.Equal(start.AddDate(0, 6, 1)))
+	assert.Equal(t, 2, hot.Churn)
+
+	// Uncommitted changes leave the file without history
+	require.NoError(t, os.WriteFile(path, []byte("package app\n\nfunc Stable() int {\n\treturn 2\n}\n\nfunc Hot() int {\n\treturn 3\n}\n"), 0644))
+	require.NoError(t, idx.indexPackages(dir))
+	require.NoError(t, idx.EnrichHistory(dir))
+	assert.Empty(t, idx.Functions["example.com/demo/app.Hot"].LastCommit)
+	commit("dave", start.AddDate(0, 6, 2))
+	require.NoError(t, idx.EnrichHistory(dir))
+	assert.Equal(t, "dave", idx.Functions["example.com/demo/app.Hot"].LastAuthor)
+
+	assert.Error(t, NewCodeIndex().EnrichHistory(t.TempDir()), "not a git repository")
+}
+
+func TestRefactorQuerySortsByChurn(t *testing.T) {
+	idx := NewCodeIndex()
+	idx.RefactoringOpportunities = []RefactoringOpportunity{{Description: "cold", Churn: 1}, {Description: "hot", Churn: 5}, {Description: "new"}}
+
+	rec := httptest.NewRecorder()
+	idx.handleRefactorQuery(rec, httptest.NewRequest(http.MethodGet, "/refactor?sort=churn", nil))
+	require.Equal(t, http.StatusOK, rec.Code)
+	var opportunities []RefactoringOpportunity
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &opportunities))
+	require.Len(t, opportunities, 3)
+	assert.Equal(t, []string{"hot", "cold", "new"}, []string{opportunities[0].Description, opportunities[1].Description, opportunities[2].Description})
+
+	rec = httptest.NewRecorder()
+	idx.handleRefactorQuery(rec, httptest.NewRequest(http.MethodGet, "/refactor?sort=name", nil))
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+}
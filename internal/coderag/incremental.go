@@ -0,0 +1,284 @@
+// incremental.go
+package coderag
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// hashContent returns the hex SHA-256 of content.
+func hashContent(content []byte) string {
+	sum := sha256.Sum256(content)
+	return hex.EncodeToString(sum[:])
+}
+
+// skipDir reports whether the go tool ignores a directory of the module at repoPath: vendor and
+// testdata directories, directories starting with "." or "_", and nested modules.
+func skipDir(repoPath, path string, name string) bool {
+	if path == repoPath {
+		return false
+	}
+	if name == "vendor" || name == "testdata" || strings.HasPrefix(name, ".") || strings.HasPrefix(name, "_") {
+		return true
+	}
+	_, err := os.Stat(filepath.Join(path, "go.mod"))
+	return err == nil
+}
+
+// hashGoFiles hashes the content of the Go files of the module at repoPath, tests excluded.
+func hashGoFiles(repoPath string) (map[string]string, error) {
+	hashes := make(map[string]string)
+	err := filepath.WalkDir(repoPath, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			if skipDir(repoPath, path, d.Name()) {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !strings.HasSuffix(path, ".go") || strings.HasSuffix(path, "_test.go") {
+			return nil
+		}
+		content, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		hashes[path] = hashContent(content)
+		return nil
+	})
+	return hashes, err
+}
+
+// changedDirs returns the directories of the files added, removed or changed between two sets of
+// hashes.
+func changedDirs(before, after map[string]string) map[string]bool {
+	dirs := make(map[string]bool)
+	for path, hash := range after {
+		if before[path] != hash {
+			dirs[filepath.Dir(path)] = true
+		}
+	}
+	for path := range before {
+		if _, exists := after[path]; !exists {
+			dirs[filepath.Dir(path)] = true
+		}
+	}
+	return dirs
+}
+
+// packagePattern returns the go/packages pattern of the package in dir.
+func packagePattern(repoPath, dir string) string {
+	rel, err := filepath.Rel(repoPath, dir)
+	if err != nil || rel == "." {
+		return "."
+	}
+	return "./" + filepath.ToSlash(rel)
+}
+
+// stalePackages returns the indexed packages with files in dirs, or all of them, and the packages
+// importing them, directly or not.
+func (idx *CodeIndex) stalePackages(dirs map[string]bool, all bool) []string {
+	idx.mu.RLock()
+	defer idx.mu.RUnlock()
+
+	stale := make(map[string]bool)
+	for pkgPath, files := range idx.pkgFiles {
+		if all || slices.ContainsFunc(files, func(file string) bool { return dirs[filepath.Dir(file)] }) {
+			stale[pkgPath] = true
+		}
+	}
+	for changed := true; changed; {
+		changed = false
+		for pkgPath, imports := range idx.pkgImports {
+			if !stale[pkgPath] && slices.ContainsFunc(imports, func(imp string) bool { return stale[imp] }) {
+				stale[pkgPath] = true
+				changed = true
+			}
+		}
+	}
+
+	pkgPaths := make([]string, 0, len(stale))
+	for pkgPath := range stale {
+		pkgPaths = append(pkgPaths, pkgPath)
+	}
+	sort.Strings(pkgPaths)
+	return pkgPaths
+}
+
+// removePackages drops the symbols of packages from the index, and the implementation edges to them,
+// and returns their functions by ID.
+func (idx *CodeIndex) removePackages(pkgPaths []string) map[string]*FunctionInfo {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+
+	previous := make(map[string]*FunctionInfo)
+	removedTypes := make(map[string]bool)
+	for _, pkgPath := range pkgPaths {
+		for _, file := range idx.pkgFiles[pkgPath] {
+			for _, id := range idx.Files[file] {
+				if fn, exists := idx.Functions[id]; exists {
+					previous[id] = fn
+					delete(idx.Functions, id)
+				}
+				if _, exists := idx.Types[id]; exists {
+					removedTypes[id] = true
+					delete(idx.Types, id)
+				}
+				delete(idx.Variables, id)
+			}
+			delete(idx.Files, file)
+		}
+		delete(idx.pkgFiles, pkgPath)
+		delete(idx.pkgImports, pkgPath)
+		delete(idx.Packages, pkgPath)
+	}
+
+	for _, info := range idx.Types {
+		info.Implements = slices.DeleteFunc(info.Implements, func(id string) bool { return removedTypes[id] })
+		info.ImplementedBy = slices.DeleteFunc(info.ImplementedBy, func(id string) bool { return removedTypes[id] })
+	}
+	for _, info := range idx.Functions {
+		info.Implements = slices.DeleteFunc(info.Implements, func(id string) bool { return previous[id] != nil })
+		info.ImplementedBy = slices.DeleteFunc(info.ImplementedBy, func(id string) bool { return previous[id] != nil })
+	}
+	return previous
+}
+
+// linkCallers rebuilds the callers of every function from the calls of the others.
+func (idx *CodeIndex) linkCallers() {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+
+	ids := make([]string, 0, len(idx.Functions))
+	for id, info := range idx.Functions {
+		info.CalledBy = []string{}
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	for _, id := range ids {
+		for _, called := range idx.Functions[id].Calls {
+			if calledInfo, exists := idx.Functions[called]; exists {
+				calledInfo.CalledBy = append(calledInfo.CalledBy, id)
+			}
+		}
+	}
+}
+
+// buildDependencyGraph derives the dependency graph from the index: the calls between the functions
+// of the repository and the interfaces its types implement.
+func (idx *CodeIndex) buildDependencyGraph() {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+
+	graph := DependencyGraph{Nodes: []string{}, Edges: []DependencyEdge{}}
+	nodes := make(map[string]bool)
+	for id, info := range idx.Functions {
+		for _, called := range info.Calls {
+			if _, exists := idx.Functions[called]; exists {
+				graph.Edges = append(graph.Edges, DependencyEdge{From: id, To: called, Kind: "calls"})
+				nodes[id], nodes[called] = true, true
+			}
+		}
+	}
+	for id, info := range idx.Types {
+		for _, iface := range info.Implements {
+			graph.Edges = append(graph.Edges, DependencyEdge{From: id, To: iface, Kind: "implements"})
+			nodes[id], nodes[iface] = true, true
+		}
+	}
+	for node := range nodes {
+		graph.Nodes = append(graph.Nodes, node)
+	}
+	sort.Strings(graph.Nodes)
+	sort.Slice(graph.Edges, func(i, j int) bool {
+		if graph.Edges[i].From != graph.Edges[j].From {
+			return graph.Edges[i].From < graph.Edges[j].From
+		}
+		if graph.Edges[i].To != graph.Edges[j].To {
+			return graph.Edges[i].To < graph.Edges[j].To
+		}
+		return graph.Edges[i].Kind < graph.Edges[j].Kind
+	})
+	idx.DependencyGraph = graph
+}
+
+// Watch re-indexes the repository at repoPath whenever its Go files change, until ctx is done.
+// Changes are collected for the debounce interval, so saving many files at once triggers a single
+// update.
+func (idx *CodeIndex) Watch(ctx context.Context, repoPath string, cfg *Config, debounce time.Duration) error {
+	repoPath, err := filepath.Abs(repoPath)
+	if err != nil {
+		return err
+	}
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return err
+	}
+	defer watcher.Close()
+
+	if err := watchTree(watcher, repoPath, repoPath); err != nil {
+		return err
+	}
+	log.Printf("Watching %s for changes", repoPath)
+
+	var pending <-chan time.Time
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case event, ok := <-watcher.Events:
+			if !ok {
+				return nil
+			}
+			if event.Has(fsnotify.Create) {
+				if info, err := os.Stat(event.Name); err == nil && info.IsDir() {
+					if err := watchTree(watcher, repoPath, event.Name); err != nil {
+						log.Printf("Failed to watch %s: %v", event.Name, err)
+					}
+					pending = time.After(debounce)
+					continue
+				}
+			}
+			// Removed directories show up as events without a .go suffix
+			if strings.HasSuffix(event.Name, ".go") || event.Has(fsnotify.Remove) || event.Has(fsnotify.Rename) {
+				pending = time.After(debounce)
+			}
+		case err, ok := <-watcher.Errors:
+			if !ok {
+				return nil
+			}
+			log.Printf("Watcher error: %v", err)
+		case <-pending:
+			pending = nil
+			if err := idx.IndexRepository(repoPath, cfg); err != nil {
+				log.Printf("Re-indexing %s failed: %v", repoPath, err)
+			}
+		}
+	}
+}
+
+// watchTree adds dir and its subdirectories to the watcher, skipping those the go tool ignores.
+func watchTree(watcher *fsnotify.Watcher, repoPath, dir string) error {
+	return filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || !d.IsDir() {
+			return err
+		}
+		if skipDir(repoPath, path, d.Name()) {
+			return filepath.SkipDir
+		}
+		return watcher.Add(path)
+	})
+}
@@ -0,0 +1,312 @@
+// search.go
+package coderag
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"net/http"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// embeddingBatchSize is the number of functions embedded per request.
+const embeddingBatchSize = 16
+
+// maxEmbeddingChars bounds the text embedded for a function, long functions are cut.
+const maxEmbeddingChars = 8000
+
+// nameWeight is the share of the name match in the score of results, the rest is the similarity
+// of the embeddings.
+const nameWeight = 0.4
+
+// stopWords are left out of the terms queries are matched to names by.
+var stopWords = map[string]bool{
+	"a": true, "an": true, "and": true, "are": true, "by": true, "do": true, "does": true, "for": true,
+	"from": true, "how": true, "in": true, "is": true, "of": true, "on": true, "or": true, "that": true,
+	"the": true, "to": true, "what": true, "where": true, "which": true, "who": true, "with": true,
+}
+
+var wordPattern = regexp.MustCompile(`[A-Za-z0-9]+`)
+
+// SearchResult is a function matching a search query.
+type SearchResult struct {
+	Function   *FunctionInfo `json:"function"`
+	Score      float64       `json:"score"`
+	NameScore  float64       `json:"name_score"` // share of the query terms found in the name
+	Similarity float64       `json:"similarity"` // cosine similarity of the query and function embeddings
+}
+
+// GenerateEmbeddings embeds the name, summary and code of the functions without an embedding.
+// Functions keep their embedding while their code and summary don't change.
+func (idx *CodeIndex) GenerateEmbeddings(cfg *Config) error {
+	if cfg == nil || cfg.EmbeddingsEndpoint == "" {
+		return nil
+	}
+
+	idx.mu.RLock()
+	var pending []*FunctionInfo
+	var texts []string
+	for _, fn := range idx.Functions {
+		if (fn.Type == "function" || fn.Type == "method") && len(fn.Embedding) == 0 {
+			pending = append(pending, fn)
+			texts = append(texts, embeddingText(fn))
+		}
+	}
+	idx.mu.RUnlock()
+
+	for start := 0; start < len(pending); start += embeddingBatchSize {
+		end := min(start+embeddingBatchSize, len(pending))
+		embeddings, err := Embed(cfg, texts[start:end])
+		if err != nil {
+			return err
+		}
+		idx.mu.Lock()
+		for i, embedding := range embeddings {
+			pending[start+i].Embedding = embedding
+		}
+		idx.mu.Unlock()
+	}
+	if len(pending) > 0 {
+		log.Printf("Embedded %d functions", len(pending))
+	}
+	return nil
+}
+
+// embeddingText returns the text a function is embedded by.
+func embeddingText(fn *FunctionInfo) string {
+	text := fn.Name + "\n"
+	if fn.Summary != "" && fn.Summary != summaryUnavailable {
+		text += fn.Summary + "\n"
+	}
+	text += fn.Code
+	if len(text) > maxEmbeddingChars {
+		text = text[:maxEmbeddingChars]
+	}
+	return text
+}
+
+// Embed returns the embeddings of texts from the OpenAI compatible embeddings endpoint.
+func Embed(cfg *Config, texts []string) ([][]float32, error) {
+	payload, err := json.Marshal(map[string]interface{}{
+		"input":           texts,
+		"model":           cfg.EmbeddingsModel,
+		"encoding_format": "float",
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal payload: %v", err)
+	}
+
+	req, err := http.NewRequest("POST", cfg.EmbeddingsEndpoint, bytes.NewReader(payload))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create HTTP request: %v", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if cfg.OpenAIAPIKey != "" {
+		req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", cfg.OpenAIAPIKey))
+	}
+
+	client := &http.Client{Timeout: 60 * time.Second}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to send request to the embeddings service: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		return nil, fmt.Errorf("embeddings service error: %s", body)
+	}
+
+	var embeddingsResp struct {
+		Data []struct {
+			Embedding []float32 `json:"embedding"`
+			Index     int       `json:"index"`
+		} `json:"data"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&embeddingsResp); err != nil {
+		return nil, fmt.Errorf("failed to decode embeddings response: %v", err)
+	}
+	if len(embeddingsResp.Data) != len(texts) {
+		return nil, fmt.Errorf("embeddings service returned %d embeddings for %d texts", len(embeddingsResp.Data), len(texts))
+	}
+
+	embeddings := make([][]float32, len(texts))
+	for _, data := range embeddingsResp.Data {
+		if data.Index < 0 || data.Index >= len(texts) {
+			return nil, fmt.Errorf("embeddings service returned an embedding for text %d", data.Index)
+		}
+		embeddings[data.Index] = data.Embedding
+	}
+	return embeddings, nil
+}
+
+// SetConfig sets the configuration search queries are embedded with, for indexes loaded from a
+// store rather than indexed.
+func (idx *CodeIndex) SetConfig(cfg *Config) {
+	idx.cfg = cfg
+}
+
+// Search returns the k functions best matching a natural-language query, by the share of the query
+// terms in their name and, when the index was built with embeddings, the similarity of their
+// embedding to the query's.
+func (idx *CodeIndex) Search(query string, k int) ([]SearchResult, error) {
+	terms := queryTerms(query)
+	var queryEmbedding []float32
+	if idx.cfg != nil && idx.cfg.EmbeddingsEndpoint != "" {
+		embeddings, err := Embed(idx.cfg, []string{query})
+		if err != nil {
+			log.Printf("Failed to embed the query, matching names only: %v", err)
+		} else {
+			queryEmbedding = embeddings[0]
+		}
+	}
+	if len(terms) == 0 && queryEmbedding == nil {
+		return nil, fmt.Errorf("query %q has no terms to search for", query)
+	}
+
+	idx.mu.RLock()
+	defer idx.mu.RUnlock()
+
+	results := []SearchResult{}
+	for _, fn := range idx.Functions {
+		if fn.Type != "function" && fn.Type != "method" {
+			continue
+		}
+		result := SearchResult{Function: fn, NameScore: nameScore(query, terms, fn)}
+		result.Score = result.NameScore
+		if queryEmbedding != nil && len(fn.Embedding) > 0 {
+			result.Similarity = cosineSimilarity(queryEmbedding, fn.Embedding)
+			result.Score = nameWeight*result.NameScore + (1-nameWeight)*max(result.Similarity, 0)
+		}
+		if result.Score > 0 {
+			results = append(results, result)
+		}
+	}
+
+	sort.Slice(results, func(i, j int) bool {
+		if results[i].Score != results[j].Score {
+			return results[i].Score > results[j].Score
+		}
+		return results[i].Function.ID < results[j].Function.ID
+	})
+	if k > 0 && len(results) > k {
+		results = results[:k]
+	}
+	return results, nil
+}
+
+// queryTerms returns the lowercase words of a query, identifiers split at case changes, without
+// stop words.
+func queryTerms(query string) []string {
+	var terms []string
+	for _, word := range wordPattern.FindAllString(query, -1) {
+		for _, term := range splitIdentifier(word) {
+			if !stopWords[term] {
+				terms = append(terms, term)
+			}
+		}
+	}
+	return terms
+}
+
+// splitIdentifier splits an identifier into its lowercase words, e.g. GetHTTPClient into get, http
+// and client.
+func splitIdentifier(identifier string) []string {
+	var words []string
+	runes := []rune(identifier)
+	start := 0
+	for i := 1; i <= len(runes); i++ {
+		boundary := i == len(runes)
+		if !boundary {
+			prev, cur := runes[i-1], runes[i]
+			next := cur
+			if i+1 < len(runes) {
+				next = runes[i+1]
+			}
+			boundary = unicode.IsLower(prev) && unicode.IsUpper(cur) ||
+				unicode.IsUpper(prev) && unicode.IsUpper(cur) && unicode.IsLower(next) ||
+				unicode.IsDigit(prev) != unicode.IsDigit(cur)
+		}
+		if boundary {
+			words = append(words, strings.ToLower(string(runes[start:i])))
+			start = i
+		}
+	}
+	return words
+}
+
+// nameScore returns the share of the query terms found in the name of a function, a term matching
+// a word of the name or its prefix. A query naming the function scores 1.
+func nameScore(query string, terms []string, fn *FunctionInfo) float64 {
+	query = strings.TrimSpace(query)
+	if query == fn.Name || strings.HasSuffix(fn.Name, "."+query) {
+		return 1
+	}
+	if len(terms) == 0 {
+		return 0
+	}
+
+	words := queryTerms(fn.Name)
+	matched := 0
+	for _, term := range terms {
+		for _, word := range words {
+			if word == term || len(term) >= 3 && strings.HasPrefix(word, term) || len(word) >= 3 && strings.HasPrefix(term, word) {
+				matched++
+				break
+			}
+		}
+	}
+	return float64(matched) / float64(len(terms))
+}
+
+// cosineSimilarity returns the cosine similarity of two vectors, 0 when their sizes differ.
+func cosineSimilarity(a, b []float32) float64 {
+	if len(a) != len(b) {
+		return 0
+	}
+	var dot, normA, normB float64
+	for i := range a {
+		dot += float64(a[i]) * float64(b[i])
+		normA += float64(a[i]) * float64(a[i])
+		normB += float64(b[i]) * float64(b[i])
+	}
+	if normA == 0 || normB == 0 {
+		return 0
+	}
+	return dot / (math.Sqrt(normA) * math.Sqrt(normB))
+}
+
+// handleSearchQuery returns the functions best matching the q query parameter, k of them, 10 by
+// default.
+func (idx *CodeIndex) handleSearchQuery(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query().Get("q")
+	if query == "" {
+		http.Error(w, "Missing 'q' query parameter", http.StatusBadRequest)
+		return
+	}
+	k := 10
+	if value := r.URL.Query().Get("k"); value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 1 {
+			http.Error(w, "Invalid 'k' query parameter", http.StatusBadRequest)
+			return
+		}
+		k = n
+	}
+
+	results, err := idx.Search(query, k)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	json.NewEncoder(w).Encode(results)
+}
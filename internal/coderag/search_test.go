@@ -0,0 +1,93 @@
+package coderag
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// bagOfWordsServer embeds texts as counts of a few words, so texts sharing them are similar.
+func bagOfWordsServer(t *testing.T) *httptest.Server {
+	vocabulary := []string{"cache", "graph", "token", "file"}
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var request struct {
+			Input []string `json:"input"`
+		}
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&request))
+		var data []map[string]interface{}
+		for i, text := range request.Input {
+			embedding := make([]float32, len(vocabulary))
+			for j, word := range vocabulary {
+				embedding[j] = float32(strings.Count(strings.ToLower(text), word)) + 0.01
+			}
+			data = append(data, map[string]interface{}{"index": i, "embedding": embedding})
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"data": data})
+	}))
+}
+
+func TestSearch(t *testing.T) {
+	dir := writeModule(t, map[string]string{
+		"app/app.go": `package app
+
+var cache = map[string]string{}
+
+func Remember(key, value string) { cache[key] = value }
+
+func DrawGraph(nodes []string) string { return "graph" }
+
+func ReadFile(path string) string { return path }
+`,
+	})
+	idx := NewCodeIndex()
+	require.NoError(t, idx.indexPackages(dir))
+
+	// Without embeddings only names match
+	results, err := idx.Search("draw the graph", 5)
+	require.NoError(t, err)
+	require.Len(t, results, 1)
+	assert.Equal(t, "DrawGraph", results[0].Function.Name)
+	assert.Equal(t, 1.0, results[0].NameScore)
+	_, err = idx.Search("the", 5)
+	assert.Error(t, err)
+
+	server := bagOfWordsServer(t)
+	defer server.Close()
+	idx.cfg = &Config{EmbeddingsEndpoint: server.URL}
+	require.NoError(t, idx.GenerateEmbeddings(idx.cfg))
+	assert.Len(t, idx.Functions["example.com/demo/app.Remember"].Embedding, 4)
+
+	// Remember only matches by its code
+	results, err = idx.Search("which function writes to the cache?", 2)
+	require.NoError(t, err)
+	require.Len(t, results, 2)
+	assert.Equal(t, "Remember", results[0].Function.Name)
+	assert.Zero(t, results[0].NameScore)
+	assert.Greater(t, results[0].Similarity, 0.9)
+
+	relationship, err := idx.HandleUserPrompt("how is the cache written?")
+	require.NoError(t, err)
+	assert.Equal(t, "example.com/demo/app.Remember", relationship.FunctionName)
+
+	rec := httptest.NewRecorder()
+	idx.handleSearchQuery(rec, httptest.NewRequest(http.MethodGet, "/search?q=read+file&k=1", nil))
+	require.Equal(t, http.StatusOK, rec.Code)
+	var found []SearchResult
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &found))
+	require.Len(t, found, 1)
+	assert.Equal(t, "ReadFile", found[0].Function.Name)
+
+	rec = httptest.NewRecorder()
+	idx.handleSearchQuery(rec, httptest.NewRequest(http.MethodGet, "/search", nil))
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+}
+
+func TestSplitIdentifier(t *testing.T) {
+	assert.Equal(t, []string{"get", "http", "client", "2"}, splitIdentifier("GetHTTPClient2"))
+	assert.Equal(t, []string{"code", "index", "lookup"}, queryTerms("CodeIndex.lookup"))
+}
@@ -0,0 +1,322 @@
+// smells.go
+package coderag
+
+import (
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/scanner"
+	"go/token"
+	"hash/fnv"
+	"sort"
+	"strings"
+)
+
+// SmellThresholds are the limits above which AnalyzeCodeSmells reports a function. A zero limit
+// disables its detector.
+type SmellThresholds struct {
+	MaxLines            int     // lines of a function
+	MaxComplexity       int     // cyclomatic complexity
+	MaxParameters       int     // parameters of a function
+	MaxNesting          int     // depth of nested blocks
+	MinDuplicateTokens  int     // tokens of the functions compared for duplication
+	DuplicateSimilarity float64 // share of token shingles two functions have in common, from 0 to 1
+}
+
+// DefaultSmellThresholds are the thresholds IndexRepository analyzes the code with.
+var DefaultSmellThresholds = SmellThresholds{
+	MaxLines:            100,
+	MaxComplexity:       15,
+	MaxParameters:       5,
+	MaxNesting:          4,
+	MinDuplicateTokens:  60,
+	DuplicateSimilarity: 0.85,
+}
+
+// shingleSize is the number of consecutive tokens compared between functions.
+const shingleSize = 8
+
+// maxShingleFunctions is the number of functions above which a shingle is too common, e.g. error
+// handling, to find duplicates by.
+const maxShingleFunctions = 25
+
+// AnalyzeCodeSmells detects long, complex, deeply nested and duplicated functions, functions with
+// too many parameters, and unexported functions nothing calls.
+func (idx *CodeIndex) AnalyzeCodeSmells(thresholds SmellThresholds) {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+
+	ids := make([]string, 0, len(idx.Functions))
+	referenced := make(map[string]bool)
+	for id, fn := range idx.Functions {
+		ids = append(ids, id)
+		for _, ref := range fn.References {
+			referenced[ref] = true
+		}
+	}
+	sort.Strings(ids)
+
+	opportunities := []RefactoringOpportunity{}
+	report := func(fn *FunctionInfo, kind, severity, description string) {
+		opportunities = append(opportunities, RefactoringOpportunity{
+			Description: description,
+			Location:    fmt.Sprintf("%s:%d", fn.FilePath, fn.LineNumber),
+			Severity:    severity,
+			Kind:        kind,
+			Function:    fn.ID,
+			Churn:       fn.Churn,
+		})
+	}
+
+	var compared []*FunctionInfo
+	var shingles []map[uint64]bool
+	for _, id := range ids {
+		fn := idx.Functions[id]
+		if fn.Type != "function" && fn.Type != "method" {
+			continue
+		}
+
+		lineCount := strings.Count(fn.Code, "\n")
+		if thresholds.MaxLines > 0 && lineCount > thresholds.MaxLines {
+			report(fn, "long function", smellSeverity(lineCount, thresholds.MaxLines),
+				fmt.Sprintf("Function '%s' is too long (%d lines). Consider breaking it into smaller functions.", fn.Name, lineCount))
+		}
+		if isDeadFunction(fn, referenced) {
+			report(fn, "dead code", "minor",
+				fmt.Sprintf("Function '%s' is never called or referenced. Consider removing it.", fn.Name))
+		}
+
+		decl := parseFuncDecl(fn.Code)
+		if decl == nil {
+			continue
+		}
+		if complexity := cyclomaticComplexity(decl); thresholds.MaxComplexity > 0 && complexity > thresholds.MaxComplexity {
+			report(fn, "complexity", smellSeverity(complexity, thresholds.MaxComplexity),
+				fmt.Sprintf("Function '%s' has a cyclomatic complexity of %d. Consider splitting its branches into smaller functions.", fn.Name, complexity))
+		}
+		if parameters := parameterCount(decl); thresholds.MaxParameters > 0 && parameters > thresholds.MaxParameters {
+			report(fn, "parameters", smellSeverity(parameters, thresholds.MaxParameters),
+				fmt.Sprintf("Function '%s' takes %d parameters. Consider grouping them in a struct.", fn.Name, parameters))
+		}
+		if nesting := nestingDepth(decl.Body, 0); thresholds.MaxNesting > 0 && nesting > thresholds.MaxNesting {
+			report(fn, "nesting", smellSeverity(nesting, thresholds.MaxNesting),
+				fmt.Sprintf("Function '%s' nests blocks %d levels deep. Consider returning early or extracting the inner blocks.", fn.Name, nesting))
+		}
+
+		if thresholds.DuplicateSimilarity > 0 {
+			if set := tokenShingles(fn.Code, thresholds.MinDuplicateTokens); set != nil {
+				compared = append(compared, fn)
+				shingles = append(shingles, set)
+			}
+		}
+	}
+
+	for _, pair := range duplicatePairs(shingles, thresholds.DuplicateSimilarity) {
+		fn, other := compared[pair.i], compared[pair.j]
+		severity := "minor"
+		if pair.similarity >= 0.95 {
+			severity = "major"
+		}
+		report(fn, "duplicate", severity,
+			fmt.Sprintf("Function '%s' is %.0f%% similar to '%s' (%s:%d). Consider extracting the shared code.",
+				fn.Name, pair.similarity*100, other.Name, other.FilePath, other.LineNumber))
+	}
+
+	idx.RefactoringOpportunities = opportunities
+}
+
+// smellSeverity grades how far a value exceeds its limit: minor, major above one and a half times
+// the limit, critical above twice the limit.
+func smellSeverity(value, limit int) string {
+	switch {
+	case value > 2*limit:
+		return "critical"
+	case 2*value > 3*limit:
+		return "major"
+	}
+	return "minor"
+}
+
+// isDeadFunction reports whether nothing in the repository calls or references a function that
+// can't be used from outside its package. Entry points and methods implementing interfaces are
+// used even when nothing calls them directly.
+func isDeadFunction(fn *FunctionInfo, referenced map[string]bool) bool {
+	name := fn.Name[strings.LastIndex(fn.Name, ".")+1:]
+	if ast.IsExported(name) || name == "init" || name == "main" || name == "_" {
+		return false
+	}
+	return len(fn.CalledBy) == 0 && len(fn.Implements) == 0 && !referenced[fn.ID]
+}
+
+// parseFuncDecl parses the code of a function, nil if it isn't valid.
+func parseFuncDecl(code string) *ast.FuncDecl {
+	file, err := parser.ParseFile(token.NewFileSet(), "", "package p\n"+code, 0)
+	if err != nil || len(file.Decls) == 0 {
+		return nil
+	}
+	decl, ok := file.Decls[0].(*ast.FuncDecl)
+	if !ok || decl.Body == nil {
+		return nil
+	}
+	return decl
+}
+
+// cyclomaticComplexity counts the independent paths through a function: one plus its branches,
+// loops, cases and boolean operators, function literals included.
+func cyclomaticComplexity(decl *ast.FuncDecl) int {
+	complexity := 1
+	ast.Inspect(decl.Body, func(n ast.Node) bool {
+		switch x := n.(type) {
+		case *ast.IfStmt, *ast.ForStmt, *ast.RangeStmt:
+			complexity++
+		case *ast.CaseClause:
+			if x.List != nil {
+				complexity++
+			}
+		case *ast.CommClause:
+			if x.Comm != nil {
+				complexity++
+			}
+		case *ast.BinaryExpr:
+			if x.Op == token.LAND || x.Op == token.LOR {
+				complexity++
+			}
+		}
+		return true
+	})
+	return complexity
+}
+
+// parameterCount counts the parameters of a function, the receiver excluded.
+func parameterCount(decl *ast.FuncDecl) int {
+	count := 0
+	for _, field := range decl.Type.Params.List {
+		if len(field.Names) == 0 {
+			count++
+		}
+		count += len(field.Names)
+	}
+	return count
+}
+
+// nestingDepth returns the deepest nesting of control blocks and function literals under node, at
+// depth. Else-if chains stay at the depth of their first if.
+func nestingDepth(node ast.Node, depth int) int {
+	deepest := depth
+	ast.Inspect(node, func(n ast.Node) bool {
+		if n == node {
+			return true
+		}
+		switch x := n.(type) {
+		case *ast.IfStmt:
+			deepest = max(deepest, ifNestingDepth(x, depth))
+			return false
+		case *ast.ForStmt, *ast.RangeStmt, *ast.SwitchStmt, *ast.TypeSwitchStmt, *ast.SelectStmt, *ast.FuncLit:
+			deepest = max(deepest, nestingDepth(x, depth+1))
+			return false
+		}
+		return true
+	})
+	return deepest
+}
+
+// ifNestingDepth returns the deepest nesting under an if statement and its else branches.
+func ifNestingDepth(stmt *ast.IfStmt, depth int) int {
+	deepest := nestingDepth(stmt.Body, depth+1)
+	switch elseStmt := stmt.Else.(type) {
+	case *ast.IfStmt:
+		deepest = max(deepest, ifNestingDepth(elseStmt, depth))
+	case *ast.BlockStmt:
+		deepest = max(deepest, nestingDepth(elseStmt, depth+1))
+	}
+	return deepest
+}
+
+// tokenShingles hashes every run of shingleSize tokens of the code, with identifiers and literals
+// normalized so renamed copies still match. It returns nil for code of fewer than minTokens tokens.
+func tokenShingles(code string, minTokens int) map[uint64]bool {
+	fset := token.NewFileSet()
+	file := fset.AddFile("", fset.Base(), len(code))
+	var s scanner.Scanner
+	s.Init(file, []byte(code), nil, 0)
+
+	var tokens []string
+	for {
+		_, tok, lit := s.Scan()
+		if tok == token.EOF {
+			break
+		}
+		switch {
+		case tok == token.IDENT:
+			tokens = append(tokens, "id")
+		case tok.IsLiteral():
+			tokens = append(tokens, "lit")
+		case tok == token.SEMICOLON && lit == "\n":
+			// Semicolons inserted at line ends depend on the formatting
+		default:
+			tokens = append(tokens, tok.String())
+		}
+	}
+	if len(tokens) < max(minTokens, shingleSize) {
+		return nil
+	}
+
+	shingles := make(map[uint64]bool)
+	for i := 0; i+shingleSize <= len(tokens); i++ {
+		h := fnv.New64a()
+		h.Write([]byte(strings.Join(tokens[i:i+shingleSize], " ")))
+		shingles[h.Sum64()] = true
+	}
+	return shingles
+}
+
+// duplicatePair is a pair of similar shingle sets.
+type duplicatePair struct {
+	i, j       int
+	similarity float64
+}
+
+// duplicatePairs returns the pairs of shingle sets whose Jaccard similarity is at least threshold.
+// Only sets sharing an uncommon shingle are compared.
+func duplicatePairs(sets []map[uint64]bool, threshold float64) []duplicatePair {
+	postings := make(map[uint64][]int)
+	for i, set := range sets {
+		for shingle := range set {
+			postings[shingle] = append(postings[shingle], i)
+		}
+	}
+
+	candidates := make(map[[2]int]bool)
+	for _, members := range postings {
+		if len(members) < 2 || len(members) > maxShingleFunctions {
+			continue
+		}
+		for a := 0; a < len(members); a++ {
+			for b := a + 1; b < len(members); b++ {
+				candidates[[2]int{members[a], members[b]}] = true
+			}
+		}
+	}
+
+	var pairs []duplicatePair
+	for candidate := range candidates {
+		a, b := sets[candidate[0]], sets[candidate[1]]
+		shared := 0
+		for shingle := range a {
+			if b[shingle] {
+				shared++
+			}
+		}
+		similarity := float64(shared) / float64(len(a)+len(b)-shared)
+		if similarity >= threshold {
+			pairs = append(pairs, duplicatePair{i: candidate[0], j: candidate[1], similarity: similarity})
+		}
+	}
+	sort.Slice(pairs, func(x, y int) bool {
+		if pairs[x].i != pairs[y].i {
+			return pairs[x].i < pairs[y].i
+		}
+		return pairs[x].j < pairs[y].j
+	})
+	return pairs
+}
@@ -0,0 +1,103 @@
+package coderag
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAnalyzeCodeSmells(t *testing.T) {
+	dir := writeModule(t, map[string]string{
+		"app/app.go": `package app
+
+import "net/http"
+
+func Grade(a, b, c, d, e, f int) string {
+	for i := 0; i < a; i++ {
+		if b > 0 {
+			switch c {
+			case 1:
+				if d > 0 && e > 0 {
+					return "deep"
+				}
+			}
+		} else if c > 0 {
+			return "c"
+		} else if d > 0 || e > 0 || f > 0 {
+			return "d"
+		}
+	}
+	return ""
+}
+
+func Sum(values []int) int {
+	total := 0
+	for _, value := range values {
+		if value > 0 {
+			total += value * 2
+		} else {
+			total -= value / 2
+		}
+	}
+	return total + len(values) - 1
+}
+
+func Total(numbers []int) int {
+	result := 0
+	for _, number := range numbers {
+		if number > 0 {
+			result += number * 3
+		} else {
+			result -= number / 3
+		}
+	}
+	return result + len(numbers) - 1
+}
+
+func used() {}
+
+func unused() {}
+
+func handler(w http.ResponseWriter, r *http.Request) { used() }
+
+func Serve() { http.HandleFunc("/", handler) }
+`,
+	})
+	idx := NewCodeIndex()
+	require.NoError(t, idx.indexPackages(dir))
+	idx.AnalyzeCodeSmells(SmellThresholds{MaxLines: 100, MaxComplexity: 6, MaxParameters: 5, MaxNesting: 3, MinDuplicateTokens: 30, DuplicateSimilarity: 0.8})
+
+	kinds := make(map[string][]RefactoringOpportunity)
+	for _, opportunity := range idx.RefactoringOpportunities {
+		kinds[opportunity.Kind] = append(kinds[opportunity.Kind], opportunity)
+	}
+	assert.NotContains(t, kinds, "long function")
+
+	require.Len(t, kinds["complexity"], 1)
+	assert.Equal(t, "example.com/demo/app.Grade", kinds["complexity"][0].Function)
+	assert.Contains(t, kinds["complexity"][0].Description, "complexity of 10")
+	assert.Equal(t, "major", kinds["complexity"][0].Severity)
+	assert.Contains(t, kinds["complexity"][0].Location, "app.go:5")
+
+	require.Len(t, kinds["parameters"], 1)
+	assert.Equal(t, "minor", kinds["parameters"][0].Severity)
+
+	// The else-if chain doesn't add levels, the if in the case does
+	require.Len(t, kinds["nesting"], 1)
+	assert.Contains(t, kinds["nesting"][0].Description, "4 levels")
+
+	require.Len(t, kinds["duplicate"], 1)
+	assert.Equal(t, "example.com/demo/app.Sum", kinds["duplicate"][0].Function)
+	assert.Contains(t, kinds["duplicate"][0].Description, "'Total'")
+
+	// handler is passed as a value, used is called
+	require.Len(t, kinds["dead code"], 1)
+	assert.Equal(t, "example.com/demo/app.unused", kinds["dead code"][0].Function)
+}
+
+func TestNestingDepth(t *testing.T) {
+	decl := parseFuncDecl("func f() { if a { } else if b { for { } } else { go func() { select {} }() } }")
+	require.NotNil(t, decl)
+	assert.Equal(t, 3, nestingDepth(decl.Body, 0))
+}
@@ -0,0 +1,346 @@
+// store.go
+package coderag
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"time"
+
+	_ "github.com/mattn/go-sqlite3" // SQLite driver
+)
+
+const storeSchema = `
+CREATE TABLE IF NOT EXISTS repositories (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	path TEXT NOT NULL UNIQUE,
+	dependency_graph TEXT NOT NULL,
+	refactoring_opportunities TEXT NOT NULL,
+	indexed_at TIMESTAMP NOT NULL
+);
+CREATE TABLE IF NOT EXISTS functions (
+	repo_id INTEGER NOT NULL REFERENCES repositories(id) ON DELETE CASCADE,
+	id TEXT NOT NULL,
+	name TEXT NOT NULL,
+	package TEXT NOT NULL,
+	file_path TEXT NOT NULL,
+	data TEXT NOT NULL,
+	PRIMARY KEY (repo_id, id)
+);
+CREATE INDEX IF NOT EXISTS idx_functions_name ON functions(repo_id, name);
+CREATE TABLE IF NOT EXISTS types (
+	repo_id INTEGER NOT NULL REFERENCES repositories(id) ON DELETE CASCADE,
+	id TEXT NOT NULL,
+	data TEXT NOT NULL,
+	PRIMARY KEY (repo_id, id)
+);
+CREATE TABLE IF NOT EXISTS variables (
+	repo_id INTEGER NOT NULL REFERENCES repositories(id) ON DELETE CASCADE,
+	id TEXT NOT NULL,
+	data TEXT NOT NULL,
+	PRIMARY KEY (repo_id, id)
+);
+CREATE TABLE IF NOT EXISTS files (
+	repo_id INTEGER NOT NULL REFERENCES repositories(id) ON DELETE CASCADE,
+	path TEXT NOT NULL,
+	hash TEXT NOT NULL,
+	symbols TEXT NOT NULL,
+	PRIMARY KEY (repo_id, path)
+);
+CREATE TABLE IF NOT EXISTS packages (
+	repo_id INTEGER NOT NULL REFERENCES repositories(id) ON DELETE CASCADE,
+	path TEXT NOT NULL,
+	exported TEXT NOT NULL,
+	files TEXT NOT NULL,
+	imports TEXT NOT NULL,
+	PRIMARY KEY (repo_id, path)
+);
+CREATE TABLE IF NOT EXISTS summaries (
+	code_hash TEXT PRIMARY KEY,
+	summary TEXT NOT NULL
+);
+`
+
+// ErrNotIndexed is returned when the store has no index of a repository.
+var ErrNotIndexed = errors.New("repository not indexed")
+
+// Store persists the code indexes of repositories in SQLite, so they survive restarts and several
+// repositories can be kept indexed side by side.
+type Store struct {
+	db *sql.DB
+}
+
+// OpenStore opens the store at path, creating it if needed.
+func OpenStore(path string) (*Store, error) {
+	db, err := sql.Open("sqlite3", path+"?_foreign_keys=on&_busy_timeout=5000")
+	if err != nil {
+		return nil, fmt.Errorf("failed to open code index store: %v", err)
+	}
+	if _, err := db.Exec(storeSchema); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to create code index store: %v", err)
+	}
+	return &Store{db: db}, nil
+}
+
+// Close closes the store.
+func (s *Store) Close() error {
+	return s.db.Close()
+}
+
+// Repositories returns the paths of the indexed repositories.
+func (s *Store) Repositories() ([]string, error) {
+	rows, err := s.db.Query(`SELECT path FROM repositories ORDER BY path`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var paths []string
+	for rows.Next() {
+		var path string
+		if err := rows.Scan(&path); err != nil {
+			return nil, err
+		}
+		paths = append(paths, path)
+	}
+	return paths, rows.Err()
+}
+
+// OpenCodeIndex returns the saved index of the repository at repoPath, or a new index when the store
+// has none. IndexRepository saves the index back to the store.
+func (s *Store) OpenCodeIndex(repoPath string) (*CodeIndex, error) {
+	idx, err := s.Load(repoPath)
+	if errors.Is(err, ErrNotIndexed) {
+		idx = NewCodeIndex()
+	} else if err != nil {
+		return nil, err
+	}
+	idx.store = s
+	return idx, nil
+}
+
+// Save replaces the saved index of the repository the index was built from.
+func (s *Store) Save(idx *CodeIndex) error {
+	idx.updateMu.Lock()
+	defer idx.updateMu.Unlock()
+	idx.mu.RLock()
+	defer idx.mu.RUnlock()
+
+	if idx.repoPath == "" {
+		return errors.New("the index has no repository")
+	}
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	// Deleting the repository deletes its rows
+	if _, err := tx.Exec(`DELETE FROM repositories WHERE path = ?`, idx.repoPath); err != nil {
+		return err
+	}
+	result, err := tx.Exec(`INSERT INTO repositories (path, dependency_graph, refactoring_opportunities, indexed_at) VALUES (?, ?, ?, ?)`,
+		idx.repoPath, mustJSON(idx.DependencyGraph), mustJSON(idx.RefactoringOpportunities), time.Now())
+	if err != nil {
+		return err
+	}
+	repoID, err := result.LastInsertId()
+	if err != nil {
+		return err
+	}
+
+	for id, fn := range idx.Functions {
+		if _, err := tx.Exec(`INSERT INTO functions (repo_id, id, name, package, file_path, data) VALUES (?, ?, ?, ?, ?, ?)`,
+			repoID, id, fn.Name, fn.Package, fn.FilePath, mustJSON(fn)); err != nil {
+			return fmt.Errorf("failed to save function %s: %v", id, err)
+		}
+	}
+	for id, info := range idx.Types {
+		if _, err := tx.Exec(`INSERT INTO types (repo_id, id, data) VALUES (?, ?, ?)`, repoID, id, mustJSON(info)); err != nil {
+			return fmt.Errorf("failed to save type %s: %v", id, err)
+		}
+	}
+	for id, info := range idx.Variables {
+		if _, err := tx.Exec(`INSERT INTO variables (repo_id, id, data) VALUES (?, ?, ?)`, repoID, id, mustJSON(info)); err != nil {
+			return fmt.Errorf("failed to save variable %s: %v", id, err)
+		}
+	}
+	for path, hash := range idx.fileHashes {
+		if _, err := tx.Exec(`INSERT INTO files (repo_id, path, hash, symbols) VALUES (?, ?, ?, ?)`,
+			repoID, path, hash, mustJSON(idx.Files[path])); err != nil {
+			return fmt.Errorf("failed to save file %s: %v", path, err)
+		}
+	}
+	for pkgPath, files := range idx.pkgFiles {
+		if _, err := tx.Exec(`INSERT INTO packages (repo_id, path, exported, files, imports) VALUES (?, ?, ?, ?, ?)`,
+			repoID, pkgPath, mustJSON(idx.Packages[pkgPath]), mustJSON(files), mustJSON(idx.pkgImports[pkgPath])); err != nil {
+			return fmt.Errorf("failed to save package %s: %v", pkgPath, err)
+		}
+	}
+	return tx.Commit()
+}
+
+// Load returns the saved index of the repository at repoPath, ready to be brought up to date by
+// IndexRepository. It returns ErrNotIndexed when the store has none.
+func (s *Store) Load(repoPath string) (*CodeIndex, error) {
+	repoPath, err := filepath.Abs(repoPath)
+	if err != nil {
+		return nil, err
+	}
+
+	idx := NewCodeIndex()
+	var repoID int64
+	var graph, opportunities string
+	err = s.db.QueryRow(`SELECT id, dependency_graph, refactoring_opportunities FROM repositories WHERE path = ?`, repoPath).
+		Scan(&repoID, &graph, &opportunities)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, fmt.Errorf("%w: %s", ErrNotIndexed, repoPath)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal([]byte(graph), &idx.DependencyGraph); err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal([]byte(opportunities), &idx.RefactoringOpportunities); err != nil {
+		return nil, err
+	}
+
+	err = s.scan(`SELECT id, data FROM functions WHERE repo_id = ?`, repoID, func(id string, data []byte) error {
+		var fn FunctionInfo
+		if err := json.Unmarshal(data, &fn); err != nil {
+			return err
+		}
+		idx.Functions[id] = &fn
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to load functions: %v", err)
+	}
+	err = s.scan(`SELECT id, data FROM types WHERE repo_id = ?`, repoID, func(id string, data []byte) error {
+		var info TypeInfo
+		if err := json.Unmarshal(data, &info); err != nil {
+			return err
+		}
+		idx.Types[id] = &info
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to load types: %v", err)
+	}
+	err = s.scan(`SELECT id, data FROM variables WHERE repo_id = ?`, repoID, func(id string, data []byte) error {
+		var info VariableInfo
+		if err := json.Unmarshal(data, &info); err != nil {
+			return err
+		}
+		idx.Variables[id] = &info
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to load variables: %v", err)
+	}
+
+	rows, err := s.db.Query(`SELECT path, hash, symbols FROM files WHERE repo_id = ?`, repoID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var path, hash, symbols string
+		if err := rows.Scan(&path, &hash, &symbols); err != nil {
+			return nil, err
+		}
+		var ids []string
+		if err := json.Unmarshal([]byte(symbols), &ids); err != nil {
+			return nil, fmt.Errorf("failed to load file %s: %v", path, err)
+		}
+		idx.fileHashes[path] = hash
+		if len(ids) > 0 {
+			idx.Files[path] = ids
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	pkgRows, err := s.db.Query(`SELECT path, exported, files, imports FROM packages WHERE repo_id = ?`, repoID)
+	if err != nil {
+		return nil, err
+	}
+	defer pkgRows.Close()
+	for pkgRows.Next() {
+		var pkgPath, exported, files, imports string
+		if err := pkgRows.Scan(&pkgPath, &exported, &files, &imports); err != nil {
+			return nil, err
+		}
+		var exportedIDs, fileList, importList []string
+		if err := errors.Join(json.Unmarshal([]byte(exported), &exportedIDs), json.Unmarshal([]byte(files), &fileList), json.Unmarshal([]byte(imports), &importList)); err != nil {
+			return nil, fmt.Errorf("failed to load package %s: %v", pkgPath, err)
+		}
+		if exportedIDs == nil {
+			exportedIDs = []string{}
+		}
+		idx.Packages[pkgPath] = exportedIDs
+		idx.pkgFiles[pkgPath] = fileList
+		idx.pkgImports[pkgPath] = importList
+	}
+	if err := pkgRows.Err(); err != nil {
+		return nil, err
+	}
+
+	idx.repoPath = repoPath
+	idx.rebuildChunks()
+	return idx, nil
+}
+
+// CachedSummary returns the summary of the code with the given hash, summarized for any
+// repository.
+func (s *Store) CachedSummary(codeHash string) (string, bool, error) {
+	var summary string
+	err := s.db.QueryRow(`SELECT summary FROM summaries WHERE code_hash = ?`, codeHash).Scan(&summary)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", false, nil
+	}
+	if err != nil {
+		return "", false, err
+	}
+	return summary, true, nil
+}
+
+// CacheSummary saves the summary of the code with the given hash.
+func (s *Store) CacheSummary(codeHash, summary string) error {
+	_, err := s.db.Exec(`INSERT OR REPLACE INTO summaries (code_hash, summary) VALUES (?, ?)`, codeHash, summary)
+	return err
+}
+
+// scan calls fn with the id and data columns of every row of the query.
+func (s *Store) scan(query string, repoID int64, fn func(id string, data []byte) error) error {
+	rows, err := s.db.Query(query, repoID)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var id string
+		var data []byte
+		if err := rows.Scan(&id, &data); err != nil {
+			return err
+		}
+		if err := fn(id, data); err != nil {
+			return fmt.Errorf("%s: %v", id, err)
+		}
+	}
+	return rows.Err()
+}
+
+// mustJSON encodes v, which is always encodable.
+func mustJSON(v interface{}) string {
+	data, err := json.Marshal(v)
+	if err != nil {
+		panic(err)
+	}
+	return string(data)
+}
@@ -0,0 +1,70 @@
+package coderag
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStoreSaveLoad(t *testing.T) {
+	store, err := OpenStore(filepath.Join(t.TempDir(), "coderag.db"))
+	require.NoError(t, err)
+	defer store.Close()
+
+	_, err = store.Load(t.TempDir())
+	assert.ErrorIs(t, err, ErrNotIndexed)
+
+	dir := writeModule(t, map[string]string{
+		"tool/tool.go": `package tool
+
+type Tool interface{ Run() }
+
+type Echo struct{}
+
+func (Echo) Run() {}
+
+var Default Tool = Echo{}
+
+func Call() { Default.Run() }
+`,
+	})
+	other := writeModule(t, map[string]string{"main.go": "package main\n\nfunc main() {}\n"})
+
+	for _, repo := range []string{dir, other} {
+		idx, err := store.OpenCodeIndex(repo)
+		require.NoError(t, err)
+		require.NoError(t, idx.indexPackages(repo))
+		for _, fn := range idx.Functions {
+			fn.Summary, fn.Embedding = "summary", []float32{1, 2}
+		}
+		require.NoError(t, store.Save(idx))
+	}
+	repos, err := store.Repositories()
+	require.NoError(t, err)
+	assert.Len(t, repos, 2)
+
+	idx, err := store.Load(dir)
+	require.NoError(t, err)
+	call := idx.Functions["example.com/demo/tool.Call"]
+	require.NotNil(t, call)
+	assert.Equal(t, "summary", call.Summary)
+	assert.Equal(t, []float32{1, 2}, call.Embedding)
+	assert.Equal(t, []string{"(example.com/demo/tool.Tool).Run"}, call.Calls)
+	assert.Equal(t, []string{"example.com/demo/tool.Tool"}, idx.Types["example.com/demo/tool.Echo"].Implements)
+	assert.Equal(t, "Tool", idx.Variables["example.com/demo/tool.Default"].Type)
+	assert.Len(t, idx.DependencyGraph.Edges, 2)
+	summaries, chunks := idx.GetChunksAndSummaries()
+	assert.Len(t, summaries, 2)
+	assert.Len(t, chunks, 2)
+
+	// A loaded index is only brought up to date
+	require.NoError(t, idx.indexPackages(dir))
+	assert.Same(t, call, idx.Functions["example.com/demo/tool.Call"])
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "tool", "more.go"), []byte("package tool\n\nfunc More() { Call() }\n"), 0644))
+	require.NoError(t, idx.indexPackages(dir))
+	assert.Equal(t, "summary", idx.Functions["example.com/demo/tool.Call"].Summary)
+	assert.Equal(t, []string{"example.com/demo/tool.More"}, idx.Functions["example.com/demo/tool.Call"].CalledBy)
+}
@@ -0,0 +1,143 @@
+// summaries.go
+package coderag
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// DefaultSummaryWorkers is the number of concurrent summary requests when the configuration sets
+// none.
+const DefaultSummaryWorkers = 4
+
+// summaryUnavailable is the summary of functions that couldn't be summarized, retried on the next
+// run.
+const summaryUnavailable = "Summary not available."
+
+// summaryAttempts is the number of times a function is sent for summary before giving up.
+const summaryAttempts = 3
+
+// summaryBackoff is the wait before the second attempt, doubled before each next one.
+var summaryBackoff = 2 * time.Second
+
+// GenerateSummaries summarizes the functions without a summary with a pool of workers, rate limited
+// as configured, and rebuilds the chunks and summaries of all functions. Summaries are cached by the
+// hash of the code, in memory and in the store when the index has one, so functions with the same
+// code are summarized once. Without an endpoint, only cached summaries are filled in.
+func (idx *CodeIndex) GenerateSummaries(cfg *Config) error {
+	pending := idx.cachedSummaries()
+	if len(pending) > 0 && cfg.OpenAIEndpoint != "" {
+		workers := cfg.SummaryWorkers
+		if workers < 1 {
+			workers = DefaultSummaryWorkers
+		}
+		limiter := rate.NewLimiter(rate.Inf, 1)
+		if cfg.SummaryRequestsPerSecond > 0 {
+			limiter = rate.NewLimiter(rate.Limit(cfg.SummaryRequestsPerSecond), 1)
+		}
+		log.Printf("Summarizing %d functions with %d workers...", len(pending), workers)
+
+		jobs := make(chan []*FunctionInfo)
+		var wg sync.WaitGroup
+		for i := 0; i < workers; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for fns := range jobs {
+					idx.summarize(fns, cfg, limiter)
+				}
+			}()
+		}
+		for _, fns := range pending {
+			jobs <- fns
+		}
+		close(jobs)
+		wg.Wait()
+	}
+
+	idx.rebuildChunks()
+	return nil
+}
+
+// cachedSummaries fills in the summaries of the functions whose code is in the cache and returns
+// the others, grouped by the hash of their code.
+func (idx *CodeIndex) cachedSummaries() map[string][]*FunctionInfo {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+
+	missing := make(map[string][]*FunctionInfo)
+	for _, fn := range idx.Functions {
+		if fn.Type != "function" && fn.Type != "method" {
+			continue
+		}
+		if fn.Summary != "" && fn.Summary != summaryUnavailable {
+			idx.summaryCache[fn.CodeHash] = fn.Summary
+		} else {
+			missing[fn.CodeHash] = append(missing[fn.CodeHash], fn)
+		}
+	}
+
+	for hash, fns := range missing {
+		summary, cached := idx.summaryCache[hash]
+		if !cached && idx.store != nil {
+			var err error
+			if summary, cached, err = idx.store.CachedSummary(hash); err != nil {
+				log.Printf("Failed to read the summary cache: %v", err)
+			}
+		}
+		if cached {
+			for _, fn := range fns {
+				fn.Summary, fn.Embedding = summary, nil
+			}
+			idx.summaryCache[hash] = summary
+			delete(missing, hash)
+		}
+	}
+	return missing
+}
+
+// summarize summarizes functions sharing the same code, retrying with a growing backoff, and caches
+// the summary. Functions that can't be summarized are marked as such.
+func (idx *CodeIndex) summarize(fns []*FunctionInfo, cfg *Config, limiter *rate.Limiter) {
+	fn := fns[0]
+	summary := summaryUnavailable
+	backoff := summaryBackoff
+	for attempt := 1; attempt <= summaryAttempts; attempt++ {
+		if err := limiter.Wait(context.Background()); err != nil {
+			log.Printf("Failed to summarize function %s: %v", fn.Name, err)
+			break
+		}
+		log.Printf("Summarizing function %s (attempt %d)...", fn.Name, attempt)
+		result, err := idx.SummarizeCode(fn.Code, cfg)
+		if err == nil {
+			summary = result
+			log.Printf("Successfully summarized function %s.", fn.Name)
+			break
+		}
+		log.Printf("Failed to summarize function %s: %v", fn.Name, err)
+		if attempt < summaryAttempts {
+			time.Sleep(backoff)
+			backoff *= 2
+		}
+	}
+
+	// The embeddings include the summary
+	idx.mu.Lock()
+	for _, fn := range fns {
+		fn.Summary, fn.Embedding = summary, nil
+	}
+	if summary != summaryUnavailable {
+		idx.summaryCache[fn.CodeHash] = summary
+	}
+	idx.mu.Unlock()
+
+	if summary != summaryUnavailable && idx.store != nil {
+		if err := idx.store.CacheSummary(fn.CodeHash, summary); err != nil {
+			log.Printf("Failed to cache the summary of %s: %v", fn.Name, err)
+		}
+	}
+}
@@ -0,0 +1,84 @@
+package coderag
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGenerateSummaries(t *testing.T) {
+	summaryBackoff = time.Millisecond
+	defer func() { summaryBackoff = 2 * time.Second }()
+
+	var requests, active, peak atomic.Int32
+	var mu sync.Mutex
+	summarized := map[string]int{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		peak.Store(max(peak.Load(), active.Add(1)))
+		defer active.Add(-1)
+		time.Sleep(20 * time.Millisecond)
+
+		var payload struct {
+			Messages []struct {
+				Content string `json:"content"`
+			} `json:"messages"`
+		}
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&payload))
+		code := payload.Messages[1].Content
+		if strings.Contains(code, "Broken") {
+			http.Error(w, "overloaded", http.StatusServiceUnavailable)
+			return
+		}
+		name := strings.Fields(code[strings.Index(code, "func "):])[1]
+		mu.Lock()
+		summarized[name]++
+		mu.Unlock()
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"choices": []map[string]interface{}{{"message": map[string]string{"content": "summary of " + name}}},
+		})
+	}))
+	defer server.Close()
+
+	// Helper has the same code in both packages
+	dir := writeModule(t, map[string]string{
+		"a/a.go": "package a\n\nfunc Helper() int { return 1 }\n\nfunc One() {}\n\nfunc Two() {}\n\nfunc Three() {}\n",
+		"b/b.go": "package b\n\nfunc Helper() int { return 1 }\n\nfunc Broken() {}\n",
+	})
+	store, err := OpenStore(filepath.Join(t.TempDir(), "coderag.db"))
+	require.NoError(t, err)
+	defer store.Close()
+
+	cfg := &Config{OpenAIEndpoint: server.URL, SummaryWorkers: 2}
+	idx := NewCodeIndex()
+	idx.store = store
+	require.NoError(t, idx.indexPackages(dir))
+	require.NoError(t, idx.GenerateSummaries(cfg))
+
+	assert.Equal(t, "summary of Helper()", idx.Functions["example.com/demo/a.Helper"].Summary)
+	assert.Equal(t, "summary of Helper()", idx.Functions["example.com/demo/b.Helper"].Summary)
+	assert.Equal(t, 1, summarized["Helper()"], "functions with the same code are summarized once")
+	assert.Equal(t, summaryUnavailable, idx.Functions["example.com/demo/b.Broken"].Summary)
+	assert.Equal(t, int32(4+summaryAttempts), requests.Load())
+	assert.LessOrEqual(t, peak.Load(), int32(2))
+	summaries, _ := idx.GetChunksAndSummaries()
+	assert.Len(t, summaries, 6)
+
+	// Another index of the same code reads the summaries from the store, and retries the failure
+	requests.Store(0)
+	idx = NewCodeIndex()
+	idx.store = store
+	require.NoError(t, idx.indexPackages(dir))
+	require.NoError(t, idx.GenerateSummaries(cfg))
+	assert.Equal(t, "summary of One()", idx.Functions["example.com/demo/a.One"].Summary)
+	assert.Equal(t, int32(summaryAttempts), requests.Load())
+}
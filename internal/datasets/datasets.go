@@ -0,0 +1,335 @@
+// datasets.go
+package datasets
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/xitongsys/parquet-go-source/local"
+	"github.com/xitongsys/parquet-go/parquet"
+	"github.com/xitongsys/parquet-go/reader"
+	"github.com/xitongsys/parquet-go/source"
+)
+
+// Formats of the datasets read.
+const (
+	FormatParquet = "parquet"
+	FormatCSV     = "csv"
+)
+
+// Column is a column of a dataset and the type of its values: string for CSV columns, the parquet
+// type otherwise, e.g. int64, or struct, list and map for nested columns.
+type Column struct {
+	Name string `json:"name"`
+	Type string `json:"type"`
+}
+
+// Info describes a dataset file.
+type Info struct {
+	Name       string    `json:"name"` // the file name
+	Format     string    `json:"format"`
+	Size       int64     `json:"size"`
+	ModifiedAt time.Time `json:"modified_at"`
+	Columns    []Column  `json:"columns"`
+	Rows       int64     `json:"rows"`
+}
+
+// Row is a row of a dataset, the string value of each of its columns. Null values are missing.
+type Row map[string]string
+
+// Reader reads the rows of a dataset in order.
+type Reader interface {
+	Columns() []Column
+	// Read returns the next rows, at most n, and io.EOF after the last one.
+	Read(n int) ([]Row, error)
+	Close() error
+}
+
+// Format returns the format of a dataset file from its extension, empty for other files.
+func Format(path string) string {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".parquet":
+		return FormatParquet
+	case ".csv":
+		return FormatCSV
+	}
+	return ""
+}
+
+// List describes the datasets of a directory, the parquet and CSV files directly in it, by name. A
+// missing directory has none.
+func List(dir string) ([]Info, error) {
+	entries, err := os.ReadDir(dir)
+	if errors.Is(err, os.ErrNotExist) {
+		return []Info{}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to list datasets: %w", err)
+	}
+	infos := []Info{}
+	for _, entry := range entries {
+		if entry.IsDir() || Format(entry.Name()) == "" {
+			continue
+		}
+		info, err := Inspect(filepath.Join(dir, entry.Name()))
+		if err != nil {
+			return nil, err
+		}
+		infos = append(infos, info)
+	}
+	sort.Slice(infos, func(i, j int) bool { return infos[i].Name < infos[j].Name })
+	return infos, nil
+}
+
+// Inspect returns the columns and row count of a dataset file. CSV files are read through to count
+// their rows.
+func Inspect(path string) (Info, error) {
+	stat, err := os.Stat(path)
+	if err != nil {
+		return Info{}, err
+	}
+	info := Info{Name: filepath.Base(path), Format: Format(path), Size: stat.Size(), ModifiedAt: stat.ModTime()}
+
+	switch info.Format {
+	case FormatParquet:
+		r, err := openParquet(path)
+		if err != nil {
+			return Info{}, err
+		}
+		defer r.Close()
+		info.Columns, info.Rows = r.Columns(), r.rows
+	case FormatCSV:
+		r, err := openCSV(path)
+		if err != nil {
+			return Info{}, err
+		}
+		defer r.Close()
+		info.Columns = r.Columns()
+		for {
+			if _, err := r.csv.Read(); err == io.EOF {
+				break
+			} else if err != nil {
+				return Info{}, fmt.Errorf("failed to read %s: %w", info.Name, err)
+			}
+			info.Rows++
+		}
+	default:
+		return Info{}, fmt.Errorf("unsupported dataset format %q", filepath.Ext(path))
+	}
+	return info, nil
+}
+
+// Open opens a parquet or CSV dataset file for reading.
+func Open(path string) (Reader, error) {
+	switch Format(path) {
+	case FormatParquet:
+		return openParquet(path)
+	case FormatCSV:
+		return openCSV(path)
+	}
+	return nil, fmt.Errorf("unsupported dataset format %q", filepath.Ext(path))
+}
+
+// Preview returns the first n rows of a dataset file.
+func Preview(path string, n int) ([]Row, error) {
+	r, err := Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	rows, err := r.Read(n)
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+	if rows == nil {
+		rows = []Row{}
+	}
+	return rows, nil
+}
+
+// csvReader reads a CSV file whose first record is the header.
+type csvReader struct {
+	file    *os.File
+	csv     *csv.Reader
+	columns []Column
+}
+
+func openCSV(path string) (*csvReader, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	r := csv.NewReader(file)
+	r.FieldsPerRecord = -1
+	r.ReuseRecord = true
+	header, err := r.Read()
+	if err != nil {
+		file.Close()
+		if err == io.EOF {
+			return nil, fmt.Errorf("%s has no header", filepath.Base(path))
+		}
+		return nil, fmt.Errorf("failed to read header of %s: %w", filepath.Base(path), err)
+	}
+	columns := make([]Column, len(header))
+	for i, name := range header {
+		columns[i] = Column{Name: strings.TrimSpace(strings.TrimPrefix(name, "\ufeff")), Type: "string"}
+	}
+	return &csvReader{file: file, csv: r, columns: columns}, nil
+}
+
+func (r *csvReader) Columns() []Column { return r.columns }
+
+func (r *csvReader) Read(n int) ([]Row, error) {
+	var rows []Row
+	for len(rows) < n {
+		record, err := r.csv.Read()
+		if err == io.EOF {
+			if len(rows) == 0 {
+				return nil, io.EOF
+			}
+			break
+		}
+		if err != nil {
+			return rows, err
+		}
+		row := make(Row, len(r.columns))
+		for i, column := range r.columns {
+			if i < len(record) {
+				row[column.Name] = record[i]
+			}
+		}
+		rows = append(rows, row)
+	}
+	return rows, nil
+}
+
+func (r *csvReader) Close() error { return r.file.Close() }
+
+// parquetReader reads a parquet file without a schema, into structs with a field per top-level
+// column.
+type parquetReader struct {
+	file    source.ParquetFile
+	pr      *reader.ParquetReader
+	columns []Column
+	fields  []string // the struct field of each column
+	rows    int64
+	read    int64
+}
+
+func openParquet(path string) (*parquetReader, error) {
+	file, err := local.NewLocalFileReader(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open parquet file: %w", err)
+	}
+	pr, err := reader.NewParquetReader(file, nil, 4)
+	if err != nil {
+		file.Close()
+		return nil, fmt.Errorf("can't create parquet reader for %s: %w", filepath.Base(path), err)
+	}
+
+	r := &parquetReader{file: file, pr: pr, rows: pr.GetNumRows()}
+	elements := pr.SchemaHandler.SchemaElements
+	// The children of the root are the columns; nested ones span the elements of their subtree
+	for i := 1; i < len(elements); i += subtreeSize(elements, i) {
+		r.columns = append(r.columns, Column{Name: pr.SchemaHandler.GetExName(i), Type: columnType(elements, i)})
+		r.fields = append(r.fields, pr.SchemaHandler.GetInName(i))
+	}
+	return r, nil
+}
+
+// subtreeSize returns the number of schema elements of the element at i and its descendants.
+func subtreeSize(elements []*parquet.SchemaElement, i int) int {
+	size := 1
+	for child := 0; child < int(elements[i].GetNumChildren()); child++ {
+		size += subtreeSize(elements, i+size)
+	}
+	return size
+}
+
+// columnType returns the type of the values of the column of the schema element at i.
+func columnType(elements []*parquet.SchemaElement, i int) string {
+	element := elements[i]
+	if element.GetNumChildren() > 0 {
+		switch {
+		case element.ConvertedType != nil && *element.ConvertedType == parquet.ConvertedType_LIST:
+			return "list"
+		case element.ConvertedType != nil && (*element.ConvertedType == parquet.ConvertedType_MAP || *element.ConvertedType == parquet.ConvertedType_MAP_KEY_VALUE):
+			return "map"
+		}
+		return "struct"
+	}
+	typ := strings.ToLower(element.GetType().String())
+	if element.ConvertedType != nil && *element.ConvertedType == parquet.ConvertedType_UTF8 {
+		typ = "string"
+	}
+	if element.RepetitionType != nil && *element.RepetitionType == parquet.FieldRepetitionType_REPEATED {
+		return "list"
+	}
+	return typ
+}
+
+func (r *parquetReader) Columns() []Column { return r.columns }
+
+func (r *parquetReader) Read(n int) ([]Row, error) {
+	if r.read >= r.rows {
+		return nil, io.EOF
+	}
+	records, err := r.pr.ReadByNumber(int(min(int64(n), r.rows-r.read)))
+	if err != nil {
+		return nil, fmt.Errorf("can't read parquet rows from %d: %w", r.read, err)
+	}
+	r.read += int64(len(records))
+	rows := make([]Row, len(records))
+	for i, record := range records {
+		value := reflect.Indirect(reflect.ValueOf(record))
+		rows[i] = make(Row, len(r.columns))
+		for j, column := range r.columns {
+			if text, ok := valueString(value.FieldByName(r.fields[j])); ok {
+				rows[i][column.Name] = text
+			}
+		}
+	}
+	if len(rows) == 0 {
+		return nil, io.EOF
+	}
+	return rows, nil
+}
+
+// valueString returns the text of a value of a column, JSON for nested values. It is false for
+// nulls.
+func valueString(value reflect.Value) (string, bool) {
+	if !value.IsValid() {
+		return "", false
+	}
+	if value.Kind() == reflect.Pointer {
+		if value.IsNil() {
+			return "", false
+		}
+		value = value.Elem()
+	}
+	switch value.Kind() {
+	case reflect.String:
+		return value.String(), true
+	case reflect.Struct, reflect.Slice, reflect.Map:
+		data, err := json.Marshal(value.Interface())
+		if err != nil {
+			return "", false
+		}
+		return string(data), true
+	}
+	return fmt.Sprint(value.Interface()), true
+}
+
+func (r *parquetReader) Close() error {
+	r.pr.ReadStop()
+	return r.file.Close()
+}
@@ -0,0 +1,78 @@
+// datasets_test.go
+package datasets
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/xitongsys/parquet-go-source/local"
+	"github.com/xitongsys/parquet-go/writer"
+)
+
+type passage struct {
+	ID      int64   `parquet:"name=id, type=INT64"`
+	Passage string  `parquet:"name=passage, type=BYTE_ARRAY, convertedtype=UTF8"`
+	Source  *string `parquet:"name=source, type=BYTE_ARRAY, convertedtype=UTF8, repetitiontype=OPTIONAL"`
+}
+
+// writeParquet writes the passages to a parquet file.
+func writeParquet(t *testing.T, path string, rows []passage) {
+	fw, err := local.NewLocalFileWriter(path)
+	require.NoError(t, err)
+	pw, err := writer.NewParquetWriter(fw, new(passage), 1)
+	require.NoError(t, err)
+	for _, row := range rows {
+		require.NoError(t, pw.Write(row))
+	}
+	require.NoError(t, pw.WriteStop())
+	require.NoError(t, fw.Close())
+}
+
+func TestDatasets(t *testing.T) {
+	dir := t.TempDir()
+	wiki := "wiki"
+	writeParquet(t, filepath.Join(dir, "passages.parquet"), []passage{
+		{ID: 1, Passage: "Bleve is a text indexing library.", Source: &wiki},
+		{ID: 2, Passage: "Parquet is a columnar format."},
+		{ID: 3, Passage: "CSV is not."},
+	})
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "faq.csv"), []byte("\ufeffquestion,answer\nWhat is it?,\"A catalog,\nof datasets\"\nWhy?,Retrieval\n"), 0o644))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "notes.txt"), []byte("not a dataset"), 0o644))
+
+	infos, err := List(dir)
+	require.NoError(t, err)
+	require.Len(t, infos, 2)
+	assert.Equal(t, "faq.csv", infos[0].Name)
+	assert.Equal(t, FormatCSV, infos[0].Format)
+	assert.Equal(t, []Column{{Name: "question", Type: "string"}, {Name: "answer", Type: "string"}}, infos[0].Columns)
+	assert.EqualValues(t, 2, infos[0].Rows)
+	assert.Equal(t, "passages.parquet", infos[1].Name)
+	assert.Equal(t, []Column{{Name: "id", Type: "int64"}, {Name: "passage", Type: "string"}, {Name: "source", Type: "string"}}, infos[1].Columns)
+	assert.EqualValues(t, 3, infos[1].Rows)
+
+	rows, err := Preview(filepath.Join(dir, "faq.csv"), 5)
+	require.NoError(t, err)
+	assert.Equal(t, []Row{{"question": "What is it?", "answer": "A catalog,\nof datasets"}, {"question": "Why?", "answer": "Retrieval"}}, rows)
+
+	r, err := Open(filepath.Join(dir, "passages.parquet"))
+	require.NoError(t, err)
+	defer r.Close()
+	rows, err = r.Read(2)
+	require.NoError(t, err)
+	assert.Equal(t, []Row{{"id": "1", "passage": "Bleve is a text indexing library.", "source": "wiki"}, {"id": "2", "passage": "Parquet is a columnar format."}}, rows)
+	rows, err = r.Read(2)
+	require.NoError(t, err)
+	assert.Len(t, rows, 1)
+	_, err = r.Read(2)
+	assert.Equal(t, io.EOF, err)
+
+	missing, err := List(filepath.Join(dir, "missing"))
+	require.NoError(t, err)
+	assert.Empty(t, missing)
+	_, err = Open(filepath.Join(dir, "notes.txt"))
+	assert.Error(t, err)
+}
@@ -0,0 +1,97 @@
+package documents
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Types of documents that can have their own chunk sizes.
+const (
+	DocTypeCode = "code"
+	DocTypePDF  = "pdf"
+	DocTypeWeb  = "web"
+	DocTypeChat = "chat"
+)
+
+// ChunkSizes are the sizes documents are split with, in characters.
+type ChunkSizes struct {
+	ChunkSize   int `yaml:"chunk_size" json:"chunk_size"`
+	OverlapSize int `yaml:"overlap_size" json:"overlap_size"`
+}
+
+// Validate checks that chunks have a size and overlap less than it.
+func (s ChunkSizes) Validate() error {
+	if s.ChunkSize <= 0 {
+		return fmt.Errorf("chunk size must be positive, got %d", s.ChunkSize)
+	}
+	if s.OverlapSize < 0 || s.OverlapSize >= s.ChunkSize {
+		return fmt.Errorf("overlap size must be between 0 and the chunk size %d, got %d", s.ChunkSize, s.OverlapSize)
+	}
+	return nil
+}
+
+// DocType returns the type of a document from its metadata: its doc_type when set, else pdf for PDFs,
+// web for HTML pages and URLs, and code for source files. It is empty for other documents.
+func DocType(metadata map[string]string) string {
+	if docType := metadata["doc_type"]; docType != "" {
+		return docType
+	}
+	if strings.EqualFold(metadata["file_type"], ".pdf") {
+		return DocTypePDF
+	}
+	source := metadata["source"]
+	if strings.HasPrefix(source, "http://") || strings.HasPrefix(source, "https://") {
+		return DocTypeWeb
+	}
+	language, _ := getLanguageFromMetadata(metadata)
+	switch language {
+	case HTML:
+		return DocTypeWeb
+	case GO, PYTHON, JS, TS:
+		return DocTypeCode
+	}
+	return ""
+}
+
+// ChunkSizesFor returns the chunk sizes of the type of a document, the default ones when its type
+// has none.
+func (dm *DocumentManager) ChunkSizesFor(metadata map[string]string) ChunkSizes {
+	dm.sizesMu.RLock()
+	defer dm.sizesMu.RUnlock()
+	if sizes, ok := dm.DocTypes[DocType(metadata)]; ok {
+		return sizes
+	}
+	return ChunkSizes{ChunkSize: dm.ChunkSize, OverlapSize: dm.OverlapSize}
+}
+
+// Chunking returns the default chunk sizes and those of each document type.
+func (dm *DocumentManager) Chunking() (ChunkSizes, map[string]ChunkSizes) {
+	dm.sizesMu.RLock()
+	defer dm.sizesMu.RUnlock()
+	types := make(map[string]ChunkSizes, len(dm.DocTypes))
+	for docType, sizes := range dm.DocTypes {
+		types[docType] = sizes
+	}
+	return ChunkSizes{ChunkSize: dm.ChunkSize, OverlapSize: dm.OverlapSize}, types
+}
+
+// SetChunking replaces the default chunk sizes and those of each document type, which apply to the
+// documents split from then on.
+func (dm *DocumentManager) SetChunking(defaults ChunkSizes, types map[string]ChunkSizes) error {
+	if err := defaults.Validate(); err != nil {
+		return err
+	}
+	copied := make(map[string]ChunkSizes, len(types))
+	for docType, sizes := range types {
+		if err := sizes.Validate(); err != nil {
+			return fmt.Errorf("%s: %w", docType, err)
+		}
+		copied[docType] = sizes
+	}
+
+	dm.sizesMu.Lock()
+	defer dm.sizesMu.Unlock()
+	dm.ChunkSize, dm.OverlapSize = defaults.ChunkSize, defaults.OverlapSize
+	dm.DocTypes = copied
+	return nil
+}
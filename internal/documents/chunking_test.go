@@ -0,0 +1,44 @@
+package documents
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDocType(t *testing.T) {
+	assert.Equal(t, DocTypeChat, DocType(map[string]string{"doc_type": "chat", "file_type": ".go"}))
+	assert.Equal(t, DocTypePDF, DocType(map[string]string{"file_type": ".pdf", "language": string(MARKDOWN)}))
+	assert.Equal(t, DocTypeWeb, DocType(map[string]string{"source": "https://example.com/docs"}))
+	assert.Equal(t, DocTypeWeb, DocType(map[string]string{"file_type": ".html"}))
+	assert.Equal(t, DocTypeCode, DocType(map[string]string{"file_type": ".go"}))
+	assert.Equal(t, "", DocType(map[string]string{"file_type": ".md"}))
+}
+
+func TestChunkSizesFor(t *testing.T) {
+	dm := NewDocumentManager(2048, 0, nil)
+	goDoc := Document{PageContent: "package main\n\nfunc a() {}\n\nfunc b() {}\n", Metadata: map[string]string{"source": "a.go", "file_type": ".go"}}
+	textDoc := Document{PageContent: "one two three four five six", Metadata: map[string]string{"source": "a.txt", "file_type": ".txt"}}
+	assert.Equal(t, ChunkSizes{ChunkSize: 2048}, dm.ChunkSizesFor(goDoc.Metadata))
+
+	require.NoError(t, dm.SetChunking(ChunkSizes{ChunkSize: 1000, OverlapSize: 100}, map[string]ChunkSizes{DocTypeCode: {ChunkSize: 20, OverlapSize: 5}}))
+	assert.Equal(t, ChunkSizes{ChunkSize: 20, OverlapSize: 5}, dm.ChunkSizesFor(goDoc.Metadata))
+	assert.Equal(t, ChunkSizes{ChunkSize: 1000, OverlapSize: 100}, dm.ChunkSizesFor(textDoc.Metadata))
+
+	// Code is split with its own sizes, text with the defaults
+	dm.IngestDocuments([]Document{goDoc, textDoc})
+	splits, err := dm.SplitDocuments()
+	require.NoError(t, err)
+	assert.Greater(t, len(splits["a.go"]), 1)
+	assert.Len(t, splits["a.txt"], 1)
+
+	defaults, types := dm.Chunking()
+	assert.Equal(t, ChunkSizes{ChunkSize: 1000, OverlapSize: 100}, defaults)
+	assert.Equal(t, map[string]ChunkSizes{DocTypeCode: {ChunkSize: 20, OverlapSize: 5}}, types)
+
+	assert.Error(t, dm.SetChunking(ChunkSizes{ChunkSize: 0}, nil))
+	assert.Error(t, dm.SetChunking(ChunkSizes{ChunkSize: 100}, map[string]ChunkSizes{DocTypePDF: {ChunkSize: 100, OverlapSize: 100}}))
+	_, types = dm.Chunking()
+	assert.Contains(t, types, DocTypeCode, "invalid sizes are not applied")
+}
@@ -0,0 +1,178 @@
+package documents
+
+import (
+	"crypto/md5"
+	"encoding/hex"
+	"fmt"
+	"hash/fnv"
+	"math/bits"
+	"slices"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/blevesearch/bleve/v2"
+)
+
+// GenerateMD5Hash returns the hex MD5 hash of the text, which identifies exact duplicates.
+func GenerateMD5Hash(text string) string {
+	sum := md5.Sum([]byte(text))
+	return hex.EncodeToString(sum[:])
+}
+
+// SimHash returns the 64 bit simhash of the words of the text, lowercased. Texts differing in a few
+// words have hashes a few bits apart.
+func SimHash(text string) uint64 {
+	words := strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsNumber(r)
+	})
+	if len(words) == 0 {
+		return 0
+	}
+	var weights [64]int
+	for _, word := range words {
+		h := fnv.New64a()
+		h.Write([]byte(word))
+		sum := h.Sum64()
+		for bit := 0; bit < 64; bit++ {
+			if sum&(1<<bit) != 0 {
+				weights[bit]++
+			} else {
+				weights[bit]--
+			}
+		}
+	}
+	var hash uint64
+	for bit, weight := range weights {
+		if weight > 0 {
+			hash |= 1 << bit
+		}
+	}
+	return hash
+}
+
+// HammingDistance returns the number of bits two simhashes differ in.
+func HammingDistance(a, b uint64) int {
+	return bits.OnesCount64(a ^ b)
+}
+
+// simHashBands is the number of 16 bit bands simhashes are bucketed by: hashes at most 3 bits apart
+// share at least one band.
+const simHashBands = 4
+
+// Internal keys of the hashes of the chunks indexed.
+func md5Key(hash string) []byte     { return []byte("chunk_md5:" + hash) }
+func chunkHashKey(id string) []byte { return []byte("chunk_hash:" + id) }
+func duplicateKey(id string) []byte { return []byte("chunk_duplicate:" + id) }
+func bandKey(band int, hash uint64) []byte {
+	return []byte(fmt.Sprintf("chunk_simhash:%d:%04x", band, (hash>>(16*band))&0xffff))
+}
+
+// chunkHashes returns the MD5 hash and simhash recorded for a chunk, empty when it has none or is no
+// longer indexed.
+func (im *IndexManager) chunkHashes(id string) (string, uint64, error) {
+	value, err := im.Index.GetInternal(chunkHashKey(id))
+	if err != nil || value == nil {
+		return "", 0, err
+	}
+	doc, err := im.Index.Document(id)
+	if err != nil || doc == nil {
+		return "", 0, err
+	}
+	md5Hash, simHash, _ := strings.Cut(string(value), " ")
+	hash, err := strconv.ParseUint(simHash, 16, 64)
+	if err != nil {
+		return "", 0, fmt.Errorf("invalid simhash of chunk %s: %w", id, err)
+	}
+	return md5Hash, hash, nil
+}
+
+// FindDuplicate returns the ID of an indexed chunk the text duplicates: one with the same content,
+// or, when maxDistance is positive, one whose simhash is at most maxDistance bits away, up to 3. It
+// is empty when the text is new. The chunk itself is only a duplicate when its content didn't
+// change.
+func (im *IndexManager) FindDuplicate(id, text string, maxDistance int) (string, error) {
+	im.dedupMu.Lock()
+	defer im.dedupMu.Unlock()
+
+	md5Hash := GenerateMD5Hash(text)
+	original, err := im.Index.GetInternal(md5Key(md5Hash))
+	if err != nil {
+		return "", fmt.Errorf("failed to look up chunk hash: %w", err)
+	}
+	if original != nil {
+		recorded, _, err := im.chunkHashes(string(original))
+		if err != nil {
+			return "", err
+		}
+		if recorded == md5Hash {
+			return string(original), nil
+		}
+	}
+	if maxDistance <= 0 {
+		return "", nil
+	}
+
+	simHash := SimHash(text)
+	for band := 0; band < simHashBands; band++ {
+		bucket, err := im.Index.GetInternal(bandKey(band, simHash))
+		if err != nil {
+			return "", fmt.Errorf("failed to look up chunk simhash: %w", err)
+		}
+		for _, candidate := range strings.Split(string(bucket), "\n") {
+			if candidate == "" || candidate == id {
+				continue
+			}
+			_, hash, err := im.chunkHashes(candidate)
+			if err != nil {
+				return "", err
+			}
+			if hash != 0 && HammingDistance(hash, simHash) <= min(maxDistance, simHashBands-1) {
+				return candidate, nil
+			}
+		}
+	}
+	return "", nil
+}
+
+// RecordChunk records the hashes of a chunk indexed, which FindDuplicate looks up.
+func (im *IndexManager) RecordChunk(id, text string) error {
+	im.dedupMu.Lock()
+	defer im.dedupMu.Unlock()
+	batch := im.Index.NewBatch()
+	if err := im.recordChunk(batch, id, text); err != nil {
+		return err
+	}
+	if err := im.Index.Batch(batch); err != nil {
+		return fmt.Errorf("failed to record hashes of chunk %s: %w", id, err)
+	}
+	return nil
+}
+
+// recordChunk adds the hashes of a chunk indexed to the batch, with dedupMu held. The chunk is no
+// longer a duplicate.
+func (im *IndexManager) recordChunk(batch *bleve.Batch, id, text string) error {
+	md5Hash, simHash := GenerateMD5Hash(text), SimHash(text)
+	batch.SetInternal(md5Key(md5Hash), []byte(id))
+	batch.SetInternal(chunkHashKey(id), []byte(fmt.Sprintf("%s %x", md5Hash, simHash)))
+	batch.DeleteInternal(duplicateKey(id))
+	for band := 0; band < simHashBands; band++ {
+		bucket, err := im.Index.GetInternal(bandKey(band, simHash))
+		if err != nil {
+			return fmt.Errorf("failed to look up chunk simhash: %w", err)
+		}
+		if !slices.Contains(strings.Split(string(bucket), "\n"), id) {
+			batch.SetInternal(bandKey(band, simHash), append(bucket, []byte(id+"\n")...))
+		}
+	}
+	return nil
+}
+
+// MarkDuplicate records that a chunk wasn't indexed because it duplicates another, so that deleting
+// its document goes past it to the chunks that follow.
+func (im *IndexManager) MarkDuplicate(id, original string) error {
+	if err := im.Index.SetInternal(duplicateKey(id), []byte(original)); err != nil {
+		return fmt.Errorf("failed to mark chunk %s as duplicate: %w", id, err)
+	}
+	return nil
+}
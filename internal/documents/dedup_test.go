@@ -0,0 +1,65 @@
+package documents
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const article = "retrieval augmented generation splits documents into chunks indexes them with embeddings and full text search then finds the chunks most similar to every prompt and adds them to the context of the model so that answers cite the sources of the knowledge base instead of guessing"
+
+func TestSimHash(t *testing.T) {
+	near := strings.Replace(article, "augmented", "assisted", 1)
+	other := "The quick brown fox jumps over the lazy dog while the farmer sleeps in the barn"
+
+	assert.Equal(t, SimHash(article), SimHash(strings.ToUpper(article)+"!"))
+	assert.LessOrEqual(t, HammingDistance(SimHash(article), SimHash(near)), 3)
+	assert.Greater(t, HammingDistance(SimHash(article), SimHash(other)), 3)
+	assert.Equal(t, "5d41402abc4b2a76b9719d911017c592", GenerateMD5Hash("hello"))
+}
+
+func TestFindDuplicate(t *testing.T) {
+	im, err := NewIndexManager(filepath.Join(t.TempDir(), "index"))
+	require.NoError(t, err)
+	defer im.Index.Close()
+
+	text := article
+	require.NoError(t, im.IndexDocumentChunk("a.md-0", text, "a.md"))
+	require.NoError(t, im.RecordChunk("a.md-0", text))
+
+	original, err := im.FindDuplicate("b.md-0", text, 0)
+	require.NoError(t, err)
+	assert.Equal(t, "a.md-0", original)
+	original, err = im.FindDuplicate("b.md-0", "another chunk", 0)
+	require.NoError(t, err)
+	assert.Empty(t, original)
+
+	// Near duplicates are found by simhash, when enabled
+	near := strings.Replace(article, "augmented", "assisted", 1)
+	original, err = im.FindDuplicate("b.md-0", near, 0)
+	require.NoError(t, err)
+	assert.Empty(t, original)
+	original, err = im.FindDuplicate("b.md-0", near, 3)
+	require.NoError(t, err)
+	assert.Equal(t, "a.md-0", original)
+	original, err = im.FindDuplicate("a.md-0", near, 3)
+	require.NoError(t, err)
+	assert.Empty(t, original, "a chunk edited doesn't duplicate itself")
+
+	// Deleting a document goes past its chunks skipped as duplicates
+	require.NoError(t, im.IndexDocumentChunk("b.md-1", "the rest", "b.md"))
+	require.NoError(t, im.MarkDuplicate("b.md-0", "a.md-0"))
+	require.NoError(t, im.DeleteDocument("b.md"))
+	doc, err := im.GetDocument("b.md-1")
+	require.NoError(t, err)
+	assert.Nil(t, doc)
+
+	// Chunks deleted are no longer duplicated
+	require.NoError(t, im.DeleteDocument("a.md"))
+	original, err = im.FindDuplicate("b.md-0", text, 0)
+	require.NoError(t, err)
+	assert.Empty(t, original)
+}
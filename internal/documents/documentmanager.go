@@ -1,6 +1,7 @@
 package documents
 
 import (
+	"context"
 	"crypto/sha1"
 	"encoding/hex"
 	"errors"
@@ -17,7 +18,20 @@ type DocumentManager struct {
 	Documents    []Document
 	ChunkSize    int
 	OverlapSize  int
+	DocTypes     map[string]ChunkSizes // chunk sizes of types of documents, see DocType
 	IndexManager *IndexManager
+	// NearDuplicateDistance, when positive, is the largest number of bits the simhash of a chunk can
+	// differ in from one indexed for it to be skipped as a near duplicate. Exact duplicates always are.
+	NearDuplicateDistance int
+	// OCR, if set, recognizes the pages of PDFs with less than OCRMinChars characters of text,
+	// DefaultOCRMinChars when unset.
+	OCR         OCR
+	OCRMinChars int
+	// Redact, if set, rewrites document content before it is kept or indexed, e.g. to remove personal data.
+	Redact func(string) string
+
+	mu      sync.Mutex   // guards Documents while documents are ingested concurrently
+	sizesMu sync.RWMutex // guards the chunk sizes changed at runtime
 }
 
 // NewDocumentManager initializes a DocumentManager with chunk, overlap sizes, and an optional IndexManager.
@@ -34,27 +48,17 @@ func (dm *DocumentManager) SplitDocuments() (map[string][]string, error) {
 	splits := make(map[string][]string)
 
 	for _, doc := range dm.Documents {
-		// Get language from metadata
-		language, err := getLanguageFromMetadata(doc.Metadata)
-		if err != nil {
-			// If language is not found, default to splitting by lines
-			language = DEFAULT
-		}
-
-		splitter, err := getSplitterForLanguage(language)
+		sizes := dm.ChunkSizesFor(doc.Metadata)
+		splitter, err := NewSplitter(doc.Metadata, sizes.ChunkSize, sizes.OverlapSize)
 		if err != nil {
 			return nil, err
 		}
 
-		splitter.ChunkSize = dm.ChunkSize
-		splitter.OverlapSize = dm.OverlapSize
-		splitter.LengthFunction = func(s string) int { return len(s) }
-
 		// Split the content
 		chunks := splitter.SplitText(doc.PageContent)
 
 		// Generate a unique key for the document
-		key := generateDocumentKey(doc)
+		key := DocumentKey(doc)
 		splits[key] = chunks
 
 		// Index the chunks if IndexManager is set
@@ -74,15 +78,27 @@ func (dm *DocumentManager) SplitDocuments() (map[string][]string, error) {
 
 // IngestDocument ingests a single document into the DocumentManager and indexes it.
 func (dm *DocumentManager) IngestDocument(doc Document) {
+	doc.PageContent = dm.redact(doc.PageContent)
+	dm.ingest(doc)
+}
+
+// ingest adds a redacted document and indexes its full content.
+func (dm *DocumentManager) ingest(doc Document) {
 	var wg sync.WaitGroup
 	wg.Add(1)
 	go func() {
 		defer wg.Done()
-		dm.Documents = append(dm.Documents, doc)
+		docID := DocumentKey(doc)
+		dm.mu.Lock()
+		if i := dm.documentIndex(docID); i >= 0 {
+			dm.Documents[i] = doc
+		} else {
+			dm.Documents = append(dm.Documents, doc)
+		}
+		dm.mu.Unlock()
 
 		// Index the full document content if IndexManager is set
 		if dm.IndexManager != nil {
-			docID := generateDocumentKey(doc)
 			err := dm.IndexManager.IndexFullDocument(docID, doc.PageContent, doc.Metadata["source"])
 			if err != nil {
 				fmt.Printf("Failed to index full document: %s\n", err)
@@ -92,47 +108,104 @@ func (dm *DocumentManager) IngestDocument(doc Document) {
 	wg.Wait()
 }
 
-// IngestDocuments ingests multiple documents into the DocumentManager.
-func (dm *DocumentManager) IngestDocuments(docs []Document) {
-	dm.Documents = append(dm.Documents, docs...)
+// documentIndex returns the index of the document with the key in Documents, -1 when there is none.
+func (dm *DocumentManager) documentIndex(key string) int {
+	for i, doc := range dm.Documents {
+		if DocumentKey(doc) == key {
+			return i
+		}
+	}
+	return -1
+}
+
+// RemoveDocument removes the document with the key, and its full content and chunks from the index
+// if IndexManager is set.
+func (dm *DocumentManager) RemoveDocument(key string) error {
+	dm.mu.Lock()
+	if i := dm.documentIndex(key); i >= 0 {
+		dm.Documents = append(dm.Documents[:i], dm.Documents[i+1:]...)
+	}
+	dm.mu.Unlock()
+
+	if dm.IndexManager != nil {
+		return dm.IndexManager.DeleteDocument(key)
+	}
+	return nil
+}
+
+// IngestDocuments ingests multiple documents into the DocumentManager, replacing those with the same
+// key, and returns them as kept, redacted.
+func (dm *DocumentManager) IngestDocuments(docs []Document) []Document {
+	dm.mu.Lock()
+	defer dm.mu.Unlock()
+	ingested := make([]Document, len(docs))
+	for i, doc := range docs {
+		doc.PageContent = dm.redact(doc.PageContent)
+		if j := dm.documentIndex(DocumentKey(doc)); j >= 0 {
+			dm.Documents[j] = doc
+		} else {
+			dm.Documents = append(dm.Documents, doc)
+		}
+		ingested[i] = doc
+	}
+	return ingested
+}
+
+func (dm *DocumentManager) redact(content string) string {
+	if dm.Redact == nil {
+		return content
+	}
+	return dm.Redact(content)
 }
 
 // IngestGitRepo ingests a Git repository and processes documents.
-func (dm *DocumentManager) IngestGitRepo(repoPath, cloneURL, branch, privateKeyPath string, fileFilter func(string) bool, insecureSkipVerify bool) error {
+func (dm *DocumentManager) IngestGitRepo(repoPath, cloneURL, branch, privateKeyPath string, fileFilter func(string) bool, insecureSkipVerify bool) (err error) {
 	var wg sync.WaitGroup
 	wg.Add(1)
 	go func() {
 		defer wg.Done()
 		gitLoader := NewGitLoader(repoPath, cloneURL, branch, privateKeyPath, fileFilter, insecureSkipVerify, dm, dm.IndexManager)
-		err := gitLoader.Load()
-		if err != nil {
-			fmt.Printf("Failed to load Git repository: %s\n", err)
-		}
+		err = gitLoader.Load()
 	}()
 	wg.Wait()
-	return nil
+	return err
 }
 
-// IngestPDF ingests a PDF file from a given path.
-func (dm *DocumentManager) IngestPDF(filePath string) error {
-	pdfDoc, err := LoadPDF(filePath)
+// IngestPDF ingests a PDF file from a given path, recognizing its scanned pages with OCR when set.
+func (dm *DocumentManager) IngestPDF(ctx context.Context, filePath string) error {
+	pdfDoc, err := LoadPDFWithOCR(ctx, filePath, dm.OCR, dm.OCRMinChars)
 	if err != nil {
 		return fmt.Errorf("failed to load PDF: %w", err)
 	}
-	dm.IngestDocument(pdfDoc)
+	pdfDoc.PageContent = dm.redact(pdfDoc.PageContent)
+	dm.ingest(pdfDoc)
 
 	// Index the full document
 	docID := pdfDoc.Metadata["file_path"]
 	return dm.IndexManager.IndexFullDocument(docID, pdfDoc.PageContent, pdfDoc.Metadata["file_path"])
 }
 
-// SplitAndIndexDocuments splits ingested documents and indexes them.
-func (dm *DocumentManager) SplitAndIndexDocuments() (map[string][]string, error) {
-	return dm.SplitDocuments() // Uses existing split logic
+// NewSplitter returns the splitter of the language of a document, found from its metadata, with the
+// chunk and overlap sizes. Documents of unknown languages are split by lines and paragraphs.
+func NewSplitter(metadata map[string]string, chunkSize, overlapSize int) (*RecursiveCharacterTextSplitter, error) {
+	language, err := getLanguageFromMetadata(metadata)
+	if err != nil {
+		language = DEFAULT
+	}
+
+	splitter, err := getSplitterForLanguage(language)
+	if err != nil {
+		return nil, err
+	}
+
+	splitter.ChunkSize = chunkSize
+	splitter.OverlapSize = overlapSize
+	splitter.LengthFunction = func(s string) int { return len(s) }
+	return splitter, nil
 }
 
-// Helper function to generate a unique key for the document
-func generateDocumentKey(doc Document) string {
+// DocumentKey returns the unique key of a document: its source, or the hash of its content.
+func DocumentKey(doc Document) string {
 	if source, ok := doc.Metadata["source"]; ok {
 		return source
 	}
@@ -0,0 +1,27 @@
+package documents
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDocumentManagerRedact(t *testing.T) {
+	dm := NewDocumentManager(100, 0, nil)
+	dm.Redact = func(s string) string { return strings.ReplaceAll(s, "secret", "[redacted]") }
+
+	dm.IngestDocument(Document{PageContent: "the secret is here", Metadata: map[string]string{"source": "a.txt"}})
+	dm.IngestDocuments([]Document{{PageContent: "another secret", Metadata: map[string]string{"source": "b.txt"}}})
+
+	assert.Equal(t, "the [redacted] is here", dm.Documents[0].PageContent)
+	assert.Equal(t, "another [redacted]", dm.Documents[1].PageContent)
+
+	splits, err := dm.SplitDocuments()
+	assert.NoError(t, err)
+	for _, chunks := range splits {
+		for _, chunk := range chunks {
+			assert.NotContains(t, chunk, "secret")
+		}
+	}
+}
@@ -1,13 +1,26 @@
 package documents
 
 import (
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
 	"fmt"
+	"log"
 	"os"
 	"path/filepath"
+	"strings"
 	"sync"
+	"sync/atomic"
 
 	gogit "github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/config"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/filemode"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/go-git/go-git/v5/plumbing/transport"
+	githttp "github.com/go-git/go-git/v5/plumbing/transport/http"
 	gitssh "github.com/go-git/go-git/v5/plumbing/transport/ssh"
+	"github.com/go-git/go-git/v5/utils/merkletrie"
 	golangssh "golang.org/x/crypto/ssh"
 )
 
@@ -20,6 +33,25 @@ type GitLoader struct {
 	InsecureSkipVerify bool
 	DocumentManager    *DocumentManager
 	IndexManager       *IndexManager
+
+	// Token authenticates HTTPS clone URLs with a personal access token, sent as the password of
+	// Username, "x-access-token" when empty. It takes precedence over PrivateKeyPath.
+	Token    string
+	Username string
+
+	// Tag or Commit pin the repository to a tag or a commit, checked out detached, instead of the
+	// latest commit of Branch. Commit may be abbreviated once it has been fetched.
+	Tag    string
+	Commit string
+
+	// Depth limits the history cloned and fetched to that many commits, 0 for the whole history.
+	// A pinned commit must be within it.
+	Depth int
+
+	// Submodules clones and ingests the submodules of the repository, recursively.
+	Submodules bool
+
+	ingested, removed atomic.Int64
 }
 
 func NewGitLoader(repoPath, cloneURL, branch, privateKeyPath string, fileFilter func(string) bool, insecureSkipVerify bool, dm *DocumentManager, im *IndexManager) *GitLoader {
@@ -35,99 +67,312 @@ func NewGitLoader(repoPath, cloneURL, branch, privateKeyPath string, fileFilter
 	}
 }
 
-// Load loads the documents from the Git repository specified by the GitLoader.
+// Load loads the documents from the Git repository specified by the GitLoader. The first load
+// ingests every file; the next ones fetch the repository and ingest the files added or modified since
+// the last commit indexed, and remove the documents of the files deleted.
 func (gl *GitLoader) Load() error {
-	var err error
-
-	// Clone or open the repository
-	if _, err = os.Stat(gl.RepoPath); os.IsNotExist(err) && gl.CloneURL != "" {
-		var auth *gitssh.PublicKeys
-		// Only set up SSH authentication if PrivateKeyPath is provided
-		if gl.PrivateKeyPath != "" {
-			sshKey, _ := os.ReadFile(gl.PrivateKeyPath)
-			signer, _ := golangssh.ParsePrivateKey(sshKey)
-			auth = &gitssh.PublicKeys{User: "git", Signer: signer}
-			if gl.InsecureSkipVerify {
-				auth.HostKeyCallback = golangssh.InsecureIgnoreHostKey()
-			}
+	gl.ingested.Store(0)
+	gl.removed.Store(0)
+	repo, err := gl.openRepository()
+	if err != nil {
+		return err
+	}
+	head, err := repo.Head()
+	if err != nil {
+		return fmt.Errorf("failed to resolve HEAD: %w", err)
+	}
+	commit := head.Hash().String()
+
+	lastCommit := ""
+	if gl.IndexManager != nil {
+		if lastCommit, err = gl.IndexManager.LastCommit(gl.repoKey()); err != nil {
+			return err
+		}
+	}
+
+	if lastCommit == commit {
+		log.Printf("Repository %s already indexed at %s", gl.repoKey(), commit)
+		return nil
+	}
+
+	synced := false
+	if lastCommit != "" {
+		if err := gl.sync(repo, plumbing.NewHash(lastCommit), head.Hash()); err != nil {
+			// e.g. the last commit is gone after a force push
+			log.Printf("Failed to diff %s from %s, ingesting all files: %v", gl.repoKey(), lastCommit, err)
+		} else {
+			synced = true
+		}
+	}
+	if !synced {
+		if err := gl.walk(); err != nil {
+			return err
+		}
+	}
+
+	if gl.IndexManager != nil {
+		return gl.IndexManager.SetLastCommit(gl.repoKey(), commit)
+	}
+	return nil
+}
+
+// Changes returns the number of files ingested and of documents removed by the last Load.
+func (gl *GitLoader) Changes() (ingested, removed int) {
+	return int(gl.ingested.Load()), int(gl.removed.Load())
+}
+
+// ClonePath returns the path of the clone of a repository under dir, distinct for each clone URL so
+// every repository keeps its own clone to sync.
+func ClonePath(dir, cloneURL string) string {
+	sum := sha1.Sum([]byte(cloneURL))
+	name := strings.TrimSuffix(filepath.Base(cloneURL), ".git")
+	return filepath.Join(dir, fmt.Sprintf("%s-%s", name, hex.EncodeToString(sum[:])[:12]))
+}
+
+// repoKey identifies the repository in the index: its clone URL, or its path when it is local.
+func (gl *GitLoader) repoKey() string {
+	if gl.CloneURL != "" {
+		return gl.CloneURL
+	}
+	if path, err := filepath.Abs(gl.RepoPath); err == nil {
+		return path
+	}
+	return gl.RepoPath
+}
+
+// auth returns the HTTPS authentication of the token or the SSH authentication of the private key,
+// nil when there is neither.
+func (gl *GitLoader) auth() (transport.AuthMethod, error) {
+	if gl.Token != "" {
+		username := gl.Username
+		if username == "" {
+			username = "x-access-token"
 		}
+		return &githttp.BasicAuth{Username: username, Password: gl.Token}, nil
+	}
+	if gl.PrivateKeyPath == "" {
+		return nil, nil
+	}
+	sshKey, err := os.ReadFile(gl.PrivateKeyPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read private key: %w", err)
+	}
+	signer, err := golangssh.ParsePrivateKey(sshKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse private key: %w", err)
+	}
+	auth := &gitssh.PublicKeys{User: "git", Signer: signer}
+	if gl.InsecureSkipVerify {
+		auth.HostKeyCallback = golangssh.InsecureIgnoreHostKey()
+	}
+	return auth, nil
+}
+
+// openRepository clones the repository when it isn't at RepoPath yet. Otherwise it opens it and,
+// when it has a clone URL, fetches it and checks out the pinned tag or commit, or the latest commit
+// of the branch.
+func (gl *GitLoader) openRepository() (*gogit.Repository, error) {
+	if gl.Tag != "" && gl.Commit != "" || gl.Branch != "" && (gl.Tag != "" || gl.Commit != "") {
+		return nil, errors.New("only one of branch, tag and commit can be set")
+	}
+	auth, err := gl.auth()
+	if err != nil {
+		return nil, err
+	}
 
-		// Clone the repository; omit the Auth field if auth is nil
-		cloneOptions := &gogit.CloneOptions{
-			URL: gl.CloneURL,
+	if _, err := os.Stat(gl.RepoPath); os.IsNotExist(err) && gl.CloneURL != "" {
+		cloneOptions := &gogit.CloneOptions{URL: gl.CloneURL, Auth: auth, Depth: gl.Depth}
+		switch {
+		case gl.Tag != "":
+			cloneOptions.ReferenceName = plumbing.NewTagReferenceName(gl.Tag)
+			cloneOptions.SingleBranch = true
+		case gl.Branch != "":
+			cloneOptions.ReferenceName = plumbing.NewBranchReferenceName(gl.Branch)
+			cloneOptions.SingleBranch = true
 		}
-		if auth != nil {
-			cloneOptions.Auth = auth
+		if gl.Submodules {
+			cloneOptions.RecurseSubmodules = gogit.DefaultSubmoduleRecursionDepth
 		}
+		repo, err := gogit.PlainClone(gl.RepoPath, false, cloneOptions)
+		if err != nil || gl.Commit == "" {
+			return repo, err
+		}
+		return repo, gl.checkoutPinned(repo, auth)
+	}
+
+	repo, err := gogit.PlainOpen(gl.RepoPath)
+	if err != nil || gl.CloneURL == "" {
+		return repo, err
+	}
 
-		_, err = gogit.PlainClone(gl.RepoPath, false, cloneOptions)
+	// Every branch is fetched, the clone may be of a single branch or tag pinned before
+	fetchOptions := &gogit.FetchOptions{
+		RemoteName: gogit.DefaultRemoteName,
+		RefSpecs:   []config.RefSpec{"+refs/heads/*:refs/remotes/origin/*"},
+		Auth:       auth,
+		Depth:      gl.Depth,
+	}
+	if gl.Tag != "" {
+		fetchOptions.Tags = gogit.AllTags
+	}
+	err = repo.Fetch(fetchOptions)
+	if err != nil && !errors.Is(err, gogit.NoErrAlreadyUpToDate) {
+		return nil, fmt.Errorf("failed to fetch %s: %w", gl.CloneURL, err)
+	}
+	if gl.Tag != "" || gl.Commit != "" {
+		return repo, gl.checkoutPinned(repo, auth)
+	}
+
+	branch := gl.Branch
+	if branch == "" {
+		head, err := repo.Head()
 		if err != nil {
-			return err
+			return nil, fmt.Errorf("failed to resolve HEAD: %w", err)
+		}
+		if !head.Name().IsBranch() {
+			return nil, errors.New("HEAD is detached on a pinned tag or commit, a branch is required")
 		}
-	} else {
-		_, err = gogit.PlainOpen(gl.RepoPath)
+		branch = head.Name().Short()
+	}
+	remote, err := repo.Reference(plumbing.NewRemoteReferenceName(gogit.DefaultRemoteName, branch), true)
+	if err != nil {
+		return nil, fmt.Errorf("failed to resolve branch %s: %w", branch, err)
+	}
+
+	worktree, err := repo.Worktree()
+	if err != nil {
+		return nil, err
+	}
+	checkout := &gogit.CheckoutOptions{Branch: plumbing.NewBranchReferenceName(branch), Force: true}
+	if _, err := repo.Reference(checkout.Branch, true); err != nil {
+		checkout.Hash, checkout.Create = remote.Hash(), true
+	}
+	if err := worktree.Checkout(checkout); err != nil {
+		return nil, fmt.Errorf("failed to check out %s: %w", branch, err)
+	}
+	if err := worktree.Reset(&gogit.ResetOptions{Commit: remote.Hash(), Mode: gogit.HardReset}); err != nil {
+		return nil, fmt.Errorf("failed to update %s: %w", branch, err)
+	}
+	return repo, gl.updateSubmodules(worktree, auth)
+}
+
+// checkoutPinned checks out the pinned tag or commit, detached.
+func (gl *GitLoader) checkoutPinned(repo *gogit.Repository, auth transport.AuthMethod) error {
+	revision := gl.Commit
+	if gl.Tag != "" {
+		revision = plumbing.NewTagReferenceName(gl.Tag).String()
+	}
+	hash, err := repo.ResolveRevision(plumbing.Revision(revision))
+	if err != nil {
+		return fmt.Errorf("failed to resolve %s: %w", revision, err)
+	}
+
+	worktree, err := repo.Worktree()
+	if err != nil {
+		return err
+	}
+	if err := worktree.Checkout(&gogit.CheckoutOptions{Hash: *hash, Force: true}); err != nil {
+		return fmt.Errorf("failed to check out %s: %w", revision, err)
+	}
+	return gl.updateSubmodules(worktree, auth)
+}
+
+// updateSubmodules checks out the submodules at the commits recorded in the worktree, recursively,
+// when Submodules is set.
+func (gl *GitLoader) updateSubmodules(worktree *gogit.Worktree, auth transport.AuthMethod) error {
+	if !gl.Submodules {
+		return nil
+	}
+	submodules, err := worktree.Submodules()
+	if err != nil {
+		return fmt.Errorf("failed to read submodules: %w", err)
+	}
+	err = submodules.Update(&gogit.SubmoduleUpdateOptions{
+		Init:              true,
+		RecurseSubmodules: gogit.DefaultSubmoduleRecursionDepth,
+		Auth:              auth,
+		Depth:             gl.Depth,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to update submodules: %w", err)
+	}
+	return nil
+}
+
+// sync ingests the files added or modified between two commits, and removes the documents of the
+// files deleted.
+func (gl *GitLoader) sync(repo *gogit.Repository, from, to plumbing.Hash) error {
+	trees := make([]*object.Tree, 2)
+	for i, hash := range []plumbing.Hash{from, to} {
+		commit, err := repo.CommitObject(hash)
 		if err != nil {
-			return err
+			return fmt.Errorf("failed to read commit %s: %w", hash, err)
+		}
+		if trees[i], err = commit.Tree(); err != nil {
+			return fmt.Errorf("failed to read tree of %s: %w", hash, err)
 		}
 	}
+	changes, err := object.DiffTree(trees[0], trees[1])
+	if err != nil {
+		return fmt.Errorf("failed to diff %s and %s: %w", from, to, err)
+	}
 
-	// Walk through the files in the repository and ingest them into DocumentManager
-	var wg sync.WaitGroup
-	err = filepath.Walk(gl.RepoPath, func(path string, info os.FileInfo, err error) error {
+	var added, modified, deleted int
+	for _, change := range changes {
+		action, err := change.Action()
 		if err != nil {
 			return err
 		}
-
-		if info.IsDir() {
-			return nil
+		if change.From.TreeEntry.Mode == filemode.Submodule || change.To.TreeEntry.Mode == filemode.Submodule {
+			// The files of a submodule aren't in the trees diffed
+			name := change.To.Name
+			if name == "" {
+				name = change.From.Name
+			}
+			return fmt.Errorf("submodule %s changed", name)
+		}
+		switch action {
+		case merkletrie.Delete:
+			deleted++
+			if err := gl.DocumentManager.RemoveDocument(filepath.FromSlash(change.From.Name)); err != nil {
+				return err
+			}
+			gl.removed.Add(1)
+		case merkletrie.Modify:
+			modified++
+			// Chunks of the old content are removed, the new content may have fewer
+			if err := gl.DocumentManager.RemoveDocument(filepath.FromSlash(change.From.Name)); err != nil {
+				return err
+			}
+			gl.ingestFile(filepath.Join(gl.RepoPath, filepath.FromSlash(change.To.Name)))
+		case merkletrie.Insert:
+			added++
+			gl.ingestFile(filepath.Join(gl.RepoPath, filepath.FromSlash(change.To.Name)))
 		}
+	}
+	log.Printf("Synced %s from %s to %s: %d added, %d modified, %d deleted files", gl.repoKey(), from, to, added, modified, deleted)
+	return nil
+}
 
-		// Filter out non-text files based on file extension
-		if !isTextFile(info.Name()) {
-			return nil
+// walk ingests every file of the repository.
+func (gl *GitLoader) walk() error {
+	var wg sync.WaitGroup
+	err := filepath.Walk(gl.RepoPath, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
 		}
 
-		if gl.FileFilter != nil && !gl.FileFilter(path) {
+		if info.IsDir() {
+			if info.Name() == ".git" {
+				return filepath.SkipDir
+			}
 			return nil
 		}
 
 		wg.Add(1)
 		go func() {
 			defer wg.Done()
-			// Read file content
-			content, err := os.ReadFile(path)
-			if err != nil {
-				fmt.Printf("Error reading file %s: %s\n", path, err)
-				return
-			}
-
-			textContent := string(content)
-			relFilePath, _ := filepath.Rel(gl.RepoPath, path)
-			fileType := filepath.Ext(info.Name())
-
-			// Construct metadata
-			metadata := map[string]string{
-				"source":    relFilePath,
-				"file_path": relFilePath,
-				"file_name": info.Name(),
-				"file_type": fileType,
-			}
-
-			// Use DocumentManager's method to determine the language
-			language, err := getLanguageFromMetadata(metadata)
-			if err == nil {
-				metadata["language"] = string(language)
-			}
-
-			// Create Document and ingest it into DocumentManager
-			doc := Document{PageContent: textContent, Metadata: metadata}
-			gl.DocumentManager.IngestDocument(doc)
-
-			// Index the full document content before splitting
-			docID := metadata["file_path"]
-			if err := gl.IndexManager.IndexFullDocument(docID, textContent, relFilePath); err != nil {
-				fmt.Printf("Failed to index full document %s: %s\n", docID, err)
-			}
+			gl.ingestFile(path)
 		}()
 
 		return nil
@@ -142,6 +387,59 @@ func (gl *GitLoader) Load() error {
 	return nil
 }
 
+// ingestFile ingests a text file of the repository into the DocumentManager and indexes it, unless
+// the file filter excludes it.
+func (gl *GitLoader) ingestFile(path string) {
+	name := filepath.Base(path)
+
+	// Filter out non-text files based on file extension
+	if !isTextFile(name) {
+		return
+	}
+
+	if gl.FileFilter != nil && !gl.FileFilter(path) {
+		return
+	}
+
+	// Read file content
+	content, err := os.ReadFile(path)
+	if err != nil {
+		fmt.Printf("Error reading file %s: %s\n", path, err)
+		return
+	}
+
+	textContent := gl.DocumentManager.redact(string(content))
+	relFilePath, _ := filepath.Rel(gl.RepoPath, path)
+	fileType := filepath.Ext(name)
+
+	// Construct metadata
+	metadata := map[string]string{
+		"source":    relFilePath,
+		"file_path": relFilePath,
+		"file_name": name,
+		"file_type": fileType,
+	}
+
+	// Use DocumentManager's method to determine the language
+	language, err := getLanguageFromMetadata(metadata)
+	if err == nil {
+		metadata["language"] = string(language)
+	}
+
+	// Create Document and ingest it into DocumentManager
+	doc := Document{PageContent: textContent, Metadata: metadata}
+	gl.DocumentManager.ingest(doc)
+	gl.ingested.Add(1)
+
+	// Index the full document content before splitting
+	if gl.IndexManager != nil {
+		docID := metadata["file_path"]
+		if err := gl.IndexManager.IndexFullDocument(docID, textContent, relFilePath); err != nil {
+			fmt.Printf("Failed to index full document %s: %s\n", docID, err)
+		}
+	}
+}
+
 // validTextFileExtensions holds the set of file extensions that are considered text files.
 var validTextFileExtensions = map[string]bool{
 	".txt":  true,
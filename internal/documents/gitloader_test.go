@@ -0,0 +1,155 @@
+package documents
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+	"time"
+
+	gogit "github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	githttp "github.com/go-git/go-git/v5/plumbing/transport/http"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// commitFiles writes the files of the repository, removing those with no content, and commits them.
+func commitFiles(t *testing.T, repo *gogit.Repository, dir string, files map[string]string) {
+	worktree, err := repo.Worktree()
+	require.NoError(t, err)
+	for name, content := range files {
+		if content == "" {
+			_, err := worktree.Remove(name)
+			require.NoError(t, err)
+			continue
+		}
+		require.NoError(t, os.WriteFile(filepath.Join(dir, name), []byte(content), 0644))
+		_, err := worktree.Add(name)
+		require.NoError(t, err)
+	}
+	_, err = worktree.Commit("update", &gogit.CommitOptions{Author: &object.Signature{Name: "test", Email: "test@example.com", When: time.Now()}})
+	require.NoError(t, err)
+}
+
+func documentSources(dm *DocumentManager) []string {
+	var sources []string
+	for _, doc := range dm.Documents {
+		sources = append(sources, doc.Metadata["source"])
+	}
+	sort.Strings(sources)
+	return sources
+}
+
+func TestGitLoaderIncrementalSync(t *testing.T) {
+	origin := t.TempDir()
+	repo, err := gogit.PlainInit(origin, false)
+	require.NoError(t, err)
+	commitFiles(t, repo, origin, map[string]string{
+		"keep.md":   "unchanged",
+		"edit.md":   "before the edit, long enough to make two chunks",
+		"remove.md": "removed later",
+	})
+
+	im, err := NewIndexManager(filepath.Join(t.TempDir(), "index"))
+	require.NoError(t, err)
+	defer im.Index.Close()
+	clone := ClonePath(t.TempDir(), origin)
+
+	dm := NewDocumentManager(30, 0, im)
+	require.NoError(t, dm.IngestGitRepo(clone, origin, "", "", nil, false))
+	assert.Equal(t, []string{"edit.md", "keep.md", "remove.md"}, documentSources(dm))
+	_, err = dm.SplitDocuments()
+	require.NoError(t, err)
+	chunk, err := im.GetDocument("edit.md-1")
+	require.NoError(t, err)
+	require.NotNil(t, chunk)
+
+	commitFiles(t, repo, origin, map[string]string{
+		"edit.md":   "after",
+		"remove.md": "",
+		"add.md":    "added",
+	})
+
+	// A new manager, as after a restart, only gets the changes
+	dm = NewDocumentManager(30, 0, im)
+	require.NoError(t, dm.IngestGitRepo(clone, origin, "", "", nil, false))
+	assert.Equal(t, []string{"add.md", "edit.md"}, documentSources(dm))
+	for _, doc := range dm.Documents {
+		if doc.Metadata["source"] == "edit.md" {
+			assert.Equal(t, "after", doc.PageContent)
+		}
+	}
+
+	// The chunks of removed files and of the old content of edited ones are deleted
+	for _, id := range []string{"remove.md", "remove.md-0", "edit.md-0", "edit.md-1"} {
+		doc, err := im.GetDocument(id)
+		require.NoError(t, err)
+		assert.Nil(t, doc, id)
+	}
+	doc, err := im.GetDocument("keep.md-0")
+	require.NoError(t, err)
+	assert.NotNil(t, doc)
+
+	head, err := repo.Head()
+	require.NoError(t, err)
+	commit, err := im.LastCommit(origin)
+	require.NoError(t, err)
+	assert.Equal(t, head.Hash().String(), commit)
+
+	// Nothing changed
+	dm = NewDocumentManager(30, 0, im)
+	require.NoError(t, dm.IngestGitRepo(clone, origin, "", "", nil, false))
+	assert.Empty(t, dm.Documents)
+}
+
+func TestGitLoaderPinning(t *testing.T) {
+	origin := t.TempDir()
+	repo, err := gogit.PlainInit(origin, false)
+	require.NoError(t, err)
+	commitFiles(t, repo, origin, map[string]string{"a.md": "one"})
+	first, err := repo.Head()
+	require.NoError(t, err)
+	_, err = repo.CreateTag("v1", first.Hash(), nil)
+	require.NoError(t, err)
+	commitFiles(t, repo, origin, map[string]string{"a.md": "two"})
+	second, err := repo.Head()
+	require.NoError(t, err)
+
+	im, err := NewIndexManager(filepath.Join(t.TempDir(), "index"))
+	require.NoError(t, err)
+	defer im.Index.Close()
+	clone := ClonePath(t.TempDir(), origin)
+
+	load := func(configure func(*GitLoader)) string {
+		dm := NewDocumentManager(100, 0, im)
+		loader := NewGitLoader(clone, origin, "", "", nil, false, dm, im)
+		configure(loader)
+		require.NoError(t, loader.Load())
+		require.Len(t, dm.Documents, 1)
+		return dm.Documents[0].PageContent
+	}
+	assert.Equal(t, "one", load(func(gl *GitLoader) { gl.Tag, gl.Depth = "v1", 1 }))
+	assert.Equal(t, "two", load(func(gl *GitLoader) { gl.Commit = second.Hash().String()[:10] }))
+	assert.Equal(t, "one", load(func(gl *GitLoader) { gl.Commit = first.Hash().String() }))
+
+	loader := NewGitLoader(clone, origin, "main", "", nil, false, NewDocumentManager(100, 0, im), im)
+	loader.Tag = "v1"
+	assert.Error(t, loader.Load())
+}
+
+func TestGitLoaderTokenAuth(t *testing.T) {
+	loader := &GitLoader{Token: "ghp_secret", PrivateKeyPath: "/nonexistent"}
+	auth, err := loader.auth()
+	require.NoError(t, err)
+	assert.Equal(t, &githttp.BasicAuth{Username: "x-access-token", Password: "ghp_secret"}, auth)
+
+	loader.Username = "oauth2"
+	auth, err = loader.auth()
+	require.NoError(t, err)
+	assert.Equal(t, "oauth2", auth.(*githttp.BasicAuth).Username)
+
+	auth, err = (&GitLoader{}).auth()
+	require.NoError(t, err)
+	assert.Nil(t, auth)
+}
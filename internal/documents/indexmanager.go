@@ -12,6 +12,8 @@ import (
 // IndexManager handles the indexing and retrieval of document chunks.
 type IndexManager struct {
 	Index bleve.Index
+
+	dedupMu sync.Mutex // guards the lookups and updates of chunk hashes
 }
 
 // NewIndexManager creates a new instance of IndexManager.
@@ -85,3 +87,59 @@ func (im *IndexManager) SearchChunks(searchRequest *bleve.SearchRequest) (*bleve
 func (im *IndexManager) GetDocument(docID string) (index.Document, error) {
 	return im.Index.Document(docID)
 }
+
+// DeleteDocument removes a document from the index: its full content and its chunks, numbered from
+// 0 after its ID, with their hashes and its version. Chunks skipped as duplicates don't end the
+// numbering.
+func (im *IndexManager) DeleteDocument(docID string) error {
+	batch := im.Index.NewBatch()
+	batch.Delete(docID)
+	batch.DeleteInternal(versionKey(docID))
+	if err := im.Index.Batch(batch); err != nil {
+		return fmt.Errorf("failed to delete document %s: %w", docID, err)
+	}
+	for i := 0; ; i++ {
+		chunkID := fmt.Sprintf("%s-%d", docID, i)
+		doc, err := im.Index.Document(chunkID)
+		if err != nil {
+			return fmt.Errorf("failed to look up chunk %s: %w", chunkID, err)
+		}
+		duplicate, err := im.Index.GetInternal(duplicateKey(chunkID))
+		if err != nil {
+			return fmt.Errorf("failed to look up chunk %s: %w", chunkID, err)
+		}
+		if doc == nil && duplicate == nil {
+			return nil
+		}
+		batch := im.Index.NewBatch()
+		batch.Delete(chunkID)
+		batch.DeleteInternal(chunkHashKey(chunkID))
+		batch.DeleteInternal(duplicateKey(chunkID))
+		if err := im.Index.Batch(batch); err != nil {
+			return fmt.Errorf("failed to delete chunk %s: %w", chunkID, err)
+		}
+	}
+}
+
+// lastCommitKey is the internal key of the last commit of a repository indexed.
+func lastCommitKey(repo string) []byte {
+	return []byte("git_commit:" + repo)
+}
+
+// LastCommit returns the hash of the last commit of the repository indexed, empty when it never
+// was.
+func (im *IndexManager) LastCommit(repo string) (string, error) {
+	commit, err := im.Index.GetInternal(lastCommitKey(repo))
+	if err != nil {
+		return "", fmt.Errorf("failed to read last commit of %s: %w", repo, err)
+	}
+	return string(commit), nil
+}
+
+// SetLastCommit records the hash of the last commit of the repository indexed.
+func (im *IndexManager) SetLastCommit(repo, commit string) error {
+	if err := im.Index.SetInternal(lastCommitKey(repo), []byte(commit)); err != nil {
+		return fmt.Errorf("failed to record last commit of %s: %w", repo, err)
+	}
+	return nil
+}
@@ -0,0 +1,228 @@
+package documents
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// DefaultOCRMinChars is the number of characters of text below which a page of a PDF is taken for a
+// scan and recognized with OCR.
+const DefaultOCRMinChars = 50
+
+// OCR recognizes the text of scanned pages of PDFs.
+type OCR interface {
+	// RecognizePage returns the text of a page of a PDF, numbered from 1, and the tesseract code of
+	// the language it is in, e.g. eng, empty when unknown.
+	RecognizePage(ctx context.Context, filePath string, page int) (text, language string, err error)
+}
+
+// scriptLanguages are the tesseract languages of the scripts reported by its orientation and script
+// detection, and by DetectLanguage.
+var scriptLanguages = map[string]string{
+	"Latin":      "eng",
+	"Cyrillic":   "rus",
+	"Greek":      "ell",
+	"Arabic":     "ara",
+	"Hebrew":     "heb",
+	"Devanagari": "hin",
+	"Han":        "chi_sim",
+	"Japanese":   "jpn",
+	"Hangul":     "kor",
+	"Thai":       "tha",
+}
+
+// scriptTables are the unicode scripts DetectLanguage tells apart, with their names in
+// scriptLanguages.
+var scriptTables = []struct {
+	name  string
+	table *unicode.RangeTable
+}{
+	{"Latin", unicode.Latin},
+	{"Cyrillic", unicode.Cyrillic},
+	{"Greek", unicode.Greek},
+	{"Arabic", unicode.Arabic},
+	{"Hebrew", unicode.Hebrew},
+	{"Devanagari", unicode.Devanagari},
+	{"Japanese", unicode.Hiragana},
+	{"Japanese", unicode.Katakana},
+	{"Hangul", unicode.Hangul},
+	{"Han", unicode.Han},
+	{"Thai", unicode.Thai},
+}
+
+// DetectLanguage returns the tesseract code of the language of the most frequent script of the text,
+// e.g. rus for Cyrillic. It is empty for text without letters.
+func DetectLanguage(text string) string {
+	counts := make(map[string]int)
+	for _, r := range text {
+		if !unicode.IsLetter(r) {
+			continue
+		}
+		for _, script := range scriptTables {
+			if unicode.Is(script.table, r) {
+				counts[script.name]++
+				break
+			}
+		}
+	}
+	// Japanese mixes kana with Han characters
+	if counts["Japanese"] > 0 {
+		counts["Japanese"] += counts["Han"]
+		delete(counts, "Han")
+	}
+
+	best, bestCount := "", 0
+	for _, script := range scriptTables {
+		if counts[script.name] > bestCount {
+			best, bestCount = script.name, counts[script.name]
+		}
+	}
+	return scriptLanguages[best]
+}
+
+// TesseractOCR rasterizes pages with pdftoppm and recognizes them with the tesseract command. The
+// language of each page is detected from its script with tesseract's orientation and script
+// detection, which needs the osd traineddata.
+type TesseractOCR struct {
+	Command    string   // tesseract when empty
+	Rasterizer string   // pdftoppm when empty
+	DPI        int      // 300 when unset
+	Languages  []string // recognized with when the script of a page isn't detected or installed, eng when empty
+}
+
+func (t *TesseractOCR) RecognizePage(ctx context.Context, filePath string, page int) (string, string, error) {
+	command, rasterizer, dpi := t.Command, t.Rasterizer, t.DPI
+	if command == "" {
+		command = "tesseract"
+	}
+	if rasterizer == "" {
+		rasterizer = "pdftoppm"
+	}
+	if dpi <= 0 {
+		dpi = 300
+	}
+	fallback := strings.Join(t.Languages, "+")
+	if fallback == "" {
+		fallback = "eng"
+	}
+
+	dir, err := os.MkdirTemp("", "ocr")
+	if err != nil {
+		return "", "", fmt.Errorf("failed to create OCR directory: %w", err)
+	}
+	defer os.RemoveAll(dir)
+
+	prefix := filepath.Join(dir, "page")
+	n := strconv.Itoa(page)
+	if out, err := exec.CommandContext(ctx, rasterizer, "-f", n, "-l", n, "-r", strconv.Itoa(dpi), "-png", "-singlefile", filePath, prefix).CombinedOutput(); err != nil {
+		return "", "", fmt.Errorf("failed to rasterize page %d: %w: %s", page, err, bytes.TrimSpace(out))
+	}
+	image := prefix + ".png"
+
+	// Orientation and script detection fails on pages with too little text; they are recognized
+	// with the fallback languages
+	language := fallback
+	if out, err := exec.CommandContext(ctx, command, image, "stdout", "--psm", "0").Output(); err == nil {
+		if detected := scriptLanguages[parseOSDScript(string(out))]; detected != "" {
+			language = detected
+		}
+	}
+
+	text, err := exec.CommandContext(ctx, command, image, "stdout", "-l", language).Output()
+	if err != nil && language != fallback {
+		// The language of the script isn't installed
+		language = fallback
+		text, err = exec.CommandContext(ctx, command, image, "stdout", "-l", language).Output()
+	}
+	if err != nil {
+		return "", "", fmt.Errorf("failed to recognize page %d: %w", page, err)
+	}
+	return string(text), language, nil
+}
+
+// parseOSDScript returns the script of tesseract's orientation and script detection output.
+func parseOSDScript(out string) string {
+	for _, line := range strings.Split(out, "\n") {
+		if script, ok := strings.CutPrefix(strings.TrimSpace(line), "Script:"); ok {
+			return strings.TrimSpace(script)
+		}
+	}
+	return ""
+}
+
+// HTTPOCR recognizes pages with an OCR API, e.g. a tesseract server run as an external service. The
+// PDF is posted as the multipart file field with the page number, and the API responds with JSON
+// holding the text and its language.
+type HTTPOCR struct {
+	URL    string
+	APIKey string       // sent as a bearer token when set
+	Client *http.Client // one with a 2 minute timeout when nil
+}
+
+func (h *HTTPOCR) RecognizePage(ctx context.Context, filePath string, page int) (string, string, error) {
+	file, err := os.Open(filePath)
+	if err != nil {
+		return "", "", err
+	}
+	defer file.Close()
+
+	var body bytes.Buffer
+	form := multipart.NewWriter(&body)
+	part, err := form.CreateFormFile("file", filepath.Base(filePath))
+	if err != nil {
+		return "", "", err
+	}
+	if _, err := io.Copy(part, file); err != nil {
+		return "", "", fmt.Errorf("failed to read PDF: %w", err)
+	}
+	form.WriteField("page", strconv.Itoa(page))
+	if err := form.Close(); err != nil {
+		return "", "", err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, h.URL, &body)
+	if err != nil {
+		return "", "", err
+	}
+	req.Header.Set("Content-Type", form.FormDataContentType())
+	if h.APIKey != "" {
+		req.Header.Set("Authorization", "Bearer "+h.APIKey)
+	}
+	client := h.Client
+	if client == nil {
+		client = &http.Client{Timeout: 2 * time.Minute}
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", "", fmt.Errorf("OCR request failed: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return "", "", fmt.Errorf("OCR API returned %s: %s", resp.Status, bytes.TrimSpace(msg))
+	}
+
+	var result struct {
+		Text     string `json:"text"`
+		Language string `json:"language"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return "", "", fmt.Errorf("invalid OCR response: %w", err)
+	}
+	if result.Language == "" {
+		result.Language = DetectLanguage(result.Text)
+	}
+	return result.Text, result.Language, nil
+}
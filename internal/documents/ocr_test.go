@@ -0,0 +1,111 @@
+package documents
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// writePDF writes a PDF with a page for each text, empty for a page without text as a scan.
+func writePDF(t *testing.T, path string, pages ...string) {
+	streams := make([]string, len(pages))
+	for i, text := range pages {
+		if text != "" {
+			streams[i] = fmt.Sprintf("BT /F1 12 Tf 72 720 Td (%s) Tj ET", text)
+		}
+	}
+	writePDFStreams(t, path, streams...)
+}
+
+// writePDFStreams writes a PDF with a page for each content stream. The fonts are F1, Helvetica, and
+// F2, Helvetica-Bold, with a width of 600 for every character.
+func writePDFStreams(t *testing.T, path string, streams ...string) {
+	widths := strings.TrimSpace(strings.Repeat("600 ", 95))
+	font := "<< /Type /Font /Subtype /Type1 /BaseFont /%s /FirstChar 32 /LastChar 126 /Widths [" + widths + "] >>"
+	objects := []string{"<< /Type /Catalog /Pages 2 0 R >>", "", fmt.Sprintf(font, "Helvetica"), fmt.Sprintf(font, "Helvetica-Bold")}
+	var kids []string
+	for _, stream := range streams {
+		page := len(objects) + 1
+		kids = append(kids, fmt.Sprintf("%d 0 R", page))
+		objects = append(objects,
+			fmt.Sprintf("<< /Type /Page /Parent 2 0 R /MediaBox [0 0 612 792] /Resources << /Font << /F1 3 0 R /F2 4 0 R >> >> /Contents %d 0 R >>", page+1),
+			fmt.Sprintf("<< /Length %d >>\nstream\n%s\nendstream", len(stream), stream))
+	}
+	objects[1] = fmt.Sprintf("<< /Type /Pages /Kids [%s] /Count %d >>", strings.Join(kids, " "), len(streams))
+
+	var pdf strings.Builder
+	pdf.WriteString("%PDF-1.4\n")
+	offsets := make([]int, len(objects))
+	for i, object := range objects {
+		offsets[i] = pdf.Len()
+		fmt.Fprintf(&pdf, "%d 0 obj\n%s\nendobj\n", i+1, object)
+	}
+	xref := pdf.Len()
+	fmt.Fprintf(&pdf, "xref\n0 %d\n0000000000 65535 f \n", len(objects)+1)
+	for _, offset := range offsets {
+		fmt.Fprintf(&pdf, "%010d 00000 n \n", offset)
+	}
+	fmt.Fprintf(&pdf, "trailer\n<< /Size %d /Root 1 0 R >>\nstartxref\n%d\n%%%%EOF\n", len(objects)+1, xref)
+	require.NoError(t, os.WriteFile(path, []byte(pdf.String()), 0644))
+}
+
+type fakeOCR map[int]string
+
+func (f fakeOCR) RecognizePage(ctx context.Context, filePath string, page int) (string, string, error) {
+	return f[page], DetectLanguage(f[page]), nil
+}
+
+func TestLoadPDFWithOCR(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "scan.pdf")
+	writePDF(t, path, "The first page has enough embedded text to be kept as it is", "")
+
+	doc, err := LoadPDF(path)
+	require.NoError(t, err)
+	assert.Contains(t, doc.PageContent, "enough embedded text")
+	assert.Empty(t, doc.Metadata["ocr_pages"])
+
+	// Only the page without text is recognized
+	doc, err = LoadPDFWithOCR(context.Background(), path, fakeOCR{1: "wrong", 2: "Вторая страница была отсканирована"}, 0)
+	require.NoError(t, err)
+	assert.Contains(t, doc.PageContent, "enough embedded text")
+	assert.Contains(t, doc.PageContent, "Вторая страница")
+	assert.NotContains(t, doc.PageContent, "wrong")
+	assert.Equal(t, "2", doc.Metadata["ocr_pages"])
+	assert.Equal(t, "1:eng,2:rus", doc.Metadata["page_languages"])
+}
+
+func TestDetectLanguage(t *testing.T) {
+	assert.Equal(t, "eng", DetectLanguage("Hello, world"))
+	assert.Equal(t, "rus", DetectLanguage("Привет, мир"))
+	assert.Equal(t, "jpn", DetectLanguage("日本語のテキスト"))
+	assert.Equal(t, "chi_sim", DetectLanguage("中文文本"))
+	assert.Empty(t, DetectLanguage("1234 !?"))
+	assert.Equal(t, "Cyrillic", parseOSDScript("Page number: 0\nOrientation in degrees: 0\nScript: Cyrillic\nScript confidence: 2.5\n"))
+}
+
+func TestHTTPOCR(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "Bearer secret", r.Header.Get("Authorization"))
+		_, header, err := r.FormFile("file")
+		require.NoError(t, err)
+		assert.Equal(t, "scan.pdf", header.Filename)
+		json.NewEncoder(w).Encode(map[string]string{"text": "page " + r.FormValue("page")})
+	}))
+	defer server.Close()
+
+	path := filepath.Join(t.TempDir(), "scan.pdf")
+	writePDF(t, path, "")
+	text, language, err := (&HTTPOCR{URL: server.URL, APIKey: "secret"}).RecognizePage(context.Background(), path, 3)
+	require.NoError(t, err)
+	assert.Equal(t, "page 3", text)
+	assert.Equal(t, "eng", language)
+}
@@ -0,0 +1,96 @@
+package documents
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// PageMarker returns the Markdown comment marking the start of a page of a document, e.g. of a PDF.
+func PageMarker(page int) string {
+	return fmt.Sprintf("<!-- page %d -->", page)
+}
+
+var (
+	pageMarkerLine = regexp.MustCompile(`(?m)^<!-- page (\d+) -->$`)
+	headingLine    = regexp.MustCompile("(?m)^(#{1,6})[ \t]+(.+?)[ \t#]*$")
+)
+
+// outlineMark is a page or heading starting at an offset of a document.
+type outlineMark struct {
+	offset int
+	level  int // of headings
+	text   string
+}
+
+// Outline locates the pages and sections of a Markdown document, from its page markers and
+// headings, so that its chunks carry where they come from.
+type Outline struct {
+	pages    []outlineMark
+	headings []outlineMark
+}
+
+// NewOutline returns the outline of a document. Only Markdown documents have headings; documents
+// without page markers have no pages.
+func NewOutline(doc Document) Outline {
+	var outline Outline
+	for _, match := range pageMarkerLine.FindAllStringSubmatchIndex(doc.PageContent, -1) {
+		outline.pages = append(outline.pages, outlineMark{offset: match[0], text: doc.PageContent[match[2]:match[3]]})
+	}
+	if language, _ := getLanguageFromMetadata(doc.Metadata); language == MARKDOWN {
+		for _, match := range headingLine.FindAllStringSubmatchIndex(doc.PageContent, -1) {
+			outline.headings = append(outline.headings, outlineMark{
+				offset: match[0],
+				level:  match[3] - match[2],
+				text:   doc.PageContent[match[4]:match[5]],
+			})
+		}
+	}
+	return outline
+}
+
+// Empty reports whether the document has neither pages nor headings.
+func (o Outline) Empty() bool {
+	return len(o.pages) == 0 && len(o.headings) == 0
+}
+
+// At returns the page, 0 when unknown, and the section, the path of the headings enclosing the
+// offset, e.g. "3 Methods > 3.1 Data", of an offset of the document.
+func (o Outline) At(offset int) (page int, section string) {
+	i := sort.Search(len(o.pages), func(i int) bool { return o.pages[i].offset > offset })
+	if i > 0 {
+		page, _ = strconv.Atoi(o.pages[i-1].text)
+	}
+
+	var path []outlineMark
+	for _, heading := range o.headings {
+		if heading.offset > offset {
+			break
+		}
+		for len(path) > 0 && path[len(path)-1].level >= heading.level {
+			path = path[:len(path)-1]
+		}
+		path = append(path, heading)
+	}
+	titles := make([]string, len(path))
+	for i, heading := range path {
+		titles[i] = heading.text
+	}
+	return page, strings.Join(titles, " > ")
+}
+
+// Locate returns the page and section of each chunk of the document, in order, found by looking for
+// the chunks in its content in turn. Chunks not found get those of the previous one.
+func (o Outline) Locate(content string, chunks []string) (pages []int, sections []string) {
+	pages, sections = make([]int, len(chunks)), make([]string, len(chunks))
+	offset := 0
+	for i, chunk := range chunks {
+		if j := strings.Index(content[offset:], strings.TrimSpace(chunk)); j >= 0 {
+			offset += j
+		}
+		pages[i], sections[i] = o.At(offset)
+	}
+	return pages, sections
+}
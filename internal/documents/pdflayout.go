@@ -0,0 +1,186 @@
+package documents
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/ledongthuc/pdf"
+)
+
+// layoutLine is a line of text of a PDF page, split into cells where the text has wide gaps.
+type layoutLine struct {
+	y     float64
+	size  float64 // the largest font size of the line
+	bold  bool
+	cells []string
+}
+
+// numberedHeading matches the section numbers headings start with, e.g. 3.1 or IV.
+var numberedHeading = regexp.MustCompile(`^((\d+(\.\d+)*)|[IVX]+)\.?\s+\S`)
+
+// pageLayoutMarkdown returns the Markdown of a page from the position and size of its characters:
+// tables, lines of aligned cells, as Markdown tables, and headings, lines in larger or bold type or
+// numbered like sections, as Markdown headings. It fails for pages without character widths, whose
+// text can't be laid out.
+func pageLayoutMarkdown(page pdf.Page) (markdown string, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("failed to lay out page: %v", r)
+		}
+	}()
+
+	lines := layoutLines(page.Content().Text)
+	if lines == nil {
+		return "", errors.New("page has no character widths")
+	}
+	return layoutMarkdown(lines), nil
+}
+
+// layoutLines groups the characters of a page into lines, top to bottom, and cells, left to right.
+// It returns nil when the characters have no width.
+func layoutLines(chars []pdf.Text) []layoutLine {
+	if len(chars) == 0 {
+		return []layoutLine{}
+	}
+	widths := false
+	for _, c := range chars {
+		widths = widths || c.W > 0
+	}
+	if !widths {
+		return nil
+	}
+
+	sorted := append([]pdf.Text(nil), chars...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		if math.Abs(sorted[i].Y-sorted[j].Y) > math.Max(sorted[i].FontSize, 1)/2 {
+			return sorted[i].Y > sorted[j].Y
+		}
+		return sorted[i].X < sorted[j].X
+	})
+
+	var lines []layoutLine
+	var current *layoutLine
+	var cell strings.Builder
+	var prev pdf.Text
+	flush := func() {
+		if text := strings.TrimSpace(cell.String()); text != "" {
+			current.cells = append(current.cells, text)
+		}
+		cell.Reset()
+	}
+	for _, c := range sorted {
+		size := math.Max(c.FontSize, 1)
+		if current == nil || math.Abs(c.Y-current.y) > size/2 {
+			if current != nil {
+				flush()
+				lines = append(lines, *current)
+			}
+			current = &layoutLine{y: c.Y}
+		} else if gap := c.X - (prev.X + prev.W); gap > 2*size {
+			flush()
+		} else if gap > 0.15*size && !strings.HasSuffix(cell.String(), " ") {
+			cell.WriteByte(' ')
+		}
+		current.size = math.Max(current.size, c.FontSize)
+		current.bold = current.bold || strings.Contains(strings.ToLower(c.Font), "bold")
+		cell.WriteString(c.S)
+		prev = c
+	}
+	flush()
+	lines = append(lines, *current)
+
+	kept := lines[:0]
+	for _, line := range lines {
+		if len(line.cells) > 0 {
+			kept = append(kept, line)
+		}
+	}
+	return kept
+}
+
+// bodySize returns the most common font size of the lines, weighted by their length.
+func bodySize(lines []layoutLine) float64 {
+	counts := make(map[float64]int)
+	for _, line := range lines {
+		for _, cell := range line.cells {
+			counts[math.Round(line.size)] += len(cell)
+		}
+	}
+	size, best := 0.0, 0
+	for s, n := range counts {
+		if n > best || (n == best && s < size) {
+			size, best = s, n
+		}
+	}
+	return size
+}
+
+// layoutMarkdown formats the lines of a page as Markdown.
+func layoutMarkdown(lines []layoutLine) string {
+	body := bodySize(lines)
+	var sb strings.Builder
+	for i := 0; i < len(lines); i++ {
+		// Consecutive lines with the same number of cells, at least two, are a table
+		if n := len(lines[i].cells); n >= 2 {
+			end := i + 1
+			for end < len(lines) && len(lines[end].cells) == n {
+				end++
+			}
+			if end-i >= 2 {
+				writeTable(&sb, lines[i:end])
+				i = end - 1
+				continue
+			}
+		}
+
+		text := strings.Join(lines[i].cells, " ")
+		if level := headingLevel(lines[i], text, body); level > 0 {
+			fmt.Fprintf(&sb, "%s %s\n\n", strings.Repeat("#", level), text)
+			continue
+		}
+		if isListItem(text) {
+			sb.WriteString(text + "\n")
+		} else {
+			sb.WriteString(text + "\n\n")
+		}
+	}
+	return sb.String()
+}
+
+// headingLevel returns the Markdown level of a line that is a heading, 0 for other lines.
+func headingLevel(line layoutLine, text string, body float64) int {
+	if len(line.cells) != 1 || len(text) > 100 || strings.HasSuffix(text, ".") {
+		return 0
+	}
+	if number := numberedHeading.FindStringSubmatch(text); number != nil && (line.bold || line.size > body) {
+		return min(strings.Count(number[2], ".")+2, 6)
+	}
+	switch {
+	case body > 0 && line.size >= 1.6*body:
+		return 1
+	case body > 0 && line.size >= 1.2*body:
+		return 2
+	case line.bold || isHeader(text) && strings.ContainsFunc(text, func(r rune) bool { return r >= 'A' && r <= 'Z' }):
+		return 3
+	}
+	return 0
+}
+
+// writeTable writes the lines as a Markdown table, the first being its header.
+func writeTable(sb *strings.Builder, lines []layoutLine) {
+	for i, line := range lines {
+		cells := make([]string, len(line.cells))
+		for j, cell := range line.cells {
+			cells[j] = strings.ReplaceAll(cell, "|", `\|`)
+		}
+		sb.WriteString("| " + strings.Join(cells, " | ") + " |\n")
+		if i == 0 {
+			sb.WriteString("|" + strings.Repeat(" --- |", len(cells)) + "\n")
+		}
+	}
+	sb.WriteString("\n")
+}
@@ -0,0 +1,59 @@
+package documents
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// paperPDF writes a PDF with headings and a table on its first page.
+func paperPDF(t *testing.T) string {
+	path := filepath.Join(t.TempDir(), "paper.pdf")
+	writePDFStreams(t, path, `BT /F2 18 Tf 72 720 Td (1 Introduction) Tj ET
+BT /F1 12 Tf 72 690 Td (Retrieved chunks need provenance for their citations.) Tj ET
+BT /F2 14 Tf 72 660 Td (1.1 Results) Tj ET
+BT /F1 12 Tf 72 630 Td (Model) Tj ET BT /F1 12 Tf 250 630 Td (Score) Tj ET
+BT /F1 12 Tf 72 615 Td (small) Tj ET BT /F1 12 Tf 250 615 Td (0.81) Tj ET
+BT /F1 12 Tf 72 600 Td (large) Tj ET BT /F1 12 Tf 250 600 Td (0.93) Tj ET`,
+		`BT /F1 12 Tf 72 720 Td (The conclusion is on the second page.) Tj ET`)
+	return path
+}
+
+func TestLoadPDFLayout(t *testing.T) {
+	doc, err := LoadPDF(paperPDF(t))
+	require.NoError(t, err)
+	assert.Equal(t, `<!-- page 1 -->
+
+## 1 Introduction
+
+Retrieved chunks need provenance for their citations.
+
+### 1.1 Results
+
+| Model | Score |
+| --- | --- |
+| small | 0.81 |
+| large | 0.93 |
+
+<!-- page 2 -->
+
+The conclusion is on the second page.
+
+`, doc.PageContent)
+}
+
+func TestOutline(t *testing.T) {
+	doc, err := LoadPDF(paperPDF(t))
+	require.NoError(t, err)
+	outline := NewOutline(doc)
+
+	pages, sections := outline.Locate(doc.PageContent, []string{"Retrieved chunks", "| small | 0.81 |", "The conclusion"})
+	assert.Equal(t, []int{1, 1, 2}, pages)
+	assert.Equal(t, []string{"1 Introduction", "1 Introduction > 1.1 Results", "1 Introduction > 1.1 Results"}, sections)
+
+	// Headings of other documents than Markdown aren't sections
+	code := Document{PageContent: "# a comment\nprint(1)\n", Metadata: map[string]string{"file_type": ".py"}}
+	assert.True(t, NewOutline(code).Empty())
+}
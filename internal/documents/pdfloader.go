@@ -1,32 +1,59 @@
 package documents
 
 import (
+	"context"
 	"fmt"
 	"path/filepath"
 	"regexp"
+	"strconv"
 	"strings"
 	"sync"
+	"unicode"
 
 	"github.com/ledongthuc/pdf"
 )
 
 // LoadPDF loads a PDF file and returns a Document.
 func LoadPDF(filePath string) (Document, error) {
-	var wg sync.WaitGroup
-	var content string
-	var err error
-
-	wg.Add(1)
-	go func() {
-		defer wg.Done()
-		content, err = GetPdfContents(filePath)
-	}()
-
-	wg.Wait()
+	return LoadPDFWithOCR(context.Background(), filePath, nil, 0)
+}
 
+// LoadPDFWithOCR loads a PDF file and returns a Document, recognizing with the OCR, when set, the
+// pages with less than minChars characters of text, DefaultOCRMinChars when unset. Those are
+// usually scans. The pages recognized and the language detected of each page are listed in the
+// ocr_pages and page_languages metadata. Pages start with a PageMarker, which gives the chunks of
+// the document their page.
+func LoadPDFWithOCR(ctx context.Context, filePath string, ocr OCR, minChars int) (Document, error) {
+	pages, err := pdfPages(filePath)
 	if err != nil {
 		return Document{}, err
 	}
+	if minChars <= 0 {
+		minChars = DefaultOCRMinChars
+	}
+
+	var ocrPages, pageLanguages []string
+	var sb strings.Builder
+	for i, p := range pages {
+		page, text, markdown := i+1, p.text, p.markdown
+		language := DetectLanguage(text)
+		if ocr != nil && textDensity(text) < minChars {
+			recognized, recognizedLanguage, err := ocr.RecognizePage(ctx, filePath, page)
+			if err != nil {
+				if ctx.Err() != nil {
+					return Document{}, ctx.Err()
+				}
+				fmt.Printf("failed to recognize page %d of %s: %v\n", page, filePath, err)
+			} else if textDensity(recognized) > textDensity(text) {
+				text, markdown, language = recognized, formatAsMarkdown(recognized), recognizedLanguage
+				ocrPages = append(ocrPages, strconv.Itoa(page))
+			}
+		}
+		if language != "" {
+			pageLanguages = append(pageLanguages, fmt.Sprintf("%d:%s", page, language))
+		}
+		fmt.Fprintf(&sb, "%s\n\n%s", PageMarker(page), markdown)
+	}
 
 	metadata := map[string]string{
 		"source":    filePath,
@@ -35,25 +62,63 @@ func LoadPDF(filePath string) (Document, error) {
 		"file_type": filepath.Ext(filePath),
 		"language":  string(MARKDOWN), // Assuming PDFs are converted to Markdown
 	}
+	if len(ocrPages) > 0 {
+		metadata["ocr_pages"] = strings.Join(ocrPages, ",")
+	}
+	if len(pageLanguages) > 0 {
+		metadata["page_languages"] = strings.Join(pageLanguages, ",")
+	}
 
 	return Document{
-		PageContent: content,
+		PageContent: sb.String(),
 		Metadata:    metadata,
 	}, nil
 }
 
+// textDensity returns the number of characters of text that aren't spaces.
+func textDensity(text string) int {
+	n := 0
+	for _, r := range text {
+		if !unicode.IsSpace(r) {
+			n++
+		}
+	}
+	return n
+}
+
 // GetPdfContents extracts the text content from the given PDF file and returns it as Markdown
 func GetPdfContents(filePath string) (string, error) {
+	pages, err := pdfPages(filePath)
+	if err != nil {
+		return "", err
+	}
+	var sb strings.Builder
+	for _, page := range pages {
+		sb.WriteString(page.markdown)
+	}
+	return sb.String(), nil
+}
+
+// pdfPage is the text of a page of a PDF: plain, and as Markdown laid out with its tables and
+// headings.
+type pdfPage struct {
+	text     string
+	markdown string
+}
+
+// pdfPages extracts the text of each page of the given PDF file, in order. Pages are extracted
+// concurrently.
+func pdfPages(filePath string) ([]pdfPage, error) {
 	// Open the PDF file
 	file, reader, err := pdf.Open(filePath)
 	if err != nil {
-		return "", fmt.Errorf("failed to open PDF file: %w", err)
+		return nil, fmt.Errorf("failed to open PDF file: %w", err)
 	}
 	defer file.Close()
 
 	// Iterate through the pages
 	totalPage := reader.NumPage()
-	var sb strings.Builder
+	pages := make([]pdfPage, totalPage)
 	var wg sync.WaitGroup
 
 	for pageIndex := 1; pageIndex <= totalPage; pageIndex++ {
@@ -71,16 +136,18 @@ func GetPdfContents(filePath string) (string, error) {
 				fmt.Printf("failed to extract text from page %d: %v\n", pageIndex, err)
 				return
 			}
-
-			// Format the text content of the page as Markdown
-			markdownText := formatAsMarkdown(text)
-			sb.WriteString(markdownText)
+			markdown, err := pageLayoutMarkdown(page)
+			if err != nil {
+				// Format the plain text of the page as Markdown
+				markdown = formatAsMarkdown(text)
+			}
+			pages[pageIndex-1] = pdfPage{text: text, markdown: markdown}
 		}(pageIndex)
 	}
 
 	wg.Wait()
 
-	return sb.String(), nil
+	return pages, nil
 }
 
 // formatAsMarkdown formats the given text as Markdown
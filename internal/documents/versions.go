@@ -0,0 +1,199 @@
+package documents
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strconv"
+
+	"github.com/blevesearch/bleve/v2"
+	index "github.com/blevesearch/bleve_index_api"
+)
+
+// versionKey is the internal key of the version of a document and its number of chunks.
+func versionKey(docID string) []byte {
+	return []byte("doc_version:" + docID)
+}
+
+// DocumentVersion returns the version of a document whose chunks were replaced with ReplaceChunks,
+// and its number of chunks. Both are 0 for documents never replaced.
+func (im *IndexManager) DocumentVersion(docID string) (version, chunks int, err error) {
+	value, err := im.Index.GetInternal(versionKey(docID))
+	if err != nil {
+		return 0, 0, fmt.Errorf("failed to read version of %s: %w", docID, err)
+	}
+	if value == nil {
+		return 0, 0, nil
+	}
+	if _, err := fmt.Sscanf(string(value), "%d %d", &version, &chunks); err != nil {
+		return 0, 0, fmt.Errorf("invalid version of %s: %w", docID, err)
+	}
+	return version, chunks, nil
+}
+
+// IndexedChunk is the text of a chunk to index with the metadata of where it comes from.
+type IndexedChunk struct {
+	Text     string
+	Metadata map[string]string
+}
+
+// provenanceFields are the metadata of chunks indexed with them, which citations refer to.
+var provenanceFields = []string{"page", "section"}
+
+// ReplaceChunks indexes the chunks of a new version of a document, which has count chunks in all,
+// and returns the version. Chunks are keyed by their index and indexed with their page and section; those missing are left out, e.g. chunks
+// skipped as duplicates. The chunks of the previous version past count, and those marked as
+// duplicates of other chunks, are deleted in the same batch, so searches see either version whole.
+func (im *IndexManager) ReplaceChunks(docID, source string, count int, chunks map[int]IndexedChunk) (int, error) {
+	version, previous, err := im.DocumentVersion(docID)
+	if err != nil {
+		return 0, err
+	}
+	if version == 0 {
+		// Documents indexed before versions were recorded have chunks numbered from 0
+		if previous, err = im.countChunks(docID); err != nil {
+			return 0, err
+		}
+	}
+	version++
+
+	im.dedupMu.Lock()
+	defer im.dedupMu.Unlock()
+	batch := im.Index.NewBatch()
+	for i := 0; i < max(count, previous); i++ {
+		chunkID := fmt.Sprintf("%s-%d", docID, i)
+		if chunk, ok := chunks[i]; ok && i < count {
+			fields := map[string]interface{}{"chunk": chunk.Text, "file_path": source}
+			for _, field := range provenanceFields {
+				if value := chunk.Metadata[field]; value != "" {
+					fields[field] = value
+				}
+			}
+			if err := batch.Index(chunkID, fields); err != nil {
+				return 0, fmt.Errorf("failed to index chunk %s: %w", chunkID, err)
+			}
+			if err := im.recordChunk(batch, chunkID, chunk.Text); err != nil {
+				return 0, err
+			}
+			continue
+		}
+		if i < count {
+			duplicate, err := im.Index.GetInternal(duplicateKey(chunkID))
+			if err != nil {
+				return 0, fmt.Errorf("failed to look up chunk %s: %w", chunkID, err)
+			}
+			if duplicate == nil {
+				// The chunk didn't change
+				continue
+			}
+		}
+		batch.Delete(chunkID)
+		batch.DeleteInternal(chunkHashKey(chunkID))
+		if i >= count {
+			batch.DeleteInternal(duplicateKey(chunkID))
+		}
+	}
+	batch.SetInternal(versionKey(docID), []byte(fmt.Sprintf("%d %d", version, count)))
+	if err := im.Index.Batch(batch); err != nil {
+		return 0, fmt.Errorf("failed to replace chunks of %s: %w", docID, err)
+	}
+	return version, nil
+}
+
+// countChunks returns the number of chunks of a document numbered from 0, counting those skipped as
+// duplicates.
+func (im *IndexManager) countChunks(docID string) (int, error) {
+	for i := 0; ; i++ {
+		chunkID := fmt.Sprintf("%s-%d", docID, i)
+		doc, err := im.Index.Document(chunkID)
+		if err != nil {
+			return 0, fmt.Errorf("failed to look up chunk %s: %w", chunkID, err)
+		}
+		duplicate, err := im.Index.GetInternal(duplicateKey(chunkID))
+		if err != nil {
+			return 0, fmt.Errorf("failed to look up chunk %s: %w", chunkID, err)
+		}
+		if doc == nil && duplicate == nil {
+			return i, nil
+		}
+	}
+}
+
+// chunkID matches the IDs of chunks: the ID of their document followed by their index.
+var chunkID = regexp.MustCompile(`^(.+)-(\d+)$`)
+
+// isChunk reports whether an indexed document is a chunk rather than a full document.
+func isChunk(doc index.Document) bool {
+	chunk := false
+	doc.VisitFields(func(field index.Field) {
+		chunk = chunk || field.Name() == "chunk"
+	})
+	return chunk
+}
+
+// CollectGarbage deletes the stale chunks of the index: those whose document is no longer indexed,
+// neither in full nor by version, and those past the chunk count of the version of their document.
+// It returns the IDs of the chunks deleted.
+func (im *IndexManager) CollectGarbage(ctx context.Context) ([]string, error) {
+	const pageSize = 1000
+	type document struct {
+		exists         bool
+		version, count int
+	}
+	documents := make(map[string]document)
+	var stale []string
+	var after []string
+	for {
+		req := bleve.NewSearchRequestOptions(bleve.NewMatchAllQuery(), pageSize, 0, false)
+		req.SortBy([]string{"_id"})
+		req.SearchAfter = after
+		res, err := im.Index.SearchInContext(ctx, req)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list indexed documents: %w", err)
+		}
+		for _, hit := range res.Hits {
+			match := chunkID.FindStringSubmatch(hit.ID)
+			if match == nil {
+				continue
+			}
+			doc, ok := documents[match[1]]
+			if !ok {
+				if doc.version, doc.count, err = im.DocumentVersion(match[1]); err != nil {
+					return nil, err
+				}
+				full, err := im.Index.Document(match[1])
+				if err != nil {
+					return nil, fmt.Errorf("failed to look up document %s: %w", match[1], err)
+				}
+				doc.exists = doc.version > 0 || (full != nil && !isChunk(full))
+				documents[match[1]] = doc
+			}
+			if i, _ := strconv.Atoi(match[2]); doc.exists && (doc.version == 0 || i < doc.count) {
+				continue
+			}
+			chunk, err := im.Index.Document(hit.ID)
+			if err != nil {
+				return nil, fmt.Errorf("failed to look up chunk %s: %w", hit.ID, err)
+			}
+			if chunk != nil && isChunk(chunk) {
+				stale = append(stale, hit.ID)
+			}
+		}
+		if len(res.Hits) < pageSize {
+			break
+		}
+		after = []string{res.Hits[len(res.Hits)-1].ID}
+	}
+
+	for start := 0; start < len(stale); start += pageSize {
+		batch := im.Index.NewBatch()
+		for _, id := range stale[start:min(start+pageSize, len(stale))] {
+			batch.Delete(id)
+			batch.DeleteInternal(chunkHashKey(id))
+		}
+		if err := im.Index.Batch(batch); err != nil {
+			return nil, fmt.Errorf("failed to delete stale chunks: %w", err)
+		}
+	}
+	return stale, nil
+}
@@ -0,0 +1,93 @@
+package documents
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// chunkTexts returns chunks by index from pairs of an index and a text.
+func chunkTexts(pairs ...interface{}) map[int]IndexedChunk {
+	chunks := make(map[int]IndexedChunk)
+	for i := 0; i < len(pairs); i += 2 {
+		chunks[pairs[i].(int)] = IndexedChunk{Text: pairs[i+1].(string)}
+	}
+	return chunks
+}
+
+func TestReplaceChunks(t *testing.T) {
+	im, err := NewIndexManager(filepath.Join(t.TempDir(), "index"))
+	require.NoError(t, err)
+	defer im.Index.Close()
+
+	exists := func(id string) bool {
+		doc, err := im.GetDocument(id)
+		require.NoError(t, err)
+		return doc != nil
+	}
+
+	version, err := im.ReplaceChunks("a.md", "a.md", 3, chunkTexts(0, "one", 1, "two", 2, "three"))
+	require.NoError(t, err)
+	assert.Equal(t, 1, version)
+
+	// A shorter version removes the chunks past its end
+	version, err = im.ReplaceChunks("a.md", "a.md", 1, chunkTexts(0, "uno"))
+	require.NoError(t, err)
+	assert.Equal(t, 2, version)
+	assert.True(t, exists("a.md-0"))
+	assert.False(t, exists("a.md-1"))
+	assert.False(t, exists("a.md-2"))
+	version, chunks, err := im.DocumentVersion("a.md")
+	require.NoError(t, err)
+	assert.Equal(t, []int{2, 1}, []int{version, chunks})
+
+	// Chunks left out are kept unless they were marked as duplicates
+	_, err = im.ReplaceChunks("a.md", "a.md", 2, chunkTexts(1, "dos"))
+	require.NoError(t, err)
+	assert.True(t, exists("a.md-0"))
+	require.NoError(t, im.MarkDuplicate("a.md-0", "b.md-0"))
+	_, err = im.ReplaceChunks("a.md", "a.md", 2, chunkTexts(1, "dos"))
+	require.NoError(t, err)
+	assert.False(t, exists("a.md-0"))
+	assert.True(t, exists("a.md-1"))
+
+	require.NoError(t, im.DeleteDocument("a.md"))
+	version, _, err = im.DocumentVersion("a.md")
+	require.NoError(t, err)
+	assert.Zero(t, version)
+}
+
+func TestCollectGarbage(t *testing.T) {
+	im, err := NewIndexManager(filepath.Join(t.TempDir(), "index"))
+	require.NoError(t, err)
+	defer im.Index.Close()
+
+	// Chunks of a document indexed in full are kept, those of a document gone aren't
+	require.NoError(t, im.IndexFullDocument("kept.md", "content", "kept.md"))
+	require.NoError(t, im.IndexDocumentChunk("kept.md-0", "content", "kept.md"))
+	require.NoError(t, im.IndexDocumentChunk("gone.md-0", "content", "gone.md"))
+	require.NoError(t, im.IndexDocumentChunk("gone.md-1", "content", "gone.md"))
+	// A full document whose ID looks like a chunk's isn't one
+	require.NoError(t, im.IndexFullDocument("notes-2", "content", "notes-2"))
+
+	// Chunks past the current version are stale
+	_, err = im.ReplaceChunks("versioned.md", "versioned.md", 1, chunkTexts(0, "content"))
+	require.NoError(t, err)
+	require.NoError(t, im.IndexDocumentChunk("versioned.md-1", "stale", "versioned.md"))
+
+	removed, err := im.CollectGarbage(context.Background())
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []string{"gone.md-0", "gone.md-1", "versioned.md-1"}, removed)
+	for _, id := range []string{"kept.md-0", "notes-2", "versioned.md-0"} {
+		doc, err := im.GetDocument(id)
+		require.NoError(t, err)
+		assert.NotNil(t, doc, id)
+	}
+
+	removed, err = im.CollectGarbage(context.Background())
+	require.NoError(t, err)
+	assert.Empty(t, removed)
+}
@@ -2,37 +2,92 @@ package edata
 
 import (
 	"bytes"
+	"encoding/binary"
 	"encoding/gob"
 	"errors"
 	"math"
+	"sort"
+	"strconv"
 	"sync"
+	"time"
 
 	"gorm.io/driver/sqlite"
 	"gorm.io/gorm"
+
+	"manifold/internal/hnsw"
 )
 
 // Global database variable
 var db *gorm.DB
 var dbOnce sync.Once
 
+// index is the in-memory HNSW index of the embeddings by document ID, built by InitDB
+var index *hnsw.Index
+
+// embeddingBatchSize is the number of embeddings read or written per query
+const embeddingBatchSize = 500
+
+// ErrNotInitialized is returned by the functions of the package before InitDB
+var ErrNotInitialized = errors.New("database not initialized")
+
 // Initialize the database connection and migrate the schema
 func InitDB(dbName string) error {
 	var err error
 	dbOnce.Do(func() {
-		db, err = gorm.Open(sqlite.Open(dbName), &gorm.Config{})
+		var conn *gorm.DB
+		conn, err = gorm.Open(sqlite.Open(dbName), &gorm.Config{})
 		if err != nil {
 			return
 		}
 		// Migrate the schema
-		err = db.AutoMigrate(&Document{}, &Embedding{}, &GraphNode{}, &GraphEdge{})
+		if err = conn.AutoMigrate(&Document{}, &Embedding{}, &GraphNode{}, &GraphEdge{}, &Entity{}, &EntityMention{}, &Relation{}); err != nil {
+			return
+		}
+		if index, err = buildIndex(conn); err != nil {
+			return
+		}
+		db = conn
 	})
 	return err
 }
 
+// buildIndex indexes the embeddings stored, reading them in batches
+func buildIndex(conn *gorm.DB) (*hnsw.Index, error) {
+	idx := hnsw.New(hnsw.Config{})
+	var batch []Embedding
+	err := conn.FindInBatches(&batch, embeddingBatchSize, func(tx *gorm.DB, _ int) error {
+		for _, e := range batch {
+			_ = idx.Insert(indexKey(e.DocumentID), e.Vector)
+		}
+		return nil
+	}).Error
+	return idx, err
+}
+
+// indexKey returns the key of a document in the index
+func indexKey(docID uint) string {
+	return strconv.FormatUint(uint64(docID), 10)
+}
+
+// Enabled reports whether the database is initialized
+func Enabled() bool {
+	return db != nil
+}
+
+// Kinds of documents
+const (
+	KindChat     = "chat"
+	KindDocument = "document"
+)
+
 // Document model stores the text documents
 type Document struct {
-	ID   uint   `gorm:"primaryKey"`
-	Text string `gorm:"type:text"`
+	ID        uint      `gorm:"primaryKey" json:"id"`
+	Kind      string    `gorm:"index:idx_edata_kind_source" json:"kind,omitempty"`
+	Source    string    `gorm:"index:idx_edata_kind_source" json:"source,omitempty"`
+	Text      string    `gorm:"type:text" json:"text"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
 }
 
 // Embedding model stores the vector embeddings associated with documents
@@ -68,27 +123,41 @@ func (e *Embedding) AfterFind(tx *gorm.DB) (err error) {
 	return
 }
 
-// Serialize the vector into bytes using encoding/gob
+// vectorFormatBinary prefixes the vectors serialized as little-endian float64 values. Vectors
+// serialized before with encoding/gob never start with it.
+const vectorFormatBinary = 0
+
+// Serialize the vector into bytes, a format byte and its values, in a single allocation
 func serializeVector(v []float64) ([]byte, error) {
-	var buf bytes.Buffer
-	enc := gob.NewEncoder(&buf)
-	err := enc.Encode(v)
-	return buf.Bytes(), err
+	data := make([]byte, 1+8*len(v))
+	data[0] = vectorFormatBinary
+	for i, value := range v {
+		binary.LittleEndian.PutUint64(data[1+8*i:], math.Float64bits(value))
+	}
+	return data, nil
 }
 
-// Deserialize the vector from bytes using encoding/gob
+// Deserialize the vector from bytes, those serialized with encoding/gob too
 func deserializeVector(data []byte) ([]float64, error) {
-	var v []float64
-	buf := bytes.NewBuffer(data)
-	dec := gob.NewDecoder(buf)
-	err := dec.Decode(&v)
-	return v, err
+	if len(data) == 0 || data[0] != vectorFormatBinary {
+		var v []float64
+		err := gob.NewDecoder(bytes.NewReader(data)).Decode(&v)
+		return v, err
+	}
+	if (len(data)-1)%8 != 0 {
+		return nil, errors.New("invalid vector length")
+	}
+	v := make([]float64, (len(data)-1)/8)
+	for i := range v {
+		v[i] = math.Float64frombits(binary.LittleEndian.Uint64(data[1+8*i:]))
+	}
+	return v, nil
 }
 
 // SaveDocument stores a text document and its vector embedding
 func SaveDocument(text string, vector []float64) (*Document, error) {
 	if db == nil {
-		return nil, errors.New("database not initialized")
+		return nil, ErrNotInitialized
 	}
 	doc := Document{Text: text}
 	if err := db.Create(&doc).Error; err != nil {
@@ -104,15 +173,91 @@ func SaveDocument(text string, vector []float64) (*Document, error) {
 		if err := db.Create(&embedding).Error; err != nil {
 			return &doc, err
 		}
+		_ = index.Insert(indexKey(doc.ID), vector)
 	}
 
 	return &doc, nil
 }
 
+// SourceDocument is a document stored by its source, see SaveSourceDocuments
+type SourceDocument struct {
+	Source string
+	Text   string
+	Vector []float64
+}
+
+// SaveSourceDocument stores a document of a kind by its source, replacing the text and embedding of
+// the one stored before with the same kind and source
+func SaveSourceDocument(kind, source, text string, vector []float64) (*Document, error) {
+	docs, err := SaveSourceDocuments(kind, []SourceDocument{{Source: source, Text: text, Vector: vector}})
+	if err != nil {
+		return nil, err
+	}
+	return &docs[0], nil
+}
+
+// SaveSourceDocuments stores documents of a kind like SaveSourceDocument, in a single transaction
+// with their embeddings inserted in batches
+func SaveSourceDocuments(kind string, sources []SourceDocument) ([]Document, error) {
+	if db == nil {
+		return nil, ErrNotInitialized
+	}
+	docs := make([]Document, len(sources))
+	err := db.Transaction(func(tx *gorm.DB) error {
+		var embeddings []Embedding
+		for i, source := range sources {
+			doc := &docs[i]
+			result := tx.Where(Document{Kind: kind, Source: source.Source}).Attrs(Document{Text: source.Text}).FirstOrCreate(doc)
+			if result.Error != nil {
+				return result.Error
+			}
+			if result.RowsAffected == 0 {
+				doc.Text = source.Text
+				if err := tx.Save(doc).Error; err != nil {
+					return err
+				}
+			}
+			if err := tx.Where("document_id = ?", doc.ID).Delete(&Embedding{}).Error; err != nil {
+				return err
+			}
+			if source.Vector != nil {
+				embeddings = append(embeddings, Embedding{DocumentID: doc.ID, Vector: source.Vector})
+			}
+		}
+		if len(embeddings) == 0 {
+			return nil
+		}
+		return tx.CreateInBatches(embeddings, embeddingBatchSize).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	for i, source := range sources {
+		if source.Vector != nil {
+			_ = index.Insert(indexKey(docs[i].ID), source.Vector)
+		} else {
+			index.Remove(indexKey(docs[i].ID))
+		}
+	}
+	return docs, nil
+}
+
+// GetDocument retrieves a document by its ID
+func GetDocument(id uint) (*Document, error) {
+	if db == nil {
+		return nil, ErrNotInitialized
+	}
+	var doc Document
+	if err := db.First(&doc, id).Error; err != nil {
+		return nil, err
+	}
+	return &doc, nil
+}
+
 // AddGraphEdge adds an edge between two documents in the graph
 func AddGraphEdge(fromDocID, toDocID uint) error {
 	if db == nil {
-		return errors.New("database not initialized")
+		return ErrNotInitialized
 	}
 	fromNode, err := getOrCreateGraphNode(fromDocID)
 	if err != nil {
@@ -145,53 +290,55 @@ func getOrCreateGraphNode(docID uint) (GraphNode, error) {
 	return node, nil
 }
 
-// GetSimilarDocuments retrieves documents with embeddings similar to the input vector
+// similarSearchSize is the number of embeddings searched first for similar documents, widened
+// until the least similar found is under the threshold
+const similarSearchSize = 32
+
+// GetSimilarDocuments retrieves documents with embeddings similar to the input vector through the
+// index, the most similar first
 func GetSimilarDocuments(vector []float64, threshold float64) ([]Document, error) {
 	if db == nil {
-		return nil, errors.New("database not initialized")
+		return nil, ErrNotInitialized
 	}
-	var embeddings []Embedding
-	if err := db.Find(&embeddings).Error; err != nil {
-		return nil, err
+	var results []hnsw.Result
+	for n := similarSearchSize; ; n *= 4 {
+		results = index.Search(vector, n)
+		if len(results) < n || results[len(results)-1].Similarity < threshold {
+			break
+		}
 	}
 
-	var similarDocs []Document
-	for _, e := range embeddings {
-		if err := e.AfterFind(nil); err != nil {
-			continue
+	similarity := make(map[uint]float64, len(results))
+	ids := make([]uint, 0, len(results))
+	for _, result := range results {
+		if result.Similarity < threshold {
+			break
 		}
-		sim := cosineSimilarity(vector, e.Vector)
-		if sim >= threshold {
-			var doc Document
-			if err := db.First(&doc, e.DocumentID).Error; err == nil {
-				similarDocs = append(similarDocs, doc)
-			}
+		id, err := strconv.ParseUint(result.Key, 10, 64)
+		if err != nil {
+			continue
 		}
+		similarity[uint(id)] = result.Similarity
+		ids = append(ids, uint(id))
 	}
-	return similarDocs, nil
-}
-
-// cosineSimilarity calculates the cosine similarity between two vectors
-func cosineSimilarity(v1, v2 []float64) float64 {
-	if len(v1) != len(v2) {
-		return 0.0
+	if len(ids) == 0 {
+		return nil, nil
 	}
-	var dotProduct, normA, normB float64
-	for i := 0; i < len(v1); i++ {
-		dotProduct += v1[i] * v2[i]
-		normA += v1[i] * v1[i]
-		normB += v2[i] * v2[i]
-	}
-	if normA == 0 || normB == 0 {
-		return 0.0
+
+	var similarDocs []Document
+	if err := db.Find(&similarDocs, ids).Error; err != nil {
+		return nil, err
 	}
-	return dotProduct / (math.Sqrt(normA) * math.Sqrt(normB))
+	sort.SliceStable(similarDocs, func(i, j int) bool {
+		return similarity[similarDocs[i].ID] > similarity[similarDocs[j].ID]
+	})
+	return similarDocs, nil
 }
 
 // GetConnectedDocuments retrieves documents connected to the starting document via the graph
 func GetConnectedDocuments(startDocID uint) ([]Document, error) {
 	if db == nil {
-		return nil, errors.New("database not initialized")
+		return nil, ErrNotInitialized
 	}
 	visited := make(map[uint]bool)
 	queue := []uint{startDocID}
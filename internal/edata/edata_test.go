@@ -0,0 +1,127 @@
+package edata
+
+import (
+	"bytes"
+	"encoding/gob"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestVectorSerialization(t *testing.T) {
+	vector := []float64{0.5, -1.25, 3e-9, 0}
+	data, err := serializeVector(vector)
+	require.NoError(t, err)
+	assert.Len(t, data, 33)
+	decoded, err := deserializeVector(data)
+	require.NoError(t, err)
+	assert.Equal(t, vector, decoded)
+
+	// Vectors serialized with encoding/gob are still read
+	var legacy bytes.Buffer
+	require.NoError(t, gob.NewEncoder(&legacy).Encode(vector))
+	decoded, err = deserializeVector(legacy.Bytes())
+	require.NoError(t, err)
+	assert.Equal(t, vector, decoded)
+
+	_, err = deserializeVector([]byte{vectorFormatBinary, 1, 2})
+	assert.Error(t, err)
+}
+
+// testDBOnce opens the database the tests share, as InitDB opens one per process.
+var testDBOnce sync.Once
+
+func initTestDB(t *testing.T) {
+	testDBOnce.Do(func() {
+		dir, err := os.MkdirTemp("", "edata")
+		require.NoError(t, err)
+		require.NoError(t, InitDB(filepath.Join(dir, "edata.db")))
+	})
+	require.True(t, Enabled())
+}
+
+func TestGetSimilarDocuments(t *testing.T) {
+	initTestDB(t)
+
+	var sources []SourceDocument
+	for i := 0; i < 100; i++ {
+		sources = append(sources, SourceDocument{Source: fmt.Sprint(i), Text: fmt.Sprint("doc ", i), Vector: []float64{1, float64(i) / 100}})
+	}
+	sources = append(sources, SourceDocument{Source: "none", Text: "no embedding"})
+	docs, err := SaveSourceDocuments(KindDocument, sources)
+	require.NoError(t, err)
+	require.Len(t, docs, 101)
+
+	// All documents over the threshold are found, the most similar first
+	similar, err := GetSimilarDocuments([]float64{1, 0}, 0.9)
+	require.NoError(t, err)
+	require.Len(t, similar, 49)
+	for i, doc := range similar {
+		assert.Equal(t, fmt.Sprint(i), doc.Source)
+	}
+
+	// Documents saved again replace their embedding
+	_, err = SaveSourceDocument(KindDocument, "0", "moved", []float64{0, 1})
+	require.NoError(t, err)
+	_, err = SaveSourceDocument(KindDocument, "1", "no longer embedded", nil)
+	require.NoError(t, err)
+	similar, err = GetSimilarDocuments([]float64{1, 0}, 0.9)
+	require.NoError(t, err)
+	require.Len(t, similar, 47)
+	assert.Equal(t, "2", similar[0].Source)
+	similar, err = GetSimilarDocuments([]float64{0, 1}, 0.99)
+	require.NoError(t, err)
+	require.Len(t, similar, 1)
+	assert.Equal(t, "moved", similar[0].Text)
+
+	similar, err = GetSimilarDocuments([]float64{1, 2, 3}, 0.5)
+	require.NoError(t, err)
+	assert.Empty(t, similar)
+}
+
+func TestEntities(t *testing.T) {
+	initTestDB(t)
+	assert.Equal(t, "acme corp", EntityKey("  Acme   Corp. "))
+
+	require.NoError(t, SaveChunkEntities(1, "a-0", []ExtractedEntity{{Name: "Acme Corp.", Type: "organization"}, {Name: "Jane"}},
+		[]ExtractedRelation{{From: "Jane", Type: "works_for", To: "acme corp"}, {From: "Acme Corp", Type: "based_in", To: "Springfield"}}))
+	require.NoError(t, SaveChunkEntities(2, "b-0", []ExtractedEntity{{Name: "Springfield", Type: "place"}, {Name: "Jane", Type: "person"}}, nil))
+	require.NoError(t, SaveChunkEntities(3, "c-0", []ExtractedEntity{{Name: "Bob", Type: "person"}}, nil))
+
+	entities, err := FindEntities([]string{"jane", "SPRINGFIELD", "nobody"})
+	require.NoError(t, err)
+	require.Len(t, entities, 2)
+	types := map[string]string{}
+	for _, e := range entities {
+		types[e.Name] = e.Type
+	}
+	assert.Equal(t, map[string]string{"Jane": "person", "Springfield": "place"}, types)
+
+	jane, err := FindEntities([]string{"Jane"})
+	require.NoError(t, err)
+	ids, err := ExpandEntities([]uint{jane[0].ID}, 1)
+	require.NoError(t, err)
+	assert.Len(t, ids, 2)
+	ids, err = ExpandEntities([]uint{jane[0].ID}, 2)
+	require.NoError(t, err)
+	assert.Len(t, ids, 3)
+
+	// Chunks mentioning the most entities come first
+	chunks, err := EntityChunks(ids, 5)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"a-0", "b-0"}, chunks)
+
+	// Extracting a chunk again replaces its mentions and relations
+	require.NoError(t, SaveChunkEntities(1, "a-0", []ExtractedEntity{{Name: "Jane"}}, nil))
+	ids, err = ExpandEntities([]uint{jane[0].ID}, 2)
+	require.NoError(t, err)
+	assert.Len(t, ids, 1)
+	chunks, err = EntityChunks(ids, 1)
+	require.NoError(t, err)
+	assert.Len(t, chunks, 1)
+}
@@ -0,0 +1,196 @@
+package edata
+
+import (
+	"sort"
+	"strings"
+	"unicode"
+
+	"gorm.io/gorm"
+)
+
+// Entity is a named entity extracted from documents, unique by its normalized name
+type Entity struct {
+	ID   uint   `gorm:"primaryKey" json:"id"`
+	Key  string `gorm:"uniqueIndex" json:"-"`
+	Name string `json:"name"`
+	Type string `json:"type,omitempty"`
+}
+
+// EntityMention links an entity to the chunk of a document it is mentioned in
+type EntityMention struct {
+	ID         uint   `gorm:"primaryKey"`
+	EntityID   uint   `gorm:"index"`
+	DocumentID uint   `gorm:"index"`
+	Chunk      string `gorm:"index"`
+}
+
+// Relation is a typed relationship between two entities, stated in the chunk of a document
+type Relation struct {
+	ID         uint   `gorm:"primaryKey"`
+	FromID     uint   `gorm:"index"`
+	ToID       uint   `gorm:"index"`
+	Type       string `gorm:"index"`
+	DocumentID uint   `gorm:"index"`
+	Chunk      string `gorm:"index"`
+}
+
+// ExtractedEntity is an entity found in a chunk
+type ExtractedEntity struct {
+	Name string `json:"name"`
+	Type string `json:"type"`
+}
+
+// ExtractedRelation is a relationship between two entities, by name, found in a chunk
+type ExtractedRelation struct {
+	From string `json:"from"`
+	Type string `json:"type"`
+	To   string `json:"to"`
+}
+
+// EntityKey normalizes the name of an entity to its lowercase words, so that "Acme Corp." and
+// "acme corp" are the same entity
+func EntityKey(name string) string {
+	words := strings.FieldsFunc(strings.ToLower(name), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+	return strings.Join(words, " ")
+}
+
+// SaveChunkEntities stores the entities and relations extracted from a chunk of a document,
+// replacing those extracted from it before. Entities named by relations only are stored too.
+func SaveChunkEntities(docID uint, chunk string, entities []ExtractedEntity, relations []ExtractedRelation) error {
+	if db == nil {
+		return ErrNotInitialized
+	}
+	return db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("chunk = ?", chunk).Delete(&EntityMention{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("chunk = ?", chunk).Delete(&Relation{}).Error; err != nil {
+			return err
+		}
+
+		ids := make(map[string]uint)
+		entity := func(name, kind string) (uint, error) {
+			key := EntityKey(name)
+			if id, ok := ids[key]; ok || key == "" {
+				return id, nil
+			}
+			var e Entity
+			if err := tx.Where(Entity{Key: key}).Attrs(Entity{Name: strings.TrimSpace(name), Type: kind}).FirstOrCreate(&e).Error; err != nil {
+				return 0, err
+			}
+			if e.Type == "" && kind != "" {
+				if err := tx.Model(&e).Update("type", kind).Error; err != nil {
+					return 0, err
+				}
+			}
+			ids[key] = e.ID
+			return e.ID, tx.Create(&EntityMention{EntityID: e.ID, DocumentID: docID, Chunk: chunk}).Error
+		}
+
+		for _, e := range entities {
+			if _, err := entity(e.Name, e.Type); err != nil {
+				return err
+			}
+		}
+		for _, r := range relations {
+			from, err := entity(r.From, "")
+			if err != nil {
+				return err
+			}
+			to, err := entity(r.To, "")
+			if err != nil {
+				return err
+			}
+			if from == 0 || to == 0 || from == to {
+				continue
+			}
+			relation := Relation{FromID: from, ToID: to, Type: r.Type, DocumentID: docID, Chunk: chunk}
+			if err := tx.Create(&relation).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// FindEntities retrieves the entities with any of the names
+func FindEntities(names []string) ([]Entity, error) {
+	if db == nil {
+		return nil, ErrNotInitialized
+	}
+	keys := make([]string, 0, len(names))
+	for _, name := range names {
+		if key := EntityKey(name); key != "" {
+			keys = append(keys, key)
+		}
+	}
+	if len(keys) == 0 {
+		return nil, nil
+	}
+	var entities []Entity
+	err := db.Where("key IN ?", keys).Find(&entities).Error
+	return entities, err
+}
+
+// ExpandEntities returns the IDs of the entities related to the starting ones within a number of
+// hops, in either direction, the starting ones included
+func ExpandEntities(ids []uint, hops int) ([]uint, error) {
+	if db == nil {
+		return nil, ErrNotInitialized
+	}
+	visited := make(map[uint]bool, len(ids))
+	frontier := make([]uint, 0, len(ids))
+	for _, id := range ids {
+		if !visited[id] {
+			visited[id] = true
+			frontier = append(frontier, id)
+		}
+	}
+	expanded := append([]uint(nil), frontier...)
+
+	for hop := 0; hop < hops && len(frontier) > 0; hop++ {
+		var relations []Relation
+		if err := db.Where("from_id IN ? OR to_id IN ?", frontier, frontier).Find(&relations).Error; err != nil {
+			return nil, err
+		}
+		frontier = frontier[:0]
+		for _, r := range relations {
+			for _, id := range []uint{r.FromID, r.ToID} {
+				if !visited[id] {
+					visited[id] = true
+					frontier = append(frontier, id)
+					expanded = append(expanded, id)
+				}
+			}
+		}
+	}
+	return expanded, nil
+}
+
+// EntityChunks returns at most limit chunks mentioning the entities, those mentioning the most of
+// them first
+func EntityChunks(ids []uint, limit int) ([]string, error) {
+	if db == nil {
+		return nil, ErrNotInitialized
+	}
+	if len(ids) == 0 || limit <= 0 {
+		return nil, nil
+	}
+	var mentions []EntityMention
+	if err := db.Where("entity_id IN ?", ids).Find(&mentions).Error; err != nil {
+		return nil, err
+	}
+
+	counts := make(map[string]int)
+	var chunks []string
+	for _, m := range mentions {
+		if counts[m.Chunk] == 0 {
+			chunks = append(chunks, m.Chunk)
+		}
+		counts[m.Chunk]++
+	}
+	sort.SliceStable(chunks, func(i, j int) bool { return counts[chunks[i]] > counts[chunks[j]] })
+	return chunks[:min(limit, len(chunks))], nil
+}
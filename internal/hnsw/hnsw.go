@@ -0,0 +1,462 @@
+// hnsw.go
+
+// Package hnsw implements hierarchical navigable small world graphs, approximate nearest neighbor
+// indexes of vectors by cosine similarity.
+package hnsw
+
+import (
+	"container/heap"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"math"
+	"math/rand"
+	"os"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Config tunes an HNSW index. EfSearch trades recall for speed at query time: the larger, the
+// closer results are to an exact search, and the slower.
+type Config struct {
+	M              int `yaml:"m,omitempty" json:"m"`                             // links per node and layer, 16 when unset
+	EfConstruction int `yaml:"ef_construction,omitempty" json:"ef_construction"` // candidates considered on insert, 200 when unset
+	EfSearch       int `yaml:"ef_search,omitempty" json:"ef_search"`             // candidates considered on search, 64 when unset
+}
+
+// withDefaults returns the config with the defaults of the fields unset.
+func (c Config) withDefaults() Config {
+	if c.M <= 0 {
+		c.M = 16
+	}
+	if c.EfConstruction <= 0 {
+		c.EfConstruction = 200
+	}
+	if c.EfSearch <= 0 {
+		c.EfSearch = 64
+	}
+	return c
+}
+
+// graphNode is a vector of the index, normalized so that cosine similarity is a dot product, with
+// its links on each of its layers. Replaced vectors stay in the graph, deleted, to keep it
+// connected.
+type graphNode struct {
+	Word      string // the key, named so in the indexes saved before the package was extracted
+	Vector    []float32
+	Neighbors [][]int32 // by layer, from 0 to the level of the node
+	Deleted   bool
+}
+
+// Index is a hierarchical navigable small world graph of vectors by key, an approximate nearest
+// neighbor index whose searches visit a logarithmic share of its nodes. It is safe for concurrent
+// use.
+type Index struct {
+	mu       sync.RWMutex
+	config   Config
+	nodes    []graphNode
+	keys     map[string]int32 // the live node of each key
+	entry    int32
+	maxLevel int
+	dim      int
+	rng      *rand.Rand
+	unsaved  int // inserts and removals since the last save or load
+}
+
+// New returns an empty index.
+func New(config Config) *Index {
+	return &Index{
+		config: config.withDefaults(),
+		keys:   make(map[string]int32),
+		entry:  -1,
+		rng:    rand.New(rand.NewSource(time.Now().UnixNano())),
+	}
+}
+
+// Len returns the number of vectors indexed.
+func (h *Index) Len() int {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	return len(h.keys)
+}
+
+// Contains reports whether the key is indexed.
+func (h *Index) Contains(key string) bool {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	_, ok := h.keys[key]
+	return ok
+}
+
+// Matches reports whether the key is indexed with the vector.
+func (h *Index) Matches(key string, vector []float64) bool {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	id, ok := h.keys[key]
+	if !ok {
+		return false
+	}
+	normalized := normalize(vector)
+	if len(normalized) != len(h.nodes[id].Vector) {
+		return false
+	}
+	for i, value := range normalized {
+		if h.nodes[id].Vector[i] != value {
+			return false
+		}
+	}
+	return true
+}
+
+// Keys returns the keys indexed.
+func (h *Index) Keys() []string {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	keys := make([]string, 0, len(h.keys))
+	for key := range h.keys {
+		keys = append(keys, key)
+	}
+	return keys
+}
+
+// UnsavedChanges returns the number of inserts and removals since the index was last saved or
+// loaded.
+func (h *Index) UnsavedChanges() int {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	return h.unsaved
+}
+
+// Remove removes the vector of a key from the index. Its node stays in the graph, deleted.
+func (h *Index) Remove(key string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if id, ok := h.keys[key]; ok {
+		h.nodes[id].Deleted = true
+		delete(h.keys, key)
+		h.unsaved++
+	}
+}
+
+// SetEfSearch changes the number of candidates searches consider.
+func (h *Index) SetEfSearch(ef int) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.config.EfSearch = ef
+	h.config = h.config.withDefaults()
+}
+
+// normalize returns the vector as float32 scaled to unit length, nil for vectors without magnitude.
+func normalize(vec []float64) []float32 {
+	var sumSquares float64
+	for _, value := range vec {
+		sumSquares += value * value
+	}
+	norm := math.Sqrt(sumSquares)
+	if norm == 0 {
+		return nil
+	}
+	normalized := make([]float32, len(vec))
+	for i, value := range vec {
+		normalized[i] = float32(value / norm)
+	}
+	return normalized
+}
+
+// distance returns the cosine distance of two normalized vectors.
+func distance(a, b []float32) float32 {
+	var dot float32
+	for i := range a {
+		dot += a[i] * b[i]
+	}
+	return 1 - dot
+}
+
+// Insert indexes the vector of a key, replacing the one indexed before. Vectors without magnitude, or
+// of another dimension than the first one indexed, are rejected.
+func (h *Index) Insert(key string, vector []float64) error {
+	normalized := normalize(vector)
+	if normalized == nil {
+		return errors.New("vector has no magnitude")
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.dim == 0 {
+		h.dim = len(normalized)
+	} else if len(normalized) != h.dim {
+		return fmt.Errorf("vector has %d dimensions, the index %d", len(normalized), h.dim)
+	}
+	if old, ok := h.keys[key]; ok {
+		h.nodes[old].Deleted = true
+	}
+	h.unsaved++
+
+	level := int(math.Floor(-math.Log(1-h.rng.Float64()) / math.Log(float64(h.config.M))))
+	id := int32(len(h.nodes))
+	h.nodes = append(h.nodes, graphNode{Word: key, Vector: normalized, Neighbors: make([][]int32, level+1)})
+	h.keys[key] = id
+	if h.entry < 0 {
+		h.entry, h.maxLevel = id, level
+		return nil
+	}
+
+	entry := h.entry
+	for layer := h.maxLevel; layer > level; layer-- {
+		entry = h.searchLayer(normalized, []int32{entry}, 1, layer)[0].id
+	}
+	entries := []int32{entry}
+	for layer := min(level, h.maxLevel); layer >= 0; layer-- {
+		candidates := h.searchLayer(normalized, entries, h.config.EfConstruction, layer)
+		neighbors := h.selectNeighbors(candidates, h.maxLinks(layer))
+		h.nodes[id].Neighbors[layer] = neighbors
+		for _, neighbor := range neighbors {
+			h.link(neighbor, id, layer)
+		}
+		entries = entries[:0]
+		for _, candidate := range candidates {
+			entries = append(entries, candidate.id)
+		}
+	}
+	if level > h.maxLevel {
+		h.entry, h.maxLevel = id, level
+	}
+	return nil
+}
+
+// maxLinks returns the number of links a node keeps on a layer, twice as many on the bottom one.
+func (h *Index) maxLinks(layer int) int {
+	if layer == 0 {
+		return 2 * h.config.M
+	}
+	return h.config.M
+}
+
+// link adds a link from a node to another on a layer, pruning its links when it has too many.
+func (h *Index) link(from, to int32, layer int) {
+	links := append(h.nodes[from].Neighbors[layer], to)
+	if len(links) > h.maxLinks(layer) {
+		candidates := make([]scored, len(links))
+		for i, link := range links {
+			candidates[i] = scored{id: link, distance: distance(h.nodes[from].Vector, h.nodes[link].Vector)}
+		}
+		sort.Slice(candidates, func(i, j int) bool { return candidates[i].distance < candidates[j].distance })
+		links = h.selectNeighbors(candidates, h.maxLinks(layer))
+	}
+	h.nodes[from].Neighbors[layer] = links
+}
+
+// selectNeighbors picks at most m of the candidates, sorted by distance, to link a node to: those
+// closer to it than to the neighbors already picked, so that links point in diverse directions,
+// then the closest of the others.
+func (h *Index) selectNeighbors(candidates []scored, m int) []int32 {
+	selected := make([]int32, 0, m)
+	var pruned []int32
+	for _, candidate := range candidates {
+		if len(selected) == m {
+			break
+		}
+		diverse := true
+		for _, neighbor := range selected {
+			if distance(h.nodes[candidate.id].Vector, h.nodes[neighbor].Vector) < candidate.distance {
+				diverse = false
+				break
+			}
+		}
+		if diverse {
+			selected = append(selected, candidate.id)
+		} else {
+			pruned = append(pruned, candidate.id)
+		}
+	}
+	for _, id := range pruned {
+		if len(selected) == m {
+			break
+		}
+		selected = append(selected, id)
+	}
+	return selected
+}
+
+// scored is a node found by a search and its distance to the query.
+type scored struct {
+	id       int32
+	distance float32
+}
+
+// candidateHeap is a heap of candidates, the closest on top, or the farthest when far is set.
+type candidateHeap struct {
+	items []scored
+	far   bool
+}
+
+func (c *candidateHeap) Len() int { return len(c.items) }
+func (c *candidateHeap) Less(i, j int) bool {
+	if c.far {
+		return c.items[i].distance > c.items[j].distance
+	}
+	return c.items[i].distance < c.items[j].distance
+}
+func (c *candidateHeap) Swap(i, j int) { c.items[i], c.items[j] = c.items[j], c.items[i] }
+func (c *candidateHeap) Push(x any)    { c.items = append(c.items, x.(scored)) }
+func (c *candidateHeap) Pop() any {
+	last := c.items[len(c.items)-1]
+	c.items = c.items[:len(c.items)-1]
+	return last
+}
+
+// searchLayer returns the ef nodes of a layer closest to the query found from the entry nodes, the
+// closest first.
+func (h *Index) searchLayer(query []float32, entries []int32, ef, layer int) []scored {
+	visited := make(map[int32]bool, ef*4)
+	candidates := &candidateHeap{}
+	results := &candidateHeap{far: true}
+	for _, entry := range entries {
+		if visited[entry] {
+			continue
+		}
+		visited[entry] = true
+		c := scored{id: entry, distance: distance(query, h.nodes[entry].Vector)}
+		heap.Push(candidates, c)
+		heap.Push(results, c)
+	}
+	for results.Len() > ef {
+		heap.Pop(results)
+	}
+
+	for candidates.Len() > 0 {
+		current := heap.Pop(candidates).(scored)
+		if results.Len() >= ef && current.distance > results.items[0].distance {
+			break
+		}
+		for _, neighbor := range h.nodes[current.id].Neighbors[layer] {
+			if visited[neighbor] {
+				continue
+			}
+			visited[neighbor] = true
+			d := distance(query, h.nodes[neighbor].Vector)
+			if results.Len() < ef || d < results.items[0].distance {
+				heap.Push(candidates, scored{id: neighbor, distance: d})
+				heap.Push(results, scored{id: neighbor, distance: d})
+				if results.Len() > ef {
+					heap.Pop(results)
+				}
+			}
+		}
+	}
+
+	sorted := make([]scored, results.Len())
+	for i := len(sorted) - 1; i >= 0; i-- {
+		sorted[i] = heap.Pop(results).(scored)
+	}
+	return sorted
+}
+
+// Result is a vector found by a search.
+type Result struct {
+	Key        string
+	Similarity float64 // cosine similarity to the query
+}
+
+// Search returns the n vectors indexed most similar to the query vector, approximately, the most
+// similar first.
+func (h *Index) Search(query []float64, n int) []Result {
+	return h.SearchFunc(query, n, h.EfSearch(), nil)
+}
+
+// Config returns the config of the index.
+func (h *Index) Config() Config {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	return h.config
+}
+
+// EfSearch returns the number of candidates searches consider.
+func (h *Index) EfSearch() int {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	return h.config.EfSearch
+}
+
+// SearchFunc returns the n vectors most similar to the query vector among those accepted, all when
+// accept is nil, considering ef candidates.
+func (h *Index) SearchFunc(query []float64, n, ef int, accept func(key string) bool) []Result {
+	normalized := normalize(query)
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	if n <= 0 || normalized == nil || h.entry < 0 || len(normalized) != h.dim {
+		return []Result{}
+	}
+
+	entry := h.entry
+	for layer := h.maxLevel; layer > 0; layer-- {
+		entry = h.searchLayer(normalized, []int32{entry}, 1, layer)[0].id
+	}
+	// Deleted nodes are found but not returned, search past as many of them as there are
+	ef = max(ef, n)
+	ef += min(len(h.nodes)-len(h.keys), ef)
+	results := make([]Result, 0, n)
+	for _, candidate := range h.searchLayer(normalized, []int32{entry}, ef, 0) {
+		node := h.nodes[candidate.id]
+		if node.Deleted || (accept != nil && !accept(node.Word)) {
+			continue
+		}
+		results = append(results, Result{Key: node.Word, Similarity: float64(1 - candidate.distance)})
+		if len(results) == n {
+			break
+		}
+	}
+	return results
+}
+
+// indexFile is the persisted form of an index.
+type indexFile struct {
+	Config   Config
+	Nodes    []graphNode
+	Entry    int32
+	MaxLevel int
+	Dim      int
+}
+
+// Save writes the index to a file.
+func (h *Index) Save(path string) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	f, err := os.Create(path)
+	if err != nil {
+		return fmt.Errorf("error creating index file: %v", err)
+	}
+	defer f.Close()
+	data := indexFile{Config: h.config, Nodes: h.nodes, Entry: h.entry, MaxLevel: h.maxLevel, Dim: h.dim}
+	if err := gob.NewEncoder(f).Encode(&data); err != nil {
+		return fmt.Errorf("error writing index: %v", err)
+	}
+	h.unsaved = 0
+	return f.Close()
+}
+
+// Load reads an index written by Save. Its EfSearch is that of the config when set.
+func Load(path string, config Config) (*Index, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var data indexFile
+	if err := gob.NewDecoder(f).Decode(&data); err != nil {
+		return nil, fmt.Errorf("error reading index: %v", err)
+	}
+
+	h := New(data.Config)
+	if config.EfSearch > 0 {
+		h.config.EfSearch = config.EfSearch
+	}
+	h.nodes, h.entry, h.maxLevel, h.dim = data.Nodes, data.Entry, data.MaxLevel, data.Dim
+	for id, node := range h.nodes {
+		if !node.Deleted {
+			h.keys[node.Word] = int32(id)
+		}
+	}
+	return h, nil
+}
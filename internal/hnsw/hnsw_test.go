@@ -0,0 +1,93 @@
+// hnsw_test.go
+package hnsw
+
+import (
+	"fmt"
+	"math/rand"
+	"path/filepath"
+	"sort"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestIndex(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	vectors := make(map[string][]float64)
+	index := New(Config{M: 8, EfConstruction: 64})
+	for i := 0; i < 1000; i++ {
+		v := make([]float64, 16)
+		for j := range v {
+			v[j] = rng.Float64()*2 - 1
+		}
+		key := fmt.Sprint(i)
+		vectors[key] = v
+		require.NoError(t, index.Insert(key, v))
+	}
+	assert.Error(t, index.Insert("zero", make([]float64, 16)))
+	assert.Error(t, index.Insert("short", []float64{1, 2}))
+	assert.Equal(t, 1000, index.Len())
+
+	// Results are close to those of an exact search
+	exact := func(query []float64, n int) map[string]bool {
+		keys := make([]string, 0, len(vectors))
+		similarity := make(map[string]float64, len(vectors))
+		for key, v := range vectors {
+			keys = append(keys, key)
+			similarity[key] = cosine(query, v)
+		}
+		sort.Slice(keys, func(i, j int) bool { return similarity[keys[i]] > similarity[keys[j]] })
+		top := make(map[string]bool, n)
+		for _, key := range keys[:n] {
+			top[key] = true
+		}
+		return top
+	}
+	found := 0
+	for key, query := range map[string][]float64{"7": vectors["7"], "70": vectors["70"], "700": vectors["700"]} {
+		results := index.Search(query, 10)
+		require.Len(t, results, 10)
+		assert.Equal(t, key, results[0].Key)
+		assert.InDelta(t, 1, results[0].Similarity, 1e-6)
+		top := exact(query, 10)
+		for i, result := range results {
+			assert.InDelta(t, cosine(query, vectors[result.Key]), result.Similarity, 1e-6)
+			if i > 0 {
+				assert.LessOrEqual(t, result.Similarity, results[i-1].Similarity)
+			}
+			if top[result.Key] {
+				found++
+			}
+		}
+	}
+	assert.GreaterOrEqual(t, found, 27)
+
+	// Removed and filtered keys are skipped
+	index.Remove("7")
+	assert.False(t, index.Contains("7"))
+	assert.NotEqual(t, "7", index.Search(vectors["7"], 1)[0].Key)
+	odd := func(key string) bool { return (key[len(key)-1]-'0')%2 == 1 }
+	filtered := index.SearchFunc(vectors["70"], 5, 64, odd)
+	require.Len(t, filtered, 5)
+	for _, result := range filtered {
+		assert.True(t, odd(result.Key), result.Key)
+	}
+
+	// Saved indexes are loaded with their graph and config
+	path := filepath.Join(t.TempDir(), "index.hnsw")
+	require.NoError(t, index.Save(path))
+	assert.Zero(t, index.UnsavedChanges())
+	loaded, err := Load(path, Config{EfSearch: 32})
+	require.NoError(t, err)
+	assert.Equal(t, Config{M: 8, EfConstruction: 64, EfSearch: 32}, loaded.Config())
+	assert.Equal(t, 999, loaded.Len())
+	assert.True(t, loaded.Matches("70", vectors["70"]))
+	assert.False(t, loaded.Matches("70", vectors["71"]))
+	assert.Equal(t, index.SearchFunc(vectors["700"], 5, 64, nil), loaded.SearchFunc(vectors["700"], 5, 64, nil))
+}
+
+func cosine(a, b []float64) float64 {
+	normalizedA, normalizedB := normalize(a), normalize(b)
+	return float64(1 - distance(normalizedA, normalizedB))
+}
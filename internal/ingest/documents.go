@@ -0,0 +1,288 @@
+// documents.go
+package ingest
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+
+	"manifold/internal/documents"
+)
+
+// DocumentsLoader loads documents of the documents package, e.g. those ingested by a
+// documents.DocumentManager, identified by their documents.DocumentKey.
+type DocumentsLoader []documents.Document
+
+func (l DocumentsLoader) Load(ctx context.Context, emit func(Document) error) error {
+	for _, doc := range l {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if err := emit(Document{ID: documents.DocumentKey(doc), Content: doc.PageContent, Metadata: doc.Metadata}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// SplitterChunker splits documents with the recursive character splitter of their language, found
+// from their metadata. Chunk IDs are the document ID followed by the index of the chunk.
+type SplitterChunker struct {
+	ChunkSize   int
+	OverlapSize int
+
+	// Sizes, when set, returns the sizes of each document from its metadata instead, e.g.
+	// DocumentManager.ChunkSizesFor.
+	Sizes func(metadata map[string]string) documents.ChunkSizes
+}
+
+func (s SplitterChunker) Chunk(doc Document) ([]Chunk, error) {
+	sizes := documents.ChunkSizes{ChunkSize: s.ChunkSize, OverlapSize: s.OverlapSize}
+	if s.Sizes != nil {
+		sizes = s.Sizes(doc.Metadata)
+	}
+	splitter, err := documents.NewSplitter(doc.Metadata, sizes.ChunkSize, sizes.OverlapSize)
+	if err != nil {
+		return nil, err
+	}
+
+	texts := splitter.SplitText(doc.Content)
+	chunks := make([]Chunk, len(texts))
+	for i, text := range texts {
+		chunks[i] = Chunk{
+			ID:         fmt.Sprintf("%s-%d", doc.ID, i),
+			DocumentID: doc.ID,
+			Index:      i,
+			Text:       text,
+			Metadata:   doc.Metadata,
+		}
+	}
+	locateChunks(doc, chunks)
+	return chunks, nil
+}
+
+// locateChunks sets the page and section metadata of the chunks of a document with an outline, such
+// as a PDF or a Markdown file with headings, so that citations of the chunks say where they come
+// from.
+func locateChunks(doc Document, chunks []Chunk) {
+	outline := documents.NewOutline(documents.Document{PageContent: doc.Content, Metadata: doc.Metadata})
+	if outline.Empty() {
+		return
+	}
+	texts := make([]string, len(chunks))
+	for i, chunk := range chunks {
+		texts[i] = chunk.Text
+	}
+	pages, sections := outline.Locate(doc.Content, texts)
+	for i := range chunks {
+		metadata := make(map[string]string, len(doc.Metadata)+2)
+		for key, value := range doc.Metadata {
+			metadata[key] = value
+		}
+		if pages[i] > 0 {
+			metadata["page"] = strconv.Itoa(pages[i])
+		}
+		if sections[i] != "" {
+			metadata["section"] = sections[i]
+		}
+		chunks[i].Metadata = metadata
+	}
+}
+
+// BleveSink indexes chunks in the bleve index of an IndexManager, with the source of their document
+// as file path, and records their hashes for DuplicateFilter.
+type BleveSink struct {
+	Index *documents.IndexManager
+}
+
+func (s BleveSink) Write(ctx context.Context, chunks []Chunk) error {
+	for _, chunk := range chunks {
+		if err := s.Index.IndexDocumentChunk(chunk.ID, chunk.Text, chunk.Metadata["source"]); err != nil {
+			return fmt.Errorf("failed to index chunk: %w", err)
+		}
+		if err := s.Index.RecordChunk(chunk.ID, chunk.Text); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// DuplicateFilter returns a pipeline filter dropping the chunks that duplicate one of another
+// document, indexed in the IndexManager or in their batch: exactly, or when maxDistance is positive,
+// with a simhash at most that many bits away. They are marked as duplicates in the index. Chunks
+// indexed with the same content already are dropped too. Chunks of the same document aren't
+// duplicates of each other, as they are replaced together.
+func DuplicateFilter(index *documents.IndexManager, maxDistance int) func(ctx context.Context, chunks []Chunk) ([]Chunk, error) {
+	return func(ctx context.Context, chunks []Chunk) ([]Chunk, error) {
+		seen := make(map[string]string, len(chunks))
+		var kept []Chunk
+		for _, chunk := range chunks {
+			hash := documents.GenerateMD5Hash(chunk.Text)
+			original, ok := seen[hash]
+			if !ok {
+				var err error
+				if original, err = index.FindDuplicate(chunk.ID, chunk.Text, maxDistance); err != nil {
+					return nil, err
+				}
+			}
+			if original == chunk.ID {
+				continue
+			}
+			if original == "" || strings.HasPrefix(original, chunk.DocumentID+"-") {
+				seen[hash] = chunk.ID
+				kept = append(kept, chunk)
+				continue
+			}
+			if err := index.MarkDuplicate(chunk.ID, original); err != nil {
+				return nil, err
+			}
+		}
+		return kept, nil
+	}
+}
+
+// VersionedSink indexes the chunks of each document as a new version of it in an IndexManager. The
+// chunks are held until Commit, which replaces those of the previous version of each document at
+// once, so that searches never see a mix of versions and chunks of longer versions don't linger.
+type VersionedSink struct {
+	Index *documents.IndexManager
+
+	// NearDuplicateDistance is the distance of DuplicateFilter, which drops the chunks duplicating
+	// those of other documents. Exact duplicates always are.
+	NearDuplicateDistance int
+
+	mu     sync.Mutex
+	chunks map[string][]Chunk
+	order  []string
+}
+
+func (s *VersionedSink) Write(ctx context.Context, chunks []Chunk) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.chunks == nil {
+		s.chunks = make(map[string][]Chunk)
+	}
+	for _, chunk := range chunks {
+		if _, ok := s.chunks[chunk.DocumentID]; !ok {
+			s.order = append(s.order, chunk.DocumentID)
+		}
+		s.chunks[chunk.DocumentID] = append(s.chunks[chunk.DocumentID], chunk)
+	}
+	return nil
+}
+
+// Commit indexes the chunks written as a new version of each document, in the order they were
+// written, and returns the result of each. A document failing doesn't stop the others.
+func (s *VersionedSink) Commit(ctx context.Context) []StoreResult {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	filter := DuplicateFilter(s.Index, s.NearDuplicateDistance)
+	results := make([]StoreResult, 0, len(s.order))
+	for _, id := range s.order {
+		chunks := s.chunks[id]
+		result := StoreResult{ID: id, Chunks: len(chunks)}
+		kept, err := filter(ctx, chunks)
+		if err == nil {
+			texts := make(map[int]documents.IndexedChunk, len(kept))
+			for _, chunk := range kept {
+				texts[chunk.Index] = documents.IndexedChunk{Text: chunk.Text, Metadata: chunk.Metadata}
+			}
+			result.Version, err = s.Index.ReplaceChunks(id, chunks[0].Metadata["source"], len(chunks), texts)
+		}
+		if err != nil {
+			result.Error = err.Error()
+		}
+		results = append(results, result)
+	}
+	s.chunks, s.order = nil, nil
+	return results
+}
+
+// SplitDocuments splits the documents of the manager and indexes their chunks in its index, when
+// it has one, as new versions of the documents. It returns the chunks of each document by key, as
+// DocumentManager.SplitDocuments. Documents are split with the chunker, a SplitterChunker with the
+// sizes of the manager when nil.
+func SplitDocuments(ctx context.Context, dm *documents.DocumentManager, chunker Chunker) (map[string][]string, error) {
+	var mu sync.Mutex
+	splits := make(map[string][]string)
+	pipeline := &Pipeline{
+		Loader:  DocumentsLoader(dm.Documents),
+		Chunker: documentsChunker(dm, chunker),
+		Sinks: []Sink{SinkFunc(func(ctx context.Context, chunks []Chunk) error {
+			mu.Lock()
+			defer mu.Unlock()
+			for _, chunk := range chunks {
+				splits[chunk.DocumentID] = append(splits[chunk.DocumentID], chunk.Text)
+			}
+			return nil
+		})},
+	}
+	var sink *VersionedSink
+	if dm.IndexManager != nil {
+		sink = &VersionedSink{Index: dm.IndexManager, NearDuplicateDistance: dm.NearDuplicateDistance}
+		pipeline.Sinks = append(pipeline.Sinks, sink)
+	}
+
+	if _, err := pipeline.Run(ctx); err != nil {
+		return nil, err
+	}
+	if sink != nil {
+		for _, result := range sink.Commit(ctx) {
+			if result.Error != "" {
+				return nil, fmt.Errorf("failed to index document %s: %s", result.ID, result.Error)
+			}
+		}
+	}
+	return splits, nil
+}
+
+// documentsChunker returns the chunker, the splitter with the sizes of the manager when nil.
+func documentsChunker(dm *documents.DocumentManager, chunker Chunker) Chunker {
+	if chunker == nil {
+		return SplitterChunker{Sizes: dm.ChunkSizesFor}
+	}
+	return chunker
+}
+
+// StoreResult is the outcome of storing a document: its chunks and the version of the document
+// indexed, or the error it failed with.
+type StoreResult struct {
+	ID      string `json:"id"`
+	Chunks  int    `json:"chunks"`
+	Version int    `json:"version,omitempty"`
+	Error   string `json:"error,omitempty"`
+}
+
+// StoreDocuments adds the documents to the manager, which redacts them, and indexes their chunks in
+// its index as SplitDocuments, replacing those of documents stored before with the same key. A
+// document failing doesn't stop the others; the result of each is returned in order.
+func StoreDocuments(ctx context.Context, dm *documents.DocumentManager, docs []documents.Document, chunker Chunker) ([]StoreResult, error) {
+	if dm.IndexManager == nil {
+		return nil, fmt.Errorf("document manager has no index")
+	}
+	stored := dm.IngestDocuments(docs)
+
+	sink := &VersionedSink{Index: dm.IndexManager, NearDuplicateDistance: dm.NearDuplicateDistance}
+	pipeline := &Pipeline{
+		Loader:  DocumentsLoader(stored),
+		Chunker: documentsChunker(dm, chunker),
+		Sinks:   []Sink{sink},
+	}
+	if _, err := pipeline.Run(ctx); err != nil {
+		return nil, err
+	}
+
+	committed := make(map[string]StoreResult, len(stored))
+	for _, result := range sink.Commit(ctx) {
+		committed[result.ID] = result
+	}
+	results := make([]StoreResult, len(stored))
+	for i, doc := range stored {
+		id := documents.DocumentKey(doc)
+		results[i] = committed[id]
+		results[i].ID = id
+	}
+	return results, nil
+}
@@ -0,0 +1,127 @@
+// embedder.go
+package ingest
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"math/rand"
+	"net/http"
+	"sort"
+	"time"
+)
+
+// retryDelay returns how long to wait before retrying a failed embeddings request: quadratic in
+// the attempt, capped at 30 seconds, with up to a second of jitter.
+var retryDelay = func(attempt int) time.Duration {
+	return min(time.Duration(attempt*attempt)*time.Second, 30*time.Second) + time.Duration(rand.Intn(1000))*time.Millisecond
+}
+
+// HTTPEmbedder embeds texts with an OpenAI compatible embeddings endpoint, retrying failed
+// requests.
+type HTTPEmbedder struct {
+	URL        string
+	Model      string // left out of requests when empty
+	APIKey     string
+	Dimensions int          // when set, embeddings of another size are an error
+	Attempts   int          // 3 when unset
+	Client     *http.Client // one with a 30 second timeout when nil
+}
+
+// NewHTTPEmbedder returns an embedder for the endpoint.
+func NewHTTPEmbedder(url, model string) *HTTPEmbedder {
+	return &HTTPEmbedder{URL: url, Model: model}
+}
+
+// Embed returns the embeddings of the texts, in order.
+func (e *HTTPEmbedder) Embed(ctx context.Context, texts []string) ([][]float32, error) {
+	attempts := e.Attempts
+	if attempts <= 0 {
+		attempts = 3
+	}
+
+	var err error
+	for attempt := 1; attempt <= attempts; attempt++ {
+		var embeddings [][]float32
+		if embeddings, err = e.embed(ctx, texts); err == nil {
+			return embeddings, nil
+		}
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		log.Printf("Attempt %d: %v", attempt, err)
+		if attempt < attempts {
+			select {
+			case <-time.After(retryDelay(attempt)):
+			case <-ctx.Done():
+				return nil, ctx.Err()
+			}
+		}
+	}
+	return nil, fmt.Errorf("failed to fetch embeddings after %d attempts: %w", attempts, err)
+}
+
+func (e *HTTPEmbedder) embed(ctx context.Context, texts []string) ([][]float32, error) {
+	payload := map[string]interface{}{"input": texts, "encoding_format": "float"}
+	if e.Model != "" {
+		payload["model"] = e.Model
+	}
+	reqBytes, err := json.Marshal(payload)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal embedding request: %v", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, e.URL, bytes.NewReader(reqBytes))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create HTTP request for embedding: %v", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if e.APIKey != "" {
+		req.Header.Set("Authorization", "Bearer "+e.APIKey)
+	}
+
+	client := e.Client
+	if client == nil {
+		client = &http.Client{Timeout: 30 * time.Second}
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to send HTTP request for embedding: %v", err)
+	}
+	defer resp.Body.Close()
+
+	respBytes, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read embedding response: %v", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("non-OK HTTP status: %s, body: %s", resp.Status, respBytes)
+	}
+
+	var embeddingResp struct {
+		Data []struct {
+			Embedding []float32 `json:"embedding"`
+			Index     int       `json:"index"`
+		} `json:"data"`
+	}
+	if err := json.Unmarshal(respBytes, &embeddingResp); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal embedding response: %v", err)
+	}
+	if len(embeddingResp.Data) != len(texts) {
+		return nil, fmt.Errorf("got %d embeddings for %d texts", len(embeddingResp.Data), len(texts))
+	}
+
+	// Embeddings are returned in order by servers leaving the index out
+	sort.SliceStable(embeddingResp.Data, func(i, j int) bool { return embeddingResp.Data[i].Index < embeddingResp.Data[j].Index })
+	embeddings := make([][]float32, len(texts))
+	for i, data := range embeddingResp.Data {
+		if e.Dimensions > 0 && len(data.Embedding) != e.Dimensions {
+			return nil, fmt.Errorf("embedding dimension mismatch at index %d: expected %d, got %d", i, e.Dimensions, len(data.Embedding))
+		}
+		embeddings[i] = data.Embedding
+	}
+	return embeddings, nil
+}
@@ -0,0 +1,240 @@
+// ingest.go
+package ingest
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+)
+
+// DefaultBatchSize is the number of chunks embedded and written together when a pipeline doesn't
+// set one.
+const DefaultBatchSize = 16
+
+// ErrFailedChunks is returned by a pipeline run when some batches of chunks failed to be ingested.
+var ErrFailedChunks = errors.New("chunks failed to be ingested")
+
+// Document is a document to ingest, e.g. a file, a PDF or a row of a dataset.
+type Document struct {
+	ID       string
+	Content  string
+	Metadata map[string]string
+}
+
+// Chunk is a part of a document, embedded when the pipeline has an embedder.
+type Chunk struct {
+	ID         string
+	DocumentID string
+	Index      int // of the chunk in its document
+	Text       string
+	Metadata   map[string]string // of the document
+	Embedding  []float32
+}
+
+// Loader emits the documents of a source, e.g. the files of a repository. Loading stops at the
+// first error returned by emit.
+type Loader interface {
+	Load(ctx context.Context, emit func(Document) error) error
+}
+
+// LoaderFunc adapts a function to a Loader.
+type LoaderFunc func(ctx context.Context, emit func(Document) error) error
+
+func (f LoaderFunc) Load(ctx context.Context, emit func(Document) error) error {
+	return f(ctx, emit)
+}
+
+// Chunker splits a document into chunks.
+type Chunker interface {
+	Chunk(doc Document) ([]Chunk, error)
+}
+
+// Embedder embeds texts, returning an embedding per text in order.
+type Embedder interface {
+	Embed(ctx context.Context, texts []string) ([][]float32, error)
+}
+
+// EmbedderFunc adapts a function to an Embedder.
+type EmbedderFunc func(ctx context.Context, texts []string) ([][]float32, error)
+
+func (f EmbedderFunc) Embed(ctx context.Context, texts []string) ([][]float32, error) {
+	return f(ctx, texts)
+}
+
+// Sink stores chunks, e.g. in a search index or a vector table.
+type Sink interface {
+	Write(ctx context.Context, chunks []Chunk) error
+}
+
+// SinkFunc adapts a function to a Sink.
+type SinkFunc func(ctx context.Context, chunks []Chunk) error
+
+func (f SinkFunc) Write(ctx context.Context, chunks []Chunk) error {
+	return f(ctx, chunks)
+}
+
+// WholeDocument is the chunker keeping each document as a single chunk.
+type WholeDocument struct{}
+
+func (WholeDocument) Chunk(doc Document) ([]Chunk, error) {
+	return []Chunk{{ID: doc.ID, DocumentID: doc.ID, Text: doc.Content, Metadata: doc.Metadata}}, nil
+}
+
+// Stats counts what a pipeline run ingested.
+type Stats struct {
+	Documents int
+	Chunks    int
+	Filtered  int // chunks dropped by the filter
+	Written   int
+	Failed    int // chunks of the batches that failed
+}
+
+// Pipeline loads documents, splits them into chunks, embeds the chunks and writes them to the sinks.
+// Chunks are processed in batches by concurrent workers while documents are loaded, so a pipeline
+// holds at most a batch per worker in memory whatever the size of the source.
+type Pipeline struct {
+	Loader   Loader
+	Chunker  Chunker  // WholeDocument when nil, used with the context when a ContextChunker
+	Embedder Embedder // chunks aren't embedded when nil
+	Sinks    []Sink
+
+	// Filter, when set, drops the chunks of a batch that don't need to be ingested, e.g. those
+	// already stored, before they are embedded.
+	Filter func(ctx context.Context, chunks []Chunk) ([]Chunk, error)
+
+	// OnBatch, when set, is called after each batch, with all its chunks, filtered or not, and the
+	// error it failed with. It is called concurrently by the workers.
+	OnBatch func(batch []Chunk, err error)
+
+	BatchSize int // DefaultBatchSize when unset
+	Workers   int // 1 when unset
+}
+
+// Run ingests the documents of the loader. A failed batch doesn't stop the run: its chunks are
+// counted as failed and Run returns an error for them once every document is processed. Loading,
+// chunking and cancellation errors stop the run.
+func (p *Pipeline) Run(ctx context.Context) (Stats, error) {
+	batchSize := p.BatchSize
+	if batchSize <= 0 {
+		batchSize = DefaultBatchSize
+	}
+	workers := max(p.Workers, 1)
+	chunker := p.Chunker
+	if chunker == nil {
+		chunker = WholeDocument{}
+	}
+
+	var mu sync.Mutex
+	var stats Stats
+	var firstErr error
+	batches := make(chan []Chunk)
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for batch := range batches {
+				filtered, written, err := p.process(ctx, batch)
+				mu.Lock()
+				stats.Filtered += filtered
+				if err != nil {
+					stats.Failed += len(batch) - filtered
+					if firstErr == nil {
+						firstErr = err
+					}
+				} else {
+					stats.Written += written
+				}
+				mu.Unlock()
+				if p.OnBatch != nil {
+					p.OnBatch(batch, err)
+				}
+			}
+		}()
+	}
+
+	send := func(batch []Chunk) error {
+		select {
+		case batches <- batch:
+			return nil
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+
+	var pending []Chunk
+	err := p.Loader.Load(ctx, func(doc Document) error {
+		chunks, err := chunk(ctx, chunker, doc)
+		if err != nil {
+			return fmt.Errorf("failed to chunk document %s: %w", doc.ID, err)
+		}
+		mu.Lock()
+		stats.Documents++
+		stats.Chunks += len(chunks)
+		mu.Unlock()
+
+		for _, chunk := range chunks {
+			pending = append(pending, chunk)
+			if len(pending) == batchSize {
+				if err := send(pending); err != nil {
+					return err
+				}
+				pending = nil
+			}
+		}
+		return nil
+	})
+	if err == nil && len(pending) > 0 {
+		err = send(pending)
+	}
+	close(batches)
+	wg.Wait()
+
+	if err != nil {
+		return stats, err
+	}
+	if firstErr != nil {
+		return stats, fmt.Errorf("%w: %d, the first with: %w", ErrFailedChunks, stats.Failed, firstErr)
+	}
+	return stats, nil
+}
+
+// process filters, embeds and writes a batch, and returns the number of chunks filtered and
+// written.
+func (p *Pipeline) process(ctx context.Context, batch []Chunk) (filtered, written int, err error) {
+	chunks := batch
+	if p.Filter != nil {
+		if chunks, err = p.Filter(ctx, batch); err != nil {
+			return 0, 0, fmt.Errorf("failed to filter chunks: %w", err)
+		}
+		filtered = len(batch) - len(chunks)
+	}
+	if len(chunks) == 0 {
+		return filtered, 0, nil
+	}
+
+	if p.Embedder != nil {
+		texts := make([]string, len(chunks))
+		for i, chunk := range chunks {
+			texts[i] = chunk.Text
+		}
+		embeddings, err := p.Embedder.Embed(ctx, texts)
+		if err != nil {
+			return filtered, 0, fmt.Errorf("failed to embed chunks: %w", err)
+		}
+		if len(embeddings) != len(chunks) {
+			return filtered, 0, fmt.Errorf("got %d embeddings for %d chunks", len(embeddings), len(chunks))
+		}
+		for i := range chunks {
+			chunks[i].Embedding = embeddings[i]
+		}
+	}
+
+	for _, sink := range p.Sinks {
+		if err := sink.Write(ctx, chunks); err != nil {
+			return filtered, 0, err
+		}
+	}
+	return filtered, len(chunks), nil
+}
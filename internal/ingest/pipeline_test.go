@@ -0,0 +1,247 @@
+// pipeline_test.go
+package ingest
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"manifold/internal/documents"
+)
+
+func docsLoader(docs ...Document) Loader {
+	return LoaderFunc(func(ctx context.Context, emit func(Document) error) error {
+		for _, doc := range docs {
+			if err := emit(doc); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// lengthEmbedder embeds a text as its length.
+var lengthEmbedder = EmbedderFunc(func(ctx context.Context, texts []string) ([][]float32, error) {
+	embeddings := make([][]float32, len(texts))
+	for i, text := range texts {
+		embeddings[i] = []float32{float32(len(text))}
+	}
+	return embeddings, nil
+})
+
+// collector is a sink keeping the chunks written.
+type collector struct {
+	mu     sync.Mutex
+	chunks []Chunk
+}
+
+func (c *collector) Write(ctx context.Context, chunks []Chunk) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.chunks = append(c.chunks, chunks...)
+	return nil
+}
+
+func (c *collector) ids() []string {
+	var ids []string
+	for _, chunk := range c.chunks {
+		ids = append(ids, chunk.ID)
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+func TestPipelineRun(t *testing.T) {
+	sink := &collector{}
+	var batches []int
+	var mu sync.Mutex
+	pipeline := &Pipeline{
+		Loader:   docsLoader(Document{ID: "a", Content: "one"}, Document{ID: "b", Content: "three"}, Document{ID: "c", Content: "seven"}),
+		Embedder: lengthEmbedder,
+		Filter: func(ctx context.Context, chunks []Chunk) ([]Chunk, error) {
+			var kept []Chunk
+			for _, chunk := range chunks {
+				if chunk.ID != "b" {
+					kept = append(kept, chunk)
+				}
+			}
+			return kept, nil
+		},
+		Sinks:     []Sink{sink},
+		BatchSize: 2,
+		Workers:   2,
+		OnBatch: func(batch []Chunk, err error) {
+			mu.Lock()
+			defer mu.Unlock()
+			assert.NoError(t, err)
+			batches = append(batches, len(batch))
+		},
+	}
+
+	stats, err := pipeline.Run(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, Stats{Documents: 3, Chunks: 3, Filtered: 1, Written: 2}, stats)
+	assert.ElementsMatch(t, []int{2, 1}, batches)
+	assert.Equal(t, []string{"a", "c"}, sink.ids())
+	for _, chunk := range sink.chunks {
+		assert.Equal(t, []float32{float32(len(chunk.Text))}, chunk.Embedding)
+	}
+}
+
+func TestPipelineRunFailedBatch(t *testing.T) {
+	sink := &collector{}
+	failing := SinkFunc(func(ctx context.Context, chunks []Chunk) error {
+		for _, chunk := range chunks {
+			if chunk.ID == "b" {
+				return errors.New("disk full")
+			}
+		}
+		return nil
+	})
+	var failed []string
+	pipeline := &Pipeline{
+		Loader:    docsLoader(Document{ID: "a"}, Document{ID: "b"}, Document{ID: "c"}),
+		Sinks:     []Sink{failing, sink},
+		BatchSize: 1,
+		OnBatch: func(batch []Chunk, err error) {
+			if err != nil {
+				failed = append(failed, batch[0].ID)
+			}
+		},
+	}
+
+	// The run goes on after a failed batch
+	stats, err := pipeline.Run(context.Background())
+	assert.ErrorIs(t, err, ErrFailedChunks)
+	assert.ErrorContains(t, err, "disk full")
+	assert.Equal(t, Stats{Documents: 3, Chunks: 3, Written: 2, Failed: 1}, stats)
+	assert.Equal(t, []string{"b"}, failed)
+	assert.Equal(t, []string{"a", "c"}, sink.ids())
+
+	// Loading errors stop it
+	pipeline.Loader = LoaderFunc(func(ctx context.Context, emit func(Document) error) error {
+		return errors.New("repository not found")
+	})
+	_, err = pipeline.Run(context.Background())
+	assert.EqualError(t, err, "repository not found")
+}
+
+func TestSplitDocuments(t *testing.T) {
+	index, err := documents.NewIndexManager(filepath.Join(t.TempDir(), "index"))
+	require.NoError(t, err)
+	defer index.Index.Close()
+
+	dm := documents.NewDocumentManager(20, 0, index)
+	dm.Documents = []documents.Document{{
+		PageContent: "The cat sleeps.\n\nThe dog barks.\n\nThe bird sings.",
+		Metadata:    map[string]string{"source": "pets.txt"},
+	}}
+
+	splits, err := SplitDocuments(context.Background(), dm, nil)
+	require.NoError(t, err)
+	require.Contains(t, splits, "pets.txt")
+	assert.Greater(t, len(splits["pets.txt"]), 1)
+
+	doc, err := index.GetDocument("pets.txt-0")
+	require.NoError(t, err)
+	assert.NotNil(t, doc)
+}
+
+func TestStoreDocumentsSkipsDuplicates(t *testing.T) {
+	index, err := documents.NewIndexManager(filepath.Join(t.TempDir(), "index"))
+	require.NoError(t, err)
+	defer index.Index.Close()
+
+	dm := documents.NewDocumentManager(100, 0, index)
+	docs := []documents.Document{
+		{PageContent: "The license of the project.", Metadata: map[string]string{"source": "LICENSE"}},
+		{PageContent: "The license of the project.", Metadata: map[string]string{"source": "vendor/LICENSE"}},
+	}
+	_, err = StoreDocuments(context.Background(), dm, docs, nil)
+	require.NoError(t, err)
+
+	doc, err := index.GetDocument("LICENSE-0")
+	require.NoError(t, err)
+	assert.NotNil(t, doc)
+	doc, err = index.GetDocument("vendor/LICENSE-0")
+	require.NoError(t, err)
+	assert.Nil(t, doc, "the copy of a chunk in the same batch is skipped")
+
+	// Storing the same documents again indexes nothing
+	var written int
+	pipeline := &Pipeline{
+		Loader:  DocumentsLoader(docs),
+		Filter:  DuplicateFilter(index, 0),
+		Chunker: SplitterChunker{ChunkSize: 100},
+		Sinks: []Sink{SinkFunc(func(ctx context.Context, chunks []Chunk) error {
+			written += len(chunks)
+			return nil
+		})},
+	}
+	stats, err := pipeline.Run(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, 2, stats.Filtered)
+	assert.Zero(t, written)
+}
+
+func TestHTTPEmbedder(t *testing.T) {
+	defer func(delay func(int) time.Duration) { retryDelay = delay }(retryDelay)
+	retryDelay = func(int) time.Duration { return 0 }
+
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		if requests == 1 {
+			http.Error(w, "overloaded", http.StatusServiceUnavailable)
+			return
+		}
+		assert.Equal(t, "Bearer secret", r.Header.Get("Authorization"))
+		var body struct {
+			Input []string `json:"input"`
+			Model string   `json:"model"`
+		}
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&body))
+		assert.Equal(t, []string{"a", "bb"}, body.Input)
+		assert.Equal(t, "nomic", body.Model)
+		// Out of order, as the index tells
+		w.Write([]byte(`{"data": [{"index": 1, "embedding": [2, 2]}, {"index": 0, "embedding": [1, 1]}]}`))
+	}))
+	defer server.Close()
+
+	embedder := &HTTPEmbedder{URL: server.URL, Model: "nomic", APIKey: "secret", Dimensions: 2}
+	embeddings, err := embedder.Embed(context.Background(), []string{"a", "bb"})
+	require.NoError(t, err)
+	assert.Equal(t, [][]float32{{1, 1}, {2, 2}}, embeddings)
+	assert.Equal(t, 2, requests)
+
+	embedder.Dimensions = 3
+	_, err = embedder.Embed(context.Background(), []string{"a", "bb"})
+	assert.ErrorContains(t, err, "dimension mismatch")
+}
+
+func TestSplitterChunkerLocatesChunks(t *testing.T) {
+	content := "<!-- page 1 -->\n\n# Pets\n\nPets live with people.\n\n<!-- page 2 -->\n\n## Dogs\n\nThe dog fetches the ball."
+	chunks, err := SplitterChunker{ChunkSize: 30}.Chunk(Document{ID: "pets.pdf", Content: content, Metadata: map[string]string{"language": "MARKDOWN"}})
+	require.NoError(t, err)
+	last := chunks[len(chunks)-1]
+	assert.Contains(t, last.Text, "ball")
+	assert.Equal(t, "2", last.Metadata["page"])
+	assert.Equal(t, "Pets > Dogs", last.Metadata["section"])
+	assert.Equal(t, "1", chunks[0].Metadata["page"])
+
+	// Documents without an outline keep their metadata
+	metadata := map[string]string{"source": "notes.txt"}
+	chunks, err = SplitterChunker{ChunkSize: 30}.Chunk(Document{ID: "notes.txt", Content: "A note.", Metadata: metadata})
+	require.NoError(t, err)
+	assert.Equal(t, metadata, chunks[0].Metadata)
+}
@@ -0,0 +1,223 @@
+// semantic.go
+package ingest
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"unicode"
+
+	"manifold/internal/documents"
+)
+
+// ContextChunker is a Chunker whose chunking can be cancelled, e.g. because it makes requests. The
+// pipeline chunks with ChunkContext when its chunker implements it.
+type ContextChunker interface {
+	Chunker
+	ChunkContext(ctx context.Context, doc Document) ([]Chunk, error)
+}
+
+// chunk splits a document with the chunker, with the context when it takes one.
+func chunk(ctx context.Context, chunker Chunker, doc Document) ([]Chunk, error) {
+	if c, ok := chunker.(ContextChunker); ok {
+		return c.ChunkContext(ctx, doc)
+	}
+	return chunker.Chunk(doc)
+}
+
+// SemanticChunker splits documents on semantic breakpoints instead of character counts: sentences
+// are embedded with their neighbours and a chunk ends where the cosine distance between consecutive
+// sentences is among the largest of the document. It suits prose such as PDFs and long articles.
+type SemanticChunker struct {
+	Embedder Embedder
+
+	// Buffer is the number of sentences on each side embedded with every sentence, which smooths
+	// the distances.
+	Buffer int
+
+	// Percentile of the distances between consecutive sentences above which a chunk ends.
+	Percentile float64
+
+	// MinSize and MaxSize bound the size of chunks in characters: a breakpoint doesn't end a chunk
+	// shorter than MinSize, and a chunk ends before it grows past MaxSize, when set. A sentence
+	// longer than MaxSize is a chunk of its own.
+	MinSize int
+	MaxSize int
+
+	BatchSize int // sentences embedded per request, DefaultBatchSize when unset
+}
+
+// NewSemanticChunker returns a chunker embedding each sentence with one neighbour on each side and
+// breaking at the 95th percentile of distances.
+func NewSemanticChunker(embedder Embedder) *SemanticChunker {
+	return &SemanticChunker{Embedder: embedder, Buffer: 1, Percentile: 95}
+}
+
+func (s *SemanticChunker) Chunk(doc Document) ([]Chunk, error) {
+	return s.ChunkContext(context.Background(), doc)
+}
+
+// ChunkContext splits the document. Chunk IDs are the document ID followed by the index of the
+// chunk, as those of SplitterChunker.
+func (s *SemanticChunker) ChunkContext(ctx context.Context, doc Document) ([]Chunk, error) {
+	sentences := splitSentences(doc.Content)
+	var texts []string
+	if len(sentences) < 2 {
+		texts = sentences
+	} else {
+		distances, err := s.distances(ctx, sentences)
+		if err != nil {
+			return nil, err
+		}
+		texts = s.group(sentences, distances)
+	}
+
+	chunks := make([]Chunk, 0, len(texts))
+	for _, text := range texts {
+		if text = strings.TrimSpace(text); text == "" {
+			continue
+		}
+		chunks = append(chunks, Chunk{
+			ID:         fmt.Sprintf("%s-%d", doc.ID, len(chunks)),
+			DocumentID: doc.ID,
+			Index:      len(chunks),
+			Text:       text,
+			Metadata:   doc.Metadata,
+		})
+	}
+	locateChunks(doc, chunks)
+	return chunks, nil
+}
+
+// distances returns the cosine distance between each sentence and the next, embedded with their
+// neighbours.
+func (s *SemanticChunker) distances(ctx context.Context, sentences []string) ([]float64, error) {
+	windows := make([]string, len(sentences))
+	for i := range sentences {
+		from, to := max(i-s.Buffer, 0), min(i+s.Buffer+1, len(sentences))
+		windows[i] = strings.Join(sentences[from:to], "")
+	}
+
+	batchSize := s.BatchSize
+	if batchSize <= 0 {
+		batchSize = DefaultBatchSize
+	}
+	embeddings := make([][]float32, 0, len(windows))
+	for start := 0; start < len(windows); start += batchSize {
+		batch := windows[start:min(start+batchSize, len(windows))]
+		batchEmbeddings, err := s.Embedder.Embed(ctx, batch)
+		if err != nil {
+			return nil, fmt.Errorf("failed to embed sentences: %w", err)
+		}
+		if len(batchEmbeddings) != len(batch) {
+			return nil, fmt.Errorf("got %d embeddings for %d sentences", len(batchEmbeddings), len(batch))
+		}
+		embeddings = append(embeddings, batchEmbeddings...)
+	}
+
+	distances := make([]float64, len(sentences)-1)
+	for i := range distances {
+		distances[i] = 1 - cosine(embeddings[i], embeddings[i+1])
+	}
+	return distances, nil
+}
+
+// group joins the sentences into chunks, ending them at the breakpoints and size limits.
+func (s *SemanticChunker) group(sentences []string, distances []float64) []string {
+	threshold := percentile(distances, s.Percentile)
+	var texts []string
+	var current strings.Builder
+	for i, sentence := range sentences {
+		if s.MaxSize > 0 && current.Len() > 0 && current.Len()+len(sentence) > s.MaxSize {
+			texts = append(texts, current.String())
+			current.Reset()
+		}
+		current.WriteString(sentence)
+		if i < len(distances) && distances[i] > threshold && current.Len() >= s.MinSize {
+			texts = append(texts, current.String())
+			current.Reset()
+		}
+	}
+	if current.Len() > 0 {
+		texts = append(texts, current.String())
+	}
+	return texts
+}
+
+// splitSentences splits text after the punctuation ending a sentence and at blank lines, keeping
+// the whitespace following each sentence so that they join back into the text.
+func splitSentences(text string) []string {
+	var sentences []string
+	runes := []rune(text)
+	start := 0
+	for i := 0; i < len(runes); i++ {
+		end := false
+		switch {
+		case runes[i] == '.' || runes[i] == '!' || runes[i] == '?':
+			end = i+1 < len(runes) && unicode.IsSpace(runes[i+1])
+		case runes[i] == '\n':
+			end = i+1 < len(runes) && runes[i+1] == '\n'
+		}
+		if !end {
+			continue
+		}
+		for i+1 < len(runes) && unicode.IsSpace(runes[i+1]) {
+			i++
+		}
+		sentences = append(sentences, string(runes[start:i+1]))
+		start = i + 1
+	}
+	if start < len(runes) {
+		sentences = append(sentences, string(runes[start:]))
+	}
+	return sentences
+}
+
+// percentile returns the p-th percentile of the values, interpolating between the closest ranks.
+func percentile(values []float64, p float64) float64 {
+	sorted := append([]float64(nil), values...)
+	sort.Float64s(sorted)
+	rank := math.Max(0, math.Min(p, 100)) / 100 * float64(len(sorted)-1)
+	lower := int(rank)
+	if lower+1 >= len(sorted) {
+		return sorted[lower]
+	}
+	return sorted[lower] + (rank-float64(lower))*(sorted[lower+1]-sorted[lower])
+}
+
+// cosine returns the cosine similarity of two vectors, 0 when they differ in size or one is zero.
+func cosine(a, b []float32) float64 {
+	if len(a) != len(b) {
+		return 0
+	}
+	var dot, normA, normB float64
+	for i := range a {
+		dot += float64(a[i]) * float64(b[i])
+		normA += float64(a[i]) * float64(a[i])
+		normB += float64(b[i]) * float64(b[i])
+	}
+	if normA == 0 || normB == 0 {
+		return 0
+	}
+	return dot / math.Sqrt(normA*normB)
+}
+
+// DocTypeChunker splits documents with the chunker of their documents.DocType, and the Default one
+// for types without a chunker.
+type DocTypeChunker struct {
+	Default Chunker
+	Types   map[string]Chunker
+}
+
+func (c DocTypeChunker) Chunk(doc Document) ([]Chunk, error) {
+	return c.ChunkContext(context.Background(), doc)
+}
+
+func (c DocTypeChunker) ChunkContext(ctx context.Context, doc Document) ([]Chunk, error) {
+	if chunker, ok := c.Types[documents.DocType(doc.Metadata)]; ok {
+		return chunk(ctx, chunker, doc)
+	}
+	return chunk(ctx, c.Default, doc)
+}
@@ -0,0 +1,75 @@
+// semantic_test.go
+package ingest
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// topicEmbedder embeds texts by how often they mention cats and stocks.
+var topicEmbedder = EmbedderFunc(func(ctx context.Context, texts []string) ([][]float32, error) {
+	embeddings := make([][]float32, len(texts))
+	for i, text := range texts {
+		embeddings[i] = []float32{float32(strings.Count(text, "cat")), float32(strings.Count(text, "stock"))}
+	}
+	return embeddings, nil
+})
+
+func TestSemanticChunker(t *testing.T) {
+	content := "The cat sleeps. A cat purrs! Every cat hunts.\n\nThe stock rose. A stock fell? The stock market closed."
+	assert.Equal(t, content, strings.Join(splitSentences(content), ""))
+	assert.Len(t, splitSentences(content), 6)
+
+	chunker := NewSemanticChunker(topicEmbedder)
+	chunker.BatchSize = 4
+	chunks, err := chunker.Chunk(Document{ID: "article", Content: content})
+	require.NoError(t, err)
+	require.Len(t, chunks, 2)
+	assert.Equal(t, "The cat sleeps. A cat purrs! Every cat hunts.", chunks[0].Text)
+	assert.Equal(t, "The stock rose. A stock fell? The stock market closed.", chunks[1].Text)
+	assert.Equal(t, "article-1", chunks[1].ID)
+	assert.Equal(t, 1, chunks[1].Index)
+
+	// Chunks end before they grow past the maximum size
+	chunker.MaxSize = 30
+	chunks, err = chunker.Chunk(Document{ID: "article", Content: content})
+	require.NoError(t, err)
+	for _, chunk := range chunks {
+		assert.LessOrEqual(t, len(chunk.Text), 30, chunk.Text)
+	}
+
+	// Documents are split by the chunker of their type, with the context of the pipeline
+	typed := DocTypeChunker{Default: WholeDocument{}, Types: map[string]Chunker{"pdf": NewSemanticChunker(topicEmbedder)}}
+	var sources []string
+	pipeline := &Pipeline{
+		Loader: docsLoader(
+			Document{ID: "paper.pdf", Content: content, Metadata: map[string]string{"file_type": ".pdf"}},
+			Document{ID: "notes.txt", Content: content},
+		),
+		Chunker: typed,
+		Sinks: []Sink{SinkFunc(func(ctx context.Context, chunks []Chunk) error {
+			for _, chunk := range chunks {
+				sources = append(sources, chunk.DocumentID)
+			}
+			return nil
+		})},
+	}
+	stats, err := pipeline.Run(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, 3, stats.Chunks)
+	assert.Equal(t, []string{"paper.pdf", "paper.pdf", "notes.txt"}, sources)
+
+	// Embedding stops with the context
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	chunker.Embedder = EmbedderFunc(func(ctx context.Context, texts []string) ([][]float32, error) {
+		return nil, ctx.Err()
+	})
+	_, err = chunker.ChunkContext(ctx, Document{ID: "article", Content: content})
+	assert.ErrorIs(t, err, context.Canceled)
+}
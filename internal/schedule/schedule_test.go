@@ -0,0 +1,49 @@
+// schedule_test.go
+package schedule
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParse(t *testing.T) {
+	// A Wednesday
+	from := time.Date(2024, time.May, 15, 10, 7, 30, 0, time.UTC)
+	tests := []struct {
+		expr string
+		next time.Time
+	}{
+		{"* * * * *", time.Date(2024, time.May, 15, 10, 8, 0, 0, time.UTC)},
+		{"*/15 * * * *", time.Date(2024, time.May, 15, 10, 15, 0, 0, time.UTC)},
+		{"5/20 * * * *", time.Date(2024, time.May, 15, 10, 25, 0, 0, time.UTC)},
+		{"0 8-18 * * *", time.Date(2024, time.May, 15, 11, 0, 0, 0, time.UTC)},
+		{"30 2 * * *", time.Date(2024, time.May, 16, 2, 30, 0, 0, time.UTC)},
+		{"0 9 * * mon-fri", time.Date(2024, time.May, 16, 9, 0, 0, 0, time.UTC)},
+		{"0 9 * * sat,7", time.Date(2024, time.May, 18, 9, 0, 0, 0, time.UTC)},
+		{"0 0 1 jan *", time.Date(2025, time.January, 1, 0, 0, 0, 0, time.UTC)},
+		// Either day field matches when both are restricted
+		{"0 0 31 * 5", time.Date(2024, time.May, 17, 0, 0, 0, 0, time.UTC)},
+		{"@daily", time.Date(2024, time.May, 16, 0, 0, 0, 0, time.UTC)},
+		{"@hourly", time.Date(2024, time.May, 15, 11, 0, 0, 0, time.UTC)},
+		{"@every 6h", from.Add(6 * time.Hour)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.expr, func(t *testing.T) {
+			s, err := Parse(tt.expr)
+			require.NoError(t, err)
+			assert.Equal(t, tt.next, s.Next(from))
+		})
+	}
+
+	never, err := Parse("0 0 30 2 *")
+	require.NoError(t, err)
+	assert.True(t, never.Next(from).IsZero())
+
+	for _, expr := range []string{"", "* * * *", "60 * * * *", "* * * * mon-", "*/0 * * * *", "5-1 * * * *", "@every 10s", "@every soon"} {
+		_, err := Parse(expr)
+		assert.Error(t, err, expr)
+	}
+}
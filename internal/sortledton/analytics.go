@@ -0,0 +1,276 @@
+// analytics.go
+
+package sortledton
+
+import (
+	"math"
+	"runtime"
+	"sort"
+	"sync"
+	"sync/atomic"
+)
+
+// GraphSnapshot is a read-only copy of the active edges of a graph, in compressed sparse row form,
+// that analytics run over while the graph keeps changing. Vertices are numbered by their position
+// in ids.
+type GraphSnapshot struct {
+	ids        []int // External IDs, sorted
+	outOffsets []int // Edges of vertex i are outTargets[outOffsets[i]:outOffsets[i+1]]
+	outTargets []int // Sorted for each vertex
+	inOffsets  []int // Edges to vertex i are inSources[inOffsets[i]:inOffsets[i+1]]
+	inSources  []int
+}
+
+// Snapshot copies the active edges of the graph, blocking writers meanwhile so that the copy is
+// consistent across vertices.
+func (g *Graph) Snapshot() *GraphSnapshot {
+	g.readWriteMu.Lock()
+	edges := make(map[int][]int, len(g.vertices))
+	vertices := make(map[int]bool)
+	for internalId, vertex := range g.vertices {
+		active := g.activeEdges(vertex, g.currentVersion())
+		if len(active) == 0 {
+			continue
+		}
+		source := g.vertexIdManager.getExternalId(internalId)
+		vertices[source] = true
+		for _, edge := range active {
+			target := g.vertexIdManager.getExternalId(edge.targetVertexId)
+			vertices[target] = true
+			edges[source] = append(edges[source], target)
+		}
+	}
+	g.readWriteMu.Unlock()
+
+	s := &GraphSnapshot{ids: make([]int, 0, len(vertices))}
+	for id := range vertices {
+		s.ids = append(s.ids, id)
+	}
+	sort.Ints(s.ids)
+	index := make(map[int]int, len(s.ids))
+	for i, id := range s.ids {
+		index[id] = i
+	}
+
+	n := len(s.ids)
+	s.outOffsets = make([]int, n+1)
+	inDegrees := make([]int, n+1)
+	for i, id := range s.ids {
+		targets := edges[id]
+		s.outOffsets[i+1] = s.outOffsets[i] + len(targets)
+		for _, target := range targets {
+			s.outTargets = append(s.outTargets, index[target])
+			inDegrees[index[target]+1]++
+		}
+		sort.Ints(s.outTargets[s.outOffsets[i]:s.outOffsets[i+1]])
+	}
+	s.inOffsets = make([]int, n+1)
+	for i := 0; i < n; i++ {
+		s.inOffsets[i+1] = s.inOffsets[i] + inDegrees[i+1]
+	}
+	s.inSources = make([]int, len(s.outTargets))
+	next := append([]int(nil), s.inOffsets[:n]...)
+	for source := 0; source < n; source++ {
+		for _, target := range s.outNeighbors(source) {
+			s.inSources[next[target]] = source
+			next[target]++
+		}
+	}
+	return s
+}
+
+// NumVertices returns the number of vertices with edges.
+func (s *GraphSnapshot) NumVertices() int {
+	return len(s.ids)
+}
+
+// NumEdges returns the number of edges.
+func (s *GraphSnapshot) NumEdges() int {
+	return len(s.outTargets)
+}
+
+func (s *GraphSnapshot) outNeighbors(v int) []int {
+	return s.outTargets[s.outOffsets[v]:s.outOffsets[v+1]]
+}
+
+func (s *GraphSnapshot) inNeighbors(v int) []int {
+	return s.inSources[s.inOffsets[v]:s.inOffsets[v+1]]
+}
+
+// undirectedNeighbors returns the sorted neighbors of each vertex ignoring edge directions, without
+// duplicates or self-loops.
+func (s *GraphSnapshot) undirectedNeighbors() [][]int {
+	neighbors := make([][]int, len(s.ids))
+	parallelFor(len(s.ids), func(start, end int) {
+		for v := start; v < end; v++ {
+			merged := mergeSorted(s.outNeighbors(v), sortedCopy(s.inNeighbors(v)))
+			unique := merged[:0]
+			for i, u := range merged {
+				if u != v && (i == 0 || u != merged[i-1]) {
+					unique = append(unique, u)
+				}
+			}
+			neighbors[v] = unique
+		}
+	})
+	return neighbors
+}
+
+// PageRank ranks the vertices by the links to them, iterating until the ranks change by less than
+// tolerance in total or for at most maxIterations. The ranks of vertices without edges are spread
+// over every vertex, so the ranks add up to 1.
+func (s *GraphSnapshot) PageRank(damping float64, maxIterations int, tolerance float64) map[int]float64 {
+	n := len(s.ids)
+	if n == 0 {
+		return map[int]float64{}
+	}
+	ranks := make([]float64, n)
+	for i := range ranks {
+		ranks[i] = 1 / float64(n)
+	}
+	next := make([]float64, n)
+	contributions := make([]float64, n)
+
+	for iteration := 0; iteration < maxIterations; iteration++ {
+		// Spread the rank of each vertex over its edges, and of dangling vertices over all of them
+		var dangling float64
+		for v := 0; v < n; v++ {
+			if degree := s.outOffsets[v+1] - s.outOffsets[v]; degree > 0 {
+				contributions[v] = ranks[v] / float64(degree)
+			} else {
+				contributions[v] = 0
+				dangling += ranks[v]
+			}
+		}
+		base := (1-damping)/float64(n) + damping*dangling/float64(n)
+
+		var mu sync.Mutex
+		var delta float64
+		parallelFor(n, func(start, end int) {
+			var localDelta float64
+			for v := start; v < end; v++ {
+				var sum float64
+				for _, u := range s.inNeighbors(v) {
+					sum += contributions[u]
+				}
+				next[v] = base + damping*sum
+				localDelta += math.Abs(next[v] - ranks[v])
+			}
+			mu.Lock()
+			delta += localDelta
+			mu.Unlock()
+		})
+		ranks, next = next, ranks
+		if delta < tolerance {
+			break
+		}
+	}
+
+	result := make(map[int]float64, n)
+	for v, rank := range ranks {
+		result[s.ids[v]] = rank
+	}
+	return result
+}
+
+// TriangleCount returns the number of triangles of the graph, ignoring edge directions.
+func (s *GraphSnapshot) TriangleCount() int64 {
+	neighbors := s.undirectedNeighbors()
+	var triangles int64
+	parallelFor(len(neighbors), func(start, end int) {
+		var local int64
+		// Count each triangle u < v < w once, from its lowest vertex
+		for u := start; u < end; u++ {
+			higherU := higherThan(neighbors[u], u)
+			for _, v := range higherU {
+				local += int64(len(intersectSortedSlices(higherU, higherThan(neighbors[v], v))))
+			}
+		}
+		atomic.AddInt64(&triangles, local)
+	})
+	return triangles
+}
+
+// WeaklyConnectedComponents returns the component of each vertex, ignoring edge directions, as the
+// lowest ID of the component.
+func (s *GraphSnapshot) WeaklyConnectedComponents() map[int]int {
+	neighbors := s.undirectedNeighbors()
+	n := len(neighbors)
+	labels := make([]int, n)
+	for v := range labels {
+		labels[v] = v
+	}
+
+	// Propagate the lowest label through the neighbors until no label changes. Vertices are
+	// numbered by ID, so the lowest label is the lowest ID.
+	next := make([]int, n)
+	for changed := true; changed; {
+		var anyChanged int32
+		parallelFor(n, func(start, end int) {
+			for v := start; v < end; v++ {
+				label := labels[v]
+				for _, u := range neighbors[v] {
+					label = min(label, labels[u])
+				}
+				next[v] = label
+				if label != labels[v] {
+					atomic.StoreInt32(&anyChanged, 1)
+				}
+			}
+		})
+		labels, next = next, labels
+		changed = anyChanged == 1
+	}
+
+	components := make(map[int]int, n)
+	for v, label := range labels {
+		components[s.ids[v]] = s.ids[label]
+	}
+	return components
+}
+
+// parallelFor calls fn on consecutive ranges of [0, n) in parallel, one per processor.
+func parallelFor(n int, fn func(start, end int)) {
+	workers := runtime.GOMAXPROCS(0)
+	size := (n + workers - 1) / workers
+	if size == 0 {
+		return
+	}
+	var wg sync.WaitGroup
+	for start := 0; start < n; start += size {
+		wg.Add(1)
+		go func(start, end int) {
+			defer wg.Done()
+			fn(start, end)
+		}(start, min(start+size, n))
+	}
+	wg.Wait()
+}
+
+// higherThan returns the part of a sorted slice above a value.
+func higherThan(sorted []int, value int) []int {
+	return sorted[sort.SearchInts(sorted, value+1):]
+}
+
+func sortedCopy(a []int) []int {
+	sorted := append([]int(nil), a...)
+	sort.Ints(sorted)
+	return sorted
+}
+
+// mergeSorted merges two sorted slices into a new one.
+func mergeSorted(a, b []int) []int {
+	merged := make([]int, 0, len(a)+len(b))
+	i, j := 0, 0
+	for i < len(a) && j < len(b) {
+		if a[i] <= b[j] {
+			merged = append(merged, a[i])
+			i++
+		} else {
+			merged = append(merged, b[j])
+			j++
+		}
+	}
+	merged = append(merged, a[i:]...)
+	return append(merged, b[j:]...)
+}
@@ -0,0 +1,84 @@
+// analytics_test.go
+
+package sortledton
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// newAnalyticsGraph returns a graph of the edges.
+func newAnalyticsGraph(edges [][2]int) *Graph {
+	g := New()
+	for _, edge := range edges {
+		g.InsertEdge(edge[0], edge[1])
+	}
+	return g
+}
+
+func TestSnapshot(t *testing.T) {
+	g := newAnalyticsGraph([][2]int{{3, 1}, {1, 2}, {1, 3}, {2, 3}, {4, 5}})
+	g.DeleteEdge(4, 5)
+	s := g.Snapshot()
+
+	// Vertices are numbered by ID, and only those with edges are kept
+	assert.Equal(t, []int{1, 2, 3}, s.ids)
+	assert.Equal(t, 3, s.NumVertices())
+	assert.Equal(t, 4, s.NumEdges())
+	assert.Equal(t, []int{1, 2}, s.outNeighbors(0))
+	assert.Equal(t, []int{0, 1}, s.inNeighbors(2))
+	assert.Equal(t, [][]int{{1, 2}, {0, 2}, {0, 1}}, s.undirectedNeighbors())
+
+	// The snapshot doesn't change with the graph
+	g.InsertEdge(2, 1)
+	assert.Equal(t, 4, s.NumEdges())
+	assert.Equal(t, 5, g.Snapshot().NumEdges())
+}
+
+func TestPageRank(t *testing.T) {
+	// A cycle ranks every vertex the same
+	ranks := newAnalyticsGraph([][2]int{{1, 2}, {2, 3}, {3, 1}}).Snapshot().PageRank(0.85, 100, 1e-9)
+	for _, id := range []int{1, 2, 3} {
+		assert.InDelta(t, 1.0/3, ranks[id], 1e-6)
+	}
+
+	// Links raise the rank of their targets, and dangling vertices keep the total at 1
+	ranks = newAnalyticsGraph([][2]int{{1, 4}, {2, 4}, {3, 4}, {4, 1}, {5, 6}}).Snapshot().PageRank(0.85, 100, 1e-9)
+	var sum float64
+	for _, rank := range ranks {
+		sum += rank
+	}
+	assert.InDelta(t, 1, sum, 1e-6)
+	assert.Greater(t, ranks[4], ranks[1])
+	assert.Greater(t, ranks[1], ranks[2])
+	assert.InDelta(t, ranks[2], ranks[3], 1e-9)
+	assert.Greater(t, ranks[6], ranks[5])
+
+	assert.Empty(t, New().Snapshot().PageRank(0.85, 100, 1e-9))
+}
+
+func TestTriangleCount(t *testing.T) {
+	tests := []struct {
+		name  string
+		edges [][2]int
+		want  int64
+	}{
+		{"none", [][2]int{{1, 2}, {2, 3}}, 0},
+		{"directions ignored", [][2]int{{1, 2}, {3, 2}, {1, 3}}, 1},
+		{"both ways counted once", [][2]int{{1, 2}, {2, 1}, {2, 3}, {3, 2}, {3, 1}, {1, 3}}, 1},
+		{"self-loops ignored", [][2]int{{1, 2}, {2, 3}, {3, 1}, {1, 1}}, 1},
+		{"clique of 4", [][2]int{{1, 2}, {1, 3}, {1, 4}, {2, 3}, {2, 4}, {3, 4}}, 4},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, newAnalyticsGraph(tt.edges).Snapshot().TriangleCount())
+		})
+	}
+}
+
+func TestWeaklyConnectedComponents(t *testing.T) {
+	g := newAnalyticsGraph([][2]int{{4, 2}, {2, 9}, {7, 8}, {5, 5}, {10, 9}})
+	assert.Equal(t, map[int]int{2: 2, 4: 2, 9: 2, 10: 2, 7: 7, 8: 7, 5: 5}, g.Snapshot().WeaklyConnectedComponents())
+	assert.Empty(t, New().Snapshot().WeaklyConnectedComponents())
+}
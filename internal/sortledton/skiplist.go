@@ -0,0 +1,397 @@
+// skiplist.go
+
+package sortledton
+
+import (
+	"math/rand"
+	"sort"
+	"sync"
+)
+
+const (
+	MAX_CAPACITY = 256
+	MIN_CAPACITY = 64
+
+	MAX_LEVEL        = 16   // Levels above 0 of a skip list at most
+	SKIP_PROBABILITY = 0.25 // Probability of a block to reach each level from the one below
+)
+
+type Edge struct {
+	targetVertexId int
+	version        int64                  // Timestamp for MVCC
+	isDeleted      bool                   // Flag to indicate logical deletion
+	weight         float64                // 1 for unweighted edges
+	properties     map[string]interface{} // Optional, nil when the edge has none
+	previous       *Edge                  // Older version, until the garbage collector reclaims it
+}
+
+// visibleAt returns the version of the edge a read as of a version sees, false when the edge
+// didn't exist then or was deleted.
+func (e Edge) visibleAt(asOf int64) (Edge, bool) {
+	for version := &e; version != nil; version = version.previous {
+		if version.version <= asOf {
+			if version.isDeleted {
+				return Edge{}, false
+			}
+			visible := *version
+			visible.previous = nil
+			return visible, true
+		}
+	}
+	return Edge{}, false
+}
+
+// Target returns the vertex the edge points to.
+func (e Edge) Target() int {
+	return e.targetVertexId
+}
+
+// Weight returns the weight of the edge.
+func (e Edge) Weight() float64 {
+	return e.weight
+}
+
+// Property returns a property of the edge.
+func (e Edge) Property(key string) (interface{}, bool) {
+	value, ok := e.properties[key]
+	return value, ok
+}
+
+// StringProperty returns a string property of the edge, false when it is missing or not a string.
+func (e Edge) StringProperty(key string) (string, bool) {
+	value, ok := e.properties[key].(string)
+	return value, ok
+}
+
+// FloatProperty returns a numeric property of the edge as a float64, false when it is missing or
+// not a number.
+func (e Edge) FloatProperty(key string) (float64, bool) {
+	switch value := e.properties[key].(type) {
+	case float64:
+		return value, true
+	case float32:
+		return float64(value), true
+	case int:
+		return float64(value), true
+	case int64:
+		return float64(value), true
+	}
+	return 0, false
+}
+
+// IntProperty returns an integer property of the edge, false when it is missing or not an integer.
+func (e Edge) IntProperty(key string) (int, bool) {
+	switch value := e.properties[key].(type) {
+	case int:
+		return value, true
+	case int64:
+		return int(value), true
+	}
+	return 0, false
+}
+
+// BoolProperty returns a boolean property of the edge, false when it is missing or not a boolean.
+func (e Edge) BoolProperty(key string) (bool, bool) {
+	value, ok := e.properties[key].(bool)
+	return value, ok
+}
+
+type EdgeBlock struct {
+	edges  []Edge       // A sorted list of edges
+	next   *EdgeBlock   // Pointer to the next block in the skip list
+	levels []*EdgeBlock // Pointers to the next blocks at levels 1 and up, as high as the block is
+	mu     sync.RWMutex
+}
+
+func (eb *EdgeBlock) isFull() bool {
+	return len(eb.edges) >= MAX_CAPACITY
+}
+
+// lastKey returns the highest target of the block, by which blocks are ordered at every level.
+// Blocks of a list are never empty.
+func (eb *EdgeBlock) lastKey() int {
+	return eb.edges[len(eb.edges)-1].targetVertexId
+}
+
+// insertEdge inserts an edge, or a new version of it, the caller holding the lock of the block, and
+// returns the block split from it when it fills up.
+func (eb *EdgeBlock) insertEdge(edge Edge) *EdgeBlock {
+	// Insert edge into edges maintaining sorted order
+	idx := sort.Search(len(eb.edges), func(i int) bool {
+		return eb.edges[i].targetVertexId >= edge.targetVertexId
+	})
+
+	if idx < len(eb.edges) && eb.edges[idx].targetVertexId == edge.targetVertexId {
+		// Edge already exists, add a version of it
+		previous := eb.edges[idx]
+		edge.previous = &previous
+		eb.edges[idx] = edge
+	} else {
+		eb.edges = append(eb.edges, Edge{})    // make space
+		copy(eb.edges[idx+1:], eb.edges[idx:]) // shift
+		eb.edges[idx] = edge                   // insert
+	}
+
+	if eb.isFull() {
+		return eb.split()
+	}
+	return nil
+}
+
+// split moves the upper half of the edges to a new block following this one at level 0, and
+// returns it to be linked at its levels.
+func (eb *EdgeBlock) split() *EdgeBlock {
+	midIndex := len(eb.edges) / 2
+	newBlock := &EdgeBlock{
+		edges: append([]Edge(nil), eb.edges[midIndex:]...),
+		next:  eb.next,
+	}
+	eb.edges = eb.edges[:midIndex]
+	eb.next = newBlock
+	return newBlock
+}
+
+// UnrolledSkipList keeps edges sorted in blocks linked at level 0, with a random share of the
+// blocks linked at higher levels too, each level skipping more blocks, so that finding the block of
+// a target takes O(log n) steps.
+type UnrolledSkipList struct {
+	head          *EdgeBlock
+	levelPointers []*EdgeBlock // First blocks of levels 1 and up
+	mu            sync.RWMutex
+}
+
+func NewUnrolledSkipList() *UnrolledSkipList {
+	return &UnrolledSkipList{
+		head:          nil,
+		levelPointers: nil,
+	}
+}
+
+// randomLevels returns the number of levels above 0 of a new block, each with probability
+// SKIP_PROBABILITY over the one below.
+func randomLevels() int {
+	levels := 0
+	for levels < MAX_LEVEL && rand.Float64() < SKIP_PROBABILITY {
+		levels++
+	}
+	return levels
+}
+
+// forward returns the block following a block at a level, the first block of the level for a nil
+// block.
+func (usl *UnrolledSkipList) forward(block *EdgeBlock, level int) *EdgeBlock {
+	switch {
+	case block == nil && level == 0:
+		return usl.head
+	case block == nil:
+		return usl.levelPointers[level-1]
+	case level == 0:
+		return block.next
+	}
+	return block.levels[level-1]
+}
+
+// setForward sets the block following a block at a level, the first block of the level for a nil
+// block.
+func (usl *UnrolledSkipList) setForward(block, next *EdgeBlock, level int) {
+	switch {
+	case block == nil && level == 0:
+		usl.head = next
+	case block == nil:
+		usl.levelPointers[level-1] = next
+	case level == 0:
+		block.next = next
+	default:
+		block.levels[level-1] = next
+	}
+}
+
+// findPredecessors returns, for each level, the last block whose edges all target vertices below
+// targetVertexId, nil when there is none. The block holding targetVertexId, or where it belongs,
+// follows the one of level 0.
+func (usl *UnrolledSkipList) findPredecessors(targetVertexId int) []*EdgeBlock {
+	predecessors := make([]*EdgeBlock, len(usl.levelPointers)+1)
+	var current *EdgeBlock
+	for level := len(predecessors) - 1; level >= 0; level-- {
+		for next := usl.forward(current, level); next != nil && next.lastKey() < targetVertexId; next = usl.forward(current, level) {
+			current = next
+		}
+		predecessors[level] = current
+	}
+	return predecessors
+}
+
+// link links a block, already linked at level 0, at its higher levels.
+func (usl *UnrolledSkipList) link(block *EdgeBlock) {
+	block.levels = make([]*EdgeBlock, randomLevels())
+	for len(usl.levelPointers) < len(block.levels) {
+		usl.levelPointers = append(usl.levelPointers, nil)
+	}
+	predecessors := usl.findPredecessors(block.edges[0].targetVertexId)
+	for level := 1; level <= len(block.levels); level++ {
+		block.levels[level-1] = usl.forward(predecessors[level], level)
+		usl.setForward(predecessors[level], block, level)
+	}
+}
+
+// unlink removes a block, not yet emptied, from every level.
+func (usl *UnrolledSkipList) unlink(block *EdgeBlock) {
+	predecessors := usl.findPredecessors(block.edges[0].targetVertexId)
+	for level, predecessor := range predecessors {
+		if usl.forward(predecessor, level) == block {
+			usl.setForward(predecessor, usl.forward(block, level), level)
+		}
+	}
+	// Drop the levels left empty
+	for len(usl.levelPointers) > 0 && usl.levelPointers[len(usl.levelPointers)-1] == nil {
+		usl.levelPointers = usl.levelPointers[:len(usl.levelPointers)-1]
+	}
+}
+
+// checkUnderutilization merges a block with the next one when both are small, the caller holding
+// the lock of the block.
+func (usl *UnrolledSkipList) checkUnderutilization(block *EdgeBlock) {
+	if len(block.edges) < MIN_CAPACITY {
+		// Try to merge with next block
+		if next := block.next; next != nil && len(block.edges)+len(next.edges) <= MAX_CAPACITY {
+			usl.unlink(next)
+			block.edges = append(block.edges, next.edges...)
+		}
+	}
+}
+
+// search returns the edge to a vertex as of a version, false when there is none.
+func (usl *UnrolledSkipList) search(targetVertexId int, asOf int64) (Edge, bool) {
+	usl.mu.RLock()
+	defer usl.mu.RUnlock()
+
+	block := usl.forward(usl.findPredecessors(targetVertexId)[0], 0)
+	if block == nil {
+		return Edge{}, false
+	}
+	block.mu.RLock()
+	defer block.mu.RUnlock()
+	idx := sort.Search(len(block.edges), func(i int) bool {
+		return block.edges[i].targetVertexId >= targetVertexId
+	})
+	if idx < len(block.edges) && block.edges[idx].targetVertexId == targetVertexId {
+		return block.edges[idx].visibleAt(asOf)
+	}
+	return Edge{}, false
+}
+
+func (usl *UnrolledSkipList) insert(edge Edge) {
+	usl.mu.Lock()
+	defer usl.mu.Unlock()
+
+	if usl.head == nil {
+		usl.head = &EdgeBlock{
+			edges: []Edge{edge},
+			next:  nil,
+		}
+		return
+	}
+
+	predecessor := usl.findPredecessors(edge.targetVertexId)[0]
+	block := usl.forward(predecessor, 0)
+	if block == nil {
+		// Past the last block, the edge goes to its end
+		block = predecessor
+	}
+	block.mu.Lock()
+	newBlock := block.insertEdge(edge)
+	block.mu.Unlock()
+	if newBlock != nil {
+		usl.link(newBlock)
+	}
+}
+
+func (usl *UnrolledSkipList) delete(targetVertexId int, version int64) {
+	usl.mu.Lock()
+	defer usl.mu.Unlock()
+
+	block := usl.forward(usl.findPredecessors(targetVertexId)[0], 0)
+	if block == nil {
+		return
+	}
+	block.mu.Lock()
+	defer block.mu.Unlock()
+	idx := sort.Search(len(block.edges), func(i int) bool {
+		return block.edges[i].targetVertexId >= targetVertexId
+	})
+	if idx < len(block.edges) && block.edges[idx].targetVertexId == targetVertexId && !block.edges[idx].isDeleted {
+		// The deletion is a version of its own, older reads still see the edge
+		previous := block.edges[idx]
+		block.edges[idx] = Edge{
+			targetVertexId: targetVertexId,
+			version:        version,
+			isDeleted:      true,
+			previous:       &previous,
+		}
+		usl.checkUnderutilization(block)
+	}
+}
+
+// removeDeletedEdges reclaims the edge versions no read as of the watermark or later sees: those
+// older than the version visible at the watermark, and edges deleted by then altogether. Blocks
+// left empty are dropped.
+func (usl *UnrolledSkipList) removeDeletedEdges(watermark int64) {
+	usl.mu.Lock()
+	defer usl.mu.Unlock()
+
+	currentBlock := usl.head
+	for currentBlock != nil {
+		currentBlock.mu.Lock()
+		var newEdges []Edge
+		for _, edge := range currentBlock.edges {
+			if edge.version <= watermark && edge.isDeleted {
+				continue
+			}
+			for version := &edge; version != nil; version = version.previous {
+				if version.version <= watermark {
+					version.previous = nil
+					break
+				}
+			}
+			newEdges = append(newEdges, edge)
+		}
+		if len(newEdges) == 0 {
+			// Unlink the block while it still has its keys
+			usl.unlink(currentBlock)
+		}
+		currentBlock.edges = newEdges
+		nextBlock := currentBlock.next
+		currentBlock.mu.Unlock()
+		currentBlock = nextBlock
+	}
+}
+
+func (usl *UnrolledSkipList) getActiveEdges(asOf int64) []int {
+	var activeEdges []int
+	for _, edge := range usl.getActiveEdgeEntries(asOf) {
+		activeEdges = append(activeEdges, edge.targetVertexId)
+	}
+	return activeEdges
+}
+
+// getActiveEdgeEntries returns the edges active as of a version, with their weights and
+// properties, by target.
+func (usl *UnrolledSkipList) getActiveEdgeEntries(asOf int64) []Edge {
+	usl.mu.RLock()
+	defer usl.mu.RUnlock()
+
+	var activeEdges []Edge
+	currentBlock := usl.head
+	for currentBlock != nil {
+		currentBlock.mu.RLock()
+		for _, edge := range currentBlock.edges {
+			if visible, ok := edge.visibleAt(asOf); ok {
+				activeEdges = append(activeEdges, visible)
+			}
+		}
+		nextBlock := currentBlock.next
+		currentBlock.mu.RUnlock()
+		currentBlock = nextBlock
+	}
+	return activeEdges
+}
@@ -0,0 +1,431 @@
+// sortledton.go
+
+// Package sortledton implements a dynamic graph after Sortledton: the edges of each vertex are
+// kept sorted in an unrolled skip list, inserts and deletes add versions of the edges that read
+// transactions see as of the version they began at, and the garbage collector reclaims the versions
+// and the tombstones of deleted vertices no active read can see anymore.
+package sortledton
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+type Vertex struct {
+	id            int
+	adjacencyList *UnrolledSkipList
+	deleted       bool  // Tombstone, until the garbage collector removes the vertex
+	deletedAt     int64 // Version of the last deletion
+	mu            sync.RWMutex
+}
+
+type VertexIdManager struct {
+	externalToInternal map[int]int
+	internalToExternal []int
+	mu                 sync.Mutex
+}
+
+func NewVertexIdManager() *VertexIdManager {
+	return &VertexIdManager{
+		externalToInternal: make(map[int]int),
+		internalToExternal: make([]int, 0),
+	}
+}
+
+func (vm *VertexIdManager) getInternalId(externalId int) int {
+	vm.mu.Lock()
+	defer vm.mu.Unlock()
+	if internalId, exists := vm.externalToInternal[externalId]; exists {
+		return internalId
+	}
+	internalId := len(vm.internalToExternal)
+	vm.externalToInternal[externalId] = internalId
+	vm.internalToExternal = append(vm.internalToExternal, externalId)
+	return internalId
+}
+
+func (vm *VertexIdManager) getExternalId(internalId int) int {
+	vm.mu.Lock()
+	defer vm.mu.Unlock()
+	return vm.internalToExternal[internalId]
+}
+
+// Graph is a directed graph of int vertices, safe for concurrent use. Vertices exist through their
+// edges: a vertex is added by the first edge from or to it.
+type Graph struct {
+	vertices        map[int]*Vertex
+	readWriteMu     sync.RWMutex // For concurrency control, held exclusively to delete vertices
+	vertexIdManager *VertexIdManager
+	version         int64         // Of the last change, read and written atomically
+	activeReads     map[int64]int // Read transactions by version
+	activeReadsMu   sync.Mutex
+}
+
+func New() *Graph {
+	return &Graph{
+		vertices:        make(map[int]*Vertex),
+		vertexIdManager: NewVertexIdManager(),
+		activeReads:     make(map[int64]int),
+	}
+}
+
+// nextVersion returns the version of a new change, the current time unless changes happen faster
+// than its resolution.
+func (g *Graph) nextVersion() int64 {
+	for {
+		last := atomic.LoadInt64(&g.version)
+		version := max(time.Now().UnixNano(), last+1)
+		if atomic.CompareAndSwapInt64(&g.version, last, version) {
+			return version
+		}
+	}
+}
+
+// currentVersion returns the version reads outside transactions see the changes up to.
+func (g *Graph) currentVersion() int64 {
+	return atomic.LoadInt64(&g.version)
+}
+
+// InsertEdge inserts an edge of weight 1 without properties.
+func (g *Graph) InsertEdge(sourceId, targetId int) {
+	g.InsertWeightedEdge(sourceId, targetId, 1, nil)
+}
+
+// InsertWeightedEdge inserts an edge with a weight and properties, a new version of the edge between
+// the vertices if there is one. Inserting an edge of a deleted vertex adds it back, without the edges
+// it had.
+func (g *Graph) InsertWeightedEdge(sourceId, targetId int, weight float64, properties map[string]interface{}) {
+	internalSourceId := g.vertexIdManager.getInternalId(sourceId)
+	internalTargetId := g.vertexIdManager.getInternalId(targetId)
+
+	for {
+		g.readWriteMu.RLock()
+		vertex, exists := g.vertices[internalSourceId]
+		target := g.vertices[internalTargetId]
+		if exists && !vertex.deleted && (target == nil || !target.deleted) {
+			// Lock the vertex
+			vertex.mu.Lock()
+			vertex.adjacencyList.insert(Edge{
+				targetVertexId: internalTargetId,
+				version:        g.nextVersion(),
+				isDeleted:      false,
+				weight:         weight,
+				properties:     properties,
+			})
+			vertex.mu.Unlock()
+			g.readWriteMu.RUnlock()
+			return
+		}
+		g.readWriteMu.RUnlock()
+
+		// Create the source vertex, or lift the tombstones, and try again
+		g.readWriteMu.Lock()
+		if vertex, exists = g.vertices[internalSourceId]; !exists {
+			g.vertices[internalSourceId] = &Vertex{
+				id:            internalSourceId,
+				adjacencyList: NewUnrolledSkipList(),
+			}
+		} else {
+			vertex.deleted = false
+		}
+		if target := g.vertices[internalTargetId]; target != nil {
+			target.deleted = false
+		}
+		g.readWriteMu.Unlock()
+	}
+}
+
+// DeleteEdge deletes the edge from one vertex to another, if there is one.
+func (g *Graph) DeleteEdge(sourceId, targetId int) {
+	internalSourceId := g.vertexIdManager.getInternalId(sourceId)
+	internalTargetId := g.vertexIdManager.getInternalId(targetId)
+
+	g.readWriteMu.RLock()
+	defer g.readWriteMu.RUnlock()
+	vertex, exists := g.vertices[internalSourceId]
+	if !exists {
+		return
+	}
+
+	// Lock the vertex
+	vertex.mu.Lock()
+	defer vertex.mu.Unlock()
+	vertex.adjacencyList.delete(internalTargetId, g.nextVersion())
+}
+
+// DeleteVertex deletes a vertex with its edges, both from and to it, which takes a lookup in the
+// edges of every vertex. The vertex is tombstoned until the garbage collector removes it with the
+// versions of its edges.
+func (g *Graph) DeleteVertex(vertexId int) {
+	internalId := g.vertexIdManager.getInternalId(vertexId)
+
+	g.readWriteMu.Lock()
+	defer g.readWriteMu.Unlock()
+	version := g.nextVersion()
+	vertex, exists := g.vertices[internalId]
+	if !exists {
+		// A vertex only edges point to gets a tombstone of its own
+		vertex = &Vertex{
+			id:            internalId,
+			adjacencyList: NewUnrolledSkipList(),
+		}
+		g.vertices[internalId] = vertex
+	}
+
+	for _, other := range g.vertices {
+		other.mu.Lock()
+		other.adjacencyList.delete(internalId, version)
+		other.mu.Unlock()
+	}
+	vertex.mu.Lock()
+	defer vertex.mu.Unlock()
+	for _, target := range vertex.adjacencyList.getActiveEdges(version) {
+		vertex.adjacencyList.delete(target, version)
+	}
+	vertex.deleted = true
+	vertex.deletedAt = version
+}
+
+// activeEdges returns the edges of a vertex as of a version, the caller holding the read lock of
+// the graph.
+func (g *Graph) activeEdges(vertex *Vertex, asOf int64) []Edge {
+	vertex.mu.RLock()
+	defer vertex.mu.RUnlock()
+	return vertex.adjacencyList.getActiveEdgeEntries(asOf)
+}
+
+// ReadTx is a read-only transaction: it sees the graph as of the version it began at, however the
+// graph changes since, and keeps the garbage collector from reclaiming what it may read until it
+// ends.
+type ReadTx struct {
+	graph   *Graph
+	version int64
+	once    sync.Once
+}
+
+// BeginRead begins a read transaction, which must be ended.
+func (g *Graph) BeginRead() *ReadTx {
+	// Wait for the changes in progress, so that none lands at or below the version of the
+	// transaction once it began
+	g.readWriteMu.Lock()
+	defer g.readWriteMu.Unlock()
+	g.activeReadsMu.Lock()
+	defer g.activeReadsMu.Unlock()
+	version := g.currentVersion()
+	g.activeReads[version]++
+	return &ReadTx{graph: g, version: version}
+}
+
+// End ends the transaction, letting the garbage collector reclaim the versions it could read.
+func (tx *ReadTx) End() {
+	tx.once.Do(func() {
+		g := tx.graph
+		g.activeReadsMu.Lock()
+		defer g.activeReadsMu.Unlock()
+		if g.activeReads[tx.version]--; g.activeReads[tx.version] == 0 {
+			delete(g.activeReads, tx.version)
+		}
+	})
+}
+
+// Neighbors returns the targets of the edges of a vertex as of the transaction.
+func (tx *ReadTx) Neighbors(vertexId int) []int {
+	return tx.graph.neighbors(vertexId, tx.version)
+}
+
+// Edge returns the edge from one vertex to another as of the transaction.
+func (tx *ReadTx) Edge(sourceId, targetId int) (Edge, bool) {
+	return tx.graph.edge(sourceId, targetId, tx.version)
+}
+
+// Edges returns the edges of a vertex as of the transaction.
+func (tx *ReadTx) Edges(vertexId int) []Edge {
+	return tx.graph.edges(vertexId, tx.version)
+}
+
+// oldestActiveVersion returns the watermark of the garbage collector: the oldest version an active
+// read transaction began at, the current one when there is none.
+func (g *Graph) oldestActiveVersion() int64 {
+	g.activeReadsMu.Lock()
+	defer g.activeReadsMu.Unlock()
+	oldest := g.currentVersion()
+	for version := range g.activeReads {
+		oldest = min(oldest, version)
+	}
+	return oldest
+}
+
+// Neighbors returns the targets of the edges of a vertex, sorted by internal ID.
+func (g *Graph) Neighbors(vertexId int) []int {
+	return g.neighbors(vertexId, g.currentVersion())
+}
+
+func (g *Graph) neighbors(vertexId int, asOf int64) []int {
+	internalId := g.vertexIdManager.getInternalId(vertexId)
+
+	g.readWriteMu.RLock()
+	defer g.readWriteMu.RUnlock()
+	vertex, exists := g.vertices[internalId]
+	if !exists {
+		return []int{}
+	}
+
+	edges := g.activeEdges(vertex, asOf)
+	neighbors := make([]int, len(edges))
+	for i, edge := range edges {
+		neighbors[i] = g.vertexIdManager.getExternalId(edge.targetVertexId)
+	}
+	return neighbors
+}
+
+// Edge returns the edge from one vertex to another, with the target as an external ID, false when
+// there is none.
+func (g *Graph) Edge(sourceId, targetId int) (Edge, bool) {
+	return g.edge(sourceId, targetId, g.currentVersion())
+}
+
+func (g *Graph) edge(sourceId, targetId int, asOf int64) (Edge, bool) {
+	internalSourceId := g.vertexIdManager.getInternalId(sourceId)
+	internalTargetId := g.vertexIdManager.getInternalId(targetId)
+
+	g.readWriteMu.RLock()
+	defer g.readWriteMu.RUnlock()
+	vertex, exists := g.vertices[internalSourceId]
+	if !exists {
+		return Edge{}, false
+	}
+
+	vertex.mu.RLock()
+	edge, ok := vertex.adjacencyList.search(internalTargetId, asOf)
+	vertex.mu.RUnlock()
+	if !ok {
+		return Edge{}, false
+	}
+	edge.targetVertexId = targetId
+	return edge, true
+}
+
+// Edges returns the edges of a vertex, with their targets as external IDs.
+func (g *Graph) Edges(vertexId int) []Edge {
+	return g.edges(vertexId, g.currentVersion())
+}
+
+func (g *Graph) edges(vertexId int, asOf int64) []Edge {
+	internalId := g.vertexIdManager.getInternalId(vertexId)
+
+	g.readWriteMu.RLock()
+	defer g.readWriteMu.RUnlock()
+	vertex, exists := g.vertices[internalId]
+	if !exists {
+		return []Edge{}
+	}
+
+	edges := g.activeEdges(vertex, asOf)
+	for i := range edges {
+		edges[i].targetVertexId = g.vertexIdManager.getExternalId(edges[i].targetVertexId)
+	}
+	return edges
+}
+
+func intersectSortedSlices(a, b []int) []int {
+	var intersection []int
+	i, j := 0, 0
+	for i < len(a) && j < len(b) {
+		if a[i] == b[j] {
+			intersection = append(intersection, a[i])
+			i++
+			j++
+		} else if a[i] < b[j] {
+			i++
+		} else {
+			j++
+		}
+	}
+	return intersection
+}
+
+// IntersectNeighbors returns the neighbors two vertices share.
+func (g *Graph) IntersectNeighbors(vertexId1, vertexId2 int) []int {
+	common := g.IntersectWeightedNeighbors(vertexId1, vertexId2)
+	neighbors := make([]int, len(common))
+	for i, neighbor := range common {
+		neighbors[i] = neighbor.VertexId
+	}
+	return neighbors
+}
+
+// CommonNeighbor is a neighbor of two vertices, with the edges from each of them.
+type CommonNeighbor struct {
+	VertexId int
+	First    Edge // from the first vertex
+	Second   Edge // from the second vertex
+}
+
+// IntersectWeightedNeighbors returns the neighbors two vertices share, with the edges to them, e.g.
+// to score how similar the vertices are from the weights.
+func (g *Graph) IntersectWeightedNeighbors(vertexId1, vertexId2 int) []CommonNeighbor {
+	internalId1 := g.vertexIdManager.getInternalId(vertexId1)
+	internalId2 := g.vertexIdManager.getInternalId(vertexId2)
+
+	g.readWriteMu.RLock()
+	firstVertex, exists1 := g.vertices[internalId1]
+	secondVertex, exists2 := g.vertices[internalId2]
+	if !exists1 || !exists2 {
+		g.readWriteMu.RUnlock()
+		return []CommonNeighbor{}
+	}
+	asOf := g.currentVersion()
+	edges1 := g.activeEdges(firstVertex, asOf)
+	edges2 := g.activeEdges(secondVertex, asOf)
+	g.readWriteMu.RUnlock()
+
+	intersection := []CommonNeighbor{}
+	i, j := 0, 0
+	for i < len(edges1) && j < len(edges2) {
+		if edges1[i].targetVertexId == edges2[j].targetVertexId {
+			externalId := g.vertexIdManager.getExternalId(edges1[i].targetVertexId)
+			first, second := edges1[i], edges2[j]
+			first.targetVertexId, second.targetVertexId = externalId, externalId
+			intersection = append(intersection, CommonNeighbor{VertexId: externalId, First: first, Second: second})
+			i++
+			j++
+		} else if edges1[i].targetVertexId < edges2[j].targetVertexId {
+			i++
+		} else {
+			j++
+		}
+	}
+	return intersection
+}
+
+// GarbageCollect reclaims the edge versions and the tombstones of vertices no read can see
+// anymore, all but those of the oldest active read transaction onwards.
+func (g *Graph) GarbageCollect() {
+	g.readWriteMu.Lock()
+	defer g.readWriteMu.Unlock()
+
+	oldestActiveTransactionTimestamp := g.oldestActiveVersion()
+
+	for _, vertex := range g.vertices {
+		vertex.mu.Lock()
+		vertex.adjacencyList.removeDeletedEdges(oldestActiveTransactionTimestamp)
+		vertex.mu.Unlock()
+	}
+
+	// Deleted vertices have no edges left once their deletion is older than every read
+	for internalId, vertex := range g.vertices {
+		if vertex.deleted && vertex.deletedAt <= oldestActiveTransactionTimestamp && vertex.adjacencyList.head == nil {
+			delete(g.vertices, internalId)
+		}
+	}
+}
+
+func (g *Graph) StartGarbageCollector(interval time.Duration) {
+	go func() {
+		for {
+			time.Sleep(interval)
+			g.GarbageCollect()
+		}
+	}()
+}
@@ -0,0 +1,225 @@
+// sortledton_test.go
+package sortledton
+
+import (
+	"math"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGraph(t *testing.T) {
+	g := New()
+	g.InsertEdge(1, 2)
+	g.InsertEdge(1, 3)
+	g.InsertWeightedEdge(2, 3, 0.5, map[string]interface{}{"document": "a.md", "chunk": 4})
+	g.DeleteEdge(1, 2)
+
+	assert.Equal(t, []int{3}, g.Neighbors(1))
+	assert.Equal(t, []int{3}, g.IntersectNeighbors(1, 2))
+
+	edge, ok := g.Edge(2, 3)
+	require.True(t, ok)
+	assert.Equal(t, 3, edge.Target())
+	assert.Equal(t, 0.5, edge.Weight())
+	document, ok := edge.StringProperty("document")
+	assert.True(t, ok)
+	assert.Equal(t, "a.md", document)
+	chunk, ok := edge.IntProperty("chunk")
+	assert.True(t, ok)
+	assert.Equal(t, 4, chunk)
+	_, ok = edge.FloatProperty("document")
+	assert.False(t, ok)
+
+	_, ok = g.Edge(1, 2)
+	assert.False(t, ok)
+	common := g.IntersectWeightedNeighbors(1, 2)
+	require.Len(t, common, 1)
+	assert.Equal(t, 1.0, common[0].First.Weight())
+	assert.Equal(t, 0.5, common[0].Second.Weight())
+}
+
+func TestDeleteVertex(t *testing.T) {
+	g := New()
+	g.InsertEdge(1, 2)
+	g.InsertEdge(2, 3)
+	g.InsertEdge(3, 2)
+	g.InsertEdge(4, 2)
+	g.InsertEdge(2, 2)
+
+	// The edges from and to the vertex go at once
+	g.DeleteVertex(2)
+	assert.Empty(t, g.Neighbors(1))
+	assert.Empty(t, g.Neighbors(2))
+	assert.Empty(t, g.Neighbors(3))
+	_, ok := g.Edge(4, 2)
+	assert.False(t, ok)
+	assert.Equal(t, 0, g.Snapshot().NumEdges())
+
+	// Inserting an edge adds the vertex back, without its old edges
+	g.InsertEdge(5, 2)
+	assert.Equal(t, []int{2}, g.Neighbors(5))
+	assert.Empty(t, g.Neighbors(1))
+
+	// The garbage collector removes the edges and the tombstones
+	g.DeleteVertex(3)
+	g.GarbageCollect()
+	assert.Empty(t, g.Neighbors(1))
+	assert.Equal(t, []int{2}, g.Neighbors(5))
+	g.readWriteMu.RLock()
+	for _, vertex := range g.vertices {
+		assert.False(t, vertex.deleted)
+	}
+	g.readWriteMu.RUnlock()
+	g.InsertEdge(1, 3)
+	assert.Equal(t, []int{3}, g.Neighbors(1))
+}
+
+func TestReadTx(t *testing.T) {
+	g := New()
+	g.InsertEdge(1, 2)
+	g.InsertWeightedEdge(1, 3, 0.5, nil)
+
+	// The transaction sees the graph as of when it began
+	tx := g.BeginRead()
+	g.DeleteEdge(1, 2)
+	g.InsertWeightedEdge(1, 3, 0.25, nil)
+	g.InsertEdge(1, 4)
+	g.DeleteVertex(3)
+	assert.Equal(t, []int{4}, g.Neighbors(1))
+	assert.Equal(t, []int{2, 3}, tx.Neighbors(1))
+	edge, ok := tx.Edge(1, 3)
+	require.True(t, ok)
+	assert.Equal(t, 0.5, edge.Weight())
+
+	// The garbage collector keeps the versions it may read until it ends
+	g.GarbageCollect()
+	assert.Equal(t, []int{2, 3}, tx.Neighbors(1))
+	assert.Len(t, tx.Edges(1), 2)
+	tx.End()
+	tx.End()
+	g.GarbageCollect()
+	assert.Equal(t, []int{4}, g.Neighbors(1))
+
+	g.readWriteMu.RLock()
+	defer g.readWriteMu.RUnlock()
+	assert.Len(t, g.vertices, 1)
+	for _, vertex := range g.vertices {
+		for block := vertex.adjacencyList.head; block != nil; block = block.next {
+			for _, edge := range block.edges {
+				assert.False(t, edge.isDeleted)
+				assert.Nil(t, edge.previous)
+			}
+		}
+	}
+}
+
+func TestUnrolledSkipList(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	usl := NewUnrolledSkipList()
+	expected := make(map[int]bool)
+	for i := 0; i < 50000; i++ {
+		target := rng.Intn(5000)
+		if rng.Intn(4) == 0 {
+			usl.delete(target, 1)
+			delete(expected, target)
+		} else {
+			usl.insert(Edge{targetVertexId: target, weight: 1})
+			expected[target] = true
+		}
+		_, ok := usl.search(target, math.MaxInt64)
+		require.Equal(t, expected[target], ok, "target %d", target)
+		if i%10000 == 0 {
+			usl.removeDeletedEdges(math.MaxInt64)
+		}
+	}
+
+	// Every level is ordered and skips over level 0
+	positions := make(map[*EdgeBlock]int)
+	position := 0
+	for block := usl.head; block != nil; block = block.next {
+		require.NotEmpty(t, block.edges)
+		positions[block] = position
+		position++
+	}
+	assert.Greater(t, position, 1)
+	for level := 1; level <= len(usl.levelPointers); level++ {
+		last := -1
+		for block := usl.forward(nil, level); block != nil; block = usl.forward(block, level) {
+			position, ok := positions[block]
+			require.True(t, ok)
+			require.Greater(t, position, last)
+			last = position
+		}
+	}
+
+	var targets []int
+	for target := range expected {
+		targets = append(targets, target)
+	}
+	sort.Ints(targets)
+	assert.Equal(t, targets, usl.getActiveEdges(math.MaxInt64))
+}
+
+func TestDurableGraph(t *testing.T) {
+	dir := t.TempDir()
+	dg, err := Open(dir, 3)
+	require.NoError(t, err)
+	require.NoError(t, dg.InsertWeightedEdge(1, 2, 0.5, map[string]interface{}{"document": "a.md"}))
+	require.NoError(t, dg.InsertEdge(1, 3))
+	require.NoError(t, dg.InsertEdge(4, 1)) // snapshots
+	require.NoError(t, dg.DeleteEdge(1, 3))
+	require.NoError(t, dg.DeleteVertex(4))
+	require.NoError(t, dg.Close())
+
+	// A record torn by a crash is dropped on recovery
+	wal, err := os.OpenFile(filepath.Join(dir, walFileName), os.O_APPEND|os.O_WRONLY, 0)
+	require.NoError(t, err)
+	_, err = wal.Write([]byte{9, 0, 0, 0, 1})
+	require.NoError(t, err)
+	require.NoError(t, wal.Close())
+
+	dg, err = Open(dir, 3)
+	require.NoError(t, err)
+	assert.Equal(t, []int{2}, dg.Graph().Neighbors(1))
+	assert.Empty(t, dg.Graph().Neighbors(4))
+	edge, ok := dg.Graph().Edge(1, 2)
+	require.True(t, ok)
+	assert.Equal(t, 0.5, edge.Weight())
+	document, _ := edge.StringProperty("document")
+	assert.Equal(t, "a.md", document)
+
+	require.NoError(t, dg.InsertEdge(2, 1))
+	require.NoError(t, dg.SaveSnapshot())
+	require.NoError(t, dg.Close())
+	dg, err = Open(dir, 3)
+	require.NoError(t, err)
+	defer dg.Close()
+	assert.Equal(t, []int{1}, dg.Graph().Neighbors(2))
+	assert.Equal(t, 2, dg.Graph().Snapshot().NumEdges())
+}
+
+func TestAnalytics(t *testing.T) {
+	g := New()
+	for _, edge := range [][2]int{{1, 2}, {2, 3}, {3, 1}, {1, 3}, {3, 4}, {4, 1}, {7, 8}, {5, 5}} {
+		g.InsertEdge(edge[0], edge[1])
+	}
+	snapshot := g.Snapshot()
+	assert.Equal(t, 7, snapshot.NumVertices())
+	assert.Equal(t, int64(2), snapshot.TriangleCount())
+	assert.Equal(t, map[int]int{1: 1, 2: 1, 3: 1, 4: 1, 5: 5, 7: 7, 8: 7}, snapshot.WeaklyConnectedComponents())
+
+	ranks := snapshot.PageRank(0.85, 100, 1e-9)
+	var sum float64
+	for _, rank := range ranks {
+		sum += rank
+	}
+	assert.InDelta(t, 1, sum, 1e-6)
+	assert.Greater(t, ranks[1], ranks[7])
+	assert.Greater(t, ranks[8], ranks[7])
+}
@@ -0,0 +1,362 @@
+// wal.go
+
+package sortledton
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	walFileName      = "edges.wal"
+	snapshotFileName = "edges.snapshot"
+
+	// Records are framed by their length and CRC-32, both little-endian uint32
+	recordHeaderSize = 8
+	maxRecordSize    = 64 << 20
+)
+
+type walOp byte
+
+const (
+	walInsert walOp = iota + 1
+	walDelete
+	walDeleteVertex // of Source
+)
+
+// walRecord is an edge or vertex operation of the write-ahead log, and an edge of a snapshot. Properties are
+// stored as JSON, so numbers come back as float64 after recovery.
+type walRecord struct {
+	Op         walOp                  `json:"op"`
+	Source     int                    `json:"source"`
+	Target     int                    `json:"target"`
+	Weight     float64                `json:"weight"`
+	Properties map[string]interface{} `json:"properties,omitempty"`
+}
+
+// DurableGraph is a Graph whose edge inserts and deletes, and vertex deletes, are appended to a write-ahead
+// log, synced to disk, before they are applied. Snapshots of the edges replace the log once it
+// grows past a number of records, or periodically, and the graph is recovered on open from the last
+// snapshot and the log written since.
+type DurableGraph struct {
+	graph         *Graph
+	dir           string
+	wal           *os.File
+	walRecords    int // since the last snapshot
+	snapshotEvery int // records, never when 0
+	mu            sync.Mutex
+}
+
+// Open opens the graph stored in a directory, creating it when missing, and snapshots it every
+// snapshotEvery log records.
+func Open(dir string, snapshotEvery int) (*DurableGraph, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("error creating graph directory: %v", err)
+	}
+	dg := &DurableGraph{graph: New(), dir: dir, snapshotEvery: snapshotEvery}
+
+	// Recover the last snapshot, then the operations logged since
+	if err := dg.loadSnapshot(); err != nil {
+		return nil, err
+	}
+	wal, err := os.OpenFile(filepath.Join(dir, walFileName), os.O_RDWR|os.O_CREATE, 0o644)
+	if err != nil {
+		return nil, fmt.Errorf("error opening write-ahead log: %v", err)
+	}
+	valid, err := readRecords(bufio.NewReader(wal), func(record walRecord) error {
+		dg.apply(record)
+		dg.walRecords++
+		return nil
+	})
+	if err != nil && !errors.Is(err, errTornRecord) {
+		wal.Close()
+		return nil, fmt.Errorf("error reading write-ahead log: %v", err)
+	}
+
+	// A record torn by a crash was never acknowledged, drop it so new records follow the last
+	// complete one
+	if err := wal.Truncate(valid); err != nil {
+		wal.Close()
+		return nil, fmt.Errorf("error truncating write-ahead log: %v", err)
+	}
+	if _, err := wal.Seek(valid, io.SeekStart); err != nil {
+		wal.Close()
+		return nil, err
+	}
+	dg.wal = wal
+	return dg, nil
+}
+
+// Graph returns the in-memory graph, for reads. Changes must go through the DurableGraph.
+func (dg *DurableGraph) Graph() *Graph {
+	return dg.graph
+}
+
+// InsertEdge logs and inserts an edge of weight 1 without properties.
+func (dg *DurableGraph) InsertEdge(sourceId, targetId int) error {
+	return dg.InsertWeightedEdge(sourceId, targetId, 1, nil)
+}
+
+// InsertWeightedEdge logs and inserts an edge with a weight and properties.
+func (dg *DurableGraph) InsertWeightedEdge(sourceId, targetId int, weight float64, properties map[string]interface{}) error {
+	return dg.log(walRecord{Op: walInsert, Source: sourceId, Target: targetId, Weight: weight, Properties: properties})
+}
+
+// DeleteEdge logs and deletes an edge.
+func (dg *DurableGraph) DeleteEdge(sourceId, targetId int) error {
+	return dg.log(walRecord{Op: walDelete, Source: sourceId, Target: targetId})
+}
+
+// DeleteVertex logs and deletes a vertex with its edges.
+func (dg *DurableGraph) DeleteVertex(vertexId int) error {
+	return dg.log(walRecord{Op: walDeleteVertex, Source: vertexId})
+}
+
+// log appends an operation to the write-ahead log and, once it is on disk, applies it.
+func (dg *DurableGraph) log(record walRecord) error {
+	dg.mu.Lock()
+	defer dg.mu.Unlock()
+	if dg.wal == nil {
+		return errors.New("graph is closed")
+	}
+
+	if err := writeRecord(dg.wal, record); err != nil {
+		return fmt.Errorf("error writing to write-ahead log: %v", err)
+	}
+	if err := dg.wal.Sync(); err != nil {
+		return fmt.Errorf("error syncing write-ahead log: %v", err)
+	}
+	dg.apply(record)
+	dg.walRecords++
+
+	if dg.snapshotEvery > 0 && dg.walRecords >= dg.snapshotEvery {
+		return dg.snapshotLocked()
+	}
+	return nil
+}
+
+// apply applies an operation to the in-memory graph.
+func (dg *DurableGraph) apply(record walRecord) {
+	switch record.Op {
+	case walInsert:
+		dg.graph.InsertWeightedEdge(record.Source, record.Target, record.Weight, record.Properties)
+	case walDelete:
+		dg.graph.DeleteEdge(record.Source, record.Target)
+	case walDeleteVertex:
+		dg.graph.DeleteVertex(record.Source)
+	}
+}
+
+// SaveSnapshot writes the edges of the graph to a new snapshot and empties the write-ahead log.
+func (dg *DurableGraph) SaveSnapshot() error {
+	dg.mu.Lock()
+	defer dg.mu.Unlock()
+	if dg.wal == nil {
+		return errors.New("graph is closed")
+	}
+	return dg.snapshotLocked()
+}
+
+func (dg *DurableGraph) snapshotLocked() error {
+	// Write the snapshot aside and rename it, so a crash leaves the previous one intact
+	path := filepath.Join(dg.dir, snapshotFileName)
+	tmp, err := os.Create(path + ".tmp")
+	if err != nil {
+		return fmt.Errorf("error creating snapshot: %v", err)
+	}
+	defer os.Remove(tmp.Name())
+	w := bufio.NewWriter(tmp)
+	for _, record := range dg.graph.edgeRecords() {
+		if err := writeRecord(w, record); err != nil {
+			tmp.Close()
+			return fmt.Errorf("error writing snapshot: %v", err)
+		}
+	}
+	if err := w.Flush(); err != nil {
+		tmp.Close()
+		return fmt.Errorf("error writing snapshot: %v", err)
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return fmt.Errorf("error syncing snapshot: %v", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return fmt.Errorf("error replacing snapshot: %v", err)
+	}
+	if err := syncDir(dg.dir); err != nil {
+		return err
+	}
+
+	// The log is covered by the snapshot now. Crashing before it is emptied only replays it over
+	// the snapshot, which leaves every edge as its last operation set it.
+	if err := dg.wal.Truncate(0); err != nil {
+		return fmt.Errorf("error truncating write-ahead log: %v", err)
+	}
+	if _, err := dg.wal.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	if err := dg.wal.Sync(); err != nil {
+		return fmt.Errorf("error syncing write-ahead log: %v", err)
+	}
+	dg.walRecords = 0
+	return nil
+}
+
+// loadSnapshot loads the edges of the last snapshot, if there is one.
+func (dg *DurableGraph) loadSnapshot() error {
+	f, err := os.Open(filepath.Join(dg.dir, snapshotFileName))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("error opening snapshot: %v", err)
+	}
+	defer f.Close()
+	// Snapshots are renamed into place once complete, so unlike the log they are never torn
+	if _, err := readRecords(bufio.NewReader(f), func(record walRecord) error {
+		dg.apply(record)
+		return nil
+	}); err != nil {
+		return fmt.Errorf("error reading snapshot: %v", err)
+	}
+	return nil
+}
+
+// StartSnapshotter snapshots the graph periodically, when the log has records.
+func (dg *DurableGraph) StartSnapshotter(interval time.Duration) {
+	go func() {
+		for {
+			time.Sleep(interval)
+			dg.mu.Lock()
+			if dg.wal == nil {
+				dg.mu.Unlock()
+				return
+			}
+			if dg.walRecords > 0 {
+				if err := dg.snapshotLocked(); err != nil {
+					slog.Error("failed to snapshot graph", "dir", dg.dir, "error", err)
+				}
+			}
+			dg.mu.Unlock()
+		}
+	}()
+}
+
+// Close closes the write-ahead log. The graph can't be changed afterwards.
+func (dg *DurableGraph) Close() error {
+	dg.mu.Lock()
+	defer dg.mu.Unlock()
+	if dg.wal == nil {
+		return nil
+	}
+	err := dg.wal.Close()
+	dg.wal = nil
+	return err
+}
+
+// edgeRecords returns the active edges of the graph as insert records, by source and target.
+// Deleted vertices have none, so the snapshot needs no record of them.
+func (g *Graph) edgeRecords() []walRecord {
+	g.readWriteMu.RLock()
+	defer g.readWriteMu.RUnlock()
+
+	var records []walRecord
+	for _, vertex := range g.vertices {
+		sourceId := g.vertexIdManager.getExternalId(vertex.id)
+		for _, edge := range g.activeEdges(vertex, g.currentVersion()) {
+			records = append(records, walRecord{
+				Op:         walInsert,
+				Source:     sourceId,
+				Target:     g.vertexIdManager.getExternalId(edge.targetVertexId),
+				Weight:     edge.weight,
+				Properties: edge.properties,
+			})
+		}
+	}
+	sort.Slice(records, func(i, j int) bool {
+		if records[i].Source != records[j].Source {
+			return records[i].Source < records[j].Source
+		}
+		return records[i].Target < records[j].Target
+	})
+	return records
+}
+
+// writeRecord writes a record framed by its length and checksum.
+func writeRecord(w io.Writer, record walRecord) error {
+	payload, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	var header [recordHeaderSize]byte
+	binary.LittleEndian.PutUint32(header[0:4], uint32(len(payload)))
+	binary.LittleEndian.PutUint32(header[4:8], crc32.ChecksumIEEE(payload))
+	if _, err := w.Write(header[:]); err != nil {
+		return err
+	}
+	_, err = w.Write(payload)
+	return err
+}
+
+// errTornRecord is returned by readRecords for a record cut short or failing its checksum.
+var errTornRecord = errors.New("torn record")
+
+// readRecords reads records until the end of the reader, and returns the offset past the last
+// complete one. Reading stops with errTornRecord at a record cut short or corrupted.
+func readRecords(r io.Reader, fn func(record walRecord) error) (int64, error) {
+	var offset int64
+	var header [recordHeaderSize]byte
+	for {
+		if _, err := io.ReadFull(r, header[:]); err == io.EOF {
+			return offset, nil
+		} else if err != nil {
+			return offset, errTornRecord
+		}
+		size := binary.LittleEndian.Uint32(header[0:4])
+		if size > maxRecordSize {
+			return offset, errTornRecord
+		}
+		payload := make([]byte, size)
+		if _, err := io.ReadFull(r, payload); err != nil {
+			return offset, errTornRecord
+		}
+		if crc32.ChecksumIEEE(payload) != binary.LittleEndian.Uint32(header[4:8]) {
+			return offset, errTornRecord
+		}
+		var record walRecord
+		if err := json.Unmarshal(payload, &record); err != nil {
+			return offset, fmt.Errorf("error decoding record: %v", err)
+		}
+		if err := fn(record); err != nil {
+			return offset, err
+		}
+		offset += recordHeaderSize + int64(size)
+	}
+}
+
+// syncDir syncs a directory, making the files renamed into it durable.
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	if err := d.Sync(); err != nil {
+		return fmt.Errorf("error syncing directory: %v", err)
+	}
+	return nil
+}
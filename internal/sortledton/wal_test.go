@@ -0,0 +1,159 @@
+// wal_test.go
+
+package sortledton
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDurableGraphRecovery(t *testing.T) {
+	dir := t.TempDir()
+	dg, err := Open(dir, 0)
+	require.NoError(t, err)
+	require.NoError(t, dg.InsertWeightedEdge(1, 2, 0.5, map[string]interface{}{"document": "a.md", "chunk": 4}))
+	require.NoError(t, dg.InsertEdge(1, 3))
+	require.NoError(t, dg.InsertEdge(2, 3))
+	require.NoError(t, dg.DeleteEdge(1, 3))
+	require.NoError(t, dg.Close())
+	assert.Error(t, dg.InsertEdge(3, 1), "closed graphs can't be changed")
+
+	// The log is replayed on open, properties coming back from JSON
+	dg, err = Open(dir, 0)
+	require.NoError(t, err)
+	defer dg.Close()
+	assert.Equal(t, []int{2}, dg.Graph().Neighbors(1))
+	assert.Equal(t, []int{3}, dg.Graph().Neighbors(2))
+	edge, ok := dg.Graph().Edge(1, 2)
+	require.True(t, ok)
+	assert.Equal(t, 0.5, edge.Weight())
+	chunk, ok := edge.FloatProperty("chunk")
+	assert.True(t, ok)
+	assert.Equal(t, 4.0, chunk)
+	assert.Equal(t, 4, dg.walRecords)
+}
+
+func TestDurableGraphTornWrite(t *testing.T) {
+	tests := []struct {
+		name string
+		want []int // neighbors of 1 recovered
+		tear func(t *testing.T, path string)
+	}{
+		{"partial header", []int{2, 3}, func(t *testing.T, path string) { appendBytes(t, path, []byte{9, 0, 0}) }},
+		{"partial payload", []int{2, 3}, func(t *testing.T, path string) { appendBytes(t, path, []byte{9, 0, 0, 0, 1, 2, 3, 4, '{'}) }},
+		{"bad checksum", []int{2}, func(t *testing.T, path string) {
+			// Corrupt the last byte of the last record
+			data, err := os.ReadFile(path)
+			require.NoError(t, err)
+			data[len(data)-1] ^= 0xff
+			require.NoError(t, os.WriteFile(path, data, 0o644))
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			dg, err := Open(dir, 0)
+			require.NoError(t, err)
+			require.NoError(t, dg.InsertEdge(1, 2))
+			require.NoError(t, dg.InsertEdge(1, 3))
+			require.NoError(t, dg.Close())
+			path := filepath.Join(dir, walFileName)
+			tt.tear(t, path)
+
+			// The torn record is dropped, and records logged after recovery follow the last complete one
+			dg, err = Open(dir, 0)
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, dg.Graph().Neighbors(1))
+			require.NoError(t, dg.InsertEdge(1, 4))
+			require.NoError(t, dg.Close())
+
+			dg, err = Open(dir, 0)
+			require.NoError(t, err)
+			defer dg.Close()
+			assert.Contains(t, dg.Graph().Neighbors(1), 4)
+		})
+	}
+}
+
+func TestDurableGraphSnapshots(t *testing.T) {
+	dir := t.TempDir()
+	dg, err := Open(dir, 3)
+	require.NoError(t, err)
+	require.NoError(t, dg.InsertWeightedEdge(1, 2, 0.5, nil))
+	require.NoError(t, dg.InsertEdge(1, 3))
+	require.NoError(t, dg.DeleteEdge(1, 3)) // snapshots and empties the log
+	assert.Equal(t, 0, dg.walRecords)
+	info, err := os.Stat(filepath.Join(dir, walFileName))
+	require.NoError(t, err)
+	assert.Zero(t, info.Size())
+	require.NoError(t, dg.InsertEdge(2, 1))
+	require.NoError(t, dg.Close())
+	assert.Error(t, dg.SaveSnapshot())
+
+	// The graph is the snapshot with the log replayed over it
+	dg, err = Open(dir, 3)
+	require.NoError(t, err)
+	assert.Equal(t, []int{2}, dg.Graph().Neighbors(1))
+	assert.Equal(t, []int{1}, dg.Graph().Neighbors(2))
+	edge, ok := dg.Graph().Edge(1, 2)
+	require.True(t, ok)
+	assert.Equal(t, 0.5, edge.Weight())
+	assert.Equal(t, 1, dg.walRecords)
+	require.NoError(t, dg.SaveSnapshot())
+	require.NoError(t, dg.Close())
+
+	records := readFileRecords(t, filepath.Join(dir, snapshotFileName))
+	assert.Equal(t, []walRecord{
+		{Op: walInsert, Source: 1, Target: 2, Weight: 0.5},
+		{Op: walInsert, Source: 2, Target: 1, Weight: 1},
+	}, records)
+}
+
+func TestReadRecords(t *testing.T) {
+	var buf bytes.Buffer
+	require.NoError(t, writeRecord(&buf, walRecord{Op: walInsert, Source: 1, Target: 2, Weight: 1}))
+	complete := int64(buf.Len())
+	require.NoError(t, writeRecord(&buf, walRecord{Op: walDelete, Source: 1, Target: 2}))
+
+	var records []walRecord
+	offset, err := readRecords(bytes.NewReader(buf.Bytes()[:buf.Len()-1]), func(record walRecord) error {
+		records = append(records, record)
+		return nil
+	})
+	assert.ErrorIs(t, err, errTornRecord)
+	assert.Equal(t, complete, offset)
+	assert.Len(t, records, 1)
+
+	offset, err = readRecords(bytes.NewReader(buf.Bytes()), func(walRecord) error { return nil })
+	assert.NoError(t, err)
+	assert.Equal(t, int64(buf.Len()), offset)
+}
+
+// appendBytes appends bytes to a file, as a write cut short by a crash would.
+func appendBytes(t *testing.T, path string, data []byte) {
+	t.Helper()
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0)
+	require.NoError(t, err)
+	_, err = f.Write(data)
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+}
+
+// readFileRecords returns the records of a log or snapshot file.
+func readFileRecords(t *testing.T, path string) []walRecord {
+	t.Helper()
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	var records []walRecord
+	_, err = readRecords(bytes.NewReader(data), func(record walRecord) error {
+		records = append(records, record)
+		return nil
+	})
+	require.NoError(t, err)
+	return records
+}
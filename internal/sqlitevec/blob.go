@@ -0,0 +1,70 @@
+// blob.go
+
+package sqlitevec
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+)
+
+// SerializeFloat32 encodes a vector as the little-endian float32 BLOB vec0 float columns take.
+func SerializeFloat32(vector []float32) []byte {
+	blob := make([]byte, len(vector)*4)
+	for i, v := range vector {
+		binary.LittleEndian.PutUint32(blob[i*4:], math.Float32bits(v))
+	}
+	return blob
+}
+
+// DeserializeFloat32 decodes a BLOB encoded by SerializeFloat32.
+func DeserializeFloat32(blob []byte) ([]float32, error) {
+	if len(blob)%4 != 0 {
+		return nil, fmt.Errorf("invalid float32 vector of %d bytes", len(blob))
+	}
+	vector := make([]float32, len(blob)/4)
+	for i := range vector {
+		vector[i] = math.Float32frombits(binary.LittleEndian.Uint32(blob[i*4:]))
+	}
+	return vector, nil
+}
+
+// SerializeFloat64 encodes a vector as a little-endian float64 BLOB, for embeddings stored at full
+// precision in regular tables. vec0 tables take float32 vectors, see Float32.
+func SerializeFloat64(vector []float64) []byte {
+	blob := make([]byte, len(vector)*8)
+	for i, v := range vector {
+		binary.LittleEndian.PutUint64(blob[i*8:], math.Float64bits(v))
+	}
+	return blob
+}
+
+// DeserializeFloat64 decodes a BLOB encoded by SerializeFloat64.
+func DeserializeFloat64(blob []byte) ([]float64, error) {
+	if len(blob)%8 != 0 {
+		return nil, fmt.Errorf("invalid float64 vector of %d bytes", len(blob))
+	}
+	vector := make([]float64, len(blob)/8)
+	for i := range vector {
+		vector[i] = math.Float64frombits(binary.LittleEndian.Uint64(blob[i*8:]))
+	}
+	return vector, nil
+}
+
+// Float32 converts a float64 vector to float32.
+func Float32(vector []float64) []float32 {
+	converted := make([]float32, len(vector))
+	for i, v := range vector {
+		converted[i] = float32(v)
+	}
+	return converted
+}
+
+// Float64 converts a float32 vector to float64.
+func Float64(vector []float32) []float64 {
+	converted := make([]float64, len(vector))
+	for i, v := range vector {
+		converted[i] = float64(v)
+	}
+	return converted
+}
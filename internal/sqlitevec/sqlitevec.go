@@ -0,0 +1,240 @@
+// sqlitevec.go
+
+// Package sqlitevec stores vectors in sqlite-vec vec0 virtual tables and searches them by k nearest
+// neighbors, on database/sql connections of github.com/mattn/go-sqlite3.
+package sqlitevec
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"regexp"
+	"sync"
+
+	sqlite_vec "github.com/asg017/sqlite-vec-go-bindings/cgo"
+)
+
+var registerOnce sync.Once
+
+// Register loads sqlite-vec in every SQLite connection opened afterwards by the process. Connections
+// already open don't have it, so register before opening the database.
+func Register() {
+	registerOnce.Do(sqlite_vec.Auto)
+}
+
+// Execer runs statements: a *sql.DB, *sql.Conn or *sql.Tx.
+type Execer interface {
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+}
+
+// Querier runs queries: a *sql.DB, *sql.Conn or *sql.Tx.
+type Querier interface {
+	QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error)
+}
+
+// Distance metrics of vec0 columns.
+const (
+	L2     = "l2"
+	Cosine = "cosine"
+	L1     = "l1"
+)
+
+var identifier = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// Table is a vec0 table of float32 vectors keyed by rowid, typically the id of the row of a
+// regular table the vector embeds.
+type Table struct {
+	Name       string
+	Column     string // "embedding" when empty
+	Dimensions int
+	Distance   string // L2 when empty
+}
+
+func (t Table) column() string {
+	if t.Column == "" {
+		return "embedding"
+	}
+	return t.Column
+}
+
+// validate checks the names, which are interpolated in the statements, and the dimensions.
+func (t Table) validate() error {
+	if !identifier.MatchString(t.Name) || !identifier.MatchString(t.column()) {
+		return fmt.Errorf("invalid vec0 table or column name %q.%q", t.Name, t.column())
+	}
+	if t.Dimensions <= 0 {
+		return fmt.Errorf("invalid dimensions %d of vec0 table %s", t.Dimensions, t.Name)
+	}
+	switch t.Distance {
+	case "", L2, Cosine, L1:
+		return nil
+	}
+	return fmt.Errorf("invalid distance metric %q of vec0 table %s", t.Distance, t.Name)
+}
+
+// Schema returns the statement creating the table if it doesn't exist.
+func (t Table) Schema() (string, error) {
+	if err := t.validate(); err != nil {
+		return "", err
+	}
+	distance := ""
+	if t.Distance != "" {
+		distance = " distance_metric=" + t.Distance
+	}
+	return fmt.Sprintf("CREATE VIRTUAL TABLE IF NOT EXISTS %s USING vec0(%s float[%d]%s);",
+		t.Name, t.column(), t.Dimensions, distance), nil
+}
+
+// Create creates the table if it doesn't exist.
+func (t Table) Create(ctx context.Context, db Execer) error {
+	schema, err := t.Schema()
+	if err != nil {
+		return err
+	}
+	if _, err := db.ExecContext(ctx, schema); err != nil {
+		return fmt.Errorf("failed to create vec0 table %s: %v", t.Name, err)
+	}
+	return nil
+}
+
+// checkDimensions checks a vector fits the table.
+func (t Table) checkDimensions(vector []float32) error {
+	if len(vector) != t.Dimensions {
+		return fmt.Errorf("vector of %d dimensions for vec0 table %s of %d", len(vector), t.Name, t.Dimensions)
+	}
+	return nil
+}
+
+// Upsert stores the vector of a rowid, replacing the one it had. vec0 tables don't support REPLACE,
+// so the old vector is deleted first: pass a *sql.Tx to do both atomically.
+func (t Table) Upsert(ctx context.Context, db Execer, rowID int64, vector []float32) error {
+	if err := t.validate(); err != nil {
+		return err
+	}
+	if err := t.checkDimensions(vector); err != nil {
+		return err
+	}
+	if err := t.Delete(ctx, db, rowID); err != nil {
+		return err
+	}
+	_, err := db.ExecContext(ctx, fmt.Sprintf("INSERT INTO %s(rowid, %s) VALUES (?, ?);", t.Name, t.column()),
+		rowID, SerializeFloat32(vector))
+	if err != nil {
+		return fmt.Errorf("failed to upsert into %s: %v", t.Name, err)
+	}
+	return nil
+}
+
+// Delete deletes the vector of a rowid.
+func (t Table) Delete(ctx context.Context, db Execer, rowID int64) error {
+	if err := t.validate(); err != nil {
+		return err
+	}
+	if _, err := db.ExecContext(ctx, fmt.Sprintf("DELETE FROM %s WHERE rowid = ?;", t.Name), rowID); err != nil {
+		return fmt.Errorf("failed to delete from %s: %v", t.Name, err)
+	}
+	return nil
+}
+
+// Vector returns the vector of a rowid, false when it has none.
+func (t Table) Vector(ctx context.Context, db Querier, rowID int64) ([]float32, bool, error) {
+	if err := t.validate(); err != nil {
+		return nil, false, err
+	}
+	rows, err := db.QueryContext(ctx, fmt.Sprintf("SELECT %s FROM %s WHERE rowid = ?;", t.column(), t.Name), rowID)
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to read from %s: %v", t.Name, err)
+	}
+	defer rows.Close()
+	if !rows.Next() {
+		return nil, false, rows.Err()
+	}
+	var blob []byte
+	if err := rows.Scan(&blob); err != nil {
+		return nil, false, err
+	}
+	vector, err := DeserializeFloat32(blob)
+	return vector, err == nil, err
+}
+
+// Item is a vector of a rowid.
+type Item struct {
+	RowID  int64
+	Vector []float32
+}
+
+// UpsertBatch stores the vectors of the items in a single transaction, all of them or none.
+func (t Table) UpsertBatch(ctx context.Context, db *sql.DB, items []Item) error {
+	if err := t.validate(); err != nil {
+		return err
+	}
+	for _, item := range items {
+		if err := t.checkDimensions(item.Vector); err != nil {
+			return fmt.Errorf("rowid %d: %v", item.RowID, err)
+		}
+	}
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %v", err)
+	}
+	defer tx.Rollback()
+	deleteStmt, err := tx.PrepareContext(ctx, fmt.Sprintf("DELETE FROM %s WHERE rowid = ?;", t.Name))
+	if err != nil {
+		return fmt.Errorf("failed to prepare delete from %s: %v", t.Name, err)
+	}
+	defer deleteStmt.Close()
+	insertStmt, err := tx.PrepareContext(ctx, fmt.Sprintf("INSERT INTO %s(rowid, %s) VALUES (?, ?);", t.Name, t.column()))
+	if err != nil {
+		return fmt.Errorf("failed to prepare insert into %s: %v", t.Name, err)
+	}
+	defer insertStmt.Close()
+	for _, item := range items {
+		if _, err := deleteStmt.ExecContext(ctx, item.RowID); err != nil {
+			return fmt.Errorf("failed to delete rowid %d from %s: %v", item.RowID, t.Name, err)
+		}
+		if _, err := insertStmt.ExecContext(ctx, item.RowID, SerializeFloat32(item.Vector)); err != nil {
+			return fmt.Errorf("failed to upsert rowid %d into %s: %v", item.RowID, t.Name, err)
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %v", err)
+	}
+	return nil
+}
+
+// Match is a vector of a kNN search, with its distance to the query.
+type Match struct {
+	RowID    int64
+	Distance float64
+}
+
+// Search returns the k vectors nearest to the query, nearest first.
+func (t Table) Search(ctx context.Context, db Querier, query []float32, k int) ([]Match, error) {
+	if err := t.validate(); err != nil {
+		return nil, err
+	}
+	if err := t.checkDimensions(query); err != nil {
+		return nil, err
+	}
+	if k <= 0 {
+		return nil, nil
+	}
+	rows, err := db.QueryContext(ctx, fmt.Sprintf(
+		"SELECT rowid, distance FROM %s WHERE %s MATCH ? AND k = ? ORDER BY distance;", t.Name, t.column()),
+		SerializeFloat32(query), k)
+	if err != nil {
+		return nil, fmt.Errorf("failed to search %s: %v", t.Name, err)
+	}
+	defer rows.Close()
+
+	var matches []Match
+	for rows.Next() {
+		var match Match
+		if err := rows.Scan(&match.RowID, &match.Distance); err != nil {
+			return nil, err
+		}
+		matches = append(matches, match)
+	}
+	return matches, rows.Err()
+}
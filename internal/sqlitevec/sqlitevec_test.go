@@ -0,0 +1,85 @@
+// sqlitevec_test.go
+package sqlitevec
+
+import (
+	"context"
+	"database/sql"
+	"testing"
+
+	_ "github.com/mattn/go-sqlite3"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSerialization(t *testing.T) {
+	vector32 := []float32{1, -0.5, 3.25}
+	decoded32, err := DeserializeFloat32(SerializeFloat32(vector32))
+	require.NoError(t, err)
+	assert.Equal(t, vector32, decoded32)
+
+	vector64 := []float64{1, -0.5, 1e-300}
+	decoded64, err := DeserializeFloat64(SerializeFloat64(vector64))
+	require.NoError(t, err)
+	assert.Equal(t, vector64, decoded64)
+
+	_, err = DeserializeFloat32([]byte{1, 2, 3})
+	assert.Error(t, err)
+	_, err = DeserializeFloat64([]byte{1, 2, 3, 4})
+	assert.Error(t, err)
+	assert.Equal(t, []float32{1, -0.5}, Float32([]float64{1, -0.5}))
+	assert.Equal(t, []float64{1, -0.5}, Float64([]float32{1, -0.5}))
+}
+
+func TestTable(t *testing.T) {
+	Register()
+	db, err := sql.Open("sqlite3", ":memory:")
+	require.NoError(t, err)
+	defer db.Close()
+	db.SetMaxOpenConns(1)
+	ctx := context.Background()
+
+	table := Table{Name: "vec_items", Dimensions: 3}
+	schema, err := table.Schema()
+	require.NoError(t, err)
+	assert.Equal(t, "CREATE VIRTUAL TABLE IF NOT EXISTS vec_items USING vec0(embedding float[3]);", schema)
+	require.NoError(t, table.Create(ctx, db))
+	require.NoError(t, table.Create(ctx, db))
+
+	require.NoError(t, table.UpsertBatch(ctx, db, []Item{
+		{RowID: 1, Vector: []float32{1, 0, 0}},
+		{RowID: 2, Vector: []float32{0, 1, 0}},
+		{RowID: 3, Vector: []float32{0, 0, 1}},
+	}))
+	require.NoError(t, table.Upsert(ctx, db, 2, []float32{0.9, 0.1, 0}))
+	vector, ok, err := table.Vector(ctx, db, 2)
+	require.NoError(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, []float32{0.9, 0.1, 0}, vector)
+	_, ok, err = table.Vector(ctx, db, 9)
+	require.NoError(t, err)
+	assert.False(t, ok)
+
+	matches, err := table.Search(ctx, db, []float32{1, 0, 0}, 2)
+	require.NoError(t, err)
+	require.Len(t, matches, 2)
+	assert.Equal(t, int64(1), matches[0].RowID)
+	assert.Equal(t, int64(2), matches[1].RowID)
+	assert.Less(t, matches[0].Distance, matches[1].Distance)
+
+	require.NoError(t, table.Delete(ctx, db, 1))
+	matches, err = table.Search(ctx, db, []float32{1, 0, 0}, 5)
+	require.NoError(t, err)
+	assert.Len(t, matches, 2)
+
+	// A batch with a vector of the wrong size stores nothing
+	assert.Error(t, table.UpsertBatch(ctx, db, []Item{{RowID: 4, Vector: []float32{1, 0, 0}}, {RowID: 5, Vector: []float32{1}}}))
+	matches, err = table.Search(ctx, db, []float32{1, 0, 0}, 5)
+	require.NoError(t, err)
+	assert.Len(t, matches, 2)
+
+	_, err = Table{Name: "items; DROP TABLE x", Dimensions: 3}.Schema()
+	assert.Error(t, err)
+	schema, err = Table{Name: "docs", Column: "vector", Dimensions: 2, Distance: Cosine}.Schema()
+	require.NoError(t, err)
+	assert.Equal(t, "CREATE VIRTUAL TABLE IF NOT EXISTS docs USING vec0(vector float[2] distance_metric=cosine);", schema)
+}
@@ -0,0 +1,127 @@
+package web
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+	"strings"
+
+	"golang.org/x/net/html"
+)
+
+// FeedItem is an item of an RSS or Atom feed.
+type FeedItem struct {
+	ID      string // the guid or id of the item, its link when it has none
+	Title   string
+	Link    string
+	Content string // the text of the content, or of the summary when the feed has none
+}
+
+// rssFeed and atomFeed are the elements of the feeds ParseFeed reads.
+type rssFeed struct {
+	Items []struct {
+		GUID        string `xml:"guid"`
+		Title       string `xml:"title"`
+		Link        string `xml:"link"`
+		Description string `xml:"description"`
+		Content     string `xml:"http://purl.org/rss/1.0/modules/content/ encoded"`
+	} `xml:"channel>item"`
+}
+
+type atomFeed struct {
+	Entries []struct {
+		ID    string `xml:"id"`
+		Title string `xml:"title"`
+		Links []struct {
+			Href string `xml:"href,attr"`
+			Rel  string `xml:"rel,attr"`
+		} `xml:"link"`
+		Summary string `xml:"summary"`
+		Content string `xml:"content"`
+	} `xml:"entry"`
+}
+
+// ParseFeed parses the items of an RSS 2.0 or Atom feed.
+func ParseFeed(r io.Reader) ([]FeedItem, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read feed: %w", err)
+	}
+	var root struct{ XMLName xml.Name }
+	if err := xml.Unmarshal(data, &root); err != nil {
+		return nil, fmt.Errorf("invalid feed: %w", err)
+	}
+
+	var items []FeedItem
+	switch root.XMLName.Local {
+	case "rss":
+		var feed rssFeed
+		if err := xml.Unmarshal(data, &feed); err != nil {
+			return nil, fmt.Errorf("invalid RSS feed: %w", err)
+		}
+		for _, item := range feed.Items {
+			content := item.Content
+			if strings.TrimSpace(content) == "" {
+				content = item.Description
+			}
+			items = append(items, FeedItem{ID: item.GUID, Title: item.Title, Link: item.Link, Content: content})
+		}
+	case "feed":
+		var feed atomFeed
+		if err := xml.Unmarshal(data, &feed); err != nil {
+			return nil, fmt.Errorf("invalid Atom feed: %w", err)
+		}
+		for _, entry := range feed.Entries {
+			item := FeedItem{ID: entry.ID, Title: entry.Title, Content: entry.Content}
+			for _, link := range entry.Links {
+				if link.Rel == "" || link.Rel == "alternate" {
+					item.Link = link.Href
+					break
+				}
+			}
+			if strings.TrimSpace(item.Content) == "" {
+				item.Content = entry.Summary
+			}
+			items = append(items, item)
+		}
+	default:
+		return nil, fmt.Errorf("unsupported feed format %q", root.XMLName.Local)
+	}
+
+	for i := range items {
+		items[i].ID = strings.TrimSpace(items[i].ID)
+		items[i].Link = strings.TrimSpace(items[i].Link)
+		items[i].Content = htmlText(items[i].Content)
+		if items[i].ID == "" {
+			items[i].ID = items[i].Link
+		}
+	}
+	return items, nil
+}
+
+// htmlText returns the text of an HTML fragment, with a line break after each block element.
+func htmlText(fragment string) string {
+	var sb strings.Builder
+	tokenizer := html.NewTokenizer(strings.NewReader(fragment))
+	for {
+		switch tokenizer.Next() {
+		case html.ErrorToken:
+			lines := strings.Split(sb.String(), "\n")
+			kept := lines[:0]
+			for _, line := range lines {
+				if line = strings.Join(strings.Fields(line), " "); line != "" {
+					kept = append(kept, line)
+				}
+			}
+			return strings.Join(kept, "\n")
+		case html.TextToken:
+			sb.Write(tokenizer.Text())
+		case html.StartTagToken, html.EndTagToken, html.SelfClosingTagToken:
+			name, _ := tokenizer.TagName()
+			switch string(name) {
+			case "p", "br", "div", "li", "h1", "h2", "h3", "h4", "h5", "h6", "tr", "pre", "blockquote":
+				sb.WriteByte('\n')
+			}
+		}
+	}
+}
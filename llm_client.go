@@ -2,14 +2,15 @@ package main
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
-	"fmt"
+	"log/slog"
 	"net/http"
 )
 
 type LLMClient interface {
-	SendCompletionRequest(payload *CompletionRequest) (*http.Response, error)
-	SendEmbeddingRequest(payload *EmbeddingRequest) (*http.Response, error)
+	SendCompletionRequest(ctx context.Context, payload *CompletionRequest) (*http.Response, error)
+	SendEmbeddingRequest(ctx context.Context, payload *EmbeddingRequest) (*http.Response, error)
 	SetModel(model string)
 }
 
@@ -28,7 +29,11 @@ func (client *Client) SetModel(model string) {
 	client.Model = model
 }
 
-func (client *Client) SendEmbeddingRequest(payload *EmbeddingRequest) (*http.Response, error) {
+func (client *Client) SendEmbeddingRequest(ctx context.Context, payload *EmbeddingRequest) (resp *http.Response, err error) {
+	span, ctx := startSpan(ctx, "llm.embedding_request")
+	span.SetTag("inputs", len(payload.Input))
+	defer func() { finishSpan(span, err) }()
+
 	jsonPayload, err := json.Marshal(payload)
 	if err != nil {
 		return nil, err
@@ -36,9 +41,9 @@ func (client *Client) SendEmbeddingRequest(payload *EmbeddingRequest) (*http.Res
 
 	url := "http://localhost:32184/v1/embeddings"
 
-	fmt.Println("Sending embedding request to:", url)
+	slog.Debug("sending embedding request", "url", url, "inputs", len(payload.Input))
 
-	req, err := http.NewRequest("POST", url, bytes.NewBuffer(jsonPayload))
+	req, err := http.NewRequestWithContext(ctx, "POST", url, bytes.NewBuffer(jsonPayload))
 	if err != nil {
 		return nil, err
 	}
@@ -47,6 +52,13 @@ func (client *Client) SendEmbeddingRequest(payload *EmbeddingRequest) (*http.Res
 	if client.APIKey != "" {
 		req.Header.Set("Authorization", "Bearer "+client.APIKey)
 	}
+	injectSpan(ctx, req)
+
+	resp, err = http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	span.SetTag("http.status_code", resp.StatusCode)
 
-	return http.DefaultClient.Do(req)
+	return resp, nil
 }
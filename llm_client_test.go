@@ -2,6 +2,9 @@
 package main
 
 import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -18,3 +21,20 @@ func TestNewLocalLLMClient(t *testing.T) {
 	assert.Equal(t, "test-model", localClient.Model)
 	assert.Equal(t, "test-api-key", localClient.APIKey)
 }
+
+// completionServer is a fake OpenAI chat completions endpoint answering every request with
+// reply(request). A reply error is returned as a 503 with the error as its body.
+func completionServer(t *testing.T, reply func(CompletionRequest) (string, error)) *httptest.Server {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var request CompletionRequest
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&request))
+		content, err := reply(request)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusServiceUnavailable)
+			return
+		}
+		json.NewEncoder(w).Encode(CompletionResponse{Choices: []Choice{{Message: Message{Role: "assistant", Content: content}}}})
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
@@ -0,0 +1,75 @@
+// manifold/logging.go
+
+package main
+
+import (
+	"context"
+	"log/slog"
+	"os"
+	"strings"
+)
+
+// loggerKey is the context key used to carry a request-scoped logger.
+type loggerKey struct{}
+
+// parseLogLevel converts a level name from the config or command line into a slog.Level.
+// Unknown values fall back to info.
+func parseLogLevel(level string) slog.Level {
+	switch strings.ToLower(strings.TrimSpace(level)) {
+	case "debug":
+		return slog.LevelDebug
+	case "warn", "warning":
+		return slog.LevelWarn
+	case "error":
+		return slog.LevelError
+	default:
+		return slog.LevelInfo
+	}
+}
+
+// initLogger configures the default slog logger with the given level and format ("text" or "json").
+// Calls made through the standard log package are routed through the same handler.
+func initLogger(level, format string) *slog.Logger {
+	opts := &slog.HandlerOptions{Level: parseLogLevel(level)}
+
+	var handler slog.Handler
+	if strings.ToLower(format) == "json" {
+		handler = slog.NewJSONHandler(os.Stderr, opts)
+	} else {
+		handler = slog.NewTextHandler(os.Stderr, opts)
+	}
+
+	logger := slog.New(handler)
+	slog.SetDefault(logger)
+	return logger
+}
+
+// withLogger returns a copy of ctx that carries the given logger.
+func withLogger(ctx context.Context, logger *slog.Logger) context.Context {
+	return context.WithValue(ctx, loggerKey{}, logger)
+}
+
+// loggerFromContext returns the request-scoped logger stored in ctx, or the default logger.
+func loggerFromContext(ctx context.Context) *slog.Logger {
+	if ctx != nil {
+		if logger, ok := ctx.Value(loggerKey{}).(*slog.Logger); ok && logger != nil {
+			return logger
+		}
+	}
+	return slog.Default()
+}
+
+// fatal logs an error message with the default logger and exits the process.
+func fatal(msg string, args ...any) {
+	slog.Error(msg, args...)
+	os.Exit(1)
+}
+
+// truncateForLog shortens long strings (prompts, documents, response bodies) so they can be
+// logged at debug level without flooding the output.
+func truncateForLog(s string, max int) string {
+	if len(s) <= max {
+		return s
+	}
+	return s[:max] + "...(truncated)"
+}
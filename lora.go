@@ -0,0 +1,303 @@
+// manifold/lora.go
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/labstack/echo/v4"
+	"gorm.io/gorm"
+)
+
+// LoraAdapter is a LoRA adapter found in the loras directory. Every adapter is loaded into the gguf
+// backend at launch without being applied; attached adapters are applied to every completion.
+type LoraAdapter struct {
+	ID       int64   `gorm:"primaryKey" json:"id"`
+	Name     string  `gorm:"uniqueIndex" json:"name"`
+	Path     string  `json:"path"`
+	Scale    float64 `json:"scale"`
+	Attached bool    `json:"attached"`
+}
+
+// LoraScale applies an adapter loaded by llama-server to a single completion request.
+type LoraScale struct {
+	ID    int     `json:"id"`
+	Scale float64 `json:"scale"`
+}
+
+// loadedLora is an adapter as reported by llama-server's /lora-adapters endpoint.
+type loadedLora struct {
+	ID    int     `json:"id"`
+	Path  string  `json:"path"`
+	Scale float64 `json:"scale"`
+}
+
+// LoraSelection is the LoRA scales by name a chat session selects. It is read from a JSON object, or
+// from a string holding one as sent by the chat form.
+type LoraSelection map[string]float64
+
+func (s *LoraSelection) UnmarshalJSON(data []byte) error {
+	var encoded string
+	if err := json.Unmarshal(data, &encoded); err == nil {
+		if encoded == "" {
+			*s = nil
+			return nil
+		}
+		data = []byte(encoded)
+	}
+	var scales map[string]float64
+	if err := json.Unmarshal(data, &scales); err != nil {
+		return fmt.Errorf("invalid lora selection: %w", err)
+	}
+	*s = scales
+	return nil
+}
+
+// loraScalesKey is the context key carrying the adapters resolved for a chat turn.
+type loraScalesKey struct{}
+
+// turnLoras are the adapters of a turn, resolved against the llama-server of client.
+type turnLoras struct {
+	client LLMClient
+	scales []LoraScale
+}
+
+// withLoraScales returns a copy of ctx applying scales to the completions the turn sends to client,
+// such as those of plan mode and agents.
+func withLoraScales(ctx context.Context, client LLMClient, scales []LoraScale) context.Context {
+	return context.WithValue(ctx, loraScalesKey{}, turnLoras{client: client, scales: scales})
+}
+
+// loraScalesFor returns the adapters of the turn in ctx when sending to client. Adapter IDs are only
+// valid on the server they were resolved against, so other clients get none.
+func loraScalesFor(ctx context.Context, client LLMClient) []LoraScale {
+	turn, ok := ctx.Value(loraScalesKey{}).(turnLoras)
+	if !ok || turn.client != client {
+		return nil
+	}
+	return turn.scales
+}
+
+// ScanLoraAdapters returns the .gguf adapters in the "loras" directory.
+func ScanLoraAdapters(dataPath string) ([]LoraAdapter, error) {
+	entries, err := os.ReadDir(filepath.Join(dataPath, "loras"))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read loras directory: %v", err)
+	}
+
+	var adapters []LoraAdapter
+	for _, entry := range entries {
+		if entry.IsDir() || !strings.HasSuffix(entry.Name(), ".gguf") {
+			continue
+		}
+		adapters = append(adapters, LoraAdapter{
+			Name:  strings.TrimSuffix(entry.Name(), ".gguf"),
+			Path:  filepath.Join(dataPath, "loras", entry.Name()),
+			Scale: 1.0,
+		})
+	}
+	return adapters, nil
+}
+
+// SyncLoraAdapters adds new adapters to the database and removes those no longer on disk.
+func (sqldb *SQLiteDB) SyncLoraAdapters(adapters []LoraAdapter) error {
+	onDisk := make(map[string]bool, len(adapters))
+	for _, adapter := range adapters {
+		onDisk[adapter.Name] = true
+
+		var existing LoraAdapter
+		err := sqldb.db.Where("name = ?", adapter.Name).First(&existing).Error
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			if err := sqldb.db.Create(&adapter).Error; err != nil {
+				slog.Error("failed to insert lora adapter", "lora", adapter.Name, "error", err)
+			}
+		} else if err != nil {
+			slog.Error("error querying lora adapter", "lora", adapter.Name, "error", err)
+		}
+	}
+
+	var dbAdapters []LoraAdapter
+	if err := sqldb.db.Find(&dbAdapters).Error; err != nil {
+		return fmt.Errorf("failed to retrieve lora adapters from DB: %v", err)
+	}
+	for _, adapter := range dbAdapters {
+		if !onDisk[adapter.Name] {
+			if err := sqldb.db.Delete(&adapter).Error; err != nil {
+				slog.Error("failed to delete lora adapter", "lora", adapter.Name, "error", err)
+			}
+		}
+	}
+	return nil
+}
+
+// GetLoraAdapters returns all adapters ordered by name, which is the order they are loaded in.
+func (sqldb *SQLiteDB) GetLoraAdapters() ([]LoraAdapter, error) {
+	var adapters []LoraAdapter
+	if err := sqldb.db.Order("name").Find(&adapters).Error; err != nil {
+		return nil, err
+	}
+	return adapters, nil
+}
+
+// syncLoraAdapters scans the loras directory into the database.
+func syncLoraAdapters(sqldb *SQLiteDB, dataPath string) error {
+	adapters, err := ScanLoraAdapters(dataPath)
+	if err != nil {
+		return err
+	}
+	return sqldb.SyncLoraAdapters(adapters)
+}
+
+// applyLoraOptions loads every known adapter into a gguf launch with a scale of 0, so requests
+// can pick which ones to apply.
+func applyLoraOptions(opts *GGUFOptions) {
+	if db == nil {
+		return
+	}
+	adapters, err := db.GetLoraAdapters()
+	if err != nil {
+		slog.Warn("failed to load lora adapters", "error", err)
+		return
+	}
+	if len(adapters) == 0 {
+		return
+	}
+
+	for _, adapter := range adapters {
+		opts.Model.Lora = append(opts.Model.Lora, adapter.Path)
+	}
+	opts.Model.LoraInitWithoutApply = true
+}
+
+// fetchLoadedLoras returns the adapters loaded by the llama-server behind client.
+func fetchLoadedLoras(ctx context.Context, client LLMClient) ([]loadedLora, error) {
+	c, ok := client.(*Client)
+	if !ok {
+		return nil, errors.New("backend does not support lora adapters")
+	}
+
+	url := strings.TrimSuffix(c.BaseURL, "/v1") + "/lora-adapters"
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("lora adapters request failed with status %d", resp.StatusCode)
+	}
+
+	var loaded []loadedLora
+	if err := json.NewDecoder(resp.Body).Decode(&loaded); err != nil {
+		return nil, err
+	}
+	return loaded, nil
+}
+
+// loraScales resolves the adapters to apply to a request: attached adapters at their scale,
+// overridden by the session's adapters by name. A scale of 0 detaches an adapter for the session.
+// It returns nil when no adapter applies, leaving the request unchanged.
+func loraScales(ctx context.Context, client LLMClient, session map[string]float64) ([]LoraScale, error) {
+	if db == nil {
+		return nil, nil
+	}
+	adapters, err := db.GetLoraAdapters()
+	if err != nil || len(adapters) == 0 {
+		return nil, err
+	}
+
+	scales := make(map[string]float64)
+	for _, adapter := range adapters {
+		if adapter.Attached {
+			scales[adapter.Path] = adapter.Scale
+		}
+	}
+	for name, scale := range session {
+		for _, adapter := range adapters {
+			if adapter.Name == name {
+				scales[adapter.Path] = scale
+			}
+		}
+	}
+	if len(scales) == 0 {
+		return nil, nil
+	}
+
+	loaded, err := fetchLoadedLoras(ctx, client)
+	if err != nil {
+		return nil, err
+	}
+
+	var result []LoraScale
+	for _, l := range loaded {
+		if scale, ok := scales[l.Path]; ok && scale != 0 {
+			result = append(result, LoraScale{ID: l.ID, Scale: scale})
+		}
+	}
+	return result, nil
+}
+
+// handleGetLoras lists the LoRA adapters.
+func handleGetLoras(c echo.Context) error {
+	adapters, err := db.GetLoraAdapters()
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to load lora adapters"})
+	}
+	return c.JSON(http.StatusOK, adapters)
+}
+
+// handleScanLoras rescans the loras directory. New adapters are loaded the next time the model starts.
+func handleScanLoras(c echo.Context, config *Config) error {
+	if err := syncLoraAdapters(db, config.DataPath); err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": err.Error()})
+	}
+	return handleGetLoras(c)
+}
+
+// handleAttachLora applies an adapter to every completion at the given scale.
+func handleAttachLora(c echo.Context) error {
+	var req struct {
+		Scale *float64 `json:"scale"`
+	}
+	if err := c.Bind(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid request body"})
+	}
+
+	updates := map[string]interface{}{"attached": true}
+	if req.Scale != nil {
+		updates["scale"] = *req.Scale
+	}
+	return updateLora(c, updates)
+}
+
+// handleDetachLora stops applying an adapter by default.
+func handleDetachLora(c echo.Context) error {
+	return updateLora(c, map[string]interface{}{"attached": false})
+}
+
+// updateLora applies updates to the adapter named in the route.
+func updateLora(c echo.Context, updates map[string]interface{}) error {
+	result := db.db.Model(&LoraAdapter{}).Where("name = ?", c.Param("name")).Updates(updates)
+	if result.Error != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to update lora adapter"})
+	}
+	if result.RowsAffected == 0 {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "Lora adapter not found"})
+	}
+	return c.JSON(http.StatusOK, map[string]string{"status": "success", "lora": c.Param("name")})
+}
@@ -0,0 +1,213 @@
+// lora_test.go
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// useLoraDB gives a test its own database of LoRA adapters scanned from dataPath/loras.
+func useLoraDB(t *testing.T, dataPath string, names ...string) {
+	testDB, err := NewSQLiteDB(t.TempDir())
+	require.NoError(t, err)
+	require.NoError(t, testDB.AutoMigrate(&LoraAdapter{}))
+	saved := db
+	db = testDB
+	t.Cleanup(func() { db = saved })
+
+	require.NoError(t, os.MkdirAll(filepath.Join(dataPath, "loras"), 0755))
+	for _, name := range names {
+		require.NoError(t, os.WriteFile(filepath.Join(dataPath, "loras", name), nil, 0644))
+	}
+	require.NoError(t, syncLoraAdapters(db, dataPath))
+}
+
+// newLoraServer starts a llama-server that loaded the adapters of paths in order, recording the
+// adapters of the completions it receives. It returns the client of its OpenAI API.
+func newLoraServer(t *testing.T, paths []string, applied *[]LoraScale) LLMClient {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/lora-adapters":
+			loaded := make([]loadedLora, len(paths))
+			for i, path := range paths {
+				loaded[i] = loadedLora{ID: i, Path: path}
+			}
+			json.NewEncoder(w).Encode(loaded)
+		case "/v1/chat/completions":
+			var request CompletionRequest
+			require.NoError(t, json.NewDecoder(r.Body).Decode(&request))
+			*applied = request.Lora
+			json.NewEncoder(w).Encode(CompletionResponse{Choices: []Choice{{Message: Message{Role: "assistant", Content: "ok"}}}})
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	t.Cleanup(server.Close)
+	return NewLocalLLMClient(server.URL+"/v1", "", "")
+}
+
+func TestLoraAdaptersLoadAndUnload(t *testing.T) {
+	dataPath := t.TempDir()
+	useLoraDB(t, dataPath, "style.gguf", "code.gguf", "notes.txt")
+
+	adapters, err := db.GetLoraAdapters()
+	require.NoError(t, err)
+	require.Len(t, adapters, 2)
+	assert.Equal(t, "code", adapters[0].Name)
+	assert.Equal(t, 1.0, adapters[0].Scale)
+	assert.False(t, adapters[0].Attached)
+
+	// Every adapter is loaded at launch without being applied
+	var opts GGUFOptions
+	applyLoraOptions(&opts)
+	assert.Equal(t, []string{filepath.Join(dataPath, "loras", "code.gguf"), filepath.Join(dataPath, "loras", "style.gguf")}, opts.Model.Lora)
+	assert.True(t, opts.Model.LoraInitWithoutApply)
+
+	// Adapters removed from disk are dropped on the next scan
+	require.NoError(t, os.Remove(filepath.Join(dataPath, "loras", "code.gguf")))
+	require.NoError(t, syncLoraAdapters(db, dataPath))
+	adapters, err = db.GetLoraAdapters()
+	require.NoError(t, err)
+	require.Len(t, adapters, 1)
+	assert.Equal(t, "style", adapters[0].Name)
+
+	opts = GGUFOptions{}
+	require.NoError(t, os.Remove(filepath.Join(dataPath, "loras", "style.gguf")))
+	require.NoError(t, syncLoraAdapters(db, dataPath))
+	applyLoraOptions(&opts)
+	assert.Empty(t, opts.Model.Lora)
+	assert.False(t, opts.Model.LoraInitWithoutApply)
+}
+
+func TestLoraAttachDetach(t *testing.T) {
+	useLoraDB(t, t.TempDir(), "style.gguf")
+
+	update := func(action, name, body string) int {
+		e := echo.New()
+		rec := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodPost, "/v1/loras/"+name+"/"+action, strings.NewReader(body))
+		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		c := e.NewContext(req, rec)
+		c.SetParamNames("name")
+		c.SetParamValues(name)
+		if action == "attach" {
+			require.NoError(t, handleAttachLora(c))
+		} else {
+			require.NoError(t, handleDetachLora(c))
+		}
+		return rec.Code
+	}
+	adapter := func() LoraAdapter {
+		adapters, err := db.GetLoraAdapters()
+		require.NoError(t, err)
+		return adapters[0]
+	}
+
+	assert.Equal(t, http.StatusOK, update("attach", "style", `{"scale": 0.5}`))
+	assert.True(t, adapter().Attached)
+	assert.Equal(t, 0.5, adapter().Scale)
+
+	assert.Equal(t, http.StatusOK, update("detach", "style", ""))
+	assert.False(t, adapter().Attached)
+	assert.Equal(t, 0.5, adapter().Scale, "the scale is kept for the next attach")
+
+	assert.Equal(t, http.StatusNotFound, update("attach", "missing", "{}"))
+}
+
+func TestLoraScales(t *testing.T) {
+	dataPath := t.TempDir()
+	useLoraDB(t, dataPath, "code.gguf", "style.gguf")
+	require.NoError(t, db.db.Model(&LoraAdapter{}).Where("name = ?", "style").Updates(map[string]interface{}{"attached": true, "scale": 0.8}).Error)
+
+	var applied []LoraScale
+	client := newLoraServer(t, []string{filepath.Join(dataPath, "loras", "code.gguf"), filepath.Join(dataPath, "loras", "style.gguf")}, &applied)
+
+	tests := []struct {
+		name    string
+		session LoraSelection
+		want    []LoraScale
+	}{
+		{"attached", nil, []LoraScale{{ID: 1, Scale: 0.8}}},
+		{"session adds", LoraSelection{"code": 0.3}, []LoraScale{{ID: 0, Scale: 0.3}, {ID: 1, Scale: 0.8}}},
+		{"session overrides", LoraSelection{"style": 0.2}, []LoraScale{{ID: 1, Scale: 0.2}}},
+		{"session detaches", LoraSelection{"style": 0}, nil},
+		{"unknown adapter", LoraSelection{"missing": 1}, []LoraScale{{ID: 1, Scale: 0.8}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			scales, err := loraScales(context.Background(), client, tt.session)
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, scales)
+		})
+	}
+}
+
+func TestCompletionAppliesTurnLoras(t *testing.T) {
+	var applied []LoraScale
+	client := newLoraServer(t, nil, &applied)
+	scales := []LoraScale{{ID: 1, Scale: 0.5}}
+	ctx := withLoraScales(context.Background(), client, scales)
+
+	send := func(ctx context.Context, client LLMClient, payload *CompletionRequest) {
+		resp, err := client.SendCompletionRequest(ctx, payload)
+		require.NoError(t, err)
+		resp.Body.Close()
+	}
+
+	// Completions of the turn, such as plan mode's, apply its adapters
+	send(ctx, client, &CompletionRequest{})
+	assert.Equal(t, scales, applied)
+
+	// Unless they choose their own
+	send(ctx, client, &CompletionRequest{Lora: []LoraScale{{ID: 0, Scale: 1}}})
+	assert.Equal(t, []LoraScale{{ID: 0, Scale: 1}}, applied)
+
+	// Adapter IDs of another server do not apply
+	var otherApplied []LoraScale
+	other := newLoraServer(t, nil, &otherApplied)
+	send(ctx, other, &CompletionRequest{})
+	assert.Empty(t, otherApplied)
+}
+
+func TestLoraSelectionUnmarshal(t *testing.T) {
+	tests := []struct {
+		data    string
+		want    LoraSelection
+		wantErr bool
+	}{
+		{`{"loras": {"style": 0.5}}`, LoraSelection{"style": 0.5}, false},
+		{`{"loras": "{\"style\": 0.5}"}`, LoraSelection{"style": 0.5}, false},
+		{`{"loras": ""}`, nil, false},
+		{`{}`, nil, false},
+		{`{"loras": "style"}`, nil, true},
+	}
+	for _, tt := range tests {
+		var message WebSocketMessage
+		err := json.Unmarshal([]byte(tt.data), &message)
+		if tt.wantErr {
+			assert.Error(t, err, tt.data)
+			continue
+		}
+		require.NoError(t, err, tt.data)
+		assert.Equal(t, tt.want, message.Loras, tt.data)
+	}
+}
+
+func TestChatSubmitRejectsInvalidLoras(t *testing.T) {
+	form := "userprompt=hello&loras=style"
+	req := httptest.NewRequest(http.MethodPost, "/v1/chat/submit", strings.NewReader(form))
+	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationForm)
+	rec := httptest.NewRecorder()
+	require.NoError(t, handleChatSubmit(echo.New().NewContext(req, rec)))
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+}
@@ -6,8 +6,10 @@ import (
 	"context"
 	"flag"
 	"fmt"
-	"log"
+	"log/slog"
 	"manifold/internal/documents"
+	"manifold/internal/ingest"
+	"net/http"
 	"os"
 	"os/signal"
 	"syscall"
@@ -26,14 +28,18 @@ var (
 	//searchIndex        bleve.Index
 	indexManager *documents.IndexManager
 	docManager   *documents.DocumentManager
+	docChunker   ingest.Chunker // splits documents, the splitter of docManager when nil
+	retriever    Retriever      // searched by the retrieval tool and /v1/documents/query
 	db           *SQLiteDB
 )
 
 func main() {
 	// Define the verbose logging flag
 	var verbose bool
+	var logLevel string
 
 	flag.BoolVar(&verbose, "verbose", false, "Enable verbose output")
+	flag.StringVar(&logLevel, "log-level", "", "Log level (debug, info, warn, error); overrides log_level in config")
 	flag.Parse()
 
 	// Get the host information
@@ -43,24 +49,30 @@ func main() {
 	// Load the configuration
 	config, err := LoadConfig("config.yml")
 	if err != nil {
-		log.Fatal(err)
+		fatal("failed to load config", "error", err)
 	}
 
+	// Configure structured logging, the command line flag takes precedence over the config
+	if logLevel == "" {
+		logLevel = config.LogLevel
+	}
+	initLogger(logLevel, config.LogFormat)
+
 	// Print the config.services with their index and name
 	for i, service := range config.Services {
-		log.Printf("Service %d: %s", i, service.Name)
+		slog.Debug("configured service", "index", i, "service", service.Name)
 	}
 
 	// Initialize the application
 	db, err = initializeApplication(config)
 	if err != nil {
-		log.Fatal(err)
+		fatal("failed to initialize application", "error", err)
 	}
 
 	// Get the list of models from the database
 	models, err := db.GetModels()
 	if err != nil {
-		log.Fatal("Failed to load models:", err)
+		fatal("failed to load models", "error", err)
 	}
 
 	config.LanguageModels = models
@@ -73,8 +85,14 @@ func main() {
 	e.Use(middleware.Logger())
 	e.Use(middleware.Recover())
 
-	// CORS default - For dev only
-	e.Use(middleware.CORS())
+	// CORS allows every origin unless auth.cors_origins is set
+	e.Use(corsMiddleware(&config.Auth))
+	upgrader.CheckOrigin = func(r *http.Request) bool {
+		return originAllowed(&config.Auth, r.Header.Get("Origin"))
+	}
+
+	// API key or session cookie authentication, a no-op unless auth.enabled is set
+	e.Use(authMiddleware(&config.Auth))
 
 	// Enable tracing middleware
 	c := jaegertracing.New(e, nil)
@@ -85,34 +103,65 @@ func main() {
 
 	// Initialize WorkflowManager
 	wm := &WorkflowManager{}
+	if config.ToolRouter.Enabled {
+		router, err := NewToolRouter(config.ToolRouter)
+		if err != nil {
+			fatal("invalid tool router configuration", "error", err)
+		}
+		wm.SetRouter(router)
+	}
+	if config.Planner.Enabled {
+		wm.SetPlanner(NewPlanner(config.Planner))
+	}
 
 	// Set as global instance
 	SetGlobalWorkflowManager(wm)
 
+	// Plugins must be known before they can be created by name
+	if err := registerPlugins(config); err != nil {
+		fatal("invalid plugin configuration", "error", err)
+	}
+	configureToolPolicies(config)
+	if err := configurePII(config); err != nil {
+		fatal("invalid pii configuration", "error", err)
+	}
+	if err := configureGuardrails(config); err != nil {
+		fatal("invalid guardrails configuration", "error", err)
+	}
+	if err := configureAgentTeam(config); err != nil {
+		fatal("invalid agents configuration", "error", err)
+	}
+	if err := configureComparison(config); err != nil {
+		fatal("invalid compare configuration", "error", err)
+	}
+
 	// Register the enabled tools
-	for _, toolConfig := range config.Tools {
-		params, _ := db.GetToolMetadataByName(toolConfig.Name)
+	for _, toolName := range configuredToolNames(config) {
+		params, _ := db.GetToolMetadataByName(toolName)
 
 		if params.Enabled {
 			// Create the tool
-			tool, err := CreateToolByName(toolConfig.Name)
+			tool, err := CreateToolByName(toolName)
 			if err != nil {
-				log.Printf("Failed to create tool '%s': %v", toolConfig.Name, err)
+				slog.Error("failed to create tool", "tool", toolName, "error", err)
+				continue
+			}
+			if err := configureTool(tool, toolName, config); err != nil {
+				slog.Error("failed to configure tool", "tool", toolName, "error", err)
 				continue
 			}
 
 			// Add the tool to the WorkflowManager
-			err = wm.AddTool(tool, toolConfig.Name)
+			err = wm.AddTool(tool, toolName)
 			if err != nil {
-				log.Printf("Failed to add tool '%s' to WorkflowManager: %v", toolConfig.Name, err)
+				slog.Error("failed to add tool to WorkflowManager", "tool", toolName, "error", err)
 			}
 		}
 	}
 
 	// Get the list of tools from the WorkflowManager
 	tools := wm.ListTools()
-	fmt.Println("Registered Tools:")
-	fmt.Println(tools)
+	slog.Info("registered tools", "tools", tools)
 
 	var embeddingsService *ExternalService
 	var embeddingsCtx context.Context
@@ -121,8 +170,7 @@ func main() {
 	embeddingsConfig := config.Services[4]
 
 	// Print the embeddings service configuration
-	log.Println("Embeddings service configuration:")
-	log.Println(embeddingsConfig)
+	slog.Debug("embeddings service configuration", "service", embeddingsConfig.Name, "host", embeddingsConfig.Host, "port", embeddingsConfig.Port)
 
 	embeddingsService = NewExternalService(embeddingsConfig, true)
 
@@ -133,77 +181,76 @@ func main() {
 	if err := embeddingsService.Start(embeddingsCtx); err != nil {
 		e.Logger.Fatal(err)
 	}
+	supervisor.Register("embeddings", embeddingsService)
 
 	switch config.LLMBackend {
-	case "gguf":
-		config.Services[1].Args = []string{
-			"--model",
-			config.SelectedModels.ModelPath,
-			"--port",
-			"32182",
-			"--host",
-			"0.0.0.0",
-			"--gpu-layers",
-			"99",
-		}
-		llmService := config.Services[1]
-		completionsService = NewExternalService(llmService, verbose)
-		completionsCtx, cancel = context.WithCancel(context.Background())
+	case "gguf", "mlx":
+		slog.Info("selected model", "model", config.SelectedModels.ModelName, "path", config.SelectedModels.ModelPath)
 
-		if err := completionsService.Start(completionsCtx); err != nil {
-			e.Logger.Fatal(err)
+		index := 1
+		if config.LLMBackend == "mlx" {
+			index = 2
 		}
 
-		// Construct the base URL from Host and Port
-		baseURL := fmt.Sprintf("http://%s:%d/v1", llmService.Host, llmService.Port)
-		llmClient = NewLocalLLMClient(baseURL, "", "")
-
-	case "mlx":
-		// Get the path to the folder containing the model
-		mlxModelPath := fmt.Sprintf("%s/models-mlx/%s", config.DataPath, config.SelectedModels.ModelName)
-
-		// Print the selected model path
-		log.Println("Selected model path:", mlxModelPath)
-
-		config.Services[2].Args = []string{
-			"--model",
-			mlxModelPath,
-			"--port",
-			"32182",
-			"--host",
-			"0.0.0.0",
-			"--log-level",
-			"DEBUG",
+		llmService, err := completionsServiceConfig(config, config.SelectedModels.ModelName, config.SelectedModels.ModelPath, config.Services[index].Port)
+		if err != nil {
+			fatal("failed to configure completions service", "error", err)
 		}
-		llmService := config.Services[2]
+		config.Services[index].Args = llmService.Args
+
 		completionsService = NewExternalService(llmService, verbose)
 		completionsCtx, cancel = context.WithCancel(context.Background())
 
 		if err := completionsService.Start(completionsCtx); err != nil {
 			e.Logger.Fatal(err)
 		}
+		supervisor.Register("completions", completionsService)
 
 		// Construct the base URL from Host and Port
 		baseURL := fmt.Sprintf("http://%s:%d/v1", llmService.Host, llmService.Port)
 		llmClient = NewLocalLLMClient(baseURL, "", "")
+		backendReadiness.Watch(baseURL, time.Duration(config.Readiness.TimeoutSeconds)*time.Second)
 
 	case "openai":
 		completionsCtx, cancel = context.WithCancel(context.Background())
 		if config.OpenAIAPIKey == "" {
-			log.Fatal("OpenAI API key is not set in config")
+			fatal("OpenAI API key is not set in config")
 		}
 		llmClient = NewLocalLLMClient("https://api.openai.com/v1", "gpt-4o-mini", config.OpenAIAPIKey)
+		backendReadiness.MarkReady()
 	case "gemini":
 		completionsCtx, cancel = context.WithCancel(context.Background())
 		if config.GoogleAPIKey == "" {
-			log.Fatal("Google API key is not set in config")
+			fatal("Google API key is not set in config")
 		}
 		llmClient = NewLocalLLMClient("https://generativelanguage.googleapis.com/v1beta/openai", "gemini-2.0-flash-exp", config.GoogleAPIKey)
+		backendReadiness.MarkReady()
 
 	default:
-		log.Fatal("Invalid LLMBackend specified in config")
+		fatal("invalid llm_backend specified in config", "backend", config.LLMBackend)
 	}
 
+	// Unload the selected model when nobody is chatting and reload it on the next request
+	idleMonitor.Configure(config, verbose)
+	slotManager.Configure(config)
+	go idleMonitor.Run(embeddingsCtx)
+
+	// Start the local text to speech and speech to text servers if configured
+	startTTSService(embeddingsCtx, config, verbose)
+	startSTTService(embeddingsCtx, config, verbose)
+	// ComfyUI may have to be installed first, /v1/images/status reports when it is ready
+	go startComfyUIService(embeddingsCtx, config, verbose)
+
+	// Start the models listed in model_pool.preload next to the selected model
+	go preloadModelPool(config, verbose)
+
+	// Watch the local services and restart them if they crash
+	supervisor.Configure(config.Supervisor)
+	go supervisor.Run(embeddingsCtx)
+
+	// Re-ingest the tracked sources on their schedules
+	go sourceScheduler.Run(embeddingsCtx)
+
 	// Set up graceful shutdown
 	go func() {
 		quit := make(chan os.Signal, 1)
@@ -215,7 +262,8 @@ func main() {
 			cancel()
 		}
 
-		// Stop the completions service first
+		// Stop the pooled models and the completions service first
+		modelPool.UnloadAll()
 		if completionsService != nil {
 			ctx, cancelTimeout := context.WithTimeout(context.Background(), 10*time.Second)
 			defer cancelTimeout()
@@ -235,89 +283,44 @@ func main() {
 	e.Logger.Info(e.Start(fmt.Sprintf(":%d", config.Services[0].Port)))
 }
 
-// function to restart completions service with new model
-func restartCompletionsService(config *Config, verbose bool) {
-	if completionsService != nil {
-		ctx, cancelTimeout := context.WithTimeout(context.Background(), 10*time.Second)
-		defer cancelTimeout()
-		if err := completionsService.Stop(ctx); err != nil {
-			log.Println(err)
-		}
-	}
+// restartCompletionsService switches the completions backend to the selected model. Local backends are
+// swapped blue/green so in-flight requests are not dropped, see warmSwap.
+func restartCompletionsService(config *Config, verbose bool) error {
+	swapMu.Lock()
+	defer swapMu.Unlock()
 
-	// Start completions service with new model
 	switch config.LLMBackend {
-	case "gguf":
-		log.Println("Selected model path:", config.SelectedModels.ModelPath)
-		config.Services[1].Args = []string{
-			"--model",
-			config.SelectedModels.ModelPath,
-			"--port",
-			"32182",
-			"--host",
-			"0.0.0.0",
-			"--gpu-layers",
-			"99",
-			"--ctx-size",
-			"128000",
-		}
-		llmService := config.Services[1]
-		completionsService = NewExternalService(llmService, verbose)
-		completionsCtx, cancel = context.WithCancel(context.Background())
-
-		if err := completionsService.Start(completionsCtx); err != nil {
-			log.Fatal(err)
-		}
-
-		// Construct the base URL from Host and Port
-		baseURL := fmt.Sprintf("http://%s:%d/v1", llmService.Host, llmService.Port)
-		llmClient = NewLocalLLMClient(baseURL, "", "")
+	case "gguf", "mlx":
+		slog.Info("selected model", "model", config.SelectedModels.ModelName, "path", config.SelectedModels.ModelPath)
 
-	case "mlx":
-		// Get the path to the folder containing the model
-		mlxModelPath := fmt.Sprintf("%s/models-mlx/%s", config.DataPath, config.SelectedModels.ModelName)
-
-		// Print the selected model path
-		log.Println("Selected model path:", mlxModelPath)
-
-		config.Services[2].Args = []string{
-			"--model",
-			mlxModelPath,
-			"--port",
-			"32182",
-			"--host",
-			"0.0.0.0",
-			"--log-level",
-			"DEBUG",
+		index := 1
+		if config.LLMBackend == "mlx" {
+			index = 2
 		}
 
-		llmService := config.Services[2]
-		llmService.Model = config.SelectedModels.ModelPath
-		completionsService = NewExternalService(llmService, verbose)
-		completionsCtx, cancel = context.WithCancel(context.Background())
-
-		if err := completionsService.Start(completionsCtx); err != nil {
-			log.Fatal(err)
+		llmService, err := completionsServiceConfig(config, config.SelectedModels.ModelName, config.SelectedModels.ModelPath, alternatePort(config.Services[index]))
+		if err != nil {
+			return err
 		}
+		config.Services[index].Args = llmService.Args
 
-		// Construct the base URL from Host and Port
-		baseURL := fmt.Sprintf("http://%s:%d/v1", llmService.Host, llmService.Port)
-		llmClient = NewLocalLLMClient(baseURL, "", "")
+		return warmSwap(llmService, config, verbose)
 
 	case "openai":
-		completionsCtx, cancel = context.WithCancel(context.Background())
 		if config.OpenAIAPIKey == "" {
-			log.Fatal("OpenAI API key is not set in config")
+			return fmt.Errorf("OpenAI API key is not set in config")
 		}
-		llmClient = NewLocalLLMClient("https://api.openai.com/v1", "gpt-4o-mini", config.OpenAIAPIKey)
+		installRemoteBackend(NewLocalLLMClient("https://api.openai.com/v1", "gpt-4o-mini", config.OpenAIAPIKey))
+
 	case "gemini":
-		completionsCtx, cancel = context.WithCancel(context.Background())
 		if config.GoogleAPIKey == "" {
-			log.Fatal("Google API key is not set in config")
+			return fmt.Errorf("Google API key is not set in config")
 		}
-		llmClient = NewLocalLLMClient("https://generativelanguage.googleapis.com/v1beta/openai", "gemini-2.0-flash-exp", config.GoogleAPIKey)
+		installRemoteBackend(NewLocalLLMClient("https://generativelanguage.googleapis.com/v1beta/openai", "gemini-2.0-flash-exp", config.GoogleAPIKey))
 
 	default:
-		log.Fatal("Invalid LLMBackend specified in config")
+		return fmt.Errorf("invalid llm_backend specified in config: %s", config.LLMBackend)
 	}
+
+	return nil
 }
@@ -0,0 +1,368 @@
+// manifold/modelpool.go
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+const (
+	defaultPoolBasePort  = 32190
+	defaultPoolMaxModels = 2
+)
+
+// poolEntry is a model running in its own backend process alongside the selected model.
+type poolEntry struct {
+	name    string
+	port    int
+	service *ExternalService
+	client  LLMClient
+	cancel  context.CancelFunc
+	started time.Time
+}
+
+// PoolModelStatus describes a pooled model as returned by /v1/models/pool.
+type PoolModelStatus struct {
+	Name    string    `json:"name"`
+	Port    int       `json:"port"`
+	PID     int       `json:"pid"`
+	Started time.Time `json:"started"`
+}
+
+// ModelPool runs additional gguf/mlx models concurrently, each on its own port, and routes
+// completions to them by model name. Models not in the pool are served by the selected model.
+type ModelPool struct {
+	mu      sync.RWMutex
+	entries map[string]*poolEntry
+	loading map[string]bool
+}
+
+var modelPool = NewModelPool()
+
+// NewModelPool creates an empty model pool.
+func NewModelPool() *ModelPool {
+	return &ModelPool{
+		entries: make(map[string]*poolEntry),
+		loading: make(map[string]bool),
+	}
+}
+
+// completionsServiceConfig builds the service config that runs a model on the configured local backend at port.
+func completionsServiceConfig(config *Config, modelName, modelPath string, port int) (ServiceConfig, error) {
+	switch config.LLMBackend {
+	case "gguf":
+		opts, err := ParseGGUFOptions(config.Llama)
+		if err != nil {
+			return ServiceConfig{}, err
+		}
+
+		// The model, host and port are always managed by manifold
+		host := "0.0.0.0"
+		opts.Model.Model = &modelPath
+		opts.Server.Host = &host
+		opts.Server.Port = &port
+
+		// Load the LoRA adapters so requests can apply them
+		applyLoraOptions(opts)
+
+		// Serve llava-style vision models with the projector shipped next to them
+		if opts.Model.Mmproj == nil {
+			if mmproj := findMmproj(modelPath); mmproj != "" {
+				opts.Model.Mmproj = &mmproj
+			}
+		}
+
+		// Size gpu-layers and ctx-size for this host unless the llama section sets them
+		if opts.Model.GpuLayers == nil || opts.General.CtxSize == nil {
+			tuning := tuneLlamaForModel(modelPath, config.GPUMemoryGB)
+			if opts.Model.GpuLayers == nil {
+				opts.Model.GpuLayers = &tuning.GPULayers
+			}
+			if opts.General.CtxSize == nil {
+				opts.General.CtxSize = &tuning.CtxSize
+			}
+		}
+
+		if err := applySpeculativeOptions(config, opts); err != nil {
+			return ServiceConfig{}, err
+		}
+		if err := applySlotOptions(config, opts); err != nil {
+			return ServiceConfig{}, err
+		}
+
+		service := config.Services[1]
+		service.Port = port
+		service.Model = modelPath
+		service.Args = opts.Args()
+		return service, nil
+
+	case "mlx":
+		service := config.Services[2]
+		service.Port = port
+		service.Model = modelPath
+		service.Args = []string{
+			"--model",
+			fmt.Sprintf("%s/models-mlx/%s", config.DataPath, modelName),
+			"--port",
+			strconv.Itoa(port),
+			"--host",
+			"0.0.0.0",
+			"--log-level",
+			"DEBUG",
+		}
+		return service, nil
+
+	default:
+		return ServiceConfig{}, fmt.Errorf("backend %s does not run local models", config.LLMBackend)
+	}
+}
+
+// nextPort returns the first pool port not used by a running or loading model. Callers must hold p.mu.
+func (p *ModelPool) nextPort(config *Config) int {
+	port := config.ModelPool.BasePort
+	if port == 0 {
+		port = defaultPoolBasePort
+	}
+
+	used := make(map[int]bool, len(p.entries))
+	for _, entry := range p.entries {
+		used[entry.port] = true
+	}
+	for used[port] {
+		port++
+	}
+	return port
+}
+
+// Load starts model in its own backend process and adds it to the pool once it is ready.
+func (p *ModelPool) Load(config *Config, model LanguageModel, verbose bool) error {
+	maxModels := config.ModelPool.MaxModels
+	if maxModels == 0 {
+		maxModels = defaultPoolMaxModels
+	}
+
+	p.mu.Lock()
+	if _, ok := p.entries[model.Name]; ok || p.loading[model.Name] {
+		p.mu.Unlock()
+		return fmt.Errorf("model %s is already loaded", model.Name)
+	}
+	if len(p.entries)+len(p.loading) >= maxModels {
+		p.mu.Unlock()
+		return fmt.Errorf("model pool is full (%d models)", maxModels)
+	}
+	// Reserve the port with a placeholder so concurrent loads pick different ports
+	port := p.nextPort(config)
+	p.entries[model.Name] = &poolEntry{name: model.Name, port: port}
+	p.loading[model.Name] = true
+	p.mu.Unlock()
+
+	entry, err := p.start(config, model, port, verbose)
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	delete(p.loading, model.Name)
+	if err != nil {
+		delete(p.entries, model.Name)
+		return err
+	}
+	p.entries[model.Name] = entry
+	supervisor.Register("pool:"+model.Name, entry.service)
+
+	slog.Info("model added to pool", "model", model.Name, "port", port)
+	return nil
+}
+
+// start launches the backend process for a pooled model and waits until it answers.
+func (p *ModelPool) start(config *Config, model LanguageModel, port int, verbose bool) (*poolEntry, error) {
+	serviceConfig, err := completionsServiceConfig(config, model.Name, model.Path, port)
+	if err != nil {
+		return nil, err
+	}
+	serviceConfig.Name = fmt.Sprintf("%s:%s", serviceConfig.Name, model.Name)
+
+	service := NewExternalService(serviceConfig, verbose)
+	ctx, cancelFn := context.WithCancel(context.Background())
+	if err := service.Start(ctx); err != nil {
+		cancelFn()
+		return nil, err
+	}
+
+	timeout := time.Duration(config.Readiness.TimeoutSeconds) * time.Second
+	if timeout <= 0 {
+		timeout = defaultReadinessTimeout
+	}
+	waitCtx, waitCancel := context.WithTimeout(ctx, timeout)
+	defer waitCancel()
+
+	baseURL := fmt.Sprintf("http://%s:%d/v1", serviceConfig.Host, port)
+	if err := waitForBackend(waitCtx, baseURL); err != nil {
+		cancelFn()
+		return nil, fmt.Errorf("model %s did not become ready: %w", model.Name, err)
+	}
+
+	return &poolEntry{
+		name:    model.Name,
+		port:    port,
+		service: service,
+		client:  NewLocalLLMClient(baseURL, model.Path, ""),
+		cancel:  cancelFn,
+		started: time.Now(),
+	}, nil
+}
+
+// Unload removes a model from the pool, then drains and stops its process.
+func (p *ModelPool) Unload(name string) error {
+	p.mu.Lock()
+	entry, ok := p.entries[name]
+	if !ok || p.loading[name] {
+		p.mu.Unlock()
+		return fmt.Errorf("model %s is not loaded", name)
+	}
+	delete(p.entries, name)
+	p.mu.Unlock()
+
+	supervisor.Unregister("pool:" + name)
+	drainAndStop(entry.service, entry.cancel)
+
+	slog.Info("model removed from pool", "model", name)
+	return nil
+}
+
+// UnloadAll stops every pooled model, used on shutdown.
+func (p *ModelPool) UnloadAll() {
+	p.mu.RLock()
+	names := make([]string, 0, len(p.entries))
+	for name := range p.entries {
+		names = append(names, name)
+	}
+	p.mu.RUnlock()
+
+	for _, name := range names {
+		if err := p.Unload(name); err != nil {
+			slog.Warn("failed to unload pooled model", "model", name, "error", err)
+		}
+	}
+}
+
+// Acquire returns the client for a pooled model and a release func, or false if the model is not pooled.
+func (p *ModelPool) Acquire(name string) (LLMClient, func(), bool) {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	entry, ok := p.entries[name]
+	if !ok || p.loading[name] {
+		return nil, nil, false
+	}
+
+	entry.service.inflight.Add(1)
+	return entry.client, entry.service.inflight.Done, true
+}
+
+// List returns the pooled models sorted by name.
+func (p *ModelPool) List() []PoolModelStatus {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	statuses := make([]PoolModelStatus, 0, len(p.entries))
+	for _, entry := range p.entries {
+		if p.loading[entry.name] {
+			continue
+		}
+		statuses = append(statuses, PoolModelStatus{
+			Name:    entry.name,
+			Port:    entry.port,
+			PID:     entry.service.PID(),
+			Started: entry.started,
+		})
+	}
+	sort.Slice(statuses, func(i, j int) bool { return statuses[i].Name < statuses[j].Name })
+	return statuses
+}
+
+// backendFor routes a request to the pooled backend running modelName, falling back to the selected model.
+func backendFor(modelName string) (LLMClient, func()) {
+	if client, release, ok := modelPool.Acquire(modelName); ok {
+		return client, release
+	}
+	return currentBackend()
+}
+
+// preloadModelPool loads the models listed in model_pool.preload in the background.
+func preloadModelPool(config *Config, verbose bool) {
+	if len(config.ModelPool.Preload) == 0 {
+		return
+	}
+
+	models, err := GetModelsByBackend(db.db, config.LLMBackend)
+	if err != nil {
+		slog.Error("failed to load models for pool", "error", err)
+		return
+	}
+
+	for _, name := range config.ModelPool.Preload {
+		model, ok := findModelByName(models, name)
+		if !ok {
+			slog.Warn("model listed in model_pool.preload not found", "model", name)
+			continue
+		}
+		if err := modelPool.Load(config, model, verbose); err != nil {
+			slog.Error("failed to preload model", "model", name, "error", err)
+		}
+	}
+}
+
+// findModelByName returns the model with the given name.
+func findModelByName(models []LanguageModel, name string) (LanguageModel, bool) {
+	for _, model := range models {
+		if model.Name == name {
+			return model, true
+		}
+	}
+	return LanguageModel{}, false
+}
+
+// handleGetModelPool lists the models running in the pool.
+func handleGetModelPool(c echo.Context) error {
+	return c.JSON(http.StatusOK, modelPool.List())
+}
+
+// handleLoadPoolModel starts a model in the pool.
+func handleLoadPoolModel(c echo.Context, config *Config) error {
+	name := c.Param("name")
+
+	models, err := GetModelsByBackend(db.db, config.LLMBackend)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to load models"})
+	}
+
+	model, ok := findModelByName(models, name)
+	if !ok {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "Model not found"})
+	}
+
+	if err := modelPool.Load(config, model, false); err != nil {
+		return c.JSON(http.StatusConflict, map[string]string{"error": err.Error()})
+	}
+
+	return c.JSON(http.StatusOK, map[string]string{"status": "success", "model": name})
+}
+
+// handleUnloadPoolModel stops a model in the pool.
+func handleUnloadPoolModel(c echo.Context) error {
+	name := c.Param("name")
+
+	if err := modelPool.Unload(name); err != nil {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": err.Error()})
+	}
+
+	return c.JSON(http.StatusOK, map[string]string{"status": "success", "model": name})
+}
@@ -0,0 +1,68 @@
+// modelpool_test.go
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestModelPool(t *testing.T) {
+	useTestBackend(t)
+	backend := newFakeLocalBackend(t, false)
+	config := &Config{
+		LLMBackend: "mlx",
+		Services:   []ServiceConfig{{}, {}, backend.serviceConfig("mlx_lm.server")},
+		ModelPool:  ModelPoolConfig{BasePort: backend.port, MaxModels: 1},
+	}
+	pool := NewModelPool()
+
+	require.NoError(t, pool.Load(config, LanguageModel{Name: "tiny", Path: "/models/tiny"}, false))
+	assert.ErrorContains(t, pool.Load(config, LanguageModel{Name: "tiny"}, false), "already loaded")
+	assert.ErrorContains(t, pool.Load(config, LanguageModel{Name: "other"}, false), "pool is full")
+	assert.True(t, supervised("pool:tiny"))
+
+	statuses := pool.List()
+	require.Len(t, statuses, 1)
+	assert.Equal(t, "tiny", statuses[0].Name)
+	assert.Equal(t, backend.port, statuses[0].Port)
+	assert.NotZero(t, statuses[0].PID)
+
+	_, _, ok := pool.Acquire("other")
+	assert.False(t, ok)
+	_, release, ok := pool.Acquire("tiny")
+	require.True(t, ok)
+	service := pool.entries["tiny"].service
+
+	// Unloading waits for the completions in flight
+	unloaded := make(chan error)
+	go func() { unloaded <- pool.Unload("tiny") }()
+	assert.Never(t, serviceExited(service), 200*time.Millisecond, 20*time.Millisecond)
+	_, _, ok = pool.Acquire("tiny")
+	assert.False(t, ok, "an unloading model takes no new requests")
+	release()
+	require.NoError(t, <-unloaded)
+	assert.True(t, service.Stopped())
+	assert.False(t, supervised("pool:tiny"))
+	assert.Empty(t, pool.List())
+	assert.Error(t, pool.Unload("tiny"))
+}
+
+func TestModelPoolLoadNotReady(t *testing.T) {
+	useTestBackend(t)
+	backend := newFakeLocalBackend(t, true)
+	config := &Config{
+		LLMBackend: "mlx",
+		Services:   []ServiceConfig{{}, {}, backend.serviceConfig("mlx_lm.server")},
+		ModelPool:  ModelPoolConfig{BasePort: backend.port},
+		Readiness:  ReadinessConfig{TimeoutSeconds: 1},
+	}
+	pool := NewModelPool()
+
+	assert.ErrorContains(t, pool.Load(config, LanguageModel{Name: "tiny"}, false), "did not become ready")
+	assert.Empty(t, pool.entries, "the port is released")
+	assert.Empty(t, pool.loading)
+	assert.False(t, supervised("pool:tiny"))
+}
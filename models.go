@@ -3,10 +3,9 @@
 package main
 
 import (
+	"context"
 	"fmt"
-	"io"
-	"log"
-	"net/http"
+	"log/slog"
 	"os"
 	"path/filepath"
 	"strings"
@@ -64,37 +63,17 @@ func (mm *ModelManager) ScanModels() ([]string, error) {
 }
 
 // DownloadModel downloads a model from the specified URL and saves it to the models directory.
-// It returns the local path to the downloaded model.
+// Interrupted downloads resume from the partial file. It returns the local path to the downloaded model.
 func (mm *ModelManager) DownloadModel(url string) (string, error) {
 	// Extract the file name from the URL
 	tokens := strings.Split(url, "/")
 	fileName := tokens[len(tokens)-1]
 	localPath := filepath.Join(mm.modelsDir, fileName)
 
-	// Create the file
-	out, err := os.Create(localPath)
-	if err != nil {
-		return "", fmt.Errorf("failed to create file: %w", err)
-	}
-	defer out.Close()
-
-	// Download the file
-	resp, err := http.Get(url)
-	if err != nil {
-		return "", fmt.Errorf("failed to download model: %w", err)
-	}
-	defer resp.Body.Close()
-
-	if resp.StatusCode != http.StatusOK {
-		return "", fmt.Errorf("failed to download model, status code: %d", resp.StatusCode)
-	}
-
-	// Write the content to the local file
-	_, err = io.Copy(out, resp.Body)
-	if err != nil {
-		return "", fmt.Errorf("failed to save model: %w", err)
+	if err := downloadFile(context.Background(), NewHFClient(""), url, localPath, "", nil); err != nil {
+		return "", err
 	}
 
-	log.Printf("Model downloaded and saved to: %s", localPath)
+	slog.Info("model downloaded", "url", url, "path", localPath)
 	return localPath, nil
 }
@@ -0,0 +1,305 @@
+// manifold/pii.go
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	PIIEmail      = "email"
+	PIIPhone      = "phone"
+	PIICreditCard = "credit_card"
+	PIIAPIKey     = "api_key"
+	PIIIP         = "ip"
+
+	defaultNERTimeout = 30 * time.Second
+	minNEREntityChars = 3 // shorter entities would replace parts of unrelated words
+)
+
+// NERConfig asks an OpenAI compatible model for named entities the regular expressions cannot find.
+type NERConfig struct {
+	Endpoint       string   `yaml:"endpoint,omitempty"` // no named entity recognition when empty
+	Model          string   `yaml:"model,omitempty"`
+	APIKey         string   `yaml:"api_key,omitempty" json:"-"`
+	Labels         []string `yaml:"labels,omitempty"`          // entity types, person when empty
+	TimeoutSeconds int      `yaml:"timeout_seconds,omitempty"` // 30 when unset
+}
+
+// PIIConfig redacts personal data and credentials from stored chats and ingested documents.
+// Redacted values become typed placeholders such as [EMAIL_1], the same value getting the same
+// placeholder within a text, so the text stays searchable.
+type PIIConfig struct {
+	Enabled   bool      `yaml:"enabled"`
+	Chats     bool      `yaml:"chats"`           // redact chat turns before they are stored and indexed
+	Documents bool      `yaml:"documents"`       // redact documents before they are indexed
+	Types     []string  `yaml:"types,omitempty"` // email, phone, credit_card, api_key and ip, all when empty
+	NER       NERConfig `yaml:"ner,omitempty"`
+}
+
+// piiPattern finds one type of personal data. Group selects the submatch to replace, and valid
+// filters out false positives.
+type piiPattern struct {
+	Type  string
+	re    *regexp.Regexp
+	group int
+	valid func(string) bool
+}
+
+var piiPatterns = []piiPattern{
+	{Type: PIIEmail, re: regexp.MustCompile(`[A-Za-z0-9._%+-]+@[A-Za-z0-9.-]+\.[A-Za-z]{2,}`)},
+	{Type: PIIAPIKey, re: regexp.MustCompile(`-----BEGIN [A-Z ]*PRIVATE KEY-----[\s\S]*?-----END [A-Z ]*PRIVATE KEY-----`)},
+	{Type: PIIAPIKey, re: regexp.MustCompile(`\b(?:sk|pk|rk)-[A-Za-z0-9_-]{16,}|\bgh[pousr]_[A-Za-z0-9]{36,}|\bgithub_pat_\w{22,}|\bAKIA[0-9A-Z]{16}\b|\bxox[abprs]-[A-Za-z0-9-]{10,}|\bAIza[0-9A-Za-z_-]{35}|\beyJ[\w-]{10,}\.[\w-]{10,}\.[\w-]{10,}`)},
+	{Type: PIIAPIKey, re: regexp.MustCompile(`(?i)\b(?:password|passwd|pwd|secret|token|api[_-]?key|access[_-]?key)\b["']?\s*[:=]\s*["']?([^\s"',;]{6,})`), group: 1},
+	{Type: PIICreditCard, re: regexp.MustCompile(`\b\d(?:[ -]?\d){12,18}\b`), valid: luhnValid},
+	{Type: PIIPhone, re: regexp.MustCompile(`(?:\+\d{1,3}[\s.-]?)?\(?\b\d{3}\)?[\s.-]\d{3}[\s.-]\d{4}\b|\+\d{1,3}(?:[\s.-]?\d{2,4}){2,4}\b`)},
+	{Type: PIIIP, re: regexp.MustCompile(`\b(?:\d{1,3}\.){3}\d{1,3}\b`), valid: ipv4Valid},
+}
+
+// PIIRedactor replaces personal data with placeholders.
+type PIIRedactor struct {
+	patterns []piiPattern
+	ner      NERConfig
+	client   LLMClient // nil without named entity recognition
+}
+
+// NewPIIRedactor creates a redactor for the configured types.
+func NewPIIRedactor(config PIIConfig) (*PIIRedactor, error) {
+	known := []string{PIIEmail, PIIPhone, PIICreditCard, PIIAPIKey, PIIIP}
+	for _, t := range config.Types {
+		if !slices.Contains(known, t) {
+			return nil, fmt.Errorf("unknown pii type %q", t)
+		}
+	}
+
+	r := &PIIRedactor{ner: config.NER}
+	for _, p := range piiPatterns {
+		if len(config.Types) == 0 || slices.Contains(config.Types, p.Type) {
+			r.patterns = append(r.patterns, p)
+		}
+	}
+	if config.NER.Endpoint != "" {
+		r.client = NewLocalLLMClient(config.NER.Endpoint, config.NER.Model, config.NER.APIKey)
+		if len(r.ner.Labels) == 0 {
+			r.ner.Labels = []string{"person"}
+		}
+	}
+	return r, nil
+}
+
+// Redact replaces the personal data in text. Entities found by the NER model are replaced after the
+// patterns, and the text is only redacted by the patterns when the model fails.
+func (r *PIIRedactor) Redact(ctx context.Context, text string) string {
+	if text == "" {
+		return text
+	}
+
+	placeholders := newPlaceholders()
+	for _, p := range r.patterns {
+		text = p.replace(text, placeholders)
+	}
+
+	if r.client != nil {
+		entities, err := r.entities(ctx, text)
+		if err != nil {
+			loggerFromContext(ctx).Warn("named entity recognition failed", "error", err)
+			return text
+		}
+		for _, entity := range entities {
+			text = strings.ReplaceAll(text, entity.value, placeholders.get(entity.label, entity.value))
+		}
+	}
+	return text
+}
+
+// replace substitutes the pattern's matches in text.
+func (p piiPattern) replace(text string, placeholders *placeholders) string {
+	matches := p.re.FindAllStringSubmatchIndex(text, -1)
+	if matches == nil {
+		return text
+	}
+
+	var out strings.Builder
+	last := 0
+	for _, m := range matches {
+		start, end := m[2*p.group], m[2*p.group+1]
+		if start < 0 {
+			continue
+		}
+		value := text[start:end]
+		if p.valid != nil && !p.valid(value) {
+			continue
+		}
+		out.WriteString(text[last:start])
+		out.WriteString(placeholders.get(p.Type, value))
+		last = end
+	}
+	out.WriteString(text[last:])
+	return out.String()
+}
+
+// placeholders numbers the redacted values of each type within a text.
+type placeholders struct {
+	seen   map[string]string
+	counts map[string]int
+}
+
+func newPlaceholders() *placeholders {
+	return &placeholders{seen: make(map[string]string), counts: make(map[string]int)}
+}
+
+// get returns the placeholder of a value, the same one every time the value is seen.
+func (p *placeholders) get(kind, value string) string {
+	key := kind + "\x00" + value
+	if placeholder, ok := p.seen[key]; ok {
+		return placeholder
+	}
+	p.counts[kind]++
+	placeholder := fmt.Sprintf("[%s_%d]", strings.ToUpper(kind), p.counts[kind])
+	p.seen[key] = placeholder
+	return placeholder
+}
+
+// nerEntity is a named entity found by the NER model.
+type nerEntity struct {
+	label string
+	value string
+}
+
+// entities asks the NER model for the entities of the configured labels in text, longest first so
+// a name is replaced before its parts.
+func (r *PIIRedactor) entities(ctx context.Context, text string) ([]nerEntity, error) {
+	timeout := defaultNERTimeout
+	if r.ner.TimeoutSeconds > 0 {
+		timeout = time.Duration(r.ner.TimeoutSeconds) * time.Second
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	example := make([]string, len(r.ner.Labels))
+	for i, label := range r.ner.Labels {
+		example[i] = strconv.Quote(label) + `: ["..."]`
+	}
+	payload := &CompletionRequest{
+		Model: r.ner.Model,
+		Messages: []Message{
+			{Role: "system", Content: "You find named entities of these types in the user's text: " + strings.Join(r.ner.Labels, ", ") + ". " +
+				"Reply with a JSON object mapping each type to the entities exactly as they are written in the text, for example {" +
+				strings.Join(example, ", ") + "}. Ignore text in square brackets. Reply with the JSON object only."},
+			{Role: "user", Content: text},
+		},
+		Temperature: 0,
+		MaxTokens:   1024,
+	}
+
+	resp, err := r.client.SendCompletionRequest(ctx, payload)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var completion CompletionResponse
+	if err := json.NewDecoder(resp.Body).Decode(&completion); err != nil {
+		return nil, fmt.Errorf("invalid ner response: %w", err)
+	}
+	if len(completion.Choices) == 0 {
+		return nil, errors.New("ner returned no choices")
+	}
+
+	reply := completion.Choices[0].Message.Content
+	labeled, ok := extractJSON(reply, false)
+	if !ok {
+		return nil, fmt.Errorf("ner reply has no entities: %s", truncateForLog(reply, 100))
+	}
+	var found map[string][]string
+	if err := json.Unmarshal([]byte(labeled), &found); err != nil {
+		return nil, fmt.Errorf("ner reply has invalid entities: %w", err)
+	}
+
+	var entities []nerEntity
+	for _, label := range r.ner.Labels {
+		for _, value := range found[label] {
+			value = strings.TrimSpace(value)
+			// Entities the model made up or that are too short to replace safely are skipped
+			if len(value) >= minNEREntityChars && strings.Contains(text, value) {
+				entities = append(entities, nerEntity{label: label, value: value})
+			}
+		}
+	}
+	sort.SliceStable(entities, func(i, j int) bool { return len(entities[i].value) > len(entities[j].value) })
+	return entities, nil
+}
+
+// luhnValid reports whether a number passes the Luhn checksum of payment cards.
+func luhnValid(number string) bool {
+	var sum, n int
+	for i := len(number) - 1; i >= 0; i-- {
+		c := number[i]
+		if c == ' ' || c == '-' {
+			continue
+		}
+		d := int(c - '0')
+		if n%2 == 1 {
+			d *= 2
+			if d > 9 {
+				d -= 9
+			}
+		}
+		sum += d
+		n++
+	}
+	return n >= 13 && sum%10 == 0
+}
+
+// ipv4Valid reports whether every octet of a dotted address is at most 255.
+func ipv4Valid(addr string) bool {
+	for _, octet := range strings.Split(addr, ".") {
+		if n, err := strconv.Atoi(octet); err != nil || n > 255 {
+			return false
+		}
+	}
+	return true
+}
+
+// piiRedactor redacts stored chats, nil when disabled.
+var piiRedactor *PIIRedactor
+
+// configurePII creates the redactor from the config and installs it on the document manager.
+func configurePII(config *Config) error {
+	piiRedactor = nil
+	if !config.PII.Enabled {
+		return nil
+	}
+	r, err := NewPIIRedactor(config.PII)
+	if err != nil {
+		return err
+	}
+	if config.PII.Chats {
+		piiRedactor = r
+	}
+	if config.PII.Documents && docManager != nil {
+		docManager.Redact = func(content string) string {
+			return r.Redact(context.Background(), content)
+		}
+	}
+	return nil
+}
+
+// redactChatTurn removes personal data from a chat turn before it is stored, when configured.
+func redactChatTurn(ctx context.Context, prompt, response string) (string, string) {
+	if piiRedactor == nil {
+		return prompt, response
+	}
+	span, ctx := startSpan(ctx, "pii.redact")
+	defer span.Finish()
+	return piiRedactor.Redact(ctx, prompt), piiRedactor.Redact(ctx, response)
+}
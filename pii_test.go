@@ -0,0 +1,75 @@
+// pii_test.go
+package main
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPIIRedact(t *testing.T) {
+	r, err := NewPIIRedactor(PIIConfig{Enabled: true})
+	require.NoError(t, err)
+	ctx := context.Background()
+
+	tests := []struct {
+		in, want string
+	}{
+		{"Mail jane.doe@example.com or JANE@corp.io, again jane.doe@example.com", "Mail [EMAIL_1] or [EMAIL_2], again [EMAIL_1]"},
+		{"Call (555) 123-4567 or +44 20 7946 0958", "Call [PHONE_1] or [PHONE_2]"},
+		{"Card 4111 1111 1111 1111, order 1234567890123", "Card [CREDIT_CARD_1], order 1234567890123"},
+		{"export OPENAI_API_KEY=sk-abcdefghijklmnopqrstuvwx", "export OPENAI_API_KEY=[API_KEY_1]"},
+		{`{"password": "hunter2!x"}`, `{"password": "[API_KEY_1]"}`},
+		{"token ghp_" + "abcdefghijklmnopqrstuvwxyz0123456789", "token [API_KEY_1]"},
+		{"Server 10.0.0.12, version 1.22.999.1", "Server [IP_1], version 1.22.999.1"},
+		{"Released on 2024-01-15 with 3 fixes", "Released on 2024-01-15 with 3 fixes"},
+	}
+	for _, tt := range tests {
+		assert.Equal(t, tt.want, r.Redact(ctx, tt.in), tt.in)
+	}
+
+	emailsOnly, err := NewPIIRedactor(PIIConfig{Enabled: true, Types: []string{PIIEmail}})
+	require.NoError(t, err)
+	assert.Equal(t, "[EMAIL_1] from 10.0.0.12", emailsOnly.Redact(ctx, "a@b.co from 10.0.0.12"))
+
+	_, err = NewPIIRedactor(PIIConfig{Types: []string{"ssn"}})
+	assert.Error(t, err)
+}
+
+func TestPIIRedactNER(t *testing.T) {
+	var received string
+	server := completionServer(t, func(request CompletionRequest) (string, error) {
+		received = request.Messages[1].Content
+		return `{"person": ["Jane Doe", "Jane", "Al", "Bob Invented"], "organization": ["Acme Corp"]}`, nil
+	})
+
+	r, err := NewPIIRedactor(PIIConfig{Enabled: true, NER: NERConfig{Endpoint: server.URL, Labels: []string{"person", "organization"}}})
+	require.NoError(t, err)
+
+	out := r.Redact(context.Background(), "Jane Doe (jane@acme.com) joined Acme Corp. Jane and Al met.")
+	assert.Equal(t, "[PERSON_1] ([EMAIL_1]) joined [ORGANIZATION_1]. [PERSON_2] and Al met.", out)
+	assert.Contains(t, received, "[EMAIL_1]", "the model only sees text the patterns redacted")
+}
+
+func TestRedactChatTurn(t *testing.T) {
+	saved := piiRedactor
+	defer func() { piiRedactor = saved }()
+
+	piiRedactor = nil
+	prompt, _ := redactChatTurn(context.Background(), "mail a@b.co", "")
+	assert.Equal(t, "mail a@b.co", prompt)
+
+	require.NoError(t, configurePII(&Config{PII: PIIConfig{Enabled: true, Chats: true}}))
+	prompt, response := redactChatTurn(context.Background(), "mail a@b.co", "sure, a@b.co")
+	assert.Equal(t, "mail [EMAIL_1]", prompt)
+	assert.Equal(t, "sure, [EMAIL_1]", response)
+}
+
+func TestLuhnValid(t *testing.T) {
+	assert.True(t, luhnValid("4111-1111-1111-1111"))
+	assert.True(t, luhnValid("5500 0000 0000 0004"))
+	assert.False(t, luhnValid("4111 1111 1111 1112"))
+	assert.False(t, luhnValid("0000"))
+}
@@ -0,0 +1,334 @@
+// manifold/planner.go
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/labstack/echo/v4"
+	"github.com/opentracing/opentracing-go"
+)
+
+const (
+	defaultPlannerMaxIterations = 5
+	defaultPlannerMaxSteps      = 3
+	plannerResultChars          = 2000 // of each tool result shown to the planner
+
+	// Kinds of plan trace entries
+	PlanStepPlan  = "plan"  // a reply of the planner
+	PlanStepTool  = "tool"  // a tool call
+	PlanStepDone  = "done"  // the planner has what it needs
+	PlanStepLimit = "limit" // max_iterations reached
+	PlanStepError = "error" // the planner failed, the answer uses the results so far
+)
+
+// PlannerConfig enables the plan mode, where the model plans tool calls, sees their results and
+// plans again until it can answer. Chat messages with "mode": "plan" use it, or every message when
+// default is set.
+type PlannerConfig struct {
+	Enabled       bool `yaml:"enabled"`
+	Default       bool `yaml:"default,omitempty"`
+	MaxIterations int  `yaml:"max_iterations,omitempty"` // planning rounds per turn, 5 when unset
+	MaxSteps      int  `yaml:"max_steps,omitempty"`      // tool calls per round, 3 when unset
+}
+
+// PlanStep is an entry of the trace of a turn run in plan mode.
+type PlanStep struct {
+	ID         uint      `gorm:"primaryKey" json:"id"`
+	SessionID  string    `gorm:"index" json:"session_id"`
+	TurnID     string    `gorm:"index" json:"turn_id"`
+	Iteration  int       `json:"iteration"`
+	Kind       string    `json:"kind"` // plan, tool, done, limit or error
+	Tool       string    `json:"tool,omitempty"`
+	Input      string    `gorm:"type:text" json:"input,omitempty"`
+	Output     string    `gorm:"type:text" json:"output,omitempty"`
+	Error      string    `json:"error,omitempty"`
+	DurationMS int64     `json:"duration_ms"`
+	CreatedAt  time.Time `json:"created_at"`
+}
+
+// plannedCall is a tool call requested by the planner.
+type plannedCall struct {
+	Tool  string `json:"tool"`
+	Input string `json:"input"`
+}
+
+// planReply is what the planner answers each round.
+type planReply struct {
+	Steps []plannedCall `json:"steps"`
+	Done  bool          `json:"done"`
+}
+
+// planResult is the outcome of an executed call.
+type planResult struct {
+	call   plannedCall
+	output string
+	err    error
+}
+
+// Planner runs turns in plan mode.
+type Planner struct {
+	config PlannerConfig
+}
+
+// NewPlanner creates a planner from its configuration.
+func NewPlanner(config PlannerConfig) *Planner {
+	if config.MaxIterations <= 0 {
+		config.MaxIterations = defaultPlannerMaxIterations
+	}
+	if config.MaxSteps <= 0 {
+		config.MaxSteps = defaultPlannerMaxSteps
+	}
+	return &Planner{config: config}
+}
+
+// SetPlanner enables the plan mode of the workflow.
+func (wm *WorkflowManager) SetPlanner(planner *Planner) {
+	wm.planner = planner
+}
+
+// planModeKey is the context key set on turns that run in plan mode.
+type planModeKey struct{}
+
+// withPlanMode returns a copy of ctx for a turn that runs in plan mode.
+func withPlanMode(ctx context.Context) context.Context {
+	return context.WithValue(ctx, planModeKey{}, true)
+}
+
+// planModeFromContext reports whether the turn runs in plan mode.
+func planModeFromContext(ctx context.Context) bool {
+	plan, _ := ctx.Value(planModeKey{}).(bool)
+	return plan
+}
+
+// RunPlan has the model plan tool calls for the prompt, executes them and feeds the results back
+// until the model is done or max_iterations is reached. It returns the prompt with the results for
+// the model to answer. Without a planner it runs the tools like Run.
+func (wm *WorkflowManager) RunPlan(ctx context.Context, prompt string, c *websocket.Conn, client LLMClient, model string) (result string, err error) {
+	if wm.planner == nil {
+		return wm.Run(ctx, prompt, c)
+	}
+	if len(wm.tools) == 0 {
+		return prompt, nil
+	}
+
+	span, ctx := startSpan(ctx, "workflow.plan")
+	defer func() { finishSpan(span, err) }()
+
+	logger := loggerFromContext(ctx)
+	config := wm.planner.config
+
+	var results []planResult
+	executed := make(map[plannedCall]bool)
+	for iteration := 1; ; iteration++ {
+		if iteration > config.MaxIterations {
+			recordPlanStep(ctx, PlanStep{Iteration: iteration - 1, Kind: PlanStepLimit})
+			logger.Info("plan stopped at max iterations", "iterations", config.MaxIterations)
+			break
+		}
+
+		c.WriteMessage(websocket.TextMessage, []byte(toolProgressHTML("Planning", fmt.Sprintf("round %d", iteration))))
+
+		start := time.Now()
+		reply, raw, planErr := wm.plan(ctx, client, model, prompt, results)
+		if planErr != nil {
+			recordPlanStep(ctx, PlanStep{Iteration: iteration, Kind: PlanStepError, Output: raw, Error: planErr.Error(), DurationMS: time.Since(start).Milliseconds()})
+			logger.Warn("planner failed, answering with the results so far", "error", planErr)
+			break
+		}
+		recordPlanStep(ctx, PlanStep{Iteration: iteration, Kind: PlanStepPlan, Output: raw, DurationMS: time.Since(start).Milliseconds()})
+
+		// A plan that only repeats calls already made cannot get further
+		var calls []plannedCall
+		for _, call := range reply.Steps {
+			if !executed[call] && len(calls) < config.MaxSteps {
+				calls = append(calls, call)
+			}
+		}
+		if reply.Done || len(calls) == 0 {
+			recordPlanStep(ctx, PlanStep{Iteration: iteration, Kind: PlanStepDone})
+			break
+		}
+
+		for _, call := range calls {
+			executed[call] = true
+			results = append(results, wm.execute(ctx, c, iteration, call))
+		}
+	}
+	span.SetTag("steps", len(results))
+
+	var out strings.Builder
+	if len(results) > 0 {
+		out.WriteString("Results of the tools called to answer the question:\n\n")
+		for i, r := range results {
+			fmt.Fprintf(&out, "[%d] %s: %s\n", i+1, r.call.Tool, r.call.Input)
+			if r.err != nil {
+				fmt.Fprintf(&out, "failed: %v\n\n", r.err)
+			} else {
+				fmt.Fprintf(&out, "%s\n\n", r.output)
+			}
+		}
+	}
+
+	promptDelimiter := "Now respond to the following question or instructions using the previous texts as reference. Ensure you always respond to the following: "
+	fmt.Fprintf(&out, "%s\n%s", promptDelimiter, prompt)
+	return out.String(), nil
+}
+
+// plan asks the model for the next tool calls. It returns the raw reply for the trace.
+func (wm *WorkflowManager) plan(ctx context.Context, client LLMClient, model, prompt string, results []planResult) (planReply, string, error) {
+	var list strings.Builder
+	for _, wrapper := range wm.tools {
+		fmt.Fprintf(&list, "- %s: %s\n", wrapper.Name, describeTool(wrapper.Name))
+	}
+	instructions := "You plan the tool calls an assistant makes before it answers a user. Available tools:\n" + list.String() +
+		fmt.Sprintf("Reply with JSON only: {\"steps\": [{\"tool\": \"<name>\", \"input\": \"<request for the tool>\"}]} to call up to %d tools, ", wm.planner.config.MaxSteps) +
+		"or {\"done\": true} when the results so far are enough to answer. Tools read their input like a user message, " +
+		"so include the URLs, code blocks, paths or places they need."
+
+	var user strings.Builder
+	user.WriteString(unwrapPrompt(prompt))
+	if len(results) > 0 {
+		user.WriteString("\n\nResults so far:\n")
+		for i, r := range results {
+			fmt.Fprintf(&user, "[%d] %s: %s\n", i+1, r.call.Tool, r.call.Input)
+			if r.err != nil {
+				fmt.Fprintf(&user, "failed: %v\n", r.err)
+			} else {
+				fmt.Fprintf(&user, "%s\n", truncateForLog(r.output, plannerResultChars))
+			}
+		}
+	}
+
+	payload := &CompletionRequest{
+		Model: model,
+		Messages: []Message{
+			{Role: "system", Content: instructions},
+			{Role: "user", Content: user.String()},
+		},
+		Temperature: 0,
+		MaxTokens:   512,
+	}
+
+	resp, err := client.SendCompletionRequest(ctx, payload)
+	if err != nil {
+		return planReply{}, "", err
+	}
+	defer resp.Body.Close()
+
+	var completion CompletionResponse
+	if err := json.NewDecoder(resp.Body).Decode(&completion); err != nil {
+		return planReply{}, "", fmt.Errorf("invalid planner response: %w", err)
+	}
+	if len(completion.Choices) == 0 {
+		return planReply{}, "", errors.New("planner returned no choices")
+	}
+
+	raw := completion.Choices[0].Message.Content
+	reply, err := parsePlanReply(raw)
+	return reply, raw, err
+}
+
+// parsePlanReply reads the JSON object from a planner reply, ignoring any text around it.
+func parsePlanReply(raw string) (planReply, error) {
+	plan, ok := extractJSON(raw, false)
+	if !ok {
+		return planReply{}, fmt.Errorf("planner reply has no plan: %s", truncateForLog(raw, 100))
+	}
+
+	var reply planReply
+	if err := json.Unmarshal([]byte(plan), &reply); err != nil {
+		return planReply{}, fmt.Errorf("planner reply has an invalid plan: %w", err)
+	}
+	return reply, nil
+}
+
+// execute runs a planned call with the named tool.
+func (wm *WorkflowManager) execute(ctx context.Context, c *websocket.Conn, iteration int, call plannedCall) planResult {
+	logger := loggerFromContext(ctx).With("tool", call.Tool)
+	result := planResult{call: call}
+
+	tool, ok := wm.GetToolByName(call.Tool)
+	if !ok {
+		result.err = fmt.Errorf("unknown tool %q", call.Tool)
+		recordPlanStep(ctx, PlanStep{Iteration: iteration, Kind: PlanStepTool, Tool: call.Tool, Input: call.Input, Error: result.err.Error()})
+		return result
+	}
+	wrapper := ToolWrapper{Tool: tool, Name: call.Tool}
+
+	toolMessage := toolStatusMessage(call.Tool)
+	c.WriteMessage(websocket.TextMessage, []byte(toolProgressHTML(toolMessage, "")))
+	progress := func(status string) {
+		c.WriteMessage(websocket.TextMessage, []byte(toolProgressHTML(toolMessage, status)))
+	}
+
+	// Tools read their input the way the chat wraps the user prompt
+	input := "{" + call.Input + "}"
+
+	start := time.Now()
+	toolSpan, toolCtx := startSpan(withWebSocket(withLogger(ctx, logger), c), "tool.process", opentracing.Tag{Key: "tool", Value: call.Tool})
+	result.output, result.err = processWithPolicy(toolCtx, wrapper, input, progress)
+	finishSpan(toolSpan, result.err)
+	if result.err != nil {
+		logger.Error("error processing with tool", "error", result.err)
+	}
+
+	recordToolInvocation(ctx, call.Tool, time.Since(start), result.output, result.err)
+	step := PlanStep{Iteration: iteration, Kind: PlanStepTool, Tool: call.Tool, Input: call.Input, Output: result.output, DurationMS: time.Since(start).Milliseconds()}
+	if result.err != nil {
+		step.Error = result.err.Error()
+	}
+	recordPlanStep(ctx, step)
+	return result
+}
+
+// recordPlanStep stores an entry of the current turn's plan trace.
+func recordPlanStep(ctx context.Context, step PlanStep) {
+	if db == nil {
+		return
+	}
+
+	turn := turnFromContext(ctx)
+	step.SessionID = turn.SessionID
+	step.TurnID = turn.TurnID
+	if err := db.Create(&step); err != nil {
+		loggerFromContext(ctx).Warn("failed to record plan step", "kind", step.Kind, "error", err)
+	}
+}
+
+// GetPlanSteps returns the plan trace of a turn or session in the order it was recorded.
+func (sqldb *SQLiteDB) GetPlanSteps(sessionID, turnID string) ([]PlanStep, error) {
+	query := sqldb.db.Model(&PlanStep{})
+	if sessionID != "" {
+		query = query.Where("session_id = ?", sessionID)
+	}
+	if turnID != "" {
+		query = query.Where("turn_id = ?", turnID)
+	}
+
+	var steps []PlanStep
+	err := query.Order("id").Find(&steps).Error
+	return steps, err
+}
+
+// handleGetPlanTrace returns the plan trace of a turn or session.
+func handleGetPlanTrace(c echo.Context) error {
+	sessionID := c.QueryParam("session_id")
+	turnID := c.QueryParam("turn_id")
+	if sessionID == "" && turnID == "" {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "session_id or turn_id is required"})
+	}
+
+	steps, err := db.GetPlanSteps(sessionID, turnID)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to load plan trace"})
+	}
+	return c.JSON(http.StatusOK, steps)
+}
@@ -0,0 +1,118 @@
+// planner_test.go
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// testWebSocket returns the server side of a websocket connection whose client discards what it reads.
+func testWebSocket(t *testing.T) *websocket.Conn {
+	conns := make(chan *websocket.Conn, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		require.NoError(t, err)
+		conns <- conn
+	}))
+	t.Cleanup(server.Close)
+
+	client, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(server.URL, "http"), nil)
+	require.NoError(t, err)
+	t.Cleanup(func() { client.Close() })
+	go func() {
+		for {
+			if _, _, err := client.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}()
+	return <-conns
+}
+
+// plannerServer replies with the plans in order, then with done.
+func plannerServer(t *testing.T, plans ...string) (*httptest.Server, *[]CompletionRequest) {
+	var requests []CompletionRequest
+	server := completionServer(t, func(request CompletionRequest) (string, error) {
+		requests = append(requests, request)
+		if len(requests) <= len(plans) {
+			return plans[len(requests)-1], nil
+		}
+		return `{"done": true}`, nil
+	})
+	return server, &requests
+}
+
+func TestRunPlan(t *testing.T) {
+	testDB, err := NewSQLiteDB(t.TempDir())
+	require.NoError(t, err)
+	require.NoError(t, testDB.AutoMigrate(&PlanStep{}, &ToolInvocation{}))
+
+	saved := db
+	db = testDB
+	defer func() { db = saved }()
+
+	server, requests := plannerServer(t,
+		`Plan: {"steps": [{"tool": "lookup", "input": "go release"}, {"tool": "missing", "input": "x"}]}`,
+		`{"steps": [{"tool": "lookup", "input": "go release"}]}`, // a repeat ends the plan
+	)
+
+	wm := &WorkflowManager{}
+	wm.AddTool(&flakyTool{}, "lookup")
+	wm.SetPlanner(NewPlanner(PlannerConfig{Enabled: true}))
+
+	ctx := withTurn(context.Background(), TurnInfo{SessionID: "s1", TurnID: "t1"})
+	out, err := wm.RunPlan(ctx, "{When was Go 1.22 released?}", testWebSocket(t), NewLocalLLMClient(server.URL, "", ""), "model")
+	require.NoError(t, err)
+	assert.Contains(t, out, "[1] lookup: go release\nok\n")
+	assert.Contains(t, out, `[2] missing: x`+"\nfailed: unknown tool \"missing\"")
+	assert.True(t, strings.HasSuffix(out, "{When was Go 1.22 released?}"))
+
+	require.Len(t, *requests, 2)
+	assert.Contains(t, (*requests)[0].Messages[0].Content, "- lookup: lookup")
+	assert.Contains(t, (*requests)[1].Messages[1].Content, "Results so far:\n[1] lookup: go release\nok")
+
+	steps, err := db.GetPlanSteps("", "t1")
+	require.NoError(t, err)
+	var kinds []string
+	for _, step := range steps {
+		kinds = append(kinds, step.Kind)
+	}
+	assert.Equal(t, []string{PlanStepPlan, PlanStepTool, PlanStepTool, PlanStepPlan, PlanStepDone}, kinds)
+	assert.Equal(t, "ok", steps[1].Output)
+	assert.NotEmpty(t, steps[2].Error)
+}
+
+func TestRunPlanLimits(t *testing.T) {
+	plan := func(input string) string { return `{"steps": [{"tool": "lookup", "input": "` + input + `"}]}` }
+	server, requests := plannerServer(t, plan("a"), plan("b"), plan("c"))
+
+	wm := &WorkflowManager{}
+	wm.AddTool(&flakyTool{}, "lookup")
+	wm.SetPlanner(NewPlanner(PlannerConfig{Enabled: true, MaxIterations: 2}))
+
+	out, err := wm.RunPlan(context.Background(), "{question}", testWebSocket(t), NewLocalLLMClient(server.URL, "", ""), "model")
+	require.NoError(t, err)
+	assert.Len(t, *requests, 2)
+	assert.Contains(t, out, "[2] lookup: b")
+	assert.NotContains(t, out, "lookup: c")
+}
+
+func TestParsePlanReply(t *testing.T) {
+	reply, err := parsePlanReply("Sure:\n```json\n{\"steps\": [{\"tool\": \"websearch\", \"input\": \"go\"}]}\n```")
+	require.NoError(t, err)
+	assert.Equal(t, []plannedCall{{Tool: "websearch", Input: "go"}}, reply.Steps)
+
+	reply, err = parsePlanReply(`{"done": true}`)
+	require.NoError(t, err)
+	assert.True(t, reply.Done)
+
+	_, err = parsePlanReply("I need no tools")
+	assert.Error(t, err)
+}
@@ -0,0 +1,350 @@
+// manifold/plugin.go
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	defaultPluginTimeout   = 30 * time.Second
+	defaultPluginOutputKB  = 256
+	pluginProtocolVersion  = 1
+	pluginStderrLimitBytes = 4096
+)
+
+// PluginConfig declares an external tool. Plugins speak JSON over stdio: each invocation starts
+// the command, writes one PluginRequest to its stdin and reads one PluginResponse from its stdout.
+type PluginConfig struct {
+	Name        string                 `yaml:"name"`
+	Description string                 `yaml:"description,omitempty"` // shown while the tool runs
+	Command     string                 `yaml:"command"`
+	Args        []string               `yaml:"args,omitempty"`
+	Enabled     bool                   `yaml:"enabled"`
+	Schema      map[string]PluginParam `yaml:"schema,omitempty"` // parameters the plugin accepts
+	Parameters  map[string]interface{} `yaml:"parameters,omitempty"`
+	Sandbox     PluginSandbox          `yaml:"sandbox,omitempty"`
+
+	Retry          RetryPolicy   `yaml:"retry,omitempty"`
+	CircuitBreaker BreakerPolicy `yaml:"circuit_breaker,omitempty"`
+}
+
+// PluginParam describes a plugin parameter.
+type PluginParam struct {
+	Type        string      `yaml:"type" json:"type"` // string, number, boolean, array or object
+	Description string      `yaml:"description,omitempty" json:"description,omitempty"`
+	Required    bool        `yaml:"required,omitempty" json:"required,omitempty"`
+	Default     interface{} `yaml:"default,omitempty" json:"default,omitempty"`
+}
+
+// PluginSandbox limits what a plugin invocation can do. Plugins run in their own working directory
+// under data_path/plugins with an empty environment apart from PATH, HOME and the listed variables.
+type PluginSandbox struct {
+	TimeoutSeconds int      `yaml:"timeout_seconds,omitempty"` // 30 when unset
+	MaxOutputKB    int      `yaml:"max_output_kb,omitempty"`   // 256 when unset
+	Env            []string `yaml:"env,omitempty"`             // variables passed through from manifold's environment
+}
+
+// PluginRequest is written to a plugin's stdin.
+type PluginRequest struct {
+	Version int                    `json:"version"`
+	Tool    string                 `json:"tool"`
+	Input   string                 `json:"input"`
+	Params  map[string]interface{} `json:"params"`
+}
+
+// PluginResponse is read from a plugin's stdout.
+type PluginResponse struct {
+	Output string `json:"output"`
+	Error  string `json:"error,omitempty"`
+}
+
+// pluginRegistry holds the configured plugins so toggled plugins can be created by name.
+var pluginRegistry = struct {
+	sync.RWMutex
+	plugins map[string]PluginConfig
+	dataDir string
+}{plugins: make(map[string]PluginConfig)}
+
+// registerPlugins records the configured plugins and returns an error for invalid declarations.
+func registerPlugins(config *Config) error {
+	pluginRegistry.Lock()
+	defer pluginRegistry.Unlock()
+
+	pluginRegistry.dataDir = filepath.Join(config.DataPath, "plugins")
+	for _, p := range config.Plugins {
+		if p.Name == "" || p.Command == "" {
+			return fmt.Errorf("plugin %q needs a name and a command", p.Name)
+		}
+		if _, err := createBuiltinTool(p.Name); err == nil {
+			return fmt.Errorf("plugin %s conflicts with a built-in tool", p.Name)
+		}
+		pluginRegistry.plugins[p.Name] = p
+	}
+	return nil
+}
+
+// lookupPlugin returns a configured plugin by name.
+func lookupPlugin(name string) (PluginConfig, bool) {
+	pluginRegistry.RLock()
+	defer pluginRegistry.RUnlock()
+	p, ok := pluginRegistry.plugins[name]
+	return p, ok
+}
+
+// ensurePluginTools adds the plugins to the tools table so they can be listed and toggled.
+func ensurePluginTools(db *SQLiteDB, plugins []PluginConfig) error {
+	for _, p := range plugins {
+		if _, err := db.GetToolMetadataByName(p.Name); err == nil {
+			continue
+		}
+		if err := db.CreateToolMetadata(ToolMetadata{Name: p.Name, Enabled: p.Enabled}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// PluginTool runs an external plugin as a workflow tool.
+type PluginTool struct {
+	plugin  PluginConfig
+	workDir string
+	enabled bool
+	params  map[string]interface{}
+}
+
+// NewPluginTool creates the tool for a configured plugin.
+func NewPluginTool(plugin PluginConfig) *PluginTool {
+	pluginRegistry.RLock()
+	workDir := filepath.Join(pluginRegistry.dataDir, plugin.Name)
+	pluginRegistry.RUnlock()
+
+	return &PluginTool{plugin: plugin, workDir: workDir, enabled: plugin.Enabled}
+}
+
+// Process invokes the plugin with the prompt and returns its output.
+func (t *PluginTool) Process(ctx context.Context, input string) (string, error) {
+	request := PluginRequest{
+		Version: pluginProtocolVersion,
+		Tool:    t.plugin.Name,
+		Input:   input,
+		Params:  t.params,
+	}
+	response, err := runPlugin(ctx, t.plugin, t.workDir, request)
+	if err != nil {
+		return "", err
+	}
+	if response.Error != "" {
+		return "", fmt.Errorf("plugin %s: %s", t.plugin.Name, response.Error)
+	}
+	return response.Output, nil
+}
+
+// Enabled returns the enabled status of the tool.
+func (t *PluginTool) Enabled() bool {
+	return t.enabled
+}
+
+// SetParams validates the parameters against the plugin's schema and fills in defaults.
+func (t *PluginTool) SetParams(params map[string]interface{}, config *Config) error {
+	if enabled, ok := params["enabled"].(bool); ok {
+		t.enabled = enabled
+	}
+
+	validated, err := validatePluginParams(t.plugin.Schema, params)
+	if err != nil {
+		return fmt.Errorf("plugin %s: %w", t.plugin.Name, err)
+	}
+	t.params = validated
+	return nil
+}
+
+// GetParams returns the tool's parameters.
+func (t *PluginTool) GetParams() map[string]interface{} {
+	params := map[string]interface{}{"enabled": t.enabled}
+	for k, v := range t.params {
+		params[k] = v
+	}
+	return params
+}
+
+// validatePluginParams checks params against a schema. Parameters outside the schema are rejected,
+// except enabled, and missing ones take their default.
+func validatePluginParams(schema map[string]PluginParam, params map[string]interface{}) (map[string]interface{}, error) {
+	out := make(map[string]interface{})
+	for name, value := range params {
+		if name == "enabled" {
+			continue
+		}
+		param, ok := schema[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown parameter %q", name)
+		}
+		if !pluginTypeMatches(param.Type, value) {
+			return nil, fmt.Errorf("parameter %q must be of type %s", name, param.Type)
+		}
+		out[name] = value
+	}
+
+	for name, param := range schema {
+		if _, ok := out[name]; ok {
+			continue
+		}
+		if param.Default != nil {
+			out[name] = param.Default
+		} else if param.Required {
+			return nil, fmt.Errorf("missing required parameter %q", name)
+		}
+	}
+	return out, nil
+}
+
+// pluginTypeMatches reports whether a YAML or JSON decoded value has the schema type.
+func pluginTypeMatches(typ string, value interface{}) bool {
+	switch typ {
+	case "", "any":
+		return true
+	case "string":
+		_, ok := value.(string)
+		return ok
+	case "number":
+		switch value.(type) {
+		case int, int64, float64:
+			return true
+		}
+	case "boolean":
+		_, ok := value.(bool)
+		return ok
+	case "array":
+		_, ok := value.([]interface{})
+		return ok
+	case "object":
+		switch value.(type) {
+		case map[string]interface{}, map[interface{}]interface{}:
+			return true
+		}
+	}
+	return false
+}
+
+// limitedBuffer keeps up to max bytes and records whether more were written.
+type limitedBuffer struct {
+	buf       bytes.Buffer
+	max       int
+	truncated bool
+}
+
+func (b *limitedBuffer) Write(p []byte) (int, error) {
+	if remaining := b.max - b.buf.Len(); remaining < len(p) {
+		b.truncated = true
+		if remaining > 0 {
+			b.buf.Write(p[:remaining])
+		}
+		return len(p), nil
+	}
+	return b.buf.Write(p)
+}
+
+// pluginEnv is the environment a plugin runs with.
+func pluginEnv(sandbox PluginSandbox, workDir string) []string {
+	env := []string{"PATH=" + os.Getenv("PATH"), "HOME=" + workDir}
+	for _, name := range sandbox.Env {
+		if value, ok := os.LookupEnv(name); ok {
+			env = append(env, name+"="+value)
+		}
+	}
+	return env
+}
+
+// runPlugin runs one sandboxed plugin invocation.
+func runPlugin(ctx context.Context, plugin PluginConfig, workDir string, request PluginRequest) (response PluginResponse, err error) {
+	span, ctx := startSpan(ctx, "plugin.invoke")
+	span.SetTag("plugin", plugin.Name)
+	defer func() { finishSpan(span, err) }()
+
+	timeout := time.Duration(plugin.Sandbox.TimeoutSeconds) * time.Second
+	if timeout <= 0 {
+		timeout = defaultPluginTimeout
+	}
+	maxOutput := plugin.Sandbox.MaxOutputKB << 10
+	if maxOutput <= 0 {
+		maxOutput = defaultPluginOutputKB << 10
+	}
+
+	if err := os.MkdirAll(workDir, 0755); err != nil {
+		return response, fmt.Errorf("failed to create plugin directory: %w", err)
+	}
+
+	input, err := json.Marshal(request)
+	if err != nil {
+		return response, err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	stdout := &limitedBuffer{max: maxOutput}
+	stderr := &limitedBuffer{max: pluginStderrLimitBytes}
+
+	cmd := exec.CommandContext(ctx, plugin.Command, plugin.Args...)
+	cmd.Dir = workDir
+	cmd.Env = pluginEnv(plugin.Sandbox, workDir)
+	cmd.Stdin = bytes.NewReader(input)
+	cmd.Stdout = stdout
+	cmd.Stderr = stderr
+	cmd.WaitDelay = time.Second
+
+	start := time.Now()
+	err = cmd.Run()
+	logger := loggerFromContext(ctx).With("plugin", plugin.Name)
+	logger.Debug("plugin finished", "duration", time.Since(start), "output_bytes", stdout.buf.Len())
+
+	if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		return response, fmt.Errorf("plugin %s timed out after %s", plugin.Name, timeout)
+	}
+	if err != nil {
+		return response, fmt.Errorf("plugin %s failed: %w: %s", plugin.Name, err, strings.TrimSpace(stderr.buf.String()))
+	}
+	if stdout.truncated {
+		return response, fmt.Errorf("plugin %s output exceeds %dKB", plugin.Name, maxOutput>>10)
+	}
+	if stderr.buf.Len() > 0 {
+		logger.Debug("plugin stderr", "stderr", truncateForLog(stderr.buf.String(), 500))
+	}
+
+	if err := json.Unmarshal(stdout.buf.Bytes(), &response); err != nil {
+		return response, fmt.Errorf("plugin %s returned invalid json: %w", plugin.Name, err)
+	}
+	return response, nil
+}
+
+// registerPluginTools adds the enabled plugins to the workflow.
+func registerPluginTools(wm *WorkflowManager, config *Config) error {
+	if err := registerPlugins(config); err != nil {
+		return err
+	}
+
+	for _, p := range config.Plugins {
+		if !p.Enabled {
+			continue
+		}
+		tool := NewPluginTool(p)
+		if err := tool.SetParams(p.Parameters, config); err != nil {
+			return err
+		}
+		wm.AddTool(tool, p.Name)
+		slog.Debug("registered plugin tool", "tool", p.Name, "command", p.Command)
+	}
+	return nil
+}
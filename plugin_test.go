@@ -0,0 +1,76 @@
+// plugin_test.go
+package main
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestValidatePluginParams(t *testing.T) {
+	schema := map[string]PluginParam{
+		"project":     {Type: "string", Required: true},
+		"max_results": {Type: "number", Default: 5},
+	}
+
+	params, err := validatePluginParams(schema, map[string]interface{}{"enabled": true, "project": "OPS"})
+	require.NoError(t, err)
+	assert.Equal(t, map[string]interface{}{"project": "OPS", "max_results": 5}, params)
+
+	_, err = validatePluginParams(schema, map[string]interface{}{})
+	assert.ErrorContains(t, err, "project")
+
+	_, err = validatePluginParams(schema, map[string]interface{}{"project": 1})
+	assert.ErrorContains(t, err, "type string")
+
+	_, err = validatePluginParams(schema, map[string]interface{}{"project": "OPS", "other": true})
+	assert.ErrorContains(t, err, "unknown parameter")
+}
+
+func TestPluginToolProcess(t *testing.T) {
+	plugin := PluginConfig{
+		Name:    "echo",
+		Command: "sh",
+		Args:    []string{"-c", `read line; echo "{\"output\": \"$HOME\"}"`},
+	}
+	dir := t.TempDir()
+	tool := &PluginTool{plugin: plugin, workDir: dir}
+
+	out, err := tool.Process(context.Background(), "hello")
+	require.NoError(t, err)
+	assert.Equal(t, dir, out)
+
+	plugin.Args = []string{"-c", `echo '{"error": "no access"}'`}
+	tool.plugin = plugin
+	_, err = tool.Process(context.Background(), "hello")
+	assert.ErrorContains(t, err, "no access")
+
+	plugin.Args = []string{"-c", "sleep 5"}
+	plugin.Sandbox.TimeoutSeconds = 1
+	tool.plugin = plugin
+	_, err = tool.Process(context.Background(), "hello")
+	assert.ErrorContains(t, err, "timed out")
+}
+
+func TestConfigureTool(t *testing.T) {
+	config := &Config{
+		Tools: []ToolConfig{{Name: "websearch", Parameters: map[string]interface{}{"enabled": false, "top_n": 7}}},
+		Plugins: []PluginConfig{{
+			Name:    "echo",
+			Command: "cat",
+			Schema:  map[string]PluginParam{"greeting": {Type: "string", Default: "hi"}},
+		}},
+	}
+	assert.Equal(t, []string{"websearch", "echo"}, configuredToolNames(config))
+
+	search := &WebSearchTool{}
+	require.NoError(t, configureTool(search, "websearch", config))
+	assert.True(t, search.Enabled())
+	assert.Equal(t, 7, search.TopN)
+
+	plugin := NewPluginTool(config.Plugins[0])
+	require.NoError(t, configureTool(plugin, "echo", config))
+	assert.Equal(t, "hi", plugin.GetParams()["greeting"])
+}
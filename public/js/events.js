@@ -51,3 +51,82 @@ document.getElementById("scroll-to-bottom-btn").addEventListener("click", functi
     userHasScrolled = false;
     this.style.display = 'none';
 });
+
+// Voice input. The mic button records audio until it is clicked again, then the recording is
+// transcribed by /v1/audio/transcriptions and appended to the prompt.
+var mediaRecorder = null;
+
+document.getElementById("mic").addEventListener("click", async function () {
+    const micBtn = this;
+
+    if (mediaRecorder && mediaRecorder.state === 'recording') {
+        mediaRecorder.stop();
+        return;
+    }
+
+    let stream;
+    try {
+        stream = await navigator.mediaDevices.getUserMedia({ audio: true });
+    } catch (err) {
+        console.error('Microphone access denied:', err);
+        return;
+    }
+
+    const chunks = [];
+    mediaRecorder = new MediaRecorder(stream);
+    mediaRecorder.addEventListener('dataavailable', (event) => chunks.push(event.data));
+    mediaRecorder.addEventListener('stop', async () => {
+        stream.getTracks().forEach((track) => track.stop());
+        micBtn.classList.remove('btn-danger');
+
+        const form = new FormData();
+        form.append('file', new Blob(chunks, { type: mediaRecorder.mimeType }), 'recording.webm');
+
+        const response = await fetch('/v1/audio/transcriptions', { method: 'POST', body: form });
+        const data = await response.json();
+        if (!response.ok) {
+            console.error('Transcription failed:', data.error);
+            return;
+        }
+
+        textarea.value = textarea.value ? `${textarea.value} ${data.text.trim()}` : data.text.trim();
+        textarea.dispatchEvent(new Event('input'));
+        textarea.focus();
+    });
+
+    mediaRecorder.start();
+    micBtn.classList.add('btn-danger');
+});
+
+// Image input. Attached images are uploaded to /v1/images right away and their ids are sent with
+// the next message, which passes them to the model as image content parts.
+var imageIds = [];
+
+function clearImages() {
+    imageIds = [];
+    document.getElementById("image-ids").value = '';
+    document.getElementById("upload").classList.remove('btn-info');
+}
+
+document.getElementById("upload").addEventListener("click", function () {
+    document.getElementById("file-input").click();
+});
+
+document.getElementById("file-input").addEventListener("change", async function () {
+    for (const file of this.files) {
+        const form = new FormData();
+        form.append('image', file);
+
+        const response = await fetch('/v1/images', { method: 'POST', body: form });
+        const data = await response.json();
+        if (!response.ok) {
+            console.error('Image upload failed:', data.error);
+            continue;
+        }
+        imageIds.push(data.id);
+    }
+    this.value = '';
+
+    document.getElementById("image-ids").value = imageIds.join(',');
+    document.getElementById("upload").classList.toggle('btn-info', imageIds.length > 0);
+});
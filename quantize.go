@@ -0,0 +1,182 @@
+// manifold/quantize.go
+
+package main
+
+import (
+	"bufio"
+	"io"
+	"math"
+	"os"
+	"sort"
+)
+
+// QuantizationConfig enables storing the embeddings of a DB in memory as int8 vectors with a scale
+// factor each, a quarter of the memory of float32 vectors and an eighth of float64 ones. The exact
+// vectors are kept in the embeddings file only, once saved.
+type QuantizationConfig struct {
+	// Rescore is the number of candidates per result of a search, ranked by quantized similarity,
+	// re-scored with their exact vectors read from the file. 0 ranks by quantized similarity only.
+	Rescore int `yaml:"rescore,omitempty" json:"rescore"`
+}
+
+// quantizeVector returns the vector scaled to int8 values, and the scale factor of the values.
+func quantizeVector(vec []float64) ([]int8, float32) {
+	var maxAbs float64
+	for _, value := range vec {
+		maxAbs = max(maxAbs, math.Abs(value))
+	}
+	quantized := make([]int8, len(vec))
+	if maxAbs == 0 {
+		return quantized, 0
+	}
+	scale := maxAbs / 127
+	for i, value := range vec {
+		quantized[i] = int8(math.Round(value / scale))
+	}
+	return quantized, float32(scale)
+}
+
+// dequantizeVector returns the approximate vector of int8 values.
+func dequantizeVector(quantized []int8, scale float32) []float64 {
+	vec := make([]float64, len(quantized))
+	for i, value := range quantized {
+		vec[i] = float64(value) * float64(scale)
+	}
+	return vec
+}
+
+// quantizedSimilarity returns the cosine similarity of the query vector, of norm queryNorm, and a
+// quantized vector, 0 when it has no magnitude. The scale factor cancels out.
+func quantizedSimilarity(query []float64, queryNorm float64, quantized []int8) float64 {
+	var dot float64
+	var sumSquares int64
+	for i, value := range quantized {
+		dot += query[i] * float64(value)
+		sumSquares += int64(value) * int64(value)
+	}
+	if sumSquares == 0 {
+		return 0
+	}
+	return dot / (queryNorm * math.Sqrt(float64(sumSquares)))
+}
+
+// EnableQuantization quantizes the embeddings of the DB and releases the exact vectors of those
+// saved to its file. Embeddings added later are quantized too, their exact vectors released once
+// saved.
+func (db *EmbeddingDB) EnableQuantization(config QuantizationConfig) {
+	db.quantization = &config
+	for word, embedding := range db.Embeddings {
+		if embedding.Quantized == nil {
+			embedding.Quantized, embedding.Scale = quantizeVector(embedding.Vector)
+			db.Embeddings[word] = embedding
+		}
+	}
+	words := make([]string, 0, len(db.Embeddings))
+	for word := range db.Embeddings {
+		words = append(words, word)
+	}
+	db.releaseVectors(words)
+}
+
+// releaseVectors drops the exact vectors of the quantized embeddings among words saved to the
+// file unchanged since.
+func (db *EmbeddingDB) releaseVectors(words []string) {
+	if db.quantization == nil || db.file == nil {
+		return
+	}
+	for _, word := range words {
+		embedding, ok := db.Embeddings[word]
+		if _, dirty := db.dirty[word]; !ok || dirty || embedding.Quantized == nil || embedding.Vector == nil {
+			continue
+		}
+		if _, saved := db.file.offsets[word]; saved {
+			embedding.Vector = nil
+			db.Embeddings[word] = embedding
+		}
+	}
+}
+
+// exactVectors returns the exact vectors of the words, those released read from the file. The
+// vectors that can't be read are dequantized.
+func (db *EmbeddingDB) exactVectors(words []string) map[string][]float64 {
+	vectors := make(map[string][]float64, len(words))
+	var released []string
+	for _, word := range words {
+		embedding, ok := db.Embeddings[word]
+		if !ok {
+			continue
+		}
+		if embedding.Vector != nil {
+			vectors[word] = embedding.Vector
+		} else {
+			released = append(released, word)
+		}
+	}
+	if len(released) == 0 {
+		return vectors
+	}
+
+	var f *os.File
+	if db.file != nil {
+		f, _ = os.Open(db.file.path)
+	}
+	if f != nil {
+		defer f.Close()
+		// Read in file order
+		sort.Slice(released, func(i, j int) bool { return db.file.offsets[released[i]] < db.file.offsets[released[j]] })
+	}
+	for _, word := range released {
+		if f != nil {
+			if offset, ok := db.file.offsets[word]; ok {
+				r := bufio.NewReader(io.NewSectionReader(f, offset, db.file.size-offset))
+				if op, embedding, _, err := readEmbeddingRecord(r, db.file.version); err == nil && op == recordPut {
+					vectors[word] = embedding.Vector
+					continue
+				}
+			}
+		}
+		embedding := db.Embeddings[word]
+		vectors[word] = dequantizeVector(embedding.Quantized, embedding.Scale)
+	}
+	return vectors
+}
+
+// exactVectorBatch is the number of exact vectors forEachVector reads at once.
+const exactVectorBatch = 1024
+
+// forEachVector calls fn with the exact vector of each embedding, reading those released from the
+// file in batches.
+func (db *EmbeddingDB) forEachVector(fn func(word string, vector []float64)) {
+	var released []string
+	flush := func() {
+		for word, vector := range db.exactVectors(released) {
+			fn(word, vector)
+		}
+		released = released[:0]
+	}
+	for word, embedding := range db.Embeddings {
+		if embedding.Vector != nil || embedding.Quantized == nil {
+			fn(word, embedding.Vector)
+			continue
+		}
+		if released = append(released, word); len(released) == exactVectorBatch {
+			flush()
+		}
+	}
+	flush()
+}
+
+// restoreVectors reads back the exact vectors of all the embeddings.
+func (db *EmbeddingDB) restoreVectors() {
+	var released []string
+	for word, embedding := range db.Embeddings {
+		if embedding.Vector == nil && embedding.Quantized != nil {
+			released = append(released, word)
+		}
+	}
+	for word, vector := range db.exactVectors(released) {
+		embedding := db.Embeddings[word]
+		embedding.Vector = vector
+		db.Embeddings[word] = embedding
+	}
+}
@@ -0,0 +1,99 @@
+// quantize_test.go
+package main
+
+import (
+	"fmt"
+	"math/rand"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"manifold/internal/hnsw"
+)
+
+func TestQuantizedEmbeddings(t *testing.T) {
+	rng := rand.New(rand.NewSource(4))
+	vector := func() []float64 {
+		v := make([]float64, 32)
+		for i := range v {
+			v[i] = rng.NormFloat64()
+		}
+		return v
+	}
+
+	quantized, scale := quantizeVector([]float64{0.5, -1, 0.25, 0})
+	assert.Equal(t, []int8{64, -127, 32, 0}, quantized)
+	for i, value := range dequantizeVector(quantized, scale) {
+		assert.InDelta(t, []float64{0.5, -1, 0.25, 0}[i], value, float64(scale)/2)
+	}
+
+	path := filepath.Join(t.TempDir(), "embeddings.db")
+	edb := NewEmbeddingDB()
+	for i := 0; i < 3000; i++ {
+		edb.AddEmbedding(Embeddings{Word: fmt.Sprintf("w%d", i), Vector: vector()})
+	}
+	require.NoError(t, edb.SaveEmbeddings(path))
+	exact := NewEmbeddingDB()
+	exact.Embeddings, _ = exact.LoadEmbeddings(path)
+
+	edb.EnableQuantization(QuantizationConfig{Rescore: 4})
+	for _, embedding := range edb.Embeddings {
+		require.Nil(t, embedding.Vector, embedding.Word)
+		require.Len(t, embedding.Quantized, 32)
+	}
+	stored, ok := edb.RetrieveEmbedding("w5")
+	require.True(t, ok)
+	assert.Equal(t, exact.Embeddings["w5"].Vector, stored)
+
+	// Re-scored results carry exact vectors and similarities
+	for q := 0; q < 10; q++ {
+		query := vector()
+		expected := exact.FindTopNSimilarEmbeddings(query, 10)
+		top := edb.FindTopNSimilarEmbeddings(query, 10)
+		require.Len(t, top, 10)
+		found := 0
+		for i, embedding := range top {
+			assert.Equal(t, exact.Embeddings[embedding.Word].Vector, embedding.Vector)
+			assert.InDelta(t, CosineSimilarity(query, embedding.Vector), embedding.Similarity, 1e-9)
+			if embedding.Word == expected[i].Word {
+				found++
+			}
+		}
+		assert.GreaterOrEqual(t, found, 9)
+	}
+
+	// Without re-scoring, similarities are approximate
+	edb.quantization.Rescore = 0
+	query := vector()
+	for _, embedding := range edb.FindTopNSimilarEmbeddings(query, 5) {
+		assert.InDelta(t, CosineSimilarity(query, embedding.Vector), embedding.Similarity, 0.02)
+	}
+
+	// Exact vectors of new embeddings are kept until saved
+	added := vector()
+	edb.AddEmbedding(Embeddings{Word: "added", Vector: added})
+	assert.Equal(t, added, edb.Embeddings["added"].Vector)
+	require.NoError(t, edb.SaveEmbeddings(path))
+	assert.Nil(t, edb.Embeddings["added"].Vector)
+	stored, _ = edb.RetrieveEmbedding("added")
+	assert.Equal(t, added, stored)
+
+	// The index is built from the exact vectors
+	edb.EnableIndex(hnsw.Config{})
+	assert.Equal(t, 3001, edb.Index.Len())
+	top := edb.SearchEmbeddings(added, 1)
+	require.Len(t, top, 1)
+	assert.Equal(t, "added", top[0].Word)
+	assert.Equal(t, added, top[0].Vector)
+
+	// Saving to another file writes the exact vectors
+	other := filepath.Join(t.TempDir(), "other.db")
+	require.NoError(t, edb.SaveEmbeddings(other))
+	copied, err := NewEmbeddingDB().LoadEmbeddings(other)
+	require.NoError(t, err)
+	require.Len(t, copied, 3001)
+	assert.Equal(t, exact.Embeddings["w7"].Vector, copied["w7"].Vector)
+	assert.Nil(t, edb.Embeddings["w7"].Vector)
+}
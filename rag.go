@@ -2,17 +2,162 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"io"
-	"log"
+	"log/slog"
 	"net/http"
 	"os"
 	"path/filepath"
+	"strconv"
+	"strings"
 
-	index "github.com/blevesearch/bleve_index_api"
 	"github.com/labstack/echo/v4"
+
+	"manifold/internal/documents"
+	"manifold/internal/ingest"
 )
 
+// defaultMaxUploadMB is the document upload limit when max_upload_mb is not set.
+const defaultMaxUploadMB = 50
+
+// maxUploadBytes returns the configured document upload limit.
+func maxUploadBytes(config *Config) int64 {
+	mb := config.MaxUploadMB
+	if mb <= 0 {
+		mb = defaultMaxUploadMB
+	}
+	return int64(mb) << 20
+}
+
+// defaultChunkSize is the size documents are split with when the config doesn't set one.
+const defaultChunkSize = 2048
+
+// DocumentsConfig sets the sizes documents are split with before they are indexed, by default and
+// for each type of document: code, pdf, web or chat. Retrieval quality is sensitive to them.
+type DocumentsConfig struct {
+	ChunkSize   int                             `yaml:"chunk_size,omitempty" json:"chunk_size"` // defaultChunkSize when unset
+	OverlapSize int                             `yaml:"overlap_size,omitempty" json:"overlap_size"`
+	Types       map[string]documents.ChunkSizes `yaml:"types,omitempty" json:"types"`
+	Semantic    SemanticChunkingConfig          `yaml:"semantic,omitempty" json:"-"`
+
+	// NearDuplicateDistance, when positive, skips chunks whose simhash is at most this many bits
+	// from that of a chunk indexed, up to 3. Exact duplicates are always skipped.
+	NearDuplicateDistance int `yaml:"near_duplicate_distance,omitempty" json:"-"`
+
+	OCR OCRConfig `yaml:"ocr,omitempty" json:"-"`
+
+	// Webhooks are notified when an ingestion job finishes or fails.
+	Webhooks []WebhookConfig `yaml:"webhooks,omitempty" json:"-"`
+}
+
+// OCRConfig recognizes the scanned pages of PDFs, those with little text, with the tesseract
+// command or, when an endpoint is set, an OCR API.
+type OCRConfig struct {
+	Enabled    bool     `yaml:"enabled"`
+	Endpoint   string   `yaml:"endpoint,omitempty"` // OCR API, e.g. a tesseract server run as a service
+	APIKey     string   `yaml:"api_key,omitempty"`
+	Command    string   `yaml:"command,omitempty"`    // tesseract when empty
+	Rasterizer string   `yaml:"rasterizer,omitempty"` // pdftoppm when empty
+	DPI        int      `yaml:"dpi,omitempty"`
+	Languages  []string `yaml:"languages,omitempty"`          // when the script of a page isn't detected, eng when empty
+	MinChars   int      `yaml:"min_chars_per_page,omitempty"` // pages with less text are recognized
+}
+
+// newOCR returns the OCR of the config, nil when it is disabled.
+func newOCR(config OCRConfig) documents.OCR {
+	switch {
+	case !config.Enabled:
+		return nil
+	case config.Endpoint != "":
+		return &documents.HTTPOCR{URL: config.Endpoint, APIKey: config.APIKey}
+	default:
+		return &documents.TesseractOCR{Command: config.Command, Rasterizer: config.Rasterizer, DPI: config.DPI, Languages: config.Languages}
+	}
+}
+
+// SemanticChunkingConfig splits the documents of some types on semantic breakpoints, found with the
+// embeddings of their sentences, instead of character counts.
+type SemanticChunkingConfig struct {
+	Types      []string `yaml:"types,omitempty"`      // disabled when empty, e.g. [pdf, web]
+	Percentile float64  `yaml:"percentile,omitempty"` // of sentence distances a chunk ends above, 95 when unset
+	Buffer     int      `yaml:"buffer,omitempty"`     // sentences on each side embedded with each sentence, 1 when unset
+	MinSize    int      `yaml:"min_chunk_size,omitempty"`
+	MaxSize    int      `yaml:"max_chunk_size,omitempty"` // the chunk size of the type when unset
+}
+
+// newDocChunker returns the chunker splitting documents of the semantic types with a
+// SemanticChunker embedding through the backend, and the others with the splitter of dm. It is nil
+// when semantic chunking is disabled.
+func newDocChunker(config DocumentsConfig, dm *documents.DocumentManager) ingest.Chunker {
+	if len(config.Semantic.Types) == 0 {
+		return nil
+	}
+	embedder := ingest.EmbedderFunc(func(ctx context.Context, texts []string) ([][]float32, error) {
+		embeddings := make([][]float32, len(texts))
+		for i, text := range texts {
+			embedding, err := GenerateEmbedding(ctx, text)
+			if err != nil {
+				return nil, err
+			}
+			embeddings[i] = make([]float32, len(embedding))
+			for j, value := range embedding {
+				embeddings[i][j] = float32(value)
+			}
+		}
+		return embeddings, nil
+	})
+
+	chunker := ingest.DocTypeChunker{Default: ingest.SplitterChunker{Sizes: dm.ChunkSizesFor}, Types: map[string]ingest.Chunker{}}
+	for _, docType := range config.Semantic.Types {
+		semantic := ingest.NewSemanticChunker(embedder)
+		if config.Semantic.Percentile > 0 {
+			semantic.Percentile = config.Semantic.Percentile
+		}
+		if config.Semantic.Buffer > 0 {
+			semantic.Buffer = config.Semantic.Buffer
+		}
+		semantic.MinSize = config.Semantic.MinSize
+		semantic.MaxSize = config.Semantic.MaxSize
+		if semantic.MaxSize <= 0 {
+			semantic.MaxSize = dm.ChunkSizesFor(map[string]string{"doc_type": docType}).ChunkSize
+		}
+		chunker.Types[docType] = semantic
+	}
+	return chunker
+}
+
+// defaults returns the default chunk sizes.
+func (c DocumentsConfig) defaults() documents.ChunkSizes {
+	if c.ChunkSize <= 0 {
+		return documents.ChunkSizes{ChunkSize: defaultChunkSize, OverlapSize: c.OverlapSize}
+	}
+	return documents.ChunkSizes{ChunkSize: c.ChunkSize, OverlapSize: c.OverlapSize}
+}
+
+// handleGetChunking returns the chunk sizes documents are split with.
+func handleGetChunking(c echo.Context) error {
+	defaults, types := docManager.Chunking()
+	return c.JSON(http.StatusOK, DocumentsConfig{ChunkSize: defaults.ChunkSize, OverlapSize: defaults.OverlapSize, Types: types})
+}
+
+// handleSetChunking changes the chunk sizes documents are split with from then on. Documents already
+// indexed keep their chunks until they are split again.
+func handleSetChunking(c echo.Context, config *Config) error {
+	var req DocumentsConfig
+	if err := c.Bind(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid request body"})
+	}
+	if err := docManager.SetChunking(documents.ChunkSizes{ChunkSize: req.ChunkSize, OverlapSize: req.OverlapSize}, req.Types); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+	}
+	req.Semantic, req.NearDuplicateDistance = config.Documents.Semantic, config.Documents.NearDuplicateDistance
+	req.OCR, req.Webhooks = config.Documents.OCR, config.Documents.Webhooks
+	config.Documents = req
+	return handleGetChunking(c)
+}
+
 type RagRequest struct {
 	Text string `json:"text"`
 	TopN int    `json:"top_n"`
@@ -26,13 +171,9 @@ func handleQueryDocuments(c echo.Context) error {
 		return c.JSON(http.StatusBadRequest, err)
 	}
 
-	// Use IndexManager to create a search request based on input
-	searchRequest := indexManager.CreateSearchRequest(req.Text, req.TopN)
-
-	// Perform the search using IndexManager
-	results, err := indexManager.SearchChunks(searchRequest)
+	chunks, err := retriever.Retrieve(c.Request().Context(), req.Text, req.TopN)
 	if err != nil {
-		return c.JSON(http.StatusInternalServerError, err)
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": err.Error()})
 	}
 
 	// Prepare a response structure to hold results
@@ -41,42 +182,16 @@ func handleQueryDocuments(c echo.Context) error {
 		Score    float64 `json:"score"`
 		Prompt   string  `json:"prompt"`
 		Response string  `json:"response"`
+		Citation string  `json:"citation"`
 	}
-	var searchResults []SearchResult
-
-	// Iterate over the search hits and retrieve the prompt and response fields
-	for _, hit := range results.Hits {
-		doc, err := indexManager.GetDocument(hit.ID)
-		if err != nil {
-			fmt.Printf("Error retrieving document %s: %v\n", hit.ID, err)
-			continue
-		}
-
-		var response string
-
-		// Use a FieldVisitor from the index package
-		doc.VisitFields(func(field index.Field) {
-			fieldName := field.Name()
-			fieldValue := string(field.Value())
-
-			if fieldName == "chunk" {
-				response += fieldValue + " "
-			} else if fieldName == "full_content" {
-
-				// print only the first 1000 characters of the full content
-				log.Printf("Full content: %s", fieldValue[:1000])
-
-				response += fieldValue
-			}
-
-		})
-
-		// Append the search result to the response slice
+	searchResults := make([]SearchResult, 0, len(chunks))
+	for _, doc := range chunks {
 		searchResults = append(searchResults, SearchResult{
-			ID:       hit.ID,
-			Score:    hit.Score,
+			ID:       doc.ID,
+			Score:    doc.Score,
 			Prompt:   req.Text,
-			Response: response,
+			Response: doc.Text,
+			Citation: doc.citation(),
 		})
 	}
 
@@ -84,21 +199,58 @@ func handleQueryDocuments(c echo.Context) error {
 	return c.JSON(http.StatusOK, searchResults)
 }
 
+// handleGitIngest clones or syncs a Git repository and ingests its files. The parameters, in the
+// query or a form body, are the clone_url, at most one of the branch, tag or commit to check out, the
+// clone depth and whether to include submodules. A personal access token for HTTPS clone URLs is
+// read from the X-Git-Token header or the token of a form body, never from the query, which is
+// logged.
 func handleGitIngest(c echo.Context) error {
 	if docManager == nil {
 		return c.JSON(http.StatusInternalServerError, "DocumentManager is not initialized")
 	}
 
-	cloneURL := c.QueryParam("clone_url")
+	cloneURL := c.FormValue("clone_url")
 	if cloneURL == "" {
 		return c.JSON(http.StatusBadRequest, "clone_url is required")
 	}
 
-	branch := c.QueryParam("branch")
-	repoPath := "/tmp/git_repo"
+	repoPath := documents.ClonePath(filepath.Join(os.TempDir(), "git_repos"), cloneURL)
 	privateKeyPath := ""
+	loader := documents.NewGitLoader(repoPath, cloneURL, c.FormValue("branch"), privateKeyPath, nil, false, docManager, docManager.IndexManager)
+	loader.Tag = c.FormValue("tag")
+	loader.Commit = c.FormValue("commit")
+	pins := 0
+	for _, ref := range []string{loader.Branch, loader.Tag, loader.Commit} {
+		if ref != "" {
+			pins++
+		}
+	}
+	if pins > 1 {
+		return c.JSON(http.StatusBadRequest, "only one of branch, tag and commit can be set")
+	}
+	loader.Username = c.FormValue("username")
+	loader.Token = c.Request().Header.Get("X-Git-Token")
+	if loader.Token == "" {
+		loader.Token = c.Request().PostFormValue("token")
+	}
+	if depth := c.FormValue("depth"); depth != "" {
+		n, err := strconv.Atoi(depth)
+		if err != nil || n < 0 {
+			return c.JSON(http.StatusBadRequest, fmt.Sprintf("invalid depth %q", depth))
+		}
+		loader.Depth = n
+	}
+	if submodules := c.FormValue("submodules"); submodules != "" {
+		recurse, err := strconv.ParseBool(submodules)
+		if err != nil {
+			return c.JSON(http.StatusBadRequest, fmt.Sprintf("invalid submodules %q", submodules))
+		}
+		loader.Submodules = recurse
+	}
 
-	err := docManager.IngestGitRepo(repoPath, cloneURL, branch, privateKeyPath, nil, false)
+	err := loader.Load()
+	ingested, removed := loader.Changes()
+	notifyIngest(IngestEvent{Job: "git", Source: cloneURL, Documents: ingested, Removed: removed}, err)
 	if err != nil {
 		return c.JSON(http.StatusInternalServerError, fmt.Sprintf("Failed to load Git repository: %s", err))
 	}
@@ -115,6 +267,10 @@ func handlePDFIngest(c echo.Context) error {
 
 	// Parse the uploaded file
 	file, err := c.FormFile("pdf")
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		return c.JSON(http.StatusRequestEntityTooLarge, fmt.Sprintf("Uploaded file is larger than %d bytes", tooLarge.Limit))
+	}
 	if err != nil {
 		return c.JSON(http.StatusBadRequest, "Error parsing uploaded file")
 	}
@@ -137,7 +293,12 @@ func handlePDFIngest(c echo.Context) error {
 		return c.JSON(http.StatusInternalServerError, "Failed to save uploaded file")
 	}
 
-	err = docManager.IngestPDF(savePath)
+	err = docManager.IngestPDF(c.Request().Context(), savePath)
+	event := IngestEvent{Job: "pdf", Source: file.Filename}
+	if err == nil {
+		event.Documents = 1
+	}
+	notifyIngest(event, err)
 	if err != nil {
 		return c.JSON(http.StatusInternalServerError, fmt.Sprintf("Failed to process PDF: %s", err))
 	}
@@ -147,9 +308,14 @@ func handlePDFIngest(c echo.Context) error {
 
 // handleSplitDocuments splits the content of all ingested documents and indexes them.
 func handleSplitDocuments(c echo.Context) error {
-	fmt.Println("Starting document splitting process...")
+	slog.Info("starting document splitting process")
 
-	splits, err := docManager.SplitAndIndexDocuments()
+	splits, err := ingest.SplitDocuments(c.Request().Context(), docManager, docChunker)
+	event := IngestEvent{Job: "split", Documents: len(splits)}
+	for _, chunks := range splits {
+		event.Chunks += len(chunks)
+	}
+	notifyIngest(event, err)
 	if err != nil {
 		return c.JSON(http.StatusInternalServerError, fmt.Sprintf("Failed to split documents: %s", err))
 	}
@@ -162,3 +328,130 @@ func handleSplitDocuments(c echo.Context) error {
 
 	return c.String(http.StatusOK, result)
 }
+
+// handleCollectGarbage deletes the stale chunks of the index, with their embeddings: those of
+// documents no longer indexed and those past the chunks of the current version of their document.
+func handleCollectGarbage(c echo.Context) error {
+	if docManager == nil || docManager.IndexManager == nil {
+		return c.JSON(http.StatusServiceUnavailable, map[string]string{"error": "document store is not initialized"})
+	}
+
+	removed, err := docManager.IndexManager.CollectGarbage(c.Request().Context())
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": err.Error()})
+	}
+	if err := db.DeleteChunkVectors(c.Request().Context(), removed); err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": err.Error()})
+	}
+	slog.Info("collected stale chunks", "removed", len(removed))
+	return c.JSON(http.StatusOK, map[string]interface{}{"removed": len(removed), "chunks": removed})
+}
+
+// maxStoreDocuments is the largest batch of documents /v1/store-documents accepts.
+const maxStoreDocuments = 500
+
+// StoreDocument is a document to store, with metadata such as the path, repository and commit it
+// comes from.
+type StoreDocument struct {
+	Content  string            `json:"content"`
+	Metadata map[string]string `json:"metadata"`
+}
+
+// StoreDocumentsRequest is a batch of documents to store.
+type StoreDocumentsRequest struct {
+	Documents []StoreDocument `json:"documents"`
+}
+
+// StoreDocumentsResponse summarizes a batch: the result of each document in order, so clients can
+// retry the failed ones alone.
+type StoreDocumentsResponse struct {
+	Stored  int                  `json:"stored"`
+	Failed  int                  `json:"failed"`
+	Results []ingest.StoreResult `json:"results"`
+}
+
+// storeDocumentSource returns the source of a stored document, its key in the index: the source of
+// its metadata, else its path prefixed by its repository. Documents with neither are keyed by the
+// hash of their content.
+func storeDocumentSource(metadata map[string]string) string {
+	if source := metadata["source"]; source != "" {
+		return source
+	}
+	path := metadata["path"]
+	if path == "" {
+		return ""
+	}
+	if repo := metadata["repo"]; repo != "" {
+		return repo + "/" + path
+	}
+	return path
+}
+
+// handleStoreDocuments splits and indexes a batch of documents. It responds 207 Multi-Status when
+// some of them failed.
+func handleStoreDocuments(c echo.Context) error {
+	if docManager == nil || docManager.IndexManager == nil {
+		return c.JSON(http.StatusServiceUnavailable, map[string]string{"error": "document store is not initialized"})
+	}
+
+	req := new(StoreDocumentsRequest)
+	if err := c.Bind(req); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "invalid request body"})
+	}
+	if len(req.Documents) == 0 {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "documents are required"})
+	}
+	if len(req.Documents) > maxStoreDocuments {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": fmt.Sprintf("at most %d documents can be stored at once", maxStoreDocuments)})
+	}
+
+	docs := make([]documents.Document, len(req.Documents))
+	seen := make(map[string]bool, len(req.Documents))
+	for i, doc := range req.Documents {
+		if strings.TrimSpace(doc.Content) == "" {
+			return c.JSON(http.StatusBadRequest, map[string]string{"error": fmt.Sprintf("document %d has no content", i)})
+		}
+		metadata := make(map[string]string, len(doc.Metadata)+2)
+		for key, value := range doc.Metadata {
+			metadata[key] = value
+		}
+		if source := storeDocumentSource(metadata); source != "" {
+			metadata["source"] = source
+			if metadata["file_path"] == "" {
+				metadata["file_path"] = metadata["path"]
+			}
+		}
+		docs[i] = documents.Document{PageContent: doc.Content, Metadata: metadata}
+
+		key := documents.DocumentKey(docs[i])
+		if seen[key] {
+			return c.JSON(http.StatusBadRequest, map[string]string{"error": fmt.Sprintf("document %d duplicates %s", i, key)})
+		}
+		seen[key] = true
+	}
+
+	results, err := storeDocuments(c.Request().Context(), docs)
+	if err != nil {
+		notifyIngest(IngestEvent{Job: "store"}, err)
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": err.Error()})
+	}
+
+	resp := StoreDocumentsResponse{Results: results}
+	chunks := 0
+	for _, result := range results {
+		if result.Error != "" {
+			resp.Failed++
+		} else {
+			resp.Stored++
+			chunks += result.Chunks
+		}
+	}
+	slog.Info("stored documents", "stored", resp.Stored, "failed", resp.Failed)
+	notifyIngest(IngestEvent{Job: "store", Documents: resp.Stored, Chunks: chunks, Failed: resp.Failed}, nil)
+
+	status := http.StatusOK
+	if resp.Failed > 0 {
+		status = http.StatusMultiStatus
+	}
+	return c.JSON(status, resp)
+}
@@ -0,0 +1,119 @@
+// rag_test.go
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"manifold/internal/documents"
+)
+
+func TestHandleStoreDocuments(t *testing.T) {
+	index, err := documents.NewIndexManager(filepath.Join(t.TempDir(), "index"))
+	require.NoError(t, err)
+	defer index.Index.Close()
+
+	saved := docManager
+	docManager = documents.NewDocumentManager(2048, 0, index)
+	defer func() { docManager = saved }()
+
+	e := echo.New()
+	store := func(body string) (int, StoreDocumentsResponse) {
+		req := httptest.NewRequest(http.MethodPost, "/v1/store-documents", strings.NewReader(body))
+		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		rec := httptest.NewRecorder()
+		require.NoError(t, handleStoreDocuments(e.NewContext(req, rec)))
+		var resp StoreDocumentsResponse
+		json.Unmarshal(rec.Body.Bytes(), &resp)
+		return rec.Code, resp
+	}
+
+	code, resp := store(`{"documents": [
+		{"content": "package main\n\nfunc main() {}\n", "metadata": {"path": "main.go", "repo": "manifold", "commit": "abc123"}},
+		{"content": "The cat sleeps all day.", "metadata": {"path": "cats.md"}}
+	]}`)
+	require.Equal(t, http.StatusOK, code)
+	assert.Equal(t, StoreDocumentsResponse{Stored: 2, Results: resp.Results}, resp)
+	assert.Equal(t, "manifold/main.go", resp.Results[0].ID)
+	assert.Equal(t, "cats.md", resp.Results[1].ID)
+	assert.Equal(t, 1, resp.Results[1].Chunks)
+
+	// The metadata is kept with the documents
+	stored := docManager.Documents[0]
+	assert.Equal(t, "abc123", stored.Metadata["commit"])
+	assert.Equal(t, "main.go", stored.Metadata["file_path"])
+
+	chunks, err := (&ftsRetriever{index: index}).Retrieve(context.Background(), "cat", 1)
+	require.NoError(t, err)
+	require.Len(t, chunks, 1)
+	assert.Equal(t, "cats.md-0", chunks[0].ID)
+
+	code, _ = store(`{"documents": []}`)
+	assert.Equal(t, http.StatusBadRequest, code)
+	code, _ = store(`{"documents": [{"content": " ", "metadata": {"path": "empty.md"}}]}`)
+	assert.Equal(t, http.StatusBadRequest, code)
+	code, _ = store(`{"documents": [{"content": "a", "metadata": {"path": "a.md"}}, {"content": "b", "metadata": {"path": "a.md"}}]}`)
+	assert.Equal(t, http.StatusBadRequest, code)
+
+	// Storing a document again replaces its chunks with a new version
+	long := strings.Repeat("The cat chases the mouse around the house. ", 100)
+	code, resp = store(`{"documents": [{"content": "` + long + `", "metadata": {"path": "cats.md"}}]}`)
+	require.Equal(t, http.StatusOK, code)
+	assert.Equal(t, 2, resp.Results[0].Version)
+	assert.Greater(t, resp.Results[0].Chunks, 1)
+	code, resp = store(`{"documents": [{"content": "The cat naps.", "metadata": {"path": "cats.md"}}]}`)
+	require.Equal(t, http.StatusOK, code)
+	assert.Equal(t, 3, resp.Results[0].Version)
+	doc, err := index.GetDocument("cats.md-1")
+	require.NoError(t, err)
+	assert.Nil(t, doc)
+	assert.Len(t, docManager.Documents, 2)
+
+	// Chunks of documents with pages and headings, like PDFs, are cited with them
+	code, _ = store(`{"documents": [{"content": "<!-- page 1 -->\n\n# Pets\n\n<!-- page 2 -->\n\n## Dogs\n\nThe dog fetches the ball.", "metadata": {"path": "pets.pdf", "language": "MARKDOWN"}}]}`)
+	require.Equal(t, http.StatusOK, code)
+	chunks, err = (&ftsRetriever{index: index}).Retrieve(context.Background(), "fetches", 1)
+	require.NoError(t, err)
+	require.Len(t, chunks, 1)
+	assert.Equal(t, "pets.pdf, page 1", chunks[0].citation())
+}
+
+func TestHandleChunking(t *testing.T) {
+	saved := docManager
+	docManager = documents.NewDocumentManager(2048, 0, nil)
+	defer func() { docManager = saved }()
+	config := &Config{}
+
+	e := echo.New()
+	set := func(body string) (int, DocumentsConfig) {
+		req := httptest.NewRequest(http.MethodPut, "/v1/documents/chunking", strings.NewReader(body))
+		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		rec := httptest.NewRecorder()
+		require.NoError(t, handleSetChunking(e.NewContext(req, rec), config))
+		var resp DocumentsConfig
+		json.Unmarshal(rec.Body.Bytes(), &resp)
+		return rec.Code, resp
+	}
+
+	code, resp := set(`{"chunk_size": 1000, "overlap_size": 100, "types": {"code": {"chunk_size": 400, "overlap_size": 50}}}`)
+	require.Equal(t, http.StatusOK, code)
+	want := DocumentsConfig{ChunkSize: 1000, OverlapSize: 100, Types: map[string]documents.ChunkSizes{"code": {ChunkSize: 400, OverlapSize: 50}}}
+	assert.Equal(t, want, resp)
+	assert.Equal(t, want, config.Documents)
+	assert.Equal(t, documents.ChunkSizes{ChunkSize: 400, OverlapSize: 50}, docManager.ChunkSizesFor(map[string]string{"file_type": ".py"}))
+
+	code, _ = set(`{"chunk_size": 100, "overlap_size": 200}`)
+	assert.Equal(t, http.StatusBadRequest, code)
+	assert.Equal(t, want, config.Documents)
+
+	assert.Equal(t, documents.ChunkSizes{ChunkSize: defaultChunkSize, OverlapSize: 10}, DocumentsConfig{OverlapSize: 10}.defaults())
+}
@@ -0,0 +1,526 @@
+// manifold/rageval.go
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/blevesearch/bleve/v2"
+	"github.com/labstack/echo/v4"
+	"gopkg.in/yaml.v2"
+
+	"manifold/internal/documents"
+	"manifold/internal/hnsw"
+)
+
+const (
+	RAGFTS      = "fts"
+	RAGVector   = "vector"
+	RAGHybrid   = "hybrid"
+	RAGReranked = "reranked"
+
+	defaultRAGEvalK          = 5
+	defaultRAGEvalCandidates = 20
+	ragEmbedBatch            = 32
+	ragEmbedMaxChars         = 4000 // longer chunks are cut before they are embedded
+	rrfK                     = 60   // the usual constant of reciprocal rank fusion
+)
+
+// RerankerConfig is a rerank endpoint in the format of llama-server, Jina and Cohere:
+// POST {endpoint}/rerank with the query and documents, answered with a relevance score per document.
+type RerankerConfig struct {
+	Endpoint string `yaml:"endpoint,omitempty"` // no reranked configuration when empty
+	Model    string `yaml:"model,omitempty"`
+	APIKey   string `yaml:"api_key,omitempty" json:"-"`
+}
+
+// RAGEvalConfig configures the retrieval evaluation.
+type RAGEvalConfig struct {
+	Candidates int            `yaml:"candidates,omitempty"` // results of each retriever fused or reranked, 20 when unset
+	Reranker   RerankerConfig `yaml:"reranker,omitempty"`
+}
+
+// RAGEvalPair is a question and the documents that answer it, by file path or chunk id.
+type RAGEvalPair struct {
+	Question string   `yaml:"question" json:"question"`
+	Relevant []string `yaml:"relevant" json:"relevant"`
+}
+
+// RAGEvalRequest selects the pairs to evaluate, inline or from a dataset file in
+// suites_path/rag, and the retrieval configurations to compare.
+type RAGEvalRequest struct {
+	Dataset        string        `json:"dataset,omitempty" yaml:"-"`
+	Pairs          []RAGEvalPair `json:"pairs,omitempty" yaml:"pairs"`
+	K              int           `json:"k,omitempty" yaml:"k,omitempty"`                           // 5 when unset
+	Configurations []string      `json:"configurations,omitempty" yaml:"configurations,omitempty"` // all when empty
+}
+
+// RAGMetrics are the mean ranking metrics of a retrieval configuration.
+type RAGMetrics struct {
+	Configuration string  `json:"configuration"`
+	Recall        float64 `json:"recall_at_k"`
+	MRR           float64 `json:"mrr"`
+	NDCG          float64 `json:"ndcg_at_k"`
+	Queries       int     `json:"queries"`
+	Errors        int     `json:"errors,omitempty"` // failed queries, counted as retrieving nothing
+	Error         string  `json:"error,omitempty"`  // why the configuration could not be evaluated
+}
+
+// RAGEvalReport compares the retrieval configurations on the same pairs.
+type RAGEvalReport struct {
+	K          int          `json:"k"`
+	Pairs      int          `json:"pairs"`
+	Results    []RAGMetrics `json:"results"`
+	DurationMs int64        `json:"duration_ms"`
+}
+
+// retrievedChunk is an indexed chunk or document returned by a retriever.
+type retrievedChunk struct {
+	ID      string
+	Path    string
+	Text    string
+	Score   float64 // of the retriever, when it scores chunks
+	Page    string  // of its document, e.g. of a PDF, when known
+	Section string  // the headings enclosing it, when known
+}
+
+// citation returns where a chunk comes from, e.g. "paper.pdf, page 12, section 3 Methods > 3.1 Data".
+func (c retrievedChunk) citation() string {
+	parts := []string{c.key()}
+	if c.Page != "" {
+		parts = append(parts, "page "+c.Page)
+	}
+	if c.Section != "" {
+		parts = append(parts, "section "+c.Section)
+	}
+	return strings.Join(parts, ", ")
+}
+
+// key identifies the document a chunk belongs to, its file path when it has one.
+func (c retrievedChunk) key() string {
+	if c.Path != "" {
+		return c.Path
+	}
+	return c.ID
+}
+
+// Retriever returns the indexed chunks most relevant to a query, best first.
+type Retriever interface {
+	Retrieve(ctx context.Context, query string, n int) ([]retrievedChunk, error)
+}
+
+// ftsRetriever is the full text search of the document index.
+type ftsRetriever struct {
+	index *documents.IndexManager
+}
+
+func (r *ftsRetriever) Retrieve(ctx context.Context, query string, n int) ([]retrievedChunk, error) {
+	request := r.index.CreateSearchRequest(query, n)
+	request.Fields = []string{"file_path", "chunk", "full_content", "page", "section"}
+	result, err := r.index.Index.SearchInContext(ctx, request)
+	if err != nil {
+		return nil, err
+	}
+	chunks := make([]retrievedChunk, len(result.Hits))
+	for i, hit := range result.Hits {
+		chunks[i] = chunkFromFields(hit.ID, hit.Fields)
+		chunks[i].Score = hit.Score
+	}
+	return chunks, nil
+}
+
+// chunkFromFields builds a chunk from the stored fields of an index hit.
+func chunkFromFields(id string, fields map[string]interface{}) retrievedChunk {
+	chunk := retrievedChunk{ID: id}
+	chunk.Path, _ = fields["file_path"].(string)
+	chunk.Page, _ = fields["page"].(string)
+	chunk.Section, _ = fields["section"].(string)
+	if text, ok := fields["chunk"].(string); ok {
+		chunk.Text = text
+	} else {
+		chunk.Text, _ = fields["full_content"].(string)
+	}
+	return chunk
+}
+
+// vectorRetriever ranks the indexed chunks by the cosine similarity of their embedding to the
+// query's, through an HNSW index once there are vectorIndexMinChunks of them. The chunks are
+// embedded once, when it is created.
+type vectorRetriever struct {
+	chunks map[string]retrievedChunk
+	db     *EmbeddingDB // of the chunks by ID
+	embed  func(ctx context.Context, texts []string) ([][]float64, error)
+}
+
+// vectorIndexMinChunks is the fewest chunks searched through an index rather than exhaustively.
+const vectorIndexMinChunks = 10000
+
+// newVectorRetriever embeds the chunks of the document index.
+func newVectorRetriever(ctx context.Context, index *documents.IndexManager, embed func(ctx context.Context, texts []string) ([][]float64, error)) (*vectorRetriever, error) {
+	count, err := index.Index.DocCount()
+	if err != nil {
+		return nil, err
+	}
+	request := bleve.NewSearchRequestOptions(bleve.NewMatchAllQuery(), int(count), 0, false)
+	request.Fields = []string{"file_path", "chunk", "full_content", "page", "section"}
+	result, err := index.Index.SearchInContext(ctx, request)
+	if err != nil {
+		return nil, err
+	}
+
+	r := &vectorRetriever{chunks: make(map[string]retrievedChunk, len(result.Hits)), db: NewEmbeddingDB(), embed: embed}
+	chunks := make([]retrievedChunk, len(result.Hits))
+	for i, hit := range result.Hits {
+		chunks[i] = chunkFromFields(hit.ID, hit.Fields)
+		r.chunks[hit.ID] = chunks[i]
+	}
+	if len(chunks) >= vectorIndexMinChunks {
+		r.db.EnableIndex(hnsw.Config{})
+	}
+	for start := 0; start < len(chunks); start += ragEmbedBatch {
+		batch := chunks[start:min(start+ragEmbedBatch, len(chunks))]
+		texts := make([]string, len(batch))
+		for i, chunk := range batch {
+			texts[i] = truncateRunes(chunk.Text, ragEmbedMaxChars)
+		}
+		vectors, err := embed(ctx, texts)
+		if err != nil {
+			return nil, fmt.Errorf("embedding chunks: %w", err)
+		}
+		if len(vectors) != len(batch) {
+			return nil, fmt.Errorf("embedding chunks: got %d vectors for %d chunks", len(vectors), len(batch))
+		}
+		for i, chunk := range batch {
+			r.db.AddEmbedding(Embeddings{Word: chunk.ID, Vector: vectors[i]})
+		}
+	}
+	return r, nil
+}
+
+func (r *vectorRetriever) Retrieve(ctx context.Context, query string, n int) ([]retrievedChunk, error) {
+	vectors, err := r.embed(ctx, []string{query})
+	if err != nil {
+		return nil, err
+	}
+	if len(vectors) != 1 || len(vectors[0]) == 0 {
+		return nil, errors.New("no query embedding")
+	}
+
+	// Chunks of another vector length, or without magnitude, are skipped
+	results := r.db.SearchEmbeddings(vectors[0], n)
+	chunks := make([]retrievedChunk, 0, len(results))
+	for _, result := range results {
+		chunk := r.chunks[result.Word]
+		chunk.Score = result.Similarity
+		chunks = append(chunks, chunk)
+	}
+	return chunks, nil
+}
+
+// hybridRetriever fuses the rankings of several retrievers with reciprocal rank fusion.
+type hybridRetriever struct {
+	retrievers []Retriever
+	candidates int
+}
+
+func (r *hybridRetriever) Retrieve(ctx context.Context, query string, n int) ([]retrievedChunk, error) {
+	scores := make(map[string]float64)
+	chunks := make(map[string]retrievedChunk)
+	var order []string
+	for _, retriever := range r.retrievers {
+		results, err := retriever.Retrieve(ctx, query, max(n, r.candidates))
+		if err != nil {
+			return nil, err
+		}
+		for rank, chunk := range results {
+			if _, ok := chunks[chunk.ID]; !ok {
+				chunks[chunk.ID] = chunk
+				order = append(order, chunk.ID)
+			}
+			scores[chunk.ID] += 1 / float64(rrfK+rank+1)
+		}
+	}
+	sort.SliceStable(order, func(i, j int) bool { return scores[order[i]] > scores[order[j]] })
+
+	fused := make([]retrievedChunk, 0, n)
+	for _, id := range order[:min(n, len(order))] {
+		fused = append(fused, chunks[id])
+	}
+	return fused, nil
+}
+
+// rerankRetriever reorders the candidates of another retriever with a rerank model.
+type rerankRetriever struct {
+	base       Retriever
+	config     RerankerConfig
+	candidates int
+}
+
+func (r *rerankRetriever) Retrieve(ctx context.Context, query string, n int) ([]retrievedChunk, error) {
+	candidates, err := r.base.Retrieve(ctx, query, max(n, r.candidates))
+	if err != nil || len(candidates) == 0 {
+		return candidates, err
+	}
+
+	texts := make([]string, len(candidates))
+	for i, chunk := range candidates {
+		texts[i] = truncateRunes(chunk.Text, ragEmbedMaxChars)
+	}
+	body, err := json.Marshal(map[string]interface{}{"model": r.config.Model, "query": query, "documents": texts, "top_n": len(texts)})
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, strings.TrimSuffix(r.config.Endpoint, "/")+"/rerank", bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if r.config.APIKey != "" {
+		req.Header.Set("Authorization", "Bearer "+r.config.APIKey)
+	}
+	injectSpan(ctx, req)
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("rerank request failed with status %d", resp.StatusCode)
+	}
+
+	var reranked struct {
+		Results []struct {
+			Index          int     `json:"index"`
+			RelevanceScore float64 `json:"relevance_score"`
+		} `json:"results"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&reranked); err != nil {
+		return nil, fmt.Errorf("invalid rerank response: %w", err)
+	}
+	sort.SliceStable(reranked.Results, func(i, j int) bool {
+		return reranked.Results[i].RelevanceScore > reranked.Results[j].RelevanceScore
+	})
+
+	chunks := make([]retrievedChunk, 0, n)
+	for _, result := range reranked.Results {
+		if result.Index >= 0 && result.Index < len(candidates) && len(chunks) < n {
+			chunks = append(chunks, candidates[result.Index])
+		}
+	}
+	return chunks, nil
+}
+
+// rankingMetrics scores a ranking against the relevant documents: the share of them in the top k,
+// the reciprocal rank of the first one and the nDCG at k with binary relevance. Chunks of the same
+// document count once, at their best rank.
+func rankingMetrics(retrieved []retrievedChunk, relevant []string, k int) (recall, rr, ndcg float64) {
+	if len(relevant) == 0 {
+		return 0, 0, 0
+	}
+	isRelevant := func(c retrievedChunk) bool {
+		return slices.Contains(relevant, c.ID) || slices.Contains(relevant, c.Path)
+	}
+
+	seen := make(map[string]bool)
+	var ranked []retrievedChunk
+	for _, chunk := range retrieved {
+		if !seen[chunk.key()] {
+			seen[chunk.key()] = true
+			ranked = append(ranked, chunk)
+		}
+	}
+
+	var found int
+	var dcg float64
+	for i, chunk := range ranked[:min(k, len(ranked))] {
+		if !isRelevant(chunk) {
+			continue
+		}
+		found++
+		dcg += 1 / math.Log2(float64(i+2))
+		if rr == 0 {
+			rr = 1 / float64(i+1)
+		}
+	}
+	var idcg float64
+	for i := 0; i < min(k, len(relevant)); i++ {
+		idcg += 1 / math.Log2(float64(i+2))
+	}
+	return float64(found) / float64(len(relevant)), rr, dcg / idcg
+}
+
+// EvaluateRetrieval runs every question through each retriever and averages the ranking metrics.
+// The retrievers are evaluated in the order of names.
+func EvaluateRetrieval(ctx context.Context, names []string, retrievers map[string]Retriever, pairs []RAGEvalPair, k int) []RAGMetrics {
+	var results []RAGMetrics
+	for _, name := range names {
+		metrics := RAGMetrics{Configuration: name, Queries: len(pairs)}
+		for _, pair := range pairs {
+			retrieved, err := retrievers[name].Retrieve(ctx, pair.Question, k)
+			if err != nil {
+				loggerFromContext(ctx).Warn("retrieval failed", "configuration", name, "question", truncateForLog(pair.Question, 100), "error", err)
+				metrics.Errors++
+				continue
+			}
+			recall, rr, ndcg := rankingMetrics(retrieved, pair.Relevant, k)
+			metrics.Recall += recall
+			metrics.MRR += rr
+			metrics.NDCG += ndcg
+		}
+		if len(pairs) > 0 {
+			metrics.Recall /= float64(len(pairs))
+			metrics.MRR /= float64(len(pairs))
+			metrics.NDCG /= float64(len(pairs))
+		}
+		results = append(results, metrics)
+	}
+	return results
+}
+
+// loadRAGEvalDataset reads the pairs of a dataset file in suites_path/rag.
+func loadRAGEvalDataset(config *Config, name string) (RAGEvalRequest, error) {
+	var dataset RAGEvalRequest
+	if !evalSuiteName.MatchString(name) {
+		return dataset, fmt.Errorf("invalid dataset name %q", name)
+	}
+	for _, ext := range []string{".yml", ".yaml"} {
+		data, err := os.ReadFile(filepath.Join(evalSuitesPath(config), "rag", name+ext))
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return dataset, err
+		}
+		if err := yaml.Unmarshal(data, &dataset); err != nil {
+			return dataset, fmt.Errorf("invalid rag dataset %s: %w", name, err)
+		}
+		return dataset, nil
+	}
+	return dataset, os.ErrNotExist
+}
+
+// buildRetrievers creates the retrievers of the configurations. Configurations that cannot be
+// built are returned with the reason.
+func buildRetrievers(ctx context.Context, config *Config, names []string, embed func(ctx context.Context, texts []string) ([][]float64, error)) (map[string]Retriever, map[string]string) {
+	candidates := config.Evals.RAG.Candidates
+	if candidates <= 0 {
+		candidates = defaultRAGEvalCandidates
+	}
+	retrievers := make(map[string]Retriever)
+	failed := make(map[string]string)
+
+	fts := &ftsRetriever{index: indexManager}
+	var vector Retriever
+	var vectorErr error
+	if slices.ContainsFunc(names, func(name string) bool { return name != RAGFTS }) {
+		var v *vectorRetriever
+		if v, vectorErr = newVectorRetriever(ctx, indexManager, embed); vectorErr == nil {
+			vector = v
+		}
+	}
+
+	for _, name := range names {
+		switch {
+		case name == RAGFTS:
+			retrievers[name] = fts
+		case vectorErr != nil:
+			failed[name] = vectorErr.Error()
+		case name == RAGVector:
+			retrievers[name] = vector
+		case name == RAGHybrid:
+			retrievers[name] = &hybridRetriever{retrievers: []Retriever{fts, vector}, candidates: candidates}
+		case name == RAGReranked && config.Evals.RAG.Reranker.Endpoint == "":
+			failed[name] = "no reranker endpoint configured"
+		case name == RAGReranked:
+			hybrid := &hybridRetriever{retrievers: []Retriever{fts, vector}, candidates: candidates}
+			retrievers[name] = &rerankRetriever{base: hybrid, config: config.Evals.RAG.Reranker, candidates: candidates}
+		}
+	}
+	return retrievers, failed
+}
+
+// handleEvaluateRetrieval evaluates the retrieval of the document index on question and relevant
+// document pairs and reports recall@k, MRR and nDCG@k per configuration.
+func handleEvaluateRetrieval(c echo.Context, config *Config) error {
+	var req RAGEvalRequest
+	if err := c.Bind(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid request body"})
+	}
+	if req.Dataset != "" {
+		dataset, err := loadRAGEvalDataset(config, req.Dataset)
+		if errors.Is(err, os.ErrNotExist) {
+			return c.JSON(http.StatusNotFound, map[string]string{"error": "Dataset not found"})
+		}
+		if err != nil {
+			return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+		}
+		req.Pairs = dataset.Pairs
+		if req.K == 0 {
+			req.K = dataset.K
+		}
+		if len(req.Configurations) == 0 {
+			req.Configurations = dataset.Configurations
+		}
+	}
+	if len(req.Pairs) == 0 {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Pairs or a dataset are required"})
+	}
+	if req.K <= 0 {
+		req.K = defaultRAGEvalK
+	}
+	if len(req.Configurations) == 0 {
+		req.Configurations = []string{RAGFTS, RAGVector, RAGHybrid, RAGReranked}
+	}
+	for _, name := range req.Configurations {
+		if !slices.Contains([]string{RAGFTS, RAGVector, RAGHybrid, RAGReranked}, name) {
+			return c.JSON(http.StatusBadRequest, map[string]string{"error": fmt.Sprintf("Unknown configuration %q", name)})
+		}
+	}
+	if indexManager == nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "The document index is not initialized"})
+	}
+
+	start := time.Now()
+	ctx := c.Request().Context()
+	retrievers, failed := buildRetrievers(ctx, config, req.Configurations, embedWith(embeddingClient()))
+
+	var names []string
+	for _, name := range req.Configurations {
+		if _, ok := retrievers[name]; ok {
+			names = append(names, name)
+		}
+	}
+	report := RAGEvalReport{K: req.K, Pairs: len(req.Pairs)}
+	results := EvaluateRetrieval(ctx, names, retrievers, req.Pairs, req.K)
+	for _, name := range req.Configurations {
+		if reason, ok := failed[name]; ok {
+			report.Results = append(report.Results, RAGMetrics{Configuration: name, Error: reason})
+			continue
+		}
+		report.Results = append(report.Results, results[slices.Index(names, name)])
+	}
+	report.DurationMs = time.Since(start).Milliseconds()
+	return c.JSON(http.StatusOK, report)
+}
+
+// truncateRunes cuts s to at most n runes.
+func truncateRunes(s string, n int) string {
+	runes := []rune(s)
+	if len(runes) <= n {
+		return s
+	}
+	return string(runes[:n])
+}
@@ -0,0 +1,210 @@
+// rageval_test.go
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"manifold/internal/documents"
+)
+
+// bagOfWords embeds texts as counts of a few words, so texts sharing them are similar.
+func bagOfWords(ctx context.Context, texts []string) ([][]float64, error) {
+	vocabulary := []string{"cat", "dog", "bird", "fish"}
+	vectors := make([][]float64, len(texts))
+	for i, text := range texts {
+		vectors[i] = make([]float64, len(vocabulary))
+		for j, word := range vocabulary {
+			vectors[i][j] = float64(strings.Count(strings.ToLower(text), word)) + 0.01
+		}
+	}
+	return vectors, nil
+}
+
+func TestRankingMetrics(t *testing.T) {
+	retrieved := []retrievedChunk{{ID: "a1", Path: "a.md"}, {ID: "b1", Path: "b.md"}, {ID: "b2", Path: "b.md"}, {ID: "c1", Path: "c.md"}}
+
+	recall, rr, ndcg := rankingMetrics(retrieved, []string{"b.md"}, 2)
+	assert.Equal(t, 1.0, recall)
+	assert.Equal(t, 0.5, rr)
+	assert.InDelta(t, 0.631, ndcg, 0.001)
+
+	// Chunks of the same document count once, so c.md is third rather than fourth
+	recall, rr, ndcg = rankingMetrics(retrieved, []string{"a1", "c.md"}, 3)
+	assert.Equal(t, 1.0, recall)
+	assert.Equal(t, 1.0, rr)
+	assert.InDelta(t, 1.5/(1+1/1.585), ndcg, 0.001)
+
+	recall, rr, ndcg = rankingMetrics(retrieved, []string{"z.md"}, 5)
+	assert.Zero(t, recall+rr+ndcg)
+}
+
+func TestEvaluateRetrieval(t *testing.T) {
+	index, err := documents.NewIndexManager(filepath.Join(t.TempDir(), "index"))
+	require.NoError(t, err)
+	defer index.Index.Close()
+	require.NoError(t, index.IndexDocumentChunk("cats-0", "The cat sleeps all day. A cat purrs.", "cats.md"))
+	require.NoError(t, index.IndexDocumentChunk("dogs-0", "The dog barks at the mailman.", "dogs.md"))
+	require.NoError(t, index.IndexDocumentChunk("birds-0", "A bird sings and a bird flies.", "birds.md"))
+
+	ctx := context.Background()
+	fts := &ftsRetriever{index: index}
+	vector, err := newVectorRetriever(ctx, index, bagOfWords)
+	require.NoError(t, err)
+	require.Len(t, vector.chunks, 3)
+
+	reranker := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/rerank", r.URL.Path)
+		var request struct {
+			Query     string   `json:"query"`
+			Documents []string `json:"documents"`
+		}
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&request))
+		var results []map[string]interface{}
+		for i, document := range request.Documents {
+			score := 0.0
+			if strings.Contains(document, "bird") {
+				score = 1
+			}
+			results = append(results, map[string]interface{}{"index": i, "relevance_score": score})
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"results": results})
+	}))
+	defer reranker.Close()
+
+	hybrid := &hybridRetriever{retrievers: []Retriever{fts, vector}, candidates: 10}
+	retrievers := map[string]Retriever{
+		RAGFTS:      fts,
+		RAGVector:   vector,
+		RAGHybrid:   hybrid,
+		RAGReranked: &rerankRetriever{base: hybrid, config: RerankerConfig{Endpoint: reranker.URL}, candidates: 10},
+	}
+	pairs := []RAGEvalPair{
+		{Question: "Why does my cat purr?", Relevant: []string{"cats.md"}},
+		{Question: "Which animal flies?", Relevant: []string{"birds.md"}},
+	}
+	names := []string{RAGFTS, RAGVector, RAGHybrid, RAGReranked}
+	results := EvaluateRetrieval(ctx, names, retrievers, pairs, 1)
+	require.Len(t, results, 4)
+
+	// The full text search finds "cat" and "flies", the vectors only know the animals' names
+	assert.Equal(t, RAGFTS, results[0].Configuration)
+	assert.Equal(t, 1.0, results[0].Recall)
+	assert.Equal(t, 0.5, results[1].Recall)
+	assert.Equal(t, 1.0, results[2].Recall)
+	// The reranker puts birds first for every question
+	assert.Equal(t, 0.5, results[3].MRR)
+	for _, result := range results {
+		assert.Equal(t, 2, result.Queries)
+		assert.Zero(t, result.Errors)
+	}
+}
+
+func TestVectorRetrieverIndexed(t *testing.T) {
+	index, err := documents.NewIndexManager(filepath.Join(t.TempDir(), "index"))
+	require.NoError(t, err)
+	defer index.Index.Close()
+
+	// Random chunks, with around each query a few chunks ever further from it
+	rng := rand.New(rand.NewSource(1))
+	randomVector := func() []float64 {
+		vector := make([]float64, 16)
+		for i := range vector {
+			vector[i] = rng.Float64()
+		}
+		return vector
+	}
+	vectors := make(map[string][]float64)
+	queries := []string{"query 0", "query 1", "query 2"}
+	for _, query := range queries {
+		vectors[query] = randomVector()
+	}
+	batch := index.Index.NewBatch()
+	for i := 0; i < vectorIndexMinChunks; i++ {
+		text := fmt.Sprintf("chunk %d", i)
+		vectors[text] = randomVector()
+		if q, k := i/5, i%5; q < len(queries) {
+			for j, x := range vectors[queries[q]] {
+				vectors[text][j] = x
+			}
+			vectors[text][0] += float64(k+1) * 0.05
+		}
+		require.NoError(t, batch.Index(fmt.Sprintf("c%d", i), map[string]interface{}{"chunk": text, "file_path": "chunks.md"}))
+	}
+	require.NoError(t, index.Index.Batch(batch))
+	embed := func(ctx context.Context, texts []string) ([][]float64, error) {
+		embedded := make([][]float64, len(texts))
+		for i, text := range texts {
+			embedded[i] = vectors[text]
+		}
+		return embedded, nil
+	}
+
+	ctx := context.Background()
+	indexed, err := newVectorRetriever(ctx, index, embed)
+	require.NoError(t, err)
+	require.NotNil(t, indexed.db.Index, "%d chunks are searched through an index", vectorIndexMinChunks)
+
+	exhaustive := &vectorRetriever{chunks: indexed.chunks, db: NewEmbeddingDB(), embed: embed}
+	for _, embedding := range indexed.db.Embeddings {
+		exhaustive.db.AddEmbedding(embedding)
+	}
+	require.Nil(t, exhaustive.db.Index)
+
+	ids := func(chunks []retrievedChunk) []string {
+		ids := make([]string, len(chunks))
+		for i, chunk := range chunks {
+			ids[i] = chunk.ID
+		}
+		return ids
+	}
+	for q, query := range queries {
+		want := make([]string, 5)
+		for k := range want {
+			want[k] = fmt.Sprintf("c%d", q*5+k)
+		}
+
+		found, err := exhaustive.Retrieve(ctx, query, 5)
+		require.NoError(t, err)
+		assert.Equal(t, want, ids(found), query)
+
+		approximate, err := indexed.Retrieve(ctx, query, 5)
+		require.NoError(t, err)
+		assert.Equal(t, ids(found), ids(approximate), query)
+		for i := range found {
+			assert.InDelta(t, found[i].Score, approximate[i].Score, 1e-6, "the index keeps float32 vectors")
+		}
+	}
+}
+
+func TestHandleEvaluateRetrievalValidation(t *testing.T) {
+	config := &Config{DataPath: t.TempDir()}
+	require.NoError(t, os.MkdirAll(filepath.Join(config.DataPath, "evals", "rag"), 0755))
+	require.NoError(t, os.WriteFile(filepath.Join(config.DataPath, "evals", "rag", "empty.yml"), []byte("k: 3"), 0644))
+
+	e := echo.New()
+	evaluate := func(body string) int {
+		req := httptest.NewRequest(http.MethodPost, "/v1/evals/rag", strings.NewReader(body))
+		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		rec := httptest.NewRecorder()
+		require.NoError(t, handleEvaluateRetrieval(e.NewContext(req, rec), config))
+		return rec.Code
+	}
+	assert.Equal(t, http.StatusBadRequest, evaluate(`{}`))
+	assert.Equal(t, http.StatusBadRequest, evaluate(`{"dataset": "../x"}`))
+	assert.Equal(t, http.StatusNotFound, evaluate(`{"dataset": "missing"}`))
+	assert.Equal(t, http.StatusBadRequest, evaluate(`{"dataset": "empty"}`))
+	assert.Equal(t, http.StatusBadRequest, evaluate(`{"pairs": [{"question": "q", "relevant": ["a"]}], "configurations": ["bm25"]}`))
+}
@@ -0,0 +1,93 @@
+// manifold/ratelimit.go
+
+package main
+
+import (
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/labstack/echo/v4/middleware"
+	"golang.org/x/time/rate"
+)
+
+// rateLimitExpiry is how long an idle client's token bucket is kept in memory.
+const rateLimitExpiry = 10 * time.Minute
+
+// rateLimitIdentifier keys the token bucket by API key when the request is authenticated, otherwise by client IP.
+func rateLimitIdentifier(c echo.Context) (string, error) {
+	if name := authKeyName(c); name != "" {
+		return "key:" + name, nil
+	}
+	return "ip:" + c.RealIP(), nil
+}
+
+// clientRateLimit is a per-client token bucket limit. The middleware of a class of endpoints and the
+// websocket chat turns share one, so that both draw from the same buckets. A nil limit allows
+// every request.
+type clientRateLimit struct {
+	store      *middleware.RateLimiterMemoryStore
+	retryAfter string // seconds until a new token is available, reported to the client as Retry-After
+}
+
+// newClientRateLimit returns the limit, nil when rate limiting is disabled or the limit has no rate
+// set.
+func newClientRateLimit(cfg *RateLimitConfig, limit RateLimit) *clientRateLimit {
+	if !cfg.Enabled || limit.RequestsPerSecond <= 0 {
+		return nil
+	}
+
+	burst := limit.Burst
+	if burst <= 0 {
+		burst = int(math.Ceil(limit.RequestsPerSecond))
+	}
+	return &clientRateLimit{
+		store: middleware.NewRateLimiterMemoryStoreWithConfig(middleware.RateLimiterMemoryStoreConfig{
+			Rate:      rate.Limit(limit.RequestsPerSecond),
+			Burst:     burst,
+			ExpiresIn: rateLimitExpiry,
+		}),
+		retryAfter: strconv.Itoa(int(math.Ceil(1 / limit.RequestsPerSecond))),
+	}
+}
+
+// Allow takes a token from the bucket of the client of a request, and reports whether there was one.
+func (l *clientRateLimit) Allow(c echo.Context) bool {
+	if l == nil {
+		return true
+	}
+	identifier, err := rateLimitIdentifier(c)
+	if err != nil {
+		return false
+	}
+	allowed, err := l.store.Allow(identifier)
+	return err == nil && allowed
+}
+
+// Middleware returns the middleware rejecting the requests over the limit with a 429.
+func (l *clientRateLimit) Middleware() echo.MiddlewareFunc {
+	if l == nil {
+		return func(next echo.HandlerFunc) echo.HandlerFunc {
+			return next
+		}
+	}
+	return middleware.RateLimiterWithConfig(middleware.RateLimiterConfig{
+		Store:               l.store,
+		IdentifierExtractor: rateLimitIdentifier,
+		ErrorHandler: func(c echo.Context, err error) error {
+			return c.JSON(http.StatusForbidden, map[string]string{"error": "Unable to identify client"})
+		},
+		DenyHandler: func(c echo.Context, identifier string, err error) error {
+			c.Response().Header().Set("Retry-After", l.retryAfter)
+			return c.JSON(http.StatusTooManyRequests, map[string]string{"error": "Rate limit exceeded"})
+		},
+	})
+}
+
+// rateLimiter returns a per-client token bucket middleware for the given limit.
+// It does nothing when rate limiting is disabled or the limit has no rate set.
+func rateLimiter(cfg *RateLimitConfig, limit RateLimit) echo.MiddlewareFunc {
+	return newClientRateLimit(cfg, limit).Middleware()
+}
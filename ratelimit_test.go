@@ -0,0 +1,97 @@
+// ratelimit_test.go
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRateLimiter(t *testing.T) {
+	auth := &AuthConfig{Enabled: true, Keys: []APIKeyConfig{{Name: "a", Key: "k-a"}, {Name: "b", Key: "k-b"}}}
+	cfg := &RateLimitConfig{Enabled: true}
+	limit := newClientRateLimit(cfg, RateLimit{RequestsPerSecond: 0.5, Burst: 2})
+	require.NotNil(t, limit)
+
+	e := echo.New()
+	e.Use(authMiddleware(auth))
+	ok := func(c echo.Context) error {
+		return c.NoContent(http.StatusOK)
+	}
+	e.GET("/", ok, limit.Middleware())
+	e.GET("/v1/models", ok, limit.Middleware())
+
+	send := func(target, key, ip string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, target, nil)
+		if key != "" {
+			req.Header.Set("Authorization", "Bearer "+key)
+		}
+		req.RemoteAddr = ip + ":1234"
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, req)
+		return rec
+	}
+
+	// Authenticated requests share the bucket of their key, whatever their IP
+	assert.Equal(t, http.StatusOK, send("/v1/models", "k-a", "10.0.0.1").Code)
+	assert.Equal(t, http.StatusOK, send("/v1/models", "k-a", "10.0.0.2").Code)
+	rec := send("/v1/models", "k-a", "10.0.0.3")
+	assert.Equal(t, http.StatusTooManyRequests, rec.Code)
+	assert.Equal(t, "2", rec.Header().Get("Retry-After"))
+	assert.JSONEq(t, `{"error": "Rate limit exceeded"}`, rec.Body.String())
+	assert.Equal(t, http.StatusOK, send("/v1/models", "k-b", "10.0.0.1").Code, "keys have their own buckets")
+
+	// Anonymous requests, to the public paths, are keyed by IP
+	assert.Equal(t, http.StatusOK, send("/", "", "10.0.0.1").Code)
+	assert.Equal(t, http.StatusOK, send("/", "", "10.0.0.1").Code)
+	assert.Equal(t, http.StatusTooManyRequests, send("/", "", "10.0.0.1").Code)
+	assert.Equal(t, http.StatusOK, send("/", "", "10.0.0.2").Code)
+
+	// Allow draws from the same buckets, as the websocket chat turns do
+	ctx := e.NewContext(httptest.NewRequest(http.MethodGet, "/ws", nil), httptest.NewRecorder())
+	ctx.Set(authKeyContextKey, &auth.Keys[1])
+	assert.True(t, limit.Allow(ctx))
+	assert.False(t, limit.Allow(ctx))
+}
+
+func TestRateLimiterDisabled(t *testing.T) {
+	tests := []struct {
+		name  string
+		cfg   RateLimitConfig
+		limit RateLimit
+	}{
+		{"disabled", RateLimitConfig{}, RateLimit{RequestsPerSecond: 1}},
+		{"no rate", RateLimitConfig{Enabled: true}, RateLimit{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			limit := newClientRateLimit(&tt.cfg, tt.limit)
+			assert.Nil(t, limit)
+
+			e := echo.New()
+			e.GET("/", func(c echo.Context) error {
+				return c.NoContent(http.StatusOK)
+			}, rateLimiter(&tt.cfg, tt.limit))
+			for i := 0; i < 5; i++ {
+				rec := httptest.NewRecorder()
+				e.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+				assert.Equal(t, http.StatusOK, rec.Code)
+				assert.True(t, limit.Allow(e.NewContext(httptest.NewRequest(http.MethodGet, "/ws", nil), rec)))
+			}
+		})
+	}
+}
+
+func TestRateLimiterDefaultBurst(t *testing.T) {
+	limit := newClientRateLimit(&RateLimitConfig{Enabled: true}, RateLimit{RequestsPerSecond: 2.5})
+	ctx := echo.New().NewContext(httptest.NewRequest(http.MethodGet, "/ws", nil), httptest.NewRecorder())
+	for i := 0; i < 3; i++ {
+		assert.True(t, limit.Allow(ctx), "the burst is the rate rounded up")
+	}
+	assert.False(t, limit.Allow(ctx))
+	assert.Equal(t, "1", limit.retryAfter)
+}
@@ -0,0 +1,203 @@
+// manifold/readiness.go
+
+package main
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+const (
+	defaultReadinessTimeout = 10 * time.Minute
+	defaultRetryAfter       = 5 * time.Second
+	readinessPollInterval   = 500 * time.Millisecond
+)
+
+// BackendReadiness tracks whether the completions backend has finished loading its model.
+// Local backends are probed on /v1/models after every (re)start until they answer.
+type BackendReadiness struct {
+	mu     sync.Mutex
+	ready  chan struct{} // closed once the backend answers
+	cancel context.CancelFunc
+	client *http.Client
+}
+
+var backendReadiness = NewBackendReadiness()
+
+// NewBackendReadiness returns a tracker for a backend that is not ready yet.
+func NewBackendReadiness() *BackendReadiness {
+	return &BackendReadiness{
+		ready:  make(chan struct{}),
+		client: &http.Client{Timeout: healthCheckTimeout},
+	}
+}
+
+// reset marks the backend as not ready and cancels any running probe. Callers must hold r.mu.
+func (r *BackendReadiness) reset() {
+	if r.cancel != nil {
+		r.cancel()
+		r.cancel = nil
+	}
+
+	// Requests already waiting keep waiting on the same channel if the backend never became ready
+	select {
+	case <-r.ready:
+		r.ready = make(chan struct{})
+	default:
+	}
+}
+
+// Watch marks the backend as not ready and polls baseURL/models until it answers. A backend still not
+// answering after timeout is reported as failed, but polled until it answers or is restarted, so a
+// model that was only slow to load still becomes ready.
+func (r *BackendReadiness) Watch(baseURL string, timeout time.Duration) {
+	if timeout <= 0 {
+		timeout = defaultReadinessTimeout
+	}
+
+	r.mu.Lock()
+	r.reset()
+	ctx, cancel := context.WithCancel(context.Background())
+	r.cancel = cancel
+	ready := r.ready
+	r.mu.Unlock()
+
+	go r.poll(ctx, baseURL+"/models", timeout, ready)
+}
+
+// MarkReady marks the backend as ready without probing it, used for remote APIs.
+func (r *BackendReadiness) MarkReady() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.reset()
+	close(r.ready)
+}
+
+// MarkNotReady marks the backend as not ready without probing it, used when it is stopped on purpose.
+func (r *BackendReadiness) MarkNotReady() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.reset()
+}
+
+// Ready reports whether the backend is ready to accept completions.
+func (r *BackendReadiness) Ready() bool {
+	r.mu.Lock()
+	ready := r.ready
+	r.mu.Unlock()
+
+	select {
+	case <-ready:
+		return true
+	default:
+		return false
+	}
+}
+
+// Wait blocks until the backend is ready or ctx is done.
+func (r *BackendReadiness) Wait(ctx context.Context) error {
+	r.mu.Lock()
+	ready := r.ready
+	r.mu.Unlock()
+
+	select {
+	case <-ready:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// poll requests url until it returns 200, then closes ready unless the probe was superseded. Once
+// timeout elapses it logs the backend as failed and keeps polling at the same interval.
+func (r *BackendReadiness) poll(ctx context.Context, url string, timeout time.Duration, ready chan struct{}) {
+	start := time.Now()
+	ticker := time.NewTicker(readinessPollInterval)
+	defer ticker.Stop()
+	deadline := time.NewTimer(timeout)
+	defer deadline.Stop()
+
+	for {
+		if r.probe(ctx, url) {
+			r.mu.Lock()
+			if ctx.Err() == nil && r.ready == ready {
+				close(ready)
+				r.cancel()
+				r.cancel = nil
+			}
+			r.mu.Unlock()
+
+			slog.Info("completions backend ready", "url", url, "elapsed", time.Since(start))
+			return
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-deadline.C:
+			slog.Error("completions backend did not become ready, still waiting", "url", url, "elapsed", time.Since(start))
+		case <-ticker.C:
+		}
+	}
+}
+
+// probe reports whether url answers with 200.
+func (r *BackendReadiness) probe(ctx context.Context, url string) bool {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return false
+	}
+
+	resp, err := r.client.Do(req)
+	if err != nil {
+		return false
+	}
+	defer resp.Body.Close()
+
+	return resp.StatusCode == http.StatusOK
+}
+
+// requireBackendReady holds completion requests for up to queue_seconds while the backend loads its
+// model, then rejects them with 503 and a Retry-After header.
+func requireBackendReady(cfg *ReadinessConfig) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			// Start the backend again if it was unloaded for inactivity
+			idleMonitor.EnsureLoaded()
+
+			if backendReadiness.Ready() {
+				return next(c)
+			}
+
+			if cfg.QueueSeconds > 0 {
+				ctx, cancel := context.WithTimeout(c.Request().Context(), time.Duration(cfg.QueueSeconds)*time.Second)
+				defer cancel()
+				if err := backendReadiness.Wait(ctx); err == nil {
+					return next(c)
+				}
+			}
+
+			retryAfter := defaultRetryAfter
+			if cfg.RetryAfterSeconds > 0 {
+				retryAfter = time.Duration(cfg.RetryAfterSeconds) * time.Second
+			}
+			c.Response().Header().Set("Retry-After", strconv.Itoa(int(retryAfter.Seconds())))
+			return c.JSON(http.StatusServiceUnavailable, map[string]string{"error": "Model is loading, retry shortly"})
+		}
+	}
+}
+
+// handleGetReadiness reports whether the completions backend is ready.
+func handleGetReadiness(c echo.Context) error {
+	return c.JSON(http.StatusOK, map[string]bool{
+		"ready":    backendReadiness.Ready(),
+		"unloaded": idleMonitor.Unloaded(),
+	})
+}
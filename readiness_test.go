@@ -0,0 +1,102 @@
+// readiness_test.go
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newModelsServer starts a server answering /v1/models like a backend that loaded its model, while
+// ready is set.
+func newModelsServer(t *testing.T, ready *atomic.Bool) *httptest.Server {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/models" || !ready.Load() {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.Write([]byte(`{"data": []}`))
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestBackendReadinessWatch(t *testing.T) {
+	var ready atomic.Bool
+	baseURL := newModelsServer(t, &ready).URL + "/v1"
+	r := NewBackendReadiness()
+
+	r.Watch(baseURL, time.Minute)
+	assert.False(t, r.Ready())
+	ctx, cancelWait := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancelWait()
+	assert.ErrorIs(t, r.Wait(ctx), context.DeadlineExceeded)
+
+	ready.Store(true)
+	require.NoError(t, r.Wait(context.Background()))
+	assert.True(t, r.Ready())
+
+	// A restart makes it wait for the backend again
+	ready.Store(false)
+	r.Watch(baseURL, time.Minute)
+	assert.False(t, r.Ready())
+	r.MarkReady()
+	assert.True(t, r.Ready())
+	r.MarkNotReady()
+	assert.False(t, r.Ready())
+
+	// A backend slower than the timeout still becomes ready once it answers
+	r.Watch(baseURL, 100*time.Millisecond)
+	time.Sleep(200 * time.Millisecond)
+	assert.False(t, r.Ready())
+	ready.Store(true)
+	require.NoError(t, r.Wait(context.Background()))
+	assert.True(t, r.Ready())
+}
+
+func TestRequireBackendReady(t *testing.T) {
+	saved := backendReadiness
+	defer func() { backendReadiness = saved }()
+
+	tests := []struct {
+		name       string
+		cfg        ReadinessConfig
+		ready      bool
+		readyAfter time.Duration // marks the backend ready while the request waits
+		status     int
+		retryAfter string
+	}{
+		{"ready", ReadinessConfig{}, true, 0, http.StatusOK, ""},
+		{"loading", ReadinessConfig{}, false, 0, http.StatusServiceUnavailable, "5"},
+		{"custom retry", ReadinessConfig{RetryAfterSeconds: 30}, false, 0, http.StatusServiceUnavailable, "30"},
+		{"queued until ready", ReadinessConfig{QueueSeconds: 5}, false, 50 * time.Millisecond, http.StatusOK, ""},
+		{"queue times out", ReadinessConfig{QueueSeconds: 1}, false, 0, http.StatusServiceUnavailable, "5"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			backendReadiness = NewBackendReadiness()
+			if tt.ready {
+				backendReadiness.MarkReady()
+			}
+			if tt.readyAfter > 0 {
+				time.AfterFunc(tt.readyAfter, backendReadiness.MarkReady)
+			}
+
+			e := echo.New()
+			e.POST("/v1/chat/completions", func(c echo.Context) error {
+				return c.NoContent(http.StatusOK)
+			}, requireBackendReady(&tt.cfg))
+			rec := httptest.NewRecorder()
+			e.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/v1/chat/completions", nil))
+			assert.Equal(t, tt.status, rec.Code)
+			assert.Equal(t, tt.retryAfter, rec.Header().Get("Retry-After"))
+		})
+	}
+}
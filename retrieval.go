@@ -0,0 +1,188 @@
+// manifold/retrieval.go
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+
+	"manifold/internal/documents"
+
+	elasticsearch "github.com/elastic/go-elasticsearch/v8"
+)
+
+const (
+	defaultElasticsearchIndex  = "documents"
+	elasticsearchKNNCandidates = 100
+)
+
+// ElasticsearchConfig is the Elasticsearch cluster documents are retrieved from. Documents are
+// matched with BM25 on the content fields and, when vector_field is set, by kNN over the
+// embeddings of the vector field.
+type ElasticsearchConfig struct {
+	Addresses          []string `yaml:"addresses"`
+	Username           string   `yaml:"username,omitempty"`
+	Password           string   `yaml:"password,omitempty" json:"-"`
+	APIKey             string   `yaml:"api_key,omitempty" json:"-"`
+	CACert             string   `yaml:"ca_cert,omitempty"` // PEM file of the CA that signed the cluster certificate
+	InsecureSkipVerify bool     `yaml:"insecure_skip_verify,omitempty"`
+	Index              string   `yaml:"index,omitempty"`          // documents when unset
+	ContentFields      []string `yaml:"content_fields,omitempty"` // [content] when unset
+	PathField          string   `yaml:"path_field,omitempty"`     // file_path when unset
+	VectorField        string   `yaml:"vector_field,omitempty"`   // dense_vector field, no kNN when unset
+}
+
+// RetrievalConfig selects the backend the retrieval tool and /v1/documents/query search.
+type RetrievalConfig struct {
+	Backend       string              `yaml:"backend,omitempty"` // "bleve" (default), "elasticsearch" or "sqlitevec"
+	Elasticsearch ElasticsearchConfig `yaml:"elasticsearch,omitempty"`
+	Dimensions    int                 `yaml:"dimensions,omitempty"` // of the embeddings in the sqlite-vec tables, 768 when unset
+}
+
+// newRetriever returns the retriever of the configured backend. The Bleve backend is the full text
+// search of the documents ingested in the local index, the sqlitevec backend the kNN search of
+// their embeddings in the database.
+func newRetriever(config RetrievalConfig, im *documents.IndexManager) (Retriever, error) {
+	switch config.Backend {
+	case "", "bleve":
+		return &ftsRetriever{index: im}, nil
+	case "elasticsearch":
+		return newElasticsearchRetriever(config.Elasticsearch)
+	case "sqlitevec":
+		return &sqliteVecRetriever{index: im}, nil
+	default:
+		return nil, fmt.Errorf("unknown retrieval backend %q, use bleve, elasticsearch or sqlitevec", config.Backend)
+	}
+}
+
+// elasticsearchRetriever retrieves documents from an Elasticsearch index.
+type elasticsearchRetriever struct {
+	client *elasticsearch.Client
+	config ElasticsearchConfig
+}
+
+func newElasticsearchRetriever(config ElasticsearchConfig) (*elasticsearchRetriever, error) {
+	if len(config.Addresses) == 0 {
+		return nil, fmt.Errorf("the elasticsearch retrieval backend needs addresses")
+	}
+	if config.Index == "" {
+		config.Index = defaultElasticsearchIndex
+	}
+	if len(config.ContentFields) == 0 {
+		config.ContentFields = []string{"content"}
+	}
+	if config.PathField == "" {
+		config.PathField = "file_path"
+	}
+
+	esConfig := elasticsearch.Config{
+		Addresses: config.Addresses,
+		Username:  config.Username,
+		Password:  config.Password,
+		APIKey:    config.APIKey,
+	}
+	if config.CACert != "" {
+		cert, err := os.ReadFile(expandHome(config.CACert))
+		if err != nil {
+			return nil, fmt.Errorf("failed to read the elasticsearch CA certificate: %w", err)
+		}
+		esConfig.CACert = cert
+	}
+	if config.InsecureSkipVerify {
+		esConfig.Transport = &http.Transport{TLSClientConfig: &tls.Config{InsecureSkipVerify: true}}
+	}
+
+	client, err := elasticsearch.NewClient(esConfig)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create the elasticsearch client: %w", err)
+	}
+	return &elasticsearchRetriever{client: client, config: config}, nil
+}
+
+// searchBody returns the search of the documents matching the query: BM25 on the content fields,
+// plus kNN over the vector field when the query embedding is known. The scores of both add up.
+func (r *elasticsearchRetriever) searchBody(query string, embedding []float64, topN int) map[string]interface{} {
+	body := map[string]interface{}{
+		"size": topN,
+		"query": map[string]interface{}{
+			"multi_match": map[string]interface{}{
+				"query":  query,
+				"fields": r.config.ContentFields,
+			},
+		},
+		"_source": append([]string{r.config.PathField}, r.config.ContentFields...),
+	}
+	if len(embedding) > 0 {
+		body["knn"] = map[string]interface{}{
+			"field":          r.config.VectorField,
+			"query_vector":   embedding,
+			"k":              topN,
+			"num_candidates": max(topN, elasticsearchKNNCandidates),
+		}
+	}
+	return body
+}
+
+// Retrieve returns the documents matching the query, with the content of their content fields.
+// Without an embedding for the query, documents are matched by text only.
+func (r *elasticsearchRetriever) Retrieve(ctx context.Context, query string, topN int) ([]retrievedChunk, error) {
+	logger := loggerFromContext(ctx)
+	var embedding []float64
+	if r.config.VectorField != "" {
+		var err error
+		if embedding, err = GenerateEmbedding(ctx, query); err != nil {
+			logger.Warn("searching elasticsearch by text only, failed to embed the query", "error", err)
+		}
+	}
+
+	body, err := json.Marshal(r.searchBody(query, embedding, topN))
+	if err != nil {
+		return nil, err
+	}
+	res, err := r.client.Search(
+		r.client.Search.WithContext(ctx),
+		r.client.Search.WithIndex(r.config.Index),
+		r.client.Search.WithBody(bytes.NewReader(body)),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query elasticsearch: %w", err)
+	}
+	defer res.Body.Close()
+	if res.IsError() {
+		return nil, fmt.Errorf("elasticsearch error: %s", res.String())
+	}
+
+	var result struct {
+		Hits struct {
+			Hits []struct {
+				ID     string                 `json:"_id"`
+				Score  float64                `json:"_score"`
+				Source map[string]interface{} `json:"_source"`
+			} `json:"hits"`
+		} `json:"hits"`
+	}
+	if err := json.NewDecoder(res.Body).Decode(&result); err != nil {
+		return nil, fmt.Errorf("failed to decode the elasticsearch response: %w", err)
+	}
+
+	chunks := make([]retrievedChunk, 0, len(result.Hits.Hits))
+	for _, hit := range result.Hits.Hits {
+		chunk := retrievedChunk{ID: hit.ID, Score: hit.Score}
+		chunk.Path, _ = hit.Source[r.config.PathField].(string)
+		var content []string
+		for _, field := range r.config.ContentFields {
+			if value, ok := hit.Source[field].(string); ok && value != "" {
+				content = append(content, value)
+			}
+		}
+		chunk.Text = strings.Join(content, "\n")
+		chunks = append(chunks, chunk)
+	}
+	return chunks, nil
+}
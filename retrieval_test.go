@@ -0,0 +1,83 @@
+// retrieval_test.go
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"manifold/internal/documents"
+)
+
+func TestNewRetriever(t *testing.T) {
+	index, err := documents.NewIndexManager(filepath.Join(t.TempDir(), "index"))
+	require.NoError(t, err)
+	defer index.Index.Close()
+	require.NoError(t, index.IndexDocumentChunk("cats-0", "The cat sleeps all day.", "cats.md"))
+
+	retriever, err := newRetriever(RetrievalConfig{}, index)
+	require.NoError(t, err)
+	chunks, err := retriever.Retrieve(context.Background(), "cat", 3)
+	require.NoError(t, err)
+	require.Len(t, chunks, 1)
+	assert.Equal(t, retrievedChunk{ID: "cats-0", Path: "cats.md", Text: "The cat sleeps all day.", Score: chunks[0].Score}, chunks[0])
+	assert.Positive(t, chunks[0].Score)
+
+	_, err = newRetriever(RetrievalConfig{Backend: "solr"}, index)
+	assert.Error(t, err)
+	_, err = newRetriever(RetrievalConfig{Backend: "elasticsearch"}, index)
+	assert.Error(t, err, "addresses are required")
+}
+
+func TestElasticsearchRetriever(t *testing.T) {
+	var search map[string]interface{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/docs/_search", r.URL.Path)
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&search))
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("X-Elastic-Product", "Elasticsearch")
+		w.Write([]byte(`{"hits": {"hits": [
+			{"_id": "1", "_score": 2.5, "_source": {"path": "a.md", "title": "Cats", "body": "The cat sleeps."}},
+			{"_id": "2", "_score": 1.0, "_source": {"body": "The dog barks."}}
+		]}}`))
+	}))
+	defer server.Close()
+
+	retriever, err := newRetriever(RetrievalConfig{Backend: "elasticsearch", Elasticsearch: ElasticsearchConfig{
+		Addresses:     []string{server.URL},
+		Index:         "docs",
+		ContentFields: []string{"title", "body"},
+		PathField:     "path",
+	}}, nil)
+	require.NoError(t, err)
+
+	chunks, err := retriever.Retrieve(context.Background(), "sleeping cats", 2)
+	require.NoError(t, err)
+	assert.Equal(t, []retrievedChunk{
+		{ID: "1", Path: "a.md", Text: "Cats\nThe cat sleeps.", Score: 2.5},
+		{ID: "2", Text: "The dog barks.", Score: 1.0},
+	}, chunks)
+
+	// Without a vector field documents are matched by text only
+	assert.Equal(t, float64(2), search["size"])
+	assert.NotContains(t, search, "knn")
+	match := search["query"].(map[string]interface{})["multi_match"].(map[string]interface{})
+	assert.Equal(t, "sleeping cats", match["query"])
+	assert.Equal(t, []interface{}{"title", "body"}, match["fields"])
+
+	es := retriever.(*elasticsearchRetriever)
+	es.config.VectorField = "embedding"
+	body := es.searchBody("sleeping cats", []float64{0.1, 0.2}, 5)
+	assert.Equal(t, map[string]interface{}{
+		"field":          "embedding",
+		"query_vector":   []float64{0.1, 0.2},
+		"k":              5,
+		"num_candidates": elasticsearchKNNCandidates,
+	}, body["knn"])
+}
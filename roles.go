@@ -0,0 +1,217 @@
+// manifold/roles.go
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+
+	"github.com/labstack/echo/v4"
+	"gorm.io/gorm"
+)
+
+// defaultRoleName is the role used by sessions that have not picked one.
+const defaultRoleName = "default"
+
+var (
+	roleNamePattern     = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+	variableNamePattern = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+	// placeholderPattern matches the {name} placeholders in role instructions and prompt templates.
+	placeholderPattern = regexp.MustCompile(`\{([A-Za-z_][A-Za-z0-9_]*)\}`)
+)
+
+// RoleVariable is a placeholder in a role's instructions, filled in per session.
+type RoleVariable struct {
+	Name        string `yaml:"name" json:"name"`
+	Description string `yaml:"description,omitempty" json:"description,omitempty"`
+	Default     string `yaml:"default,omitempty" json:"default,omitempty"`
+	Required    bool   `yaml:"required,omitempty" json:"required,omitempty"`
+}
+
+// Validate checks the role name and that every placeholder in the instructions is declared once.
+func (r *CompletionsRole) Validate() error {
+	if !roleNamePattern.MatchString(r.Name) {
+		return fmt.Errorf("invalid role name %q, use letters, digits, - and _", r.Name)
+	}
+	if strings.TrimSpace(r.Instructions) == "" {
+		return fmt.Errorf("instructions are required")
+	}
+
+	declared := make(map[string]bool)
+	for _, v := range r.Variables {
+		if !variableNamePattern.MatchString(v.Name) {
+			return fmt.Errorf("invalid variable name %q", v.Name)
+		}
+		if declared[v.Name] {
+			return fmt.Errorf("variable %q is declared twice", v.Name)
+		}
+		declared[v.Name] = true
+	}
+
+	for _, match := range placeholderPattern.FindAllStringSubmatch(r.Instructions, -1) {
+		if !declared[match[1]] {
+			return fmt.Errorf("placeholder {%s} is not a declared variable", match[1])
+		}
+	}
+	return nil
+}
+
+// Render fills the declared variables into the instructions, using their defaults when no value is
+// given. Braces that are not declared variables are left as they are.
+func (r *CompletionsRole) Render(values map[string]string) (string, error) {
+	instructions := r.Instructions
+	for _, v := range r.Variables {
+		value, ok := values[v.Name]
+		if !ok || value == "" {
+			if v.Required && v.Default == "" {
+				return "", fmt.Errorf("role %s requires variable %q", r.Name, v.Name)
+			}
+			value = v.Default
+		}
+		instructions = strings.ReplaceAll(instructions, "{"+v.Name+"}", value)
+	}
+	return instructions, nil
+}
+
+// GetRoles returns the completions roles ordered by name.
+func (sqldb *SQLiteDB) GetRoles() ([]CompletionsRole, error) {
+	var roles []CompletionsRole
+	err := sqldb.db.Order("name").Find(&roles).Error
+	return roles, err
+}
+
+// GetRole returns the completions role with the given name.
+func (sqldb *SQLiteDB) GetRole(name string) (*CompletionsRole, error) {
+	var role CompletionsRole
+	if err := sqldb.First(name, &role); err != nil {
+		return nil, err
+	}
+	return &role, nil
+}
+
+// refreshConfigRoles reloads config.Roles, which the UI reads, after the roles table changes.
+func refreshConfigRoles(config *Config) error {
+	roles, err := db.GetRoles()
+	if err != nil {
+		return err
+	}
+	config.Roles = roles
+	return nil
+}
+
+// sessionInstructions resolves the system prompt of a chat turn: instructions sent with the message
+// win, then the role picked for the session, then the default role.
+func sessionInstructions(instructions, roleName string, values map[string]string) (string, error) {
+	if instructions != "" {
+		return instructions, nil
+	}
+	if roleName == "" {
+		roleName = defaultRoleName
+	}
+
+	role, err := db.GetRole(roleName)
+	if err != nil {
+		return "", fmt.Errorf("role %s not found: %w", roleName, err)
+	}
+	return role.Render(values)
+}
+
+// handleGetRoles lists the completions roles.
+func handleGetRoles(c echo.Context) error {
+	roles, err := db.GetRoles()
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to load roles"})
+	}
+	return c.JSON(http.StatusOK, roles)
+}
+
+// handleGetRole returns a completions role by name.
+func handleGetRole(c echo.Context) error {
+	role, err := db.GetRole(c.Param("name"))
+	if err != nil {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "Role not found"})
+	}
+	return c.JSON(http.StatusOK, role)
+}
+
+// handleCreateRole adds a completions role.
+func handleCreateRole(c echo.Context, config *Config) error {
+	var role CompletionsRole
+	if err := c.Bind(&role); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid request body"})
+	}
+	role.ID = 0
+	if err := role.Validate(); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+	}
+
+	if _, err := db.GetRole(role.Name); err == nil {
+		return c.JSON(http.StatusConflict, map[string]string{"error": "Role already exists"})
+	}
+	if err := db.Create(&role); err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to create role"})
+	}
+
+	if err := refreshConfigRoles(config); err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to reload roles"})
+	}
+	return c.JSON(http.StatusCreated, role)
+}
+
+// handleUpdateRole replaces the instructions and variables of a completions role.
+func handleUpdateRole(c echo.Context, config *Config) error {
+	existing, err := db.GetRole(c.Param("name"))
+	if err != nil {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "Role not found"})
+	}
+
+	var role CompletionsRole
+	if err := c.Bind(&role); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid request body"})
+	}
+	role.ID = existing.ID
+	role.Name = existing.Name
+	if err := role.Validate(); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+	}
+
+	if err := db.db.Save(&role).Error; err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to update role"})
+	}
+
+	if err := refreshConfigRoles(config); err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to reload roles"})
+	}
+	return c.JSON(http.StatusOK, role)
+}
+
+// handleDeleteRole removes a completions role. The default role cannot be deleted.
+func handleDeleteRole(c echo.Context, config *Config) error {
+	name := c.Param("name")
+	if name == defaultRoleName {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "The default role cannot be deleted"})
+	}
+
+	role, err := db.GetRole(name)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "Role not found"})
+	}
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to load role"})
+	}
+	if err := db.Delete(role.ID, &CompletionsRole{}); err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to delete role"})
+	}
+	if err := db.db.Where("role_name = ?", name).Delete(&RoleExample{}).Error; err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to delete role examples"})
+	}
+
+	if err := refreshConfigRoles(config); err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to reload roles"})
+	}
+	return c.JSON(http.StatusOK, map[string]string{"status": "success", "role": name})
+}
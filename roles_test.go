@@ -0,0 +1,50 @@
+// roles_test.go
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCompletionsRoleValidate(t *testing.T) {
+	role := CompletionsRole{
+		Name:         "translator",
+		Instructions: "Translate into {language}. Reply as JSON like {\"text\": \"...\"}.",
+		Variables:    []RoleVariable{{Name: "language", Default: "French"}},
+	}
+	assert.NoError(t, role.Validate())
+
+	role.Variables = nil
+	assert.ErrorContains(t, role.Validate(), "{language}")
+
+	role.Variables = []RoleVariable{{Name: "language"}, {Name: "language"}}
+	assert.ErrorContains(t, role.Validate(), "declared twice")
+
+	role.Variables = []RoleVariable{{Name: "language"}}
+	role.Name = "bad name"
+	assert.Error(t, role.Validate())
+}
+
+func TestCompletionsRoleRender(t *testing.T) {
+	role := CompletionsRole{
+		Name:         "translator",
+		Instructions: "Translate into {language} for {audience}.",
+		Variables: []RoleVariable{
+			{Name: "language", Default: "French"},
+			{Name: "audience", Required: true},
+		},
+	}
+
+	_, err := role.Render(nil)
+	assert.ErrorContains(t, err, "audience")
+
+	out, err := role.Render(map[string]string{"audience": "children"})
+	require.NoError(t, err)
+	assert.Equal(t, "Translate into French for children.", out)
+
+	out, err = role.Render(map[string]string{"audience": "children", "language": "German"})
+	require.NoError(t, err)
+	assert.Equal(t, "Translate into German for children.", out)
+}
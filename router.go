@@ -0,0 +1,233 @@
+// manifold/router.go
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+)
+
+const (
+	RouterModeHeuristic = "heuristic"
+	RouterModeLLM       = "llm"
+
+	defaultRouterTimeout = 10 * time.Second
+)
+
+// ToolRouterConfig selects which tools run for a prompt. Without a router every enabled tool runs
+// on every prompt.
+type ToolRouterConfig struct {
+	Enabled        bool     `yaml:"enabled"`
+	Mode           string   `yaml:"mode,omitempty"`     // heuristic or llm, heuristic when empty
+	Endpoint       string   `yaml:"endpoint,omitempty"` // OpenAI compatible base URL of the llm mode, the chat backend when empty
+	Model          string   `yaml:"model,omitempty"`    // model name sent to the endpoint
+	APIKey         string   `yaml:"api_key,omitempty" json:"-"`
+	TimeoutSeconds int      `yaml:"timeout_seconds,omitempty"` // 10 when unset
+	Always         []string `yaml:"always,omitempty"`          // tools that run on every prompt
+}
+
+// RelevantTool is implemented by tools that can tell from the prompt alone whether they have
+// anything to contribute.
+type RelevantTool interface {
+	Relevant(prompt string) bool
+}
+
+var (
+	// smallTalkPattern matches greetings and acknowledgements that need no tools.
+	smallTalkPattern = regexp.MustCompile(`(?i)^\W*(hi|hello|hey|thanks|thank you|thx|ok|okay|cool|great|bye|good (morning|evening|night))\W*$`)
+
+	// routerPatterns are the heuristics for the built-in tools that cannot decide on their own.
+	routerPatterns = map[string]*regexp.Regexp{
+		"websearch": regexp.MustCompile(`(?i)\b(search|look up|google|latest|news|recent|currently|this (week|month|year)|price of|release[sd]?|who (is|was|won)|20\d\d)\b`),
+		"webget":    regexp.MustCompile(`https?://\S+`),
+		"teams":     regexp.MustCompile(`(?i)\b(research|investigate|in[- ]depth|thorough(ly)?|compare|comparison|analy[sz]e|pros and cons)\b`),
+	}
+
+	// toolDescriptions tell the llm router what the built-in tools are for.
+	toolDescriptions = map[string]string{
+		"websearch": "searches the web for recent or factual information",
+		"webget":    "fetches the content of URLs given in the prompt",
+		"retrieval": "searches the user's stored documents and notes",
+		"teams":     "asks a team of agents to research a complex question",
+		"codeexec":  "runs Python, Go or JavaScript code blocks from the prompt",
+		"shell":     "runs shell commands from shell code blocks in the prompt",
+		"fsread":    "reads local files referenced as @path",
+		"weather":   "looks up current weather and forecasts",
+		"datetime":  "tells the current date and time",
+		"coderag":   "looks up functions, call trees and relevant code of the indexed code repository",
+	}
+)
+
+// ToolRouter picks the tools worth running for a prompt, by heuristics or by asking a model.
+type ToolRouter struct {
+	config ToolRouterConfig
+	client LLMClient // nil uses the chat backend
+}
+
+// NewToolRouter creates a router from its configuration.
+func NewToolRouter(config ToolRouterConfig) (*ToolRouter, error) {
+	switch config.Mode {
+	case "":
+		config.Mode = RouterModeHeuristic
+	case RouterModeHeuristic, RouterModeLLM:
+	default:
+		return nil, fmt.Errorf("unknown tool router mode %q", config.Mode)
+	}
+
+	r := &ToolRouter{config: config}
+	if config.Mode == RouterModeLLM && config.Endpoint != "" {
+		r.client = NewLocalLLMClient(config.Endpoint, config.Model, config.APIKey)
+	}
+	return r, nil
+}
+
+// Route returns the tools to run for the prompt, in their workflow order.
+func (r *ToolRouter) Route(ctx context.Context, prompt string, tools []ToolWrapper) []ToolWrapper {
+	span, ctx := startSpan(ctx, "workflow.route")
+	span.SetTag("mode", r.config.Mode)
+	defer span.Finish()
+
+	logger := loggerFromContext(ctx)
+	text := strings.TrimSpace(unwrapPrompt(prompt))
+
+	var selected map[string]bool
+	if r.config.Mode == RouterModeLLM && !smallTalkPattern.MatchString(text) {
+		names, err := r.classify(ctx, text, tools)
+		if err != nil {
+			logger.Warn("tool router failed, falling back to heuristics", "error", err)
+		} else {
+			selected = make(map[string]bool)
+			for _, name := range names {
+				selected[name] = true
+			}
+		}
+	}
+
+	var routed []ToolWrapper
+	for _, wrapper := range tools {
+		relevant := slices.Contains(r.config.Always, wrapper.Name)
+		if !relevant && selected != nil {
+			relevant = selected[wrapper.Name]
+		} else if !relevant {
+			relevant = heuristicRelevant(wrapper, text)
+		}
+		if relevant {
+			routed = append(routed, wrapper)
+		}
+	}
+
+	names := make([]string, 0, len(routed))
+	for _, wrapper := range routed {
+		names = append(names, wrapper.Name)
+	}
+	span.SetTag("tools", strings.Join(names, ","))
+	logger.Debug("routed prompt", "mode", r.config.Mode, "tools", names)
+	return routed
+}
+
+// heuristicRelevant decides whether a tool is worth running without asking a model. Tools the
+// heuristics know nothing about always run.
+func heuristicRelevant(wrapper ToolWrapper, prompt string) bool {
+	if smallTalkPattern.MatchString(prompt) {
+		return false
+	}
+	if tool, ok := wrapper.Tool.(RelevantTool); ok {
+		return tool.Relevant(prompt)
+	}
+	if pattern, ok := routerPatterns[wrapper.Name]; ok {
+		return pattern.MatchString(prompt)
+	}
+	return true
+}
+
+// classify asks the router model which tools the prompt needs.
+func (r *ToolRouter) classify(ctx context.Context, prompt string, tools []ToolWrapper) ([]string, error) {
+	timeout := defaultRouterTimeout
+	if r.config.TimeoutSeconds > 0 {
+		timeout = time.Duration(r.config.TimeoutSeconds) * time.Second
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	client := r.client
+	if client == nil {
+		backend, release := currentBackend()
+		defer release()
+		client = backend
+	}
+	if client == nil {
+		return nil, errors.New("no completions backend")
+	}
+
+	var list strings.Builder
+	for _, wrapper := range tools {
+		fmt.Fprintf(&list, "- %s: %s\n", wrapper.Name, describeTool(wrapper.Name))
+	}
+	instructions := "You decide which tools an assistant needs before it answers a user. Available tools:\n" + list.String() +
+		"Reply with a JSON array of the names of the tools that would clearly help answer the user's message, " +
+		"for example [\"websearch\"]. Reply with [] when none are needed. Reply with the JSON array only."
+
+	payload := &CompletionRequest{
+		Model: r.config.Model,
+		Messages: []Message{
+			{Role: "system", Content: instructions},
+			{Role: "user", Content: prompt},
+		},
+		Temperature: 0,
+		MaxTokens:   64,
+	}
+
+	resp, err := client.SendCompletionRequest(ctx, payload)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var completion CompletionResponse
+	if err := json.NewDecoder(resp.Body).Decode(&completion); err != nil {
+		return nil, fmt.Errorf("invalid router response: %w", err)
+	}
+	if len(completion.Choices) == 0 {
+		return nil, errors.New("router returned no choices")
+	}
+	return parseToolSelection(completion.Choices[0].Message.Content)
+}
+
+// parseToolSelection reads the JSON array of tool names from a router reply, ignoring any text around it.
+func parseToolSelection(reply string) ([]string, error) {
+	list, ok := extractJSON(reply, true)
+	if !ok {
+		return nil, fmt.Errorf("router reply has no tool list: %s", truncateForLog(reply, 100))
+	}
+
+	var names []string
+	if err := json.Unmarshal([]byte(list), &names); err != nil {
+		return nil, fmt.Errorf("router reply has an invalid tool list: %w", err)
+	}
+	return names, nil
+}
+
+// describeTool returns what a tool is for, as shown to the router model.
+func describeTool(name string) string {
+	if desc, ok := toolDescriptions[name]; ok {
+		return desc
+	}
+	if plugin, ok := lookupPlugin(name); ok && plugin.Description != "" {
+		return plugin.Description
+	}
+	return name
+}
+
+// unwrapPrompt strips the braces the chat wraps around the user prompt.
+func unwrapPrompt(prompt string) string {
+	if strings.HasPrefix(prompt, "{") && strings.HasSuffix(prompt, "}") {
+		return prompt[1 : len(prompt)-1]
+	}
+	return prompt
+}
@@ -0,0 +1,95 @@
+// router_test.go
+package main
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func routerTestTools() []ToolWrapper {
+	return []ToolWrapper{
+		{Name: "websearch", Tool: &WebSearchTool{}},
+		{Name: "webget", Tool: &WebGetTool{}},
+		{Name: "retrieval", Tool: &RetrievalTool{}},
+		{Name: "weather", Tool: &WeatherTool{}},
+		{Name: "codeexec", Tool: &CodeExecTool{}},
+	}
+}
+
+func toolNames(tools []ToolWrapper) []string {
+	var names []string
+	for _, wrapper := range tools {
+		names = append(names, wrapper.Name)
+	}
+	return names
+}
+
+func TestToolRouterHeuristic(t *testing.T) {
+	router, err := NewToolRouter(ToolRouterConfig{Enabled: true})
+	require.NoError(t, err)
+	ctx := context.Background()
+
+	tests := []struct {
+		prompt string
+		want   []string
+	}{
+		{"{hello!}", nil},
+		{"{Explain how channels work}", []string{"retrieval"}},
+		{"{What is the latest news about Go?}", []string{"websearch", "retrieval"}},
+		{"{Summarize https://go.dev/blog}", []string{"webget", "retrieval"}},
+		{"{Will it rain in Paris?}", []string{"retrieval", "weather"}},
+		{"{Fix this:\n```python\nprint(1/0)\n```}", []string{"retrieval", "codeexec"}},
+	}
+	for _, tt := range tests {
+		assert.Equal(t, tt.want, toolNames(router.Route(ctx, tt.prompt, routerTestTools())), tt.prompt)
+	}
+}
+
+func TestToolRouterLLM(t *testing.T) {
+	var request CompletionRequest
+	server := completionServer(t, func(r CompletionRequest) (string, error) {
+		request = r
+		return `Tools: ["weather", "unknown"]`, nil
+	})
+
+	router, err := NewToolRouter(ToolRouterConfig{Enabled: true, Mode: RouterModeLLM, Endpoint: server.URL, Always: []string{"retrieval"}})
+	require.NoError(t, err)
+
+	routed := router.Route(context.Background(), "{Should I take an umbrella today?}", routerTestTools())
+	assert.Equal(t, []string{"retrieval", "weather"}, toolNames(routed))
+	assert.Contains(t, request.Messages[0].Content, "- weather: looks up current weather and forecasts")
+	assert.Equal(t, "Should I take an umbrella today?", request.Messages[1].Content)
+}
+
+func TestToolRouterLLMFallback(t *testing.T) {
+	server := completionServer(t, func(CompletionRequest) (string, error) {
+		return "I think websearch", nil
+	})
+
+	router, err := NewToolRouter(ToolRouterConfig{Enabled: true, Mode: RouterModeLLM, Endpoint: server.URL})
+	require.NoError(t, err)
+
+	routed := router.Route(context.Background(), "{Search for the latest Go release}", routerTestTools())
+	assert.Equal(t, []string{"websearch", "retrieval"}, toolNames(routed))
+}
+
+func TestParseToolSelection(t *testing.T) {
+	names, err := parseToolSelection("```json\n[\"websearch\", \"webget\"]\n```")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"websearch", "webget"}, names)
+
+	names, err = parseToolSelection("[]")
+	require.NoError(t, err)
+	assert.Empty(t, names)
+
+	_, err = parseToolSelection("none")
+	assert.Error(t, err)
+}
+
+func TestNewToolRouterInvalidMode(t *testing.T) {
+	_, err := NewToolRouter(ToolRouterConfig{Mode: "random"})
+	assert.Error(t, err)
+}
@@ -6,8 +6,11 @@ import (
 	"html/template"
 	"io"
 	"net/http"
+	"path/filepath"
 
 	"github.com/labstack/echo/v4"
+
+	"manifold/internal/apiguard"
 )
 
 // TemplateRenderer is a custom html/template renderer for Echo framework
@@ -27,21 +30,61 @@ func setupRoutes(e *echo.Echo, config *Config) {
 	e.Renderer = t
 	e.Static("/", "public")
 
+	// Per-client rate limits for the endpoints that hit the GPU or the embeddings service. The chat
+	// one applies to each turn of the websocket sessions too.
+	chatTurns := newClientRateLimit(&config.RateLimit, config.RateLimit.Chat)
+	chatLimit := chatTurns.Middleware()
+	ingestLimit := rateLimiter(&config.RateLimit, config.RateLimit.Ingest)
+	searchLimit := rateLimiter(&config.RateLimit, config.RateLimit.Search)
+
 	e.GET("/", func(c echo.Context) error {
 		return c.Render(http.StatusOK, "base.html", nil)
 	})
 
+	// auth routes
+	e.POST("/v1/auth/login", func(c echo.Context) error {
+		return handleLogin(c, &config.Auth)
+	})
+	e.POST("/v1/auth/logout", handleLogout)
+
 	e.GET("/v1/config", func(c echo.Context) error {
 		return handleGetConfig(c, config)
 	})
 
 	// chat submit route
-	e.POST("/v1/chat/submit", handleChatSubmit)
+	e.POST("/v1/chat/submit", handleChatSubmit, chatLimit, requireBackendReady(&config.Readiness))
 	e.POST("/v1/chat/role/:role", func(c echo.Context) error {
 		return handleSetChatRole(c, config)
 	})
 
+	// role routes
+	e.GET("/v1/roles", handleGetRoles)
+	e.GET("/v1/roles/:name", handleGetRole)
+	e.POST("/v1/roles", func(c echo.Context) error {
+		return handleCreateRole(c, config)
+	}, audit("role.create"), requireAdmin)
+	e.PUT("/v1/roles/:name", func(c echo.Context) error {
+		return handleUpdateRole(c, config)
+	}, audit("role.update"), requireAdmin)
+	e.DELETE("/v1/roles/:name", func(c echo.Context) error {
+		return handleDeleteRole(c, config)
+	}, audit("role.delete"), requireAdmin)
+	e.GET("/v1/roles/:name/examples", handleGetRoleExamples)
+	e.POST("/v1/roles/:name/examples", handleCreateRoleExample, audit("role.example.create"), requireAdmin)
+	e.DELETE("/v1/roles/:name/examples/:id", handleDeleteRoleExample, audit("role.example.delete"), requireAdmin)
+
+	// prompt template routes
+	e.GET("/v1/templates", handleGetPromptTemplates)
+	e.POST("/v1/templates", handleCreatePromptTemplate, audit("template.create"), requireAdmin)
+	e.GET("/v1/templates/:name", handleGetPromptTemplate)
+	e.PUT("/v1/templates/:name", handleUpdatePromptTemplate, audit("template.update"), requireAdmin)
+	e.DELETE("/v1/templates/:name", handleDeletePromptTemplate, audit("template.delete"), requireAdmin)
+	e.GET("/v1/templates/:name/versions", handleGetPromptTemplateVersions)
+	e.POST("/v1/templates/:name/render", handleRenderPromptTemplate)
+
 	// model routes
+	e.GET("/v1/models", handleGetModels)
+	e.GET("/v1/models/:name", handleGetModel)
 	e.POST("/v1/models/select", func(c echo.Context) error {
 		modelName := c.FormValue("modelName")
 		if modelName == "" {
@@ -56,43 +99,218 @@ func setupRoutes(e *echo.Echo, config *Config) {
 		// update the config
 		config.SelectedModels, _ = GetSelectedModels(db.db)
 
-		// restart the completions service
-		restartCompletionsService(config, true)
+		// swap the completions service to the new model, the previous one keeps serving until the new one is ready
+		if err := restartCompletionsService(config, true); err != nil {
+			return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to switch model: " + err.Error()})
+		}
 
 		// Return json object with status and model name
 		return c.JSON(http.StatusOK, map[string]string{"status": "success", "model": modelName})
+	}, audit("model.select"), requireAdmin)
+
+	// Hugging Face model browser
+	e.GET("/v1/hf/search", func(c echo.Context) error {
+		return handleSearchHFModels(c, config)
+	}, searchLimit)
+	e.GET("/v1/hf/files", func(c echo.Context) error {
+		return handleListHFFiles(c, config)
+	}, searchLimit)
+
+	// Model downloads run in the background, progress is streamed on /v1/downloads/events
+	e.POST("/v1/downloads", func(c echo.Context) error {
+		return handleStartDownload(c, config)
+	}, audit("download.start"), requireAdmin)
+	e.GET("/v1/downloads", handleListDownloads)
+	e.GET("/v1/downloads/events", handleDownloadEvents)
+	e.GET("/v1/downloads/:id", handleGetDownload)
+	e.POST("/v1/downloads/:id/pause", handlePauseDownload, audit("download.pause"), requireAdmin)
+	e.POST("/v1/downloads/:id/resume", func(c echo.Context) error {
+		return handleResumeDownload(c, config)
+	}, audit("download.resume"), requireAdmin)
+	e.DELETE("/v1/downloads/:id", handleCancelDownload, audit("download.cancel"), requireAdmin)
+
+	// Text to speech
+	e.POST("/v1/audio/speech", func(c echo.Context) error {
+		return handleSpeech(c, config)
+	}, chatLimit)
+	e.POST("/v1/chats/:id/speech", func(c echo.Context) error {
+		return handleSpeakChat(c, config)
+	}, chatLimit)
+
+	// Speech to text
+	e.POST("/v1/audio/transcriptions", func(c echo.Context) error {
+		return handleTranscription(c, config)
+	}, chatLimit)
+	e.POST("/v1/images", func(c echo.Context) error {
+		return handleUploadImage(c, config)
+	}, chatLimit)
+	e.POST("/v1/images/generations", func(c echo.Context) error {
+		return handleImageGeneration(c, config)
+	}, chatLimit)
+	e.GET("/v1/images/status", handleGetComfyUIStatus)
+	e.Static(generatedImagesRoute, filepath.Join(config.DataPath, "tmp"))
+
+	// Speculative decoding
+	e.GET("/v1/speculative", func(c echo.Context) error {
+		return handleGetSpeculative(c, config)
 	})
+	e.PUT("/v1/speculative", func(c echo.Context) error {
+		return handleSetSpeculative(c, config)
+	}, audit("speculative.update"), requireAdmin)
+
+	// LoRA adapters
+	e.GET("/v1/loras", handleGetLoras)
+	e.POST("/v1/loras/scan", func(c echo.Context) error {
+		return handleScanLoras(c, config)
+	}, audit("lora.scan"), requireAdmin)
+	e.POST("/v1/loras/:name/attach", handleAttachLora, audit("lora.attach"), requireAdmin)
+	e.POST("/v1/loras/:name/detach", handleDetachLora, audit("lora.detach"), requireAdmin)
+
+	// Model pool routes
+	e.GET("/v1/models/pool", handleGetModelPool)
+	e.POST("/v1/models/pool/:name", func(c echo.Context) error {
+		return handleLoadPoolModel(c, config)
+	}, audit("pool.load"), requireAdmin)
+	e.DELETE("/v1/models/pool/:name", handleUnloadPoolModel, audit("pool.unload"), requireAdmin)
+
+	// Service routes
+	e.GET("/v1/services", handleGetServices)
+	e.GET("/v1/ready", handleGetReadiness)
 
 	// Tool routes
 	e.POST("/v1/tools/:toolName/toggle", func(c echo.Context) error {
 		return handleToolToggle(c, config)
-	})
+	}, audit("tool.toggle"), requireAdmin)
 	e.GET("/v1/tools/list", handleGetTools)
+	e.GET("/v1/tools", handleGetToolStatus)
+	e.GET("/v1/tools/history", handleGetToolHistory)
+	e.GET("/v1/plans", handleGetPlanTrace)
+	e.GET("/v1/guardrails/events", handleGetGuardrailEvents, requireAdmin)
+	e.POST("/v1/tools/:toolName/reset", handleResetToolCircuit, audit("tool.reset"), requireAdmin)
+	e.GET("/v1/tools/:toolName/params", func(c echo.Context) error {
+		return handleGetToolParams(c, config)
+	})
+	e.PUT("/v1/tools/:toolName/params", func(c echo.Context) error {
+		return handleUpdateToolParams(c, config)
+	}, audit("tool.params"), requireAdmin)
+
+	// Ratings of chat responses and the usage stats of the chat history
+	e.POST("/v1/feedback", handleFeedback)
+	e.GET("/v1/usage", handleGetUsage)
+
+	// Comparison mode of the chat
+	e.GET("/v1/compare", handleGetComparison)
+	e.POST("/v1/compare/preference", handlePreferResponse)
+
+	// Evaluation suites run against a backend
+	e.GET("/v1/evals/suites", func(c echo.Context) error {
+		return handleGetEvalSuites(c, config)
+	})
+	e.POST("/v1/evals/runs", func(c echo.Context) error {
+		return handleStartEvalRun(c, config)
+	}, audit("eval.run"), requireAdmin)
+	e.GET("/v1/evals/runs", handleGetEvalRuns)
+	e.GET("/v1/evals/runs/:id", handleGetEvalRun)
+	e.POST("/v1/evals/rag", func(c echo.Context) error {
+		return handleEvaluateRetrieval(c, config)
+	}, audit("eval.rag"), requireAdmin)
+
+	// Fine-tuning datasets exported from the chat history
+	e.GET("/v1/datasets/export", handleExportDataset, audit("dataset.export"), requireAdmin)
+
+	// Catalog of the parquet and CSV datasets of DataPath/datasets, and their ingestion
+	e.GET("/v1/datasets", func(c echo.Context) error {
+		return handleGetDatasets(c, config)
+	})
+	e.GET("/v1/datasets/ingestions", handleGetDatasetIngestions)
+	e.GET("/v1/datasets/:name", func(c echo.Context) error {
+		return handleGetDataset(c, config)
+	})
+	e.POST("/v1/datasets/:name/ingest", func(c echo.Context) error {
+		return handleIngestDataset(c, config)
+	}, audit("dataset.ingest"), requireAdmin, ingestLimit)
+
+	// Administrative actions recorded by the audit middleware
+	e.GET("/v1/admin/audit", handleGetAuditLog, requireAdmin)
+
+	// Agent team of the teams tool
+	e.GET("/v1/agents", func(c echo.Context) error {
+		return handleGetAgents(c, config)
+	})
+	e.GET("/v1/agents/transcripts", handleGetAgentTranscript)
 
 	// Retrieval Augmented Generation (RAG) routes
 	// Route for storing text and embeddings
-	e.POST("/v1/embeddings", handleEmbeddingRequest)
+	e.POST("/v1/embeddings", handleEmbeddingRequest, ingestLimit)
 
 	// Document routes
-	e.POST("/v1/documents/ingest/git", handleGitIngest)
-	e.POST("/v1/documents/ingest/pdf", handlePDFIngest)
-	e.POST("/v1/documents/split", handleSplitDocuments)
+	e.POST("/v1/documents/ingest/git", handleGitIngest, audit("documents.ingest.git"), ingestLimit)
+	// Uploads are limited to max_upload_mb
+	uploadLimit := echo.WrapMiddleware(apiguard.LimitBody(maxUploadBytes(config)))
+	e.POST("/v1/documents/ingest/pdf", handlePDFIngest, audit("documents.ingest.pdf"), uploadLimit,
+		echo.WrapMiddleware(apiguard.RequireContentType(echo.MIMEMultipartForm)), ingestLimit)
+	e.POST("/v1/documents/split", handleSplitDocuments, audit("documents.split"), ingestLimit)
+	e.GET("/v1/documents/chunking", handleGetChunking)
+	e.PUT("/v1/documents/chunking", func(c echo.Context) error {
+		return handleSetChunking(c, config)
+	}, audit("documents.chunking"), requireAdmin)
+	e.POST("/v1/documents/gc", handleCollectGarbage, audit("documents.gc"), requireAdmin)
+	e.GET("/v1/sources", handleGetSources)
+	e.POST("/v1/sources", handleCreateSource, audit("source.create"), requireAdmin)
+	e.PUT("/v1/sources/:id", handleUpdateSource, audit("source.update"), requireAdmin)
+	e.DELETE("/v1/sources/:id", handleDeleteSource, audit("source.delete"), requireAdmin)
+	e.POST("/v1/sources/:id/refresh", handleRefreshSource, audit("source.refresh"), requireAdmin, ingestLimit)
+	e.POST("/v1/store-documents", handleStoreDocuments, audit("documents.store"), uploadLimit,
+		echo.WrapMiddleware(apiguard.RequireContentType(echo.MIMEApplicationJSON)), ingestLimit)
 	e.POST("/v1/documents/query", func(c echo.Context) error {
 		err := handleQueryDocuments(c)
 		return err
-	})
+	}, searchLimit)
+
+	// Chat turns and documents of the edata store and their graph, when enabled
+	e.GET("/v1/edata/documents/:id", handleGetEDataDocument, requireEData)
+	e.GET("/v1/edata/documents/:id/connected", handleGetConnectedEDataDocuments, requireEData, searchLimit)
+	e.POST("/v1/edata/edges", handleCreateEDataEdge, requireEData, audit("edata.edge.create"), requireAdmin)
+	e.POST("/v1/edata/similar", handleSearchSimilarEDataDocuments, requireEData, searchLimit)
 
 	// tool routes
 	//e.GET("/v1/tools", handleRenderTools)
 
-	e.GET("/ws", handleWebSocketConnection)
+	e.GET("/ws", func(c echo.Context) error {
+		return handleWebSocketConnection(c, config, chatTurns)
+	})
 }
 
 // handleGetConfig is a handler for getting the configuration
 func handleGetConfig(c echo.Context, config *Config) error {
+	configMu.RLock()
+	defer configMu.RUnlock()
 	return c.JSON(http.StatusOK, config)
 }
 
+// handleGetModels lists the scanned models with the metadata read from their files.
+func handleGetModels(c echo.Context) error {
+	models, err := db.GetModels()
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to load models"})
+	}
+	return c.JSON(http.StatusOK, models)
+}
+
+// handleGetModel returns a single model by name.
+func handleGetModel(c echo.Context) error {
+	models, err := db.GetModels()
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to load models"})
+	}
+
+	model, ok := findModelByName(models, c.Param("name"))
+	if !ok {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "Model not found"})
+	}
+	return c.JSON(http.StatusOK, model)
+}
+
 // Helper function to convert bool to string
 func boolToString(b bool) string {
 	if b {
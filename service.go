@@ -4,10 +4,12 @@ package main
 
 import (
 	"context"
+	"errors"
 	"fmt"
-	"log"
+	"log/slog"
 	"os"
 	"os/exec"
+	"sync"
 	"syscall"
 	"time"
 )
@@ -23,6 +25,15 @@ type ExternalService struct {
 	config  ServiceConfig
 	cmd     *exec.Cmd
 	verbose bool
+
+	// inflight counts completions still streaming from this instance so it can be drained on a model swap
+	inflight sync.WaitGroup
+
+	mu      sync.Mutex
+	ctx     context.Context
+	done    chan struct{}
+	exitErr error
+	stopped bool
 }
 
 // NewExternalService creates a new ExternalService instance
@@ -35,61 +46,144 @@ func NewExternalService(config ServiceConfig, verbose bool) *ExternalService {
 
 // Start launches the external service process
 func (es *ExternalService) Start(ctx context.Context) error {
-	es.cmd = exec.CommandContext(ctx, es.config.Command, es.config.Args...)
+	cmd := exec.CommandContext(ctx, es.config.Command, es.config.Args...)
 
 	if es.verbose {
-		es.cmd.Stdout = os.Stdout
-		es.cmd.Stderr = os.Stderr
+		cmd.Stdout = os.Stdout
+		cmd.Stderr = os.Stderr
 	} else {
-		es.cmd.Stdout = nil
-		es.cmd.Stderr = nil
+		cmd.Stdout = nil
+		cmd.Stderr = nil
 	}
 
-	if err := es.cmd.Start(); err != nil {
+	if err := cmd.Start(); err != nil {
 		return fmt.Errorf("failed to start %s: %w", es.config.Name, err)
 	}
 
-	fmt.Printf("%s started with PID %d\n", es.config.Name, es.cmd.Process.Pid)
+	done := make(chan struct{})
+
+	es.mu.Lock()
+	es.cmd = cmd
+	es.ctx = ctx
+	es.done = done
+	es.exitErr = nil
+	es.stopped = false
+	es.mu.Unlock()
+
+	// Reap the process as soon as it exits so crashes can be detected by the supervisor
+	go func() {
+		err := cmd.Wait()
+
+		es.mu.Lock()
+		if es.done == done {
+			es.exitErr = err
+		}
+		es.mu.Unlock()
+
+		close(done)
+	}()
+
+	slog.Info("service started", "service", es.config.Name, "pid", cmd.Process.Pid)
 	return nil
 }
 
 // Stop terminates the external service process
 func (es *ExternalService) Stop(ctx context.Context) error {
-	if es.cmd == nil || es.cmd.Process == nil {
+	es.mu.Lock()
+	cmd, done := es.cmd, es.done
+	es.stopped = true
+	es.mu.Unlock()
+
+	if cmd == nil || cmd.Process == nil {
 		return fmt.Errorf("%s is not running", es.config.Name)
 	}
 
-	err := es.cmd.Process.Signal(syscall.SIGTERM)
-	if err != nil {
+	err := cmd.Process.Signal(syscall.SIGTERM)
+	if err != nil && !errors.Is(err, os.ErrProcessDone) {
 		return fmt.Errorf("failed to stop %s: %w", es.config.Name, err)
 	}
 
-	// Create a channel to receive the result of Wait()
-	done := make(chan error, 1)
-	go func() {
-		_, err := es.cmd.Process.Wait()
-		done <- err
-	}()
-
 	// Wait for the process to exit or for the context to be canceled
 	select {
-	case err := <-done:
-		if err != nil {
-			return fmt.Errorf("error waiting for %s to stop: %w", es.config.Name, err)
-		}
+	case <-done:
 	case <-ctx.Done():
 		return ctx.Err()
 	}
 
-	fmt.Printf("%s stopped\n", es.config.Name)
+	slog.Info("service stopped", "service", es.config.Name)
+	return nil
+}
+
+// Kill ends a process that stopped responding. Unlike Stop it is not marked as stopped on purpose,
+// so the supervisor sees it as crashed and restarts it.
+func (es *ExternalService) Kill() error {
+	es.mu.Lock()
+	cmd := es.cmd
+	es.mu.Unlock()
+
+	if cmd == nil || cmd.Process == nil {
+		return fmt.Errorf("%s is not running", es.config.Name)
+	}
+	if err := cmd.Process.Kill(); err != nil && !errors.Is(err, os.ErrProcessDone) {
+		return fmt.Errorf("failed to kill %s: %w", es.config.Name, err)
+	}
 	return nil
 }
 
+// Exited reports whether the process has exited and, if so, the error it exited with.
+func (es *ExternalService) Exited() (bool, error) {
+	es.mu.Lock()
+	defer es.mu.Unlock()
+
+	if es.done == nil {
+		return false, nil
+	}
+	select {
+	case <-es.done:
+		return true, es.exitErr
+	default:
+		return false, nil
+	}
+}
+
+// Stopped reports whether the service was stopped on purpose with Stop.
+func (es *ExternalService) Stopped() bool {
+	es.mu.Lock()
+	defer es.mu.Unlock()
+	return es.stopped
+}
+
+// Restart starts the process again with the context it was first started with.
+func (es *ExternalService) Restart() error {
+	es.mu.Lock()
+	ctx := es.ctx
+	es.mu.Unlock()
+
+	if ctx == nil {
+		return fmt.Errorf("%s was never started", es.config.Name)
+	}
+	if err := ctx.Err(); err != nil {
+		return fmt.Errorf("cannot restart %s: %w", es.config.Name, err)
+	}
+	return es.Start(ctx)
+}
+
+// PID returns the process ID of the running process, or 0 if it is not running.
+func (es *ExternalService) PID() int {
+	es.mu.Lock()
+	defer es.mu.Unlock()
+
+	if es.cmd == nil || es.cmd.Process == nil {
+		return 0
+	}
+	return es.cmd.Process.Pid
+}
+
 // UpdateWorkflowManagerForToolToggle handles enabling or disabling tools, including starting/stopping services.
 func UpdateWorkflowManagerForToolToggle(toolName string, enabled bool, config *Config) {
 	wm := GetGlobalWorkflowManager()
 	if wm == nil {
-		log.Println("WorkflowManager is not initialized")
+		slog.Error("WorkflowManager is not initialized")
 		return
 	}
 
@@ -102,7 +196,7 @@ func UpdateWorkflowManagerForToolToggle(toolName string, enabled bool, config *C
 				var ok bool
 				teamsTool, ok = wrapper.Tool.(*TeamsTool)
 				if !ok {
-					log.Println("Failed to cast to TeamsTool")
+					slog.Error("failed to cast to TeamsTool", "tool", toolName)
 					return
 				}
 				break
@@ -110,7 +204,7 @@ func UpdateWorkflowManagerForToolToggle(toolName string, enabled bool, config *C
 		}
 
 		// if teamsTool == nil {
-		// 	log.Println("TeamsTool not found in WorkflowManager")
+		// 	slog.Error("TeamsTool not found in WorkflowManager")
 		// 	return
 		// }
 
@@ -120,6 +214,8 @@ func UpdateWorkflowManagerForToolToggle(toolName string, enabled bool, config *C
 			// Create a new ServiceConfig for the Teams tool
 			teamsTool.serviceConfig = ServiceConfig{
 				Name:    "teams",
+				Host:    config.Services[5].Host,
+				Port:    config.Services[5].Port,
 				Command: config.Services[5].Command,
 				Args:    args,
 			}
@@ -128,39 +224,41 @@ func UpdateWorkflowManagerForToolToggle(toolName string, enabled bool, config *C
 			if teamsTool.service == nil {
 				teamsTool.service = NewExternalService(teamsTool.serviceConfig, false) // Set verbose as needed
 				if err := teamsTool.service.Start(context.Background()); err != nil {
-					log.Printf("Failed to start Teams ExternalService: %v", err)
+					slog.Error("failed to start Teams ExternalService", "tool", toolName, "error", err)
 					return
 				}
+				supervisor.Register("teams", teamsTool.service)
 
 				// Initialize the LLMClient pointing to the Teams service
 				baseURL := fmt.Sprintf("http://%s:%d/v1", teamsTool.serviceConfig.Host, teamsTool.serviceConfig.Port)
 				teamsTool.client = NewLocalLLMClient(baseURL, "", "") // Adjust APIKey if needed
 
-				log.Printf("Teams tool '%s' has been enabled and service started", toolName)
+				slog.Info("teams tool enabled and service started", "tool", toolName)
 
 				// Logic to register the tool with the WorkflowManager
 				tool, err := CreateToolByName(toolName)
 				if err != nil {
-					log.Printf("Failed to create tool '%s': %v", toolName, err)
+					slog.Error("failed to create tool", "tool", toolName, "error", err)
 					return
 				}
 				err = wm.AddTool(tool, toolName)
 				if err != nil {
-					log.Printf("Failed to add tool '%s' to WorkflowManager: %v", toolName, err)
+					slog.Error("failed to add tool to WorkflowManager", "tool", toolName, "error", err)
 				}
-				log.Printf("Tool '%s' has been enabled and added to WorkflowManager", toolName)
+				slog.Info("tool enabled and added to WorkflowManager", "tool", toolName)
 			}
 		} else {
 			// Stop the ExternalService
 			if teamsTool.service != nil {
 				ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 				defer cancel()
+				supervisor.Unregister("teams")
 				if err := teamsTool.service.Stop(ctx); err != nil {
-					log.Printf("Failed to stop Teams ExternalService: %v", err)
+					slog.Error("failed to stop Teams ExternalService", "tool", toolName, "error", err)
 				} else {
 					teamsTool.service = nil
 					teamsTool.client = nil
-					log.Printf("Teams tool '%s' has been disabled and service stopped", toolName)
+					slog.Info("teams tool disabled and service stopped", "tool", toolName)
 				}
 			}
 		}
@@ -169,21 +267,25 @@ func UpdateWorkflowManagerForToolToggle(toolName string, enabled bool, config *C
 			// Logic to register the tool with the WorkflowManager
 			tool, err := CreateToolByName(toolName)
 			if err != nil {
-				log.Printf("Failed to create tool '%s': %v", toolName, err)
+				slog.Error("failed to create tool", "tool", toolName, "error", err)
+				return
+			}
+			if err := configureTool(tool, toolName, config); err != nil {
+				slog.Error("failed to configure tool", "tool", toolName, "error", err)
 				return
 			}
 			err = wm.AddTool(tool, toolName)
 			if err != nil {
-				log.Printf("Failed to add tool '%s' to WorkflowManager: %v", toolName, err)
+				slog.Error("failed to add tool to WorkflowManager", "tool", toolName, "error", err)
 			}
-			log.Printf("Tool '%s' has been enabled and added to WorkflowManager", toolName)
+			slog.Info("tool enabled and added to WorkflowManager", "tool", toolName)
 		} else {
 			// Logic to unregister the tool from the WorkflowManager
 			err := wm.RemoveTool(toolName)
 			if err != nil {
-				log.Printf("Failed to remove tool '%s' from WorkflowManager: %v", toolName, err)
+				slog.Error("failed to remove tool from WorkflowManager", "tool", toolName, "error", err)
 			} else {
-				log.Printf("Tool '%s' has been disabled and removed from WorkflowManager", toolName)
+				slog.Info("tool disabled and removed from WorkflowManager", "tool", toolName)
 			}
 		}
 	}
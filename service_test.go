@@ -98,3 +98,30 @@ func TestExternalServiceStopWithoutStart(t *testing.T) {
 	assert.Error(t, err, "Service.Stop should return an error when service is not running")
 	assert.Contains(t, err.Error(), "is not running")
 }
+
+func TestExternalServiceExited(t *testing.T) {
+	serviceConfig := ServiceConfig{
+		Name:    "echo_service",
+		Command: "echo",
+		Args:    []string{"Hello, World!"},
+		Host:    "localhost",
+		Port:    12345,
+	}
+
+	service := NewExternalService(serviceConfig, false)
+
+	exited, _ := service.Exited()
+	assert.False(t, exited, "Service that was never started should not report an exit")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	require.NoError(t, service.Start(ctx))
+
+	// "echo" exits immediately, the supervisor should see it as exited without a Stop call
+	assert.Eventually(t, func() bool {
+		exited, _ := service.Exited()
+		return exited
+	}, 2*time.Second, 10*time.Millisecond)
+	assert.False(t, service.Stopped(), "Service should not be marked as stopped on purpose")
+}
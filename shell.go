@@ -0,0 +1,382 @@
+// manifold/shell.go
+
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"html"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+const (
+	defaultShellTimeout        = 60 * time.Second
+	defaultShellConfirmTimeout = 60 * time.Second
+	defaultShellOutputKB       = 64
+)
+
+var (
+	// shellBlockPattern matches the fenced shell blocks whose lines are run as commands.
+	shellBlockPattern = regexp.MustCompile("(?s)```(?:sh|shell|bash|console)[^\\n]*\\n(.*?)```")
+
+	// defaultShellAllow are the commands the shell tool runs unless the allow parameter is set.
+	defaultShellAllow = []string{"ls", "pwd", "git status", "git log", "git diff", "go test", "go vet"}
+
+	// defaultShellDenyFlags make allowed commands run other programs or write files.
+	defaultShellDenyFlags = []string{"-exec", "-toolexec", "-o", "--output", "--ext-diff", "--upload-pack", "-coverprofile", "-cpuprofile", "-memprofile", "-trace"}
+)
+
+// websocketKey is the context key carrying the chat websocket of a workflow run.
+type websocketKey struct{}
+
+// withWebSocket returns a copy of ctx that carries the chat websocket, for tools that talk to the user.
+func withWebSocket(ctx context.Context, c *websocket.Conn) context.Context {
+	return context.WithValue(ctx, websocketKey{}, c)
+}
+
+// websocketFromContext returns the chat websocket stored in ctx, or nil.
+func websocketFromContext(ctx context.Context) *websocket.Conn {
+	c, _ := ctx.Value(websocketKey{}).(*websocket.Conn)
+	return c
+}
+
+// ShellTool runs the allowlisted commands found in shell code blocks of the prompt. Commands run
+// without a shell in the working directory, path arguments cannot leave it, and with confirm set
+// the user approves every command in the chat before it runs. Output streams to the chat while
+// the command runs and is handed to the model afterwards.
+type ShellTool struct {
+	enabled        bool
+	workDir        string
+	allow          [][]string
+	denyFlags      []string
+	confirm        bool
+	timeout        time.Duration
+	confirmTimeout time.Duration
+	maxOutputKB    int
+}
+
+// Process runs the allowed commands in the input and returns their output.
+func (t *ShellTool) Process(ctx context.Context, input string) (string, error) {
+	commands := extractShellCommands(input)
+	if len(commands) == 0 {
+		return "", nil
+	}
+
+	conn := websocketFromContext(ctx)
+	logger := loggerFromContext(ctx)
+
+	var out strings.Builder
+	out.WriteString("Shell command results:\n")
+	for _, command := range commands {
+		fmt.Fprintf(&out, "\n$ %s\n", command)
+
+		args, err := t.validate(command)
+		if err != nil {
+			fmt.Fprintf(&out, "(not run: %v)\n", err)
+			continue
+		}
+
+		if t.confirm {
+			approved, err := confirmCommand(ctx, conn, command, t.confirmTimeout)
+			if err != nil || !approved {
+				logger.Info("shell command declined", "command", command, "error", err)
+				out.WriteString("(not run: declined by the user)\n")
+				continue
+			}
+		}
+
+		output, exitCode, err := t.run(ctx, conn, args)
+		out.WriteString(output)
+		if err != nil {
+			fmt.Fprintf(&out, "(failed: %v)\n", err)
+			continue
+		}
+		fmt.Fprintf(&out, "(exit code %d)\n", exitCode)
+	}
+	return out.String(), nil
+}
+
+// Relevant reports whether the prompt has shell blocks to run.
+func (t *ShellTool) Relevant(prompt string) bool {
+	return len(extractShellCommands(prompt)) > 0
+}
+
+// Enabled returns the enabled status of the tool.
+func (t *ShellTool) Enabled() bool {
+	return t.enabled
+}
+
+// SetParams configures the tool with provided parameters.
+func (t *ShellTool) SetParams(params map[string]interface{}, config *Config) error {
+	if enabled, ok := params["enabled"].(bool); ok {
+		t.enabled = enabled
+	}
+
+	t.workDir = "."
+	if dir, ok := params["work_dir"].(string); ok && dir != "" {
+		t.workDir = dir
+	}
+	abs, err := filepath.Abs(t.workDir)
+	if err != nil {
+		return fmt.Errorf("invalid work_dir: %w", err)
+	}
+	t.workDir = abs
+
+	allow := defaultShellAllow
+	if list, ok := params["allow"]; ok {
+		allow = interfaceToStringSlice(list)
+	}
+	t.allow = nil
+	for _, entry := range allow {
+		if fields := strings.Fields(entry); len(fields) > 0 {
+			t.allow = append(t.allow, fields)
+		}
+	}
+
+	t.denyFlags = defaultShellDenyFlags
+	if list, ok := params["deny_flags"]; ok {
+		t.denyFlags = interfaceToStringSlice(list)
+	}
+
+	t.confirm = true
+	if confirm, ok := params["confirm"].(bool); ok {
+		t.confirm = confirm
+	}
+	t.timeout = defaultShellTimeout
+	if seconds, ok := params["timeout_seconds"].(int); ok && seconds > 0 {
+		t.timeout = time.Duration(seconds) * time.Second
+	}
+	t.confirmTimeout = defaultShellConfirmTimeout
+	t.maxOutputKB = defaultShellOutputKB
+	if kb, ok := params["max_output_kb"].(int); ok && kb > 0 {
+		t.maxOutputKB = kb
+	}
+	return nil
+}
+
+// GetParams returns the tool's parameters.
+func (t *ShellTool) GetParams() map[string]interface{} {
+	var allow []string
+	for _, fields := range t.allow {
+		allow = append(allow, strings.Join(fields, " "))
+	}
+	return map[string]interface{}{
+		"enabled":         t.enabled,
+		"work_dir":        t.workDir,
+		"allow":           allow,
+		"deny_flags":      t.denyFlags,
+		"confirm":         t.confirm,
+		"timeout_seconds": int(t.timeout.Seconds()),
+		"max_output_kb":   t.maxOutputKB,
+	}
+}
+
+// extractShellCommands returns the commands in the shell code blocks, one per line.
+func extractShellCommands(input string) []string {
+	var commands []string
+	for _, match := range shellBlockPattern.FindAllStringSubmatch(input, -1) {
+		for _, line := range strings.Split(match[1], "\n") {
+			line = strings.TrimSpace(strings.TrimPrefix(strings.TrimSpace(line), "$ "))
+			if line == "" || strings.HasPrefix(line, "#") {
+				continue
+			}
+			commands = append(commands, line)
+		}
+	}
+	return commands
+}
+
+// splitCommand splits a command line into arguments, honoring single and double quotes. Unquoted
+// shell operators are rejected since commands never run through a shell.
+func splitCommand(command string) ([]string, error) {
+	var args []string
+	var current strings.Builder
+	inArg := false
+	var quote rune
+
+	for _, r := range command {
+		switch {
+		case quote != 0:
+			if r == quote {
+				quote = 0
+			} else {
+				current.WriteRune(r)
+			}
+		case r == '\'' || r == '"':
+			quote = r
+			inArg = true
+		case r == ' ' || r == '\t':
+			if inArg {
+				args = append(args, current.String())
+				current.Reset()
+				inArg = false
+			}
+		case strings.ContainsRune(";|&<>`$(){}\\", r):
+			return nil, fmt.Errorf("shell operator %q is not allowed", r)
+		default:
+			current.WriteRune(r)
+			inArg = true
+		}
+	}
+	if quote != 0 {
+		return nil, errors.New("unterminated quote")
+	}
+	if inArg {
+		args = append(args, current.String())
+	}
+	return args, nil
+}
+
+// validate parses a command and checks it against the allowlist, the denied flags and the working directory.
+func (t *ShellTool) validate(command string) ([]string, error) {
+	args, err := splitCommand(command)
+	if err != nil {
+		return nil, err
+	}
+	if len(args) == 0 {
+		return nil, errors.New("empty command")
+	}
+
+	allowed := false
+	for _, entry := range t.allow {
+		if len(args) >= len(entry) && slices.Equal(args[:len(entry)], entry) {
+			allowed = true
+			break
+		}
+	}
+	if !allowed {
+		return nil, fmt.Errorf("%s is not in the allowlist", args[0])
+	}
+
+	for _, arg := range args[1:] {
+		flag, value, hasValue := strings.Cut(arg, "=")
+		for _, denied := range t.denyFlags {
+			if flag == denied {
+				return nil, fmt.Errorf("flag %s is not allowed", flag)
+			}
+		}
+
+		path := arg
+		if strings.HasPrefix(arg, "-") {
+			if !hasValue {
+				continue
+			}
+			path = value
+		}
+		if err := t.checkPath(path); err != nil {
+			return nil, err
+		}
+	}
+	return args, nil
+}
+
+// checkPath rejects arguments that resolve outside the working directory.
+func (t *ShellTool) checkPath(arg string) error {
+	if !strings.Contains(arg, "/") && arg != ".." && !strings.HasPrefix(arg, "~") {
+		return nil
+	}
+	if strings.HasPrefix(arg, "~") {
+		return fmt.Errorf("path %s is outside the working directory", arg)
+	}
+
+	path := arg
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(t.workDir, path)
+	}
+	rel, err := filepath.Rel(t.workDir, filepath.Clean(path))
+	if err != nil || rel == ".." || strings.HasPrefix(rel, "../") {
+		return fmt.Errorf("path %s is outside the working directory", arg)
+	}
+	return nil
+}
+
+// confirmCommand asks the user to approve a command in the chat and waits for the answer, which
+// the session reader of the chat hands over.
+func confirmCommand(ctx context.Context, conn *websocket.Conn, command string, timeout time.Duration) (bool, error) {
+	reader := sessionReaderFromContext(ctx)
+	if conn == nil || reader == nil {
+		return false, errors.New("no chat connection to confirm the command")
+	}
+
+	prompt := fmt.Sprintf(`<div id='progress' class='p-2'>Run <code>%s</code>?
+<form ws-send class='d-inline'><input type='hidden' name='shell_confirm' value='yes'><button class='btn btn-sm btn-success mx-1'>Run</button></form>
+<form ws-send class='d-inline'><input type='hidden' name='shell_confirm' value='no'><button class='btn btn-sm btn-secondary'>Skip</button></form></div>`,
+		html.EscapeString(command))
+	if err := conn.WriteMessage(websocket.TextMessage, []byte(prompt)); err != nil {
+		return false, err
+	}
+
+	answer, err := reader.Confirmation(ctx, timeout)
+	if err != nil {
+		return false, err
+	}
+	return answer == "yes", nil
+}
+
+// run executes an approved command and streams its output to the chat line by line.
+func (t *ShellTool) run(ctx context.Context, conn *websocket.Conn, args []string) (text string, exitCode int, err error) {
+	span, ctx := startSpan(ctx, "shell.run")
+	span.SetTag("command", args[0])
+	defer func() { finishSpan(span, err) }()
+
+	ctx, cancel := context.WithTimeout(ctx, t.timeout)
+	defer cancel()
+
+	cmd := exec.CommandContext(ctx, args[0], args[1:]...)
+	cmd.Dir = t.workDir
+	cmd.Env = append(os.Environ(), "GIT_PAGER=cat", "PAGER=cat", "NO_COLOR=1")
+	cmd.WaitDelay = time.Second
+
+	pr, pw := io.Pipe()
+	cmd.Stdout = pw
+	cmd.Stderr = pw
+
+	if err := cmd.Start(); err != nil {
+		return "", 0, err
+	}
+	go func() {
+		pw.CloseWithError(cmd.Wait())
+	}()
+
+	output := &limitedBuffer{max: t.maxOutputKB << 10}
+	var shown strings.Builder
+	scanner := bufio.NewScanner(pr)
+	scanner.Buffer(make([]byte, 64<<10), 1<<20)
+	for scanner.Scan() {
+		line := scanner.Text() + "\n"
+		output.Write([]byte(line))
+
+		if conn != nil && !output.truncated {
+			shown.WriteString(html.EscapeString(line))
+			msg := fmt.Sprintf("<div id='progress' class='p-2'><pre class='mb-0'>$ %s\n%s</pre></div>", html.EscapeString(strings.Join(args, " ")), shown.String())
+			conn.WriteMessage(websocket.TextMessage, []byte(msg))
+		}
+	}
+
+	// The pipe returns cmd.Wait's error once the command exits
+	err = scanner.Err()
+	text = output.buf.String()
+	if output.truncated {
+		text += "(output truncated)\n"
+	}
+	if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		return text, 0, fmt.Errorf("timed out after %s", t.timeout)
+	}
+
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		return text, exitErr.ExitCode(), nil
+	}
+	return text, 0, err
+}
@@ -0,0 +1,136 @@
+// shell_test.go
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestExtractShellCommands(t *testing.T) {
+	input := "Check the tree:\n```shell\n$ git status\n# comment\n\nls -la\n```\n```python\nprint(1)\n```"
+
+	assert.Equal(t, []string{"git status", "ls -la"}, extractShellCommands(input))
+}
+
+func TestSplitCommand(t *testing.T) {
+	args, err := splitCommand(`go test -run "TestA|TestB" ./...`)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"go", "test", "-run", "TestA|TestB", "./..."}, args)
+
+	for _, command := range []string{"ls; rm -rf /", "ls | sh", "ls $(pwd)", "ls > out", "ls 'open"} {
+		_, err := splitCommand(command)
+		assert.Error(t, err, command)
+	}
+}
+
+func TestShellToolValidate(t *testing.T) {
+	tool := &ShellTool{}
+	require.NoError(t, tool.SetParams(map[string]interface{}{
+		"enabled":  true,
+		"work_dir": t.TempDir(),
+		"allow":    []interface{}{"ls", "git status", "go test"},
+	}, &Config{}))
+
+	for _, command := range []string{"ls", "ls -la sub/dir", "git status --short", "go test ./...", "go test -run=TestA"} {
+		_, err := tool.validate(command)
+		assert.NoError(t, err, command)
+	}
+	for _, command := range []string{"rm -rf .", "git push", "gitstatus", "ls ..", "ls ../secret", "ls /etc", "ls ~/.ssh", "go test -exec=sh", "go test -o bin", "go test --coverprofile=../out"} {
+		_, err := tool.validate(command)
+		assert.Error(t, err, command)
+	}
+}
+
+func TestShellToolProcess(t *testing.T) {
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "hello.txt"), []byte("hi"), 0644))
+
+	tool := &ShellTool{}
+	require.NoError(t, tool.SetParams(map[string]interface{}{
+		"enabled":  true,
+		"work_dir": dir,
+		"allow":    []interface{}{"ls"},
+		"confirm":  false,
+	}, &Config{}))
+
+	out, err := tool.Process(context.Background(), "```sh\nls\ncat hello.txt\n```")
+	require.NoError(t, err)
+	assert.Contains(t, out, "$ ls\nhello.txt\n(exit code 0)")
+	assert.Contains(t, out, "$ cat hello.txt\n(not run: cat is not in the allowlist)")
+}
+
+func TestShellToolConfirmWithoutChat(t *testing.T) {
+	tool := &ShellTool{}
+	require.NoError(t, tool.SetParams(map[string]interface{}{
+		"enabled":  true,
+		"work_dir": t.TempDir(),
+		"allow":    []interface{}{"ls"},
+	}, &Config{}))
+
+	out, err := tool.Process(context.Background(), "```sh\nls\n```")
+	require.NoError(t, err)
+	assert.Contains(t, out, "declined by the user")
+}
+
+func TestSessionReaderConfirmation(t *testing.T) {
+	conns := make(chan *websocket.Conn, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		require.NoError(t, err)
+		conns <- conn
+	}))
+	t.Cleanup(server.Close)
+
+	client, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(server.URL, "http"), nil)
+	require.NoError(t, err)
+	t.Cleanup(func() { client.Close() })
+	reader := newSessionReader(<-conns)
+
+	waiting := func() bool {
+		reader.mu.Lock()
+		defer reader.mu.Unlock()
+		return reader.confirm != nil
+	}
+
+	// A chat message sent while a command waits for its confirmation is kept for the next turn
+	answers := make(chan string, 1)
+	go func() {
+		answer, err := reader.Confirmation(context.Background(), 5*time.Second)
+		assert.NoError(t, err)
+		answers <- answer
+	}()
+	require.Eventually(t, waiting, time.Second, 10*time.Millisecond)
+	require.NoError(t, client.WriteJSON(map[string]string{"chat_message": "next turn"}))
+	require.NoError(t, client.WriteJSON(map[string]string{"shell_confirm": "yes"}))
+	assert.Equal(t, "yes", <-answers)
+
+	wsMessage, err := reader.Next()
+	require.NoError(t, err)
+	assert.Equal(t, "next turn", wsMessage.ChatMessage)
+
+	// Late answers are dropped and the session keeps reading
+	_, err = reader.Confirmation(context.Background(), 50*time.Millisecond)
+	assert.Error(t, err)
+	require.NoError(t, client.WriteJSON(map[string]string{"shell_confirm": "yes"}))
+	require.NoError(t, client.WriteJSON(map[string]string{"chat_message": "after"}))
+	wsMessage, err = reader.Next()
+	require.NoError(t, err)
+	assert.Equal(t, "after", wsMessage.ChatMessage)
+
+	// A closed session ends the wait
+	client.Close()
+	_, err = reader.Next()
+	assert.Error(t, err)
+	_, err = reader.Confirmation(context.Background(), 5*time.Second)
+	assert.Error(t, err)
+}
@@ -0,0 +1,203 @@
+// manifold/slots.go
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// slotState is a llama-server slot and the chat session whose KV cache it holds.
+type slotState struct {
+	owner    string
+	busy     bool
+	lastUsed time.Time
+}
+
+// SlotManager pins each chat session to a llama-server slot so consecutive turns reuse the KV
+// cache of the previous ones. When there are more sessions than slots, the least recently used
+// slot is taken over; with persistence enabled its KV cache is saved first and restored when its
+// session comes back.
+type SlotManager struct {
+	mu       sync.Mutex
+	enabled  bool
+	persist  bool
+	slotsDir string
+	count    int
+	servers  map[string][]slotState // by backend base URL
+	saved    map[string]bool        // sessions with a saved slot file
+	client   *http.Client
+}
+
+var slotManager = &SlotManager{client: &http.Client{Timeout: time.Minute}}
+
+// Configure enables slot affinity for the gguf backend with the number of parallel slots it runs.
+func (m *SlotManager) Configure(config *Config) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.enabled = config.Slots.Affinity && config.LLMBackend == "gguf"
+	m.persist = config.Slots.Persist
+	m.slotsDir = filepath.Join(config.DataPath, "slots")
+	m.servers = make(map[string][]slotState)
+	m.saved = make(map[string]bool)
+
+	m.count = 1
+	if opts, err := ParseGGUFOptions(config.Llama); err == nil && opts.Server.Parallel != nil && *opts.Server.Parallel > 0 {
+		m.count = *opts.Server.Parallel
+	}
+}
+
+// applySlotOptions sets the slot save path and prompt similarity on a gguf launch.
+func applySlotOptions(config *Config, opts *GGUFOptions) error {
+	if config.Slots.Persist && opts.Server.SlotSavePath == nil {
+		slotsDir := filepath.Join(config.DataPath, "slots")
+		if err := os.MkdirAll(slotsDir, 0755); err != nil {
+			return fmt.Errorf("failed to create slots directory: %w", err)
+		}
+		opts.Server.SlotSavePath = &slotsDir
+	}
+	if config.Slots.PromptSimilarity > 0 && opts.Server.SlotPromptSimilarity == nil {
+		opts.Server.SlotPromptSimilarity = &config.Slots.PromptSimilarity
+	}
+	return nil
+}
+
+// Acquire returns the slot a session should use on the backend behind client, and a func to call
+// when the turn is done. It returns nil when affinity is disabled or every slot is busy, in which
+// case llama-server picks a slot itself.
+func (m *SlotManager) Acquire(ctx context.Context, client LLMClient, sessionID string) (*int, func()) {
+	c, ok := client.(*Client)
+
+	m.mu.Lock()
+	if !m.enabled || !ok {
+		m.mu.Unlock()
+		return nil, func() {}
+	}
+
+	slots, ok := m.servers[c.BaseURL]
+	if !ok {
+		slots = make([]slotState, m.count)
+		m.servers[c.BaseURL] = slots
+	}
+
+	// Reuse the slot the session already owns, otherwise take a free or the least recently used one
+	id := -1
+	for i := range slots {
+		if slots[i].owner == sessionID && !slots[i].busy {
+			id = i
+			break
+		}
+	}
+	for i := range slots {
+		if id == -1 && slots[i].owner == "" {
+			id = i
+		}
+	}
+	if id == -1 {
+		for i := range slots {
+			if !slots[i].busy && (id == -1 || slots[i].lastUsed.Before(slots[id].lastUsed)) {
+				id = i
+			}
+		}
+	}
+	if id == -1 {
+		m.mu.Unlock()
+		return nil, func() {}
+	}
+
+	evicted := ""
+	if slots[id].owner != sessionID {
+		evicted = slots[id].owner
+	}
+	restore := slots[id].owner != sessionID && m.saved[sessionID]
+	persist := m.persist
+	slots[id].owner = sessionID
+	slots[id].busy = true
+	m.mu.Unlock()
+
+	logger := loggerFromContext(ctx)
+	if persist && evicted != "" {
+		if err := m.slotAction(ctx, c, id, "save", evicted); err != nil {
+			logger.Warn("failed to save slot", "slot", id, "session", evicted, "error", err)
+		} else {
+			m.mu.Lock()
+			m.saved[evicted] = true
+			m.mu.Unlock()
+		}
+	}
+	if persist && restore {
+		if err := m.slotAction(ctx, c, id, "restore", sessionID); err != nil {
+			logger.Warn("failed to restore slot", "slot", id, "error", err)
+		}
+	}
+
+	slot := id
+	return &slot, func() {
+		m.mu.Lock()
+		defer m.mu.Unlock()
+		if current, ok := m.servers[c.BaseURL]; ok && id < len(current) {
+			current[id].busy = false
+			current[id].lastUsed = time.Now()
+		}
+	}
+}
+
+// EndSession frees the session's slots and deletes its saved KV cache.
+func (m *SlotManager) EndSession(sessionID string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, slots := range m.servers {
+		for i := range slots {
+			if slots[i].owner == sessionID {
+				slots[i].owner = ""
+			}
+		}
+	}
+	if m.saved[sessionID] {
+		delete(m.saved, sessionID)
+		os.Remove(filepath.Join(m.slotsDir, slotFilename(sessionID)))
+	}
+}
+
+// slotAction saves or restores the KV cache of a slot to the session's file under --slot-save-path.
+func (m *SlotManager) slotAction(ctx context.Context, c *Client, id int, action, sessionID string) error {
+	body, err := json.Marshal(map[string]string{"filename": slotFilename(sessionID)})
+	if err != nil {
+		return err
+	}
+
+	url := fmt.Sprintf("%s/slots/%d?action=%s", strings.TrimSuffix(c.BaseURL, "/v1"), id, action)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := m.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("slot %s failed with status %d", action, resp.StatusCode)
+	}
+	slog.Debug("slot "+action, "slot", id, "session", sessionID)
+	return nil
+}
+
+// slotFilename is the file a session's KV cache is saved to.
+func slotFilename(sessionID string) string {
+	return "session-" + sessionID + ".bin"
+}
@@ -0,0 +1,47 @@
+// slots_test.go
+package main
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSlotManagerAffinity(t *testing.T) {
+	m := &SlotManager{}
+	m.Configure(&Config{
+		LLMBackend: "gguf",
+		Slots:      SlotsConfig{Affinity: true},
+		Llama:      map[string]interface{}{"parallel": 2},
+	})
+	client := NewLocalLLMClient("http://localhost:32182/v1", "", "")
+	ctx := context.Background()
+
+	a, releaseA := m.Acquire(ctx, client, "a")
+	b, releaseB := m.Acquire(ctx, client, "b")
+	require.NotNil(t, a)
+	require.NotNil(t, b)
+	assert.NotEqual(t, *a, *b)
+
+	// Both slots are busy, llama-server picks one
+	c, releaseC := m.Acquire(ctx, client, "c")
+	assert.Nil(t, c)
+	releaseC()
+
+	releaseA()
+	releaseB()
+
+	// Sessions keep their slot across turns
+	again, release := m.Acquire(ctx, client, "a")
+	require.NotNil(t, again)
+	assert.Equal(t, *a, *again)
+	release()
+
+	// A new session takes over the least recently used slot
+	c, releaseC = m.Acquire(ctx, client, "c")
+	require.NotNil(t, c)
+	assert.Equal(t, *b, *c)
+	releaseC()
+}
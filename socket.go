@@ -4,9 +4,17 @@ package main
 
 import (
 	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
 	"encoding/json"
-	"log"
+	"errors"
+	"html"
+	"log/slog"
 	"net/http"
+	"strings"
+	"sync"
+	"time"
 
 	"github.com/gorilla/websocket"
 	"github.com/labstack/echo/v4"
@@ -24,9 +32,17 @@ type WebSocketMessage struct {
 	RoleInstructions string                 `json:"role_instructions"`
 	Model            string                 `json:"model"`
 	Headers          map[string]interface{} `json:"HEADERS"`
+	Loras            LoraSelection          `json:"loras,omitempty"`  // LoRA scales by name for the rest of the session
+	Images           string                 `json:"images,omitempty"` // comma separated ids from /v1/images
+	Role             string                 `json:"role,omitempty"`   // default role for the rest of the session
+	RoleVariables    map[string]string      `json:"role_variables,omitempty"`
+	Mode             string                 `json:"mode,omitempty"`          // "plan" runs the turn in plan mode, "compare" answers it with both compare backends
+	ShellConfirm     string                 `json:"shell_confirm,omitempty"` // answer to a shell command confirmation, "yes" or "no"
 }
 
-func handleWebSocketConnection(c echo.Context) error {
+// handleWebSocketConnection runs a chat session, each turn taking a token of the client's chat rate
+// limit.
+func handleWebSocketConnection(c echo.Context, config *Config, chatTurns *clientRateLimit) error {
 
 	// Upgrade the HTTP connection to a WebSocket connection.
 	ws, err := upgrader.Upgrade(c.Response(), c.Request(), nil)
@@ -36,21 +52,78 @@ func handleWebSocketConnection(c echo.Context) error {
 	}
 	defer ws.Close()
 
+	// Every websocket connection is a chat session, tag its log records with the session ID
+	sessionID := newSessionID()
+	logger := slog.Default().With("session_id", sessionID)
+	ctx := withLogger(context.Background(), logger)
+	logger.Info("websocket session opened", "remote_addr", c.RealIP())
+	defer slotManager.EndSession(sessionID)
+
 	var responseBuffer bytes.Buffer
 
+	// LoRA adapters selected for this session, applied on top of the attached ones
+	var sessionLoras LoraSelection
+
+	// Role used when a message carries no instructions of its own, and the values of its variables
+	var sessionRole string
+	var sessionRoleVariables map[string]string
+
+	// Messages are read in the background so a running turn gets the answers it waits for
+	reader := newSessionReader(ws)
+	ctx = withSessionReader(ctx, reader)
+
 	for {
 		var wsMessage WebSocketMessage
 
-		// Read and unmarshal the initial WebSocket message
-		wsMessage, err = readAndUnmarshalMessage(ws)
+		// Wait for the next chat message
+		wsMessage, err = reader.Next()
 		if err != nil {
+			logger.Info("websocket session closed", "reason", err)
 			return err
 		}
 
+		// Turns over the limit are answered without reaching the model, the session stays open
+		if !chatTurns.Allow(c) {
+			limited := responseHTML(0, []byte("Rate limit exceeded, try again in "+chatTurns.retryAfter+"s."))
+			if err := ws.WriteMessage(websocket.TextMessage, []byte(limited)); err != nil {
+				return err
+			}
+			continue
+		}
+
 		userPrompt := wsMessage.ChatMessage
 
-		// Get the system instructions (assuming they are part of the message)
-		cpt := GetSystemTemplate(wsMessage.RoleInstructions, userPrompt)
+		turnID := newSessionID()
+		turnCtx := withTurn(withLogger(ctx, logger.With("turn_id", turnID)), TurnInfo{SessionID: sessionID, TurnID: turnID})
+
+		// Blocked prompts never reach the model, redacted ones reach it redacted
+		if guardrails != nil {
+			result := guardrails.Check(turnCtx, GuardrailPrompt, userPrompt)
+			if result.Blocked {
+				blocked := responseHTML(0, []byte(html.EscapeString(guardrails.BlockMessage())))
+				if err := ws.WriteMessage(websocket.TextMessage, []byte(blocked)); err != nil {
+					return err
+				}
+				continue
+			}
+			userPrompt = result.Text
+		}
+		if wsMessage.Loras != nil {
+			sessionLoras = wsMessage.Loras
+		}
+		if wsMessage.Role != "" {
+			sessionRole = wsMessage.Role
+		}
+		if wsMessage.RoleVariables != nil {
+			sessionRoleVariables = wsMessage.RoleVariables
+		}
+
+		// Get the system instructions, falling back on the session's role
+		instructions, err := sessionInstructions(wsMessage.RoleInstructions, sessionRole, sessionRoleVariables)
+		if err != nil {
+			logger.Warn("failed to resolve role instructions", "role", sessionRole, "error", err)
+		}
+		cpt := GetSystemTemplate(instructions, userPrompt)
 
 		// Get the model path from the name of the model from the database
 		models, err := db.GetModels()
@@ -63,11 +136,7 @@ func handleWebSocketConnection(c echo.Context) error {
 			if model.Name == wsMessage.Model {
 				modelPath = model.Path
 
-				// Print the model path
-				log.Println("Model path:", modelPath)
-
-				// Set the model in the LLM client
-				llmClient.SetModel(modelPath)
+				logger.Debug("resolved model path", "model", model.Name, "path", modelPath)
 			}
 		}
 
@@ -79,15 +148,88 @@ func handleWebSocketConnection(c echo.Context) error {
 			MaxTokens:   16384,
 			Stream:      true,
 		}
+
+		// Show the model the role's examples closest to this prompt
+		payload.Messages = withRoleExamples(ctx, payload.Messages, sessionRole, userPrompt)
+
+		// Attach uploaded images to the user message for vision models
+		if wsMessage.Images != "" {
+			images, err := imageDataURLs(config.DataPath, strings.Split(wsMessage.Images, ","))
+			if err != nil {
+				logger.Warn("failed to load chat images", "error", err)
+			} else {
+				payload.Messages[len(payload.Messages)-1].Images = images
+			}
+		}
+
 		// Clear the response buffer
 		responseBuffer.Reset()
 
-		// Pass llmClient as an argument
-		err = StreamCompletionToWebSocket(ws, llmClient, 0, wsMessage.Model, payload, &responseBuffer)
+		// Start the backend again if it was unloaded for inactivity, then hold the turn while it loads
+		idleMonitor.EnsureLoaded()
+		if !backendReadiness.Ready() {
+			loadingMsg := "<div id='progress' class='progress-bar placeholder-wave fs-5' style='width: 100%;'>Loading model...</div>"
+			ws.WriteMessage(websocket.TextMessage, []byte(loadingMsg))
+			if err := backendReadiness.Wait(c.Request().Context()); err != nil {
+				return err
+			}
+		}
+
+		// Hold on to the active backend for the whole turn so a model swap drains it before stopping it
+		client, release := backendFor(wsMessage.Model)
+		if modelPath != "" {
+			client.SetModel(modelPath)
+		}
+
+		payload.Lora, err = loraScales(ctx, client, sessionLoras)
+		if err != nil {
+			logger.Warn("failed to resolve lora adapters", "error", err)
+		}
+		turnCtx = withLoraScales(turnCtx, client, payload.Lora)
+
+		// Keep the session on the same llama-server slot so its KV cache is reused
+		slot, releaseSlot := slotManager.Acquire(ctx, client, sessionID)
+		payload.IDSlot = slot
+		payload.CachePrompt = slot != nil
+
+		// Every chat turn is traced as its own root span so a slow turn can be inspected on its own
+		span, turnCtx := startSpan(turnCtx, "chat.turn")
+		span.SetTag("session_id", sessionID)
+		span.SetTag("turn_id", turnID)
+		span.SetTag("model", wsMessage.Model)
+		if config.Planner.Enabled && (wsMessage.Mode == "plan" || config.Planner.Default) {
+			turnCtx = withPlanMode(turnCtx)
+			span.SetTag("mode", "plan")
+		}
+
+		turn := ChatTurn{Role: sessionRole, Instructions: instructions, UserPrompt: userPrompt}
+		if wsMessage.Mode == "compare" && comparison != nil {
+			span.SetTag("mode", "compare")
+			err = comparison.Run(turnCtx, ws, client, payload, turn)
+			finishSpan(span, err)
+			releaseSlot()
+			release()
+			if err != nil {
+				return err
+			}
+			continue
+		}
+
+		err = StreamCompletionToWebSocket(turnCtx, ws, client, 0, wsMessage.Model, payload, &responseBuffer)
+		finishSpan(span, err)
+		releaseSlot()
+		release()
 		if err != nil {
 			return err
 		}
 
+		// Stored responses can be rated from the chat
+		responseID := recordChatHistory(turnCtx, turn, wsMessage.Model, responseBuffer.String())
+		if responseID != 0 {
+			if err := ws.WriteMessage(websocket.TextMessage, []byte(feedbackHTML(TurnCounter, responseID))); err != nil {
+				return err
+			}
+		}
 	}
 }
 
@@ -108,3 +250,134 @@ func readAndUnmarshalMessage(c *websocket.Conn) (WebSocketMessage, error) {
 
 	return wsMessage, nil
 }
+
+// sessionReader reads the messages of a chat session in the background. Shell command confirmations
+// go to the command waiting for them and every other message is queued for the next turn.
+type sessionReader struct {
+	mu      sync.Mutex
+	queue   []WebSocketMessage
+	err     error
+	confirm chan string   // the confirmation being waited for, nil when none
+	ready   chan struct{} // signaled when a message is queued or the read fails
+	closed  chan struct{} // closed when the read fails
+}
+
+// newSessionReader starts reading the messages of ws until it fails.
+func newSessionReader(ws *websocket.Conn) *sessionReader {
+	r := &sessionReader{ready: make(chan struct{}, 1), closed: make(chan struct{})}
+	go r.run(ws)
+	return r
+}
+
+func (r *sessionReader) run(ws *websocket.Conn) {
+	for {
+		wsMessage, err := readAndUnmarshalMessage(ws)
+
+		r.mu.Lock()
+		switch {
+		case err != nil:
+			r.err = err
+		case wsMessage.ShellConfirm != "":
+			// Answers to confirmations that already timed out are dropped
+			if r.confirm != nil {
+				r.confirm <- wsMessage.ShellConfirm
+				r.confirm = nil
+			}
+		default:
+			r.queue = append(r.queue, wsMessage)
+		}
+		r.mu.Unlock()
+
+		select {
+		case r.ready <- struct{}{}:
+		default:
+		}
+		if err != nil {
+			close(r.closed)
+			return
+		}
+	}
+}
+
+// Next returns the next chat message, waiting for it, or the error that ended the session.
+func (r *sessionReader) Next() (WebSocketMessage, error) {
+	for {
+		r.mu.Lock()
+		if len(r.queue) > 0 {
+			wsMessage := r.queue[0]
+			r.queue = r.queue[1:]
+			r.mu.Unlock()
+			return wsMessage, nil
+		}
+		err := r.err
+		r.mu.Unlock()
+		if err != nil {
+			return WebSocketMessage{}, err
+		}
+		<-r.ready
+	}
+}
+
+// Confirmation waits for the answer to a shell command confirmation until the timeout passes or
+// ctx is done.
+func (r *sessionReader) Confirmation(ctx context.Context, timeout time.Duration) (string, error) {
+	answer := make(chan string, 1)
+	r.mu.Lock()
+	if r.err != nil {
+		r.mu.Unlock()
+		return "", r.err
+	}
+	r.confirm = answer
+	r.mu.Unlock()
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	var err error
+	select {
+	case confirmed := <-answer:
+		return confirmed, nil
+	case <-timer.C:
+		err = errors.New("no answer to the confirmation")
+	case <-r.closed:
+		err = errors.New("chat session closed")
+	case <-ctx.Done():
+		err = ctx.Err()
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.confirm == answer {
+		r.confirm = nil
+	}
+	// The answer may have arrived while giving up
+	select {
+	case confirmed := <-answer:
+		return confirmed, nil
+	default:
+		return "", err
+	}
+}
+
+// sessionReaderKey is the context key carrying the reader of a chat session.
+type sessionReaderKey struct{}
+
+// withSessionReader returns a copy of ctx that carries the reader of the chat session.
+func withSessionReader(ctx context.Context, r *sessionReader) context.Context {
+	return context.WithValue(ctx, sessionReaderKey{}, r)
+}
+
+// sessionReaderFromContext returns the chat session reader stored in ctx, or nil.
+func sessionReaderFromContext(ctx context.Context) *sessionReader {
+	r, _ := ctx.Value(sessionReaderKey{}).(*sessionReader)
+	return r
+}
+
+// newSessionID returns a random identifier for a websocket chat session.
+func newSessionID() string {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return "unknown"
+	}
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,370 @@
+// manifold/sources.go
+
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"gorm.io/gorm"
+
+	"manifold/internal/documents"
+	"manifold/internal/schedule"
+	"manifold/internal/web"
+)
+
+// Kinds of tracked sources.
+const (
+	sourceKindURL = "url"
+	sourceKindGit = "git"
+	sourceKindRSS = "rss"
+)
+
+const (
+	// sourceCheckInterval is how often the scheduler looks for sources due for a refresh.
+	sourceCheckInterval = time.Minute
+	// maxFeedItems is the number of the latest items of a feed indexed on each refresh.
+	maxFeedItems = 50
+	// maxFeedBytes is the largest feed fetched.
+	maxFeedBytes = 10 << 20
+)
+
+// errSourceRefreshing is returned by SourceScheduler.Refresh for a source already being refreshed.
+var errSourceRefreshing = errors.New("source is already being refreshed")
+
+// fetchWebPage returns the content of a web page as Markdown.
+var fetchWebPage = web.WebGetHandler
+
+// SourceScheduler re-ingests the tracked sources on their schedules.
+type SourceScheduler struct {
+	mu         sync.Mutex
+	refreshing map[int64]bool
+}
+
+var sourceScheduler = &SourceScheduler{}
+
+// Run refreshes the sources due until ctx is canceled.
+func (s *SourceScheduler) Run(ctx context.Context) {
+	ticker := time.NewTicker(sourceCheckInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			s.refreshDue(ctx)
+		}
+	}
+}
+
+// refreshDue refreshes the sources whose next refresh has come, one at a time.
+func (s *SourceScheduler) refreshDue(ctx context.Context) {
+	if db == nil || docManager == nil {
+		return
+	}
+	due, err := db.DueURLTrackings(time.Now())
+	if err != nil {
+		slog.Error("failed to list sources due for a refresh", "error", err)
+		return
+	}
+	for i := range due {
+		if ctx.Err() != nil {
+			return
+		}
+		s.Refresh(ctx, &due[i])
+	}
+}
+
+// Refresh re-fetches and re-indexes a source, and records the outcome and its next refresh. A source
+// already being refreshed is skipped with errSourceRefreshing.
+func (s *SourceScheduler) Refresh(ctx context.Context, tracking *URLTracking) error {
+	s.mu.Lock()
+	if s.refreshing == nil {
+		s.refreshing = make(map[int64]bool)
+	}
+	if s.refreshing[tracking.ID] {
+		s.mu.Unlock()
+		return errSourceRefreshing
+	}
+	s.refreshing[tracking.ID] = true
+	s.mu.Unlock()
+	defer func() {
+		s.mu.Lock()
+		delete(s.refreshing, tracking.ID)
+		s.mu.Unlock()
+	}()
+
+	started := time.Now()
+	docs, chunks, err := refreshSource(ctx, tracking)
+	notifyIngest(IngestEvent{Job: "refresh", Source: tracking.URL, Documents: docs, Chunks: chunks}, err)
+
+	now := time.Now().UTC()
+	tracking.LastRefreshAt = &now
+	tracking.LastDocuments, tracking.LastChunks = docs, chunks
+	tracking.LastStatus, tracking.LastError = "ok", ""
+	if err != nil {
+		tracking.LastStatus, tracking.LastError = "failed", err.Error()
+		slog.Warn("failed to refresh source", "url", tracking.URL, "kind", tracking.Kind, "error", err)
+	} else {
+		slog.Info("refreshed source", "url", tracking.URL, "kind", tracking.Kind, "documents", docs, "chunks", chunks, "took", time.Since(started).Round(time.Millisecond))
+	}
+	tracking.NextRefreshAt = nextRefresh(tracking.Schedule, now)
+	if saveErr := db.SaveURLTracking(tracking); saveErr != nil {
+		slog.Error("failed to record source refresh", "url", tracking.URL, "error", saveErr)
+	}
+	return err
+}
+
+// nextRefresh returns the next time of the schedule after now, nil when it has none.
+func nextRefresh(expr string, now time.Time) *time.Time {
+	if expr == "" {
+		return nil
+	}
+	s, err := schedule.Parse(expr)
+	if err != nil {
+		return nil
+	}
+	next := s.Next(now)
+	if next.IsZero() {
+		return nil
+	}
+	return &next
+}
+
+// refreshSource fetches a source and indexes its content, replacing that of its previous refresh.
+// It returns the number of documents and chunks indexed.
+func refreshSource(ctx context.Context, tracking *URLTracking) (docs, chunks int, err error) {
+	if docManager == nil || docManager.IndexManager == nil {
+		return 0, 0, errors.New("document store is not initialized")
+	}
+
+	switch tracking.Kind {
+	case sourceKindGit:
+		loader := documents.NewGitLoader(documents.ClonePath(filepath.Join(os.TempDir(), "git_repos"), tracking.URL), tracking.URL, tracking.Branch, "", nil, false, docManager, docManager.IndexManager)
+		err := loader.Load()
+		ingested, _ := loader.Changes()
+		return ingested, 0, err
+	case sourceKindRSS:
+		return refreshFeed(ctx, tracking.URL)
+	default:
+		content, err := fetchWebPage(tracking.URL)
+		if err != nil {
+			return 0, 0, err
+		}
+		return storeSourceDocuments(ctx, []documents.Document{{
+			PageContent: content,
+			Metadata:    map[string]string{"source": tracking.URL, "file_path": tracking.URL},
+		}})
+	}
+}
+
+// refreshFeed indexes the latest items of an RSS or Atom feed, each as a document keyed by its ID.
+// Items without content are fetched from their link.
+func refreshFeed(ctx context.Context, feedURL string) (int, int, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, feedURL, nil)
+	if err != nil {
+		return 0, 0, err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return 0, 0, fmt.Errorf("failed to fetch feed: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return 0, 0, fmt.Errorf("failed to fetch feed: %s", resp.Status)
+	}
+	items, err := web.ParseFeed(io.LimitReader(resp.Body, maxFeedBytes))
+	if err != nil {
+		return 0, 0, err
+	}
+	if len(items) > maxFeedItems {
+		items = items[:maxFeedItems]
+	}
+
+	var docs []documents.Document
+	seen := make(map[string]bool, len(items))
+	for _, item := range items {
+		content := item.Content
+		if strings.TrimSpace(content) == "" && item.Link != "" {
+			if content, err = fetchWebPage(item.Link); err != nil {
+				slog.Warn("failed to fetch feed item", "feed", feedURL, "url", item.Link, "error", err)
+				continue
+			}
+		}
+		if item.ID == "" || seen[item.ID] || strings.TrimSpace(content) == "" {
+			continue
+		}
+		seen[item.ID] = true
+		if item.Title != "" {
+			content = item.Title + "\n\n" + content
+		}
+		docs = append(docs, documents.Document{PageContent: content, Metadata: map[string]string{
+			"source":    item.ID,
+			"file_path": item.Link,
+			"title":     item.Title,
+			"feed":      feedURL,
+			"doc_type":  documents.DocTypeWeb,
+		}})
+	}
+	if len(docs) == 0 {
+		return 0, 0, nil
+	}
+	return storeSourceDocuments(ctx, docs)
+}
+
+// storeSourceDocuments indexes the documents of a source and returns the number of documents and
+// chunks indexed. It fails when every document did.
+func storeSourceDocuments(ctx context.Context, docs []documents.Document) (int, int, error) {
+	results, err := storeDocuments(ctx, docs)
+	if err != nil {
+		return 0, 0, err
+	}
+	stored, chunks := 0, 0
+	var failure string
+	for _, result := range results {
+		if result.Error != "" {
+			failure = result.Error
+			continue
+		}
+		stored++
+		chunks += result.Chunks
+	}
+	if stored == 0 && failure != "" {
+		return 0, 0, errors.New(failure)
+	}
+	return stored, chunks, nil
+}
+
+// SourceRequest tracks a source, or changes its branch and schedule.
+type SourceRequest struct {
+	URL      string `json:"url"`
+	Kind     string `json:"kind"` // url when empty
+	Branch   string `json:"branch"`
+	Schedule string `json:"schedule"`
+}
+
+// validate checks the kind and schedule of the request.
+func (r *SourceRequest) validate() error {
+	if r.Kind == "" {
+		r.Kind = sourceKindURL
+	}
+	switch r.Kind {
+	case sourceKindURL, sourceKindGit, sourceKindRSS:
+	default:
+		return fmt.Errorf("kind must be %s, %s or %s", sourceKindURL, sourceKindGit, sourceKindRSS)
+	}
+	if r.Branch != "" && r.Kind != sourceKindGit {
+		return errors.New("only git sources have a branch")
+	}
+	if r.Schedule != "" {
+		if _, err := schedule.Parse(r.Schedule); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// handleGetSources lists the tracked sources and how their last refresh went.
+func handleGetSources(c echo.Context) error {
+	sources, err := db.ListURLTrackings()
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to load sources"})
+	}
+	return c.JSON(http.StatusOK, sources)
+}
+
+// handleCreateSource tracks a source, refreshed on its schedule from then on.
+func handleCreateSource(c echo.Context) error {
+	var req SourceRequest
+	if err := c.Bind(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid request body"})
+	}
+	if req.URL == "" {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "url is required"})
+	}
+	if err := req.validate(); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+	}
+
+	tracking := URLTracking{URL: req.URL, Kind: req.Kind, Branch: req.Branch, Schedule: req.Schedule}
+	tracking.NextRefreshAt = nextRefresh(tracking.Schedule, time.Now().UTC())
+	if err := db.CreateURLTracking(&tracking); err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to track source"})
+	}
+	return c.JSON(http.StatusCreated, tracking)
+}
+
+// sourceParam returns the tracked source of the id path parameter, or responds with an error.
+func sourceParam(c echo.Context) (*URLTracking, error) {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		return nil, c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid source id"})
+	}
+	tracking, err := db.GetURLTracking(id)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, c.JSON(http.StatusNotFound, map[string]string{"error": "Source not found"})
+	}
+	if err != nil {
+		return nil, c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to load source"})
+	}
+	return &tracking, nil
+}
+
+// handleUpdateSource changes the branch and schedule of a tracked source.
+func handleUpdateSource(c echo.Context) error {
+	tracking, err := sourceParam(c)
+	if tracking == nil {
+		return err
+	}
+	var req SourceRequest
+	if err := c.Bind(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid request body"})
+	}
+	req.URL, req.Kind = tracking.URL, tracking.Kind
+	if err := req.validate(); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+	}
+
+	tracking.Branch, tracking.Schedule = req.Branch, req.Schedule
+	tracking.NextRefreshAt = nextRefresh(tracking.Schedule, time.Now().UTC())
+	if err := db.SaveURLTracking(tracking); err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to update source"})
+	}
+	return c.JSON(http.StatusOK, tracking)
+}
+
+// handleDeleteSource stops tracking a source. Its documents stay indexed.
+func handleDeleteSource(c echo.Context) error {
+	tracking, err := sourceParam(c)
+	if tracking == nil {
+		return err
+	}
+	if err := db.DeleteURLTracking(tracking.ID); err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to delete source"})
+	}
+	return c.NoContent(http.StatusNoContent)
+}
+
+// handleRefreshSource refreshes a tracked source now and returns it with the outcome.
+func handleRefreshSource(c echo.Context) error {
+	tracking, err := sourceParam(c)
+	if tracking == nil {
+		return err
+	}
+	if err := sourceScheduler.Refresh(c.Request().Context(), tracking); errors.Is(err, errSourceRefreshing) {
+		return c.JSON(http.StatusConflict, map[string]string{"error": err.Error()})
+	}
+	return c.JSON(http.StatusOK, tracking)
+}
@@ -0,0 +1,117 @@
+// sources_test.go
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"manifold/internal/documents"
+)
+
+func TestSources(t *testing.T) {
+	testDB, err := NewSQLiteDB(t.TempDir())
+	require.NoError(t, err)
+	require.NoError(t, testDB.AutoMigrate(&URLTracking{}))
+	savedDB := db
+	db = testDB
+	defer func() { db = savedDB }()
+
+	index, err := documents.NewIndexManager(filepath.Join(t.TempDir(), "index"))
+	require.NoError(t, err)
+	defer index.Index.Close()
+	savedManager := docManager
+	docManager = documents.NewDocumentManager(2048, 0, index)
+	defer func() { docManager = savedManager }()
+
+	feed := `<?xml version="1.0"?>
+<rss version="2.0"><channel><title>News</title>
+<item><guid>item-1</guid><title>First</title><link>https://example.com/1</link><description>&lt;p&gt;Bleve indexes the chunks.&lt;/p&gt;</description></item>
+<item><guid>item-2</guid><title>Second</title><link>https://example.com/2</link></item>
+</channel></rss>`
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, feed)
+	}))
+	defer server.Close()
+
+	savedFetch := fetchWebPage
+	fetchWebPage = func(address string) (string, error) {
+		if address == "https://example.com/2" {
+			return "The second item is fetched from its link.", nil
+		}
+		return "", errors.New("unreachable")
+	}
+	defer func() { fetchWebPage = savedFetch }()
+
+	e := echo.New()
+	call := func(method, path, body string, handler echo.HandlerFunc, names ...string) (int, URLTracking) {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		rec := httptest.NewRecorder()
+		c := e.NewContext(req, rec)
+		if len(names) > 0 {
+			c.SetParamNames("id")
+			c.SetParamValues(names[0])
+		}
+		require.NoError(t, handler(c))
+		var tracking URLTracking
+		json.Unmarshal(rec.Body.Bytes(), &tracking)
+		return rec.Code, tracking
+	}
+
+	code, _ := call(http.MethodPost, "/v1/sources", `{"url": "https://example.com", "kind": "ftp"}`, handleCreateSource)
+	assert.Equal(t, http.StatusBadRequest, code)
+	code, _ = call(http.MethodPost, "/v1/sources", `{"url": "https://example.com", "schedule": "every day"}`, handleCreateSource)
+	assert.Equal(t, http.StatusBadRequest, code)
+
+	code, source := call(http.MethodPost, "/v1/sources", `{"url": "`+server.URL+`", "kind": "rss", "schedule": "@hourly"}`, handleCreateSource)
+	require.Equal(t, http.StatusCreated, code)
+	require.NotNil(t, source.NextRefreshAt)
+	assert.True(t, source.NextRefreshAt.After(time.Now()))
+	id := fmt.Sprint(source.ID)
+
+	code, source = call(http.MethodPost, "/v1/sources/"+id+"/refresh", "", handleRefreshSource, id)
+	require.Equal(t, http.StatusOK, code)
+	assert.Equal(t, "ok", source.LastStatus)
+	assert.Equal(t, 2, source.LastDocuments)
+	assert.Positive(t, source.LastChunks)
+	require.NotNil(t, source.LastRefreshAt)
+	version, _, err := index.DocumentVersion("item-2")
+	require.NoError(t, err)
+	assert.Equal(t, 1, version)
+
+	// The scheduler refreshes the sources due, recording failures
+	page, err := testDB.GetURLTracking(source.ID)
+	require.NoError(t, err)
+	page.ID, page.URL, page.Kind = 0, "https://example.com/down", sourceKindURL
+	past := time.Now().Add(-time.Minute)
+	page.NextRefreshAt = &past
+	require.NoError(t, testDB.CreateURLTracking(&page))
+	sourceScheduler.refreshDue(context.Background())
+	page, err = testDB.GetURLTracking(page.ID)
+	require.NoError(t, err)
+	assert.Equal(t, "failed", page.LastStatus)
+	assert.Equal(t, "unreachable", page.LastError)
+	require.NotNil(t, page.NextRefreshAt)
+	assert.True(t, page.NextRefreshAt.After(time.Now()))
+
+	code, source = call(http.MethodPut, "/v1/sources/"+id, `{"schedule": ""}`, handleUpdateSource, id)
+	require.Equal(t, http.StatusOK, code)
+	assert.Nil(t, source.NextRefreshAt)
+
+	code, _ = call(http.MethodDelete, "/v1/sources/"+id, "", handleDeleteSource, id)
+	assert.Equal(t, http.StatusNoContent, code)
+	code, _ = call(http.MethodPost, "/v1/sources/"+id+"/refresh", "", handleRefreshSource, id)
+	assert.Equal(t, http.StatusNotFound, code)
+}
@@ -0,0 +1,185 @@
+// manifold/speculative.go
+
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"sync"
+
+	"github.com/labstack/echo/v4"
+)
+
+// LlamaTimings is the timings object llama-server adds to the last chunk of a completion.
+// draft_n and draft_n_accepted are only present when speculative decoding is enabled.
+type LlamaTimings struct {
+	PromptN            int     `json:"prompt_n"`
+	PromptMS           float64 `json:"prompt_ms"`
+	PredictedN         int     `json:"predicted_n"`
+	PredictedMS        float64 `json:"predicted_ms"`
+	PredictedPerSecond float64 `json:"predicted_per_second"`
+	DraftN             int     `json:"draft_n"`
+	DraftNAccepted     int     `json:"draft_n_accepted"`
+}
+
+// SpeculativeStats aggregates llama-server timings to compare generation with and without a draft model.
+type SpeculativeStats struct {
+	mu             sync.Mutex
+	draftRequests  int
+	draftTokens    int
+	acceptedTokens int
+	draftPredicted int
+	draftMS        float64
+	plainRequests  int
+	plainPredicted int
+	plainMS        float64
+}
+
+// SpeculativeMetrics is the summary returned by /v1/speculative.
+type SpeculativeMetrics struct {
+	DraftRequests        int     `json:"draft_requests"`
+	DraftTokens          int     `json:"draft_tokens"`
+	AcceptedTokens       int     `json:"accepted_tokens"`
+	AcceptanceRate       float64 `json:"acceptance_rate"`
+	DraftTokensPerSecond float64 `json:"draft_tokens_per_second"`
+	PlainRequests        int     `json:"plain_requests"`
+	PlainTokensPerSecond float64 `json:"plain_tokens_per_second"`
+	Speedup              float64 `json:"speedup,omitempty"` // 0 until both modes have been measured
+}
+
+var speculativeStats = &SpeculativeStats{}
+
+// Record adds the timings of one completion.
+func (s *SpeculativeStats) Record(t LlamaTimings) {
+	if t.PredictedN == 0 || t.PredictedMS == 0 {
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if t.DraftN > 0 {
+		s.draftRequests++
+		s.draftTokens += t.DraftN
+		s.acceptedTokens += t.DraftNAccepted
+		s.draftPredicted += t.PredictedN
+		s.draftMS += t.PredictedMS
+	} else {
+		s.plainRequests++
+		s.plainPredicted += t.PredictedN
+		s.plainMS += t.PredictedMS
+	}
+}
+
+// Metrics returns the acceptance rate and generation speed with and without the draft model.
+func (s *SpeculativeStats) Metrics() SpeculativeMetrics {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	m := SpeculativeMetrics{
+		DraftRequests:  s.draftRequests,
+		DraftTokens:    s.draftTokens,
+		AcceptedTokens: s.acceptedTokens,
+		PlainRequests:  s.plainRequests,
+	}
+	if s.draftTokens > 0 {
+		m.AcceptanceRate = float64(s.acceptedTokens) / float64(s.draftTokens)
+	}
+	if s.draftMS > 0 {
+		m.DraftTokensPerSecond = float64(s.draftPredicted) / s.draftMS * 1000
+	}
+	if s.plainMS > 0 {
+		m.PlainTokensPerSecond = float64(s.plainPredicted) / s.plainMS * 1000
+	}
+	if m.DraftTokensPerSecond > 0 && m.PlainTokensPerSecond > 0 {
+		m.Speedup = m.DraftTokensPerSecond / m.PlainTokensPerSecond
+	}
+	return m
+}
+
+// applySpeculativeOptions sets the draft model flags on a gguf launch when speculative decoding is enabled.
+func applySpeculativeOptions(config *Config, opts *GGUFOptions) error {
+	configMu.RLock()
+	spec := config.Speculative
+	configMu.RUnlock()
+	if !spec.Enabled || spec.DraftModel == "" {
+		return nil
+	}
+
+	models, err := GetModelsByBackend(db.db, "gguf")
+	if err != nil {
+		return err
+	}
+	draft, ok := findModelByName(models, spec.DraftModel)
+	if !ok {
+		return fmt.Errorf("draft model %s not found", spec.DraftModel)
+	}
+
+	opts.Model.ModelDraft = &draft.Path
+	if spec.DraftMax > 0 {
+		opts.General.Draft = &spec.DraftMax
+	}
+	if spec.DraftMin > 0 {
+		opts.General.DraftMin = &spec.DraftMin
+	}
+	if spec.DraftPMin > 0 {
+		opts.General.DraftPMin = &spec.DraftPMin
+	}
+
+	// The draft model is small, offload it like the main model unless configured
+	if spec.GPULayersDraft != nil {
+		opts.Model.GpuLayersDraft = spec.GPULayersDraft
+	} else if opts.Model.GpuLayersDraft == nil {
+		opts.Model.GpuLayersDraft = opts.Model.GpuLayers
+	}
+	return nil
+}
+
+// handleGetSpeculative returns the speculative decoding settings and metrics.
+func handleGetSpeculative(c echo.Context, config *Config) error {
+	configMu.RLock()
+	spec := config.Speculative
+	configMu.RUnlock()
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"config":  spec,
+		"metrics": speculativeStats.Metrics(),
+	})
+}
+
+// handleSetSpeculative changes the draft model settings and restarts the completions service with them.
+func handleSetSpeculative(c echo.Context, config *Config) error {
+	if config.LLMBackend != "gguf" {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Speculative decoding requires the gguf backend"})
+	}
+
+	configMu.RLock()
+	spec := config.Speculative
+	configMu.RUnlock()
+	if err := c.Bind(&spec); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid request body"})
+	}
+
+	if spec.Enabled {
+		models, err := GetModelsByBackend(db.db, "gguf")
+		if err != nil {
+			return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to load models"})
+		}
+		if _, ok := findModelByName(models, spec.DraftModel); !ok {
+			return c.JSON(http.StatusBadRequest, map[string]string{"error": "Draft model not found"})
+		}
+	}
+
+	// The service restarts outside the lock, it reads the settings while building its flags
+	configMu.Lock()
+	previous := config.Speculative
+	config.Speculative = spec
+	configMu.Unlock()
+	if err := restartCompletionsService(config, false); err != nil {
+		configMu.Lock()
+		config.Speculative = previous
+		configMu.Unlock()
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to restart with draft model: " + err.Error()})
+	}
+
+	return c.JSON(http.StatusOK, spec)
+}
@@ -0,0 +1,23 @@
+// speculative_test.go
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSpeculativeStatsMetrics(t *testing.T) {
+	stats := &SpeculativeStats{}
+	stats.Record(LlamaTimings{PredictedN: 100, PredictedMS: 4000})
+	stats.Record(LlamaTimings{PredictedN: 100, PredictedMS: 2000, DraftN: 80, DraftNAccepted: 60})
+	stats.Record(LlamaTimings{}) // prompt-only completions are ignored
+
+	m := stats.Metrics()
+	assert.Equal(t, 1, m.DraftRequests)
+	assert.Equal(t, 1, m.PlainRequests)
+	assert.InDelta(t, 0.75, m.AcceptanceRate, 1e-9)
+	assert.InDelta(t, 50, m.DraftTokensPerSecond, 1e-9)
+	assert.InDelta(t, 25, m.PlainTokensPerSecond, 1e-9)
+	assert.InDelta(t, 2, m.Speedup, 1e-9)
+}
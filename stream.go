@@ -0,0 +1,84 @@
+// manifold/stream.go
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"html"
+	"strings"
+)
+
+// Tool event types.
+const (
+	ToolEventProgress = "progress" // a status line shown while the tool runs
+	ToolEventContent  = "content"  // a piece of the tool's output
+	ToolEventError    = "error"    // the tool failed, Err is set
+)
+
+// toolPreviewChars is how much of a content event is shown in the chat while the tool runs.
+const toolPreviewChars = 120
+
+// ToolEvent is an update from a streaming tool.
+type ToolEvent struct {
+	Type string
+	Text string
+	Err  error
+}
+
+// StreamingTool is implemented by tools that report progress while they run. ProcessStream sends
+// events on the returned channel and closes it when the tool is done. The tool's output is the
+// concatenation of its content events.
+type StreamingTool interface {
+	Tool
+	ProcessStream(ctx context.Context, input string) <-chan ToolEvent
+}
+
+// collectToolEvents drains a tool's events, passing a status line for every event to progress,
+// and returns the tool's output.
+func collectToolEvents(events <-chan ToolEvent, progress func(string)) (string, error) {
+	var out strings.Builder
+	var err error
+	for event := range events {
+		switch event.Type {
+		case ToolEventContent:
+			out.WriteString(event.Text)
+			if progress != nil {
+				progress(contentPreview(event.Text))
+			}
+		case ToolEventProgress:
+			if progress != nil {
+				progress(event.Text)
+			}
+		case ToolEventError:
+			err = event.Err
+		}
+	}
+	return out.String(), err
+}
+
+// contentPreview is the first line of a content event, shortened for the progress bar.
+func contentPreview(text string) string {
+	line, _, _ := strings.Cut(strings.TrimSpace(text), "\n")
+	if runes := []rune(line); len(runes) > toolPreviewChars {
+		line = string(runes[:toolPreviewChars]) + "…"
+	}
+	return fmt.Sprintf("got %q", line)
+}
+
+// processTool runs a tool, streaming its progress when it supports it.
+func processTool(ctx context.Context, tool Tool, input string, progress func(string)) (string, error) {
+	if streaming, ok := tool.(StreamingTool); ok {
+		return collectToolEvents(streaming.ProcessStream(ctx, input), progress)
+	}
+	return tool.Process(ctx, input)
+}
+
+// toolProgressHTML renders a tool's status for the chat's progress bar.
+func toolProgressHTML(message, status string) string {
+	text := html.EscapeString(message)
+	if status != "" {
+		text += ": " + html.EscapeString(status)
+	}
+	return fmt.Sprintf("<div id='progress' class='progress-bar placeholder-wave fs-5 text-truncate' style='width: 100%%;'>%s</div>", text)
+}
@@ -0,0 +1,83 @@
+// stream_test.go
+package main
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// streamingTool sends its events and a final error, if set.
+type streamingTool struct {
+	events []ToolEvent
+}
+
+func (t *streamingTool) Process(ctx context.Context, input string) (string, error) {
+	return collectToolEvents(t.ProcessStream(ctx, input), nil)
+}
+
+func (t *streamingTool) ProcessStream(ctx context.Context, input string) <-chan ToolEvent {
+	events := make(chan ToolEvent)
+	go func() {
+		defer close(events)
+		for _, event := range t.events {
+			events <- event
+		}
+	}()
+	return events
+}
+
+func (t *streamingTool) Enabled() bool                                                 { return true }
+func (t *streamingTool) SetParams(params map[string]interface{}, config *Config) error { return nil }
+func (t *streamingTool) GetParams() map[string]interface{}                             { return nil }
+
+func TestProcessToolStreams(t *testing.T) {
+	tool := &streamingTool{events: []ToolEvent{
+		{Type: ToolEventProgress, Text: "Found 2 results"},
+		{Type: ToolEventContent, Text: "first page\nmore text"},
+		{Type: ToolEventContent, Text: "second page"},
+	}}
+
+	var updates []string
+	out, err := processTool(context.Background(), tool, "prompt", func(status string) {
+		updates = append(updates, status)
+	})
+	require.NoError(t, err)
+	assert.Equal(t, "first page\nmore textsecond page", out)
+	assert.Equal(t, []string{"Found 2 results", `got "first page"`, `got "second page"`}, updates)
+}
+
+func TestProcessToolStreamError(t *testing.T) {
+	tool := &streamingTool{events: []ToolEvent{
+		{Type: ToolEventContent, Text: "partial"},
+		{Type: ToolEventError, Err: errors.New("connection reset")},
+	}}
+
+	_, err := processTool(context.Background(), tool, "prompt", nil)
+	assert.EqualError(t, err, "connection reset")
+}
+
+func TestProcessToolWithoutStreaming(t *testing.T) {
+	called := false
+	out, err := processTool(context.Background(), &flakyTool{}, "prompt", func(string) { called = true })
+	require.NoError(t, err)
+	assert.Equal(t, "ok", out)
+	assert.False(t, called)
+}
+
+func TestContentPreview(t *testing.T) {
+	long := strings.Repeat("a", toolPreviewChars+10)
+	assert.Equal(t, `got "`+strings.Repeat("a", toolPreviewChars)+`…"`, contentPreview(long))
+	assert.Equal(t, `got "title"`, contentPreview("\n  title\nbody"))
+}
+
+func TestToolProgressHTML(t *testing.T) {
+	out := toolProgressHTML("Searching the web", "Fetching <b>x</b>")
+	assert.Contains(t, out, "Searching the web: Fetching &lt;b&gt;x&lt;/b&gt;")
+	assert.Contains(t, out, "id='progress'")
+	assert.Contains(t, toolProgressHTML("Reading files", ""), ">Reading files</div>")
+}
@@ -0,0 +1,154 @@
+// manifold/stt.go
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"mime/multipart"
+	"net/http"
+	"path/filepath"
+
+	"github.com/labstack/echo/v4"
+)
+
+const (
+	transcriptionsEndpoint = "/audio/transcriptions"
+
+	// maxAudioUploadBytes matches the OpenAI transcription upload limit.
+	maxAudioUploadBytes = 25 << 20
+)
+
+// sttService is the local whisper.cpp server started when stt.provider is "local".
+var sttService *ExternalService
+
+// Transcription is the text recognized in an uploaded audio file.
+type Transcription struct {
+	Text string `json:"text"`
+}
+
+// startSTTService launches the local whisper.cpp server and hands it to the supervisor.
+func startSTTService(ctx context.Context, config *Config, verbose bool) {
+	if config.STT.Provider != "local" || config.STT.Service.Command == "" {
+		return
+	}
+
+	sttService = NewExternalService(config.STT.Service, verbose)
+	if err := sttService.Start(ctx); err != nil {
+		slog.Error("failed to start stt service", "service", config.STT.Service.Name, "error", err)
+		return
+	}
+	supervisor.Register("stt", sttService)
+}
+
+// transcribe sends audio to the configured provider. whisper.cpp's server takes the upload on
+// /inference, OpenAI on /v1/audio/transcriptions; both answer with {"text": ...}.
+func transcribe(ctx context.Context, config *Config, filename string, audio io.Reader, language, prompt string) (text string, err error) {
+	var url, apiKey string
+	fields := map[string]string{"response_format": "json"}
+
+	switch config.STT.Provider {
+	case "openai":
+		url = "https://api.openai.com/v1" + transcriptionsEndpoint
+		apiKey = config.OpenAIAPIKey
+		fields["model"] = config.STT.Model
+		if fields["model"] == "" {
+			fields["model"] = "whisper-1"
+		}
+	case "local":
+		url = fmt.Sprintf("http://%s:%d/inference", config.STT.Service.Host, config.STT.Service.Port)
+	default:
+		return "", fmt.Errorf("speech to text is not configured")
+	}
+
+	if language == "" {
+		language = config.STT.Language
+	}
+	if language != "" {
+		fields["language"] = language
+	}
+	if prompt != "" {
+		fields["prompt"] = prompt
+	}
+
+	var body bytes.Buffer
+	writer := multipart.NewWriter(&body)
+	for k, v := range fields {
+		if err := writer.WriteField(k, v); err != nil {
+			return "", err
+		}
+	}
+	part, err := writer.CreateFormFile("file", filepath.Base(filename))
+	if err != nil {
+		return "", err
+	}
+	if _, err := io.Copy(part, audio); err != nil {
+		return "", err
+	}
+	if err := writer.Close(); err != nil {
+		return "", err
+	}
+
+	span, ctx := startSpan(ctx, "stt.transcribe")
+	span.SetTag("provider", config.STT.Provider)
+	span.SetTag("audio_bytes", body.Len())
+	defer func() { finishSpan(span, err) }()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, &body)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", writer.FormDataContentType())
+	if apiKey != "" {
+		req.Header.Set("Authorization", "Bearer "+apiKey)
+	}
+	injectSpan(ctx, req)
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	span.SetTag("http.status_code", resp.StatusCode)
+
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return "", fmt.Errorf("transcription failed with status %d: %s", resp.StatusCode, msg)
+	}
+
+	var result Transcription
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return "", fmt.Errorf("failed to decode transcription: %w", err)
+	}
+	return result.Text, nil
+}
+
+// handleTranscription transcribes an uploaded audio file, taking the OpenAI multipart form fields.
+func handleTranscription(c echo.Context, config *Config) error {
+	c.Request().Body = http.MaxBytesReader(c.Response(), c.Request().Body, maxAudioUploadBytes+1<<20)
+
+	file, err := c.FormFile("file")
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Audio file is required"})
+	}
+	if file.Size > maxAudioUploadBytes {
+		return c.JSON(http.StatusRequestEntityTooLarge, map[string]string{"error": "Audio file exceeds 25MB"})
+	}
+
+	src, err := file.Open()
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Failed to read audio file"})
+	}
+	defer src.Close()
+
+	text, err := transcribe(c.Request().Context(), config, file.Filename, src, c.FormValue("language"), c.FormValue("prompt"))
+	if err != nil {
+		return c.JSON(http.StatusBadGateway, map[string]string{"error": err.Error()})
+	}
+
+	return c.JSON(http.StatusOK, Transcription{Text: text})
+}
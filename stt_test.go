@@ -0,0 +1,91 @@
+// stt_test.go
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"testing"
+
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHandleTranscription(t *testing.T) {
+	// A whisper.cpp server echoing the upload and its form fields
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/inference" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		file, header, err := r.FormFile("file")
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		defer file.Close()
+		audio, _ := io.ReadAll(file)
+		if string(audio) == "noise" {
+			http.Error(w, "failed to decode audio", http.StatusInternalServerError)
+			return
+		}
+		json.NewEncoder(w).Encode(Transcription{
+			Text: header.Filename + " " + string(audio) + " " + r.FormValue("language") + " " + r.FormValue("prompt") + " " + r.FormValue("response_format"),
+		})
+	}))
+	defer server.Close()
+
+	u, err := url.Parse(server.URL)
+	require.NoError(t, err)
+	port, err := strconv.Atoi(u.Port())
+	require.NoError(t, err)
+	config := &Config{STT: STTConfig{Provider: "local", Language: "en", Service: ServiceConfig{Host: u.Hostname(), Port: port}}}
+
+	tests := []struct {
+		name   string
+		config *Config
+		fields map[string]string
+		audio  string // no file is uploaded when empty
+		status int
+		text   string
+	}{
+		{"default language", config, nil, "speech", http.StatusOK, "note.wav speech en  json"},
+		{"form fields", config, map[string]string{"language": "fr", "prompt": "manifold"}, "speech", http.StatusOK, "note.wav speech fr manifold json"},
+		{"no file", config, map[string]string{"language": "fr"}, "", http.StatusBadRequest, ""},
+		{"provider error", config, nil, "noise", http.StatusBadGateway, ""},
+		{"not configured", &Config{}, nil, "speech", http.StatusBadGateway, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var body bytes.Buffer
+			writer := multipart.NewWriter(&body)
+			for k, v := range tt.fields {
+				require.NoError(t, writer.WriteField(k, v))
+			}
+			if tt.audio != "" {
+				part, err := writer.CreateFormFile("file", "note.wav")
+				require.NoError(t, err)
+				part.Write([]byte(tt.audio))
+			}
+			require.NoError(t, writer.Close())
+
+			req := httptest.NewRequest(http.MethodPost, "/v1/audio/transcriptions", &body)
+			req.Header.Set(echo.HeaderContentType, writer.FormDataContentType())
+			rec := httptest.NewRecorder()
+			require.NoError(t, handleTranscription(echo.New().NewContext(req, rec), tt.config))
+
+			assert.Equal(t, tt.status, rec.Code)
+			if tt.status == http.StatusOK {
+				var got Transcription
+				require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &got))
+				assert.Equal(t, tt.text, got.Text)
+			}
+		})
+	}
+}
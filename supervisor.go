@@ -0,0 +1,325 @@
+// manifold/supervisor.go
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+const (
+	defaultSupervisorInterval = 10 * time.Second
+	defaultMaxRestartBackoff  = 60 * time.Second
+	defaultMaxFailedChecks    = 3
+	initialRestartBackoff     = time.Second
+	healthCheckTimeout        = 2 * time.Second
+)
+
+// Service states reported by the supervisor.
+const (
+	ServiceStarting   = "starting"
+	ServiceHealthy    = "healthy"
+	ServiceUnhealthy  = "unhealthy"
+	ServiceCrashed    = "crashed"
+	ServiceRestarting = "restarting"
+	ServiceStopped    = "stopped"
+)
+
+// ServiceStatus is the health of a supervised service as returned by /v1/services.
+type ServiceStatus struct {
+	Name        string    `json:"name"`
+	URL         string    `json:"url"`
+	PID         int       `json:"pid"`
+	Status      string    `json:"status"`
+	Restarts    int       `json:"restarts"`
+	LastError   string    `json:"last_error,omitempty"`
+	LastCheck   time.Time `json:"last_check"`
+	NextRestart time.Time `json:"next_restart,omitempty"`
+}
+
+// supervisedService tracks a single ExternalService, its restart backoff and its failed health
+// checks in a row.
+type supervisedService struct {
+	service  *ExternalService
+	status   ServiceStatus
+	backoff  time.Duration
+	failures int
+}
+
+// ServiceSupervisor polls the health of the local model services and restarts crashed or hung
+// processes.
+type ServiceSupervisor struct {
+	mu         sync.Mutex
+	services   map[string]*supervisedService
+	interval   time.Duration
+	maxBackoff time.Duration
+	maxFailed  int
+	client     *http.Client
+}
+
+var supervisor = NewServiceSupervisor(SupervisorConfig{})
+
+// NewServiceSupervisor creates a supervisor using the intervals in config, falling back to defaults.
+func NewServiceSupervisor(config SupervisorConfig) *ServiceSupervisor {
+	s := &ServiceSupervisor{
+		services: make(map[string]*supervisedService),
+		client:   &http.Client{Timeout: healthCheckTimeout},
+	}
+	s.Configure(config)
+	return s
+}
+
+// Configure updates the polling interval, maximum restart backoff and failed checks of a hung service.
+func (s *ServiceSupervisor) Configure(config SupervisorConfig) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.interval = defaultSupervisorInterval
+	if config.IntervalSeconds > 0 {
+		s.interval = time.Duration(config.IntervalSeconds) * time.Second
+	}
+	s.maxBackoff = defaultMaxRestartBackoff
+	if config.MaxBackoffSeconds > 0 {
+		s.maxBackoff = time.Duration(config.MaxBackoffSeconds) * time.Second
+	}
+	s.maxFailed = defaultMaxFailedChecks
+	if config.MaxFailedChecks > 0 {
+		s.maxFailed = config.MaxFailedChecks
+	}
+}
+
+// Register adds a started service under the given name, replacing any previous service with that name.
+func (s *ServiceSupervisor) Register(name string, service *ExternalService) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.services[name] = &supervisedService{
+		service: service,
+		backoff: initialRestartBackoff,
+		status: ServiceStatus{
+			Name:   name,
+			URL:    fmt.Sprintf("http://%s:%d", service.config.Host, service.config.Port),
+			PID:    service.PID(),
+			Status: ServiceStarting,
+		},
+	}
+}
+
+// Unregister stops supervising the named service.
+func (s *ServiceSupervisor) Unregister(name string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.services, name)
+}
+
+// Statuses returns the status of every supervised service sorted by name.
+func (s *ServiceSupervisor) Statuses() []ServiceStatus {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	statuses := make([]ServiceStatus, 0, len(s.services))
+	for _, svc := range s.services {
+		statuses = append(statuses, svc.status)
+	}
+	sort.Slice(statuses, func(i, j int) bool { return statuses[i].Name < statuses[j].Name })
+	return statuses
+}
+
+// Run polls every supervised service until ctx is canceled.
+func (s *ServiceSupervisor) Run(ctx context.Context) {
+	s.mu.Lock()
+	interval := s.interval
+	s.mu.Unlock()
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			s.checkAll(ctx)
+		}
+	}
+}
+
+// checkAll checks each service outside the lock so a slow health endpoint does not block status reads.
+func (s *ServiceSupervisor) checkAll(ctx context.Context) {
+	s.mu.Lock()
+	names := make([]string, 0, len(s.services))
+	for name := range s.services {
+		names = append(names, name)
+	}
+	s.mu.Unlock()
+
+	for _, name := range names {
+		s.check(ctx, name)
+	}
+}
+
+// check updates the status of a single service, restarting it with exponential backoff if it crashed.
+// A service failing too many checks in a row is hung, it is killed to be restarted as crashed.
+func (s *ServiceSupervisor) check(ctx context.Context, name string) {
+	s.mu.Lock()
+	svc, ok := s.services[name]
+	if !ok {
+		s.mu.Unlock()
+		return
+	}
+	service := svc.service
+	url := svc.status.URL
+	s.mu.Unlock()
+
+	now := time.Now()
+
+	if service.Stopped() {
+		s.update(name, service, func(st *supervisedService) {
+			st.status.Status = ServiceStopped
+			st.status.LastCheck = now
+		})
+		return
+	}
+
+	if exited, exitErr := service.Exited(); exited {
+		s.handleCrash(name, service, exitErr, now)
+		return
+	}
+
+	status, err := s.probe(ctx, url)
+	hung := false
+	s.update(name, service, func(st *supervisedService) {
+		st.status.PID = service.PID()
+		st.status.LastCheck = now
+		st.status.Status = status
+		st.status.NextRestart = time.Time{}
+		if err != nil {
+			st.status.LastError = err.Error()
+		}
+		if status == ServiceHealthy {
+			st.backoff = initialRestartBackoff
+		}
+		// A model still loading answers 503, only checks failing outright count
+		if status == ServiceUnhealthy {
+			st.failures++
+		} else {
+			st.failures = 0
+		}
+		if st.failures >= s.maxFailed {
+			hung = true
+			st.failures = 0
+			st.status.LastError = fmt.Sprintf("%d health checks failed in a row: %s", s.maxFailed, st.status.LastError)
+		}
+	})
+
+	if hung {
+		slog.Warn("service is not responding, killing it", "service", name, "pid", service.PID())
+		if err := service.Kill(); err != nil {
+			slog.Error("failed to kill hung service", "service", name, "error", err)
+		}
+	}
+}
+
+// handleCrash schedules or performs a restart of a crashed service.
+func (s *ServiceSupervisor) handleCrash(name string, service *ExternalService, exitErr error, now time.Time) {
+	s.mu.Lock()
+	st, ok := s.services[name]
+	if !ok || st.service != service {
+		s.mu.Unlock()
+		return
+	}
+
+	st.status.LastCheck = now
+	if exitErr != nil {
+		st.status.LastError = exitErr.Error()
+	} else {
+		st.status.LastError = "process exited"
+	}
+
+	// First time the crash is seen: schedule the restart after the current backoff
+	if st.status.NextRestart.IsZero() {
+		slog.Warn("service crashed", "service", name, "error", st.status.LastError, "restart_in", st.backoff)
+		st.status.Status = ServiceCrashed
+		st.status.NextRestart = now.Add(st.backoff)
+		s.mu.Unlock()
+		return
+	}
+
+	if now.Before(st.status.NextRestart) {
+		s.mu.Unlock()
+		return
+	}
+
+	st.status.Status = ServiceRestarting
+	s.mu.Unlock()
+
+	err := service.Restart()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	st.status.Restarts++
+	st.backoff *= 2
+	if st.backoff > s.maxBackoff {
+		st.backoff = s.maxBackoff
+	}
+
+	if err != nil {
+		slog.Error("failed to restart service", "service", name, "error", err, "retry_in", st.backoff)
+		st.status.Status = ServiceCrashed
+		st.status.LastError = err.Error()
+		st.status.NextRestart = time.Now().Add(st.backoff)
+		return
+	}
+
+	slog.Info("service restarted", "service", name, "restarts", st.status.Restarts)
+	st.status.Status = ServiceStarting
+	st.status.PID = service.PID()
+	st.status.NextRestart = time.Time{}
+}
+
+// update applies fn to the named service if it is still the same instance that was checked.
+func (s *ServiceSupervisor) update(name string, service *ExternalService, fn func(*supervisedService)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if st, ok := s.services[name]; ok && st.service == service {
+		fn(st)
+	}
+}
+
+// probe calls the service's /health endpoint. llama-server answers 503 while the model is loading;
+// servers without a health endpoint answer 404, which still proves the process is serving HTTP.
+func (s *ServiceSupervisor) probe(ctx context.Context, baseURL string) (string, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, baseURL+"/health", nil)
+	if err != nil {
+		return ServiceUnhealthy, err
+	}
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return ServiceUnhealthy, err
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK, http.StatusNotFound:
+		return ServiceHealthy, nil
+	case http.StatusServiceUnavailable:
+		return ServiceStarting, nil
+	default:
+		return ServiceUnhealthy, fmt.Errorf("health check returned status %d", resp.StatusCode)
+	}
+}
+
+// handleGetServices returns the status of every supervised service.
+func handleGetServices(c echo.Context) error {
+	return c.JSON(http.StatusOK, supervisor.Statuses())
+}
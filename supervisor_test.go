@@ -0,0 +1,67 @@
+// supervisor_test.go
+package main
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestServiceSupervisorKillsHungService(t *testing.T) {
+	// The health endpoint fails while hung is set, and answers 503 like a loading model otherwise
+	var hung atomic.Bool
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if hung.Load() {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+	_, port, err := net.SplitHostPort(server.Listener.Addr().String())
+	require.NoError(t, err)
+	portNumber, err := strconv.Atoi(port)
+	require.NoError(t, err)
+
+	command := filepath.Join(t.TempDir(), "backend")
+	require.NoError(t, os.WriteFile(command, []byte("#!/bin/sh\nexec sleep 60\n"), 0o755))
+	service := NewExternalService(ServiceConfig{Name: "completions", Host: "127.0.0.1", Port: portNumber, Command: command}, false)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	require.NoError(t, service.Start(ctx))
+
+	s := NewServiceSupervisor(SupervisorConfig{MaxFailedChecks: 2})
+	s.Register("completions", service)
+
+	// A loading model is not hung however long it takes
+	for range 3 {
+		s.check(ctx, "completions")
+	}
+	assert.Equal(t, ServiceStarting, s.Statuses()[0].Status)
+
+	hung.Store(true)
+	s.check(ctx, "completions")
+	exited, _ := service.Exited()
+	assert.False(t, exited, "a single failed check is not enough")
+
+	s.check(ctx, "completions")
+	assert.Eventually(t, serviceExited(service), 5*time.Second, 20*time.Millisecond)
+	assert.False(t, service.Stopped())
+	assert.Contains(t, s.Statuses()[0].LastError, "2 health checks failed in a row")
+
+	// The killed process is restarted as crashed
+	s.check(ctx, "completions")
+	status := s.Statuses()[0]
+	assert.Equal(t, ServiceCrashed, status.Status)
+	assert.False(t, status.NextRestart.IsZero())
+}
@@ -0,0 +1,314 @@
+// manifold/templates.go
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"gorm.io/gorm"
+)
+
+// Template variable types.
+const (
+	TemplateVarString  = "string"
+	TemplateVarNumber  = "number"
+	TemplateVarBoolean = "boolean"
+	TemplateVarEnum    = "enum"
+)
+
+// messageVariable is filled with the chat message when a template used from the chat does not get it.
+const messageVariable = "message"
+
+// TemplateVariable is a typed {name} placeholder in a prompt template.
+type TemplateVariable struct {
+	Name        string   `json:"name"`
+	Type        string   `json:"type,omitempty"` // string, number, boolean or enum, string when empty
+	Description string   `json:"description,omitempty"`
+	Default     string   `json:"default,omitempty"`
+	Required    bool     `json:"required,omitempty"`
+	Options     []string `json:"options,omitempty"` // allowed values of an enum
+}
+
+// PromptTemplateVersion is one version of a stored prompt template. Every update adds a version,
+// the highest one is the current template.
+type PromptTemplateVersion struct {
+	ID          uint               `gorm:"primaryKey" json:"id"`
+	Name        string             `gorm:"uniqueIndex:idx_template_version" json:"name"`
+	Version     int                `gorm:"uniqueIndex:idx_template_version" json:"version"`
+	Description string             `json:"description,omitempty"`
+	Template    string             `gorm:"type:text" json:"template"`
+	Variables   []TemplateVariable `gorm:"serializer:json" json:"variables"`
+	CreatedAt   time.Time          `json:"created_at"`
+}
+
+// checkValue validates a value against the variable's type.
+func (v TemplateVariable) checkValue(value string) error {
+	switch v.Type {
+	case "", TemplateVarString:
+		return nil
+	case TemplateVarNumber:
+		if _, err := strconv.ParseFloat(value, 64); err != nil {
+			return fmt.Errorf("variable %q must be a number", v.Name)
+		}
+	case TemplateVarBoolean:
+		if _, err := strconv.ParseBool(value); err != nil {
+			return fmt.Errorf("variable %q must be true or false", v.Name)
+		}
+	case TemplateVarEnum:
+		if !slices.Contains(v.Options, value) {
+			return fmt.Errorf("variable %q must be one of %s", v.Name, strings.Join(v.Options, ", "))
+		}
+	}
+	return nil
+}
+
+// Validate checks the template name, its variable declarations and that every placeholder is declared.
+func (t *PromptTemplateVersion) Validate() error {
+	if !roleNamePattern.MatchString(t.Name) {
+		return fmt.Errorf("invalid template name %q, use letters, digits, - and _", t.Name)
+	}
+	if strings.TrimSpace(t.Template) == "" {
+		return fmt.Errorf("template is required")
+	}
+
+	declared := make(map[string]bool)
+	for _, v := range t.Variables {
+		if !variableNamePattern.MatchString(v.Name) {
+			return fmt.Errorf("invalid variable name %q", v.Name)
+		}
+		if declared[v.Name] {
+			return fmt.Errorf("variable %q is declared twice", v.Name)
+		}
+		declared[v.Name] = true
+
+		switch v.Type {
+		case "", TemplateVarString, TemplateVarNumber, TemplateVarBoolean:
+		case TemplateVarEnum:
+			if len(v.Options) == 0 {
+				return fmt.Errorf("enum variable %q needs options", v.Name)
+			}
+		default:
+			return fmt.Errorf("variable %q has unknown type %q", v.Name, v.Type)
+		}
+		if v.Default != "" {
+			if err := v.checkValue(v.Default); err != nil {
+				return fmt.Errorf("invalid default: %w", err)
+			}
+		}
+	}
+
+	for _, match := range placeholderPattern.FindAllStringSubmatch(t.Template, -1) {
+		if !declared[match[1]] {
+			return fmt.Errorf("placeholder {%s} is not a declared variable", match[1])
+		}
+	}
+	return nil
+}
+
+// Render checks the values against the declared variables and fills them into the template.
+func (t *PromptTemplateVersion) Render(values map[string]string) (string, error) {
+	vars := make(map[string]string, len(t.Variables))
+	for _, v := range t.Variables {
+		value, ok := values[v.Name]
+		if !ok || value == "" {
+			if v.Required && v.Default == "" {
+				return "", fmt.Errorf("template %s requires variable %q", t.Name, v.Name)
+			}
+			value = v.Default
+		}
+		if value != "" {
+			if err := v.checkValue(value); err != nil {
+				return "", err
+			}
+		}
+		vars[v.Name] = value
+	}
+
+	pt := PromptTemplate{Template: t.Template}
+	return pt.Format(vars), nil
+}
+
+// GetPromptTemplate returns a version of a template, the latest one when version is 0.
+func (sqldb *SQLiteDB) GetPromptTemplate(name string, version int) (*PromptTemplateVersion, error) {
+	var t PromptTemplateVersion
+	query := sqldb.db.Where("name = ?", name)
+	if version > 0 {
+		query = query.Where("version = ?", version)
+	}
+	if err := query.Order("version DESC").First(&t).Error; err != nil {
+		return nil, err
+	}
+	return &t, nil
+}
+
+// GetPromptTemplates returns the latest version of every template ordered by name.
+func (sqldb *SQLiteDB) GetPromptTemplates() ([]PromptTemplateVersion, error) {
+	var templates []PromptTemplateVersion
+	err := sqldb.db.Where("version = (SELECT MAX(version) FROM prompt_template_versions AS v WHERE v.name = prompt_template_versions.name)").
+		Order("name").Find(&templates).Error
+	return templates, err
+}
+
+// GetPromptTemplateVersions returns the version history of a template, newest first.
+func (sqldb *SQLiteDB) GetPromptTemplateVersions(name string) ([]PromptTemplateVersion, error) {
+	var versions []PromptTemplateVersion
+	err := sqldb.db.Where("name = ?", name).Order("version DESC").Find(&versions).Error
+	return versions, err
+}
+
+// renderPromptTemplate renders a stored template referenced as name or name@version.
+func renderPromptTemplate(ref string, values map[string]string) (string, error) {
+	name, version := ref, 0
+	if n, v, ok := strings.Cut(ref, "@"); ok {
+		parsed, err := strconv.Atoi(v)
+		if err != nil || parsed <= 0 {
+			return "", fmt.Errorf("invalid template version in %q", ref)
+		}
+		name, version = n, parsed
+	}
+
+	t, err := db.GetPromptTemplate(name, version)
+	if err != nil {
+		return "", fmt.Errorf("template %s not found", ref)
+	}
+	return t.Render(values)
+}
+
+// handleGetPromptTemplates lists the current version of every template.
+func handleGetPromptTemplates(c echo.Context) error {
+	templates, err := db.GetPromptTemplates()
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to load templates"})
+	}
+	return c.JSON(http.StatusOK, templates)
+}
+
+// handleGetPromptTemplate returns a template, the version given by ?version or the latest one.
+func handleGetPromptTemplate(c echo.Context) error {
+	version, _ := strconv.Atoi(c.QueryParam("version"))
+	t, err := db.GetPromptTemplate(c.Param("name"), version)
+	if err != nil {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "Template not found"})
+	}
+	return c.JSON(http.StatusOK, t)
+}
+
+// handleGetPromptTemplateVersions returns the version history of a template.
+func handleGetPromptTemplateVersions(c echo.Context) error {
+	versions, err := db.GetPromptTemplateVersions(c.Param("name"))
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to load template versions"})
+	}
+	if len(versions) == 0 {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "Template not found"})
+	}
+	return c.JSON(http.StatusOK, versions)
+}
+
+// handleCreatePromptTemplate stores the first version of a template.
+func handleCreatePromptTemplate(c echo.Context) error {
+	var t PromptTemplateVersion
+	if err := c.Bind(&t); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid request body"})
+	}
+	if err := t.Validate(); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+	}
+
+	if _, err := db.GetPromptTemplate(t.Name, 0); err == nil {
+		return c.JSON(http.StatusConflict, map[string]string{"error": "Template already exists"})
+	}
+
+	t.ID, t.Version = 0, 1
+	if err := db.Create(&t); err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to create template"})
+	}
+	return c.JSON(http.StatusCreated, t)
+}
+
+// handleUpdatePromptTemplate stores a new version of a template. Earlier versions stay available.
+func handleUpdatePromptTemplate(c echo.Context) error {
+	latest, err := db.GetPromptTemplate(c.Param("name"), 0)
+	if err != nil {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "Template not found"})
+	}
+
+	var t PromptTemplateVersion
+	if err := c.Bind(&t); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid request body"})
+	}
+	t.ID, t.Name, t.Version = 0, latest.Name, latest.Version+1
+	if err := t.Validate(); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+	}
+
+	if err := db.Create(&t); err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to update template"})
+	}
+	return c.JSON(http.StatusOK, t)
+}
+
+// handleDeletePromptTemplate removes a template with all its versions.
+func handleDeletePromptTemplate(c echo.Context) error {
+	name := c.Param("name")
+	result := db.db.Where("name = ?", name).Delete(&PromptTemplateVersion{})
+	if result.Error != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to delete template"})
+	}
+	if result.RowsAffected == 0 {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "Template not found"})
+	}
+	return c.JSON(http.StatusOK, map[string]string{"status": "success", "template": name})
+}
+
+// handleRenderPromptTemplate renders a template with the given variables.
+func handleRenderPromptTemplate(c echo.Context) error {
+	var req struct {
+		Version   int               `json:"version"`
+		Variables map[string]string `json:"variables"`
+	}
+	if err := c.Bind(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid request body"})
+	}
+
+	t, err := db.GetPromptTemplate(c.Param("name"), req.Version)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "Template not found"})
+	}
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to load template"})
+	}
+
+	prompt, err := t.Render(req.Variables)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+	}
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"name":    t.Name,
+		"version": t.Version,
+		"prompt":  prompt,
+	})
+}
+
+// chatTemplatePrompt renders the template selected in the chat form. The chat message fills the
+// message variable unless the form sets it.
+func chatTemplatePrompt(ref, variablesJSON, message string) (string, error) {
+	values := make(map[string]string)
+	if variablesJSON != "" {
+		if err := json.Unmarshal([]byte(variablesJSON), &values); err != nil {
+			return "", fmt.Errorf("invalid template variables: %w", err)
+		}
+	}
+	if _, ok := values[messageVariable]; !ok {
+		values[messageVariable] = message
+	}
+	return renderPromptTemplate(ref, values)
+}
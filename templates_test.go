@@ -0,0 +1,56 @@
+// templates_test.go
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPromptTemplateValidate(t *testing.T) {
+	tmpl := PromptTemplateVersion{
+		Name:     "summarize",
+		Template: "Summarize in {words} words, {tone} tone:\n{message}",
+		Variables: []TemplateVariable{
+			{Name: "words", Type: TemplateVarNumber, Default: "100"},
+			{Name: "tone", Type: TemplateVarEnum, Options: []string{"formal", "casual"}, Default: "casual"},
+			{Name: "message", Required: true},
+		},
+	}
+	require.NoError(t, tmpl.Validate())
+
+	bad := tmpl
+	bad.Variables = bad.Variables[:2]
+	assert.ErrorContains(t, bad.Validate(), "{message}")
+
+	bad = tmpl
+	bad.Variables = []TemplateVariable{{Name: "words", Type: "date"}, {Name: "tone"}, {Name: "message"}}
+	assert.ErrorContains(t, bad.Validate(), "unknown type")
+
+	bad = tmpl
+	bad.Variables = []TemplateVariable{{Name: "words", Type: TemplateVarNumber, Default: "many"}, {Name: "tone"}, {Name: "message"}}
+	assert.ErrorContains(t, bad.Validate(), "must be a number")
+}
+
+func TestPromptTemplateRender(t *testing.T) {
+	tmpl := PromptTemplateVersion{
+		Name:     "summarize",
+		Template: "Summarize in {words} words, {tone} tone:\n{message}",
+		Variables: []TemplateVariable{
+			{Name: "words", Type: TemplateVarNumber, Default: "100"},
+			{Name: "tone", Type: TemplateVarEnum, Options: []string{"formal", "casual"}, Default: "casual"},
+			{Name: "message", Required: true},
+		},
+	}
+
+	out, err := tmpl.Render(map[string]string{"message": "the text", "tone": "formal"})
+	require.NoError(t, err)
+	assert.Equal(t, "Summarize in 100 words, formal tone:\nthe text", out)
+
+	_, err = tmpl.Render(map[string]string{"message": "the text", "tone": "angry"})
+	assert.ErrorContains(t, err, "one of formal, casual")
+
+	_, err = tmpl.Render(map[string]string{"words": "50"})
+	assert.ErrorContains(t, err, "message")
+}
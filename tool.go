@@ -7,17 +7,18 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
-	"log"
+	"log/slog"
 	"net/http"
 	"strings"
 	"sync"
 	"time"
 
+	"manifold/internal/sqlitevec"
 	"manifold/internal/web"
 
-	index "github.com/blevesearch/bleve_index_api"
 	"github.com/gorilla/websocket"
 	"github.com/labstack/echo/v4"
+	"github.com/opentracing/opentracing-go"
 )
 
 // Tool interface defines the contract for all tools.
@@ -36,14 +37,15 @@ type ToolWrapper struct {
 
 // WorkflowManager manages a set of tools and runs them in sequence.
 type WorkflowManager struct {
-	tools []ToolWrapper
+	tools   []ToolWrapper
+	router  *ToolRouter // runs every tool when nil
+	planner *Planner    // nil disables the plan mode
 }
 
 // RegisterTools initializes and registers all enabled tools based on the configuration.
 func RegisterTools(wm *WorkflowManager, config *Config) error {
 	for _, toolConfig := range config.Tools {
-		// Print tool configuration for debugging
-		log.Printf("Tool: %s, Parameters: %v", toolConfig.Name, toolConfig.Parameters)
+		slog.Debug("registering tool", "tool", toolConfig.Name, "parameters", toolConfig.Parameters)
 
 		switch toolConfig.Name {
 		case "websearch":
@@ -73,12 +75,65 @@ func RegisterTools(wm *WorkflowManager, config *Config) error {
 				}
 				wm.AddTool(tool, toolConfig.Name)
 			}
+		case "codeexec":
+			if enabled, ok := toolConfig.Parameters["enabled"].(bool); ok && enabled {
+				tool := &CodeExecTool{}
+				err := tool.SetParams(toolConfig.Parameters, config)
+				if err != nil {
+					return fmt.Errorf("failed to set params for tool %s: %w", toolConfig.Name, err)
+				}
+				wm.AddTool(tool, toolConfig.Name)
+			}
+		case "shell":
+			if enabled, ok := toolConfig.Parameters["enabled"].(bool); ok && enabled {
+				tool := &ShellTool{}
+				err := tool.SetParams(toolConfig.Parameters, config)
+				if err != nil {
+					return fmt.Errorf("failed to set params for tool %s: %w", toolConfig.Name, err)
+				}
+				wm.AddTool(tool, toolConfig.Name)
+			}
+		case "fsread":
+			if enabled, ok := toolConfig.Parameters["enabled"].(bool); ok && enabled {
+				tool := &FSReadTool{}
+				err := tool.SetParams(toolConfig.Parameters, config)
+				if err != nil {
+					return fmt.Errorf("failed to set params for tool %s: %w", toolConfig.Name, err)
+				}
+				wm.AddTool(tool, toolConfig.Name)
+			}
+		case "weather":
+			if enabled, ok := toolConfig.Parameters["enabled"].(bool); ok && enabled {
+				tool := &WeatherTool{}
+				err := tool.SetParams(toolConfig.Parameters, config)
+				if err != nil {
+					return fmt.Errorf("failed to set params for tool %s: %w", toolConfig.Name, err)
+				}
+				wm.AddTool(tool, toolConfig.Name)
+			}
+		case "datetime":
+			if enabled, ok := toolConfig.Parameters["enabled"].(bool); ok && enabled {
+				tool := &DateTimeTool{}
+				err := tool.SetParams(toolConfig.Parameters, config)
+				if err != nil {
+					return fmt.Errorf("failed to set params for tool %s: %w", toolConfig.Name, err)
+				}
+				wm.AddTool(tool, toolConfig.Name)
+			}
+		case "coderag":
+			if enabled, ok := toolConfig.Parameters["enabled"].(bool); ok && enabled {
+				tool := &CodeRAGTool{}
+				err := tool.SetParams(toolConfig.Parameters, config)
+				if err != nil {
+					return fmt.Errorf("failed to set params for tool %s: %w", toolConfig.Name, err)
+				}
+				wm.AddTool(tool, toolConfig.Name)
+			}
 		case "teams":
 			if enabled, ok := toolConfig.Parameters["enabled"].(bool); ok && enabled {
 				teamServiceConfig := config.Services[5]
 
-				// Print the service configuration for debugging
-				log.Printf("Teams Service Config: %v", teamServiceConfig)
+				slog.Debug("teams service configuration", "tool", toolConfig.Name, "service", teamServiceConfig.Name, "port", teamServiceConfig.Port)
 
 				// Prepare parameters including service configuration
 				teamsParams := map[string]interface{}{
@@ -103,7 +158,38 @@ func RegisterTools(wm *WorkflowManager, config *Config) error {
 		}
 	}
 
-	return nil
+	// External tools declared in the plugins section
+	return registerPluginTools(wm, config)
+}
+
+// configuredToolNames returns the names of the tools and plugins in the config.
+func configuredToolNames(config *Config) []string {
+	var names []string
+	for _, toolConfig := range config.Tools {
+		names = append(names, toolConfig.Name)
+	}
+	for _, plugin := range config.Plugins {
+		names = append(names, plugin.Name)
+	}
+	return names
+}
+
+// configureTool applies the parameters from the config to a tool created by name. The teams tool
+// starts its service when configured and is set up by the toggle instead.
+func configureTool(tool Tool, name string, config *Config) error {
+	if name == "teams" {
+		return nil
+	}
+
+	params := configuredToolParams(config, name)
+
+	// The tool is enabled in the database, whatever the config file says
+	enabled := make(map[string]interface{}, len(params)+1)
+	for k, v := range params {
+		enabled[k] = v
+	}
+	enabled["enabled"] = true
+	return tool.SetParams(enabled, config)
 }
 
 // AddTool adds a new tool to the workflow if it is enabled.
@@ -123,6 +209,55 @@ func (wm *WorkflowManager) RemoveTool(name string) error {
 	return fmt.Errorf("tool %s not found", name)
 }
 
+// ReplaceTool swaps the tool registered under name for another instance. The tools are copied so
+// workflows already running keep the instance they started with.
+func (wm *WorkflowManager) ReplaceTool(name string, tool Tool) error {
+	for i, wrapper := range wm.tools {
+		if wrapper.Name == name {
+			tools := append([]ToolWrapper(nil), wm.tools...)
+			tools[i].Tool = tool
+			wm.tools = tools
+			return nil
+		}
+	}
+	return fmt.Errorf("tool %s not found", name)
+}
+
+// toolStatusMessage is shown in the chat while a tool runs.
+func toolStatusMessage(name string) string {
+	switch name {
+	case "websearch":
+		return "Searching the web"
+	case "webget":
+		return "Fetching web content"
+	case "retrieval":
+		return "Trying to remember things"
+	case "teams":
+		return "Asking the team"
+	case "codeexec":
+		return "Running code"
+	case "shell":
+		return "Running commands"
+	case "fsread":
+		return "Reading files"
+	case "weather":
+		return "Checking the weather"
+	case "datetime":
+		return "Checking the time"
+	case "coderag":
+		return "Looking through the code"
+	}
+	if plugin, ok := lookupPlugin(name); ok && plugin.Description != "" {
+		return plugin.Description
+	}
+	return "Running " + name
+}
+
+// SetRouter makes the workflow run only the tools the router picks for each prompt.
+func (wm *WorkflowManager) SetRouter(router *ToolRouter) {
+	wm.router = router
+}
+
 // ListTools returns a list of tool names in the workflow.
 func (wm *WorkflowManager) ListTools() []string {
 	var toolNames []string
@@ -139,36 +274,50 @@ func (wm *WorkflowManager) Run(ctx context.Context, prompt string, c *websocket.
 		return prompt, nil
 	}
 
-	// Get the list of enabled tools and print their names
-	log.Printf("Enabled tools: %v", wm.ListTools())
+	span, ctx := startSpan(ctx, "workflow.run")
+	span.SetTag("tools", strings.Join(wm.ListTools(), ","))
+	defer span.Finish()
+
+	logger := loggerFromContext(ctx)
+	logger.Debug("running workflow", "tools", wm.ListTools())
+
+	tools := wm.tools
+	if wm.router != nil {
+		tools = wm.router.Route(ctx, prompt, tools)
+		if len(tools) == 0 {
+			return prompt, nil
+		}
+	}
 
 	var allContent strings.Builder
 	var teamsResponse string
 
-	for _, wrapper := range wm.tools {
-		var toolMessage string
+	for _, wrapper := range tools {
+		// Tools that keep failing are skipped until their circuit breaker lets a trial call through
+		if _, breaker := toolPolicy(wrapper.Name); breaker.Status().State == CircuitOpen {
+			logger.Debug("skipping tool with open circuit", "tool", wrapper.Name)
+			recordToolInvocation(ctx, wrapper.Name, 0, "", ErrCircuitOpen)
+			continue
+		}
 
-		switch wrapper.Name {
-		case "websearch":
-			toolMessage = "Searching the web"
-		case "webget":
-			toolMessage = "Fetching web content"
-		case "retrieval":
-			toolMessage = "Trying to remember things"
-		case "teams":
-			toolMessage = "Asking the team"
+		toolMessage := toolStatusMessage(wrapper.Name)
+		c.WriteMessage(websocket.TextMessage, []byte(toolProgressHTML(toolMessage, "")))
+		progress := func(status string) {
+			c.WriteMessage(websocket.TextMessage, []byte(toolProgressHTML(toolMessage, status)))
 		}
 
-		formattedContent := fmt.Sprintf("<div id='progress' class='progress-bar placeholder-wave fs-5' style='width: 100%%;'>%s</div>", toolMessage)
-		c.WriteMessage(websocket.TextMessage, []byte(formattedContent))
+		toolLogger := logger.With("tool", wrapper.Name)
+		start := time.Now()
 
-		processed, err := wrapper.Tool.Process(ctx, prompt)
+		toolSpan, toolCtx := startSpan(withWebSocket(withLogger(ctx, toolLogger), c), "tool.process", opentracing.Tag{Key: "tool", Value: wrapper.Name})
+		processed, err := processWithPolicy(toolCtx, wrapper, prompt, progress)
+		finishSpan(toolSpan, err)
 		if err != nil {
-			log.Printf("error processing with tool %s: %v", wrapper.Name, err)
+			toolLogger.Error("error processing with tool", "error", err)
 		}
 
-		// Print the processed output for debugging
-		log.Printf("Processed output from tool %s: %s", wrapper.Name, processed)
+		toolLogger.Debug("tool finished", "duration", time.Since(start), "output_bytes", len(processed))
+		recordToolInvocation(ctx, wrapper.Name, time.Since(start), processed, err)
 
 		if wrapper.Name == "teams" {
 			teamsResponse = processed
@@ -210,43 +359,49 @@ type WebSearchTool struct {
 
 // Process executes the web search tool logic.
 func (t *WebSearchTool) Process(ctx context.Context, input string) (string, error) {
-	//params := t.GetParams()
-	//log.Printf("WebSearchTool: Parameters: %v", params)
-	// Print the search engine and endpoint for debugging
-	//log.Printf("Search Engine: %s, Endpoint: %s", t.SearchEngine, t.Endpoint)
+	return collectToolEvents(t.ProcessStream(ctx, input), nil)
+}
 
-	// Perform search using GetSearXNGResults
-	urls := web.GetSearXNGResults("https://search.intelligence.dev", input)
+// ProcessStream searches the web and streams the content of each result page as it is fetched.
+func (t *WebSearchTool) ProcessStream(ctx context.Context, input string) <-chan ToolEvent {
+	events := make(chan ToolEvent)
+	go func() {
+		defer close(events)
 
-	urls = urls[:3]
+		// Perform search using GetSearXNGResults
+		urls := web.GetSearXNGResults("https://search.intelligence.dev", input)
+		urls = urls[:min(len(urls), 3)]
 
-	if len(urls) == 0 {
-		return "", errors.New("no URLs found after filtering")
-	}
-
-	// Print the URLs for debugging
-	log.Printf("URLs: %v", urls)
-
-	// Fetch contents concurrently
-	type result struct {
-		content string
-		err     error
-	}
+		if len(urls) == 0 {
+			events <- ToolEvent{Type: ToolEventError, Err: errors.New("no URLs found after filtering")}
+			return
+		}
 
-	var aggregatedContent strings.Builder
+		logger := loggerFromContext(ctx)
+		logger.Debug("search results", "urls", urls)
+		events <- ToolEvent{Type: ToolEventProgress, Text: fmt.Sprintf("Found %d results", len(urls))}
 
-	for _, u := range urls {
-		log.Printf("Fetching URL: %s", u)
+		for i, u := range urls {
+			if ctx.Err() != nil {
+				events <- ToolEvent{Type: ToolEventError, Err: ctx.Err()}
+				return
+			}
+			logger.Debug("fetching URL", "url", u)
+			events <- ToolEvent{Type: ToolEventProgress, Text: fmt.Sprintf("Fetching %s (%d/%d)", u, i+1, len(urls))}
+
+			span, _ := startSpan(ctx, "web.fetch", opentracing.Tag{Key: "url", Value: u})
+			content, err := web.WebGetHandler(u)
+			finishSpan(span, err)
+			if err != nil {
+				logger.Warn("failed to fetch content from URL", "url", u, "error", err)
+			}
 
-		content, err := web.WebGetHandler(u)
-		if err != nil {
-			log.Printf("Failed to fetch content from URL %s: %v", u, err)
+			if content != "" {
+				events <- ToolEvent{Type: ToolEventContent, Text: content}
+			}
 		}
-
-		aggregatedContent.WriteString(content)
-	}
-
-	return aggregatedContent.String(), nil
+	}()
+	return events
 }
 
 // Enabled returns the enabled status of the tool.
@@ -315,9 +470,11 @@ func (t *WebGetTool) Process(ctx context.Context, input string) (string, error)
 	var aggregatedContent strings.Builder
 	for _, u := range urls {
 		// Fetch and process content using internal/web's WebGetHandler function
+		span, _ := startSpan(ctx, "web.fetch", opentracing.Tag{Key: "url", Value: u})
 		content, err := web.WebGetHandler(u)
+		finishSpan(span, err)
 		if err != nil {
-			log.Printf("Failed to fetch content from URL %s: %v", u, err)
+			loggerFromContext(ctx).Warn("failed to fetch content from URL", "url", u, "error", err)
 			continue
 		}
 
@@ -327,9 +484,9 @@ func (t *WebGetTool) Process(ctx context.Context, input string) (string, error)
 
 	timestamp := time.Now().Format(time.RFC3339)
 
-	err := SaveChatTurn(input, aggregatedContent.String(), timestamp)
+	err := SaveChatTurn(ctx, input, aggregatedContent.String(), timestamp)
 	if err != nil {
-		log.Printf("Failed to save web document: %v", err)
+		loggerFromContext(ctx).Error("failed to save web document", "error", err)
 	}
 
 	return aggregatedContent.String(), nil
@@ -392,7 +549,7 @@ func (t *RetrievalTool) GetParams() map[string]interface{} {
 // 	}
 
 // 	// Print the retrieved documents for debugging
-// 	log.Printf("Retrieved Documents: %v", documents)
+// 	slog.Debug("retrieved documents", "documents", documents)
 
 // 	// Combine the documents into a single string
 // 	var result strings.Builder
@@ -405,75 +562,43 @@ func (t *RetrievalTool) GetParams() map[string]interface{} {
 // }
 
 func (t *RetrievalTool) Process(ctx context.Context, input string) (string, error) {
-	promptEmbeddings, err := GenerateEmbedding(input)
+	promptEmbeddings, err := GenerateEmbedding(ctx, input)
 	if err != nil {
 		return "", fmt.Errorf("failed to generate embeddings: %w", err)
 	}
 
-	// Use the IndexManager to create a search request based on the input
-	searchRequest := indexManager.CreateSearchRequest(input, 10)
-
-	// Perform the search to retrieve the top N documents
-	searchResults, err := indexManager.SearchChunks(searchRequest)
+	// Retrieve the top documents from the configured backend
+	searchSpan, _ := startSpan(ctx, "retrieval.search")
+	chunks, err := retriever.Retrieve(ctx, input, 10)
+	finishSpan(searchSpan, err)
 	if err != nil {
 		return "", fmt.Errorf("failed to retrieve documents: %w", err)
 	}
 
-	// Print the retrieved search results for debugging
-	log.Printf("Retrieved Documents: %v", searchResults)
+	logger := loggerFromContext(ctx)
+	logger.Debug("retrieved documents", "hits", len(chunks))
 
-	// Combine the retrieved documents' content into a single string
+	// Combine the content of the documents similar to the input into a single string. The
+	// embeddings of the chunks are kept in vec_chunks, so each is only generated once
 	var result strings.Builder
-	for _, hit := range searchResults.Hits {
-		doc, err := indexManager.GetDocument(hit.ID)
-		if err != nil {
-			log.Printf("Error retrieving document %s: %v\n", hit.ID, err)
-			continue
-		}
-
-		// Extract the content of the document (assuming full_content field is stored)
+	for _, doc := range chunks {
 		var content string
+		embeddings, err := db.chunkEmbedding(ctx, doc)
+		if err != nil {
+			logger.Error("error generating embeddings", "error", err)
+		} else {
+			similarity := CosineSimilarity(promptEmbeddings, embeddings)
+			logger.Debug("scored document", "id", doc.ID, "score", doc.Score, "similarity", similarity)
 
-		doc.VisitFields(func(field index.Field) {
-			// Print the field name for debugging
-			log.Printf("Field Name: %s", field.Name())
-
-			if field.Name() == "chunk" {
-				embeddings, err := GenerateEmbedding(string(field.Value()))
-				if err != nil {
-					log.Printf("Error generating embeddings: %v", err)
-				} else {
-					similarity := CosineSimilarity(promptEmbeddings, embeddings)
-					log.Printf("Chunk: %s", string(field.Value()))
-					log.Printf("Search Score: %f", hit.Score)
-					log.Printf("Similarity: %f", similarity)
-
-					// If the similarity is above a certain threshold, add the content to the result
-					if similarity > 0.5 {
-						content += string(field.Value())
-					}
-				}
-
-			} else if field.Name() == "full_content" {
-				embeddings, err := GenerateEmbedding(string(field.Value()))
-				if err != nil {
-					log.Printf("Error generating embeddings: %v", err)
-				} else {
-					similarity := CosineSimilarity(promptEmbeddings, embeddings)
-					log.Printf("Full Document: %s", string(field.Value()))
-					log.Printf("Search Score: %f", hit.Score)
-					log.Printf("Similarity: %f", similarity)
-
-					// If the similarity is above a certain threshold, add the content to the result
-					if similarity > 0.5 {
-						content += string(field.Value())
-					}
-				}
+			// If the similarity is above a certain threshold, add the content to the result
+			if similarity > 0.5 {
+				content = doc.Text
 			}
-		})
+		}
 
-		// Append the content to the result if it is not empty
+		// Append the content to the result if it is not empty, with where it comes from
 		if content != "" {
+			fmt.Fprintf(&result, "[%s]\n", doc.citation())
 			result.WriteString(content)
 			result.WriteString("\n") // Separator between documents
 		} else {
@@ -481,6 +606,18 @@ func (t *RetrievalTool) Process(ctx context.Context, input string) (string, erro
 		}
 	}
 
+	// Add the earlier chat turns similar to the input, searched in vec_chats
+	turns, err := db.SearchChats(ctx, promptEmbeddings, t.topN)
+	if err != nil {
+		logger.Warn("failed to search chat turns", "error", err)
+	}
+	for _, turn := range turns {
+		logger.Debug("scored chat turn", "id", turn.ID, "similarity", turn.Similarity)
+		if turn.Similarity > 0.5 {
+			fmt.Fprintf(&result, "[chat %d]\nUser: %s\nAssistant: %s\n", turn.ID, turn.Prompt, turn.Response)
+		}
+	}
+
 	return result.String(), nil
 }
 
@@ -489,7 +626,8 @@ func (t *RetrievalTool) Enabled() bool {
 	return t.enabled
 }
 
-// TeamsTool is a new tool for integrating with the Teams service.
+// TeamsTool asks the agent team configured in the agents section, or the Teams service when
+// there is none.
 type TeamsTool struct {
 	enabled       bool
 	service       *ExternalService
@@ -507,8 +645,13 @@ func (t *TeamsTool) Process(ctx context.Context, input string) (string, error) {
 	// 	return "", errors.New("TeamsTool is disabled")
 	// }
 
-	// Print the input for debugging
-	log.Printf("TeamsTool: Input: %s", input)
+	logger := loggerFromContext(ctx)
+	logger.Debug("teams input", "input", truncateForLog(input, 200))
+
+	// A configured agent team replaces the search query rewrite
+	if agentTeam != nil {
+		return agentTeam.Run(ctx, unwrapPrompt(input))
+	}
 
 	// Retrieve the text between {} as user prompt
 	userPrompt := input[strings.Index(input, "{")+1 : strings.LastIndex(input, "}")]
@@ -531,13 +674,10 @@ func (t *TeamsTool) Process(ctx context.Context, input string) (string, error) {
 		Stream:      false, // As per requirement
 	}
 
-	// Print the payload for debugging
-	log.Printf("TeamsTool: Payload: %v", payload)
-
 	// Send the completion request to the Teams service
-	resp, err := llmClient.SendCompletionRequest(payload)
+	resp, err := llmClient.SendCompletionRequest(ctx, payload)
 	if err != nil {
-		log.Printf("TeamsTool: Error sending completion request: %v", err)
+		logger.Error("error sending completion request", "error", err)
 		return "", err
 	}
 	defer resp.Body.Close()
@@ -545,7 +685,7 @@ func (t *TeamsTool) Process(ctx context.Context, input string) (string, error) {
 	// Parse the response
 	var completionResp CompletionResponse
 	if err := json.NewDecoder(resp.Body).Decode(&completionResp); err != nil {
-		log.Printf("TeamsTool: Error decoding completion response: %v", err)
+		logger.Error("error decoding completion response", "error", err)
 		return "", err
 	}
 
@@ -561,13 +701,12 @@ func (t *TeamsTool) Process(ctx context.Context, input string) (string, error) {
 	// Append the response instructions to the response content
 	responseContent = fmt.Sprintf("%s\n\n%s", responseContent, responseIns)
 
-	// Print the response content for debugging
-	log.Printf("TeamsTool: Response Content: %s", responseContent)
+	logger.Debug("teams response", "response", truncateForLog(responseContent, 200))
 
 	// Append the response as a document
-	err = SaveChatTurn(input, responseContent, time.Now().Format(time.RFC3339))
+	err = SaveChatTurn(ctx, input, responseContent, time.Now().Format(time.RFC3339))
 	if err != nil {
-		log.Printf("TeamsTool: Failed to save chat turn: %v", err)
+		logger.Error("failed to save chat turn", "error", err)
 	}
 
 	return responseContent, nil
@@ -655,8 +794,24 @@ func interfaceToStringSlice(input interface{}) []string {
 	return strSlice
 }
 
-// CreateToolByName is a helper function to create a tool by its name
+// CreateToolByName is a helper function to create a tool by its name, built-in or plugin
 func CreateToolByName(toolName string) (Tool, error) {
+	if tool, err := createBuiltinTool(toolName); err == nil {
+		return tool, nil
+	}
+
+	if plugin, ok := lookupPlugin(toolName); ok {
+		tool := NewPluginTool(plugin)
+		if err := tool.SetParams(plugin.Parameters, nil); err != nil {
+			return nil, err
+		}
+		return tool, nil
+	}
+	return nil, fmt.Errorf("unknown tool: %s", toolName)
+}
+
+// createBuiltinTool creates one of the tools compiled into manifold.
+func createBuiltinTool(toolName string) (Tool, error) {
 	switch toolName {
 	case "websearch":
 		return &WebSearchTool{}, nil
@@ -666,24 +821,42 @@ func CreateToolByName(toolName string) (Tool, error) {
 		return &RetrievalTool{}, nil
 	case "teams":
 		return &TeamsTool{}, nil
+	case "codeexec":
+		return &CodeExecTool{}, nil
+	case "shell":
+		return &ShellTool{}, nil
+	case "fsread":
+		return &FSReadTool{}, nil
+	case "weather":
+		return &WeatherTool{}, nil
+	case "datetime":
+		return newDateTimeTool(), nil
+	case "coderag":
+		return &CodeRAGTool{}, nil
 	default:
 		return nil, fmt.Errorf("unknown tool: %s", toolName)
 	}
 }
 
-func SaveChatTurn(prompt, response, timestamp string) error {
+func SaveChatTurn(ctx context.Context, prompt, response, timestamp string) (err error) {
+	span, ctx := startSpan(ctx, "chat.save_turn")
+	defer func() { finishSpan(span, err) }()
+
+	// Personal data never reaches the chat table, the full-text or the vector index
+	prompt, response = redactChatTurn(ctx, prompt, response)
+
 	// Concatenate the prompt and response
 	concatenatedText := fmt.Sprintf("User: %s\nAssistant: %s", prompt, response)
 
 	// Generate embeddings for the prompt and response
-	embeddings, err := GenerateEmbedding(concatenatedText)
+	embeddings, err := GenerateEmbedding(ctx, concatenatedText)
 	if err != nil {
-		log.Printf("Error generating embeddings: %v", err)
+		loggerFromContext(ctx).Error("error generating embeddings", "error", err)
 		return err
 	}
 
 	// Convert embeddings to BLOB
-	embeddingBlob := embeddingToBlob(embeddings)
+	embeddingBlob := sqlitevec.SerializeFloat64(embeddings)
 
 	// Insert the prompt, response, and embeddings into the Chat table
 	chat := Chat{
@@ -698,6 +871,11 @@ func SaveChatTurn(prompt, response, timestamp string) error {
 		return fmt.Errorf("failed to save chat turn: %w", err)
 	}
 
+	// Index its embedding in vec_chats, searched by the retrieval tool
+	if err := db.SaveChatVector(ctx, chat.ID, embeddings); err != nil {
+		loggerFromContext(ctx).Warn("failed to save chat turn in vec_chats", "error", err)
+	}
+
 	// Insert the prompt and response into the chat_fts table for full-text search
 	if err := db.db.Exec(`
         INSERT INTO chat_fts (prompt, response, modelName) 
@@ -721,10 +899,16 @@ func SaveChatTurn(prompt, response, timestamp string) error {
 		return fmt.Errorf("failed to index document chunk: %w", err)
 	}
 
+	saveChatTurnData(ctx, docID, concatenatedText, embeddings)
+
 	return nil
 }
 
-func GenerateEmbedding(text string) ([]float64, error) {
+func GenerateEmbedding(ctx context.Context, text string) (embeddings []float64, err error) {
+	span, ctx := startSpan(ctx, "embeddings.generate")
+	span.SetTag("input_bytes", len(text))
+	defer func() { finishSpan(span, err) }()
+
 	// Invoke the embeddings API
 	textArr := []string{text}
 	embeddingRequest := EmbeddingRequest{
@@ -733,16 +917,16 @@ func GenerateEmbedding(text string) ([]float64, error) {
 		EncodingFormat: "float",
 	}
 
-	resp, err := llmClient.SendEmbeddingRequest(&embeddingRequest)
+	resp, err := embeddingClient().SendEmbeddingRequest(ctx, &embeddingRequest)
 	if err != nil {
-		log.Printf("Error sending embedding request: %v", err)
+		loggerFromContext(ctx).Error("error sending embedding request", "error", err)
 		return nil, err
 	}
 	defer resp.Body.Close()
 
 	var embeddingResponse EmbeddingResponse
 	if err := json.NewDecoder(resp.Body).Decode(&embeddingResponse); err != nil {
-		log.Printf("Error decoding embedding response: %v", err)
+		loggerFromContext(ctx).Error("error decoding embedding response", "error", err)
 		return nil, err
 	}
 
@@ -751,7 +935,6 @@ func GenerateEmbedding(text string) ([]float64, error) {
 	}
 
 	// Concatenate the embeddings into a single slice
-	var embeddings []float64
 	for _, emb := range embeddingResponse.Data {
 		embeddings = append(embeddings, emb.Embedding...)
 	}
@@ -792,7 +975,7 @@ func handleToolToggle(c echo.Context, config *Config) error {
 		})
 	}
 
-	log.Printf("Toggling tool '%s' to %s", toolName, map[bool]string{true: "enabled", false: "disabled"}[requestPayload.Enabled])
+	slog.Info("toggling tool", "tool", toolName, "enabled", requestPayload.Enabled)
 
 	// Update the tool's enabled status in the database
 	if err := db.UpdateToolMetadataByName(tool.Name, requestPayload.Enabled); err != nil {
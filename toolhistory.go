@@ -0,0 +1,153 @@
+// manifold/toolhistory.go
+
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+const (
+	ToolStatusSuccess = "success"
+	ToolStatusError   = "error"
+	ToolStatusSkipped = "skipped"
+
+	toolOutputPreviewBytes  = 2000
+	toolHistoryMaxRows      = 10000
+	defaultToolHistoryLimit = 50
+	maxToolHistoryLimit     = 500
+)
+
+// ToolInvocation records one tool call of a chat turn, so it can be traced which tool added what
+// to the prompt that produced an answer.
+type ToolInvocation struct {
+	ID            uint      `gorm:"primaryKey" json:"id"`
+	SessionID     string    `gorm:"index" json:"session_id"`
+	TurnID        string    `gorm:"index" json:"turn_id"`
+	Tool          string    `gorm:"index" json:"tool"`
+	Status        string    `json:"status"` // success, error or skipped
+	Error         string    `json:"error,omitempty"`
+	DurationMS    int64     `json:"duration_ms"`
+	OutputBytes   int       `json:"output_bytes"`
+	OutputPreview string    `gorm:"type:text" json:"output_preview,omitempty"` // the first 2000 bytes of the output
+	CreatedAt     time.Time `gorm:"index" json:"created_at"`
+}
+
+// TurnInfo identifies a chat turn of a websocket session.
+type TurnInfo struct {
+	SessionID string
+	TurnID    string
+}
+
+// turnKey is the context key carrying the current chat turn.
+type turnKey struct{}
+
+// withTurn returns a copy of ctx that carries the chat turn.
+func withTurn(ctx context.Context, turn TurnInfo) context.Context {
+	return context.WithValue(ctx, turnKey{}, turn)
+}
+
+// turnFromContext returns the chat turn stored in ctx, or an empty one.
+func turnFromContext(ctx context.Context) TurnInfo {
+	turn, _ := ctx.Value(turnKey{}).(TurnInfo)
+	return turn
+}
+
+// recordToolInvocation stores the outcome of a tool call of the current turn and drops the
+// oldest records beyond toolHistoryMaxRows.
+func recordToolInvocation(ctx context.Context, tool string, duration time.Duration, output string, err error) {
+	if db == nil {
+		return
+	}
+
+	turn := turnFromContext(ctx)
+	invocation := ToolInvocation{
+		SessionID:   turn.SessionID,
+		TurnID:      turn.TurnID,
+		Tool:        tool,
+		Status:      ToolStatusSuccess,
+		DurationMS:  duration.Milliseconds(),
+		OutputBytes: len(output),
+	}
+	invocation.OutputPreview = truncateForLog(output, toolOutputPreviewBytes)
+	switch {
+	case errors.Is(err, ErrCircuitOpen):
+		invocation.Status = ToolStatusSkipped
+		invocation.Error = err.Error()
+	case err != nil:
+		invocation.Status = ToolStatusError
+		invocation.Error = truncateForLog(err.Error(), breakerLastErrorMaxBytes)
+	}
+
+	logger := loggerFromContext(ctx)
+	if err := db.Create(&invocation); err != nil {
+		logger.Warn("failed to record tool invocation", "tool", tool, "error", err)
+		return
+	}
+	if invocation.ID > toolHistoryMaxRows {
+		db.db.Where("id <= ?", invocation.ID-toolHistoryMaxRows).Delete(&ToolInvocation{})
+	}
+}
+
+// GetToolInvocations returns the newest invocations matching the filter fields that are set.
+func (sqldb *SQLiteDB) GetToolInvocations(filter ToolInvocation, since time.Time, limit int) ([]ToolInvocation, error) {
+	query := sqldb.db.Model(&ToolInvocation{})
+	if filter.Tool != "" {
+		query = query.Where("tool = ?", filter.Tool)
+	}
+	if filter.SessionID != "" {
+		query = query.Where("session_id = ?", filter.SessionID)
+	}
+	if filter.TurnID != "" {
+		query = query.Where("turn_id = ?", filter.TurnID)
+	}
+	if filter.Status != "" {
+		query = query.Where("status = ?", filter.Status)
+	}
+	if !since.IsZero() {
+		query = query.Where("created_at >= ?", since)
+	}
+
+	var invocations []ToolInvocation
+	err := query.Order("id DESC").Limit(limit).Find(&invocations).Error
+	return invocations, err
+}
+
+// handleGetToolHistory lists recorded tool invocations, newest first. They can be filtered by
+// tool, session_id, turn_id, status and since (RFC 3339), and limit caps the number returned.
+func handleGetToolHistory(c echo.Context) error {
+	limit := defaultToolHistoryLimit
+	if v := c.QueryParam("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 {
+			return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid limit"})
+		}
+		limit = min(n, maxToolHistoryLimit)
+	}
+
+	var since time.Time
+	if v := c.QueryParam("since"); v != "" {
+		parsed, err := time.Parse(time.RFC3339, v)
+		if err != nil {
+			return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid since, use RFC 3339"})
+		}
+		since = parsed
+	}
+
+	filter := ToolInvocation{
+		Tool:      c.QueryParam("tool"),
+		SessionID: c.QueryParam("session_id"),
+		TurnID:    c.QueryParam("turn_id"),
+		Status:    c.QueryParam("status"),
+	}
+	invocations, err := db.GetToolInvocations(filter, since, limit)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to load tool history"})
+	}
+	return c.JSON(http.StatusOK, invocations)
+}
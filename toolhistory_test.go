@@ -0,0 +1,52 @@
+// toolhistory_test.go
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRecordToolInvocation(t *testing.T) {
+	testDB, err := NewSQLiteDB(t.TempDir())
+	require.NoError(t, err)
+	require.NoError(t, testDB.AutoMigrate(&ToolInvocation{}))
+
+	saved := db
+	db = testDB
+	defer func() { db = saved }()
+
+	ctx := withTurn(context.Background(), TurnInfo{SessionID: "s1", TurnID: "t1"})
+	recordToolInvocation(ctx, "websearch", 1500*time.Millisecond, "search results", nil)
+	recordToolInvocation(ctx, "webget", 20*time.Millisecond, "", errors.New("timeout"))
+	recordToolInvocation(withTurn(context.Background(), TurnInfo{SessionID: "s1", TurnID: "t2"}), "webget", 0, "", ErrCircuitOpen)
+
+	all, err := db.GetToolInvocations(ToolInvocation{}, time.Time{}, 10)
+	require.NoError(t, err)
+	require.Len(t, all, 3)
+	assert.Equal(t, ToolStatusSkipped, all[0].Status)
+
+	turn, err := db.GetToolInvocations(ToolInvocation{TurnID: "t1"}, time.Time{}, 10)
+	require.NoError(t, err)
+	require.Len(t, turn, 2)
+	assert.Equal(t, "webget", turn[0].Tool)
+	assert.Equal(t, ToolStatusError, turn[0].Status)
+	assert.Equal(t, "timeout", turn[0].Error)
+	assert.Equal(t, "websearch", turn[1].Tool)
+	assert.Equal(t, ToolStatusSuccess, turn[1].Status)
+	assert.Equal(t, int64(1500), turn[1].DurationMS)
+	assert.Equal(t, len("search results"), turn[1].OutputBytes)
+	assert.Equal(t, "search results", turn[1].OutputPreview)
+
+	failed, err := db.GetToolInvocations(ToolInvocation{Tool: "webget", Status: ToolStatusError}, time.Time{}, 10)
+	require.NoError(t, err)
+	assert.Len(t, failed, 1)
+
+	recent, err := db.GetToolInvocations(ToolInvocation{}, time.Now().Add(time.Hour), 10)
+	require.NoError(t, err)
+	assert.Empty(t, recent)
+}
@@ -0,0 +1,251 @@
+// manifold/toolparams.go
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"math"
+	"net/http"
+	"sort"
+
+	"github.com/labstack/echo/v4"
+)
+
+// builtinToolSchemas are the parameters of the built-in tools that can be changed at runtime.
+// Plugins use the schema from their configuration.
+var builtinToolSchemas = map[string]map[string]PluginParam{
+	"websearch": {
+		"search_engine": {Type: "string", Description: "ddg or sxng"},
+		"endpoint":      {Type: "string", Description: "search engine URL"},
+		"top_n":         {Type: "number", Description: "results to fetch"},
+		"concurrency":   {Type: "number", Description: "pages fetched in parallel"},
+	},
+	"webget": {},
+	"retrieval": {
+		"top_n": {Type: "number", Description: "documents to retrieve"},
+	},
+	"teams": {}, // starts its service when configured, nothing to change at runtime
+	"codeexec": {
+		"languages":       {Type: "array", Description: "python, go and javascript"},
+		"timeout_seconds": {Type: "number"},
+		"memory_mb":       {Type: "number"},
+		"max_output_kb":   {Type: "number"},
+		"max_snippets":    {Type: "number"},
+		"image":           {Type: "string", Description: "sandbox container image"},
+	},
+	"shell": {
+		"work_dir":        {Type: "string"},
+		"allow":           {Type: "array", Description: "commands that may run"},
+		"deny_flags":      {Type: "array"},
+		"confirm":         {Type: "boolean"},
+		"timeout_seconds": {Type: "number"},
+		"max_output_kb":   {Type: "number"},
+	},
+	"fsread": {
+		"roots":        {Type: "array", Description: "directories that may be read"},
+		"max_file_kb":  {Type: "number"},
+		"max_total_kb": {Type: "number"},
+		"max_entries":  {Type: "number"},
+	},
+	"weather": {
+		"location":        {Type: "string", Description: "place used when the prompt names none"},
+		"units":           {Type: "string", Description: "celsius or fahrenheit"},
+		"forecast_days":   {Type: "number"},
+		"geocoding_url":   {Type: "string"},
+		"forecast_url":    {Type: "string"},
+		"timeout_seconds": {Type: "number"},
+	},
+	"datetime": {
+		"timezone": {Type: "string", Description: "IANA timezone name"},
+		"format":   {Type: "string", Description: "Go time layout"},
+		"always":   {Type: "boolean"},
+	},
+	"coderag": {
+		"repo_path":           {Type: "string", Description: "Go module to index"},
+		"store_path":          {Type: "string", Description: "SQLite file of the index"},
+		"watch":               {Type: "boolean", Description: "re-index on changes"},
+		"top_k":               {Type: "number"},
+		"call_depth":          {Type: "number"},
+		"summary_endpoint":    {Type: "string", Description: "chat completions URL summarizing functions"},
+		"summary_model":       {Type: "string"},
+		"summary_workers":     {Type: "number"},
+		"embeddings_endpoint": {Type: "string"},
+		"embeddings_model":    {Type: "string"},
+	},
+}
+
+// toolSchema returns the parameter schema of a built-in tool or plugin.
+func toolSchema(name string) (map[string]PluginParam, bool) {
+	if schema, ok := builtinToolSchemas[name]; ok {
+		return schema, true
+	}
+	if plugin, ok := lookupPlugin(name); ok {
+		return plugin.Schema, true
+	}
+	return nil, false
+}
+
+// configuredToolParams returns the parameters of a tool or plugin from the config.
+func configuredToolParams(config *Config, name string) map[string]interface{} {
+	for _, toolConfig := range config.Tools {
+		if toolConfig.Name == name && toolConfig.Parameters != nil {
+			return toolConfig.Parameters
+		}
+	}
+	for _, plugin := range config.Plugins {
+		if plugin.Name == name && plugin.Parameters != nil {
+			return plugin.Parameters
+		}
+	}
+	return map[string]interface{}{}
+}
+
+// setConfiguredToolParams stores the parameters of a tool or plugin in the config, so the tool
+// keeps them when it is toggled off and on.
+func setConfiguredToolParams(config *Config, name string, params map[string]interface{}) {
+	for i := range config.Tools {
+		if config.Tools[i].Name == name {
+			config.Tools[i].Parameters = params
+			return
+		}
+	}
+	for i := range config.Plugins {
+		if config.Plugins[i].Name == name {
+			config.Plugins[i].Parameters = params
+			return
+		}
+	}
+	config.Tools = append(config.Tools, ToolConfig{Name: name, Parameters: params})
+}
+
+// checkToolParams validates a parameter update against a schema. JSON numbers without a fraction
+// become ints, the type tools read from YAML.
+func checkToolParams(schema map[string]PluginParam, params map[string]interface{}) error {
+	for name, value := range params {
+		if name == "enabled" {
+			return fmt.Errorf("use the toggle endpoint to enable or disable a tool")
+		}
+		param, ok := schema[name]
+		if !ok {
+			return fmt.Errorf("unknown parameter %q", name)
+		}
+		if f, ok := value.(float64); ok && f == math.Trunc(f) {
+			value = int(f)
+			params[name] = value
+		}
+		if !pluginTypeMatches(param.Type, value) {
+			return fmt.Errorf("parameter %q must be of type %s", name, param.Type)
+		}
+	}
+	return nil
+}
+
+// GetToolByName returns a tool registered in the workflow.
+func (wm *WorkflowManager) GetToolByName(name string) (Tool, bool) {
+	for _, wrapper := range wm.tools {
+		if wrapper.Name == name {
+			return wrapper.Tool, true
+		}
+	}
+	return nil, false
+}
+
+// saveToolParams writes parameters to the tools table so /v1/tools/list shows the values in use.
+func saveToolParams(tool *ToolMetadata, params map[string]interface{}) error {
+	existing := make(map[string]bool, len(tool.Params))
+	for _, p := range tool.Params {
+		existing[p.ParamName] = true
+	}
+
+	names := make([]string, 0, len(params))
+	for name := range params {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		value := fmt.Sprintf("%v", params[name])
+		var err error
+		if existing[name] {
+			err = db.UpdateToolParam(tool.ID, name, value)
+		} else {
+			err = db.CreateToolParam(tool.ID, name, value)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// handleGetToolParams returns the parameter schema of a tool and its current parameters.
+func handleGetToolParams(c echo.Context, config *Config) error {
+	name := c.Param("toolName")
+	schema, ok := toolSchema(name)
+	if !ok {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": fmt.Sprintf("Tool '%s' has no parameter schema", name)})
+	}
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"tool":   name,
+		"schema": schema,
+		"params": configuredToolParams(config, name),
+	})
+}
+
+// handleUpdateToolParams validates new parameters of a tool against its schema and applies them
+// to the running tool. Parameters left out keep their current value.
+func handleUpdateToolParams(c echo.Context, config *Config) error {
+	name := c.Param("toolName")
+	metadata, err := db.GetToolMetadataByName(name)
+	if err != nil {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": fmt.Sprintf("Tool '%s' not found", name)})
+	}
+	schema, ok := toolSchema(name)
+	if !ok {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": fmt.Sprintf("Tool '%s' has no parameter schema", name)})
+	}
+
+	// Bind would add the path parameter to the map
+	var update map[string]interface{}
+	if err := json.NewDecoder(c.Request().Body).Decode(&update); err != nil || len(update) == 0 {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid request payload"})
+	}
+	if err := checkToolParams(schema, update); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+	}
+
+	params := make(map[string]interface{})
+	for k, v := range configuredToolParams(config, name) {
+		params[k] = v
+	}
+	for k, v := range update {
+		params[k] = v
+	}
+	params["enabled"] = metadata.Enabled
+
+	// The parameters go to a new instance that replaces the running tool, turns already using the
+	// old one finish with it and a rejected update leaves it untouched
+	candidate, err := CreateToolByName(name)
+	if err != nil {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": err.Error()})
+	}
+	if err := candidate.SetParams(params, config); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+	}
+
+	if wm := GetGlobalWorkflowManager(); wm != nil {
+		if _, ok := wm.GetToolByName(name); ok {
+			wm.ReplaceTool(name, candidate)
+		}
+	}
+
+	setConfiguredToolParams(config, name, params)
+	if err := saveToolParams(metadata, update); err != nil {
+		slog.Warn("failed to save tool parameters", "tool", name, "error", err)
+	}
+
+	slog.Info("tool parameters updated", "tool", name, "params", update)
+	return c.JSON(http.StatusOK, map[string]interface{}{"status": "success", "tool": name, "params": params})
+}
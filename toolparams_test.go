@@ -0,0 +1,89 @@
+// toolparams_test.go
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCheckToolParams(t *testing.T) {
+	schema := builtinToolSchemas["websearch"]
+
+	params := map[string]interface{}{"top_n": float64(3), "endpoint": "http://localhost:8080"}
+	require.NoError(t, checkToolParams(schema, params))
+	assert.Equal(t, 3, params["top_n"])
+
+	assert.Error(t, checkToolParams(schema, map[string]interface{}{"top_n": "three"}))
+	assert.Error(t, checkToolParams(schema, map[string]interface{}{"unknown": 1}))
+	assert.Error(t, checkToolParams(schema, map[string]interface{}{"enabled": false}))
+}
+
+func TestHandleUpdateToolParams(t *testing.T) {
+	testDB, err := NewSQLiteDB(t.TempDir())
+	require.NoError(t, err)
+	require.NoError(t, testDB.AutoMigrate(&ToolMetadata{}, &ToolParam{}))
+	config := &Config{Tools: []ToolConfig{{Name: "datetime", Parameters: map[string]interface{}{"enabled": true, "timezone": "UTC"}}}}
+	require.NoError(t, loadToolsToDB(testDB, config.Tools))
+
+	savedDB, savedWM := db, GetGlobalWorkflowManager()
+	db = testDB
+	defer func() { db = savedDB; SetGlobalWorkflowManager(savedWM) }()
+
+	clock := &DateTimeTool{}
+	require.NoError(t, configureTool(clock, "datetime", config))
+	wm := &WorkflowManager{}
+	wm.AddTool(clock, "datetime")
+	SetGlobalWorkflowManager(wm)
+
+	update := func(body string) *httptest.ResponseRecorder {
+		e := echo.New()
+		req := httptest.NewRequest(http.MethodPut, "/v1/tools/datetime/params", strings.NewReader(body))
+		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		rec := httptest.NewRecorder()
+		c := e.NewContext(req, rec)
+		c.SetParamNames("toolName")
+		c.SetParamValues("datetime")
+		require.NoError(t, handleUpdateToolParams(c, config))
+		return rec
+	}
+
+	rec := update(`{"timezone": "Europe/Berlin", "always": true}`)
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+
+	// A new instance replaces the running tool, turns already using the old one are not affected
+	running, ok := wm.GetToolByName("datetime")
+	require.True(t, ok)
+	assert.NotSame(t, clock, running)
+	assert.Equal(t, "UTC", clock.GetParams()["timezone"])
+	assert.Equal(t, "Europe/Berlin", running.GetParams()["timezone"])
+	assert.Equal(t, true, running.GetParams()["always"])
+	assert.Equal(t, "Europe/Berlin", configuredToolParams(config, "datetime")["timezone"])
+
+	metadata, err := db.GetToolMetadataByName("datetime")
+	require.NoError(t, err)
+	stored := make(map[string]string)
+	for _, p := range metadata.Params {
+		stored[p.ParamName] = p.ParamValue
+	}
+	assert.Equal(t, "Europe/Berlin", stored["timezone"])
+	assert.Equal(t, "true", stored["always"])
+
+	// The tool rejects the timezone, the running instance keeps its parameters
+	rec = update(`{"timezone": "Mars/Olympus"}`)
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+	var resp map[string]string
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &resp))
+	assert.Contains(t, resp["error"], "invalid timezone")
+	running, _ = wm.GetToolByName("datetime")
+	assert.Equal(t, "Europe/Berlin", running.GetParams()["timezone"])
+
+	rec = update(`{"format": 5}`)
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+}
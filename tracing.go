@@ -0,0 +1,45 @@
+// manifold/tracing.go
+
+package main
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/opentracing/opentracing-go"
+	"github.com/opentracing/opentracing-go/ext"
+	otlog "github.com/opentracing/opentracing-go/log"
+)
+
+// startSpan starts a span as a child of the span carried by ctx, if any, using the global tracer
+// registered by the jaegertracing middleware. The returned context carries the new span.
+func startSpan(ctx context.Context, operation string, opts ...opentracing.StartSpanOption) (opentracing.Span, context.Context) {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	return opentracing.StartSpanFromContext(ctx, operation, opts...)
+}
+
+// finishSpan marks the span as failed when err is not nil and finishes it.
+func finishSpan(span opentracing.Span, err error) {
+	if err != nil {
+		ext.Error.Set(span, true)
+		span.LogFields(otlog.Error(err))
+	}
+	span.Finish()
+}
+
+// injectSpan tags the span carried by ctx as an outgoing HTTP call and propagates its context in the
+// request headers so backends that understand Jaeger headers can join the trace.
+func injectSpan(ctx context.Context, req *http.Request) {
+	span := opentracing.SpanFromContext(ctx)
+	if span == nil {
+		return
+	}
+
+	ext.SpanKindRPCClient.Set(span)
+	ext.HTTPMethod.Set(span, req.Method)
+	ext.HTTPUrl.Set(span, req.URL.String())
+
+	_ = span.Tracer().Inject(span.Context(), opentracing.HTTPHeaders, opentracing.HTTPHeadersCarrier(req.Header))
+}
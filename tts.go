@@ -0,0 +1,202 @@
+// manifold/tts.go
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+
+	"github.com/labstack/echo/v4"
+)
+
+// ttsContentTypes maps the supported response formats to their content types.
+var ttsContentTypes = map[string]string{
+	"mp3":  "audio/mpeg",
+	"opus": "audio/ogg",
+	"aac":  "audio/aac",
+	"flac": "audio/flac",
+	"wav":  "audio/wav",
+	"pcm":  "audio/pcm",
+}
+
+// SpeechRequest is the OpenAI-compatible text to speech request body.
+type SpeechRequest struct {
+	Model          string  `json:"model,omitempty"`
+	Input          string  `json:"input"`
+	Voice          string  `json:"voice,omitempty"`
+	ResponseFormat string  `json:"response_format,omitempty"`
+	Speed          float64 `json:"speed,omitempty"`
+}
+
+// ttsService is the local speech server started when tts.provider is "local".
+var ttsService *ExternalService
+
+// ttsBaseURL returns the OpenAI-compatible base URL and API key of the configured TTS provider.
+func ttsBaseURL(config *Config) (string, string, error) {
+	switch config.TTS.Provider {
+	case "openai":
+		return "https://api.openai.com/v1", config.OpenAIAPIKey, nil
+	case "local":
+		return fmt.Sprintf("http://%s:%d/v1", config.TTS.Service.Host, config.TTS.Service.Port), "", nil
+	default:
+		return "", "", fmt.Errorf("text to speech is not configured")
+	}
+}
+
+// startTTSService launches the local piper/kokoro server and hands it to the supervisor.
+func startTTSService(ctx context.Context, config *Config, verbose bool) {
+	if config.TTS.Provider != "local" || config.TTS.Service.Command == "" {
+		return
+	}
+
+	ttsService = NewExternalService(config.TTS.Service, verbose)
+	if err := ttsService.Start(ctx); err != nil {
+		slog.Error("failed to start tts service", "service", config.TTS.Service.Name, "error", err)
+		return
+	}
+	supervisor.Register("tts", ttsService)
+}
+
+// synthesizeSpeech sends a speech request to the configured provider, filling in configured defaults.
+// The caller must close the response body.
+func synthesizeSpeech(ctx context.Context, config *Config, req SpeechRequest) (resp *http.Response, err error) {
+	baseURL, apiKey, err := ttsBaseURL(config)
+	if err != nil {
+		return nil, err
+	}
+
+	if req.Model == "" {
+		req.Model = config.TTS.Model
+	}
+	if req.Voice == "" {
+		req.Voice = config.TTS.Voice
+	}
+	if req.ResponseFormat == "" {
+		req.ResponseFormat = config.TTS.Format
+	}
+	if req.ResponseFormat == "" {
+		req.ResponseFormat = "mp3"
+	}
+
+	body, err := json.Marshal(req)
+	if err != nil {
+		return nil, err
+	}
+
+	span, ctx := startSpan(ctx, "tts.speech")
+	span.SetTag("provider", config.TTS.Provider)
+	span.SetTag("input_chars", len(req.Input))
+	defer func() { finishSpan(span, err) }()
+
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, baseURL+ttsEndpoint, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	httpReq.Header.Set("Content-Type", "application/json")
+	if apiKey != "" {
+		httpReq.Header.Set("Authorization", "Bearer "+apiKey)
+	}
+	injectSpan(ctx, httpReq)
+
+	resp, err = http.DefaultClient.Do(httpReq)
+	if err != nil {
+		return nil, err
+	}
+	span.SetTag("http.status_code", resp.StatusCode)
+
+	if resp.StatusCode != http.StatusOK {
+		defer resp.Body.Close()
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return nil, fmt.Errorf("speech request failed with status %d: %s", resp.StatusCode, msg)
+	}
+	return resp, nil
+}
+
+// speechContentType returns the content type of a response, falling back on the requested format.
+func speechContentType(resp *http.Response, format string) string {
+	if ct := resp.Header.Get(echo.HeaderContentType); ct != "" {
+		return ct
+	}
+	if ct, ok := ttsContentTypes[format]; ok {
+		return ct
+	}
+	return "audio/mpeg"
+}
+
+// handleSpeech converts text to speech and streams the audio back as it is generated.
+func handleSpeech(c echo.Context, config *Config) error {
+	var req SpeechRequest
+	if err := c.Bind(&req); err != nil || req.Input == "" {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Input is required"})
+	}
+
+	resp, err := synthesizeSpeech(c.Request().Context(), config, req)
+	if err != nil {
+		return c.JSON(http.StatusBadGateway, map[string]string{"error": err.Error()})
+	}
+	defer resp.Body.Close()
+
+	return c.Stream(http.StatusOK, speechContentType(resp, req.ResponseFormat), resp.Body)
+}
+
+// handleSpeakChat reads a chat turn's response aloud. The audio is saved under data_path/audio
+// and recorded on the chat turn, so later requests replay it without synthesizing again.
+func handleSpeakChat(c echo.Context, config *Config) error {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid chat id"})
+	}
+
+	var chat Chat
+	if err := db.db.First(&chat, id).Error; err != nil {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "Chat not found"})
+	}
+
+	if chat.AudioPath != "" && fileExists(chat.AudioPath) {
+		return c.File(chat.AudioPath)
+	}
+
+	format := config.TTS.Format
+	if format == "" {
+		format = "mp3"
+	}
+
+	resp, err := synthesizeSpeech(c.Request().Context(), config, SpeechRequest{Input: chat.Response, ResponseFormat: format})
+	if err != nil {
+		return c.JSON(http.StatusBadGateway, map[string]string{"error": err.Error()})
+	}
+	defer resp.Body.Close()
+
+	audioDir := filepath.Join(config.DataPath, "audio")
+	if err := os.MkdirAll(audioDir, 0755); err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to create audio directory"})
+	}
+
+	audioPath := filepath.Join(audioDir, fmt.Sprintf("chat-%d.%s", chat.ID, format))
+	out, err := os.Create(audioPath)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to save audio"})
+	}
+	_, err = io.Copy(out, resp.Body)
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(audioPath)
+		return c.JSON(http.StatusBadGateway, map[string]string{"error": "Failed to read audio: " + err.Error()})
+	}
+
+	if err := db.db.Model(&chat).Update("audio_path", audioPath).Error; err != nil {
+		slog.Error("failed to record chat audio", "chat_id", chat.ID, "error", err)
+	}
+
+	return c.File(audioPath)
+}
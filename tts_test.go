@@ -0,0 +1,152 @@
+// tts_test.go
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newSpeechServer starts a local TTS server answering with the request input as audio, and returns
+// the config using it. Inputs starting with "fail" are rejected, "cut" responses end early.
+func newSpeechServer(t *testing.T, requests *atomic.Int32) *Config {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		var req SpeechRequest
+		if r.URL.Path != "/v1"+ttsEndpoint || json.NewDecoder(r.Body).Decode(&req) != nil {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		switch {
+		case strings.HasPrefix(req.Input, "fail"):
+			http.Error(w, "voice not found", http.StatusInternalServerError)
+		case strings.HasPrefix(req.Input, "cut"):
+			// The connection closes before the announced length is sent
+			w.Header().Set("Content-Length", "1024")
+			w.Write([]byte(req.Input))
+		default:
+			if req.ResponseFormat == "wav" {
+				w.Header().Set(echo.HeaderContentType, "audio/x-wav")
+			} else {
+				// Without a content type the handler falls back on the requested format
+				w.Header()[echo.HeaderContentType] = nil
+			}
+			w.Write([]byte(req.Voice + ":" + req.ResponseFormat + ":" + req.Input))
+		}
+	}))
+	t.Cleanup(server.Close)
+
+	u, err := url.Parse(server.URL)
+	require.NoError(t, err)
+	port, err := strconv.Atoi(u.Port())
+	require.NoError(t, err)
+	config := &Config{
+		DataPath: t.TempDir(),
+		TTS:      TTSConfig{Provider: "local", Voice: "amy", Service: ServiceConfig{Host: u.Hostname(), Port: port}},
+	}
+	return config
+}
+
+func TestHandleSpeech(t *testing.T) {
+	var requests atomic.Int32
+	config := newSpeechServer(t, &requests)
+
+	tests := []struct {
+		name        string
+		config      *Config
+		body        string
+		status      int
+		contentType string
+		audio       string
+	}{
+		{"defaults", config, `{"input": "hello"}`, http.StatusOK, "audio/mpeg", "amy:mp3:hello"},
+		{"request options", config, `{"input": "hi", "voice": "bob", "response_format": "opus"}`, http.StatusOK, "audio/ogg", "bob:opus:hi"},
+		{"server content type", config, `{"input": "hi", "response_format": "wav"}`, http.StatusOK, "audio/x-wav", "amy:wav:hi"},
+		{"no input", config, `{"voice": "bob"}`, http.StatusBadRequest, "", ""},
+		{"provider error", config, `{"input": "fail"}`, http.StatusBadGateway, "", ""},
+		{"not configured", &Config{}, `{"input": "hello"}`, http.StatusBadGateway, "", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, "/v1/audio/speech", strings.NewReader(tt.body))
+			req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+			rec := httptest.NewRecorder()
+			require.NoError(t, handleSpeech(echo.New().NewContext(req, rec), tt.config))
+
+			assert.Equal(t, tt.status, rec.Code)
+			if tt.status == http.StatusOK {
+				assert.Equal(t, tt.contentType, rec.Header().Get(echo.HeaderContentType))
+				assert.Equal(t, tt.audio, rec.Body.String())
+			}
+		})
+	}
+}
+
+func TestHandleSpeakChat(t *testing.T) {
+	var requests atomic.Int32
+	config := newSpeechServer(t, &requests)
+
+	testDB, err := NewSQLiteDB(t.TempDir())
+	require.NoError(t, err)
+	require.NoError(t, testDB.AutoMigrate(&Chat{}))
+	saved := db
+	db = testDB
+	defer func() { db = saved }()
+
+	chat := Chat{Prompt: "greet me", Response: "hello there"}
+	cut := Chat{Prompt: "greet me", Response: "cut short"}
+	require.NoError(t, db.db.Create(&chat).Error)
+	require.NoError(t, db.db.Create(&cut).Error)
+
+	speak := func(id string) *httptest.ResponseRecorder {
+		e := echo.New()
+		rec := httptest.NewRecorder()
+		c := e.NewContext(httptest.NewRequest(http.MethodPost, "/v1/chats/"+id+"/speech", nil), rec)
+		c.SetParamNames("id")
+		c.SetParamValues(id)
+		require.NoError(t, handleSpeakChat(c, config))
+		return rec
+	}
+
+	assert.Equal(t, http.StatusBadRequest, speak("abc").Code)
+	assert.Equal(t, http.StatusNotFound, speak("999").Code)
+
+	// The first request synthesizes and saves the audio, later ones replay the file
+	id := strconv.FormatInt(chat.ID, 10)
+	rec := speak(id)
+	require.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "amy:mp3:hello there", rec.Body.String())
+	require.NoError(t, db.db.First(&chat, chat.ID).Error)
+	assert.Equal(t, filepath.Join(config.DataPath, "audio", "chat-"+id+".mp3"), chat.AudioPath)
+	assert.FileExists(t, chat.AudioPath)
+
+	rec = speak(id)
+	require.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "amy:mp3:hello there", rec.Body.String())
+	assert.Equal(t, int32(1), requests.Load(), "the saved audio is replayed")
+
+	// A removed file is synthesized again
+	require.NoError(t, os.Remove(chat.AudioPath))
+	assert.Equal(t, http.StatusOK, speak(id).Code)
+	assert.Equal(t, int32(2), requests.Load())
+
+	// Audio cut off while it is written is not kept
+	id = strconv.FormatInt(cut.ID, 10)
+	rec = speak(id)
+	assert.Equal(t, http.StatusBadGateway, rec.Code)
+	assert.Contains(t, rec.Body.String(), "Failed to read audio")
+	assert.NoFileExists(t, filepath.Join(config.DataPath, "audio", "chat-"+id+".mp3"))
+	require.NoError(t, db.db.First(&cut, cut.ID).Error)
+	assert.Empty(t, cut.AudioPath)
+}
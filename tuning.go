@@ -0,0 +1,179 @@
+// manifold/tuning.go
+
+package main
+
+import (
+	"log/slog"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+)
+
+const (
+	gib = 1 << 30
+
+	// tuningOverheadBytes is reserved for the compute buffers and the rest of the system.
+	tuningOverheadBytes = 2 * gib
+
+	// kvBytesPerLayerToken approximates the f16 KV cache of a grouped-query attention model
+	// (8 KV heads of dimension 128): 2 (K and V) * 8 * 128 * 2 bytes.
+	kvBytesPerLayerToken = 4096
+
+	minTunedCtxSize = 4096
+	maxTunedCtxSize = 131072
+)
+
+// quantBitsPerWeight maps GGUF quantization names, as they appear in model file names, to their
+// approximate bits per weight. Longer names come first so Q4_K_M is not matched as Q4_K.
+var quantBitsPerWeight = []struct {
+	name string
+	bits float64
+}{
+	{"IQ2_XXS", 2.1}, {"IQ2_XS", 2.3}, {"IQ3_XXS", 3.1}, {"IQ3_XS", 3.3}, {"IQ4_XS", 4.3}, {"IQ4_NL", 4.5},
+	{"Q2_K", 3.35}, {"Q3_K_S", 3.5}, {"Q3_K_M", 3.9}, {"Q3_K_L", 4.3},
+	{"Q4_K_S", 4.6}, {"Q4_K_M", 4.85}, {"Q4_K_L", 5.0}, {"Q4_0", 4.55}, {"Q4_1", 5.0},
+	{"Q5_K_S", 5.5}, {"Q5_K_M", 5.7}, {"Q5_K_L", 5.8}, {"Q5_0", 5.5}, {"Q5_1", 6.0},
+	{"Q6_K", 6.56}, {"Q8_0", 8.5}, {"BF16", 16}, {"F16", 16}, {"F32", 32},
+}
+
+// LlamaTuning holds the computed llama-server defaults for a model on this host.
+type LlamaTuning struct {
+	GPULayers int
+	CtxSize   int
+}
+
+var (
+	hostInfoOnce sync.Once
+	hostInfo     HostInfoProvider
+	hostHasGPU   bool
+)
+
+// cachedHostInfo collects host information once, since GPU detection shells out on macOS.
+func cachedHostInfo() (HostInfoProvider, bool) {
+	hostInfoOnce.Do(func() {
+		hostInfo = NewHostInfoProvider()
+		gpus, err := hostInfo.GetGPUs()
+		hostHasGPU = err == nil && len(gpus) > 0
+	})
+	return hostInfo, hostHasGPU
+}
+
+// bitsPerWeight returns the quantization of a model from its file name, assuming Q4_K_M if unknown.
+func bitsPerWeight(modelPath string) float64 {
+	name := strings.ToUpper(filepath.Base(modelPath))
+	for _, q := range quantBitsPerWeight {
+		if strings.Contains(name, q.name) {
+			return q.bits
+		}
+	}
+	return 4.85
+}
+
+// estimateLayerCount guesses the number of transformer blocks from the parameter count, following the
+// common model sizes (1B, 3B, 7-8B, 13-14B, 27-34B, 70B and larger).
+func estimateLayerCount(params float64) int {
+	switch {
+	case params < 2e9:
+		return 24
+	case params < 5e9:
+		return 28
+	case params < 10e9:
+		return 32
+	case params < 20e9:
+		return 48
+	case params < 40e9:
+		return 64
+	case params < 90e9:
+		return 80
+	default:
+		return 126
+	}
+}
+
+// acceleratorMemory returns the bytes available to hold model weights and KV cache, and whether they
+// live on a GPU. Apple silicon shares system memory with the GPU; Metal allows roughly 70% of it.
+// Discrete GPU memory cannot be detected portably, so it comes from gpu_memory_gb in the config.
+func acceleratorMemory(host HostInfoProvider, hasGPU bool, gpuMemoryGB int) (int64, bool) {
+	systemBytes := int64(host.GetMemory()) * gib
+
+	switch {
+	case gpuMemoryGB > 0:
+		return int64(gpuMemoryGB) * gib, true
+	case host.GetOS() == "darwin" && host.GetArch() == "arm64":
+		return systemBytes * 7 / 10, true
+	case hasGPU:
+		// A GPU without a known memory size: keep the previous behavior of offloading everything
+		return 0, true
+	default:
+		return systemBytes / 2, false
+	}
+}
+
+// tuneLlama computes gpu-layers and ctx-size for a model of modelBytes with the given quantization.
+// All layers are offloaded when the weights fit, the remaining memory goes to the KV cache.
+// When they do not fit, the offloaded share of layers is proportional to the memory available.
+func tuneLlama(modelBytes int64, bits float64, memBytes int64, onGPU bool) LlamaTuning {
+	layers := estimateLayerCount(float64(modelBytes) * 8 / bits)
+	kvPerToken := int64(layers * kvBytesPerLayerToken)
+
+	// Unknown GPU memory size
+	if onGPU && memBytes == 0 {
+		return LlamaTuning{GPULayers: 99, CtxSize: 32768}
+	}
+
+	usable := memBytes - tuningOverheadBytes
+	gpuLayers := 0
+	if onGPU {
+		gpuLayers = 99
+		if modelBytes > usable {
+			gpuLayers = int(int64(layers) * max(usable, 0) / modelBytes)
+		}
+	}
+
+	// Offloading only part of the model means memory is tight, keep the context small
+	ctxSize := minTunedCtxSize
+	if !onGPU || gpuLayers == 99 {
+		remaining := usable
+		if onGPU {
+			remaining -= modelBytes
+		}
+		if remaining > 0 {
+			ctxSize = int(remaining / kvPerToken)
+		}
+	}
+
+	ctxSize = min(max(ctxSize, minTunedCtxSize), maxTunedCtxSize)
+	ctxSize -= ctxSize % 1024
+
+	return LlamaTuning{GPULayers: gpuLayers, CtxSize: ctxSize}
+}
+
+// tuneLlamaForModel computes llama-server defaults for a model file on this host.
+func tuneLlamaForModel(modelPath string, gpuMemoryGB int) LlamaTuning {
+	info, err := os.Stat(modelPath)
+	if err != nil {
+		slog.Warn("cannot size model for tuning, using defaults", "path", modelPath, "error", err)
+		return LlamaTuning{GPULayers: 99, CtxSize: 32768}
+	}
+
+	host, hasGPU := cachedHostInfo()
+	memBytes, onGPU := acceleratorMemory(host, hasGPU, gpuMemoryGB)
+	tuning := tuneLlama(info.Size(), bitsPerWeight(modelPath), memBytes, onGPU)
+
+	// Never go past the context the model was trained with
+	if meta, err := ReadGGUFMetadata(modelPath); err == nil && meta.ContextLength > 0 {
+		tuning.CtxSize = min(tuning.CtxSize, meta.ContextLength)
+	}
+
+	slog.Info("tuned llama-server parameters",
+		"model", filepath.Base(modelPath),
+		"model_gb", float64(info.Size())/gib,
+		"memory_gb", memBytes/gib,
+		"os", runtime.GOOS,
+		"gpu_layers", tuning.GPULayers,
+		"ctx_size", tuning.CtxSize,
+	)
+	return tuning
+}
@@ -0,0 +1,29 @@
+// tuning_test.go
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBitsPerWeight(t *testing.T) {
+	assert.Equal(t, 4.85, bitsPerWeight("/models/Meta-Llama-3.1-8B-Instruct-Q4_K_M.gguf"))
+	assert.Equal(t, 8.5, bitsPerWeight("qwen2.5-7b-instruct-q8_0.gguf"))
+	assert.Equal(t, 2.3, bitsPerWeight("model-IQ2_XS.gguf"))
+	assert.Equal(t, 4.85, bitsPerWeight("model.gguf"))
+}
+
+func TestTuneLlama(t *testing.T) {
+	// An 8B model fits a 24GB GPU with room for the maximum context
+	assert.Equal(t, LlamaTuning{GPULayers: 99, CtxSize: 131072}, tuneLlama(5*gib, 4.85, 24*gib, true))
+
+	// A 70B model offloads about half of its layers with a small context
+	assert.Equal(t, LlamaTuning{GPULayers: 41, CtxSize: 4096}, tuneLlama(42*gib, 4.85, 24*gib, true))
+
+	// Without a GPU the KV cache is sized from system memory
+	assert.Equal(t, LlamaTuning{GPULayers: 0, CtxSize: 75776}, tuneLlama(8*gib, 4.85, 16*gib, false))
+
+	// Unknown GPU memory keeps offloading everything
+	assert.Equal(t, LlamaTuning{GPULayers: 99, CtxSize: 32768}, tuneLlama(8*gib, 4.85, 0, true))
+}
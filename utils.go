@@ -5,7 +5,7 @@ package main
 import (
 	"bytes"
 	"fmt"
-	"log"
+	"log/slog"
 	"os"
 	"os/exec"
 	"runtime"
@@ -65,7 +65,7 @@ func NewHostInfoProvider() HostInfoProvider {
 func getMemoryTotal() uint64 {
 	vmStat, err := mem.VirtualMemory()
 	if err != nil {
-		log.Printf("Error getting memory info: %v", err)
+		slog.Warn("error getting memory info", "error", err)
 		return 0
 	}
 
@@ -92,7 +92,7 @@ func getMacOSGPUInfo() ([]GPUInfo, error) {
 	cmd.Stdout = &out
 	err := cmd.Run()
 	if err != nil {
-		log.Printf("Error running system_profiler: %v", err)
+		slog.Warn("error running system_profiler", "error", err)
 		return nil, err
 	}
 
@@ -125,7 +125,7 @@ func parseMacOSGPUInfo(input string) ([]GPUInfo, error) {
 func getLinuxWindowsGPUInfo() ([]GPUInfo, error) {
 	gpu, err := ghw.GPU()
 	if err != nil {
-		log.Printf("Error getting GPU info: %v", err)
+		slog.Warn("error getting GPU info", "error", err)
 		return nil, err
 	}
 
@@ -143,7 +143,7 @@ func GetHostInfo() (HostInfoProvider, error) {
 	host := NewHostInfoProvider()
 	_, err := host.GetGPUs() // We're calling this just to populate GPU info
 	if err != nil {
-		log.Printf("Error getting GPU info: %v", err)
+		slog.Warn("error getting GPU info", "error", err)
 	}
 	return host, nil
 }
@@ -156,7 +156,7 @@ func PrintHostInfo(host HostInfoProvider) {
 
 	gpus, err := host.GetGPUs()
 	if err != nil {
-		log.Printf("Error getting GPU info: %v", err)
+		slog.Warn("error getting GPU info", "error", err)
 		return
 	}
 
@@ -172,3 +172,18 @@ func fileExists(path string) bool {
 	_, err := os.Stat(path)
 	return err == nil
 }
+
+// extractJSON returns the JSON object of a model reply, from its first { to its last }, ignoring
+// the text the model wrote around it. With array set it returns the JSON array instead.
+func extractJSON(reply string, array bool) (string, bool) {
+	openDelim, closeDelim := "{", "}"
+	if array {
+		openDelim, closeDelim = "[", "]"
+	}
+	start := strings.Index(reply, openDelim)
+	end := strings.LastIndex(reply, closeDelim)
+	if start < 0 || end < start {
+		return "", false
+	}
+	return reply[start : end+1], true
+}
@@ -79,3 +79,25 @@ func TestFileExists(t *testing.T) {
 	exists = fileExists(nonExistingFile)
 	assert.False(t, exists, "fileExists should return false for non-existing file")
 }
+
+func TestExtractJSON(t *testing.T) {
+	tests := []struct {
+		reply string
+		array bool
+		want  string
+		ok    bool
+	}{
+		{`{"a": 1}`, false, `{"a": 1}`, true},
+		{"Sure:\n```json\n{\"a\": {\"b\": 2}}\n```", false, `{"a": {"b": 2}}`, true},
+		{`Tools: ["websearch"] done`, true, `["websearch"]`, true},
+		{`[{"a": 1}, {"b": 2}]`, true, `[{"a": 1}, {"b": 2}]`, true},
+		{"no JSON here", false, "", false},
+		{"} backwards {", false, "", false},
+		{`{"a": 1}`, true, "", false},
+	}
+	for _, tt := range tests {
+		got, ok := extractJSON(tt.reply, tt.array)
+		assert.Equal(t, tt.ok, ok, tt.reply)
+		assert.Equal(t, tt.want, got, tt.reply)
+	}
+}
@@ -0,0 +1,327 @@
+// manifold/vecindex.go
+
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log/slog"
+
+	"github.com/blevesearch/bleve/v2"
+
+	"manifold/internal/documents"
+	"manifold/internal/ingest"
+	"manifold/internal/sqlitevec"
+)
+
+// defaultVectorDimensions is the length of the embeddings of the sqlite-vec tables when the
+// retrieval config doesn't set it, that of nomic-embed-text.
+const defaultVectorDimensions = 768
+
+// The sqlite-vec tables of the embeddings of the chat turns, by chat id, and of the indexed
+// document chunks, by the id of the chunk in vec_chunk_ids. Both are compared by cosine distance.
+var (
+	chatVectors  = sqlitevec.Table{Name: "vec_chats", Dimensions: defaultVectorDimensions, Distance: sqlitevec.Cosine}
+	chunkVectors = sqlitevec.Table{Name: "vec_chunks", Dimensions: defaultVectorDimensions, Distance: sqlitevec.Cosine}
+)
+
+// embedIngestedChunks is set when the retrieval backend is sqlitevec, so the chunks of the
+// documents stored are embedded as they are indexed.
+var embedIngestedChunks bool
+
+// generateVectorEmbedding embeds the chunks stored in vec_chunks, stubbed in tests.
+var generateVectorEmbedding = GenerateEmbedding
+
+// CreateVectorTables creates the sqlite-vec tables, in new and existing databases, for embeddings of
+// the dimensions. Tables created before with other dimensions are kept; embeddings of another
+// length are then not stored, until they are dropped.
+func (sqldb *SQLiteDB) CreateVectorTables(dimensions int) error {
+	if dimensions <= 0 {
+		dimensions = defaultVectorDimensions
+	}
+	chatVectors.Dimensions, chunkVectors.Dimensions = dimensions, dimensions
+
+	conn, err := sqldb.db.DB()
+	if err != nil {
+		return err
+	}
+	ctx := context.Background()
+	if err := chatVectors.Create(ctx, conn); err != nil {
+		return err
+	}
+	if err := chunkVectors.Create(ctx, conn); err != nil {
+		return err
+	}
+	_, err = conn.ExecContext(ctx, `
+		CREATE TABLE IF NOT EXISTS vec_chunk_ids (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			chunk_id TEXT NOT NULL UNIQUE
+		);
+	`)
+	if err != nil {
+		return fmt.Errorf("failed to create vec_chunk_ids table: %v", err)
+	}
+	return nil
+}
+
+// SaveChatVector stores the embedding of a chat turn in vec_chats.
+func (sqldb *SQLiteDB) SaveChatVector(ctx context.Context, chatID int64, embedding []float64) error {
+	conn, err := sqldb.db.DB()
+	if err != nil {
+		return err
+	}
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %v", err)
+	}
+	defer tx.Rollback()
+	if err := chatVectors.Upsert(ctx, tx, chatID, sqlitevec.Float32(embedding)); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// ChatMatch is a chat turn similar to a query, with the cosine similarity of their embeddings.
+type ChatMatch struct {
+	Chat
+	Similarity float64 `json:"similarity"`
+}
+
+// SearchChats returns the k chat turns with the embeddings nearest to the embedding, nearest first.
+func (sqldb *SQLiteDB) SearchChats(ctx context.Context, embedding []float64, k int) ([]ChatMatch, error) {
+	conn, err := sqldb.db.DB()
+	if err != nil {
+		return nil, err
+	}
+	matches, err := chatVectors.Search(ctx, conn, sqlitevec.Float32(embedding), k)
+	if err != nil || len(matches) == 0 {
+		return nil, err
+	}
+	ids := make([]int64, len(matches))
+	for i, match := range matches {
+		ids[i] = match.RowID
+	}
+	var chats []Chat
+	if err := sqldb.db.WithContext(ctx).Find(&chats, ids).Error; err != nil {
+		return nil, err
+	}
+	byID := make(map[int64]Chat, len(chats))
+	for _, chat := range chats {
+		byID[chat.ID] = chat
+	}
+
+	// Turns deleted since they were embedded are skipped
+	results := make([]ChatMatch, 0, len(matches))
+	for _, match := range matches {
+		if chat, ok := byID[match.RowID]; ok {
+			results = append(results, ChatMatch{Chat: chat, Similarity: 1 - match.Distance})
+		}
+	}
+	return results, nil
+}
+
+// chunkRowID returns the id of a chunk in vec_chunk_ids, added when it has none.
+func chunkRowID(ctx context.Context, tx *sql.Tx, chunkID string) (int64, error) {
+	if _, err := tx.ExecContext(ctx, `INSERT OR IGNORE INTO vec_chunk_ids (chunk_id) VALUES (?);`, chunkID); err != nil {
+		return 0, fmt.Errorf("failed to record chunk %s: %v", chunkID, err)
+	}
+	var id int64
+	if err := tx.QueryRowContext(ctx, `SELECT id FROM vec_chunk_ids WHERE chunk_id = ?;`, chunkID).Scan(&id); err != nil {
+		return 0, fmt.Errorf("failed to read chunk %s: %v", chunkID, err)
+	}
+	return id, nil
+}
+
+// SaveChunkVector stores the embedding of an indexed chunk in vec_chunks.
+func (sqldb *SQLiteDB) SaveChunkVector(ctx context.Context, chunkID string, embedding []float64) error {
+	conn, err := sqldb.db.DB()
+	if err != nil {
+		return err
+	}
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %v", err)
+	}
+	defer tx.Rollback()
+	id, err := chunkRowID(ctx, tx, chunkID)
+	if err != nil {
+		return err
+	}
+	if err := chunkVectors.Upsert(ctx, tx, id, sqlitevec.Float32(embedding)); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// DeleteChunkVectors deletes the embeddings of chunks removed from the index.
+func (sqldb *SQLiteDB) DeleteChunkVectors(ctx context.Context, chunkIDs []string) error {
+	conn, err := sqldb.db.DB()
+	if err != nil {
+		return err
+	}
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %v", err)
+	}
+	defer tx.Rollback()
+	for _, chunkID := range chunkIDs {
+		var id int64
+		err := tx.QueryRowContext(ctx, `SELECT id FROM vec_chunk_ids WHERE chunk_id = ?;`, chunkID).Scan(&id)
+		if errors.Is(err, sql.ErrNoRows) {
+			continue
+		} else if err != nil {
+			return fmt.Errorf("failed to read chunk %s: %v", chunkID, err)
+		}
+		if err := chunkVectors.Delete(ctx, tx, id); err != nil {
+			return err
+		}
+		if _, err := tx.ExecContext(ctx, `DELETE FROM vec_chunk_ids WHERE id = ?;`, id); err != nil {
+			return fmt.Errorf("failed to delete chunk %s: %v", chunkID, err)
+		}
+	}
+	return tx.Commit()
+}
+
+// ChunkVector returns the embedding of a chunk stored in vec_chunks, false when it has none.
+func (sqldb *SQLiteDB) ChunkVector(ctx context.Context, chunkID string) ([]float64, bool, error) {
+	conn, err := sqldb.db.DB()
+	if err != nil {
+		return nil, false, err
+	}
+	var id int64
+	err = conn.QueryRowContext(ctx, `SELECT id FROM vec_chunk_ids WHERE chunk_id = ?;`, chunkID).Scan(&id)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, false, nil
+	} else if err != nil {
+		return nil, false, err
+	}
+	vector, ok, err := chunkVectors.Vector(ctx, conn, id)
+	if !ok || err != nil {
+		return nil, false, err
+	}
+	return sqlitevec.Float64(vector), true, nil
+}
+
+// chunkEmbedding returns the embedding of a chunk stored in vec_chunks, or embeds it and stores it
+// when it has none.
+func (sqldb *SQLiteDB) chunkEmbedding(ctx context.Context, chunk retrievedChunk) ([]float64, error) {
+	if embedding, ok, err := sqldb.ChunkVector(ctx, chunk.ID); ok {
+		return embedding, nil
+	} else if err != nil {
+		loggerFromContext(ctx).Warn("failed to read chunk embedding", "chunk", chunk.ID, "error", err)
+	}
+	embedding, err := generateVectorEmbedding(ctx, truncateRunes(chunk.Text, ragEmbedMaxChars))
+	if err != nil {
+		return nil, err
+	}
+	if err := sqldb.SaveChunkVector(ctx, chunk.ID, embedding); err != nil {
+		loggerFromContext(ctx).Warn("failed to store chunk embedding", "chunk", chunk.ID, "error", err)
+	}
+	return embedding, nil
+}
+
+// embedChunks embeds the chunks not in vec_chunks yet. Chunks failing are logged and skipped.
+func (sqldb *SQLiteDB) embedChunks(ctx context.Context, chunks []retrievedChunk) {
+	for _, chunk := range chunks {
+		if _, err := sqldb.chunkEmbedding(ctx, chunk); err != nil {
+			loggerFromContext(ctx).Error("failed to embed chunk", "chunk", chunk.ID, "error", err)
+		}
+	}
+}
+
+// embedStoredChunks embeds the chunks of the documents stored.
+func (sqldb *SQLiteDB) embedStoredChunks(ctx context.Context, im *documents.IndexManager, results []ingest.StoreResult) {
+	for _, result := range results {
+		if result.Error != "" {
+			continue
+		}
+		ids := make([]string, result.Chunks)
+		for i := range ids {
+			ids[i] = fmt.Sprintf("%s-%d", result.ID, i)
+		}
+		chunks, err := chunksByID(ctx, im, ids)
+		if err != nil {
+			loggerFromContext(ctx).Error("failed to read chunks to embed", "document", result.ID, "error", err)
+			continue
+		}
+		// Chunks of a document stored again changed, their embeddings are replaced
+		for _, chunk := range chunks {
+			embedding, err := generateVectorEmbedding(ctx, truncateRunes(chunk.Text, ragEmbedMaxChars))
+			if err == nil {
+				err = sqldb.SaveChunkVector(ctx, chunk.ID, embedding)
+			}
+			if err != nil {
+				loggerFromContext(ctx).Error("failed to embed chunk", "chunk", chunk.ID, "error", err)
+			}
+		}
+	}
+}
+
+// backfillChunkVectors embeds the indexed chunks missing from vec_chunks, e.g. those ingested
+// before the retrieval backend was sqlitevec.
+func (sqldb *SQLiteDB) backfillChunkVectors(ctx context.Context, im *documents.IndexManager) error {
+	count, err := im.Index.DocCount()
+	if err != nil {
+		return err
+	}
+	request := bleve.NewSearchRequestOptions(bleve.NewMatchAllQuery(), int(count), 0, false)
+	request.Fields = []string{"file_path", "chunk", "full_content", "page", "section"}
+	result, err := im.Index.SearchInContext(ctx, request)
+	if err != nil {
+		return err
+	}
+	chunks := make([]retrievedChunk, len(result.Hits))
+	for i, hit := range result.Hits {
+		chunks[i] = chunkFromFields(hit.ID, hit.Fields)
+	}
+	sqldb.embedChunks(ctx, chunks)
+	slog.Info("embedded indexed chunks", "chunks", len(chunks))
+	return nil
+}
+
+// sqliteVecRetriever retrieves the indexed chunks with the embeddings in vec_chunks of the database
+// nearest to the query's.
+type sqliteVecRetriever struct {
+	index *documents.IndexManager
+}
+
+func (r *sqliteVecRetriever) Retrieve(ctx context.Context, query string, n int) ([]retrievedChunk, error) {
+	embedding, err := generateVectorEmbedding(ctx, query)
+	if err != nil {
+		return nil, fmt.Errorf("failed to embed the query: %w", err)
+	}
+	conn, err := db.db.DB()
+	if err != nil {
+		return nil, err
+	}
+	matches, err := chunkVectors.Search(ctx, conn, sqlitevec.Float32(embedding), n)
+	if err != nil || len(matches) == 0 {
+		return nil, err
+	}
+
+	ids := make([]string, 0, len(matches))
+	similarities := make(map[string]float64, len(matches))
+	for _, match := range matches {
+		var id string
+		err := conn.QueryRowContext(ctx, `SELECT chunk_id FROM vec_chunk_ids WHERE id = ?;`, match.RowID).Scan(&id)
+		if errors.Is(err, sql.ErrNoRows) {
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+		similarities[id] = 1 - match.Distance
+	}
+
+	// Chunks removed from the index since they were embedded are skipped
+	chunks, err := chunksByID(ctx, r.index, ids)
+	if err != nil {
+		return nil, err
+	}
+	for i := range chunks {
+		chunks[i].Score = similarities[chunks[i].ID]
+	}
+	return chunks, nil
+}
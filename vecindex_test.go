@@ -0,0 +1,105 @@
+// vecindex_test.go
+package main
+
+import (
+	"context"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"manifold/internal/documents"
+	"manifold/internal/ingest"
+)
+
+func TestVectorTables(t *testing.T) {
+	testDB, err := NewSQLiteDB(t.TempDir())
+	require.NoError(t, err)
+	require.NoError(t, testDB.AutoMigrate(&Chat{}))
+	savedChats, savedChunks := chatVectors, chunkVectors
+	defer func() { chatVectors, chunkVectors = savedChats, savedChunks }()
+	require.NoError(t, testDB.CreateVectorTables(3))
+	require.NoError(t, testDB.CreateVectorTables(3))
+
+	saved := db
+	db = testDB
+	defer func() { db = saved }()
+	ctx := context.Background()
+
+	// Chat turns are searched by the similarity of their embeddings
+	for _, chat := range []Chat{{Prompt: "cats?", Response: "they sleep"}, {Prompt: "dogs?", Response: "they bark"}} {
+		require.NoError(t, testDB.Create(&chat))
+		vector := []float64{1, 0, 0}
+		if strings.HasPrefix(chat.Prompt, "dogs") {
+			vector = []float64{0, 1, 0}
+		}
+		require.NoError(t, testDB.SaveChatVector(ctx, chat.ID, vector))
+	}
+	require.NoError(t, testDB.SaveChatVector(ctx, 1, []float64{1, 0.1, 0}), "replaces the embedding")
+	assert.Error(t, testDB.SaveChatVector(ctx, 3, []float64{1, 0}), "of another length")
+	turns, err := testDB.SearchChats(ctx, []float64{1, 0, 0}, 5)
+	require.NoError(t, err)
+	require.Len(t, turns, 2)
+	assert.Equal(t, "cats?", turns[0].Prompt)
+	assert.InDelta(t, 0.995, turns[0].Similarity, 0.001)
+	assert.InDelta(t, 0, turns[1].Similarity, 0.001)
+
+	// Chunks are embedded as they are stored, and retrieved by the embedding of the query
+	index, err := documents.NewIndexManager(filepath.Join(t.TempDir(), "index"))
+	require.NoError(t, err)
+	defer index.Index.Close()
+	require.NoError(t, index.IndexDocumentChunk("cats-0", "The cat sleeps all day.", "cats.md"))
+	require.NoError(t, index.IndexDocumentChunk("dogs-0", "The dog barks at night.", "dogs.md"))
+	require.NoError(t, index.IndexDocumentChunk("birds-0", "The bird sings.", "birds.md"))
+
+	embedded := 0
+	savedEmbed := generateVectorEmbedding
+	generateVectorEmbedding = func(ctx context.Context, text string) ([]float64, error) {
+		embedded++
+		switch {
+		case strings.Contains(text, "cat"):
+			return []float64{1, 0, 0}, nil
+		case strings.Contains(text, "dog"):
+			return []float64{0, 1, 0}, nil
+		}
+		return []float64{0, 0, 1}, nil
+	}
+	defer func() { generateVectorEmbedding = savedEmbed }()
+	testDB.embedStoredChunks(ctx, index, []ingest.StoreResult{{ID: "cats", Chunks: 1}, {ID: "dogs", Chunks: 1}, {ID: "fish", Error: "failed"}})
+	assert.Equal(t, 2, embedded)
+
+	retriever, err := newRetriever(RetrievalConfig{Backend: "sqlitevec"}, index)
+	require.NoError(t, err)
+	chunks, err := retriever.Retrieve(ctx, "a dog", 1)
+	require.NoError(t, err)
+	require.Len(t, chunks, 1)
+	assert.Equal(t, "dogs-0", chunks[0].ID)
+	assert.Equal(t, "dogs.md", chunks[0].Path)
+	assert.InDelta(t, 1, chunks[0].Score, 1e-6)
+
+	// Chunks embedded before are not embedded again, the others are stored as they are embedded
+	embedded = 0
+	require.NoError(t, testDB.backfillChunkVectors(ctx, index))
+	assert.Equal(t, 1, embedded)
+	vector, ok, err := testDB.ChunkVector(ctx, "birds-0")
+	require.NoError(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, []float64{0, 0, 1}, vector)
+
+	// Chunks removed from the index are not retrieved
+	require.NoError(t, index.Index.Delete("dogs-0"))
+	chunks, err = retriever.Retrieve(ctx, "a dog", 1)
+	require.NoError(t, err)
+	assert.Empty(t, chunks)
+
+	// The embeddings of chunks collected as garbage are deleted with them
+	require.NoError(t, testDB.DeleteChunkVectors(ctx, []string{"birds-0", "fish-0"}))
+	_, ok, err = testDB.ChunkVector(ctx, "birds-0")
+	require.NoError(t, err)
+	assert.False(t, ok)
+	_, ok, err = testDB.ChunkVector(ctx, "cats-0")
+	require.NoError(t, err)
+	assert.True(t, ok)
+}
@@ -1,37 +1,42 @@
 package main
 
 import (
+	"container/heap"
+	"context"
 	"encoding/json"
 	"fmt"
-	"log"
 	"math"
 	"os"
 	"runtime"
 	"sort"
+	"strings"
 	"sync"
+	"time"
+
+	"manifold/internal/hnsw"
 )
 
 // Vector represents a vector of floats.
 type Vector []float64
 
-// Node is a struct that represents a node in a k-d tree.
-type Node struct {
-	Domain []float64
-	Value  float64
-	Left   *Node
-	Right  *Node
-}
-
 // Embedding represents a word embedding.
 type Embeddings struct {
 	Word       string
 	Vector     []float64
-	Similarity float64 // Similarity field to store the cosine similarity
+	Similarity float64           // Similarity field to store the cosine similarity
+	Metadata   map[string]string `json:",omitempty"` // e.g. source, collection, timestamp and tags, see EmbeddingFilter
+	Quantized  []int8            `json:"-"`          // Vector as int8 values when the DB is quantized, see QuantizationConfig
+	Scale      float32           `json:"-"`          // of the Quantized values
 }
 
 // EmbeddingDB represents a database of Embeddings.
 type EmbeddingDB struct {
 	Embeddings map[string]Embeddings
+	Index      *hnsw.Index `json:"-"` // approximate nearest neighbor index, nil until EnableIndex or LoadIndex
+
+	dirty        map[string]struct{} // words changed since the last save
+	file         *embeddingFile      // the file last loaded or saved
+	quantization *QuantizationConfig // nil unless EnableQuantization
 }
 
 // Document represents a document to be ranked.
@@ -45,12 +50,38 @@ type Document struct {
 func NewEmbeddingDB() *EmbeddingDB {
 	return &EmbeddingDB{
 		Embeddings: make(map[string]Embeddings),
+		dirty:      make(map[string]struct{}),
 	}
 }
 
 // AddEmbedding adds a new embedding to the database.
 func (db *EmbeddingDB) AddEmbedding(embedding Embeddings) {
+	if db.quantization != nil {
+		embedding.Quantized, embedding.Scale = quantizeVector(embedding.Vector)
+	}
 	db.Embeddings[embedding.Word] = embedding
+	db.markDirty(embedding.Word)
+	if db.Index != nil {
+		// Embeddings the index rejects are those exact searches skip too
+		_ = db.Index.Insert(embedding.Word, embedding.Vector)
+	}
+}
+
+// RemoveEmbedding removes an embedding from the database.
+func (db *EmbeddingDB) RemoveEmbedding(word string) {
+	delete(db.Embeddings, word)
+	db.markDirty(word)
+	if db.Index != nil {
+		db.Index.Remove(word)
+	}
+}
+
+// markDirty records that the embedding of a word changed since the last save.
+func (db *EmbeddingDB) markDirty(word string) {
+	if db.dirty == nil {
+		db.dirty = make(map[string]struct{})
+	}
+	db.dirty[word] = struct{}{}
 }
 
 // AddEmbeddings adds a slice of embeddings to the database.
@@ -61,7 +92,7 @@ func (db *EmbeddingDB) AddEmbeddings(embeddings []Embeddings) {
 }
 
 // GenerateEmbedding generates vectors for each chunk of text by calling the LLMClient's SendEmbeddingRequest.
-func GenerateEmbeddings(textChunks []string, client LLMClient) ([]Embeddings, error) {
+func GenerateEmbeddings(ctx context.Context, textChunks []string, client LLMClient) ([]Embeddings, error) {
 	// Create an embedding request payload
 	payload := &EmbeddingRequest{
 		Input: textChunks,
@@ -69,7 +100,7 @@ func GenerateEmbeddings(textChunks []string, client LLMClient) ([]Embeddings, er
 	}
 
 	// Send embedding request through the LLMClient
-	resp, err := client.SendEmbeddingRequest(payload)
+	resp, err := client.SendEmbeddingRequest(ctx, payload)
 	if err != nil {
 		return nil, fmt.Errorf("error sending embedding request: %v", err)
 	}
@@ -98,63 +129,105 @@ func GenerateEmbeddings(textChunks []string, client LLMClient) ([]Embeddings, er
 	return embeddings, nil
 }
 
-// SaveEmbeddings saves the Embeddings to a file, appending new ones to existing data.
+// SaveEmbeddings saves the Embeddings to a file, appending new ones to existing data. Saving to the
+// file last loaded or saved only appends the embeddings changed since, compacting the file once
+// most of it is stale; saving to another one writes all the embeddings. The index, if any, is
+// saved alongside once a quarter of it changed.
 func (db *EmbeddingDB) SaveEmbeddings(path string) error {
-	// Read the existing content from the file
-	var existingEmbeddings map[string]Embeddings
-	content, err := os.ReadFile(path)
-	if err != nil {
-		if !os.IsNotExist(err) {
-			return fmt.Errorf("error reading file: %v", err)
+	if db.file == nil || db.file.path != path {
+		_, file, err := readEmbeddingFile(path)
+		if err != nil && !os.IsNotExist(err) {
+			return err
 		}
-		existingEmbeddings = make(map[string]Embeddings)
-	} else {
-		err = json.Unmarshal(content, &existingEmbeddings)
-		if err != nil {
-			return fmt.Errorf("error unmarshaling existing embeddings: %v", err)
+		db.restoreVectors()
+		db.file = file
+		for word := range db.Embeddings {
+			db.markDirty(word)
 		}
 	}
+	written := sortedWords(db.dirty)
 
-	// Merge new embeddings with existing ones
-	for key, embedding := range db.Embeddings {
-		existingEmbeddings[key] = embedding
+	compacted := db.file.legacy
+	if compacted {
+		// Files saved before the log are converted
+		if err := db.CompactEmbeddings(path); err != nil {
+			return err
+		}
+	} else if err := db.appendEmbeddings(); err != nil {
+		return err
 	}
-
-	// Marshal the combined embeddings to JSON
-	jsonData, err := json.Marshal(existingEmbeddings)
-	if err != nil {
-		return fmt.Errorf("error marshaling embeddings: %v", err)
+	if db.file.stale() {
+		if err := db.CompactEmbeddings(path); err != nil {
+			return err
+		}
+		compacted = true
 	}
-
-	// Open the file in write mode (this will overwrite the existing file)
-	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
-	if err != nil {
-		return fmt.Errorf("error opening file: %v", err)
+	if compacted {
+		written = written[:0]
+		for word := range db.Embeddings {
+			written = append(written, word)
+		}
 	}
-	defer f.Close()
+	db.releaseVectors(written)
 
-	// Write the combined JSON to the file
-	if _, err := f.Write(jsonData); err != nil {
-		return fmt.Errorf("error writing to file: %v", err)
+	if db.Index != nil {
+		_, err := os.Stat(indexPath(path))
+		if compacted || os.IsNotExist(err) || db.Index.UnsavedChanges()*4 > db.Index.Len() {
+			return db.Index.Save(indexPath(path))
+		}
 	}
-
 	return nil
 }
 
-// LoadEmbeddings loads the Embeddings from a file.
+// LoadEmbeddings loads the Embeddings from a file, saved by SaveEmbeddings or a JSON map of them.
 func (db *EmbeddingDB) LoadEmbeddings(path string) (map[string]Embeddings, error) {
-	content, err := os.ReadFile(path)
+	embeddings, file, err := readEmbeddingFile(path)
 	if err != nil {
 		return nil, err
 	}
+	// Vectors released are read from the file last loaded or saved
+	db.restoreVectors()
+	db.file = file
+	return embeddings, nil
+}
+
+// indexPath returns the path of the index persisted alongside the embeddings file.
+func indexPath(path string) string {
+	return path + ".hnsw"
+}
+
+// EnableIndex builds an HNSW index of the embeddings, kept up to date by AddEmbedding.
+func (db *EmbeddingDB) EnableIndex(config hnsw.Config) {
+	db.Index = hnsw.New(config)
+	db.forEachVector(func(word string, vector []float64) {
+		_ = db.Index.Insert(word, vector)
+	})
+}
 
-	var embeddings map[string]Embeddings
-	err = json.Unmarshal(content, &embeddings)
+// LoadIndex loads the index persisted alongside the embeddings file, updating it with the embeddings
+// changed since it was saved, or builds one when there is none. EfSearch of the config overrides
+// the persisted one.
+func (db *EmbeddingDB) LoadIndex(path string, config hnsw.Config) error {
+	index, err := hnsw.Load(indexPath(path), config)
+	if os.IsNotExist(err) {
+		db.EnableIndex(config)
+		return nil
+	}
 	if err != nil {
-		return nil, err
+		return err
 	}
-
-	return embeddings, nil
+	db.forEachVector(func(word string, vector []float64) {
+		if !index.Matches(word, vector) {
+			_ = index.Insert(word, vector)
+		}
+	})
+	for _, word := range index.Keys() {
+		if _, ok := db.Embeddings[word]; !ok {
+			index.Remove(word)
+		}
+	}
+	db.Index = index
+	return nil
 }
 
 // RetrieveEmbedding retrieves an embedding from the database.
@@ -164,13 +237,16 @@ func (db *EmbeddingDB) RetrieveEmbedding(word string) ([]float64, bool) {
 		return nil, false
 	}
 
+	if embedding.Vector == nil && embedding.Quantized != nil {
+		return db.exactVectors([]string{word})[word], true
+	}
 	return embedding.Vector, true
 }
 
 // CosineSimilarity calculates the cosine similarity between two vectors.
 func CosineSimilarity(a, b []float64) float64 {
 	if len(a) != len(b) {
-		log.Fatal("Vectors must be of the same length")
+		fatal("vectors must be of the same length", "a", len(a), "b", len(b))
 	}
 
 	var dotProduct, magnitudeA, magnitudeB float64
@@ -231,3 +307,242 @@ func SortEmbeddingsBySimilarity(embeddings []Embeddings) {
 		return embeddings[i].Similarity > embeddings[j].Similarity
 	})
 }
+
+// Metadata of embeddings that filters match on.
+const (
+	embeddingSource     = "source"
+	embeddingCollection = "collection"
+	embeddingTimestamp  = "timestamp" // RFC 3339
+	embeddingTags       = "tags"      // comma-separated
+)
+
+// EmbeddingFilter scopes similarity searches to the embeddings with metadata matching all of its
+// fields set, the way Bleve queries are scoped to documents.
+type EmbeddingFilter struct {
+	Source     string            `json:"source,omitempty"` // prefix of the source
+	Collection string            `json:"collection,omitempty"`
+	Tags       []string          `json:"tags,omitempty"` // all of them
+	Since      time.Time         `json:"since,omitempty"`
+	Until      time.Time         `json:"until,omitempty"` // exclusive
+	Metadata   map[string]string `json:"metadata,omitempty"`
+}
+
+// Match reports whether the embedding matches the filter. Embeddings without a valid timestamp
+// don't match a time range.
+func (f EmbeddingFilter) Match(embedding Embeddings) bool {
+	if f.Source != "" && !strings.HasPrefix(embedding.Metadata[embeddingSource], f.Source) {
+		return false
+	}
+	if f.Collection != "" && embedding.Metadata[embeddingCollection] != f.Collection {
+		return false
+	}
+	if len(f.Tags) > 0 {
+		tags := make(map[string]bool)
+		for _, tag := range strings.Split(embedding.Metadata[embeddingTags], ",") {
+			tags[strings.TrimSpace(tag)] = true
+		}
+		for _, tag := range f.Tags {
+			if !tags[tag] {
+				return false
+			}
+		}
+	}
+	if !f.Since.IsZero() || !f.Until.IsZero() {
+		timestamp, err := time.Parse(time.RFC3339, embedding.Metadata[embeddingTimestamp])
+		if err != nil || (!f.Since.IsZero() && timestamp.Before(f.Since)) || (!f.Until.IsZero() && !timestamp.Before(f.Until)) {
+			return false
+		}
+	}
+	for key, value := range f.Metadata {
+		if embedding.Metadata[key] != value {
+			return false
+		}
+	}
+	return true
+}
+
+// minTopNShard is the fewest embeddings a shard of FindTopNSimilarEmbeddings scans, below which
+// goroutines cost more than they save.
+const minTopNShard = 1024
+
+// FindTopNSimilarEmbeddings returns the n embeddings most similar to the query vector by cosine
+// similarity, the most similar first, with their Similarity set. The embeddings are scanned in
+// shards in parallel, each keeping its best n in a min-heap. Embeddings of another dimension than
+// the query, or without magnitude, are skipped.
+func (db *EmbeddingDB) FindTopNSimilarEmbeddings(query []float64, n int) []Embeddings {
+	return db.FindTopNMatchingEmbeddings(query, n, nil)
+}
+
+// FindTopNMatchingEmbeddings returns the n embeddings most similar to the query vector like
+// FindTopNSimilarEmbeddings, among those matched, all when match is nil. Quantized embeddings are
+// ranked by quantized similarity, the best candidates re-scored exactly when configured.
+func (db *EmbeddingDB) FindTopNMatchingEmbeddings(query []float64, n int, match func(Embeddings) bool) []Embeddings {
+	queryNorm := vectorNorm(query)
+	if n <= 0 || queryNorm == 0 || len(db.Embeddings) == 0 {
+		return []Embeddings{}
+	}
+	candidates := n
+	if db.quantization != nil && db.quantization.Rescore > 1 {
+		candidates = n * db.quantization.Rescore
+	}
+
+	embeddings := make([]Embeddings, 0, len(db.Embeddings))
+	for _, embedding := range db.Embeddings {
+		embeddings = append(embeddings, embedding)
+	}
+	shards := min(runtime.NumCPU(), (len(embeddings)+minTopNShard-1)/minTopNShard)
+	shardSize := (len(embeddings) + shards - 1) / shards
+
+	tops := make([]similarityHeap, shards)
+	var wg sync.WaitGroup
+	for shard := 0; shard < shards; shard++ {
+		wg.Add(1)
+		go func(shard int) {
+			defer wg.Done()
+			top := make(similarityHeap, 0, candidates+1)
+			for _, embedding := range embeddings[shard*shardSize : min((shard+1)*shardSize, len(embeddings))] {
+				if match != nil && !match(embedding) {
+					continue
+				}
+				if db.quantization != nil && embedding.Quantized != nil {
+					if len(embedding.Quantized) != len(query) || embedding.Scale == 0 {
+						continue
+					}
+					embedding.Similarity = quantizedSimilarity(query, queryNorm, embedding.Quantized)
+					top.offer(embedding, candidates)
+					continue
+				}
+				if len(embedding.Vector) != len(query) {
+					continue
+				}
+				norm := vectorNorm(embedding.Vector)
+				if norm == 0 {
+					continue
+				}
+				var dot float64
+				for i, value := range query {
+					dot += value * embedding.Vector[i]
+				}
+				embedding.Similarity = dot / (queryNorm * norm)
+				top.offer(embedding, candidates)
+			}
+			tops[shard] = top
+		}(shard)
+	}
+	wg.Wait()
+
+	merged := make(similarityHeap, 0, candidates+1)
+	for _, top := range tops {
+		for _, embedding := range top {
+			merged.offer(embedding, candidates)
+		}
+	}
+	results := []Embeddings(merged)
+	if db.quantization != nil {
+		results = db.rescore(query, queryNorm, results, candidates > n)
+	}
+	sort.Slice(results, func(i, j int) bool { return merged.less(results[j], results[i]) })
+	return results[:min(n, len(results))]
+}
+
+// rescore sets the exact vectors of the quantized results, and their exact similarity when exact is
+// set.
+func (db *EmbeddingDB) rescore(query []float64, queryNorm float64, results []Embeddings, exact bool) []Embeddings {
+	words := make([]string, len(results))
+	for i, result := range results {
+		words[i] = result.Word
+	}
+	vectors := db.exactVectors(words)
+	for i := range results {
+		results[i].Vector = vectors[results[i].Word]
+		if exact && results[i].Quantized != nil {
+			var dot float64
+			for j, value := range query {
+				dot += value * results[i].Vector[j]
+			}
+			results[i].Similarity = dot / (queryNorm * vectorNorm(results[i].Vector))
+		}
+	}
+	return results
+}
+
+// SearchEmbeddings returns the n embeddings most similar to the query vector like
+// FindTopNSimilarEmbeddings, approximately through the index when there is one.
+func (db *EmbeddingDB) SearchEmbeddings(query []float64, n int) []Embeddings {
+	return db.SearchMatchingEmbeddings(query, n, nil)
+}
+
+// SearchMatchingEmbeddings returns the n embeddings most similar to the query vector among those
+// matched, all when match is nil, approximately through the index when there is one. The index is
+// searched wider until enough embeddings match, or scanned whole once that costs as much.
+func (db *EmbeddingDB) SearchMatchingEmbeddings(query []float64, n int, match func(Embeddings) bool) []Embeddings {
+	if db.Index == nil {
+		return db.FindTopNMatchingEmbeddings(query, n, match)
+	}
+
+	accept := func(word string) bool {
+		embedding, ok := db.Embeddings[word]
+		return ok && (match == nil || match(embedding))
+	}
+	var found []hnsw.Result
+	for ef := db.Index.EfSearch(); ; ef *= 4 {
+		found = db.Index.SearchFunc(query, n, ef, accept)
+		if len(found) == n || match == nil {
+			break
+		}
+		if ef*4 >= db.Index.Len() {
+			return db.FindTopNMatchingEmbeddings(query, n, match)
+		}
+	}
+	results := make([]Embeddings, len(found))
+	for i, result := range found {
+		embedding := db.Embeddings[result.Key]
+		embedding.Similarity = result.Similarity
+		results[i] = embedding
+	}
+	if db.quantization != nil {
+		results = db.rescore(query, 0, results, false)
+	}
+	return results
+}
+
+// vectorNorm returns the L2 norm of a vector.
+func vectorNorm(vec []float64) float64 {
+	var sumSquares float64
+	for _, value := range vec {
+		sumSquares += value * value
+	}
+	return math.Sqrt(sumSquares)
+}
+
+// similarityHeap is a min-heap of embeddings by similarity, the least similar of the best found so
+// far on top. Ties are broken by word so results don't depend on map order.
+type similarityHeap []Embeddings
+
+func (h similarityHeap) less(a, b Embeddings) bool {
+	if a.Similarity != b.Similarity {
+		return a.Similarity < b.Similarity
+	}
+	return a.Word > b.Word
+}
+
+func (h similarityHeap) Len() int           { return len(h) }
+func (h similarityHeap) Less(i, j int) bool { return h.less(h[i], h[j]) }
+func (h similarityHeap) Swap(i, j int)      { h[i], h[j] = h[j], h[i] }
+func (h *similarityHeap) Push(x any)        { *h = append(*h, x.(Embeddings)) }
+func (h *similarityHeap) Pop() any {
+	old := *h
+	last := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return last
+}
+
+// offer keeps the embedding when it is among the n most similar seen.
+func (h *similarityHeap) offer(embedding Embeddings, n int) {
+	if h.Len() < n {
+		heap.Push(h, embedding)
+	} else if h.less((*h)[0], embedding) {
+		(*h)[0] = embedding
+		heap.Fix(h, 0)
+	}
+}
@@ -0,0 +1,111 @@
+// vecstore_test.go
+package main
+
+import (
+	"fmt"
+	"math/rand"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"manifold/internal/hnsw"
+)
+
+func TestFindTopNSimilarEmbeddings(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	vector := func(dim int) []float64 {
+		v := make([]float64, dim)
+		for i := range v {
+			v[i] = rng.Float64()*2 - 1
+		}
+		return v
+	}
+
+	edb := NewEmbeddingDB()
+	for i := 0; i < 5000; i++ {
+		edb.AddEmbedding(Embeddings{Word: fmt.Sprintf("w%d", i), Vector: vector(16)})
+	}
+	edb.AddEmbedding(Embeddings{Word: "short", Vector: vector(8)})
+	edb.AddEmbedding(Embeddings{Word: "zero", Vector: make([]float64, 16)})
+	query := vector(16)
+
+	var expected []Embeddings
+	for _, embedding := range edb.Embeddings {
+		if embedding.Word == "short" || embedding.Word == "zero" {
+			continue
+		}
+		embedding.Similarity = CosineSimilarity(query, embedding.Vector)
+		expected = append(expected, embedding)
+	}
+	SortEmbeddingsBySimilarity(expected)
+
+	top := edb.FindTopNSimilarEmbeddings(query, 10)
+	require.Len(t, top, 10)
+	for i := range top {
+		assert.Equal(t, expected[i].Word, top[i].Word)
+		assert.InDelta(t, expected[i].Similarity, top[i].Similarity, 1e-9)
+	}
+	assert.True(t, sort.SliceIsSorted(top, func(i, j int) bool { return top[i].Similarity > top[j].Similarity }))
+
+	// The query itself is the most similar
+	edb.AddEmbedding(Embeddings{Word: "query", Vector: query})
+	top = edb.FindTopNSimilarEmbeddings(query, 1)
+	require.Len(t, top, 1)
+	assert.Equal(t, "query", top[0].Word)
+	assert.InDelta(t, 1, top[0].Similarity, 1e-9)
+
+	assert.Len(t, edb.FindTopNSimilarEmbeddings(query, 10000), 5001)
+	assert.Empty(t, edb.FindTopNSimilarEmbeddings(query, 0))
+	assert.Empty(t, edb.FindTopNSimilarEmbeddings(make([]float64, 16), 5))
+	assert.Empty(t, NewEmbeddingDB().FindTopNSimilarEmbeddings(query, 5))
+}
+
+func TestFilteredSimilaritySearch(t *testing.T) {
+	rng := rand.New(rand.NewSource(3))
+	edb := NewEmbeddingDB()
+	for i := 0; i < 2000; i++ {
+		vector := make([]float64, 16)
+		for j := range vector {
+			vector[j] = rng.Float64()*2 - 1
+		}
+		metadata := map[string]string{
+			"source":     fmt.Sprintf("docs/%s/%d.md", []string{"guides", "api"}[i%2], i),
+			"collection": []string{"kb", "code", "notes", "web"}[i%4],
+			"timestamp":  time.Date(2026, 1, 1+i%28, 0, 0, 0, 0, time.UTC).Format(time.RFC3339),
+			"tags":       []string{"go", "go, rag", "rag"}[i%3],
+		}
+		edb.AddEmbedding(Embeddings{Word: fmt.Sprintf("w%d", i), Vector: vector, Metadata: metadata})
+	}
+	edb.AddEmbedding(Embeddings{Word: "untagged", Vector: make([]float64, 16)})
+	query := edb.Embeddings["w7"].Vector
+
+	filter := EmbeddingFilter{Source: "docs/api/", Collection: "web", Tags: []string{"rag", "go"}, Since: time.Date(2026, 1, 5, 0, 0, 0, 0, time.UTC), Until: time.Date(2026, 1, 20, 0, 0, 0, 0, time.UTC)}
+	exact := edb.FindTopNMatchingEmbeddings(query, 5, filter.Match)
+	require.Len(t, exact, 5)
+	for _, embedding := range exact {
+		assert.True(t, filter.Match(embedding), embedding.Word)
+		assert.Equal(t, "web", embedding.Metadata["collection"])
+	}
+	assert.Equal(t, "w7", exact[0].Word)
+
+	// The index is searched wider until enough embeddings match
+	edb.EnableIndex(hnsw.Config{EfSearch: 16})
+	approximate := edb.SearchMatchingEmbeddings(query, 5, filter.Match)
+	require.Len(t, approximate, 5)
+	for _, embedding := range approximate {
+		assert.True(t, filter.Match(embedding), embedding.Word)
+	}
+	assert.Equal(t, "w7", approximate[0].Word)
+	assert.NotNil(t, approximate[0].Metadata)
+
+	only := EmbeddingFilter{Metadata: map[string]string{"source": "docs/guides/42.md"}}
+	top := edb.SearchMatchingEmbeddings(query, 3, only.Match)
+	require.Len(t, top, 1)
+	assert.Equal(t, "w42", top[0].Word)
+	assert.Empty(t, edb.SearchMatchingEmbeddings(query, 3, EmbeddingFilter{Collection: "missing"}.Match))
+	assert.False(t, EmbeddingFilter{Since: time.Now()}.Match(edb.Embeddings["untagged"]))
+	assert.True(t, EmbeddingFilter{}.Match(edb.Embeddings["untagged"]))
+}
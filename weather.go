@@ -0,0 +1,295 @@
+// manifold/weather.go
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+)
+
+const (
+	defaultGeocodingURL    = "https://geocoding-api.open-meteo.com/v1/search"
+	defaultForecastURL     = "https://api.open-meteo.com/v1/forecast"
+	defaultForecastDays    = 3
+	defaultWeatherTimeout  = 10 * time.Second
+	weatherCurrentFields   = "temperature_2m,apparent_temperature,relative_humidity_2m,precipitation,weather_code,wind_speed_10m"
+	weatherDailyFields     = "weather_code,temperature_2m_max,temperature_2m_min,precipitation_sum"
+	weatherUnitsFahrenheit = "fahrenheit"
+)
+
+var (
+	// weatherPattern detects prompts that ask about the weather.
+	weatherPattern = regexp.MustCompile(`(?i)\b(weather|forecast|temperature|raining|rain|snowing|snow|sunny|humid|humidity|windy)\b`)
+
+	// weatherLocationPattern takes the capitalized place name after in, for or at.
+	weatherLocationPattern = regexp.MustCompile(`\b(?:[Ii]n|[Ff]or|[Aa]t)\s+([A-Z][\p{L}.'-]*(?:,?\s[A-Z][\p{L}.'-]*)*)`)
+)
+
+// weatherCodes describes the WMO weather interpretation codes returned by Open-Meteo.
+var weatherCodes = map[int]string{
+	0: "clear sky", 1: "mainly clear", 2: "partly cloudy", 3: "overcast",
+	45: "fog", 48: "depositing rime fog",
+	51: "light drizzle", 53: "moderate drizzle", 55: "dense drizzle",
+	56: "light freezing drizzle", 57: "dense freezing drizzle",
+	61: "slight rain", 63: "moderate rain", 65: "heavy rain",
+	66: "light freezing rain", 67: "heavy freezing rain",
+	71: "slight snow fall", 73: "moderate snow fall", 75: "heavy snow fall", 77: "snow grains",
+	80: "slight rain showers", 81: "moderate rain showers", 82: "violent rain showers",
+	85: "slight snow showers", 86: "heavy snow showers",
+	95: "thunderstorm", 96: "thunderstorm with slight hail", 99: "thunderstorm with heavy hail",
+}
+
+// WeatherLocation is a place found by the Open-Meteo geocoding API.
+type WeatherLocation struct {
+	Name      string  `json:"name"`
+	Latitude  float64 `json:"latitude"`
+	Longitude float64 `json:"longitude"`
+	Country   string  `json:"country"`
+	Admin1    string  `json:"admin1"`
+	Timezone  string  `json:"timezone"`
+}
+
+// WeatherForecast is the part of an Open-Meteo forecast response the tool reports.
+type WeatherForecast struct {
+	CurrentUnits map[string]string `json:"current_units"`
+	Current      struct {
+		Time                string  `json:"time"`
+		Temperature         float64 `json:"temperature_2m"`
+		ApparentTemperature float64 `json:"apparent_temperature"`
+		Humidity            float64 `json:"relative_humidity_2m"`
+		Precipitation       float64 `json:"precipitation"`
+		WeatherCode         int     `json:"weather_code"`
+		WindSpeed           float64 `json:"wind_speed_10m"`
+	} `json:"current"`
+	Daily struct {
+		Time             []string  `json:"time"`
+		WeatherCode      []int     `json:"weather_code"`
+		TemperatureMax   []float64 `json:"temperature_2m_max"`
+		TemperatureMin   []float64 `json:"temperature_2m_min"`
+		PrecipitationSum []float64 `json:"precipitation_sum"`
+	} `json:"daily"`
+}
+
+// WeatherTool adds the current weather and a short forecast from Open-Meteo to prompts that ask
+// about the weather. The place is taken from the prompt ("weather in Berlin") or the configured
+// default location. Open-Meteo needs no API key.
+type WeatherTool struct {
+	enabled      bool
+	location     string
+	units        string
+	forecastDays int
+	geocodingURL string
+	forecastURL  string
+	client       *http.Client
+}
+
+// Process looks up the weather when the input asks about it.
+func (t *WeatherTool) Process(ctx context.Context, input string) (string, error) {
+	if !weatherPattern.MatchString(input) {
+		return "", nil
+	}
+	if t.client == nil {
+		// Tools toggled on at runtime are created without parameters
+		t.SetParams(map[string]interface{}{}, nil)
+	}
+
+	place := t.location
+	if match := weatherLocationPattern.FindStringSubmatch(input); match != nil {
+		place = match[1]
+	}
+	if place == "" {
+		return "", nil
+	}
+
+	location, err := t.geocode(ctx, place)
+	if err != nil {
+		return "", err
+	}
+	forecast, err := t.forecast(ctx, location)
+	if err != nil {
+		return "", err
+	}
+	return formatWeather(location, forecast), nil
+}
+
+// Relevant reports whether the prompt asks about the weather.
+func (t *WeatherTool) Relevant(prompt string) bool {
+	return weatherPattern.MatchString(prompt)
+}
+
+// Enabled returns the enabled status of the tool.
+func (t *WeatherTool) Enabled() bool {
+	return t.enabled
+}
+
+// SetParams configures the tool with provided parameters.
+func (t *WeatherTool) SetParams(params map[string]interface{}, config *Config) error {
+	if enabled, ok := params["enabled"].(bool); ok {
+		t.enabled = enabled
+	}
+	if location, ok := params["location"].(string); ok {
+		t.location = location
+	}
+
+	t.units = "celsius"
+	if units, ok := params["units"].(string); ok && units != "" {
+		if units != "celsius" && units != weatherUnitsFahrenheit {
+			return fmt.Errorf("units must be celsius or fahrenheit, got %q", units)
+		}
+		t.units = units
+	}
+
+	t.forecastDays = defaultForecastDays
+	if days, ok := params["forecast_days"].(int); ok && days > 0 {
+		if days > 16 {
+			return fmt.Errorf("forecast_days must be at most 16")
+		}
+		t.forecastDays = days
+	}
+
+	t.geocodingURL = defaultGeocodingURL
+	if u, ok := params["geocoding_url"].(string); ok && u != "" {
+		t.geocodingURL = u
+	}
+	t.forecastURL = defaultForecastURL
+	if u, ok := params["forecast_url"].(string); ok && u != "" {
+		t.forecastURL = u
+	}
+
+	timeout := defaultWeatherTimeout
+	if seconds, ok := params["timeout_seconds"].(int); ok && seconds > 0 {
+		timeout = time.Duration(seconds) * time.Second
+	}
+	t.client = &http.Client{Timeout: timeout}
+	return nil
+}
+
+// GetParams returns the tool's parameters.
+func (t *WeatherTool) GetParams() map[string]interface{} {
+	params := map[string]interface{}{
+		"enabled":       t.enabled,
+		"location":      t.location,
+		"units":         t.units,
+		"forecast_days": t.forecastDays,
+		"geocoding_url": t.geocodingURL,
+		"forecast_url":  t.forecastURL,
+	}
+	if t.client != nil {
+		params["timeout_seconds"] = int(t.client.Timeout.Seconds())
+	}
+	return params
+}
+
+// geocode finds the coordinates of a place.
+func (t *WeatherTool) geocode(ctx context.Context, place string) (WeatherLocation, error) {
+	query := url.Values{"name": {place}, "count": {"1"}, "format": {"json"}}
+
+	var result struct {
+		Results []WeatherLocation `json:"results"`
+	}
+	if err := t.getJSON(ctx, t.geocodingURL+"?"+query.Encode(), &result); err != nil {
+		return WeatherLocation{}, err
+	}
+	if len(result.Results) == 0 {
+		return WeatherLocation{}, fmt.Errorf("no location found for %q", place)
+	}
+	return result.Results[0], nil
+}
+
+// forecast fetches the current weather and the daily forecast for a location.
+func (t *WeatherTool) forecast(ctx context.Context, location WeatherLocation) (WeatherForecast, error) {
+	query := url.Values{
+		"latitude":      {fmt.Sprintf("%.4f", location.Latitude)},
+		"longitude":     {fmt.Sprintf("%.4f", location.Longitude)},
+		"current":       {weatherCurrentFields},
+		"daily":         {weatherDailyFields},
+		"timezone":      {"auto"},
+		"forecast_days": {fmt.Sprint(t.forecastDays)},
+	}
+	if t.units == weatherUnitsFahrenheit {
+		query.Set("temperature_unit", weatherUnitsFahrenheit)
+		query.Set("wind_speed_unit", "mph")
+		query.Set("precipitation_unit", "inch")
+	}
+
+	var forecast WeatherForecast
+	err := t.getJSON(ctx, t.forecastURL+"?"+query.Encode(), &forecast)
+	return forecast, err
+}
+
+// getJSON sends a GET request to Open-Meteo and decodes the JSON response into out.
+func (t *WeatherTool) getJSON(ctx context.Context, rawURL string, out interface{}) (err error) {
+	span, ctx := startSpan(ctx, "weather.request")
+	defer func() { finishSpan(span, err) }()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, rawURL, nil)
+	if err != nil {
+		return err
+	}
+	injectSpan(ctx, req)
+
+	resp, err := t.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("weather request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("weather request failed with status %d: %s", resp.StatusCode, msg)
+	}
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return fmt.Errorf("invalid weather response: %w", err)
+	}
+	return nil
+}
+
+// formatWeather writes the forecast as text for the model.
+func formatWeather(location WeatherLocation, forecast WeatherForecast) string {
+	place := location.Name
+	for _, part := range []string{location.Admin1, location.Country} {
+		if part != "" && part != location.Name {
+			place += ", " + part
+		}
+	}
+
+	tempUnit := forecast.CurrentUnits["temperature_2m"]
+	var out strings.Builder
+	fmt.Fprintf(&out, "Weather for %s (Open-Meteo, local time %s):\n", place, forecast.Current.Time)
+	fmt.Fprintf(&out, "Now: %s, %.1f%s (feels like %.1f%s), humidity %.0f%%, wind %.1f %s, precipitation %.1f %s\n",
+		weatherDescription(forecast.Current.WeatherCode),
+		forecast.Current.Temperature, tempUnit,
+		forecast.Current.ApparentTemperature, tempUnit,
+		forecast.Current.Humidity,
+		forecast.Current.WindSpeed, forecast.CurrentUnits["wind_speed_10m"],
+		forecast.Current.Precipitation, forecast.CurrentUnits["precipitation"])
+
+	daily := forecast.Daily
+	for i, day := range daily.Time {
+		if i >= len(daily.WeatherCode) || i >= len(daily.TemperatureMax) || i >= len(daily.TemperatureMin) {
+			break
+		}
+		fmt.Fprintf(&out, "%s: %s, %.1f%s to %.1f%s", day, weatherDescription(daily.WeatherCode[i]),
+			daily.TemperatureMin[i], tempUnit, daily.TemperatureMax[i], tempUnit)
+		if i < len(daily.PrecipitationSum) {
+			fmt.Fprintf(&out, ", precipitation %.1f %s", daily.PrecipitationSum[i], forecast.CurrentUnits["precipitation"])
+		}
+		out.WriteString("\n")
+	}
+	return out.String()
+}
+
+// weatherDescription describes a WMO weather code.
+func weatherDescription(code int) string {
+	if desc, ok := weatherCodes[code]; ok {
+		return desc
+	}
+	return fmt.Sprintf("weather code %d", code)
+}
@@ -0,0 +1,59 @@
+// weather_test.go
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWeatherTool(t *testing.T) {
+	var forecastQuery string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		switch r.URL.Path {
+		case "/search":
+			assert.Equal(t, "New York", r.URL.Query().Get("name"))
+			w.Write([]byte(`{"results":[{"name":"New York","latitude":40.71,"longitude":-74.01,"country":"United States","admin1":"New York"}]}`))
+		case "/forecast":
+			forecastQuery = r.URL.RawQuery
+			w.Write([]byte(`{
+				"current_units":{"temperature_2m":"°F","wind_speed_10m":"mp/h","precipitation":"inch"},
+				"current":{"time":"2026-10-16T09:00","temperature_2m":58.1,"apparent_temperature":55,"relative_humidity_2m":70,"precipitation":0,"weather_code":2,"wind_speed_10m":8.4},
+				"daily":{"time":["2026-10-16"],"weather_code":[61],"temperature_2m_max":[62],"temperature_2m_min":[50.5],"precipitation_sum":[0.2]}
+			}`))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	tool := &WeatherTool{}
+	require.NoError(t, tool.SetParams(map[string]interface{}{
+		"enabled":       true,
+		"units":         "fahrenheit",
+		"geocoding_url": server.URL + "/search",
+		"forecast_url":  server.URL + "/forecast",
+	}, &Config{}))
+
+	out, err := tool.Process(context.Background(), "What's the weather in New York tomorrow?")
+	require.NoError(t, err)
+	assert.Contains(t, forecastQuery, "temperature_unit=fahrenheit")
+	assert.Contains(t, out, "Weather for New York, United States")
+	assert.Contains(t, out, "Now: partly cloudy, 58.1°F (feels like 55.0°F), humidity 70%, wind 8.4 mp/h")
+	assert.Contains(t, out, "2026-10-16: slight rain, 50.5°F to 62.0°F, precipitation 0.2 inch")
+
+	out, err = tool.Process(context.Background(), "Explain goroutines")
+	require.NoError(t, err)
+	assert.Empty(t, out)
+}
+
+func TestWeatherToolParams(t *testing.T) {
+	tool := &WeatherTool{}
+	assert.Error(t, tool.SetParams(map[string]interface{}{"units": "kelvin"}, &Config{}))
+	assert.Error(t, tool.SetParams(map[string]interface{}{"forecast_days": 30}, &Config{}))
+}
@@ -0,0 +1,163 @@
+// manifold/webhooks.go
+
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"slices"
+	"sync"
+	"time"
+)
+
+// Ingestion events posted to webhooks.
+const (
+	eventIngestCompleted = "ingest.completed"
+	eventIngestFailed    = "ingest.failed"
+)
+
+// WebhookConfig posts the ingestion events to a URL, so that external automations can follow up on
+// ingestion jobs.
+type WebhookConfig struct {
+	URL    string   `yaml:"url"`
+	Secret string   `yaml:"secret,omitempty"` // signs the body with HMAC-SHA256 in the X-Manifold-Signature header
+	Events []string `yaml:"events,omitempty"` // ingest.completed or ingest.failed, all when empty
+}
+
+// IngestEvent is posted to webhooks when an ingestion job finishes or fails.
+type IngestEvent struct {
+	Event     string    `json:"event"`
+	Job       string    `json:"job"`              // git, pdf, split, store, refresh or dataset
+	Source    string    `json:"source,omitempty"` // the clone URL, file name, source URL or dataset ingested
+	Documents int       `json:"documents"`        // documents ingested
+	Chunks    int       `json:"chunks"`           // chunks indexed
+	Removed   int       `json:"removed,omitempty"`
+	Failed    int       `json:"failed,omitempty"` // documents that failed, for batches
+	Error     string    `json:"error,omitempty"`
+	Time      time.Time `json:"time"`
+}
+
+// WebhookNotifier posts events to the webhooks in the background, retrying failed deliveries.
+type WebhookNotifier struct {
+	hooks   []WebhookConfig
+	client  *http.Client
+	retries int           // deliveries after the first one failed
+	backoff time.Duration // before the first retry, doubled for each next one
+	wg      sync.WaitGroup
+}
+
+// ingestWebhooks notifies the ingestion events, nil when no webhook is configured.
+var ingestWebhooks *WebhookNotifier
+
+// newWebhookNotifier returns the notifier of the webhooks, nil when there are none.
+func newWebhookNotifier(hooks []WebhookConfig) *WebhookNotifier {
+	if len(hooks) == 0 {
+		return nil
+	}
+	return &WebhookNotifier{
+		hooks:   hooks,
+		client:  &http.Client{Timeout: 10 * time.Second},
+		retries: 3,
+		backoff: time.Second,
+	}
+}
+
+// Notify posts the event to the webhooks subscribed to it without waiting for the deliveries. It
+// does nothing on a nil notifier.
+func (n *WebhookNotifier) Notify(event IngestEvent) {
+	if n == nil {
+		return
+	}
+	if event.Time.IsZero() {
+		event.Time = time.Now().UTC()
+	}
+	body, err := json.Marshal(event)
+	if err != nil {
+		slog.Error("failed to encode webhook event", "event", event.Event, "error", err)
+		return
+	}
+	for _, hook := range n.hooks {
+		if len(hook.Events) > 0 && !slices.Contains(hook.Events, event.Event) {
+			continue
+		}
+		n.wg.Add(1)
+		go func(hook WebhookConfig) {
+			defer n.wg.Done()
+			if err := n.deliver(hook, event.Event, body); err != nil {
+				slog.Error("failed to deliver webhook", "url", hook.URL, "event", event.Event, "error", err)
+			}
+		}(hook)
+	}
+}
+
+// Wait waits for the deliveries in progress.
+func (n *WebhookNotifier) Wait() {
+	if n != nil {
+		n.wg.Wait()
+	}
+}
+
+// deliver posts the body to the webhook, retrying on network errors, 429 and 5xx responses.
+func (n *WebhookNotifier) deliver(hook WebhookConfig, event string, body []byte) error {
+	backoff := n.backoff
+	var err error
+	for attempt := 0; attempt <= n.retries; attempt++ {
+		if attempt > 0 {
+			time.Sleep(backoff)
+			backoff *= 2
+		}
+		var retry bool
+		if retry, err = n.post(hook, event, body); err == nil || !retry {
+			return err
+		}
+	}
+	return err
+}
+
+// post posts the body to the webhook once and reports whether a failure is worth retrying.
+func (n *WebhookNotifier) post(hook WebhookConfig, event string, body []byte) (bool, error) {
+	req, err := http.NewRequest(http.MethodPost, hook.URL, bytes.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Manifold-Event", event)
+	if hook.Secret != "" {
+		req.Header.Set("X-Manifold-Signature", "sha256="+signWebhook(hook.Secret, body))
+	}
+	resp, err := n.client.Do(req)
+	if err != nil {
+		return true, err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 1<<10))
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return false, nil
+	}
+	retry := resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500
+	return retry, fmt.Errorf("webhook returned %s", resp.Status)
+}
+
+// signWebhook returns the hex HMAC-SHA256 of the body with the secret, which receivers compare with
+// the X-Manifold-Signature header to authenticate events.
+func signWebhook(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// notifyIngest notifies the webhooks that an ingestion job finished, or failed with err.
+func notifyIngest(event IngestEvent, err error) {
+	event.Event = eventIngestCompleted
+	if err != nil {
+		event.Event, event.Error = eventIngestFailed, err.Error()
+	}
+	ingestWebhooks.Notify(event)
+}
@@ -0,0 +1,66 @@
+// webhooks_test.go
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWebhookNotifier(t *testing.T) {
+	var mu sync.Mutex
+	var events []IngestEvent
+	attempts := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		attempts++
+		if attempts == 1 {
+			// The first delivery fails and is retried
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		body, _ := io.ReadAll(r.Body)
+		var event IngestEvent
+		require.NoError(t, json.Unmarshal(body, &event))
+		assert.Equal(t, event.Event, r.Header.Get("X-Manifold-Event"))
+		assert.Equal(t, "sha256="+signWebhook("secret", body), r.Header.Get("X-Manifold-Signature"))
+		events = append(events, event)
+	}))
+	defer server.Close()
+
+	notifier := newWebhookNotifier([]WebhookConfig{
+		{URL: server.URL, Secret: "secret"},
+		{URL: server.URL, Secret: "secret", Events: []string{eventIngestFailed}},
+	})
+	notifier.backoff = 0
+	ingestWebhooks = notifier
+	defer func() { ingestWebhooks = nil }()
+
+	notifyIngest(IngestEvent{Job: "store", Documents: 2, Chunks: 7}, nil)
+	notifier.Wait()
+	require.Len(t, events, 1, "failed events only go to the second webhook")
+	assert.Equal(t, eventIngestCompleted, events[0].Event)
+	assert.Equal(t, "store", events[0].Job)
+	assert.Equal(t, 2, events[0].Documents)
+	assert.Equal(t, 7, events[0].Chunks)
+	assert.False(t, events[0].Time.IsZero())
+	assert.Equal(t, 2, attempts)
+
+	notifyIngest(IngestEvent{Job: "git", Source: "https://example.com/repo.git"}, io.ErrUnexpectedEOF)
+	notifier.Wait()
+	require.Len(t, events, 3)
+	for _, event := range events[1:] {
+		assert.Equal(t, eventIngestFailed, event.Event)
+		assert.Equal(t, io.ErrUnexpectedEOF.Error(), event.Error)
+	}
+
+	assert.Nil(t, newWebhookNotifier(nil))
+	newWebhookNotifier(nil).Notify(IngestEvent{Event: eventIngestCompleted})
+}